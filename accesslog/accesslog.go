@@ -0,0 +1,148 @@
+package accesslog
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Entry is a single access log record, written as one JSON line
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Remote   string            `json:"remote"`
+	Method   string            `json:"method"`
+	Host     string            `json:"host"`
+	URI      string            `json:"uri"`
+	Proto    string            `json:"proto"`
+	TLS      *TLS              `json:"tls,omitempty"`
+	Status   int               `json:"status"`
+	Bytes    int64             `json:"bytes"`
+	Duration float64           `json:"duration_ms"`
+	Route    string            `json:"route,omitempty"`
+	Backend  string            `json:"backend,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Attempts []Attempt         `json:"attempts,omitempty"`
+}
+
+// TLS records what the client's TLS handshake negotiated, to size up
+// legacy clients before raising the minimum version
+type TLS struct {
+	Version string `json:"version"`
+	Cipher  string `json:"cipher"`
+	ALPN    string `json:"alpn,omitempty"`
+}
+
+// NewTLS describes a connection's TLS state, or returns nil without TLS
+func NewTLS(cs *tls.ConnectionState) *TLS {
+	if cs == nil {
+		return nil
+	}
+	return &TLS{
+		Version: tls.VersionName(cs.Version),
+		Cipher:  tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:    cs.NegotiatedProtocol,
+	}
+}
+
+// Attempt records one round trip to a backend; retries and failover add more
+type Attempt struct {
+	Backend  string  `json:"backend"`
+	Duration float64 `json:"duration_ms"`
+	Outcome  string  `json:"outcome"` // status code, or the error if no response
+}
+
+// Logger writes redacted access log entries
+type Logger struct {
+	redactor *Redactor
+	headers  []string // request headers to include in entries
+
+	tail  *Tail   // optional: live subscribers
+	sinks []*Sink // optional: remote collectors
+
+	mu  sync.Mutex
+	enc *json.Encoder // nil when entries only go to the tail
+}
+
+// New creates a Logger writing to w, or only to its tail if w is nil.
+// Entries include the listed request headers; all values pass through r
+// before being written.
+func New(w io.Writer, r *Redactor, headers []string) *Logger {
+	l := &Logger{
+		redactor: r,
+		headers:  headers,
+	}
+	if w != nil {
+		l.enc = json.NewEncoder(w)
+	}
+	return l
+}
+
+// SetTail also sends every entry to t's subscribers
+// Must be called before logging
+func (l *Logger) SetTail(t *Tail) {
+	l.tail = t
+}
+
+// AddSink also ships every entry to s
+// Must be called before logging
+func (l *Logger) AddSink(s *Sink) {
+	l.sinks = append(l.sinks, s)
+}
+
+// Close sends the entries still queued for sinks, waiting at most until
+// ctx is done. Entries logged afterwards are not shipped.
+func (l *Logger) Close(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	for _, s := range l.sinks {
+		s.Close(ctx)
+	}
+}
+
+// Enabled reports whether entries are currently written anywhere, so
+// callers can skip building them
+func (l *Logger) Enabled() bool {
+	return l.enc != nil || len(l.sinks) > 0 || l.tail.Active()
+}
+
+// Log fills the request fields of e from req and its original URL u,
+// redacts them and writes the entry
+func (l *Logger) Log(req *http.Request, u *url.URL, e Entry) {
+	e.Remote = req.RemoteAddr
+	e.Method = req.Method
+	e.Host = req.Host
+	e.URI = l.redactor.URI(u)
+	e.Proto = req.Proto
+	e.TLS = NewTLS(req.TLS)
+
+	for _, name := range l.headers {
+		if v := req.Header.Get(name); v != "" {
+			if e.Headers == nil {
+				e.Headers = make(map[string]string, len(l.headers))
+			}
+			e.Headers[name] = l.redactor.Header(name, v)
+		}
+	}
+
+	if l.tail.Active() {
+		l.tail.publish(e)
+	}
+	for _, s := range l.sinks {
+		s.enqueue(e)
+	}
+	if l.enc == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		slog.Error("access log write failed", "err", err)
+	}
+}
@@ -0,0 +1,164 @@
+package accesslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactQuery(t *testing.T) {
+	r, err := NewRedactor(DefaultRedactQuery, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"no secrets", "q=shoes&page=2", "q=shoes&page=2"},
+		{"token masked", "q=shoes&token=abc123", "q=shoes&token=[REDACTED]"},
+		{"case insensitive", "Password=hunter2", "Password=[REDACTED]"},
+		{"escaped name", "api%5Fkey=xyz", "api%5Fkey=[REDACTED]"},
+		{"flag without value", "token&x=1", "token&x=1"},
+		{"order preserved", "b=1&secret=s&a=2", "b=1&secret=[REDACTED]&a=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Query(tt.query); got != tt.want {
+				t.Errorf("Query(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	r, err := NewRedactor(nil, nil, []string{`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`, `tok_\w+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/users/123e4567-e89b-12d3-a456-426614174000/orders", "/users/[REDACTED]/orders"},
+		{"/reset/tok_abc123", "/reset/[REDACTED]"},
+		{"/about", "/about"},
+		{"/prefix-tok_abc", "/prefix-tok_abc"}, // patterns match whole segments
+	}
+
+	for _, tt := range tests {
+		if got := r.Path(tt.path); got != tt.want {
+			t.Errorf("Path(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNewRedactorInvalidPattern(t *testing.T) {
+	if _, err := NewRedactor(nil, nil, []string{"("}); err == nil {
+		t.Error("NewRedactor() expected error for invalid pattern")
+	}
+}
+
+func TestLoggerRedactsEntry(t *testing.T) {
+	r, err := NewRedactor(DefaultRedactQuery, DefaultRedactHeaders, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	l := New(&buf, r, []string{"User-Agent", "Authorization"})
+
+	req := httptest.NewRequest("GET", "http://example.com/login?next=/home&token=abc", nil)
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set("Authorization", "Bearer secret")
+	u, _ := url.Parse("/login?next=/home&token=abc")
+
+	l.Log(req, u, Entry{Status: 200, Backend: "web:80"})
+
+	if strings.Contains(buf.String(), "abc") || strings.Contains(buf.String(), "Bearer") {
+		t.Fatalf("secret leaked into log: %s", buf.String())
+	}
+
+	var e Entry
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if e.URI != "/login?next=/home&token=[REDACTED]" {
+		t.Errorf("URI = %q", e.URI)
+	}
+	if e.Headers["Authorization"] != Mask {
+		t.Errorf("Authorization = %q, want %q", e.Headers["Authorization"], Mask)
+	}
+	if e.Headers["User-Agent"] != "curl/8.0" {
+		t.Errorf("User-Agent = %q, want %q", e.Headers["User-Agent"], "curl/8.0")
+	}
+	if e.Status != 200 || e.Backend != "web:80" || e.Host != "example.com" {
+		t.Errorf("entry = %+v", e)
+	}
+}
+
+func TestLoggerTLS(t *testing.T) {
+	r, _ := NewRedactor(nil, nil, nil)
+	var buf bytes.Buffer
+	l := New(&buf, r, nil)
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, NegotiatedProtocol: "h2"}
+	l.Log(req, req.URL, Entry{Status: 200})
+	l.Log(httptest.NewRequest("GET", "http://example.com/", nil), req.URL, Entry{Status: 200})
+
+	dec := json.NewDecoder(&buf)
+	var withTLS, plain Entry
+	if err := dec.Decode(&withTLS); err != nil {
+		t.Fatal(err)
+	}
+	want := TLS{Version: "TLS 1.2", Cipher: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", ALPN: "h2"}
+	if withTLS.TLS == nil || *withTLS.TLS != want {
+		t.Errorf("TLS = %+v, want %+v", withTLS.TLS, want)
+	}
+	if err := dec.Decode(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if plain.TLS != nil {
+		t.Errorf("plain HTTP entry has TLS %+v", plain.TLS)
+	}
+}
+
+func TestTail(t *testing.T) {
+	r, _ := NewRedactor(DefaultRedactQuery, nil, nil)
+	l := New(nil, r, nil)
+	tail := NewTail()
+	l.SetTail(tail)
+
+	if l.Enabled() {
+		t.Fatal("Enabled() = true with neither a writer nor subscribers")
+	}
+
+	entries, unsubscribe := tail.Subscribe()
+	if !l.Enabled() {
+		t.Fatal("Enabled() = false with a subscriber")
+	}
+	req := httptest.NewRequest("GET", "http://example.com/?token=abc", nil)
+	l.Log(req, req.URL, Entry{Status: 502})
+
+	e := <-entries
+	if e.Status != 502 || e.Host != "example.com" || e.URI != "/?token=[REDACTED]" {
+		t.Errorf("tailed entry = %+v, want the redacted entry", e)
+	}
+
+	unsubscribe()
+	if _, ok := <-entries; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+	if l.Enabled() {
+		t.Error("Enabled() = true after the last subscriber left")
+	}
+	unsubscribe() // safe to call twice
+}
@@ -0,0 +1,107 @@
+package accesslog
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values
+const Mask = "[REDACTED]"
+
+// Redaction defaults keep common secrets out of logs unless overridden
+var (
+	DefaultRedactQuery   = []string{"token", "access_token", "refresh_token", "password", "passwd", "secret", "api_key", "apikey", "key", "signature", "sig"}
+	DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+)
+
+// Redactor masks sensitive query parameters, headers and path segments
+type Redactor struct {
+	query   map[string]struct{} // lowercased parameter names
+	headers map[string]struct{} // canonical header names
+	paths   []*regexp.Regexp    // anchored patterns matched against each path segment
+}
+
+// NewRedactor creates a Redactor. Query parameter names are matched
+// case-insensitively; path patterns must match a whole path segment.
+func NewRedactor(query, headers, paths []string) (*Redactor, error) {
+	r := &Redactor{
+		query:   make(map[string]struct{}, len(query)),
+		headers: make(map[string]struct{}, len(headers)),
+	}
+	for _, q := range query {
+		r.query[strings.ToLower(q)] = struct{}{}
+	}
+	for _, h := range headers {
+		r.headers[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	for _, p := range paths {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+		}
+		r.paths = append(r.paths, re)
+	}
+	return r, nil
+}
+
+// URI returns the request path and query with sensitive parts masked
+func (r *Redactor) URI(u *url.URL) string {
+	path := r.Path(u.EscapedPath())
+	if u.RawQuery == "" {
+		return path
+	}
+	return path + "?" + r.Query(u.RawQuery)
+}
+
+// Path masks path segments matching any pattern
+func (r *Redactor) Path(path string) string {
+	if len(r.paths) == 0 {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if seg == "" {
+			continue
+		}
+		for _, re := range r.paths {
+			if re.MatchString(seg) {
+				segments[i] = Mask
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Query masks the values of sensitive parameters in a raw query string,
+// preserving parameter order
+func (r *Redactor) Query(rawQuery string) string {
+	if len(r.query) == 0 {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		name, _, hasValue := strings.Cut(param, "=")
+		if !hasValue {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if _, ok := r.query[strings.ToLower(name)]; ok {
+			params[i] = param[:strings.IndexByte(param, '=')+1] + Mask
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// Header returns value, or Mask if the header is sensitive
+func (r *Redactor) Header(name, value string) string {
+	if _, ok := r.headers[http.CanonicalHeaderKey(name)]; ok {
+		return Mask
+	}
+	return value
+}
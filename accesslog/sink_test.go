@@ -0,0 +1,148 @@
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSinkInvalid(t *testing.T) {
+	for _, rawURL := range []string{
+		"kafka://broker:9092",
+		"syslog+udp://logs.example.com",
+		"not a url",
+		"https://",
+	} {
+		if _, err := NewSink(rawURL); err == nil {
+			t.Errorf("NewSink(%q) succeeded, want an error", rawURL)
+		}
+	}
+}
+
+func TestHTTPSinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   string // sink scheme replacing http
+		wantPath string
+		check    func(t *testing.T, body []byte)
+	}{
+		{
+			name:     "http batch",
+			scheme:   "http",
+			wantPath: "/ingest",
+			check: func(t *testing.T, body []byte) {
+				lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+				var e Entry
+				if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &e) != nil || e.URI != "/b" {
+					t.Errorf("body = %s, want two JSON lines", body)
+				}
+			},
+		},
+		{
+			name:     "loki",
+			scheme:   "loki+http",
+			wantPath: DefaultLokiPath,
+			check: func(t *testing.T, body []byte) {
+				var push struct {
+					Streams []struct {
+						Stream map[string]string `json:"stream"`
+						Values [][2]string       `json:"values"`
+					} `json:"streams"`
+				}
+				if err := json.Unmarshal(body, &push); err != nil || len(push.Streams) != 1 {
+					t.Fatalf("body = %s, want one stream", body)
+				}
+				s := push.Streams[0]
+				if s.Stream["job"] != "liteproxy" || len(s.Values) != 2 || s.Values[0][0] != strconv.FormatInt(time.Unix(1, 0).UnixNano(), 10) {
+					t.Errorf("stream = %+v, want job liteproxy and two timestamped lines", s)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan []byte, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user, pass, _ := r.BasicAuth(); user != "user" || pass != "key" {
+					t.Errorf("basic auth = %q %q, want the URL's credentials", user, pass)
+				}
+				if r.URL.Path != tt.wantPath {
+					t.Errorf("path = %s, want %s", r.URL.Path, tt.wantPath)
+				}
+				body, _ := io.ReadAll(r.Body)
+				got <- body
+			}))
+			defer srv.Close()
+
+			rawURL := strings.Replace(srv.URL, "http://", tt.scheme+"://user:key@", 1)
+			if tt.scheme == "http" {
+				rawURL += "/ingest"
+			}
+			sink, err := NewSink(rawURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(sink.String(), "key") {
+				t.Errorf("String() = %s, want credentials hidden", sink)
+			}
+			sink.enqueue(Entry{Time: time.Unix(1, 0), URI: "/a"})
+			sink.enqueue(Entry{Time: time.Unix(2, 0), URI: "/b"})
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			sink.Close(ctx)
+
+			select {
+			case body := <-got:
+				tt.check(t, body)
+			default:
+				t.Fatal("nothing sent before Close returned")
+			}
+		})
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	sink, err := NewSink("syslog+tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.enqueue(Entry{Time: time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC), Status: 200})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go sink.Close(ctx)
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// Octet counting: the message length, a space, then the message
+	n, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, _ := strconv.Atoi(strings.TrimSpace(n))
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(msg), "<134>1 2026-01-02T15:04:05Z ") || !strings.Contains(string(msg), ` liteproxy `) || !strings.HasSuffix(string(msg), "}") {
+		t.Errorf("message = %q, want an RFC 5424 message with the JSON entry", msg)
+	}
+}
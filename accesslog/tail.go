@@ -0,0 +1,62 @@
+package accesslog
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// tailBuffer is how many entries a subscriber may fall behind before
+// entries are dropped for it
+const tailBuffer = 256
+
+// Tail fans access log entries out to live subscribers, such as the admin
+// API's /tail endpoint. Slow subscribers miss entries rather than slowing
+// down requests.
+type Tail struct {
+	mu   sync.Mutex
+	subs map[chan Entry]struct{}
+	n    atomic.Int32 // len(subs), read without the lock on every request
+}
+
+// NewTail creates a Tail without subscribers
+func NewTail() *Tail {
+	return &Tail{subs: make(map[chan Entry]struct{})}
+}
+
+// Subscribe returns a channel of new entries and a function that
+// unsubscribes and closes it
+func (t *Tail) Subscribe() (<-chan Entry, func()) {
+	ch := make(chan Entry, tailBuffer)
+	t.mu.Lock()
+	t.subs[ch] = struct{}{}
+	t.n.Store(int32(len(t.subs)))
+	t.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(t.subs, ch)
+			t.n.Store(int32(len(t.subs)))
+			t.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Active reports whether anyone is subscribed
+func (t *Tail) Active() bool {
+	return t != nil && t.n.Load() > 0
+}
+
+// publish sends e to every subscriber with room for it
+func (t *Tail) publish(e Entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for ch := range t.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,409 @@
+// Package admin serves a local HTTP API for inspecting and reloading the
+// running proxy
+package admin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/accesslog"
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/health"
+	"github.com/localrivet/liteproxy/proxy"
+)
+
+//go:embed dashboard.html
+var dashboard []byte
+
+// Server is the admin API. Every field is optional; endpoints whose source
+// is nil answer 404
+type Server struct {
+	// Addr is the address the API listens on. Requests must name it, an IP
+	// or localhost as their host, so a page can't reach the API through a
+	// DNS name it rebinds to loopback.
+	Addr string
+
+	Routes       func() []compose.Route        // currently loaded routes
+	Health       func() []health.BackendStatus // backend health
+	Saturation   func() []proxy.ServiceLoad    // in-flight, waiting and shed requests per service
+	Tail         *accesslog.Tail               // live access log entries
+	Status       func() Status                 // version, uptime and reload state
+	Certificates func() any                    // certificate issuance status, nil until HTTPS is set up
+	Reload       func() error                  // re-read the configuration
+	Config       any                           // effective configuration, without secrets
+
+	Faults     func() []proxy.FaultStatus               // faults injected per route
+	SetFault   func(route string, fault *compose.Fault) // override a route's fault; nil turns it off
+	ClearFault func(route string)                       // revert a route to its labels' fault
+
+	// Validate parses data as the compose file at path (empty when only one
+	// is loaded) with the rest of the configuration, without applying it
+	Validate func(path string, data []byte) ([]compose.Route, error)
+}
+
+// Addr returns the address to listen on: a bare port or ":port" binds to
+// loopback only, so the API is never exposed by accident
+func Addr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return net.JoinHostPort("127.0.0.1", addr)
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// Handler returns the API's HTTP handler
+//
+//	GET  /              web dashboard built on the endpoints below
+//	GET  /routes        loaded routes with their upstreams
+//	GET  /health        backend health check status
+//	GET  /saturation    in-flight, waiting and shed requests per service
+//	GET  /tail          stream access log entries as JSON lines
+//	GET  /status        version, uptime, config hash and last reload
+//	GET  /certificates  ACME pre-checks and issuance queue
+//	GET  /config        effective configuration
+//	POST /reload        reload the configuration
+//	GET  /faults        faults injected per route
+//	PUT  /faults        override a route's fault (route, delay, abort, status)
+//	DELETE /faults      revert a route to its labels' fault (route)
+//	POST /validate      diff a candidate compose file against the live routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		w.Write(dashboard)
+	})
+	mux.HandleFunc("GET /routes", func(w http.ResponseWriter, r *http.Request) {
+		if s.Routes == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, compose.Export(s.Routes()))
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		if s.Health == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Health())
+	})
+	mux.HandleFunc("GET /saturation", func(w http.ResponseWriter, r *http.Request) {
+		if s.Saturation == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Saturation())
+	})
+	mux.HandleFunc("GET /tail", s.serveTail)
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		if s.Status == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Status())
+	})
+	mux.HandleFunc("GET /certificates", func(w http.ResponseWriter, r *http.Request) {
+		var status any
+		if s.Certificates != nil {
+			status = s.Certificates()
+		}
+		if status == nil {
+			http.Error(w, "HTTPS is not enabled", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		if s.Config == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Config)
+	})
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		if s.Reload == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err := s.Reload(); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		resp := map[string]int{}
+		if s.Routes != nil {
+			resp["routes"] = len(s.Routes())
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+	mux.HandleFunc("GET /faults", func(w http.ResponseWriter, r *http.Request) {
+		if s.Faults == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Faults())
+	})
+	mux.HandleFunc("PUT /faults", func(w http.ResponseWriter, r *http.Request) {
+		if s.SetFault == nil {
+			http.NotFound(w, r)
+			return
+		}
+		route, ok := s.faultRoute(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		fault, err := compose.ParseFault(q.Get("delay"), q.Get("abort"), q.Get("status"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		s.SetFault(route, fault)
+		writeJSON(w, http.StatusOK, map[string]any{"route": route, "fault": fault})
+	})
+	mux.HandleFunc("DELETE /faults", func(w http.ResponseWriter, r *http.Request) {
+		if s.ClearFault == nil {
+			http.NotFound(w, r)
+			return
+		}
+		route, ok := s.faultRoute(w, r)
+		if !ok {
+			return
+		}
+		s.ClearFault(route)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /validate", func(w http.ResponseWriter, r *http.Request) {
+		if s.Validate == nil {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCandidateSize))
+		if err != nil {
+			writeJSON(w, http.StatusRequestEntityTooLarge, Validation{Error: err.Error()})
+			return
+		}
+		routes, err := s.Validate(r.URL.Query().Get("file"), data)
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, Validation{Error: err.Error()})
+			return
+		}
+		var live []compose.Route
+		if s.Routes != nil {
+			live = s.Routes()
+		}
+		diff := compose.Diff(live, routes)
+		writeJSON(w, http.StatusOK, Validation{OK: true, Routes: len(routes), ConfigHash: compose.Hash(routes), Diff: &diff})
+	})
+	return s.guard(mux)
+}
+
+// guard refuses requests a web page could make from an operator's browser:
+// ones for a host other than the API's own (DNS rebinding), and
+// state-changing ones from another site. The API has no authentication.
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			http.Error(w, "cross-site request refused", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether a Host header names the API: an IP address,
+// localhost, or the host of the configured address
+func (s *Server) allowedHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	configured, _, err := net.SplitHostPort(s.Addr)
+	return err == nil && configured != "" && strings.EqualFold(host, configured)
+}
+
+// sameOrigin reports whether a request came from the API's own pages, or
+// from something other than a browser
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// maxCandidateSize limits compose files sent to /validate
+const maxCandidateSize = 10 << 20
+
+// Validation is the outcome of checking a candidate compose file
+type Validation struct {
+	OK         bool               `json:"ok"`
+	Error      string             `json:"error,omitempty"`       // why a reload would fail
+	Routes     int                `json:"routes,omitempty"`      // routes a reload would load
+	ConfigHash string             `json:"config_hash,omitempty"` // compose.Hash of those routes
+	Diff       *compose.RouteDiff `json:"diff,omitempty"`        // changes from the live routes
+}
+
+// faultRoute reads the route query parameter of /faults, answering the
+// request itself if it's missing or names no loaded route
+func (s *Server) faultRoute(w http.ResponseWriter, r *http.Request) (string, bool) {
+	route := r.URL.Query().Get("route")
+	if route == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "route is required (host and path, e.g. app.example.com/api)"})
+		return "", false
+	}
+	if s.Routes != nil {
+		for _, rt := range s.Routes() {
+			if rt.Key() == route {
+				return route, true
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no route %q", route)})
+		return "", false
+	}
+	return route, true
+}
+
+// Status identifies a running proxy and its configuration, for fleet
+// inventory tools. Fields are only ever added, never renamed.
+type Status struct {
+	Version    string        `json:"version"`
+	GoVersion  string        `json:"go_version"`
+	StartedAt  time.Time     `json:"started_at"`
+	Uptime     float64       `json:"uptime_seconds"`
+	ConfigHash string        `json:"config_hash"` // compose.Hash of the loaded routes
+	Routes     int           `json:"routes"`
+	Reloads    int           `json:"reloads"`               // reload attempts since startup
+	LastReload *ReloadStatus `json:"last_reload,omitempty"` // nil until the first reload
+}
+
+// ReloadStatus is the outcome of a configuration reload
+type ReloadStatus struct {
+	Time  time.Time `json:"time"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"` // why the previous routes were kept
+}
+
+// TailFilter selects the entries streamed by /tail. Empty fields match
+// everything.
+type TailFilter struct {
+	Host   string // request host, without port
+	Path   string // URI prefix
+	Status string // a status code (404) or class (5xx)
+}
+
+// ParseTailFilter reads a filter from /tail's host, path and status
+// query parameters
+func ParseTailFilter(q url.Values) (TailFilter, error) {
+	f := TailFilter{Host: q.Get("host"), Path: q.Get("path"), Status: strings.ToLower(q.Get("status"))}
+	if f.Status != "" {
+		class, isClass := strings.CutSuffix(f.Status, "xx")
+		code, err := strconv.Atoi(f.Status)
+		validClass := isClass && len(class) == 1 && class >= "1" && class <= "5"
+		validCode := err == nil && code >= 100 && code <= 599
+		if !validClass && !validCode {
+			return TailFilter{}, fmt.Errorf("invalid status %q (want e.g. 404 or 5xx)", f.Status)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether e passes the filter
+func (f TailFilter) Match(e accesslog.Entry) bool {
+	if f.Host != "" {
+		host := e.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, f.Host) {
+			return false
+		}
+	}
+	if f.Path != "" && !strings.HasPrefix(e.URI, f.Path) {
+		return false
+	}
+	if f.Status != "" {
+		status := strconv.Itoa(e.Status)
+		if class, ok := strings.CutSuffix(f.Status, "xx"); ok {
+			return status[:1] == class
+		}
+		return status == f.Status
+	}
+	return true
+}
+
+// serveTail streams matching access log entries until the client goes away
+func (s *Server) serveTail(w http.ResponseWriter, r *http.Request) {
+	if s.Tail == nil {
+		http.NotFound(w, r)
+		return
+	}
+	filter, err := ParseTailFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, unsubscribe := s.Tail.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-entries:
+			if !filter.Match(e) {
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("admin: writing response failed", "err", err)
+	}
+}
@@ -0,0 +1,341 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/accesslog"
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/health"
+	"github.com/localrivet/liteproxy/proxy"
+)
+
+func TestAddr(t *testing.T) {
+	tests := map[string]string{
+		"9901":           "127.0.0.1:9901",
+		":9901":          "127.0.0.1:9901",
+		"0.0.0.0:9901":   "0.0.0.0:9901",
+		"10.0.0.5:9901":  "10.0.0.5:9901",
+		"localhost:9901": "localhost:9901",
+	}
+	for in, want := range tests {
+		if got := Addr(in); got != want {
+			t.Errorf("Addr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080},
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+	}
+	reloads := 0
+	var reloadErr error
+	s := &Server{
+		Routes: func() []compose.Route { return routes },
+		Health: func() []health.BackendStatus {
+			return []health.BackendStatus{{Addr: "web:80", Healthy: false, Check: "http", LastError: "503"}}
+		},
+		Saturation: func() []proxy.ServiceLoad {
+			return []proxy.ServiceLoad{{Service: "api", InFlight: 12, Waiting: 9, Shed: map[string]uint64{"client_concurrency": 3}}}
+		},
+		Status: func() Status {
+			return Status{Version: "v1.2.3", Routes: 2, LastReload: &ReloadStatus{OK: false, Error: "bad label"}}
+		},
+		Reload: func() error { reloads++; return reloadErr },
+		Config: struct{ HTTPPort int }{80},
+	}
+	h := s.Handler()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "http://localhost:9901"+path, nil))
+		return w
+	}
+
+	w := do("GET", "/")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(w.Body.String(), "<title>liteproxy</title>") {
+		t.Errorf("GET / = %d %s, want the dashboard", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := do("GET", "/nope"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /nope = %d, want 404", w.Code)
+	}
+
+	w = do("GET", "/routes")
+	var got []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /routes = %d %s", w.Code, w.Body)
+	}
+	if len(got) != 2 || got[0]["path"] != "/" || got[1]["upstreams"].([]any)[0] != "api:8080" {
+		t.Errorf("GET /routes = %v, want sorted routes with upstreams", got)
+	}
+
+	w = do("GET", "/health")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("GET /health = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	w = do("GET", "/saturation")
+	var loads []proxy.ServiceLoad
+	if err := json.Unmarshal(w.Body.Bytes(), &loads); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /saturation = %d %s", w.Code, w.Body)
+	}
+	if len(loads) != 1 || loads[0].Waiting != 9 || loads[0].Shed["client_concurrency"] != 3 {
+		t.Errorf("GET /saturation = %+v", loads)
+	}
+
+	w = do("GET", "/status")
+	var status map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /status = %d %s", w.Code, w.Body)
+	}
+	if status["version"] != "v1.2.3" || status["last_reload"].(map[string]any)["error"] != "bad label" {
+		t.Errorf("GET /status = %v", status)
+	}
+
+	if w = do("GET", "/config"); w.Code != http.StatusOK {
+		t.Errorf("GET /config = %d", w.Code)
+	}
+
+	// Certificates are unavailable without HTTPS
+	if w = do("GET", "/certificates"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /certificates = %d, want 404", w.Code)
+	}
+
+	// Reload only accepts POST
+	if w = do("GET", "/reload"); w.Code != http.StatusMethodNotAllowed || reloads != 0 {
+		t.Errorf("GET /reload = %d, reloads = %d, want 405 without reloading", w.Code, reloads)
+	}
+	if w = do("POST", "/reload"); w.Code != http.StatusOK || reloads != 1 {
+		t.Errorf("POST /reload = %d, reloads = %d, want 200", w.Code, reloads)
+	}
+	reloadErr = errors.New("parsing compose file: bad label")
+	if w = do("POST", "/reload"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("POST /reload with a broken config = %d, want 422", w.Code)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	routes := []compose.Route{{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080}}
+	overrides := map[string]*compose.Fault{}
+	s := &Server{
+		Routes: func() []compose.Route { return routes },
+		Faults: func() []proxy.FaultStatus {
+			var list []proxy.FaultStatus
+			for route, f := range overrides {
+				list = append(list, proxy.FaultStatus{Route: route, Fault: f, Source: proxy.FaultFromAdmin})
+			}
+			return list
+		},
+		SetFault:   func(route string, f *compose.Fault) { overrides[route] = f },
+		ClearFault: func(route string) { delete(overrides, route) },
+	}
+	h := s.Handler()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "http://localhost:9901"+path, nil))
+		return w
+	}
+
+	if w := do("PUT", "/faults?route=example.com/api&delay=200ms&abort=10%25"); w.Code != http.StatusOK {
+		t.Fatalf("PUT /faults = %d %s", w.Code, w.Body)
+	}
+	if f := overrides["example.com/api"]; f == nil || f.Delay != 200*time.Millisecond || f.AbortPercent != 10 || f.AbortStatus != 503 {
+		t.Errorf("override = %+v, want 200ms delay and 10%% 503s", f)
+	}
+
+	w := do("GET", "/faults")
+	var list []proxy.FaultStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK || len(list) != 1 {
+		t.Fatalf("GET /faults = %d %s", w.Code, w.Body)
+	}
+
+	// No parameters turns the route's fault off
+	if w := do("PUT", "/faults?route=example.com/api"); w.Code != http.StatusOK {
+		t.Errorf("PUT /faults without a fault = %d", w.Code)
+	}
+	if f, ok := overrides["example.com/api"]; !ok || f != nil {
+		t.Errorf("override = %+v, %v, want nil", f, ok)
+	}
+
+	if w := do("PUT", "/faults?route=example.com/api&abort=lots"); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT /faults with a bad abort = %d, want 400", w.Code)
+	}
+	if w := do("PUT", "/faults?route=example.com/nope&delay=1s"); w.Code != http.StatusNotFound {
+		t.Errorf("PUT /faults for an unknown route = %d, want 404", w.Code)
+	}
+	if w := do("DELETE", "/faults"); w.Code != http.StatusBadRequest {
+		t.Errorf("DELETE /faults without a route = %d, want 400", w.Code)
+	}
+	if w := do("DELETE", "/faults?route=example.com/api"); w.Code != http.StatusNoContent || len(overrides) != 0 {
+		t.Errorf("DELETE /faults = %d, overrides = %v, want 204 and none", w.Code, overrides)
+	}
+}
+
+func TestTailFilter(t *testing.T) {
+	e := accesslog.Entry{Host: "example.com:8443", URI: "/api/users?page=2", Status: 502}
+	tests := []struct {
+		query   string
+		want    bool
+		wantErr bool
+	}{
+		{query: "", want: true},
+		{query: "host=EXAMPLE.com", want: true},
+		{query: "host=other.com", want: false},
+		{query: "path=/api", want: true},
+		{query: "path=/web", want: false},
+		{query: "status=502", want: true},
+		{query: "status=5xx", want: true},
+		{query: "status=4xx", want: false},
+		{query: "host=example.com&status=404", want: false},
+		{query: "status=6xx", wantErr: true},
+		{query: "status=oops", wantErr: true},
+		{query: "status=42", wantErr: true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := ParseTailFilter(q)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTailFilter(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && f.Match(e) != tt.want {
+			t.Errorf("filter %q Match() = %v, want %v", tt.query, !tt.want, tt.want)
+		}
+	}
+}
+
+func TestTailStream(t *testing.T) {
+	tail := accesslog.NewTail()
+	r, _ := accesslog.NewRedactor(nil, nil, nil)
+	l := accesslog.New(nil, r, nil)
+	l.SetTail(tail)
+	srv := httptest.NewServer((&Server{Tail: tail}).Handler())
+	defer srv.Close()
+
+	if resp, _ := http.Get(srv.URL + "/tail?status=teapot"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid filter: status %d, want 400", resp.StatusCode)
+	}
+
+	resp, err := http.Get(srv.URL + "/tail?status=5xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	for !tail.Active() {
+		time.Sleep(time.Millisecond)
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	l.Log(req, req.URL, accesslog.Entry{Status: 200})
+	l.Log(req, req.URL, accesslog.Entry{Status: 503})
+
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e accesslog.Entry
+	if err := json.Unmarshal(line, &e); err != nil || e.Status != 503 {
+		t.Errorf("first streamed entry = %s, want the 503", line)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	live := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080},
+	}
+	var gotPath string
+	s := &Server{
+		Routes: func() []compose.Route { return live },
+		Validate: func(path string, data []byte) ([]compose.Route, error) {
+			gotPath = path
+			if string(data) == "broken" {
+				return nil, errors.New("service web: invalid port")
+			}
+			return []compose.Route{
+				{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 8080},
+				{Host: "shop.example.com", PathPrefix: "/", ServiceName: "shop", ServicePort: 80},
+			}, nil
+		},
+	}
+	h := s.Handler()
+	post := func(path, body string) (*httptest.ResponseRecorder, Validation) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "http://localhost:9901"+path, strings.NewReader(body)))
+		var v Validation
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("POST %s: %v (%s)", path, err, w.Body)
+		}
+		return w, v
+	}
+
+	w, v := post("/validate?file=/srv/compose.yaml", "services: {}")
+	if w.Code != http.StatusOK || !v.OK || v.Routes != 2 || gotPath != "/srv/compose.yaml" {
+		t.Fatalf("POST /validate = %d %+v (file %q)", w.Code, v, gotPath)
+	}
+	d := v.Diff
+	if len(d.Added) != 1 || d.Added[0].Host != "shop.example.com" || len(d.Removed) != 1 || d.Removed[0].Path() != "/api" ||
+		len(d.Changed) != 1 || d.Changed[0].Fields[0] != "port" {
+		t.Errorf("diff = %+v, want shop added, /api removed and / changed port", d)
+	}
+
+	w, v = post("/validate", "broken")
+	if w.Code != http.StatusUnprocessableEntity || v.OK || v.Error != "service web: invalid port" {
+		t.Errorf("POST /validate with a broken file = %d %+v, want 422 with the error", w.Code, v)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	reloads := 0
+	h := (&Server{Addr: "admin.internal:9901", Reload: func() error { reloads++; return nil }, Config: struct{}{}}).Handler()
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		want    int
+	}{
+		{name: "loopback", method: "GET", target: "http://127.0.0.1:9901/config", want: http.StatusOK},
+		{name: "ipv6 loopback", method: "GET", target: "http://[::1]:9901/config", want: http.StatusOK},
+		{name: "localhost", method: "GET", target: "http://localhost:9901/config", want: http.StatusOK},
+		{name: "configured host", method: "GET", target: "http://admin.internal:9901/config", want: http.StatusOK},
+		{name: "rebound name", method: "GET", target: "http://attacker.example:9901/config", want: http.StatusForbidden},
+		{name: "curl reload", method: "POST", target: "http://127.0.0.1:9901/reload", want: http.StatusOK},
+		{name: "dashboard reload", method: "POST", target: "http://127.0.0.1:9901/reload",
+			headers: map[string]string{"Origin": "http://127.0.0.1:9901", "Sec-Fetch-Site": "same-origin"}, want: http.StatusOK},
+		{name: "cross-site origin", method: "POST", target: "http://127.0.0.1:9901/reload",
+			headers: map[string]string{"Origin": "https://attacker.example"}, want: http.StatusForbidden},
+		{name: "cross-site fetch", method: "POST", target: "http://127.0.0.1:9901/reload",
+			headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, want: http.StatusForbidden},
+		{name: "cross-site read", method: "GET", target: "http://127.0.0.1:9901/config",
+			headers: map[string]string{"Sec-Fetch-Site": "cross-site"}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		before := reloads
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusForbidden && reloads != before {
+			t.Errorf("%s: reloaded despite being refused", tt.name)
+		}
+	}
+}
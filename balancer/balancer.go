@@ -0,0 +1,164 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// Strategies supported by the liteproxy.balance label
+const (
+	RoundRobin = "round_robin"
+	URLHash    = "url_hash"
+	LeastConn  = "least_conn"
+)
+
+// virtualNodes is the number of points each backend occupies on the hash ring.
+// More points spread keys more evenly at the cost of a larger ring.
+const virtualNodes = 160
+
+// Balancer picks a backend address for a request
+type Balancer interface {
+	// Next returns the backend for the given key (ignored by key-less strategies),
+	// skipping backends for which healthy returns false. A nil healthy func
+	// accepts every backend. Returns "" if no backend is acceptable.
+	Next(key string, healthy func(addr string) bool) string
+}
+
+// Tracker is implemented by balancers that track in-flight requests
+// Done must be called once for every backend returned by Next
+type Tracker interface {
+	Done(addr string)
+}
+
+// New creates a balancer for the strategy, defaulting to round robin
+func New(strategy string, backends []string) Balancer {
+	switch strategy {
+	case URLHash:
+		return NewRing(backends)
+	case LeastConn:
+		return newLeastConn(backends)
+	default:
+		return &roundRobin{backends: backends}
+	}
+}
+
+// Valid reports whether strategy is a known balancing strategy
+func Valid(strategy string) bool {
+	return strategy == RoundRobin || strategy == URLHash || strategy == LeastConn
+}
+
+// roundRobin cycles through backends in order
+type roundRobin struct {
+	backends []string
+	next     atomic.Uint64
+}
+
+func (b *roundRobin) Next(_ string, healthy func(string) bool) string {
+	n := b.next.Add(1) - 1
+	for i := range b.backends {
+		backend := b.backends[(n+uint64(i))%uint64(len(b.backends))]
+		if healthy == nil || healthy(backend) {
+			return backend
+		}
+	}
+	return ""
+}
+
+// leastConn picks the backend with the fewest in-flight requests, starting
+// the scan at a rotating offset so ties are spread round robin
+type leastConn struct {
+	backends []string
+	active   []atomic.Int64
+	index    map[string]int
+	next     atomic.Uint64
+}
+
+func newLeastConn(backends []string) *leastConn {
+	b := &leastConn{
+		backends: backends,
+		active:   make([]atomic.Int64, len(backends)),
+		index:    make(map[string]int, len(backends)),
+	}
+	for i, backend := range backends {
+		b.index[backend] = i
+	}
+	return b
+}
+
+func (b *leastConn) Next(_ string, healthy func(string) bool) string {
+	n := len(b.backends)
+	start := int(b.next.Add(1) % uint64(n))
+	best := -1
+	var bestActive int64
+	for i := range n {
+		j := (start + i) % n
+		if healthy != nil && !healthy(b.backends[j]) {
+			continue
+		}
+		if a := b.active[j].Load(); best < 0 || a < bestActive {
+			best, bestActive = j, a
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	b.active[best].Add(1)
+	return b.backends[best]
+}
+
+func (b *leastConn) Done(addr string) {
+	if i, ok := b.index[addr]; ok {
+		b.active[i].Add(-1)
+	}
+}
+
+// Ring is a consistent hash ring: each key maps to the same backend for as long
+// as that backend is present, and adding or removing a backend only remaps the
+// keys that belonged to it
+type Ring struct {
+	points []uint32          // sorted hash points
+	owners map[uint32]string // hash point → backend
+}
+
+// NewRing builds a consistent hash ring over the given backends
+func NewRing(backends []string) *Ring {
+	r := &Ring{
+		points: make([]uint32, 0, len(backends)*virtualNodes),
+		owners: make(map[uint32]string, len(backends)*virtualNodes),
+	}
+	for _, backend := range backends {
+		for i := 0; i < virtualNodes; i++ {
+			p := hash(backend + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[p]; taken {
+				continue
+			}
+			r.owners[p] = backend
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Next returns the backend owning key: the first point clockwise from its hash.
+// Unhealthy owners are skipped, so their keys spill over to the next backend on
+// the ring and return once they recover.
+func (r *Ring) Next(key string, healthy func(string) bool) string {
+	h := hash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	for i := range r.points {
+		owner := r.owners[r.points[(start+i)%len(r.points)]] // wrap around
+		if healthy == nil || healthy(owner) {
+			return owner
+		}
+	}
+	return ""
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,166 @@
+package balancer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingConsistent(t *testing.T) {
+	backends := []string{"cache-1:80", "cache-2:80", "cache-3:80"}
+	r := NewRing(backends)
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("example.com/assets/%d.js", i)
+		first := r.Next(key, nil)
+		for j := 0; j < 5; j++ {
+			if got := r.Next(key, nil); got != first {
+				t.Fatalf("Next(%q) = %q, want %q", key, got, first)
+			}
+		}
+	}
+}
+
+func TestRingDistribution(t *testing.T) {
+	backends := []string{"cache-1:80", "cache-2:80", "cache-3:80"}
+	r := NewRing(backends)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[r.Next(fmt.Sprintf("/page/%d", i), nil)]++
+	}
+
+	for _, b := range backends {
+		// Each backend should get a reasonable share (ideal is 1000)
+		if counts[b] < 500 {
+			t.Errorf("backend %s got %d keys, want at least 500 (counts: %v)", b, counts[b], counts)
+		}
+	}
+}
+
+func TestRingMinimalRemap(t *testing.T) {
+	before := NewRing([]string{"cache-1:80", "cache-2:80", "cache-3:80"})
+	after := NewRing([]string{"cache-1:80", "cache-2:80", "cache-3:80", "cache-4:80"})
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("/page/%d", i)
+		b, a := before.Next(key, nil), after.Next(key, nil)
+		if b != a {
+			if a != "cache-4:80" {
+				t.Errorf("key %q moved from %s to %s, want only moves to the new backend", key, b, a)
+			}
+			moved++
+		}
+	}
+
+	// Roughly a quarter of keys should move to the new backend
+	if moved > 500 {
+		t.Errorf("%d of 1000 keys moved, want fewer than 500", moved)
+	}
+}
+
+func TestRingEmpty(t *testing.T) {
+	if got := NewRing(nil).Next("/", nil); got != "" {
+		t.Errorf("Next() on empty ring = %q, want empty", got)
+	}
+}
+
+func TestRingSkipsUnhealthy(t *testing.T) {
+	r := NewRing([]string{"cache-1:80", "cache-2:80", "cache-3:80"})
+
+	key := "example.com/app.js"
+	owner := r.Next(key, nil)
+	healthy := func(addr string) bool { return addr != owner }
+
+	fallback := r.Next(key, healthy)
+	if fallback == "" || fallback == owner {
+		t.Fatalf("Next() with owner down = %q, want another backend", fallback)
+	}
+	if got := r.Next(key, healthy); got != fallback {
+		t.Errorf("Next() fallback not stable: %q then %q", fallback, got)
+	}
+	if got := r.Next(key, func(string) bool { return false }); got != "" {
+		t.Errorf("Next() with all down = %q, want empty", got)
+	}
+}
+
+func TestRoundRobinSkipsUnhealthy(t *testing.T) {
+	b := New(RoundRobin, []string{"a:80", "b:80", "c:80"})
+	healthy := func(addr string) bool { return addr != "b:80" }
+
+	for i := 0; i < 6; i++ {
+		if got := b.Next("", healthy); got == "b:80" || got == "" {
+			t.Errorf("Next() = %q, want a healthy backend", got)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	b := New(RoundRobin, []string{"a:80", "b:80"})
+
+	want := []string{"a:80", "b:80", "a:80", "b:80"}
+	for i, w := range want {
+		if got := b.Next("", nil); got != w {
+			t.Errorf("Next() call %d = %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     bool
+	}{
+		{RoundRobin, true},
+		{URLHash, true},
+		{"random", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := Valid(tt.strategy); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkRingNext(b *testing.B) {
+	r := NewRing([]string{"cache-1:80", "cache-2:80", "cache-3:80"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Next("example.com/assets/app.js", nil)
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	b := New(LeastConn, []string{"a:80", "b:80", "c:80"})
+	tracker := b.(Tracker)
+
+	// Three requests in flight land on three different backends
+	inFlight := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		inFlight[b.Next("", nil)] = true
+	}
+	if len(inFlight) != 3 {
+		t.Fatalf("in-flight backends = %v, want all three", inFlight)
+	}
+
+	// Once b finishes it is the only idle backend
+	tracker.Done("b:80")
+	for i := 0; i < 3; i++ {
+		got := b.Next("", nil)
+		if got != "b:80" {
+			t.Fatalf("Next() = %q, want the idle b:80", got)
+		}
+		tracker.Done(got)
+	}
+
+	// Unhealthy backends are skipped even when idle
+	if got := b.Next("", func(addr string) bool { return addr != "b:80" }); got == "b:80" || got == "" {
+		t.Errorf("Next() = %q, want a healthy backend", got)
+	}
+	if got := b.Next("", func(string) bool { return false }); got != "" {
+		t.Errorf("Next() with no healthy backends = %q, want empty", got)
+	}
+}
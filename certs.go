@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	liteTLS "github.com/localrivet/liteproxy/tls"
+)
+
+// certsUsage describes `liteproxy certs`
+const certsUsage = `usage: liteproxy certs export [-dir DIR] [-passphrase-file FILE] BUNDLE
+       liteproxy certs import [-dir DIR] [-passphrase-file FILE] [-force] BUNDLE
+
+BUNDLE may be - for stdout or stdin. The passphrase comes from
+LITEPROXY_BUNDLE_PASSPHRASE unless -passphrase-file is given.`
+
+// runCerts implements `liteproxy certs`: move the ACME account key and
+// certificates between hosts as one encrypted bundle
+func runCerts(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) < 1 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(stderr, certsUsage)
+		return 2
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("certs "+cmd, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", getEnv("LITEPROXY_ACME_DIR", "./certs"), "certificate directory")
+	passFile := fs.String("passphrase-file", "", "read the passphrase from this file")
+	force := fs.Bool("force", false, "import: replace files that already exist")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, certsUsage)
+		return 2
+	}
+	bundle := fs.Arg(0)
+
+	passphrase := []byte(os.Getenv("LITEPROXY_BUNDLE_PASSPHRASE"))
+	if *passFile != "" {
+		data, err := os.ReadFile(*passFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "liteproxy certs: %v\n", err)
+			return 1
+		}
+		passphrase = bytes.TrimRight(data, "\r\n")
+	}
+	if len(passphrase) == 0 {
+		fmt.Fprintln(stderr, "liteproxy certs: no passphrase (set LITEPROXY_BUNDLE_PASSPHRASE or pass -passphrase-file)")
+		return 2
+	}
+
+	// Status goes to stderr, so a bundle written to stdout can be piped
+	if cmd == "export" {
+		out := stdout
+		if bundle != "-" {
+			f, err := os.OpenFile(bundle, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if err != nil {
+				fmt.Fprintf(stderr, "liteproxy certs: %v\n", err)
+				return 1
+			}
+			defer f.Close()
+			out = f
+		}
+		n, err := liteTLS.ExportBundle(*dir, out, passphrase)
+		if err != nil {
+			fmt.Fprintf(stderr, "liteproxy certs export: %v\n", err)
+			if bundle != "-" {
+				os.Remove(bundle)
+			}
+			return 1
+		}
+		fmt.Fprintf(stderr, "exported %d files from %s\n", n, *dir)
+		return 0
+	}
+
+	in := stdin
+	if bundle != "-" {
+		f, err := os.Open(bundle)
+		if err != nil {
+			fmt.Fprintf(stderr, "liteproxy certs: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	n, err := liteTLS.ImportBundle(*dir, in, passphrase, *force)
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy certs import: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "imported %d files into %s\n", n, *dir)
+	return 0
+}
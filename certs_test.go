@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCerts(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(src, "acme_account+key"), []byte("account key"), 0o600)
+	t.Setenv("LITEPROXY_BUNDLE_PASSPHRASE", "")
+
+	var stdout, stderr bytes.Buffer
+	if code := runCerts([]string{"export", "-dir", src, "-"}, nil, &stdout, &stderr); code != 2 {
+		t.Errorf("no passphrase: exit %d, want 2", code)
+	}
+
+	passFile := filepath.Join(t.TempDir(), "passphrase")
+	os.WriteFile(passFile, []byte("correct horse\n"), 0o600)
+	stderr.Reset()
+	if code := runCerts([]string{"export", "-dir", src, "-passphrase-file", passFile, "-"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("export: exit %d: %s", code, stderr.String())
+	}
+
+	// The trailing newline of the file is not part of the passphrase
+	t.Setenv("LITEPROXY_BUNDLE_PASSPHRASE", "correct horse")
+	if code := runCerts([]string{"import", "-dir", dst, "-"}, &stdout, &stdout, &stderr); code != 0 {
+		t.Fatalf("import: exit %d: %s", code, stderr.String())
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "acme_account+key")); string(got) != "account key" {
+		t.Errorf("imported account key = %q", got)
+	}
+
+	// Bundle files are never overwritten
+	bundle := filepath.Join(t.TempDir(), "certs.bundle")
+	if code := runCerts([]string{"export", "-dir", src, bundle}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("export to file: exit %d: %s", code, stderr.String())
+	}
+	if code := runCerts([]string{"export", "-dir", src, bundle}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("export over an existing bundle: exit %d, want 1", code)
+	}
+
+	for _, args := range [][]string{nil, {"backup"}, {"import"}} {
+		if code := runCerts(args, nil, &stdout, &stderr); code != 2 {
+			t.Errorf("runCerts(%q): exit %d, want 2", args, code)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package compose
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for splitting a route's traffic with a canary service
+const (
+	LabelCanaryService = "liteproxy.canary.service"
+	LabelCanaryPort    = "liteproxy.canary.port"
+	LabelCanaryWeight  = "liteproxy.canary.weight"
+	LabelCanaryHash    = "liteproxy.canary.hash"
+)
+
+// Canary sends a share of a route's requests to another service, such as
+// a new version being rolled out
+type Canary struct {
+	Service string `json:"service"`
+	Host    string `json:"host,omitempty"` // resolvable name of Service when it differs (multi-project setups)
+	Port    int    `json:"port"`
+	Weight  int    `json:"weight"`         // percent of requests sent to the canary (0-100)
+	Hash    string `json:"hash,omitempty"` // ip, header:<name> or cookie:<name> keeping clients on one side; random per request if empty
+}
+
+// Addr returns the canary's backend address
+func (c *Canary) Addr() string {
+	host := c.Host
+	if host == "" {
+		host = c.Service
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.Port))
+}
+
+// extractCanary extracts the canary split
+func extractCanary(route *Route, labels types.Labels) error {
+	service := labels[LabelCanaryService]
+	if service == "" {
+		for _, label := range []string{LabelCanaryPort, LabelCanaryWeight, LabelCanaryHash} {
+			if labels[label] != "" {
+				return fmt.Errorf("%s requires %s", label, LabelCanaryService)
+			}
+		}
+		return nil
+	}
+	if route.Passthrough {
+		return fmt.Errorf("%s is not supported with %s", LabelCanaryService, LabelPassthrough)
+	}
+
+	canary := &Canary{Service: service, Port: route.ServicePort}
+	if v := labels[LabelCanaryPort]; v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid %s %q", LabelCanaryPort, v)
+		}
+		canary.Port = port
+	}
+	v := labels[LabelCanaryWeight]
+	if v == "" {
+		return fmt.Errorf("%s requires %s", LabelCanaryService, LabelCanaryWeight)
+	}
+	weight, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+	if err != nil || weight < 0 || weight > 100 {
+		return fmt.Errorf("invalid %s %q (want a percentage 0-100)", LabelCanaryWeight, v)
+	}
+	canary.Weight = weight
+	if hash := labels[LabelCanaryHash]; hash != "" {
+		kind, name, _ := strings.Cut(hash, ":")
+		switch {
+		case kind == "ip" && name == "":
+		case (kind == "header" || kind == "cookie") && name != "":
+		default:
+			return fmt.Errorf("invalid %s %q (want ip, header:<name> or cookie:<name>)", LabelCanaryHash, hash)
+		}
+		canary.Hash = hash
+	}
+	route.Canary = canary
+	return nil
+}
@@ -0,0 +1,95 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCanary(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Canary
+		wantErr string
+	}{
+		{name: "none"},
+		{
+			name: "weight",
+			labels: `liteproxy.canary.service: "api-v2"
+      liteproxy.canary.weight: "10"`,
+			want: &Canary{Service: "api-v2", Port: 8080, Weight: 10},
+		},
+		{
+			name: "port, percent sign and hash",
+			labels: `liteproxy.canary.service: "api-v2"
+      liteproxy.canary.port: "9090"
+      liteproxy.canary.weight: "25%"
+      liteproxy.canary.hash: "cookie:session"`,
+			want: &Canary{Service: "api-v2", Port: 9090, Weight: 25, Hash: "cookie:session"},
+		},
+		{
+			name: "ip hash",
+			labels: `liteproxy.canary.service: "api-v2"
+      liteproxy.canary.weight: "0"
+      liteproxy.canary.hash: "ip"`,
+			want: &Canary{Service: "api-v2", Port: 8080, Hash: "ip"},
+		},
+		{name: "service without weight", labels: `liteproxy.canary.service: "api-v2"`, wantErr: "liteproxy.canary.service requires liteproxy.canary.weight"},
+		{name: "weight without service", labels: `liteproxy.canary.weight: "10"`, wantErr: "liteproxy.canary.weight requires liteproxy.canary.service"},
+		{
+			name: "weight over 100",
+			labels: `liteproxy.canary.service: "api-v2"
+      liteproxy.canary.weight: "110"`,
+			wantErr: `invalid liteproxy.canary.weight "110"`,
+		},
+		{
+			name: "invalid port",
+			labels: `liteproxy.canary.service: "api-v2"
+      liteproxy.canary.weight: "10"
+      liteproxy.canary.port: "http"`,
+			wantErr: `invalid liteproxy.canary.port "http"`,
+		},
+		{
+			name: "header hash without name",
+			labels: `liteproxy.canary.service: "api-v2"
+      liteproxy.canary.weight: "10"
+      liteproxy.canary.hash: "header:"`,
+			wantErr: `invalid liteproxy.canary.hash "header:"`,
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.canary.service: "api-v2"
+      liteproxy.canary.weight: "10"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].Canary
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Canary = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package compose
+
+import (
+	"log/slog"
+	"maps"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Proxies whose labels x-liteproxy.compat can translate
+const (
+	CompatTraefik = "traefik" // traefik.http.routers.* and traefik.http.services.*
+	CompatCaddy   = "caddy"   // caddy-docker-proxy's caddy and caddy.reverse_proxy
+)
+
+// compatLabels returns the service's labels with those of the proxies in
+// compat translated into liteproxy labels, as liteproxy import would.
+// Services with liteproxy.host or liteproxy.port are left as they are;
+// other liteproxy labels on the service override translated ones. What
+// can't be translated is logged.
+func compatLabels(service types.ServiceConfig, compat []string, filename string) types.Labels {
+	if service.Labels[LabelHost] != "" || service.Labels[LabelPort] != "" {
+		return service.Labels
+	}
+
+	im := &Import{}
+	var translated map[string]string
+	for _, name := range compat {
+		switch name {
+		case CompatTraefik:
+			translated = traefikLabels(&service, im)
+		case CompatCaddy:
+			translated = caddyLabels(&service, im)
+		}
+		if translated != nil {
+			break
+		}
+	}
+	for _, w := range im.Warnings {
+		slog.Warn("compatibility labels partly translated", "file", filename, "warning", w)
+	}
+	if translated == nil {
+		return service.Labels
+	}
+
+	labels := maps.Clone(service.Labels)
+	for k, v := range translated {
+		if _, ok := labels[k]; !ok {
+			labels[k] = v
+		}
+	}
+	return labels
+}
@@ -0,0 +1,114 @@
+package compose
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for answering CORS on behalf of the backend
+const (
+	LabelCORSOrigins     = "liteproxy.cors.origins"
+	LabelCORSMethods     = "liteproxy.cors.methods"
+	LabelCORSHeaders     = "liteproxy.cors.headers"
+	LabelCORSCredentials = "liteproxy.cors.credentials"
+)
+
+// DefaultCORSMethods are the methods allowed unless liteproxy.cors.methods is set
+var DefaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// CORS is a route's cross-origin policy. Preflights are answered by the
+// proxy, and responses to allowed origins carry the CORS headers.
+type CORS struct {
+	Origins     []string `json:"origins"`               // "*", exact origins, or "https://*.example.com" for any subdomain
+	Methods     []string `json:"methods"`               // allowed in preflights
+	Headers     []string `json:"headers,omitempty"`     // request headers allowed in preflights; empty = whatever is asked for
+	Credentials bool     `json:"credentials,omitempty"` // let browsers send cookies and authorization
+}
+
+// AnyOrigin reports whether every origin is allowed
+func (c *CORS) AnyOrigin() bool {
+	return slices.Contains(c.Origins, "*")
+}
+
+// AllowsOrigin reports whether origin, from an Origin header, may read
+// responses
+func (c *CORS) AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range c.Origins {
+		if o == "*" || o == origin {
+			return true
+		}
+		// https://*.example.com matches https://api.example.com but not https://example.com
+		if scheme, host, ok := strings.Cut(o, "://*."); ok {
+			if rest, ok := strings.CutPrefix(origin, scheme+"://"); ok && strings.HasSuffix(rest, "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowsMethod reports whether a preflight for method may be allowed
+func (c *CORS) AllowsMethod(method string) bool {
+	return slices.Contains(c.Methods, method)
+}
+
+// AllowsHeader reports whether a preflight asking for header may be allowed
+func (c *CORS) AllowsHeader(header string) bool {
+	return len(c.Headers) == 0 || slices.ContainsFunc(c.Headers, func(h string) bool {
+		return strings.EqualFold(h, header)
+	})
+}
+
+// extractCORS extracts the route's CORS policy
+func extractCORS(route *Route, labels types.Labels) error {
+	origins := labels[LabelCORSOrigins]
+	if origins == "" {
+		for _, label := range []string{LabelCORSMethods, LabelCORSHeaders, LabelCORSCredentials} {
+			if labels[label] != "" {
+				return fmt.Errorf("%s requires %s", label, LabelCORSOrigins)
+			}
+		}
+		return nil
+	}
+	if route.Passthrough {
+		return fmt.Errorf("%s is not supported with %s", LabelCORSOrigins, LabelPassthrough)
+	}
+
+	cors := &CORS{Methods: DefaultCORSMethods}
+	for _, o := range splitNames(origins) {
+		if o != "*" {
+			u, err := url.Parse(o)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" ||
+				strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+				return fmt.Errorf("invalid %s %q (want *, or origins like https://app.example.com or https://*.example.com)", LabelCORSOrigins, o)
+			}
+		}
+		cors.Origins = append(cors.Origins, strings.ToLower(o))
+	}
+	if v := labels[LabelCORSMethods]; v != "" {
+		cors.Methods = nil
+		for _, m := range splitNames(v) {
+			cors.Methods = append(cors.Methods, strings.ToUpper(m))
+		}
+	}
+	cors.Headers = splitNames(labels[LabelCORSHeaders])
+	if v := labels[LabelCORSCredentials]; v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", LabelCORSCredentials, v)
+		}
+		// Browsers refuse credentials with a wildcard origin
+		if b && cors.AnyOrigin() {
+			return fmt.Errorf("%s is not supported with %s \"*\"", LabelCORSCredentials, LabelCORSOrigins)
+		}
+		cors.Credentials = b
+	}
+	route.CORS = cors
+	return nil
+}
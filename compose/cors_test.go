@@ -0,0 +1,100 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *CORS
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "any origin", labels: `liteproxy.cors.origins: "*"`, want: &CORS{Origins: []string{"*"}, Methods: DefaultCORSMethods}},
+		{
+			name: "full",
+			labels: `liteproxy.cors.origins: "https://App.example.com, https://*.example.org"
+      liteproxy.cors.methods: "get, post"
+      liteproxy.cors.headers: "Authorization, Content-Type"
+      liteproxy.cors.credentials: "true"`,
+			want: &CORS{
+				Origins:     []string{"https://app.example.com", "https://*.example.org"},
+				Methods:     []string{"GET", "POST"},
+				Headers:     []string{"Authorization", "Content-Type"},
+				Credentials: true,
+			},
+		},
+		{name: "origin with path", labels: `liteproxy.cors.origins: "https://app.example.com/"`, wantErr: `invalid liteproxy.cors.origins "https://app.example.com/"`},
+		{name: "origin without scheme", labels: `liteproxy.cors.origins: "app.example.com"`, wantErr: "invalid liteproxy.cors.origins"},
+		{name: "inner wildcard", labels: `liteproxy.cors.origins: "https://api.*.example.com"`, wantErr: "invalid liteproxy.cors.origins"},
+		{name: "methods without origins", labels: `liteproxy.cors.methods: "GET"`, wantErr: "liteproxy.cors.methods requires liteproxy.cors.origins"},
+		{
+			name: "credentials with any origin",
+			labels: `liteproxy.cors.origins: "*"
+      liteproxy.cors.credentials: "true"`,
+			wantErr: `liteproxy.cors.credentials is not supported with liteproxy.cors.origins "*"`,
+		},
+		{
+			name: "invalid credentials",
+			labels: `liteproxy.cors.origins: "https://app.example.com"
+      liteproxy.cors.credentials: "cookies"`,
+			wantErr: `invalid liteproxy.cors.credentials "cookies"`,
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.cors.origins: "*"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  web:
+    image: web
+    labels:
+      liteproxy.host: "web.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := routes[0].CORS; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CORS = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSAllowsOrigin(t *testing.T) {
+	c := &CORS{Origins: []string{"https://app.example.com", "https://*.example.org"}}
+	tests := map[string]bool{
+		"https://app.example.com":      true,
+		"https://APP.example.com":      true,
+		"http://app.example.com":       false,
+		"https://api.example.org":      true,
+		"https://a.b.example.org":      true,
+		"https://example.org":          false,
+		"https://evilexample.org":      false,
+		"https://app.example.com.evil": false,
+	}
+	for origin, want := range tests {
+		if got := c.AllowsOrigin(origin); got != want {
+			t.Errorf("AllowsOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
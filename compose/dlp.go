@@ -0,0 +1,73 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/localrivet/liteproxy/dlp"
+)
+
+// Labels for scanning responses for sensitive data
+const (
+	LabelDLP        = "liteproxy.dlp"
+	LabelDLPMode    = "liteproxy.dlp.mode"
+	LabelDLPMaxSize = "liteproxy.dlp.max_size"
+)
+
+// Values for the liteproxy.dlp.mode label
+const (
+	DLPModeEnforce = "enforce" // patterns mask or block as written
+	DLPModeLog     = "log"     // every match is only logged and counted
+)
+
+// DefaultDLPMaxSize is the largest response body scanned
+const DefaultDLPMaxSize = 1 << 20
+
+// DLP scans response bodies for sensitive data before they reach the client
+type DLP struct {
+	Patterns []string `json:"patterns"` // pattern files, or "builtin" for the embedded set
+	Mode     string   `json:"mode"`
+	MaxSize  int      `json:"max_size"` // larger bodies pass through unscanned
+
+	Set *dlp.Set `json:"-"` // compiled when the configuration loads
+}
+
+// extractDLP extracts the response scanning settings, compiling the
+// patterns so mistakes show up at startup rather than on the first response
+func extractDLP(labels types.Labels) (*DLP, error) {
+	patterns := labels[LabelDLP]
+	if patterns == "" {
+		for _, label := range []string{LabelDLPMode, LabelDLPMaxSize} {
+			if labels[label] != "" {
+				return nil, fmt.Errorf("%s requires %s", label, LabelDLP)
+			}
+		}
+		return nil, nil
+	}
+	d := &DLP{Mode: DLPModeEnforce, MaxSize: DefaultDLPMaxSize}
+	for _, src := range strings.Split(patterns, ",") {
+		if src = strings.TrimSpace(src); src != "" {
+			d.Patterns = append(d.Patterns, src)
+		}
+	}
+	switch mode := labels[LabelDLPMode]; mode {
+	case "":
+	case DLPModeEnforce, DLPModeLog:
+		d.Mode = mode
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want %s or %s)", LabelDLPMode, mode, DLPModeEnforce, DLPModeLog)
+	}
+	if size := labels[LabelDLPMaxSize]; size != "" {
+		n, err := parseSize(size)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", LabelDLPMaxSize, size)
+		}
+		d.MaxSize = n
+	}
+	var err error
+	if d.Set, err = dlp.Load(d.Patterns); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,89 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDLP(t *testing.T) {
+	patterns := filepath.Join(t.TempDir(), "patterns.yaml")
+	os.WriteFile(patterns, []byte("patterns:\n  - id: corp-host\n    pattern: '\\.corp\\.example\\.com'\n"), 0o644)
+
+	tests := []struct {
+		name         string
+		labels       string
+		wantPatterns []string
+		wantMode     string
+		wantMaxSize  int
+		wantErr      string
+	}{
+		{name: "none"},
+		{name: "builtin", labels: `liteproxy.dlp: "builtin"`, wantPatterns: []string{"builtin"}, wantMode: DLPModeEnforce, wantMaxSize: DefaultDLPMaxSize},
+		{
+			name: "builtin and file in log mode",
+			labels: `liteproxy.dlp: "builtin, ` + patterns + `"
+      liteproxy.dlp.mode: "log"
+      liteproxy.dlp.max_size: "256KB"`,
+			wantPatterns: []string{"builtin", patterns},
+			wantMode:     DLPModeLog,
+			wantMaxSize:  256 << 10,
+		},
+		{name: "missing file", labels: `liteproxy.dlp: "/nonexistent/patterns.yaml"`, wantErr: "reading DLP patterns"},
+		{name: "mode without patterns", labels: `liteproxy.dlp.mode: "log"`, wantErr: "requires liteproxy.dlp"},
+		{
+			name: "invalid mode",
+			labels: `liteproxy.dlp: "builtin"
+      liteproxy.dlp.mode: "mask"`,
+			wantErr: "invalid liteproxy.dlp.mode",
+		},
+		{
+			name: "invalid max size",
+			labels: `liteproxy.dlp: "builtin"
+      liteproxy.dlp.max_size: "lots"`,
+			wantErr: "invalid liteproxy.dlp.max_size",
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.dlp: "builtin"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  app:
+    image: app
+    labels:
+      liteproxy.host: "app.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].DLP
+			if tt.wantPatterns == nil {
+				if got != nil {
+					t.Errorf("DLP = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || !slices.Equal(got.Patterns, tt.wantPatterns) || got.Mode != tt.wantMode || got.MaxSize != tt.wantMaxSize || got.Set == nil {
+				t.Errorf("DLP = %+v, want patterns %v in %s mode up to %d bytes, compiled", got, tt.wantPatterns, tt.wantMode, tt.wantMaxSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for how a route's backend names are resolved
+const (
+	LabelDNSTTL = "liteproxy.dns.ttl"
+	LabelDNSPin = "liteproxy.dns.pin"
+)
+
+// DNS overrides how often a route's backend names are looked up, and
+// whether the last addresses they resolved to are kept when lookups fail
+type DNS struct {
+	TTL time.Duration `json:"ttl,omitempty"` // how long answers are used before a new lookup (0 = every connection)
+	Pin bool          `json:"pin,omitempty"` // dial the last good addresses while lookups fail
+}
+
+// extractDNS extracts DNS settings. It must run after the dialer and
+// discovery are known, as dialed backends aren't resolved by liteproxy and
+// DNS discovery always pins.
+func extractDNS(route *Route, labels types.Labels) error {
+	ttl, pin := labels[LabelDNSTTL], labels[LabelDNSPin]
+	if ttl == "" && pin == "" {
+		return nil
+	}
+	label := LabelDNSTTL
+	if ttl == "" {
+		label = LabelDNSPin
+	}
+	switch {
+	case route.Passthrough:
+		return fmt.Errorf("%s is not supported with %s", label, LabelPassthrough)
+	case route.Dial != "":
+		return fmt.Errorf("%s is not supported with %s", label, LabelDial)
+	}
+
+	dns := &DNS{}
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", LabelDNSTTL, ttl)
+		}
+		dns.TTL = d
+	}
+	if pin != "" {
+		p, err := strconv.ParseBool(pin)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", LabelDNSPin, pin)
+		}
+		if !p && route.Discovery == DiscoveryDNS {
+			return fmt.Errorf("%s %q is not supported with %s %q, which always keeps the last good addresses", LabelDNSPin, pin, LabelDiscovery, DiscoveryDNS)
+		}
+		dns.Pin = p
+	}
+	if *dns != (DNS{}) {
+		route.DNS = dns
+	}
+	return nil
+}
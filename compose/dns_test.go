@@ -0,0 +1,81 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDNS(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *DNS
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "ttl", labels: `liteproxy.dns.ttl: "1m"`, want: &DNS{TTL: time.Minute}},
+		{name: "pin", labels: `liteproxy.dns.pin: "true"`, want: &DNS{Pin: true}},
+		{name: "pin off", labels: `liteproxy.dns.pin: "false"`},
+		{
+			name: "ttl and pin",
+			labels: `liteproxy.dns.ttl: "30s"
+      liteproxy.dns.pin: "true"`,
+			want: &DNS{TTL: 30 * time.Second, Pin: true},
+		},
+		{name: "invalid ttl", labels: `liteproxy.dns.ttl: "0s"`, wantErr: `invalid liteproxy.dns.ttl "0s"`},
+		{name: "invalid pin", labels: `liteproxy.dns.pin: "sometimes"`, wantErr: `invalid liteproxy.dns.pin "sometimes"`},
+		{
+			name: "passthrough",
+			labels: `liteproxy.dns.pin: "true"
+      liteproxy.passthrough: "true"`,
+			wantErr: "liteproxy.dns.pin is not supported with liteproxy.passthrough",
+		},
+		{
+			name: "pin with dns discovery",
+			labels: `liteproxy.dns.pin: "true"
+      liteproxy.discovery: "dns"`,
+			want: &DNS{Pin: true},
+		},
+		{
+			name: "pin off with dns discovery",
+			labels: `liteproxy.dns.pin: "false"
+      liteproxy.discovery: "dns"`,
+			wantErr: `liteproxy.dns.pin "false" is not supported with liteproxy.discovery "dns"`,
+		},
+		{
+			name: "dial",
+			labels: `liteproxy.dns.ttl: "1m"
+      liteproxy.dial: "socks5://proxy:1080"`,
+			wantErr: "liteproxy.dns.ttl is not supported with liteproxy.dial",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  web:
+    image: web
+    labels:
+      liteproxy.host: "web.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].DNS
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("DNS = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
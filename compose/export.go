@@ -0,0 +1,127 @@
+package compose
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ExportedRoute is a route as shown by `liteproxy routes` and the admin API
+type ExportedRoute struct {
+	Route
+	Upstreams []string `json:"upstreams"` // resolved backend addresses
+}
+
+// Export returns routes with their upstreams, sorted by host then path
+func Export(routes []Route) []ExportedRoute {
+	exported := make([]ExportedRoute, len(routes))
+	for i, r := range routes {
+		exported[i] = ExportedRoute{Route: r, Upstreams: r.Addrs()}
+	}
+	slices.SortStableFunc(exported, func(a, b ExportedRoute) int {
+		return cmp.Or(strings.Compare(a.Host, b.Host), strings.Compare(a.Path(), b.Path()))
+	})
+	return exported
+}
+
+// Hash fingerprints the effective route table, so two proxies (or two
+// reloads) with the same hash route identically
+func Hash(routes []Route) string {
+	data, err := json.Marshal(Export(routes))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// RouteDiff is what changed between two route tables
+type RouteDiff struct {
+	Added   []Route       `json:"added,omitempty"`
+	Removed []Route       `json:"removed,omitempty"`
+	Changed []RouteChange `json:"changed,omitempty"`
+}
+
+// RouteChange is a route whose settings changed
+type RouteChange struct {
+	Route  Route    `json:"route"`  // the new settings
+	Fields []string `json:"fields"` // JSON names of the settings that differ
+}
+
+// Empty reports whether the tables route identically
+func (d RouteDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Key identifies a route across reloads: its host and path, the ports
+// of a forward route, or the host and port of a passthrough preset
+func (r *Route) Key() string {
+	if r.IsForward() {
+		return fmt.Sprintf("tcp:%d udp:%d", r.TCPPort, r.UDPPort)
+	}
+	if len(r.SNIPorts) != 0 {
+		ports := make([]string, len(r.SNIPorts))
+		for i, port := range r.SNIPorts {
+			ports[i] = strconv.Itoa(port)
+		}
+		return r.Host + " sni:" + strings.Join(ports, ",")
+	}
+	return r.Host + r.Path()
+}
+
+// Diff compares two route tables by Key, sorted like Export
+func Diff(old, new []Route) RouteDiff {
+	before := make(map[string]Route, len(old))
+	for _, r := range Export(old) {
+		before[r.Key()] = r.Route
+	}
+	var d RouteDiff
+	seen := make(map[string]bool, len(new))
+	for _, r := range Export(new) {
+		key := r.Key()
+		seen[key] = true
+		prev, ok := before[key]
+		if !ok {
+			d.Added = append(d.Added, r.Route)
+			continue
+		}
+		if fields := changedFields(prev, r.Route); len(fields) > 0 {
+			d.Changed = append(d.Changed, RouteChange{Route: r.Route, Fields: fields})
+		}
+	}
+	for _, r := range Export(old) {
+		if !seen[r.Key()] {
+			d.Removed = append(d.Removed, r.Route)
+		}
+	}
+	return d
+}
+
+// changedFields returns the JSON names of the settings that differ
+func changedFields(a, b Route) []string {
+	var fa, fb map[string]json.RawMessage
+	da, _ := json.Marshal(a)
+	db, _ := json.Marshal(b)
+	json.Unmarshal(da, &fa)
+	json.Unmarshal(db, &fb)
+
+	var fields []string
+	for _, name := range slices.Sorted(maps.Keys(fa)) {
+		if string(fa[name]) != string(fb[name]) {
+			fields = append(fields, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(fb)) {
+		if _, ok := fa[name]; !ok {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
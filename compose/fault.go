@@ -0,0 +1,81 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels injecting faults for chaos testing
+const (
+	LabelFaultDelay  = "liteproxy.fault.delay"
+	LabelFaultAbort  = "liteproxy.fault.abort"
+	LabelFaultStatus = "liteproxy.fault.status"
+)
+
+// DefaultFaultStatus is answered to aborted requests unless
+// liteproxy.fault.status is set
+const DefaultFaultStatus = 503
+
+// Fault is an artificial failure injected before requests reach the
+// backend, to test how clients cope with a slow or failing edge
+type Fault struct {
+	Delay        time.Duration `json:"delay,omitempty"`         // added before every request
+	AbortPercent float64       `json:"abort_percent,omitempty"` // share of requests answered with AbortStatus
+	AbortStatus  int           `json:"abort_status,omitempty"`
+}
+
+// ParseFault reads a fault from the values of its labels, as set on a
+// route or through the admin API. It returns nil if delay and abort are
+// both empty.
+func ParseFault(delay, abort, status string) (*Fault, error) {
+	if delay == "" && abort == "" {
+		if status != "" {
+			return nil, fmt.Errorf("%s requires %s", LabelFaultStatus, LabelFaultAbort)
+		}
+		return nil, nil
+	}
+	f := &Fault{}
+	if delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s %q (want a duration like 200ms)", LabelFaultDelay, delay)
+		}
+		f.Delay = d
+	}
+	if abort != "" {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(abort, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return nil, fmt.Errorf("invalid %s %q (want a percentage like 10%%)", LabelFaultAbort, abort)
+		}
+		f.AbortPercent = p
+		f.AbortStatus = DefaultFaultStatus
+	}
+	if status != "" {
+		if abort == "" {
+			return nil, fmt.Errorf("%s requires %s", LabelFaultStatus, LabelFaultAbort)
+		}
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 400 || code > 599 {
+			return nil, fmt.Errorf("invalid %s %q (want a 4xx or 5xx status)", LabelFaultStatus, status)
+		}
+		f.AbortStatus = code
+	}
+	return f, nil
+}
+
+// extractFault extracts liteproxy.fault.*
+func extractFault(route *Route, labels types.Labels) error {
+	f, err := ParseFault(labels[LabelFaultDelay], labels[LabelFaultAbort], labels[LabelFaultStatus])
+	if err != nil {
+		return err
+	}
+	if f != nil && route.Passthrough {
+		return fmt.Errorf("liteproxy.fault.* is not supported with %s", LabelPassthrough)
+	}
+	route.Fault = f
+	return nil
+}
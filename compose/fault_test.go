@@ -0,0 +1,78 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFault(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Fault
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "delay", labels: `liteproxy.fault.delay: "250ms"`, want: &Fault{Delay: 250 * time.Millisecond}},
+		{name: "abort", labels: `liteproxy.fault.abort: "10%"`, want: &Fault{AbortPercent: 10, AbortStatus: 503}},
+		{name: "abort without percent sign", labels: `liteproxy.fault.abort: "2.5"`, want: &Fault{AbortPercent: 2.5, AbortStatus: 503}},
+		{
+			name: "all",
+			labels: `liteproxy.fault.delay: "1s"
+      liteproxy.fault.abort: "100%"
+      liteproxy.fault.status: "429"`,
+			want: &Fault{Delay: time.Second, AbortPercent: 100, AbortStatus: 429},
+		},
+		{name: "invalid delay", labels: `liteproxy.fault.delay: "slow"`, wantErr: `invalid liteproxy.fault.delay "slow"`},
+		{name: "zero abort", labels: `liteproxy.fault.abort: "0%"`, wantErr: `invalid liteproxy.fault.abort "0%"`},
+		{name: "abort over 100", labels: `liteproxy.fault.abort: "150%"`, wantErr: `invalid liteproxy.fault.abort "150%"`},
+		{name: "status alone", labels: `liteproxy.fault.status: "500"`, wantErr: "liteproxy.fault.status requires liteproxy.fault.abort"},
+		{
+			name: "status with delay only",
+			labels: `liteproxy.fault.delay: "1s"
+      liteproxy.fault.status: "500"`,
+			wantErr: "liteproxy.fault.status requires liteproxy.fault.abort",
+		},
+		{
+			name: "invalid status",
+			labels: `liteproxy.fault.abort: "5%"
+      liteproxy.fault.status: "200"`,
+			wantErr: `invalid liteproxy.fault.status "200"`,
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.fault.delay: "1s"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  web:
+    image: web
+    labels:
+      liteproxy.host: "staging.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].Fault
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Fault = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
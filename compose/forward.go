@@ -0,0 +1,106 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels exposing a non-HTTP service on a dedicated host port
+const (
+	LabelTCPPort = "liteproxy.tcp.port"
+	LabelUDPPort = "liteproxy.udp.port"
+)
+
+// IsForward reports whether the route forwards raw TCP or UDP ports rather
+// than serving a host
+func (r *Route) IsForward() bool {
+	return r.TCPPort != 0 || r.UDPPort != 0
+}
+
+// extractForward extracts a route forwarding host ports to the service's
+// liteproxy.port, such as a database or SMTP server. It has no host.
+func extractForward(service types.ServiceConfig) (*Route, error) {
+	labels := service.Labels
+	if labels[LabelHost] != "" {
+		return nil, fmt.Errorf("%s and %s are not supported with %s; forward routes have no host", LabelTCPPort, LabelUDPPort, LabelHost)
+	}
+	portStr := labels[LabelPort]
+	if portStr == "" {
+		return nil, fmt.Errorf("missing required label %s", LabelPort)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+	route := &Route{ServiceName: service.Name, ServicePort: port}
+	for _, p := range []struct {
+		label string
+		port  *int
+	}{{LabelTCPPort, &route.TCPPort}, {LabelUDPPort, &route.UDPPort}} {
+		v := labels[p.label]
+		if v == "" {
+			continue
+		}
+		if *p.port, err = strconv.Atoi(v); err != nil || *p.port < 1 || *p.port > 65535 {
+			return nil, fmt.Errorf("invalid %s %q (want a port number)", p.label, v)
+		}
+	}
+
+	// Tell TCP backends the client's address, like passthrough routes
+	switch v := labels[LabelProxyProtocol]; v {
+	case "", "false":
+	case "true", "v2":
+		route.ProxyProtocol = 2
+	case "v1":
+		route.ProxyProtocol = 1
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want true, v1 or v2)", LabelProxyProtocol, v)
+	}
+	if route.ProxyProtocol != 0 && route.TCPPort == 0 {
+		return nil, fmt.Errorf("%s requires %s", LabelProxyProtocol, LabelTCPPort)
+	}
+	return route, nil
+}
+
+// checkForwardPorts makes sure no two routes forward the same host port.
+// Passthrough presets share their port, routed by SNI, but not their host;
+// the SNI-only presets (mqtt, amqp, tls) can share a port with each other.
+func checkForwardPorts(routes []Route) error {
+	owners := make(map[string]string)
+	shared := make(map[string]string)   // port → preset of the routes sharing it
+	sniHosts := make(map[string]string) // port and host → service
+	for _, r := range routes {
+		for _, port := range r.SNIPorts {
+			key := "tcp/" + strconv.Itoa(port)
+			if prev, ok := owners[key]; ok && shared[key] == "" {
+				return fmt.Errorf("tcp port %d is forwarded by both %s and %s", port, prev, r.ServiceName)
+			}
+			if preset := shared[key]; preset != "" && preset != r.Preset && !(sniOnly(preset) && sniOnly(r.Preset)) {
+				return fmt.Errorf("tcp port %d is shared by %s (%s) and %s (%s)", port, owners[key], preset, r.ServiceName, r.Preset)
+			}
+			owners[key], shared[key] = r.ServiceName, r.Preset
+			for _, host := range append([]string{r.Host}, r.Aliases...) {
+				if prev, ok := sniHosts[key+" "+host]; ok {
+					return fmt.Errorf("%s and %s both serve %s on port %d", prev, r.ServiceName, host, port)
+				}
+				sniHosts[key+" "+host] = r.ServiceName
+			}
+		}
+		for _, p := range []struct {
+			proto string
+			port  int
+		}{{"tcp", r.TCPPort}, {"udp", r.UDPPort}} {
+			if p.port == 0 {
+				continue
+			}
+			key := p.proto + "/" + strconv.Itoa(p.port)
+			if prev, ok := owners[key]; ok {
+				return fmt.Errorf("%s port %d is forwarded by both %s and %s", p.proto, p.port, prev, r.ServiceName)
+			}
+			owners[key] = r.ServiceName
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    Route
+		wantErr string
+	}{
+		{
+			name: "tcp",
+			labels: `liteproxy.tcp.port: "5432"
+      liteproxy.port: "5432"`,
+			want: Route{ServiceName: "svc", ServicePort: 5432, TCPPort: 5432},
+		},
+		{
+			name: "tcp and udp with proxy protocol",
+			labels: `liteproxy.tcp.port: "53"
+      liteproxy.udp.port: "53"
+      liteproxy.port: "5353"
+      liteproxy.proxy_protocol: "v1"`,
+			want: Route{ServiceName: "svc", ServicePort: 5353, TCPPort: 53, UDPPort: 53, ProxyProtocol: 1},
+		},
+		{name: "no backend port", labels: `liteproxy.udp.port: "514"`, wantErr: "missing required label liteproxy.port"},
+		{
+			name: "with host",
+			labels: `liteproxy.tcp.port: "25"
+      liteproxy.host: "mail.example.com"
+      liteproxy.port: "25"`,
+			wantErr: "not supported with liteproxy.host",
+		},
+		{
+			name: "invalid port",
+			labels: `liteproxy.tcp.port: "70000"
+      liteproxy.port: "25"`,
+			wantErr: "invalid liteproxy.tcp.port",
+		},
+		{
+			name: "proxy protocol on udp",
+			labels: `liteproxy.udp.port: "514"
+      liteproxy.port: "514"
+      liteproxy.proxy_protocol: "true"`,
+			wantErr: "requires liteproxy.tcp.port",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  svc:
+    image: svc
+    labels:
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0]
+			if !got.IsForward() || got.Host != "" || got.ServicePort != tt.want.ServicePort || got.TCPPort != tt.want.TCPPort ||
+				got.UDPPort != tt.want.UDPPort || got.ProxyProtocol != tt.want.ProxyProtocol {
+				t.Errorf("route = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilesForwardConflict(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name+".yaml")
+		os.WriteFile(path, []byte(`
+services:
+  db-`+name+`:
+    image: postgres
+    labels:
+      liteproxy.tcp.port: "5432"
+      liteproxy.port: "5432"
+`), 0o644)
+		files = append(files, path)
+	}
+	if _, err := ParseFiles(files); err == nil || !strings.Contains(err.Error(), "tcp port 5432 is forwarded by both db-a and db-b") {
+		t.Errorf("ParseFiles() error = %v", err)
+	}
+	if _, err := ParseFiles(files[:1]); err != nil {
+		t.Errorf("one file: %v", err)
+	}
+}
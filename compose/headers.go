@@ -0,0 +1,99 @@
+package compose
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// LabelHeaders prefixes the header manipulation labels:
+//
+//	liteproxy.headers.request.set.<Name>: value
+//	liteproxy.headers.request.add.<Name>: value
+//	liteproxy.headers.request.remove: Name, Name
+//
+// and the same under liteproxy.headers.response
+const LabelHeaders = "liteproxy.headers."
+
+// HeaderRules are changes to the headers of a route's requests or responses,
+// applied in order: remove, set, add
+type HeaderRules struct {
+	Remove []string          `json:"remove,omitempty"` // headers to delete
+	Set    map[string]string `json:"set,omitempty"`    // headers to replace
+	Add    map[string]string `json:"add,omitempty"`    // values appended to existing headers
+}
+
+func (h *HeaderRules) set(name, value string) {
+	if h.Set == nil {
+		h.Set = make(map[string]string)
+	}
+	h.Set[name] = value
+}
+
+func (h *HeaderRules) add(name, value string) {
+	if h.Add == nil {
+		h.Add = make(map[string]string)
+	}
+	h.Add[name] = value
+}
+
+// extractHeaders extracts request and response header rules, returning nil
+// for a side without any
+func extractHeaders(labels types.Labels) (request, response *HeaderRules, err error) {
+	rules := map[string]*HeaderRules{"request": {}, "response": {}}
+	for key, value := range labels {
+		rest, ok := strings.CutPrefix(key, LabelHeaders)
+		if !ok {
+			continue
+		}
+		side, action, _ := strings.Cut(rest, ".")
+		r, ok := rules[side]
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid label %s (want %srequest.* or %sresponse.*)", key, LabelHeaders, LabelHeaders)
+		}
+
+		if action == "remove" {
+			for _, name := range strings.Split(value, ",") {
+				name, err := headerName(key, strings.TrimSpace(name))
+				if err != nil {
+					return nil, nil, err
+				}
+				r.Remove = append(r.Remove, name)
+			}
+			continue
+		}
+
+		action, name, _ := strings.Cut(action, ".")
+		name, err := headerName(key, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch action {
+		case "set":
+			r.set(name, value)
+		case "add":
+			r.add(name, value)
+		default:
+			return nil, nil, fmt.Errorf("invalid label %s (want set.<Name>, add.<Name> or remove)", key)
+		}
+	}
+
+	empty := func(r *HeaderRules) bool { return len(r.Remove) == 0 && len(r.Set) == 0 && len(r.Add) == 0 }
+	if !empty(rules["request"]) {
+		request = rules["request"]
+	}
+	if !empty(rules["response"]) {
+		response = rules["response"]
+	}
+	return request, response, nil
+}
+
+// headerName validates and canonicalizes a header name from label key
+func headerName(key, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, " \t:\"(),/;<=>?@[\\]{}") {
+		return "", fmt.Errorf("invalid header name %q in %s", name, key)
+	}
+	return textproto.CanonicalMIMEHeaderKey(name), nil
+}
@@ -0,0 +1,344 @@
+package compose
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// ImportedService is a service with liteproxy labels translated from another
+// proxy's configuration
+type ImportedService struct {
+	Name   string
+	Labels map[string]string
+}
+
+// Import results, with warnings for anything that could not be translated
+type Import struct {
+	Services []ImportedService
+	Warnings []string
+}
+
+func (im *Import) warnf(format string, args ...any) {
+	im.Warnings = append(im.Warnings, fmt.Sprintf(format, args...))
+}
+
+// traefikRule matches one matcher in a Traefik rule, e.g. Host(`a`, `b`)
+var traefikRule = regexp.MustCompile(`(\w+)\(([^)]*)\)`)
+
+// traefikObject holds the labels of one Traefik router, service or middleware
+type traefikObject map[string]string
+
+// ImportTraefik translates Traefik docker labels in compose yaml data into
+// liteproxy labels. Each service maps to one route, so only the first HTTP
+// router of a service is translated
+func ImportTraefik(data []byte, filename string) (*Import, error) {
+	project, err := loadProject(data, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &Import{}
+	for _, name := range slices.Sorted(maps.Keys(project.Services)) {
+		service := project.Services[name]
+		if labels := traefikLabels(&service, im); labels != nil {
+			im.Services = append(im.Services, ImportedService{Name: name, Labels: labels})
+		}
+	}
+	return im, nil
+}
+
+// traefikLabels translates one service's Traefik labels, returning nil if
+// it has none or is disabled
+func traefikLabels(service *types.ServiceConfig, im *Import) map[string]string {
+	if service.Labels["traefik.enable"] == "false" {
+		return nil
+	}
+
+	// Group labels by kind (http.routers, tcp.services, ...) and object name
+	objects := make(map[string]map[string]traefikObject)
+	for _, key := range slices.Sorted(maps.Keys(service.Labels)) {
+		value := service.Labels[key]
+		parts := strings.SplitN(strings.ToLower(key), ".", 5)
+		if parts[0] != "traefik" {
+			continue
+		}
+		if len(parts) < 5 {
+			if key != "traefik.enable" && !strings.HasPrefix(key, "traefik.docker.") {
+				im.warnf("service %s: %s not translated", service.Name, key)
+			}
+			continue
+		}
+		kind := parts[1] + "." + parts[2]
+		if objects[kind] == nil {
+			objects[kind] = make(map[string]traefikObject)
+		}
+		if objects[kind][parts[3]] == nil {
+			objects[kind][parts[3]] = make(traefikObject)
+		}
+		objects[kind][parts[3]][parts[4]] = value
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	routers, services := objects["http.routers"], objects["http.services"]
+	passthrough := false
+	if len(routers) == 0 {
+		routers, services = objects["tcp.routers"], objects["tcp.services"]
+		passthrough = true
+	}
+	if len(routers) == 0 {
+		im.warnf("service %s: no HTTP or TCP router, skipped", service.Name)
+		return nil
+	}
+	routerNames := slices.Sorted(maps.Keys(routers))
+	if len(routerNames) > 1 {
+		im.warnf("service %s: only router %s translated, ignoring %s", service.Name, routerNames[0], strings.Join(routerNames[1:], ", "))
+	}
+	router := routers[routerNames[0]]
+
+	labels := make(map[string]string)
+	hosts, path := parseTraefikRule(router["rule"], service.Name, im)
+	if len(hosts) == 0 {
+		im.warnf("service %s: rule %q has no host, skipped", service.Name, router["rule"])
+		return nil
+	}
+	setHosts(labels, hosts, service.Name, im)
+
+	if passthrough {
+		if router["tls.passthrough"] != "true" {
+			im.warnf("service %s: TCP router without tls.passthrough is translated as passthrough", service.Name)
+		}
+		labels[LabelPassthrough] = "true"
+	} else if path != "" && path != "/" {
+		labels[LabelPath] = path
+	}
+
+	// The router's service, or the only service defined
+	lb := traefikObject{}
+	if name := strings.ToLower(router["service"]); name != "" {
+		lb = services[strings.TrimSuffix(name, "@docker")]
+	} else if len(services) == 1 {
+		for _, s := range services {
+			lb = s
+		}
+	}
+	if port := cmp.Or(lb["loadbalancer.server.port"], containerPort(service)); port != "" {
+		labels[LabelPort] = port
+	} else {
+		im.warnf("service %s: no port found, skipped", service.Name)
+		return nil
+	}
+
+	if !passthrough {
+		// Traefik passes the Host header by default, liteproxy does not
+		labels[LabelPassHost] = cmp.Or(lb["loadbalancer.passhostheader"], "true")
+		if p := lb["loadbalancer.healthcheck.path"]; p != "" {
+			labels[LabelHealthType] = HealthHTTP
+			labels[LabelHealthPath] = p
+		}
+		if v := lb["loadbalancer.healthcheck.interval"]; v != "" {
+			labels[LabelHealthInterval] = v
+		}
+		if v := lb["loadbalancer.healthcheck.timeout"]; v != "" {
+			labels[LabelHealthTimeout] = v
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(lb)) {
+		switch key {
+		case "loadbalancer.server.port", "loadbalancer.passhostheader", "loadbalancer.healthcheck.path",
+			"loadbalancer.healthcheck.interval", "loadbalancer.healthcheck.timeout":
+		default:
+			im.warnf("service %s: service option %s not translated", service.Name, key)
+		}
+	}
+
+	middlewares := objects["http.middlewares"]
+	for _, name := range strings.Split(router["middlewares"], ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		name, _, _ = strings.Cut(name, "@")
+		mw := middlewares[name]
+		switch {
+		case mw["stripprefix.prefixes"] != "":
+			if slices.Contains(strings.Split(mw["stripprefix.prefixes"], ","), labels[LabelPath]) {
+				labels[LabelStripPrefix] = "true"
+			} else {
+				im.warnf("service %s: stripprefix %s does not match path %s", service.Name, mw["stripprefix.prefixes"], labels[LabelPath])
+			}
+		case mw["redirectscheme.scheme"] == "https":
+			// liteproxy redirects HTTP to HTTPS already
+		default:
+			im.warnf("service %s: middleware %s not translated", service.Name, name)
+		}
+	}
+	return labels
+}
+
+// setHosts sets the route's host from the first of hosts, serving its www.
+// variant too if listed
+func setHosts(labels map[string]string, hosts []string, service string, im *Import) {
+	labels[LabelHost] = hosts[0]
+	for _, h := range hosts[1:] {
+		if h == "www."+hosts[0] {
+			labels[LabelWWW] = WWWServe
+		} else {
+			im.warnf("service %s: extra host %s not translated, add a separate service", service, h)
+		}
+	}
+}
+
+// parseTraefikRule extracts hosts and the path prefix from a Traefik rule
+func parseTraefikRule(rule, service string, im *Import) (hosts []string, path string) {
+	for _, m := range traefikRule.FindAllStringSubmatch(rule, -1) {
+		var args []string
+		for _, a := range strings.Split(m[2], ",") {
+			if a = strings.Trim(strings.TrimSpace(a), "`\"'"); a != "" {
+				args = append(args, a)
+			}
+		}
+		switch m[1] {
+		case "Host", "HostSNI":
+			hosts = append(hosts, args...)
+		case "PathPrefix", "Path":
+			if m[1] == "Path" {
+				im.warnf("service %s: exact Path(%s) translated as a prefix", service, strings.Join(args, ", "))
+			}
+			if path == "" && len(args) > 0 {
+				path = strings.TrimSuffix(args[0], "/")
+			} else {
+				im.warnf("service %s: only the first path in %q translated", service, rule)
+			}
+		default:
+			im.warnf("service %s: matcher %s not translated", service, m[1])
+		}
+	}
+	return hosts, path
+}
+
+// containerPort returns the service's only exposed port, as Traefik does
+func containerPort(service *types.ServiceConfig) string {
+	if len(service.Ports) == 1 {
+		return strconv.Itoa(int(service.Ports[0].Target))
+	}
+	if len(service.Expose) == 1 {
+		return service.Expose[0]
+	}
+	return ""
+}
+
+// caddySite matches the caddy-docker-proxy labels naming a site: caddy, or
+// caddy_0, caddy_1... for several sites
+var caddySite = regexp.MustCompile(`^caddy(_\d+)?$`)
+
+// caddyUpstreams matches caddy-docker-proxy's {{upstreams}} template, with
+// an optional scheme and port
+var caddyUpstreams = regexp.MustCompile(`^\{\{\s*upstreams\s*(https?)?\s*(\d+)?\s*\}\}$`)
+
+// caddyHandlePathProxy matches the reverse_proxy inside a handle_path block,
+// optionally ordered as 0_reverse_proxy
+var caddyHandlePathProxy = regexp.MustCompile(`^handle_path\.(\d+_)?reverse_proxy$`)
+
+// ImportCaddy translates caddy-docker-proxy labels in compose yaml data into
+// liteproxy labels. Each service maps to one route, so only the first site
+// of a service is translated
+func ImportCaddy(data []byte, filename string) (*Import, error) {
+	project, err := loadProject(data, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &Import{}
+	for _, name := range slices.Sorted(maps.Keys(project.Services)) {
+		service := project.Services[name]
+		if labels := caddyLabels(&service, im); labels != nil {
+			im.Services = append(im.Services, ImportedService{Name: name, Labels: labels})
+		}
+	}
+	return im, nil
+}
+
+// caddyLabels translates one service's caddy-docker-proxy labels, returning
+// nil if it has none. The site needs a reverse_proxy to {{upstreams}},
+// directly or in a handle_path block.
+func caddyLabels(service *types.ServiceConfig, im *Import) map[string]string {
+	var sites []string
+	for _, key := range slices.Sorted(maps.Keys(service.Labels)) {
+		if caddySite.MatchString(key) {
+			sites = append(sites, key)
+		}
+	}
+	if len(sites) == 0 {
+		return nil
+	}
+	if len(sites) > 1 {
+		im.warnf("service %s: only site %s translated, ignoring %s", service.Name, sites[0], strings.Join(sites[1:], ", "))
+	}
+	site := sites[0]
+
+	var hosts []string
+	for _, h := range strings.FieldsFunc(service.Labels[site], func(r rune) bool { return r == ',' || r == ' ' }) {
+		h = strings.TrimPrefix(strings.TrimPrefix(h, "https://"), "http://")
+		hosts = append(hosts, h)
+	}
+	if len(hosts) == 0 {
+		im.warnf("service %s: %s has no host, skipped", service.Name, site)
+		return nil
+	}
+
+	var upstream, path string
+	strip := false
+	for _, key := range slices.Sorted(maps.Keys(service.Labels)) {
+		directive, ok := strings.CutPrefix(key, site+".")
+		if !ok {
+			continue
+		}
+		value := service.Labels[key]
+		switch {
+		case directive == "reverse_proxy":
+			upstream = value
+			// An optional path matcher comes first, e.g. /api/* {{upstreams 8080}}
+			if matcher, rest, ok := strings.Cut(value, " "); ok && strings.HasPrefix(matcher, "/") {
+				path, upstream = matcher, strings.TrimSpace(rest)
+			}
+		case directive == "handle_path":
+			path, strip = value, true
+		case caddyHandlePathProxy.MatchString(directive):
+			upstream = value
+		default:
+			im.warnf("service %s: caddy directive %s not translated", service.Name, directive)
+		}
+	}
+
+	m := caddyUpstreams.FindStringSubmatch(strings.TrimSpace(upstream))
+	if m == nil {
+		im.warnf("service %s: reverse_proxy %q not translated, skipped", service.Name, upstream)
+		return nil
+	}
+
+	labels := make(map[string]string)
+	setHosts(labels, hosts, service.Name, im)
+	// {{upstreams}} without a port dials port 80
+	labels[LabelPort] = cmp.Or(m[2], "80")
+	if m[1] == "https" {
+		labels[LabelProtocol] = SchemeHTTPS
+	}
+	if path = strings.TrimSuffix(strings.TrimSuffix(path, "*"), "/"); path != "" {
+		labels[LabelPath] = path
+		if strip {
+			labels[LabelStripPrefix] = "true"
+		}
+	}
+	// Caddy passes the Host header by default, liteproxy does not
+	labels[LabelPassHost] = "true"
+	return labels
+}
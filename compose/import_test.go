@@ -0,0 +1,188 @@
+package compose
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestImportTraefik(t *testing.T) {
+	yaml := `
+services:
+  api:
+    image: api
+    expose: ["8080"]
+    labels:
+      traefik.enable: "true"
+      traefik.http.routers.api.rule: "Host(` + "`example.com`" + `) && PathPrefix(` + "`/api/`" + `)"
+      traefik.http.routers.api.middlewares: "api-strip@docker,auth"
+      traefik.http.middlewares.api-strip.stripprefix.prefixes: "/api"
+      traefik.http.services.api.loadBalancer.healthCheck.path: "/health"
+      traefik.http.services.api.loadBalancer.healthCheck.interval: "5s"
+  web:
+    image: web
+    labels:
+      traefik.http.routers.web.rule: "Host(` + "`example.com`, `www.example.com`" + `)"
+      traefik.http.services.web.loadbalancer.server.port: "3000"
+      traefik.http.services.web.loadbalancer.passhostheader: "false"
+  db:
+    image: postgres
+    labels:
+      traefik.tcp.routers.db.rule: "HostSNI(` + "`db.example.com`" + `)"
+      traefik.tcp.routers.db.tls.passthrough: "true"
+      traefik.tcp.services.db.loadbalancer.server.port: "5432"
+  hidden:
+    image: hidden
+    labels:
+      traefik.enable: "false"
+      traefik.http.routers.hidden.rule: "Host(` + "`hidden.example.com`" + `)"
+`
+	im, err := ImportTraefik([]byte(yaml), "compose.yaml")
+	if err != nil {
+		t.Fatalf("ImportTraefik() error = %v", err)
+	}
+
+	want := []ImportedService{
+		{Name: "api", Labels: map[string]string{
+			LabelHost: "example.com", LabelPath: "/api", LabelPort: "8080", LabelStripPrefix: "true", LabelPassHost: "true",
+			LabelHealthType: "http", LabelHealthPath: "/health", LabelHealthInterval: "5s",
+		}},
+		{Name: "db", Labels: map[string]string{LabelHost: "db.example.com", LabelPort: "5432", LabelPassthrough: "true"}},
+		{Name: "web", Labels: map[string]string{LabelHost: "example.com", LabelPort: "3000", LabelPassHost: "false", LabelWWW: WWWServe}},
+	}
+	checkImported(t, im, want)
+
+	if len(im.Warnings) != 1 || !strings.Contains(im.Warnings[0], "middleware auth") {
+		t.Errorf("Warnings = %q, want only the auth middleware", im.Warnings)
+	}
+}
+
+func TestImportNginx(t *testing.T) {
+	conf := `
+http {
+    upstream api_pool {
+        hash $request_uri;
+        server api-1:8080;
+        server api-2:8080 weight=2;
+    }
+
+    server {
+        listen 443 ssl;
+        server_name example.com www.example.com;
+        proxy_set_header Host $host;  # keep the client's host
+
+        location / {
+            proxy_pass http://web:3000;
+        }
+        location /api/ {
+            proxy_pass http://api_pool/;
+            proxy_buffering off;
+        }
+        location ~ \.php$ {
+            proxy_pass http://php;
+        }
+    }
+
+    server {
+        server_name "docs.example.com";
+        location / { proxy_pass http://web; }
+    }
+
+    server {
+        listen 80;
+        server_name _;
+        return 301 https://$host$request_uri;
+    }
+}
+`
+	im, err := ImportNginx([]byte(conf))
+	if err != nil {
+		t.Fatalf("ImportNginx() error = %v", err)
+	}
+
+	want := []ImportedService{
+		{Name: "web", Labels: map[string]string{LabelHost: "example.com", LabelPort: "3000", LabelPassHost: "true", LabelWWW: WWWServe}},
+		{Name: "api_pool", Labels: map[string]string{
+			LabelHost: "example.com", LabelPath: "/api", LabelPort: "8080", LabelStripPrefix: "true", LabelPassHost: "true",
+			LabelBackends: "api-1:8080,api-2:8080", LabelBalance: "url_hash", LabelResponseBuffering: "false", LabelWWW: WWWServe,
+		}},
+		{Name: "web-2", Labels: map[string]string{LabelHost: "docs.example.com", LabelPort: "80"}},
+	}
+	checkImported(t, im, want)
+
+	if len(im.Warnings) != 1 || !strings.Contains(im.Warnings[0], "regex location") {
+		t.Errorf("Warnings = %q, want only the regex location", im.Warnings)
+	}
+}
+
+func TestImportNginxErrors(t *testing.T) {
+	for _, conf := range []string{
+		"server { server_name example.com;",
+		"server { location / { proxy_pass http://web }",
+		"}",
+		`server { server_name "example.com; }`,
+	} {
+		if _, err := ImportNginx([]byte(conf)); err == nil {
+			t.Errorf("ImportNginx(%q) error = nil, want error", conf)
+		}
+	}
+}
+
+func checkImported(t *testing.T, im *Import, want []ImportedService) {
+	t.Helper()
+	if len(im.Services) != len(want) {
+		t.Fatalf("got %d services %+v, want %d", len(im.Services), im.Services, len(want))
+	}
+	for i, w := range want {
+		got := im.Services[i]
+		if got.Name != w.Name || !maps.Equal(got.Labels, w.Labels) {
+			t.Errorf("service %d = %s %v\nwant %s %v", i, got.Name, got.Labels, w.Name, w.Labels)
+		}
+	}
+}
+
+func TestImportCaddy(t *testing.T) {
+	yaml := `
+services:
+  web:
+    image: web
+    labels:
+      caddy: "example.com, www.example.com"
+      caddy.reverse_proxy: "{{upstreams 3000}}"
+  api:
+    image: api
+    labels:
+      caddy: "api.example.com"
+      caddy.handle_path: "/v1/*"
+      caddy.handle_path.0_reverse_proxy: "{{upstreams https 8443}}"
+      caddy.encode: "gzip"
+  docs:
+    image: docs
+    labels:
+      caddy: "example.com"
+      caddy.reverse_proxy: "/docs/* {{upstreams}}"
+  static:
+    image: static
+    labels:
+      caddy: "static.example.com"
+      caddy.file_server: ""
+`
+	im, err := ImportCaddy([]byte(yaml), "compose.yaml")
+	if err != nil {
+		t.Fatalf("ImportCaddy() error = %v", err)
+	}
+
+	want := []ImportedService{
+		{Name: "api", Labels: map[string]string{
+			LabelHost: "api.example.com", LabelPath: "/v1", LabelPort: "8443", LabelStripPrefix: "true",
+			LabelProtocol: SchemeHTTPS, LabelPassHost: "true",
+		}},
+		{Name: "docs", Labels: map[string]string{LabelHost: "example.com", LabelPath: "/docs", LabelPort: "80", LabelPassHost: "true"}},
+		{Name: "web", Labels: map[string]string{LabelHost: "example.com", LabelPort: "3000", LabelPassHost: "true", LabelWWW: WWWServe}},
+	}
+	checkImported(t, im, want)
+
+	if len(im.Warnings) != 3 {
+		t.Errorf("Warnings = %q, want encode, file_server and the skipped static service", im.Warnings)
+	}
+}
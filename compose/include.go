@@ -0,0 +1,139 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/compose-spec/compose-go/v2/template"
+	"go.yaml.in/yaml/v4"
+)
+
+// maxIncludeDepth bounds chains of x-liteproxy includes
+const maxIncludeDepth = 10
+
+// resolveBlock merges the files named by the x-liteproxy block's include
+// key under it, and expands ${VAR} references in its values from the
+// environment. Included files hold the same settings, either at the top
+// level or in their own x-liteproxy block, and are read relative to the
+// file including them. Settings in the including block win.
+func resolveBlock(block map[string]any, dir string) (map[string]any, error) {
+	resolved, err := mergeIncludes(block, dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range resolved {
+		if resolved[k], err = expandValue(v); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", ExtensionKey, k, err)
+		}
+	}
+	return resolved, nil
+}
+
+func mergeIncludes(block map[string]any, dir string, chain []string) (map[string]any, error) {
+	raw, ok := block["include"]
+	if !ok {
+		return block, nil
+	}
+	var paths []string
+	switch v := raw.(type) {
+	case string:
+		paths = []string{v}
+	case []any:
+		for _, p := range v {
+			s, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.include must be a path or a list of paths", ExtensionKey)
+			}
+			paths = append(paths, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s.include must be a path or a list of paths", ExtensionKey)
+	}
+
+	merged := make(map[string]any)
+	for _, p := range paths {
+		p, err := template.SubstituteWithOptions(p, os.LookupEnv, template.WithoutLogging)
+		if err != nil {
+			return nil, fmt.Errorf("%s.include: %w", ExtensionKey, err)
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		if slices.Contains(chain, p) {
+			return nil, fmt.Errorf("%s.include: %s includes itself", ExtensionKey, p)
+		}
+		if len(chain) == maxIncludeDepth {
+			return nil, fmt.Errorf("%s.include: more than %d levels deep", ExtensionKey, maxIncludeDepth)
+		}
+		included, err := readInclude(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s.include: %w", ExtensionKey, err)
+		}
+		included, err = mergeIncludes(included, filepath.Dir(p), append(chain, p))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range included {
+			merged[k] = v
+		}
+	}
+	for k, v := range block {
+		if k != "include" {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+// readInclude reads the settings in an included file
+func readInclude(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if nested, ok := doc[ExtensionKey]; ok {
+		block, ok := nested.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: %s must be a mapping", path, ExtensionKey)
+		}
+		return block, nil
+	}
+	if doc == nil {
+		doc = map[string]any{}
+	}
+	return doc, nil
+}
+
+// expandValue expands ${VAR}, ${VAR:-default} and ${VAR:?error} in the
+// strings of a setting, including those nested in lists and mappings
+func expandValue(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return template.SubstituteWithOptions(v, os.LookupEnv, template.WithoutLogging)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			if out[i], err = expandValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			var err error
+			if out[k], err = expandValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,77 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSettingsInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("shared/liteproxy.yaml", "backend_host: \"{service}.${DOMAIN}\"\n")
+	write("shared/compose.yaml", "x-liteproxy:\n  include: liteproxy.yaml\n")
+	write("shared/network.yaml", "network: ${NETWORK:-edge}\n")
+	write("loop-a.yaml", "include: loop-b.yaml\n")
+	write("loop-b.yaml", "include: loop-a.yaml\n")
+	t.Setenv("DOMAIN", "internal")
+	t.Setenv("SUFFIX", "prod")
+
+	tests := []struct {
+		name     string
+		block    string
+		wantHost string
+		wantErr  string
+	}{
+		{name: "expand", block: `backend_host: "{service}-${SUFFIX}"`, wantHost: "web-prod"},
+		{name: "include", block: `include: shared/liteproxy.yaml`, wantHost: "web.internal"},
+		{name: "include from a compose file", block: `include: [shared/compose.yaml]`, wantHost: "web.internal"},
+		{name: "default", block: `include: shared/network.yaml`, wantHost: "web.edge"},
+		{
+			name: "block wins",
+			block: `include: shared/liteproxy.yaml
+  backend_host: "{service}.local"`,
+			wantHost: "web.local",
+		},
+		{name: "missing file", block: `include: nope.yaml`, wantErr: "x-liteproxy.include"},
+		{name: "cycle", block: `include: loop-a.yaml`, wantErr: "includes itself"},
+		{name: "not a path", block: `include: 3`, wantErr: "x-liteproxy.include must be a path or a list of paths"},
+		{name: "required variable", block: `backend_host: "${UNSET_LITEPROXY_VAR:?set it}"`, wantErr: "set it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+x-liteproxy:
+  ` + tt.block + `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "80"
+`
+			routes, err := Parse([]byte(yaml), filepath.Join(dir, "compose.yaml"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := routes[0].DialHost(); got != tt.wantHost {
+				t.Errorf("DialHost() = %q, want %q", got, tt.wantHost)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package compose
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MarshalJSON writes durations as strings ("10s") rather than nanoseconds
+func (h HealthCheck) MarshalJSON() ([]byte, error) {
+	type plain HealthCheck
+	return json.Marshal(struct {
+		plain
+		Interval string `json:"interval"`
+		Timeout  string `json:"timeout"`
+	}{plain(h), h.Interval.String(), h.Timeout.String()})
+}
+
+// MarshalJSON writes durations as strings ("30s") rather than nanoseconds
+func (p PassiveCheck) MarshalJSON() ([]byte, error) {
+	type plain PassiveCheck
+	return json.Marshal(struct {
+		plain
+		FailTimeout string `json:"fail_timeout"`
+	}{plain(p), p.FailTimeout.String()})
+}
+
+// MarshalJSON writes the durations as strings ("30s") rather than nanoseconds
+func (t Timeout) MarshalJSON() ([]byte, error) {
+	type plain Timeout
+	return json.Marshal(struct {
+		plain
+		Duration string `json:"duration,omitempty"`
+		Headers  string `json:"headers,omitempty"`
+	}{plain(t), durationString(t.Duration), durationString(t.Headers)})
+}
+
+// durationString formats d, or returns "" for 0 so omitempty drops it
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// MarshalJSON writes the wait as a string ("30s") rather than nanoseconds
+func (s ScaledToZero) MarshalJSON() ([]byte, error) {
+	type plain ScaledToZero
+	return json.Marshal(struct {
+		plain
+		Wait string `json:"wait,omitempty"`
+	}{plain(s), durationString(s.Wait)})
+}
+
+// MarshalJSON writes the TTL as a string ("1m0s") rather than nanoseconds
+func (d DNS) MarshalJSON() ([]byte, error) {
+	type plain DNS
+	return json.Marshal(struct {
+		plain
+		TTL string `json:"ttl,omitempty"`
+	}{plain(d), durationString(d.TTL)})
+}
+
+// MarshalJSON writes the durations as strings ("100ms") rather than nanoseconds
+func (r Retry) MarshalJSON() ([]byte, error) {
+	type plain Retry
+	return json.Marshal(struct {
+		plain
+		Backoff string `json:"backoff"`
+		After   string `json:"after,omitempty"`
+	}{plain(r), r.Backoff.String(), durationString(r.After)})
+}
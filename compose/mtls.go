@@ -0,0 +1,72 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for authenticating clients with certificates
+const (
+	LabelMTLSCA      = "liteproxy.mtls.ca"
+	LabelMTLSRequire = "liteproxy.mtls.require"
+)
+
+// ClientAuth asks clients of a host for certificates during the TLS
+// handshake and verifies them against a CA bundle
+type ClientAuth struct {
+	CA      string `json:"ca"`      // CA bundle (PEM) client certificates must chain to
+	Require bool   `json:"require"` // reject clients without a certificate; false only verifies ones given
+}
+
+// extractClientAuth extracts client certificate settings, checking the CA
+// bundle loads so mistakes show up at startup rather than on the first
+// handshake
+func extractClientAuth(route *Route, labels types.Labels) error {
+	ca := labels[LabelMTLSCA]
+	if ca == "" {
+		if labels[LabelMTLSRequire] != "" {
+			return fmt.Errorf("%s requires %s", LabelMTLSRequire, LabelMTLSCA)
+		}
+		return nil
+	}
+	if route.Passthrough {
+		return fmt.Errorf("%s is not supported with %s", LabelMTLSCA, LabelPassthrough)
+	}
+	if _, err := LoadCertPool(ca); err != nil {
+		return fmt.Errorf("invalid %s: %w", LabelMTLSCA, err)
+	}
+
+	auth := &ClientAuth{CA: ca, Require: true}
+	if v := labels[LabelMTLSRequire]; v != "" {
+		require, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", LabelMTLSRequire, v)
+		}
+		auth.Require = require
+	}
+	route.ClientAuth = auth
+	return nil
+}
+
+// checkClientAuth makes sure routes sharing a host agree on client
+// certificates, since the handshake asks for them before any path is known
+func checkClientAuth(routes []Route) error {
+	byHost := make(map[string]*Route)
+	for i := range routes {
+		r := &routes[i]
+		if r.Host == "" {
+			continue
+		}
+		prev, ok := byHost[r.Host]
+		if !ok {
+			byHost[r.Host] = r
+			continue
+		}
+		if (prev.ClientAuth == nil) != (r.ClientAuth == nil) || (r.ClientAuth != nil && *prev.ClientAuth != *r.ClientAuth) {
+			return fmt.Errorf("%s and %s both serve %s with different %s settings", prev.ServiceName, r.ServiceName, r.Host, LabelMTLSCA)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "clients.crt")
+	writeSelfSigned(t, ca, filepath.Join(dir, "clients.key"))
+
+	tests := []struct {
+		name    string
+		labels  string
+		want    *ClientAuth
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "required by default", labels: `liteproxy.mtls.ca: "` + ca + `"`, want: &ClientAuth{CA: ca, Require: true}},
+		{
+			name: "optional",
+			labels: `liteproxy.mtls.ca: "` + ca + `"
+      liteproxy.mtls.require: "false"`,
+			want: &ClientAuth{CA: ca},
+		},
+		{name: "require without ca", labels: `liteproxy.mtls.require: "true"`, wantErr: "liteproxy.mtls.require requires liteproxy.mtls.ca"},
+		{name: "missing ca file", labels: `liteproxy.mtls.ca: "` + filepath.Join(dir, "nope.crt") + `"`, wantErr: "invalid liteproxy.mtls.ca"},
+		{
+			name: "invalid require",
+			labels: `liteproxy.mtls.ca: "` + ca + `"
+      liteproxy.mtls.require: "sometimes"`,
+			wantErr: `invalid liteproxy.mtls.require "sometimes"`,
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.mtls.ca: "` + ca + `"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].ClientAuth
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("ClientAuth = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilesClientAuthConflict(t *testing.T) {
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "clients.crt")
+	writeSelfSigned(t, ca, filepath.Join(dir, "clients.key"))
+	write := func(name, labels string) string {
+		path := filepath.Join(dir, name+".yaml")
+		os.WriteFile(path, []byte(`
+services:
+  `+name+`:
+    image: app
+    labels:
+      liteproxy.host: "app.example.com"
+      liteproxy.port: "80"
+      `+labels+`
+`), 0o644)
+		return path
+	}
+	secure := write("admin", `liteproxy.path: "/admin"
+      liteproxy.mtls.ca: "`+ca+`"`)
+	public := write("web", `liteproxy.path: "/"`)
+	alsoSecure := write("api", `liteproxy.path: "/api"
+      liteproxy.mtls.ca: "`+ca+`"`)
+
+	if _, err := ParseFiles([]string{secure, public}); err == nil || !strings.Contains(err.Error(), "admin and web both serve app.example.com with different liteproxy.mtls.ca settings") {
+		t.Errorf("ParseFiles() error = %v", err)
+	}
+	if _, err := ParseFiles([]string{secure, alsoSecure}); err != nil {
+		t.Errorf("matching settings: %v", err)
+	}
+}
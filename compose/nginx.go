@@ -0,0 +1,301 @@
+package compose
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/localrivet/liteproxy/balancer"
+)
+
+// nginxDirective is a parsed nginx directive with its arguments and block
+type nginxDirective struct {
+	name  string
+	args  []string
+	block []nginxDirective
+}
+
+// nginxUpstream is an upstream block: backend addresses and balancing
+type nginxUpstream struct {
+	servers []string
+	balance string
+}
+
+// ImportNginx translates simple nginx server blocks into liteproxy labels.
+// Each location with a proxy_pass becomes one service
+func ImportNginx(data []byte) (*Import, error) {
+	directives, err := parseNginx(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	im := &Import{}
+	upstreams := make(map[string]nginxUpstream)
+	var servers []nginxDirective
+	var walk func([]nginxDirective)
+	walk = func(ds []nginxDirective) {
+		for _, d := range ds {
+			switch d.name {
+			case "http":
+				walk(d.block)
+			case "upstream":
+				if len(d.args) == 1 {
+					upstreams[d.args[0]] = nginxUpstreamFrom(d, im)
+				}
+			case "server":
+				servers = append(servers, d)
+			}
+		}
+	}
+	walk(directives)
+
+	names := make(map[string]int)
+	for _, server := range servers {
+		for _, svc := range nginxServer(server, upstreams, im) {
+			names[svc.Name]++
+			if n := names[svc.Name]; n > 1 {
+				svc.Name += "-" + strconv.Itoa(n)
+			}
+			im.Services = append(im.Services, svc)
+		}
+	}
+	return im, nil
+}
+
+func nginxUpstreamFrom(d nginxDirective, im *Import) nginxUpstream {
+	var up nginxUpstream
+	for _, s := range d.block {
+		switch {
+		case s.name == "server" && len(s.args) > 0:
+			up.servers = append(up.servers, s.args[0])
+		case s.name == "hash" && len(s.args) > 0 && s.args[0] == "$request_uri":
+			up.balance = balancer.URLHash
+		case s.name == "keepalive":
+		default:
+			im.warnf("upstream %s: %s not translated", d.args[0], s.name)
+		}
+	}
+	return up
+}
+
+// nginxServer translates the proxy_pass locations of one server block
+func nginxServer(server nginxDirective, upstreams map[string]nginxUpstream, im *Import) []ImportedService {
+	var hosts []string
+	passHost, buffering := false, true
+	for _, d := range server.block {
+		switch d.name {
+		case "server_name":
+			for _, h := range d.args {
+				if h != "_" && h != "" {
+					hosts = append(hosts, h)
+				}
+			}
+		case "proxy_set_header":
+			passHost = passHost || nginxPassesHost(d)
+		case "proxy_buffering":
+			buffering = !slices.Contains(d.args, "off")
+		}
+	}
+	if len(hosts) == 0 {
+		// Catch-all servers usually just redirect to HTTPS
+		if slices.ContainsFunc(server.block, func(d nginxDirective) bool { return d.name == "location" }) {
+			im.warnf("server without server_name skipped")
+		}
+		return nil
+	}
+	host := hosts[0]
+
+	var services []ImportedService
+	for _, loc := range server.block {
+		if loc.name != "location" || len(loc.args) == 0 {
+			continue
+		}
+		svc, ok := nginxLocation(host, loc, passHost, buffering, upstreams, im)
+		if !ok {
+			continue
+		}
+		for _, h := range hosts[1:] {
+			if h == "www."+host {
+				svc.Labels[LabelWWW] = WWWServe
+			} else {
+				im.warnf("server %s: extra server_name %s not translated, add a separate service", host, h)
+			}
+		}
+		services = append(services, svc)
+	}
+	if len(services) == 0 {
+		im.warnf("server %s: no proxy_pass locations, skipped", host)
+	}
+	return services
+}
+
+// nginxLocation translates one location block, reporting false if it does
+// not proxy or cannot be expressed as a path prefix
+func nginxLocation(host string, loc nginxDirective, passHost, buffering bool, upstreams map[string]nginxUpstream, im *Import) (ImportedService, bool) {
+	path := loc.args[len(loc.args)-1]
+	if len(loc.args) > 1 {
+		switch loc.args[0] {
+		case "^~":
+		case "=":
+			im.warnf("server %s: exact location %s translated as a prefix", host, path)
+		default:
+			im.warnf("server %s: regex location %s %s skipped", host, loc.args[0], path)
+			return ImportedService{}, false
+		}
+	}
+
+	var target string
+	for _, d := range loc.block {
+		switch d.name {
+		case "proxy_pass":
+			if len(d.args) > 0 {
+				target = d.args[0]
+			}
+		case "proxy_set_header":
+			passHost = passHost || nginxPassesHost(d)
+		case "proxy_buffering":
+			buffering = !slices.Contains(d.args, "off")
+		}
+	}
+	if target == "" {
+		return ImportedService{}, false
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		im.warnf("server %s: proxy_pass %s not translated", host, target)
+		return ImportedService{}, false
+	}
+	if u.Scheme == "https" {
+		im.warnf("server %s: proxy_pass %s uses HTTPS, translated as plain HTTP", host, target)
+	}
+
+	labels := map[string]string{LabelHost: host}
+	if p := strings.TrimSuffix(path, "/"); p != "" {
+		labels[LabelPath] = p
+	}
+	name := u.Hostname()
+	port := u.Port()
+	if up, ok := upstreams[u.Host]; ok && len(up.servers) > 0 {
+		_, port, _ = net.SplitHostPort(up.servers[0])
+		labels[LabelBackends] = strings.Join(up.servers, ",")
+		if up.balance != "" {
+			labels[LabelBalance] = up.balance
+		}
+	}
+	if port == "" {
+		port = "80"
+	}
+	labels[LabelPort] = port
+
+	// A URI on proxy_pass replaces the matched location prefix
+	switch u.Path {
+	case "":
+	case "/":
+		if labels[LabelPath] != "" {
+			labels[LabelStripPrefix] = "true"
+		}
+	default:
+		im.warnf("server %s: proxy_pass URI %s not translated", host, u.Path)
+	}
+	if passHost {
+		labels[LabelPassHost] = "true"
+	}
+	if !buffering {
+		labels[LabelResponseBuffering] = "false"
+	}
+	return ImportedService{Name: name, Labels: labels}, true
+}
+
+// nginxPassesHost reports whether d forwards the client's Host header
+func nginxPassesHost(d nginxDirective) bool {
+	return len(d.args) == 2 && strings.EqualFold(d.args[0], "Host") && (d.args[1] == "$host" || d.args[1] == "$http_host")
+}
+
+// parseNginx parses nginx configuration into directives
+func parseNginx(src string) ([]nginxDirective, error) {
+	tokens, err := tokenizeNginx(src)
+	if err != nil {
+		return nil, err
+	}
+	ds, rest, err := parseNginxBlock(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("nginx config: unexpected %q", rest[0])
+	}
+	return ds, nil
+}
+
+func parseNginxBlock(tokens []string) ([]nginxDirective, []string, error) {
+	var ds []nginxDirective
+	for len(tokens) > 0 {
+		if tokens[0] == "}" {
+			return ds, tokens, nil
+		}
+		d := nginxDirective{name: tokens[0]}
+		tokens = tokens[1:]
+		for {
+			if len(tokens) == 0 {
+				return nil, nil, fmt.Errorf("nginx config: %s: unexpected end of file", d.name)
+			}
+			tok := tokens[0]
+			tokens = tokens[1:]
+			if tok == ";" {
+				break
+			}
+			if tok == "{" {
+				block, rest, err := parseNginxBlock(tokens)
+				if err != nil {
+					return nil, nil, err
+				}
+				if len(rest) == 0 {
+					return nil, nil, fmt.Errorf("nginx config: %s: missing }", d.name)
+				}
+				d.block, tokens = block, rest[1:]
+				break
+			}
+			d.args = append(d.args, tok)
+		}
+		ds = append(ds, d)
+	}
+	return ds, nil, nil
+}
+
+// tokenizeNginx splits nginx configuration into words, quoted strings and
+// the punctuation ; { }, dropping comments
+func tokenizeNginx(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == ';' || c == '{' || c == '}':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("nginx config: unterminated string")
+			}
+			tokens = append(tokens, src[i+1:i+1+end])
+			i += end + 2
+		default:
+			start := i
+			for i < len(src) && !unicode.IsSpace(rune(src[i])) && !strings.ContainsRune(";{}", rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, src[start:i])
+		}
+	}
+	return tokens, nil
+}
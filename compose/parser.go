@@ -1,82 +1,446 @@
 package compose
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"math"
+	"net"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/compose-spec/compose-go/v2/loader"
 	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/localrivet/liteproxy/balancer"
+	"github.com/localrivet/liteproxy/dialer"
+	"github.com/localrivet/liteproxy/flags"
 )
 
 const (
-	LabelHost        = "liteproxy.host"
-	LabelPort        = "liteproxy.port"
-	LabelPortHTTP    = "liteproxy.port.http"
-	LabelPath        = "liteproxy.path"
+	LabelHost         = "liteproxy.host"
+	LabelPort         = "liteproxy.port"
+	LabelPortHTTP     = "liteproxy.port.http"
+	LabelPath         = "liteproxy.path"
+	LabelPriority     = "liteproxy.priority"
 	LabelRedirectFrom = "liteproxy.redirect_from"
-	LabelPassHost    = "liteproxy.passhost"
-	LabelStripPrefix = "liteproxy.strip_prefix"
-	LabelPassthrough = "liteproxy.passthrough"
+	LabelPassHost     = "liteproxy.passhost"
+	LabelStripPrefix  = "liteproxy.strip_prefix"
+	LabelPassthrough  = "liteproxy.passthrough"
+	LabelBackends     = "liteproxy.backends"
+	LabelUpstreams    = "liteproxy.upstreams" // alias for liteproxy.backends
+	LabelDiscovery    = "liteproxy.discovery"
+	LabelBalance      = "liteproxy.balance"
+	LabelSticky       = "liteproxy.sticky"
+	LabelStickyCookie = "liteproxy.sticky_cookie"
+	LabelWWW          = "liteproxy.www"
+	LabelRobots       = "liteproxy.robots"
+	LabelSecurityTxt  = "liteproxy.security_txt"
+
+	LabelUpstreamEncoding  = "liteproxy.upstream_encoding"
+	LabelCompress          = "liteproxy.compress"
+	LabelCompressMinSize   = "liteproxy.compress.min_size"
+	LabelResponseBuffering = "liteproxy.response_buffering"
+	LabelCopyBufferSize    = "liteproxy.copy_buffer_size"
+	LabelUpstreamProtocol  = "liteproxy.upstream_protocol"
+	LabelProtocol          = "liteproxy.protocol"
+	LabelTimeout           = "liteproxy.timeout"
+	LabelTimeoutResponse   = "liteproxy.timeout_response"
+	LabelHeaderTimeout     = "liteproxy.response_header_timeout"
+	LabelRetries           = "liteproxy.retries"
+	LabelRetryBackoff      = "liteproxy.retry_backoff"
+	LabelRetryAfter        = "liteproxy.retry_after"
+	LabelClientConcurrency = "liteproxy.client_concurrency"
+	LabelDirectPaths       = "liteproxy.direct_paths"
+	LabelDial              = "liteproxy.dial"
+	LabelProxyProtocol     = "liteproxy.proxy_protocol"
+	LabelFlags             = "liteproxy.flags"
+	LabelRouteHeader       = "liteproxy.route_header"
+
+	LabelHealthType     = "liteproxy.healthcheck.type"
+	LabelHealthPath     = "liteproxy.healthcheck.path"
+	LabelHealthService  = "liteproxy.healthcheck.service"
+	LabelHealthInterval = "liteproxy.healthcheck.interval"
+	LabelHealthTimeout  = "liteproxy.healthcheck.timeout"
+
+	LabelHealthMaxFails    = "liteproxy.healthcheck.max_fails"
+	LabelHealthFailTimeout = "liteproxy.healthcheck.fail_timeout"
+)
+
+// Values for the liteproxy.www label
+const (
+	WWWRedirect = "redirect" // 301 www.host to host
+	WWWServe    = "serve"    // serve www.host from the same route
 )
 
+// Values for the liteproxy.discovery label
+const (
+	DiscoveryStatic = "static" // proxy to the configured names, resolved per connection
+	DiscoveryDNS    = "dns"    // resolve every A record and balance across them
+)
+
+// Values for the liteproxy.upstream_encoding label
+const (
+	EncodingPassthrough = "passthrough" // forward the client's Accept-Encoding untouched
+	EncodingIdentity    = "identity"    // ask the backend for uncompressed responses
+)
+
+// Values for the liteproxy.upstream_protocol label
+const (
+	ProtocolAuto  = "auto"  // HTTP/2 when the backend negotiates it, HTTP/1.1 otherwise
+	ProtocolHTTP1 = "http1" // always HTTP/1.1
+)
+
+// Values for the liteproxy.protocol label
+const (
+	SchemeHTTP  = "http"  // plain HTTP (default)
+	SchemeH2C   = "h2c"   // HTTP/2 over cleartext, for gRPC backends
+	SchemeHTTPS = "https" // TLS, verified against the system roots unless upstream_tls.ca is set
+)
+
+// DefaultCompressMinSize is the smallest response compressed; below it the
+// encoding's framing costs more than it saves
+const DefaultCompressMinSize = 1 << 10
+
+// Encodings for liteproxy.compress, as named in Content-Encoding
+const (
+	CompressBrotli = "br"
+	CompressGzip   = "gzip"
+)
+
+// Limits for liteproxy.copy_buffer_size
+const (
+	MinCopyBufferSize = 4 << 10
+	MaxCopyBufferSize = 16 << 20
+)
+
+// RobotsDisallow is the liteproxy.robots shorthand that blocks all crawlers
+const RobotsDisallow = "disallow"
+
+// robotsDisallowAll is served for liteproxy.robots: "disallow"
+const robotsDisallowAll = "User-agent: *\nDisallow: /\n"
+
+// Health check probe types
+const (
+	HealthHTTP = "http"
+	HealthTCP  = "tcp"
+	HealthGRPC = "grpc"
+)
+
+// Health check defaults
+const (
+	DefaultHealthInterval = 10 * time.Second
+	DefaultHealthTimeout  = 2 * time.Second
+	DefaultFailTimeout    = 30 * time.Second
+)
+
+// DefaultRetryBackoff is the wait before the first retry; it doubles after each
+const DefaultRetryBackoff = 100 * time.Millisecond
+
+// DefaultStickyCookie names the session affinity cookie unless liteproxy.sticky_cookie is set
+const DefaultStickyCookie = "lp_srv"
+
 // Route represents a single routing rule extracted from compose labels
 type Route struct {
-	Host           string
-	PathPrefix     string
-	ServiceName    string
-	ServicePort    int
-	HTTPPort       int  // Optional: separate port for HTTP passthrough (for ACME challenges)
-	PassHostHeader bool
-	StripPrefix    bool
-	RedirectFrom   []string
-	Passthrough    bool // Forward raw TCP without terminating TLS or processing HTTP
+	Host              string            `json:"host"`
+	PathPrefix        string            `json:"path"`
+	PathExact         string            `json:"path_exact,omitempty"` // Optional: the only path matched, instead of PathPrefix
+	PathRegex         string            `json:"path_regex,omitempty"` // Optional: regular expression paths must match, instead of PathPrefix
+	ServiceName       string            `json:"service"`
+	ServicePort       int               `json:"port"`
+	Project           string            `json:"project,omitempty"`         // Compose project the route was defined in
+	Tenant            string            `json:"tenant,omitempty"`          // Tenant the route was generated for (see LabelTenants)
+	TenantsFile       string            `json:"tenants_file,omitempty"`    // Tenants file the route was generated from
+	TenantResolver    string            `json:"tenant_resolver,omitempty"` // Optional: lookup file or URL mapping each subdomain to a backend
+	TenantBackends    map[string]string `json:"tenant_backends,omitempty"` // Tenant → host:port, loaded from a TenantResolver file
+	BackendHost       string            `json:"backend_host,omitempty"`    // Optional: host to dial instead of ServiceName (from x-liteproxy.backend_host)
+	HTTPPort          int               `json:"http_port,omitempty"`       // Optional: separate port for HTTP passthrough (for ACME challenges)
+	TCPPort           int               `json:"tcp_port,omitempty"`        // Optional: host port forwarded to the service as raw TCP (forward routes have no host)
+	UDPPort           int               `json:"udp_port,omitempty"`        // Optional: host port whose datagrams are relayed to the service
+	PassHostHeader    bool              `json:"passhost,omitempty"`
+	StripPrefix       bool              `json:"strip_prefix,omitempty"`
+	RedirectFrom      []string          `json:"redirect_from,omitempty"`
+	Aliases           []string          `json:"aliases,omitempty"`            // Additional hosts served by the route (e.g. www. variant)
+	Robots            string            `json:"robots,omitempty"`             // Optional: robots.txt served by the proxy for the host
+	SecurityTxt       string            `json:"security_txt,omitempty"`       // Optional: /.well-known/security.txt served by the proxy for the host
+	UpstreamEncoding  string            `json:"upstream_encoding,omitempty"`  // Accept-Encoding handling towards the backend (passthrough, identity)
+	Compress          *Compress         `json:"compress,omitempty"`           // Optional: compress responses the backend sent uncompressed
+	FlushImmediately  bool              `json:"flush_immediately,omitempty"`  // Write response data to the client as soon as it arrives
+	CopyBufferSize    int               `json:"copy_buffer_size,omitempty"`   // Optional: bytes per proxy copy buffer (0 = default 32KB)
+	UpstreamProtocol  string            `json:"upstream_protocol,omitempty"`  // HTTP version towards the backend (auto, http1)
+	Protocol          string            `json:"protocol,omitempty"`           // How the backend is spoken to (http, h2c, https)
+	Passthrough       bool              `json:"passthrough,omitempty"`        // Forward raw TCP without terminating TLS or processing HTTP
+	ProxyProtocol     int               `json:"proxy_protocol,omitempty"`     // PROXY protocol version sent to passthrough backends (1 or 2, 0 = none)
+	ForwardedFor      bool              `json:"forwarded_for,omitempty"`      // Add X-Forwarded-For to plain HTTP requests on passthrough connections
+	Preset            string            `json:"preset,omitempty"`             // Passthrough preset (mqtt, amqp, postgres, minecraft, tls) routed on SNIPorts
+	SNIPorts          []int             `json:"sni_ports,omitempty"`          // Host ports of a passthrough preset, shared by SNI with other routes
+	IdleTimeout       time.Duration     `json:"idle_timeout,omitempty"`       // Close passthrough connections with no data either way for this long (0 = never)
+	Postgres          *PostgresMatch    `json:"postgres,omitempty"`           // Optional: databases and users a postgres passthrough route serves without TLS
+	Backends          []string          `json:"backends,omitempty"`           // Optional: explicit backend addresses (host:port) to balance across
+	Balance           string            `json:"balance,omitempty"`            // Balancing strategy across Backends (round_robin, url_hash, least_conn)
+	StickyCookie      string            `json:"sticky_cookie,omitempty"`      // Optional: cookie pinning each client to one backend (session affinity)
+	Regions           *Regions          `json:"regions,omitempty"`            // Optional: backend per client region, with failover between regions
+	Canary            *Canary           `json:"canary,omitempty"`             // Optional: share of requests sent to another service
+	Discovery         string            `json:"discovery,omitempty"`          // How backend addresses are found (static, dns)
+	DNS               *DNS              `json:"dns,omitempty"`                // Optional: lookup interval and pinning of last good addresses for backend names
+	Dial              string            `json:"dial,omitempty"`               // Optional: dialer for backends on overlays or behind tunnels (see package dialer)
+	UpstreamTLS       *UpstreamTLS      `json:"upstream_tls,omitempty"`       // Optional: HTTPS to the backend, with a client certificate for mTLS
+	ClientAuth        *ClientAuth       `json:"client_auth,omitempty"`        // Optional: client certificates verified during the TLS handshake
+	CORS              *CORS             `json:"cors,omitempty"`               // Optional: cross-origin policy answered by the proxy
+	Scan              *Scan             `json:"scan,omitempty"`               // Optional: content scanner that must pass request bodies
+	WAF               *WAF              `json:"waf,omitempty"`                // Optional: request inspection rules
+	DLP               *DLP              `json:"dlp,omitempty"`                // Optional: sensitive data patterns masked or blocked in responses
+	Timeout           *Timeout          `json:"timeout,omitempty"`            // Optional: end-to-end deadline for each request
+	Streams           *Streams          `json:"streams,omitempty"`            // Optional: caps and idle timeout for WebSocket and event stream connections
+	Retry             *Retry            `json:"retry,omitempty"`              // Optional: retry requests the backend refused to connect
+	Fault             *Fault            `json:"fault,omitempty"`              // Optional: latency and errors injected for chaos testing
+	ScaledToZero      *ScaledToZero     `json:"scaled_to_zero,omitempty"`     // Optional: what to answer while the service has no running containers
+	ClientConcurrency int               `json:"client_concurrency,omitempty"` // Optional: in-flight requests allowed per client IP (0 = proxy default)
+	Priority          int               `json:"priority,omitempty"`           // Rank among the host's routes, higher first, ahead of path length (default 0)
+	DirectPaths       []string          `json:"direct_paths,omitempty"`       // Paths (or /prefix/*) proxied without edge policies, e.g. platform health checks
+	HTTPSRedirect     *HTTPSRedirect    `json:"https_redirect,omitempty"`     // Optional: plain HTTP paths and redirect status (default: 301 everything)
+	HSTS              *HSTS             `json:"hsts,omitempty"`               // Optional: Strict-Transport-Security added to HTTPS responses
+	Flags             []string          `json:"flags,omitempty"`              // Optional: feature flags sent to the backend as X-Flag-* headers ("*" for all)
+	RouteHeader       bool              `json:"route_header,omitempty"`       // Send X-Liteproxy-Route describing the matched route to the backend
+	RequestHeaders    *HeaderRules      `json:"request_headers,omitempty"`    // Optional: header changes on requests to the backend
+	ResponseHeaders   *HeaderRules      `json:"response_headers,omitempty"`   // Optional: header changes on responses to the client
+	HealthCheck       *HealthCheck      `json:"healthcheck,omitempty"`        // Optional: active health check for the route's backends
+	PassiveCheck      *PassiveCheck     `json:"passive_check,omitempty"`      // Optional: eject backends based on live traffic failures
+}
+
+// HealthCheck describes how to probe a route's backends
+type HealthCheck struct {
+	Type     string        `json:"type"`              // http, tcp or grpc
+	Path     string        `json:"path,omitempty"`    // HTTP path to GET (http only)
+	Service  string        `json:"service,omitempty"` // Service name for grpc.health.v1 (grpc only, empty = whole server)
+	Interval time.Duration `json:"interval"`          // Time between probes
+	Timeout  time.Duration `json:"timeout"`           // Per-probe timeout
+}
+
+// Timeout bounds a proxied request: the whole exchange, connecting,
+// waiting for the response headers and copying the body, and optionally
+// the wait for the headers alone
+type Timeout struct {
+	Duration time.Duration `json:"duration,omitempty"` // Deadline for the whole exchange (0 = none)
+	Headers  time.Duration `json:"headers,omitempty"`  // Deadline for the response headers of each attempt (0 = none)
+	Response string        `json:"response,omitempty"` // Body of the 504 sent when a deadline passes before the response starts
+}
+
+// Retry describes how requests are retried when the backend can't be
+// connected to, or answers 503 with a Retry-After. Only idempotent requests
+// whose body can be sent again are retried.
+type Retry struct {
+	Attempts int           `json:"attempts"` // retries after the first try
+	Backoff  time.Duration `json:"backoff"`  // wait before the first retry, doubling after each
+
+	After time.Duration `json:"after,omitempty"` // longest Retry-After waited out once on a 503 (0 = never)
+}
+
+// Compress describes on-the-fly response compression
+type Compress struct {
+	Encodings []string `json:"encodings"` // offered to clients, preferred first
+	MinSize   int      `json:"min_size"`  // smaller responses are sent as they are
+}
+
+// PassiveCheck describes when live traffic failures eject a backend
+type PassiveCheck struct {
+	MaxFails    int           `json:"max_fails"`    // Consecutive failures (5xx, dial errors, timeouts) before ejecting
+	FailTimeout time.Duration `json:"fail_timeout"` // How long to wait before probing an ejected backend for recovery
+}
+
+// DialHost returns the host to dial for the route's service
+func (r *Route) DialHost() string {
+	if r.BackendHost != "" {
+		return r.BackendHost
+	}
+	return r.ServiceName
+}
+
+// IsDirect reports whether requests for path skip edge policies such as
+// per-client limits and the HTTPS redirect
+func (r *Route) IsDirect(path string) bool {
+	return matchPaths(r.DirectPaths, path)
+}
+
+// matchPaths reports whether path is one of paths, which end in * to
+// match a prefix
+func matchPaths(paths []string, path string) bool {
+	for _, p := range paths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Addrs returns the backend addresses for the route
+// Routes without explicit backends proxy to the service itself
+func (r *Route) Addrs() []string {
+	if len(r.Backends) > 0 {
+		return r.Backends
+	}
+	return []string{net.JoinHostPort(r.DialHost(), strconv.Itoa(r.ServicePort))}
 }
 
 // ParseFile reads a compose file and extracts routes from labeled services
+// Site files (see IsSiteFile) are parsed with ParseSiteFile instead
 func ParseFile(path string) ([]Route, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading compose file: %w", err)
 	}
+	return parseData(data, path)
+}
 
+// parseData parses the contents of the compose or site file at path
+func parseData(data []byte, path string) ([]Route, error) {
+	if IsSiteFile(path) {
+		return ParseSiteFile(data, path)
+	}
 	return Parse(data, path)
 }
 
 // Parse parses compose yaml data and extracts routes from labeled services
 func Parse(data []byte, filename string) ([]Route, error) {
-	config := types.ConfigDetails{
-		ConfigFiles: []types.ConfigFile{
-			{
-				Filename: filename,
-				Content:  data,
-			},
-		},
+	project, err := loadProject(data, filename)
+	if err != nil {
+		return nil, err
 	}
 
-	project, err := loader.LoadWithContext(context.Background(), config, func(options *loader.Options) {
-		options.SkipInterpolation = true
-	}, loader.WithSkipValidation)
+	settings, err := parseSettings(project, filepath.Dir(filename))
 	if err != nil {
-		return nil, fmt.Errorf("parsing compose file: %w", err)
+		return nil, err
 	}
+	name := projectName(project, filename)
 
 	var routes []Route
 	for _, service := range project.Services {
+		if len(settings.Compat) > 0 {
+			service.Labels = compatLabels(service, settings.Compat, filename)
+		}
 		route, err := extractRoute(service)
 		if err != nil {
 			return nil, fmt.Errorf("service %s: %w", service.Name, err)
 		}
 		if route != nil {
-			routes = append(routes, *route)
+			route.Project = name
+			if route.BackendHost, err = settings.dialHost(project, name, service, service.Name); err != nil {
+				return nil, fmt.Errorf("service %s: %w", service.Name, err)
+			}
+			if err := extractTenantResolver(route, service.Labels, filepath.Dir(filename)); err != nil {
+				return nil, fmt.Errorf("service %s: %w", service.Name, err)
+			}
+			if route.Canary != nil {
+				if route.TenantResolver != "" {
+					return nil, fmt.Errorf("service %s: %s is not supported with %s", service.Name, LabelCanaryService, LabelTenantResolver)
+				}
+				if route.Canary.Host, err = settings.dialHost(project, name, service, route.Canary.Service); err != nil {
+					return nil, fmt.Errorf("service %s: %w", service.Name, err)
+				}
+			}
+			expanded, err := expandTenants(*route, service.Labels, filepath.Dir(filename))
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", service.Name, err)
+			}
+			routes = append(routes, expanded...)
 		}
 	}
 
 	return routes, nil
 }
 
+// ParseFiles parses several compose files, each as its own project, and
+// returns their routes combined. Use x-liteproxy.backend_host in each file
+// so identically named services in different projects dial different hosts.
+func ParseFiles(paths []string) ([]Route, error) {
+	return ParseFilesWith(paths, "", nil)
+}
+
+// ParseFilesWith parses paths like ParseFiles, but with data in place of
+// the contents of the file at path, which is added if it isn't among them.
+// It checks a candidate file against the rest of the configuration.
+func ParseFilesWith(paths []string, path string, data []byte) ([]Route, error) {
+	if path != "" && !slices.Contains(paths, path) {
+		paths = append(slices.Clip(paths), path)
+	}
+	var routes []Route
+	for _, p := range paths {
+		var (
+			fileRoutes []Route
+			err        error
+		)
+		if p == path {
+			fileRoutes, err = parseData(data, p)
+		} else {
+			fileRoutes, err = ParseFile(p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		routes = append(routes, fileRoutes...)
+	}
+	if err := checkForwardPorts(routes); err != nil {
+		return nil, err
+	}
+	if err := checkClientAuth(routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// DirFiles lists the compose files (*.yaml, *.yml) and site files in dir,
+// sorted by name so routes load in a predictable order
+func DirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading compose directory: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ext := filepath.Ext(name); ext == ".yaml" || ext == ".yml" || IsSiteFile(name) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files, nil
+}
+
+// loadProject loads compose yaml data without interpolation or validation
+func loadProject(data []byte, filename string) (*types.Project, error) {
+	config := types.ConfigDetails{
+		ConfigFiles: []types.ConfigFile{
+			{
+				Filename: filename,
+				Content:  data,
+			},
+		},
+	}
+
+	project, err := loader.LoadWithContext(context.Background(), config, func(options *loader.Options) {
+		options.SkipInterpolation = true
+	}, loader.WithSkipValidation)
+	if err != nil {
+		return nil, fmt.Errorf("parsing compose file: %w", err)
+	}
+	return project, nil
+}
+
+// RouteFromLabels extracts a Route from liteproxy labels found outside a
+// compose file, such as Kubernetes annotations. service names the backend.
+func RouteFromLabels(service string, labels map[string]string) (*Route, error) {
+	return extractRoute(types.ServiceConfig{Name: service, Labels: labels})
+}
+
 // extractRoute extracts a Route from service labels, returns nil if no liteproxy labels
 func extractRoute(service types.ServiceConfig) (*Route, error) {
 	labels := service.Labels
@@ -84,6 +448,11 @@ func extractRoute(service types.ServiceConfig) (*Route, error) {
 	host := labels[LabelHost]
 	portStr := labels[LabelPort]
 
+	// Non-HTTP services exposed on their own host ports
+	if labels[LabelTCPPort] != "" || labels[LabelUDPPort] != "" {
+		return extractForward(service)
+	}
+
 	// No liteproxy labels = not proxied
 	if host == "" && portStr == "" {
 		return nil, nil
@@ -134,9 +503,122 @@ func extractRoute(service types.ServiceConfig) (*Route, error) {
 		route.RedirectFrom = domains
 	}
 
+	// Optional: www (pair the www. variant with the apex host)
+	if www := labels[LabelWWW]; www != "" {
+		if strings.HasPrefix(host, "*.") || strings.HasPrefix(host, "www.") {
+			return nil, fmt.Errorf("%s requires an apex host, got %q", LabelWWW, host)
+		}
+		wwwHost := "www." + host
+		switch www {
+		case WWWRedirect:
+			if !slices.Contains(route.RedirectFrom, wwwHost) {
+				route.RedirectFrom = append(route.RedirectFrom, wwwHost)
+			}
+		case WWWServe:
+			route.Aliases = append(route.Aliases, wwwHost)
+		default:
+			return nil, fmt.Errorf("invalid www %q (want %q or %q)", www, WWWRedirect, WWWServe)
+		}
+	}
+
+	// Optional: robots.txt ("disallow" or the file contents)
+	if robots := labels[LabelRobots]; robots != "" {
+		if robots == RobotsDisallow {
+			robots = robotsDisallowAll
+		}
+		route.Robots = withTrailingNewline(robots)
+	}
+
+	// Optional: security.txt contents
+	if securityTxt := labels[LabelSecurityTxt]; securityTxt != "" {
+		route.SecurityTxt = withTrailingNewline(securityTxt)
+	}
+
+	// Optional: upstream_encoding (what the backend may compress with)
+	if encoding := labels[LabelUpstreamEncoding]; encoding != "" {
+		if encoding != EncodingPassthrough && encoding != EncodingIdentity {
+			return nil, fmt.Errorf("invalid upstream_encoding %q (want %q or %q)", encoding, EncodingPassthrough, EncodingIdentity)
+		}
+		route.UpstreamEncoding = encoding
+	}
+
+	// Optional: compress responses the backend didn't
+	var encodings []string
+	switch compress := labels[LabelCompress]; compress {
+	case "true":
+		// Brotli compresses text smaller, for clients that take both
+		encodings = []string{CompressBrotli, CompressGzip}
+	case "gzip":
+		encodings = []string{CompressGzip}
+	case "br", "brotli":
+		encodings = []string{CompressBrotli}
+	case "", "false":
+		if labels[LabelCompressMinSize] != "" {
+			return nil, fmt.Errorf("%s requires %s", LabelCompressMinSize, LabelCompress)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want true, gzip or br)", LabelCompress, compress)
+	}
+	if encodings != nil {
+		route.Compress = &Compress{Encodings: encodings, MinSize: DefaultCompressMinSize}
+		if size := labels[LabelCompressMinSize]; size != "" {
+			n, err := parseSize(size)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", LabelCompressMinSize, size)
+			}
+			route.Compress.MinSize = n
+		}
+	}
+
+	// Optional: response_buffering=false flushes every write to the client
+	if buffering := labels[LabelResponseBuffering]; buffering != "" {
+		route.FlushImmediately = buffering == "false"
+	}
+
+	// Optional: copy_buffer_size (e.g. 256KB, 1MB) for large downloads
+	if size := labels[LabelCopyBufferSize]; size != "" {
+		n, err := parseSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid copy_buffer_size %q: %w", size, err)
+		}
+		if n < MinCopyBufferSize || n > MaxCopyBufferSize {
+			return nil, fmt.Errorf("copy_buffer_size %q out of range (4KB to 16MB)", size)
+		}
+		route.CopyBufferSize = n
+	}
+
+	// Optional: upstream_protocol (pin backends that mishandle HTTP/2)
+	if protocol := labels[LabelUpstreamProtocol]; protocol != "" {
+		if protocol != ProtocolAuto && protocol != ProtocolHTTP1 {
+			return nil, fmt.Errorf("invalid upstream_protocol %q (want %q or %q)", protocol, ProtocolAuto, ProtocolHTTP1)
+		}
+		route.UpstreamProtocol = protocol
+	}
+
+	// Optional: protocol (h2c for gRPC backends, https for TLS ones)
+	if protocol := labels[LabelProtocol]; protocol != "" {
+		if protocol != SchemeHTTP && protocol != SchemeH2C && protocol != SchemeHTTPS {
+			return nil, fmt.Errorf("invalid protocol %q (want %q, %q or %q)", protocol, SchemeHTTP, SchemeH2C, SchemeHTTPS)
+		}
+		if protocol == SchemeH2C && route.UpstreamProtocol == ProtocolHTTP1 {
+			return nil, fmt.Errorf("%s %q is not supported with %s %q", LabelUpstreamProtocol, ProtocolHTTP1, LabelProtocol, SchemeH2C)
+		}
+		route.Protocol = protocol
+	}
+
 	// Optional: passthrough (forward raw TCP to backend)
-	if passthrough := labels[LabelPassthrough]; passthrough != "" {
-		route.Passthrough = passthrough == "true"
+	if err := extractPassthrough(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: exact or regular expression path instead of a prefix
+	if err := extractPathMatch(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: injected latency and errors for chaos testing
+	if err := extractFault(route, labels); err != nil {
+		return nil, err
 	}
 
 	// Optional: http_port for passthrough (separate port for HTTP/ACME challenges)
@@ -148,5 +630,437 @@ func extractRoute(service types.ServiceConfig) (*Route, error) {
 		route.HTTPPort = httpPort
 	}
 
+	// Optional: tell passthrough backends the client's address
+	switch v := labels[LabelProxyProtocol]; v {
+	case "", "false":
+	case "true", "v2":
+		route.ProxyProtocol = 2
+	case "v1":
+		route.ProxyProtocol = 1
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want true, v1 or v2)", LabelProxyProtocol, v)
+	}
+	if route.ProxyProtocol != 0 && !route.Passthrough {
+		return nil, fmt.Errorf("%s requires %s", LabelProxyProtocol, LabelPassthrough)
+	}
+
+	// Optional: backends (comma-separated, port defaults to liteproxy.port)
+	if labels[LabelBackends] != "" && labels[LabelUpstreams] != "" {
+		return nil, fmt.Errorf("%s and %s are aliases, set only one", LabelBackends, LabelUpstreams)
+	}
+	if backends := cmp.Or(labels[LabelBackends], labels[LabelUpstreams]); backends != "" {
+		for _, b := range strings.Split(backends, ",") {
+			b = strings.TrimSpace(b)
+			if b == "" {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(b); err != nil {
+				b = net.JoinHostPort(b, strconv.Itoa(port))
+			}
+			route.Backends = append(route.Backends, b)
+		}
+	}
+
+	// Optional: balance strategy across backends
+	if balance := labels[LabelBalance]; balance != "" {
+		if !balancer.Valid(balance) {
+			return nil, fmt.Errorf("invalid balance %q", balance)
+		}
+		route.Balance = balance
+	}
+
+	// Optional: session affinity, pinning clients to a backend with a cookie
+	switch sticky := labels[LabelSticky]; sticky {
+	case "true":
+		route.StickyCookie = cmp.Or(labels[LabelStickyCookie], DefaultStickyCookie)
+		if !validCookieName(route.StickyCookie) {
+			return nil, fmt.Errorf("invalid %s %q", LabelStickyCookie, route.StickyCookie)
+		}
+		if route.Passthrough {
+			return nil, fmt.Errorf("%s is not supported with %s", LabelSticky, LabelPassthrough)
+		}
+	case "", "false":
+		if labels[LabelStickyCookie] != "" {
+			return nil, fmt.Errorf("%s requires %s", LabelStickyCookie, LabelSticky)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want true or false)", LabelSticky, sticky)
+	}
+
+	// Optional: backend discovery, DNS by default for replicated services
+	// since Docker's DNS returns one A record per replica
+	switch discovery := labels[LabelDiscovery]; discovery {
+	case "":
+		if replicas(service) > 1 {
+			route.Discovery = DiscoveryDNS
+		}
+	case DiscoveryStatic, DiscoveryDNS:
+		route.Discovery = discovery
+	default:
+		return nil, fmt.Errorf("invalid %s %q", LabelDiscovery, discovery)
+	}
+
+	// Optional: a backend per region, picked by a request header
+	if err := extractRegions(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: canary split with another service
+	if err := extractCanary(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: end-to-end request timeout, and one for the response headers
+	if timeout, headers := labels[LabelTimeout], labels[LabelHeaderTimeout]; timeout != "" || headers != "" {
+		route.Timeout = &Timeout{Response: labels[LabelTimeoutResponse]}
+		if timeout != "" {
+			d, err := time.ParseDuration(timeout)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", LabelTimeout, timeout)
+			}
+			route.Timeout.Duration = d
+		}
+		if headers != "" {
+			d, err := time.ParseDuration(headers)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", LabelHeaderTimeout, headers)
+			}
+			route.Timeout.Headers = d
+		}
+	} else if labels[LabelTimeoutResponse] != "" {
+		return nil, fmt.Errorf("%s requires %s or %s", LabelTimeoutResponse, LabelTimeout, LabelHeaderTimeout)
+	}
+
+	// Optional: limits on long-lived connections, which the timeout skips
+	if err := extractStreams(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: retries on connection failures
+	if n := labels[LabelRetries]; n != "" {
+		attempts, err := strconv.Atoi(n)
+		if err != nil || attempts < 0 {
+			return nil, fmt.Errorf("invalid %s %q (want a number)", LabelRetries, n)
+		}
+		backoff := DefaultRetryBackoff
+		if b := labels[LabelRetryBackoff]; b != "" {
+			backoff, err = time.ParseDuration(b)
+			if err != nil || backoff <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", LabelRetryBackoff, b)
+			}
+		}
+		if attempts > 0 {
+			route.Retry = &Retry{Attempts: attempts, Backoff: backoff}
+		}
+	} else if labels[LabelRetryBackoff] != "" {
+		return nil, fmt.Errorf("%s requires %s", LabelRetryBackoff, LabelRetries)
+	}
+	if v := labels[LabelRetryAfter]; v != "" {
+		after, err := time.ParseDuration(v)
+		if err != nil || after <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", LabelRetryAfter, v)
+		}
+		if route.Retry == nil {
+			route.Retry = &Retry{Backoff: DefaultRetryBackoff}
+		}
+		route.Retry.After = after
+	}
+
+	// Optional: per-client concurrent request cap
+	if n := labels[LabelClientConcurrency]; n != "" {
+		limit, err := strconv.Atoi(n)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid %s %q (want a positive number)", LabelClientConcurrency, n)
+		}
+		route.ClientConcurrency = limit
+	}
+
+	// Optional: explicit rank among the host's routes
+	if p := labels[LabelPriority]; p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q (want a whole number)", LabelPriority, p)
+		}
+		route.Priority = priority
+	}
+
+	// Optional: paths that bypass edge policies
+	if paths := labels[LabelDirectPaths]; paths != "" {
+		for _, p := range strings.Split(paths, ",") {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "/") || strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+				return nil, fmt.Errorf("invalid %s entry %q (want /path or /prefix/*)", LabelDirectPaths, p)
+			}
+			route.DirectPaths = append(route.DirectPaths, p)
+		}
+	}
+
+	// Optional: plain HTTP exemptions, redirect status and HSTS
+	if err := extractHTTPSRedirect(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: feature flags evaluated for each request
+	if names := labels[LabelFlags]; names != "" {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name != "*" && !flags.ValidName(name) {
+				return nil, fmt.Errorf("invalid %s entry %q (want a flag name or *)", LabelFlags, name)
+			}
+			route.Flags = append(route.Flags, name)
+		}
+		if route.Passthrough {
+			return nil, fmt.Errorf("%s is not supported with %s", LabelFlags, LabelPassthrough)
+		}
+	}
+
+	// Optional: tell the backend which route delivered the request
+	switch v := labels[LabelRouteHeader]; v {
+	case "", "false":
+	case "true":
+		if route.Passthrough {
+			return nil, fmt.Errorf("%s is not supported with %s", LabelRouteHeader, LabelPassthrough)
+		}
+		route.RouteHeader = true
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want true or false)", LabelRouteHeader, v)
+	}
+
+	// Optional: header manipulation
+	route.RequestHeaders, route.ResponseHeaders, err = extractHeaders(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Optional: active health check
+	healthCheck, err := extractHealthCheck(labels)
+	if err != nil {
+		return nil, err
+	}
+	route.HealthCheck = healthCheck
+
+	// Optional: passive health check
+	passiveCheck, err := extractPassiveCheck(labels)
+	if err != nil {
+		return nil, err
+	}
+	route.PassiveCheck = passiveCheck
+
+	// Optional: dial backends through an overlay network or tunnel
+	if dial := labels[LabelDial]; dial != "" {
+		if _, err := dialer.New(dial); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", LabelDial, err)
+		}
+		// These connect to backends themselves, bypassing the dialer
+		switch {
+		case route.Passthrough:
+			return nil, fmt.Errorf("%s is not supported with %s", LabelDial, LabelPassthrough)
+		case route.Discovery == DiscoveryDNS:
+			return nil, fmt.Errorf("%s is not supported with %s %q", LabelDial, LabelDiscovery, DiscoveryDNS)
+		case route.HealthCheck != nil || route.PassiveCheck != nil:
+			return nil, fmt.Errorf("%s is not supported with health checks", LabelDial)
+		}
+		route.Dial = dial
+	}
+
+	// Optional: refresh interval and pinning for flapping backend DNS
+	if err := extractDNS(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: answer for a service scaled to zero instead of dial errors
+	if err := extractScaledToZero(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: HTTPS (and mTLS) to the backend
+	route.UpstreamTLS, err = extractUpstreamTLS(labels)
+	if err != nil {
+		return nil, err
+	}
+	if route.UpstreamTLS != nil && route.Protocol != "" && route.Protocol != SchemeHTTPS {
+		return nil, fmt.Errorf("upstream TLS requires %s %q", LabelProtocol, SchemeHTTPS)
+	}
+	if route.UsesUpstreamTLS() && route.HealthCheck != nil && route.HealthCheck.Type != HealthTCP {
+		return nil, fmt.Errorf("upstream TLS requires %s %q", LabelHealthType, HealthTCP)
+	}
+	if route.Protocol == SchemeH2C && route.HealthCheck != nil && route.HealthCheck.Type == HealthHTTP {
+		return nil, fmt.Errorf("%s %q requires %s %q or %q", LabelProtocol, SchemeH2C, LabelHealthType, HealthGRPC, HealthTCP)
+	}
+	if route.Protocol != "" && route.Passthrough {
+		return nil, fmt.Errorf("%s is not supported with %s", LabelProtocol, LabelPassthrough)
+	}
+
+	// Optional: client certificates (mTLS from the client)
+	if err := extractClientAuth(route, labels); err != nil {
+		return nil, err
+	}
+
+	// Optional: CORS for backends that don't implement it
+	if err := extractCORS(route, labels); err != nil {
+		return nil, err
+	}
+
+	if route.Compress != nil && route.Passthrough {
+		return nil, fmt.Errorf("%s is not supported with %s", LabelCompress, LabelPassthrough)
+	}
+
+	// Optional: request inspection rules
+	route.WAF, err = extractWAF(labels)
+	if err != nil {
+		return nil, err
+	}
+	if route.WAF != nil && route.Passthrough {
+		return nil, fmt.Errorf("%s is not supported with %s", LabelWAF, LabelPassthrough)
+	}
+
+	// Optional: scan uploads before forwarding them
+	route.Scan, err = extractScan(labels)
+	if err != nil {
+		return nil, err
+	}
+	if route.Scan != nil && route.Passthrough {
+		return nil, fmt.Errorf("%s is not supported with %s", LabelScan, LabelPassthrough)
+	}
+
+	// Optional: scan responses for sensitive data
+	route.DLP, err = extractDLP(labels)
+	if err != nil {
+		return nil, err
+	}
+	if route.DLP != nil && route.Passthrough {
+		return nil, fmt.Errorf("%s is not supported with %s", LabelDLP, LabelPassthrough)
+	}
+
 	return route, nil
 }
+
+// validCookieName reports whether name is usable as a cookie name
+func validCookieName(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// replicas returns the number of containers compose runs for the service
+func replicas(service types.ServiceConfig) int {
+	if service.Deploy != nil && service.Deploy.Replicas != nil {
+		return *service.Deploy.Replicas
+	}
+	if service.Scale != nil {
+		return *service.Scale
+	}
+	return 1
+}
+
+// parseSize parses a byte count with an optional KB/MB/GB suffix (powers of 1024)
+func parseSize(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1
+	for _, unit := range []struct {
+		suffix string
+		mult   int
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.mult
+			break
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size")
+	}
+	if n > math.MaxInt/multiplier {
+		return 0, fmt.Errorf("size too large")
+	}
+	return n * multiplier, nil
+}
+
+func withTrailingNewline(s string) string {
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return s
+}
+
+// extractHealthCheck extracts health check settings, returns nil if none are configured
+func extractHealthCheck(labels types.Labels) (*HealthCheck, error) {
+	hc := &HealthCheck{
+		Type:     labels[LabelHealthType],
+		Path:     labels[LabelHealthPath],
+		Service:  labels[LabelHealthService],
+		Interval: DefaultHealthInterval,
+		Timeout:  DefaultHealthTimeout,
+	}
+
+	if hc.Type == "" {
+		// A path alone implies an HTTP check
+		if hc.Path == "" {
+			return nil, nil
+		}
+		hc.Type = HealthHTTP
+	}
+
+	switch hc.Type {
+	case HealthHTTP:
+		if hc.Path == "" {
+			hc.Path = "/"
+		}
+	case HealthTCP, HealthGRPC:
+	default:
+		return nil, fmt.Errorf("invalid healthcheck type %q", hc.Type)
+	}
+
+	if interval := labels[LabelHealthInterval]; interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid healthcheck interval %q", interval)
+		}
+		hc.Interval = d
+	}
+
+	if timeout := labels[LabelHealthTimeout]; timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid healthcheck timeout %q", timeout)
+		}
+		hc.Timeout = d
+	}
+
+	return hc, nil
+}
+
+// extractPassiveCheck extracts passive health check settings, returns nil if max_fails is not set
+func extractPassiveCheck(labels types.Labels) (*PassiveCheck, error) {
+	maxFailsStr := labels[LabelHealthMaxFails]
+	if maxFailsStr == "" {
+		return nil, nil
+	}
+
+	maxFails, err := strconv.Atoi(maxFailsStr)
+	if err != nil || maxFails < 1 {
+		return nil, fmt.Errorf("invalid healthcheck max_fails %q", maxFailsStr)
+	}
+
+	pc := &PassiveCheck{
+		MaxFails:    maxFails,
+		FailTimeout: DefaultFailTimeout,
+	}
+
+	if failTimeout := labels[LabelHealthFailTimeout]; failTimeout != "" {
+		d, err := time.ParseDuration(failTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid healthcheck fail_timeout %q", failTimeout)
+		}
+		pc.FailTimeout = d
+	}
+
+	return pc, nil
+}
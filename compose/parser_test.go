@@ -1,7 +1,13 @@
 package compose
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParse(t *testing.T) {
@@ -223,3 +229,1278 @@ services:
 		t.Errorf("ServiceName = %q, want %q", routes[0].ServiceName, "my-awesome-service")
 	}
 }
+
+func TestParseBackends(t *testing.T) {
+	yaml := `
+services:
+  cache:
+    image: varnish
+    labels:
+      liteproxy.host: "cdn.example.com"
+      liteproxy.port: "6081"
+      liteproxy.backends: "varnish-1, varnish-2:6082"
+      liteproxy.balance: "url_hash"
+`
+	routes, err := Parse([]byte(yaml), "test.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(routes) != 1 {
+		t.Fatalf("expected 1 route, got %d", len(routes))
+	}
+
+	r := routes[0]
+	want := []string{"varnish-1:6081", "varnish-2:6082"}
+	if len(r.Backends) != len(want) {
+		t.Fatalf("Backends = %v, want %v", r.Backends, want)
+	}
+	for i := range want {
+		if r.Backends[i] != want[i] {
+			t.Errorf("Backends[%d] = %q, want %q", i, r.Backends[i], want[i])
+		}
+	}
+	if r.Balance != "url_hash" {
+		t.Errorf("Balance = %q, want %q", r.Balance, "url_hash")
+	}
+	if addrs := r.Addrs(); len(addrs) != 2 {
+		t.Errorf("Addrs() = %v, want backends", addrs)
+	}
+}
+
+func TestParseInvalidBalance(t *testing.T) {
+	yaml := `
+services:
+  cache:
+    image: varnish
+    labels:
+      liteproxy.host: "cdn.example.com"
+      liteproxy.port: "6081"
+      liteproxy.balance: "random"
+`
+	if _, err := Parse([]byte(yaml), "test.yaml"); err == nil {
+		t.Error("Parse() expected error for invalid balance")
+	}
+}
+
+func TestParseDiscovery(t *testing.T) {
+	tests := []struct {
+		name          string
+		service       string
+		wantDiscovery string
+		wantBackends  []string
+		wantErr       bool
+	}{
+		{
+			name: "upstreams alias",
+			service: `
+    labels:
+      liteproxy.upstreams: "app-1, app-2:9000"`,
+			wantBackends: []string{"app-1:8080", "app-2:9000"},
+		},
+		{
+			name: "backends and upstreams both set",
+			service: `
+    labels:
+      liteproxy.backends: "app-1"
+      liteproxy.upstreams: "app-2"`,
+			wantErr: true,
+		},
+		{
+			name: "replicas default to dns",
+			service: `
+    deploy:
+      replicas: 3`,
+			wantDiscovery: DiscoveryDNS,
+		},
+		{
+			name: "scale defaults to dns",
+			service: `
+    scale: 2`,
+			wantDiscovery: DiscoveryDNS,
+		},
+		{
+			name: "static overrides replicas",
+			service: `
+    deploy:
+      replicas: 3
+    labels:
+      liteproxy.discovery: "static"`,
+			wantDiscovery: DiscoveryStatic,
+		},
+		{
+			name: "invalid discovery",
+			service: `
+    labels:
+      liteproxy.discovery: "consul"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  app:
+    image: app` + tt.service + `
+`
+			if !strings.Contains(tt.service, "labels:") {
+				yaml += "    labels:\n"
+			}
+			yaml += `      liteproxy.host: "example.com"
+      liteproxy.port: "8080"
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Parse() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			r := routes[0]
+			if r.Discovery != tt.wantDiscovery {
+				t.Errorf("Discovery = %q, want %q", r.Discovery, tt.wantDiscovery)
+			}
+			if !slices.Equal(r.Backends, tt.wantBackends) {
+				t.Errorf("Backends = %v, want %v", r.Backends, tt.wantBackends)
+			}
+		})
+	}
+}
+
+func TestRouteAddrsDefault(t *testing.T) {
+	r := Route{ServiceName: "web", ServicePort: 8080}
+	addrs := r.Addrs()
+	if len(addrs) != 1 || addrs[0] != "web:8080" {
+		t.Errorf("Addrs() = %v, want [web:8080]", addrs)
+	}
+}
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *HealthCheck
+		wantErr bool
+	}{
+		{
+			name:   "no health check",
+			labels: ``,
+			want:   nil,
+		},
+		{
+			name: "grpc check with service",
+			labels: `
+      liteproxy.healthcheck.type: "grpc"
+      liteproxy.healthcheck.service: "orders.v1"
+      liteproxy.healthcheck.interval: "5s"`,
+			want: &HealthCheck{Type: "grpc", Service: "orders.v1", Interval: 5 * time.Second, Timeout: DefaultHealthTimeout},
+		},
+		{
+			name: "path implies http",
+			labels: `
+      liteproxy.healthcheck.path: "/healthz"`,
+			want: &HealthCheck{Type: "http", Path: "/healthz", Interval: DefaultHealthInterval, Timeout: DefaultHealthTimeout},
+		},
+		{
+			name: "http defaults to root path",
+			labels: `
+      liteproxy.healthcheck.type: "http"
+      liteproxy.healthcheck.timeout: "500ms"`,
+			want: &HealthCheck{Type: "http", Path: "/", Interval: DefaultHealthInterval, Timeout: 500 * time.Millisecond},
+		},
+		{
+			name: "invalid type",
+			labels: `
+      liteproxy.healthcheck.type: "icmp"`,
+			wantErr: true,
+		},
+		{
+			name: "invalid interval",
+			labels: `
+      liteproxy.healthcheck.type: "tcp"
+      liteproxy.healthcheck.interval: "often"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "9090"` + tt.labels + "\n"
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := routes[0].HealthCheck
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("HealthCheck = %+v, want %+v", got, tt.want)
+			}
+			if got != nil && *got != *tt.want {
+				t.Errorf("HealthCheck = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePassiveCheck(t *testing.T) {
+	yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "8080"
+      liteproxy.healthcheck.max_fails: "5"
+      liteproxy.healthcheck.fail_timeout: "10s"
+`
+	routes, err := Parse([]byte(yaml), "test.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	pc := routes[0].PassiveCheck
+	if pc == nil {
+		t.Fatal("PassiveCheck = nil, want set")
+	}
+	if pc.MaxFails != 5 {
+		t.Errorf("MaxFails = %d, want 5", pc.MaxFails)
+	}
+	if pc.FailTimeout != 10*time.Second {
+		t.Errorf("FailTimeout = %v, want 10s", pc.FailTimeout)
+	}
+	if routes[0].HealthCheck != nil {
+		t.Error("HealthCheck set, want nil (passive checks do not need active checks)")
+	}
+
+	bad := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "8080"
+      liteproxy.healthcheck.max_fails: "0"
+`
+	if _, err := Parse([]byte(bad), "test.yaml"); err == nil {
+		t.Error("Parse() expected error for max_fails 0")
+	}
+}
+
+func TestParseWWW(t *testing.T) {
+	tests := []struct {
+		name         string
+		host         string
+		www          string
+		wantRedirect []string
+		wantAliases  []string
+		wantErr      bool
+	}{
+		{name: "redirect", host: "example.com", www: "redirect", wantRedirect: []string{"www.example.com"}},
+		{name: "serve", host: "example.com", www: "serve", wantAliases: []string{"www.example.com"}},
+		{name: "invalid value", host: "example.com", www: "both", wantErr: true},
+		{name: "wildcard host", host: "*.example.com", www: "redirect", wantErr: true},
+		{name: "already www", host: "www.example.com", www: "serve", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "` + tt.host + `"
+      liteproxy.port: "80"
+      liteproxy.www: "` + tt.www + `"
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			r := routes[0]
+			if !slices.Equal(r.RedirectFrom, tt.wantRedirect) {
+				t.Errorf("RedirectFrom = %v, want %v", r.RedirectFrom, tt.wantRedirect)
+			}
+			if !slices.Equal(r.Aliases, tt.wantAliases) {
+				t.Errorf("Aliases = %v, want %v", r.Aliases, tt.wantAliases)
+			}
+		})
+	}
+}
+
+func TestParseWWWRedirectNoDuplicate(t *testing.T) {
+	yaml := `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "80"
+      liteproxy.redirect_from: "www.example.com,old.example.com"
+      liteproxy.www: "redirect"
+`
+	routes, err := Parse([]byte(yaml), "test.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(routes[0].RedirectFrom) != 2 {
+		t.Errorf("RedirectFrom = %v, want www.example.com listed once", routes[0].RedirectFrom)
+	}
+}
+
+func TestParseRobotsAndSecurityTxt(t *testing.T) {
+	yaml := `
+services:
+  staging:
+    image: nginx
+    labels:
+      liteproxy.host: "staging.example.com"
+      liteproxy.port: "80"
+      liteproxy.robots: "disallow"
+      liteproxy.security_txt: |
+        Contact: mailto:security@example.com
+        Expires: 2030-01-01T00:00:00Z
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "80"
+      liteproxy.robots: "User-agent: *\nDisallow: /admin"
+`
+	routes, err := Parse([]byte(yaml), "test.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := map[string][2]string{
+		"staging.example.com": {
+			"User-agent: *\nDisallow: /\n",
+			"Contact: mailto:security@example.com\nExpires: 2030-01-01T00:00:00Z\n",
+		},
+		"example.com": {"User-agent: *\nDisallow: /admin\n", ""},
+	}
+	for _, r := range routes {
+		w := want[r.Host]
+		if r.Robots != w[0] {
+			t.Errorf("%s: Robots = %q, want %q", r.Host, r.Robots, w[0])
+		}
+		if r.SecurityTxt != w[1] {
+			t.Errorf("%s: SecurityTxt = %q, want %q", r.Host, r.SecurityTxt, w[1])
+		}
+	}
+}
+
+func TestParseUpstreamEncoding(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: "identity", want: EncodingIdentity},
+		{value: "passthrough", want: EncodingPassthrough},
+		{value: "gzip", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		yaml := `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "80"
+      liteproxy.upstream_encoding: "` + tt.value + `"
+`
+		routes, err := Parse([]byte(yaml), "test.yaml")
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if !tt.wantErr && routes[0].UpstreamEncoding != tt.want {
+			t.Errorf("UpstreamEncoding = %q, want %q", routes[0].UpstreamEncoding, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{in: "65536", want: 65536},
+		{in: "256KB", want: 256 << 10},
+		{in: "1MB", want: 1 << 20},
+		{in: "2m", want: 2 << 20},
+		{in: "512 kb", want: 512 << 10},
+		{in: "1.5MB", wantErr: true},
+		{in: "-1KB", wantErr: true},
+		{in: "big", wantErr: true},
+		{in: "9223372036854775807GB", wantErr: true},
+		{in: "17179869184GB", wantErr: true}, // wraps to 0 on 64-bit
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseLargeFileOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    string
+		wantFlush bool
+		wantSize  int
+		wantErr   bool
+	}{
+		{name: "defaults"},
+		{name: "unbuffered", labels: `liteproxy.response_buffering: "false"`, wantFlush: true},
+		{name: "buffered", labels: `liteproxy.response_buffering: "true"`},
+		{name: "buffer size", labels: `liteproxy.copy_buffer_size: "1MB"`, wantSize: 1 << 20},
+		{name: "buffer too small", labels: `liteproxy.copy_buffer_size: "1KB"`, wantErr: true},
+		{name: "buffer too large", labels: `liteproxy.copy_buffer_size: "64MB"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  artifacts:
+    image: nginx
+    labels:
+      liteproxy.host: "downloads.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if routes[0].FlushImmediately != tt.wantFlush {
+				t.Errorf("FlushImmediately = %v, want %v", routes[0].FlushImmediately, tt.wantFlush)
+			}
+			if routes[0].CopyBufferSize != tt.wantSize {
+				t.Errorf("CopyBufferSize = %d, want %d", routes[0].CopyBufferSize, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestParseUpstreamProtocol(t *testing.T) {
+	for value, wantErr := range map[string]bool{"auto": false, "http1": false, "h3": true} {
+		yaml := `
+services:
+  legacy:
+    image: app
+    labels:
+      liteproxy.host: "legacy.example.com"
+      liteproxy.port: "8080"
+      liteproxy.upstream_protocol: "` + value + `"
+`
+		routes, err := Parse([]byte(yaml), "test.yaml")
+		if (err != nil) != wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", value, err, wantErr)
+			continue
+		}
+		if !wantErr && routes[0].UpstreamProtocol != value {
+			t.Errorf("UpstreamProtocol = %q, want %q", routes[0].UpstreamProtocol, value)
+		}
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    string
+		wantErr string
+	}{
+		{name: "default"},
+		{name: "h2c", labels: `liteproxy.protocol: "h2c"`, want: SchemeH2C},
+		{name: "https", labels: `liteproxy.protocol: "https"`, want: SchemeHTTPS},
+		{
+			name: "h2c with grpc health check",
+			labels: `liteproxy.protocol: "h2c"
+      liteproxy.healthcheck.type: "grpc"`,
+			want: SchemeH2C,
+		},
+		{name: "invalid", labels: `liteproxy.protocol: "grpc"`, wantErr: `invalid protocol "grpc"`},
+		{
+			name: "h2c pinned to http1",
+			labels: `liteproxy.protocol: "h2c"
+      liteproxy.upstream_protocol: "http1"`,
+			wantErr: `liteproxy.upstream_protocol "http1" is not supported with liteproxy.protocol "h2c"`,
+		},
+		{
+			name: "h2c with http health check",
+			labels: `liteproxy.protocol: "h2c"
+      liteproxy.healthcheck.path: "/health"`,
+			wantErr: `liteproxy.protocol "h2c" requires liteproxy.healthcheck.type "grpc" or "tcp"`,
+		},
+		{
+			name: "https with http health check",
+			labels: `liteproxy.protocol: "https"
+      liteproxy.healthcheck.path: "/health"`,
+			wantErr: `upstream TLS requires liteproxy.healthcheck.type "tcp"`,
+		},
+		{
+			name: "h2c with upstream tls",
+			labels: `liteproxy.protocol: "h2c"
+      liteproxy.upstream_tls.spiffe_id: "spiffe://example.org"`,
+			wantErr: `upstream TLS requires liteproxy.protocol "https"`,
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.protocol: "h2c"
+      liteproxy.passthrough: "true"`,
+			wantErr: "liteproxy.protocol is not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "50051"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if routes[0].Protocol != tt.want {
+				t.Errorf("Protocol = %q, want %q", routes[0].Protocol, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Timeout
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "timeout", labels: `liteproxy.timeout: "30s"`, want: &Timeout{Duration: 30 * time.Second}},
+		{
+			name: "custom response",
+			labels: `liteproxy.timeout: "2m"
+      liteproxy.timeout_response: "Report generation took too long"`,
+			want: &Timeout{Duration: 2 * time.Minute, Response: "Report generation took too long"},
+		},
+		{name: "header timeout", labels: `liteproxy.response_header_timeout: "5s"`, want: &Timeout{Headers: 5 * time.Second}},
+		{
+			name: "both",
+			labels: `liteproxy.timeout: "2m"
+      liteproxy.response_header_timeout: "10s"
+      liteproxy.timeout_response: "slow"`,
+			want: &Timeout{Duration: 2 * time.Minute, Headers: 10 * time.Second, Response: "slow"},
+		},
+		{name: "invalid", labels: `liteproxy.timeout: "soon"`, wantErr: true},
+		{name: "zero", labels: `liteproxy.timeout: "0s"`, wantErr: true},
+		{name: "response without timeout", labels: `liteproxy.timeout_response: "slow"`, wantErr: true},
+		{name: "invalid header timeout", labels: `liteproxy.response_header_timeout: "soon"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  reports:
+    image: reports
+    labels:
+      liteproxy.host: "reports.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := routes[0].Timeout
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Timeout = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Retry
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "retries", labels: `liteproxy.retries: "3"`, want: &Retry{Attempts: 3, Backoff: DefaultRetryBackoff}},
+		{
+			name: "backoff",
+			labels: `liteproxy.retries: "2"
+      liteproxy.retry_backoff: "250ms"`,
+			want: &Retry{Attempts: 2, Backoff: 250 * time.Millisecond},
+		},
+		{name: "zero", labels: `liteproxy.retries: "0"`},
+		{name: "negative", labels: `liteproxy.retries: "-1"`, wantErr: true},
+		{
+			name: "zero backoff",
+			labels: `liteproxy.retries: "2"
+      liteproxy.retry_backoff: "0s"`,
+			wantErr: true,
+		},
+		{name: "backoff without retries", labels: `liteproxy.retry_backoff: "1s"`, wantErr: true},
+		{name: "retry after", labels: `liteproxy.retry_after: "5s"`, want: &Retry{Backoff: DefaultRetryBackoff, After: 5 * time.Second}},
+		{
+			name: "retries and retry after",
+			labels: `liteproxy.retries: "2"
+      liteproxy.retry_after: "2s"`,
+			want: &Retry{Attempts: 2, Backoff: DefaultRetryBackoff, After: 2 * time.Second},
+		},
+		{name: "invalid retry after", labels: `liteproxy.retry_after: "soon"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := routes[0].Retry
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Retry = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDial(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    string
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "interface", labels: `liteproxy.dial: "iface://wg0"`, want: "iface://wg0"},
+		{name: "socks5", labels: `liteproxy.dial: "socks5://127.0.0.1:1055"`, want: "socks5://127.0.0.1:1055"},
+		{name: "unknown", labels: `liteproxy.dial: "carrier-pigeon://loft"`, wantErr: "unknown dialer"},
+		{
+			name: "health check",
+			labels: `liteproxy.dial: "iface://wg0"
+      liteproxy.healthcheck.path: "/healthz"`,
+			wantErr: "not supported with health checks",
+		},
+		{
+			name: "dns discovery",
+			labels: `liteproxy.dial: "iface://wg0"
+      liteproxy.discovery: "dns"`,
+			wantErr: "not supported with liteproxy.discovery",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := routes[0].Dial; got != tt.want {
+				t.Errorf("Dial = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxyProtocol(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    int
+		wantErr bool
+	}{
+		{name: "none", labels: `liteproxy.passthrough: "true"`},
+		{name: "true", labels: "liteproxy.passthrough: \"true\"\n      liteproxy.proxy_protocol: \"true\"", want: 2},
+		{name: "v1", labels: "liteproxy.passthrough: \"true\"\n      liteproxy.proxy_protocol: \"v1\"", want: 1},
+		{name: "v3", labels: "liteproxy.passthrough: \"true\"\n      liteproxy.proxy_protocol: \"v3\"", wantErr: true},
+		{name: "without passthrough", labels: `liteproxy.proxy_protocol: "true"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  postgres:
+    image: postgres
+    labels:
+      liteproxy.host: "db.example.com"
+      liteproxy.port: "5432"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && routes[0].ProxyProtocol != tt.want {
+				t.Errorf("ProxyProtocol = %d, want %d", routes[0].ProxyProtocol, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClientConcurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    int
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "limit", labels: `liteproxy.client_concurrency: "20"`, want: 20},
+		{name: "zero", labels: `liteproxy.client_concurrency: "0"`, wantErr: true},
+		{name: "invalid", labels: `liteproxy.client_concurrency: "lots"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].ClientConcurrency; got != tt.want {
+				t.Errorf("ClientConcurrency = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    int
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "high", labels: `liteproxy.priority: "10"`, want: 10},
+		{name: "negative", labels: `liteproxy.priority: "-5"`, want: -5},
+		{name: "invalid", labels: `liteproxy.priority: "first"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].Priority; got != tt.want {
+				t.Errorf("Priority = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePathMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    string
+		wantExact string
+		wantRegex string
+		wantPath  string
+		wantErr   string
+	}{
+		{name: "prefix", labels: `liteproxy.path: "/api"`, wantPath: "/api"},
+		{name: "exact", labels: `liteproxy.path_exact: "/healthz"`, wantExact: "/healthz", wantPath: "=/healthz"},
+		{name: "regex", labels: `liteproxy.path_regex: "^/v[0-9]+/"`, wantRegex: "^/v[0-9]+/", wantPath: "~^/v[0-9]+/"},
+		{name: "exact without slash", labels: `liteproxy.path_exact: "healthz"`, wantErr: `invalid liteproxy.path_exact "healthz"`},
+		{name: "invalid regex", labels: `liteproxy.path_regex: "^/v[0-9+/"`, wantErr: `invalid liteproxy.path_regex "^/v[0-9+/"`},
+		{
+			name: "exact with prefix",
+			labels: `liteproxy.path: "/api"
+      liteproxy.path_exact: "/api"`,
+			wantErr: "liteproxy.path_exact is not supported with liteproxy.path",
+		},
+		{
+			name: "exact with regex",
+			labels: `liteproxy.path_exact: "/api"
+      liteproxy.path_regex: "^/api"`,
+			wantErr: "liteproxy.path_exact is not supported with liteproxy.path_regex",
+		},
+		{
+			name: "regex with strip_prefix",
+			labels: `liteproxy.path_regex: "^/api"
+      liteproxy.strip_prefix: "true"`,
+			wantErr: "liteproxy.strip_prefix is not supported with liteproxy.path_regex",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			r := routes[0]
+			if r.PathExact != tt.wantExact || r.PathRegex != tt.wantRegex || r.Path() != tt.wantPath {
+				t.Errorf("route = exact %q regex %q Path() %q, want %q %q %q",
+					r.PathExact, r.PathRegex, r.Path(), tt.wantExact, tt.wantRegex, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestParseCompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Compress
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "enabled", labels: `liteproxy.compress: "true"`, want: &Compress{Encodings: []string{"br", "gzip"}, MinSize: DefaultCompressMinSize}},
+		{name: "gzip", labels: `liteproxy.compress: "gzip"`, want: &Compress{Encodings: []string{"gzip"}, MinSize: DefaultCompressMinSize}},
+		{name: "brotli", labels: `liteproxy.compress: "br"`, want: &Compress{Encodings: []string{"br"}, MinSize: DefaultCompressMinSize}},
+		{name: "brotli by name", labels: `liteproxy.compress: "brotli"`, want: &Compress{Encodings: []string{"br"}, MinSize: DefaultCompressMinSize}},
+		{name: "min size", labels: "liteproxy.compress: \"true\"\n      liteproxy.compress.min_size: \"4KB\"", want: &Compress{Encodings: []string{"br", "gzip"}, MinSize: 4 << 10}},
+		{name: "disabled", labels: `liteproxy.compress: "false"`},
+		{name: "invalid", labels: `liteproxy.compress: "zstd"`, wantErr: true},
+		{name: "min size without compress", labels: `liteproxy.compress.min_size: "4KB"`, wantErr: true},
+		{name: "passthrough", labels: "liteproxy.compress: \"true\"\n      liteproxy.passthrough: \"true\"", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  app:
+    image: app
+    labels:
+      liteproxy.host: "app.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].Compress; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compress = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSticky(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "default cookie", labels: `liteproxy.sticky: "true"`, want: DefaultStickyCookie},
+		{name: "custom cookie", labels: "liteproxy.sticky: \"true\"\n      liteproxy.sticky_cookie: \"app_srv\"", want: "app_srv"},
+		{name: "disabled", labels: `liteproxy.sticky: "false"`},
+		{name: "cookie without sticky", labels: `liteproxy.sticky_cookie: "app_srv"`, wantErr: true},
+		{name: "invalid cookie name", labels: "liteproxy.sticky: \"true\"\n      liteproxy.sticky_cookie: \"a;b\"", wantErr: true},
+		{name: "invalid value", labels: `liteproxy.sticky: "yes"`, wantErr: true},
+		{name: "passthrough", labels: "liteproxy.sticky: \"true\"\n      liteproxy.passthrough: \"true\"", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  app:
+    image: app
+    labels:
+      liteproxy.host: "app.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].StickyCookie; got != tt.want {
+				t.Errorf("StickyCookie = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDirectPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "paths", labels: `liteproxy.direct_paths: "/healthz, /health/*"`, want: []string{"/healthz", "/health/*"}},
+		{name: "relative", labels: `liteproxy.direct_paths: "healthz"`, wantErr: true},
+		{name: "inner wildcard", labels: `liteproxy.direct_paths: "/*/health"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].DirectPaths; !slices.Equal(got, tt.want) {
+				t.Errorf("DirectPaths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "names", labels: `liteproxy.flags: "new-checkout, dark_mode"`, want: []string{"new-checkout", "dark_mode"}},
+		{name: "all", labels: `liteproxy.flags: "*"`, want: []string{"*"}},
+		{name: "invalid name", labels: `liteproxy.flags: "new checkout"`, wantErr: true},
+		{name: "passthrough", labels: "liteproxy.flags: \"*\"\n      liteproxy.passthrough: \"true\"", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].Flags; !slices.Equal(got, tt.want) {
+				t.Errorf("Flags = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRouteHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "on", labels: `liteproxy.route_header: "true"`, want: true},
+		{name: "off", labels: `liteproxy.route_header: "false"`},
+		{name: "invalid", labels: `liteproxy.route_header: "yes"`, wantErr: true},
+		{name: "passthrough", labels: "liteproxy.route_header: \"true\"\n      liteproxy.passthrough: \"true\"", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].RouteHeader; got != tt.want {
+				t.Errorf("RouteHeader = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteIsDirect(t *testing.T) {
+	r := Route{DirectPaths: []string{"/healthz", "/health/*"}}
+	tests := map[string]bool{
+		"/healthz":      true,
+		"/healthz/deep": false,
+		"/health/ready": true,
+		"/health/":      true,
+		"/health":       false,
+		"/api":          false,
+	}
+	for path, want := range tests {
+		if got := r.IsDirect(path); got != want {
+			t.Errorf("IsDirect(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		labels       string
+		wantRequest  *HeaderRules
+		wantResponse *HeaderRules
+		wantErr      bool
+	}{
+		{name: "none"},
+		{
+			name: "request and response",
+			labels: `liteproxy.headers.request.set.x-tenant: "acme"
+      liteproxy.headers.request.remove: "Cookie"
+      liteproxy.headers.response.set.Strict-Transport-Security: "max-age=31536000"
+      liteproxy.headers.response.add.Vary: "Origin"
+      liteproxy.headers.response.remove: "Server, X-Powered-By"`,
+			wantRequest: &HeaderRules{Remove: []string{"Cookie"}, Set: map[string]string{"X-Tenant": "acme"}},
+			wantResponse: &HeaderRules{
+				Remove: []string{"Server", "X-Powered-By"},
+				Set:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+				Add:    map[string]string{"Vary": "Origin"},
+			},
+		},
+		{name: "unknown side", labels: `liteproxy.headers.upstream.set.X-A: "1"`, wantErr: true},
+		{name: "unknown action", labels: `liteproxy.headers.request.append.X-A: "1"`, wantErr: true},
+		{name: "missing name", labels: `liteproxy.headers.request.set: "1"`, wantErr: true},
+		{name: "invalid name", labels: `liteproxy.headers.response.remove: "Bad Header"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routes[0].RequestHeaders; !reflect.DeepEqual(got, tt.wantRequest) {
+				t.Errorf("RequestHeaders = %+v, want %+v", got, tt.wantRequest)
+			}
+			if got := routes[0].ResponseHeaders; !reflect.DeepEqual(got, tt.wantResponse) {
+				t.Errorf("ResponseHeaders = %+v, want %+v", got, tt.wantResponse)
+			}
+		})
+	}
+}
+func TestHash(t *testing.T) {
+	a := []Route{
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080},
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+	}
+	reordered := []Route{a[1], a[0]}
+	changed := []Route{a[0], {Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 3000}}
+
+	if Hash(a) != Hash(reordered) {
+		t.Error("Hash() depends on route order")
+	}
+	if Hash(a) == Hash(changed) {
+		t.Error("Hash() unchanged after a port change")
+	}
+	if !strings.HasPrefix(Hash(a), "sha256:") {
+		t.Errorf("Hash() = %q, want a sha256: prefix", Hash(a))
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := []Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080},
+		{Host: "old.example.com", PathPrefix: "/", ServiceName: "legacy", ServicePort: 80},
+		{ServiceName: "db", ServicePort: 5432, TCPPort: 5432},
+	}
+	new := []Route{
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 9090, Timeout: &Timeout{Duration: time.Second}},
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+		{Host: "new.example.com", PathPrefix: "/", ServiceName: "shop", ServicePort: 80},
+		{ServiceName: "db", ServicePort: 5432, TCPPort: 5432},
+	}
+
+	d := Diff(old, new)
+	if len(d.Added) != 1 || d.Added[0].Host != "new.example.com" {
+		t.Errorf("Added = %+v, want new.example.com", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Host != "old.example.com" {
+		t.Errorf("Removed = %+v, want old.example.com", d.Removed)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Route.Key() != "example.com/api" || !slices.Equal(d.Changed[0].Fields, []string{"port", "timeout"}) {
+		t.Errorf("Changed = %+v, want example.com/api with port and timeout", d.Changed)
+	}
+	if !Diff(old, slices.Clone(old)).Empty() {
+		t.Error("Diff() of identical tables is not empty")
+	}
+}
+
+func TestDirFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.yml", "a.yaml", "Liteproxyfile", "shop.liteproxy", ".hidden.yaml", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.yaml"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := DirFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	want := []string{"Liteproxyfile", "a.yaml", "b.yml", "shop.liteproxy"}
+	if !slices.Equal(names, want) {
+		t.Errorf("DirFiles() = %v, want %v", names, want)
+	}
+
+	if _, err := DirFiles(filepath.Join(dir, "missing")); err == nil {
+		t.Error("DirFiles() on a missing directory: expected error")
+	}
+}
@@ -0,0 +1,63 @@
+package compose
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels matching paths other than by prefix (liteproxy.path)
+const (
+	LabelPathExact = "liteproxy.path_exact"
+	LabelPathRegex = "liteproxy.path_regex"
+)
+
+// extractPathMatch extracts liteproxy.path_exact or liteproxy.path_regex,
+// which replace the route's path prefix
+func extractPathMatch(route *Route, labels types.Labels) error {
+	exact, pattern := labels[LabelPathExact], labels[LabelPathRegex]
+	if exact == "" && pattern == "" {
+		return nil
+	}
+	label := LabelPathExact
+	if pattern != "" {
+		label = LabelPathRegex
+	}
+	switch {
+	case exact != "" && pattern != "":
+		return fmt.Errorf("%s is not supported with %s", LabelPathExact, LabelPathRegex)
+	case labels[LabelPath] != "":
+		return fmt.Errorf("%s is not supported with %s", label, LabelPath)
+	case route.StripPrefix:
+		return fmt.Errorf("%s is not supported with %s", LabelStripPrefix, label)
+	case route.Passthrough:
+		return fmt.Errorf("%s is not supported with %s", label, LabelPassthrough)
+	}
+
+	if exact != "" {
+		if !strings.HasPrefix(exact, "/") {
+			return fmt.Errorf("invalid %s %q (must start with /)", LabelPathExact, exact)
+		}
+		route.PathPrefix, route.PathExact = "", exact
+		return nil
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid %s %q: %w", LabelPathRegex, pattern, err)
+	}
+	route.PathPrefix, route.PathRegex = "", pattern
+	return nil
+}
+
+// Path describes how the route matches paths, for keys, logs and
+// metrics: the prefix, "=" and the exact path, or "~" and the pattern
+func (r *Route) Path() string {
+	switch {
+	case r.PathExact != "":
+		return "=" + r.PathExact
+	case r.PathRegex != "":
+		return "~" + r.PathRegex
+	}
+	return r.PathPrefix
+}
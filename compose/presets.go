@@ -0,0 +1,143 @@
+package compose
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels tuning passthrough routes
+const (
+	LabelPassthroughPort         = "liteproxy.passthrough.port"
+	LabelPassthroughIdleTimeout  = "liteproxy.passthrough.idle_timeout"
+	LabelPassthroughForwardedFor = "liteproxy.passthrough.forwarded_for"
+
+	LabelPostgresDatabases = "liteproxy.postgres.databases"
+	LabelPostgresUsers     = "liteproxy.postgres.users"
+)
+
+// Passthrough presets: values of liteproxy.passthrough for protocols
+// routed on their own port by SNI, or by a hostname early in the stream
+const (
+	PresetMQTT      = "mqtt"      // MQTT over TLS
+	PresetAMQP      = "amqp"      // AMQPS
+	PresetPostgres  = "postgres"  // PostgreSQL, routed by SNI after its SSLRequest or by startup message
+	PresetMinecraft = "minecraft" // Minecraft Java Edition, routed by the server address in its handshake
+	PresetTLS       = "tls"       // any other TLS service, such as IMAPS, on liteproxy.passthrough.port
+)
+
+// sniOnly reports whether a preset routes by the ClientHello's SNI alone,
+// so its routes can share a port with those of the other SNI-only presets
+func sniOnly(preset string) bool {
+	return preset == PresetMQTT || preset == PresetAMQP || preset == PresetTLS
+}
+
+// preset is a protocol's standard TLS port and an idle timeout longer than
+// its usual keepalive interval (0 = none)
+type preset struct {
+	port int
+	idle time.Duration
+}
+
+var presets = map[string]preset{
+	PresetMQTT:      {port: 8883, idle: 10 * time.Minute}, // device keepalives are often minutes apart
+	PresetAMQP:      {port: 5671, idle: 3 * time.Minute},  // brokers default to 60s heartbeats
+	PresetPostgres:  {port: 5432},                         // pooled connections sit idle for hours
+	PresetMinecraft: {port: 25565, idle: time.Minute},     // servers send keepalives every 15s
+	PresetTLS:       {},                                   // no standard port
+}
+
+// PostgresMatch routes cleartext Postgres connections, which carry no SNI,
+// by the database and user in their startup message
+type PostgresMatch struct {
+	Databases []string `json:"databases,omitempty"` // empty = any
+	Users     []string `json:"users,omitempty"`     // empty = any
+}
+
+// Matches reports whether a startup message for database and user matches
+func (m *PostgresMatch) Matches(database, user string) bool {
+	return (len(m.Databases) == 0 || slices.Contains(m.Databases, database)) &&
+		(len(m.Users) == 0 || slices.Contains(m.Users, user))
+}
+
+// extractPassthrough extracts liteproxy.passthrough and its tuning labels
+func extractPassthrough(route *Route, labels types.Labels) error {
+	switch v := labels[LabelPassthrough]; v {
+	case "", "false":
+	case "true":
+		route.Passthrough = true
+	case PresetMQTT, PresetAMQP, PresetPostgres, PresetMinecraft, PresetTLS:
+		route.Passthrough = true
+		route.Preset = v
+		if port := presets[v].port; port != 0 {
+			route.SNIPorts = []int{port}
+		}
+		route.IdleTimeout = presets[v].idle
+	default:
+		return fmt.Errorf("invalid %s %q (want true, %s, %s, %s, %s or %s)", LabelPassthrough, v, PresetMQTT, PresetAMQP, PresetPostgres, PresetMinecraft, PresetTLS)
+	}
+
+	if v := labels[LabelPassthroughPort]; v != "" {
+		if route.Preset == "" {
+			return fmt.Errorf("%s requires %s %q, %q, %q, %q or %q", LabelPassthroughPort, LabelPassthrough, PresetMQTT, PresetAMQP, PresetPostgres, PresetMinecraft, PresetTLS)
+		}
+		route.SNIPorts = nil
+		for _, item := range strings.Split(v, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("invalid %s %q (want port numbers like 993, 995)", LabelPassthroughPort, v)
+			}
+			if !slices.Contains(route.SNIPorts, port) {
+				route.SNIPorts = append(route.SNIPorts, port)
+			}
+		}
+	}
+	if route.Preset == PresetTLS && len(route.SNIPorts) == 0 {
+		return fmt.Errorf("%s %q requires %s", LabelPassthrough, PresetTLS, LabelPassthroughPort)
+	}
+	if v := labels[LabelPassthroughIdleTimeout]; v != "" {
+		if !route.Passthrough {
+			return fmt.Errorf("%s requires %s", LabelPassthroughIdleTimeout, LabelPassthrough)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", LabelPassthroughIdleTimeout, v)
+		}
+		route.IdleTimeout = d
+	}
+	switch v := labels[LabelPassthroughForwardedFor]; v {
+	case "", "false":
+	case "true":
+		if !route.Passthrough || route.Preset != "" {
+			return fmt.Errorf("%s requires %s \"true\"", LabelPassthroughForwardedFor, LabelPassthrough)
+		}
+		route.ForwardedFor = true
+	default:
+		return fmt.Errorf("invalid %s %q (want true or false)", LabelPassthroughForwardedFor, v)
+	}
+
+	databases, users := labels[LabelPostgresDatabases], labels[LabelPostgresUsers]
+	if databases == "" && users == "" {
+		return nil
+	}
+	if route.Preset != PresetPostgres {
+		return fmt.Errorf("%s and %s require %s %q", LabelPostgresDatabases, LabelPostgresUsers, LabelPassthrough, PresetPostgres)
+	}
+	route.Postgres = &PostgresMatch{Databases: splitNames(databases), Users: splitNames(users)}
+	return nil
+}
+
+// splitNames splits a comma-separated list, dropping empty entries
+func splitNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,181 @@
+package compose
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePassthroughPreset(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    Route
+		wantErr string
+	}{
+		{name: "plain", labels: `liteproxy.passthrough: "true"`, want: Route{Passthrough: true}},
+		{name: "off", labels: `liteproxy.passthrough: "false"`},
+		{name: "mqtt", labels: `liteproxy.passthrough: "mqtt"`,
+			want: Route{Passthrough: true, Preset: PresetMQTT, SNIPorts: []int{8883}, IdleTimeout: 10 * time.Minute}},
+		{name: "amqp", labels: `liteproxy.passthrough: "amqp"`,
+			want: Route{Passthrough: true, Preset: PresetAMQP, SNIPorts: []int{5671}, IdleTimeout: 3 * time.Minute}},
+		{name: "postgres", labels: `liteproxy.passthrough: "postgres"`,
+			want: Route{Passthrough: true, Preset: PresetPostgres, SNIPorts: []int{5432}}},
+		{name: "minecraft", labels: `liteproxy.passthrough: "minecraft"`,
+			want: Route{Passthrough: true, Preset: PresetMinecraft, SNIPorts: []int{25565}, IdleTimeout: time.Minute}},
+		{
+			name: "tls",
+			labels: `liteproxy.passthrough: "tls"
+      liteproxy.passthrough.port: "993"`,
+			want: Route{Passthrough: true, Preset: PresetTLS, SNIPorts: []int{993}},
+		},
+		{
+			name: "tls on several ports",
+			labels: `liteproxy.passthrough: "tls"
+      liteproxy.passthrough.port: "993, 995, 993"`,
+			want: Route{Passthrough: true, Preset: PresetTLS, SNIPorts: []int{993, 995}},
+		},
+		{name: "tls without port", labels: `liteproxy.passthrough: "tls"`, wantErr: `liteproxy.passthrough "tls" requires liteproxy.passthrough.port`},
+		{
+			name: "postgres by database",
+			labels: `liteproxy.passthrough: "postgres"
+      liteproxy.postgres.databases: "orders, invoices"
+      liteproxy.postgres.users: "app"`,
+			want: Route{Passthrough: true, Preset: PresetPostgres, SNIPorts: []int{5432},
+				Postgres: &PostgresMatch{Databases: []string{"orders", "invoices"}, Users: []string{"app"}}},
+		},
+		{
+			name:    "databases without postgres",
+			labels:  `liteproxy.postgres.databases: "orders"`,
+			wantErr: `liteproxy.postgres.databases and liteproxy.postgres.users require liteproxy.passthrough "postgres"`,
+		},
+		{
+			name: "custom port and timeout",
+			labels: `liteproxy.passthrough: "mqtt"
+      liteproxy.passthrough.port: "18883"
+      liteproxy.passthrough.idle_timeout: "1h"`,
+			want: Route{Passthrough: true, Preset: PresetMQTT, SNIPorts: []int{18883}, IdleTimeout: time.Hour},
+		},
+		{
+			name: "idle timeout on plain passthrough",
+			labels: `liteproxy.passthrough: "true"
+      liteproxy.passthrough.idle_timeout: "30s"`,
+			want: Route{Passthrough: true, IdleTimeout: 30 * time.Second},
+		},
+		{name: "invalid", labels: `liteproxy.passthrough: "kafka"`, wantErr: `invalid liteproxy.passthrough "kafka"`},
+		{
+			name: "port without preset",
+			labels: `liteproxy.passthrough: "true"
+      liteproxy.passthrough.port: "8883"`,
+			wantErr: `liteproxy.passthrough.port requires liteproxy.passthrough "mqtt", "amqp", "postgres", "minecraft" or "tls"`,
+		},
+		{
+			name: "invalid port",
+			labels: `liteproxy.passthrough: "amqp"
+      liteproxy.passthrough.port: "99999"`,
+			wantErr: `invalid liteproxy.passthrough.port "99999"`,
+		},
+		{name: "idle timeout without passthrough", labels: `liteproxy.passthrough.idle_timeout: "1m"`, wantErr: "liteproxy.passthrough.idle_timeout requires liteproxy.passthrough"},
+		{
+			name: "invalid idle timeout",
+			labels: `liteproxy.passthrough: "mqtt"
+      liteproxy.passthrough.idle_timeout: "forever"`,
+			wantErr: `invalid liteproxy.passthrough.idle_timeout "forever"`,
+		},
+		{
+			name: "forwarded for",
+			labels: `liteproxy.passthrough: "true"
+      liteproxy.passthrough.forwarded_for: "true"`,
+			want: Route{Passthrough: true, ForwardedFor: true},
+		},
+		{name: "forwarded for without passthrough", labels: `liteproxy.passthrough.forwarded_for: "true"`, wantErr: `liteproxy.passthrough.forwarded_for requires liteproxy.passthrough "true"`},
+		{
+			name: "forwarded for on a preset",
+			labels: `liteproxy.passthrough: "mqtt"
+      liteproxy.passthrough.forwarded_for: "true"`,
+			wantErr: `liteproxy.passthrough.forwarded_for requires liteproxy.passthrough "true"`,
+		},
+		{
+			name: "invalid forwarded for",
+			labels: `liteproxy.passthrough: "true"
+      liteproxy.passthrough.forwarded_for: "yes"`,
+			wantErr: `invalid liteproxy.passthrough.forwarded_for "yes"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  broker:
+    image: emqx
+    labels:
+      liteproxy.host: "mqtt.example.com"
+      liteproxy.port: "8883"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			r := routes[0]
+			if !reflect.DeepEqual(r.Postgres, tt.want.Postgres) {
+				t.Errorf("Postgres = %+v, want %+v", r.Postgres, tt.want.Postgres)
+			}
+			if r.Passthrough != tt.want.Passthrough || r.Preset != tt.want.Preset || !slices.Equal(r.SNIPorts, tt.want.SNIPorts) || r.IdleTimeout != tt.want.IdleTimeout || r.ForwardedFor != tt.want.ForwardedFor {
+				t.Errorf("route = passthrough %v preset %q port %d idle %v forwarded for %v, want %v %q %d %v %v",
+					r.Passthrough, r.Preset, r.SNIPorts, r.IdleTimeout, r.ForwardedFor,
+					tt.want.Passthrough, tt.want.Preset, tt.want.SNIPorts, tt.want.IdleTimeout, tt.want.ForwardedFor)
+			}
+		})
+	}
+}
+
+func TestCheckForwardPortsPresets(t *testing.T) {
+	mqtt := func(service, host string) Route {
+		return Route{ServiceName: service, Host: host, Passthrough: true, Preset: PresetMQTT, SNIPorts: []int{8883}}
+	}
+	tests := []struct {
+		name    string
+		routes  []Route
+		wantErr string
+	}{
+		{name: "hosts share the port", routes: []Route{mqtt("a", "a.example.com"), mqtt("b", "b.example.com")}},
+		{name: "same host twice", routes: []Route{mqtt("a", "mqtt.example.com"), mqtt("b", "mqtt.example.com")},
+			wantErr: "a and b both serve mqtt.example.com on port 8883"},
+		{name: "sni-only presets share the port", routes: []Route{mqtt("a", "a.example.com"), {ServiceName: "ws", Host: "ws.example.com", Preset: PresetTLS, SNIPorts: []int{995, 8883}}}},
+		{name: "sni-only presets, same host", routes: []Route{mqtt("a", "mqtt.example.com"), {ServiceName: "ws", Host: "mqtt.example.com", Preset: PresetTLS, SNIPorts: []int{8883}}},
+			wantErr: "a and ws both serve mqtt.example.com on port 8883"},
+		{name: "different presets", routes: []Route{mqtt("a", "a.example.com"), {ServiceName: "db", Host: "db.example.com", Preset: PresetPostgres, SNIPorts: []int{8883}}},
+			wantErr: "tcp port 8883 is shared by a (mqtt) and db (postgres)"},
+		{name: "tcp forward first", routes: []Route{{ServiceName: "raw", TCPPort: 8883}, mqtt("a", "a.example.com")},
+			wantErr: "tcp port 8883 is forwarded by both raw and a"},
+		{name: "tcp forward after", routes: []Route{mqtt("a", "a.example.com"), {ServiceName: "raw", TCPPort: 8883}},
+			wantErr: "tcp port 8883 is forwarded by both a and raw"},
+	}
+	for _, tt := range tests {
+		err := checkForwardPorts(tt.routes)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPostgresMatch(t *testing.T) {
+	m := &PostgresMatch{Databases: []string{"orders"}}
+	if !m.Matches("orders", "anyone") || m.Matches("billing", "anyone") {
+		t.Error("database match wrong")
+	}
+	m = &PostgresMatch{Users: []string{"reporting"}}
+	if !m.Matches("orders", "reporting") || m.Matches("orders", "app") {
+		t.Error("user match wrong")
+	}
+}
@@ -0,0 +1,157 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// ExtensionKey is the top-level compose extension holding file-wide liteproxy settings
+const ExtensionKey = "x-liteproxy"
+
+// DefaultBackendHost dials services by their compose service name
+const DefaultBackendHost = "{service}"
+
+// LabelNetwork picks the network a service is dialed on, overriding
+// x-liteproxy.network for that service
+const LabelNetwork = "liteproxy.network"
+
+// Settings holds file-wide settings from the x-liteproxy block
+type Settings struct {
+	// BackendHost is a template for the host dialed for each service.
+	// {service} and {project} are replaced, e.g. "{project}-{service}-1"
+	// for compose's default container names, or "{service}.{project}" for
+	// a per-project DNS suffix.
+	BackendHost string
+
+	// Network dials every service by its name on one network, as
+	// "{service}.{network}", for a proxy attached to several networks.
+	// Networks declared in the file are dialed by their full name.
+	Network string
+
+	// Compat lists proxies (traefik, caddy) whose labels are translated
+	// for services without liteproxy.host, so their compose files work
+	// without relabeling.
+	Compat []string
+}
+
+// parseSettings reads the x-liteproxy block, returning defaults if absent.
+// Includes are read relative to dir.
+func parseSettings(project *types.Project, dir string) (Settings, error) {
+	settings := Settings{BackendHost: DefaultBackendHost}
+
+	raw, ok := project.Extensions[ExtensionKey]
+	if !ok {
+		return settings, nil
+	}
+	block, ok := raw.(map[string]any)
+	if !ok {
+		return settings, fmt.Errorf("%s must be a mapping", ExtensionKey)
+	}
+	block, err := resolveBlock(block, dir)
+	if err != nil {
+		return settings, err
+	}
+
+	if v, ok := block["backend_host"]; ok {
+		s, ok := v.(string)
+		if !ok || !strings.Contains(s, "{service}") {
+			return settings, fmt.Errorf("%s.backend_host must be a string containing {service}", ExtensionKey)
+		}
+		settings.BackendHost = s
+	}
+
+	if v, ok := block["network"]; ok {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return settings, fmt.Errorf("%s.network must be a network name", ExtensionKey)
+		}
+		if settings.BackendHost != DefaultBackendHost {
+			return settings, fmt.Errorf("%s.network is not supported with %s.backend_host", ExtensionKey, ExtensionKey)
+		}
+		settings.Network = s
+	}
+
+	if v, ok := block["compat"]; ok {
+		var names []any
+		switch v := v.(type) {
+		case string:
+			for _, name := range strings.Split(v, ",") {
+				names = append(names, strings.TrimSpace(name))
+			}
+		case []any:
+			names = v
+		}
+		for _, name := range names {
+			if name != CompatTraefik && name != CompatCaddy {
+				return settings, fmt.Errorf("%s.compat must list %s or %s, got %v", ExtensionKey, CompatTraefik, CompatCaddy, name)
+			}
+			settings.Compat = append(settings.Compat, name.(string))
+		}
+		if len(settings.Compat) == 0 {
+			return settings, fmt.Errorf("%s.compat must list %s or %s", ExtensionKey, CompatTraefik, CompatCaddy)
+		}
+	}
+
+	return settings, nil
+}
+
+// backendHost expands the BackendHost template for a service
+func (s Settings) backendHost(project, service string) string {
+	return strings.NewReplacer("{service}", service, "{project}", project).Replace(s.BackendHost)
+}
+
+// dialHost returns the host dialed for service, or "" for its plain name.
+// A network from liteproxy.network or x-liteproxy.network takes precedence
+// over the backend_host template.
+func (s Settings) dialHost(project *types.Project, name string, service types.ServiceConfig, target string) (string, error) {
+	network := s.Network
+	if v := service.Labels[LabelNetwork]; v != "" {
+		network = v
+	}
+	if network == "" {
+		if s.BackendHost == DefaultBackendHost {
+			return "", nil
+		}
+		return s.backendHost(name, target), nil
+	}
+
+	if n, ok := project.Networks[network]; ok {
+		// Networks declared here: the service must be attached to them
+		if _, attached := service.Networks[network]; !attached {
+			return "", fmt.Errorf("not attached to network %s", network)
+		}
+		if n.Name != "" {
+			network = n.Name
+		}
+	}
+	network = strings.ReplaceAll(network, "{project}", name)
+	for _, c := range network {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", fmt.Errorf("invalid network %q", network)
+		}
+	}
+	return target + "." + network, nil
+}
+
+// projectName returns the compose project name: the top-level name if set,
+// otherwise the directory containing the file, normalized like docker compose
+func projectName(project *types.Project, filename string) string {
+	if project.Name != "" {
+		return project.Name
+	}
+	dir := filepath.Base(filepath.Dir(filename))
+	if abs, err := filepath.Abs(filename); err == nil {
+		dir = filepath.Base(filepath.Dir(abs))
+	}
+
+	var b strings.Builder
+	for _, c := range strings.ToLower(dir) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
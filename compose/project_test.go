@@ -0,0 +1,330 @@
+package compose
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseBackendHostTemplate(t *testing.T) {
+	yaml := `
+name: shop
+x-liteproxy:
+  backend_host: "{project}-{service}-1"
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "shop.example.com"
+      liteproxy.port: "80"
+`
+	routes, err := Parse([]byte(yaml), "test.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	r := routes[0]
+	if r.Project != "shop" {
+		t.Errorf("Project = %q, want %q", r.Project, "shop")
+	}
+	if r.DialHost() != "shop-web-1" {
+		t.Errorf("DialHost() = %q, want %q", r.DialHost(), "shop-web-1")
+	}
+	if r.ServiceName != "web" {
+		t.Errorf("ServiceName = %q, want %q", r.ServiceName, "web")
+	}
+	if addrs := r.Addrs(); addrs[0] != "shop-web-1:80" {
+		t.Errorf("Addrs() = %v, want [shop-web-1:80]", addrs)
+	}
+}
+
+func TestParseBackendHostDefault(t *testing.T) {
+	yaml := `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "80"
+`
+	routes, err := Parse([]byte(yaml), "/srv/My Stack/compose.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if routes[0].DialHost() != "web" {
+		t.Errorf("DialHost() = %q, want %q", routes[0].DialHost(), "web")
+	}
+	// Project name falls back to the normalized directory name
+	if routes[0].Project != "mystack" {
+		t.Errorf("Project = %q, want %q", routes[0].Project, "mystack")
+	}
+}
+
+func TestParseBackendHostInvalid(t *testing.T) {
+	yaml := `
+x-liteproxy:
+  backend_host: "static-host"
+services:
+  web:
+    image: nginx
+`
+	if _, err := Parse([]byte(yaml), "test.yaml"); err == nil {
+		t.Error("Parse() expected error for backend_host without {service}")
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    map[string]string // service → dial host
+		wantErr string
+	}{
+		{
+			name: "file-wide network",
+			yaml: `
+name: shop
+x-liteproxy:
+  network: "edge"
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "shop.example.com"
+      liteproxy.port: "80"
+`,
+			want: map[string]string{"web": "web.edge"},
+		},
+		{
+			name: "declared networks use their full name",
+			yaml: `
+name: shop
+x-liteproxy:
+  network: "default"
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "shop.example.com"
+      liteproxy.port: "80"
+  admin:
+    image: nginx
+    networks: [internal]
+    labels:
+      liteproxy.host: "admin.example.com"
+      liteproxy.port: "80"
+      liteproxy.network: "internal"
+networks:
+  internal: {}
+`,
+			want: map[string]string{"web": "web.shop_default", "admin": "admin.shop_internal"},
+		},
+		{
+			name: "label with project placeholder",
+			yaml: `
+name: shop
+x-liteproxy:
+  backend_host: "{project}-{service}-1"
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "shop.example.com"
+      liteproxy.port: "80"
+      liteproxy.network: "{project}_public"
+  api:
+    image: nginx
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "80"
+`,
+			want: map[string]string{"web": "web.shop_public", "api": "shop-api-1"},
+		},
+		{
+			name: "canary on the same network",
+			yaml: `
+x-liteproxy:
+  network: "edge"
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "shop.example.com"
+      liteproxy.port: "80"
+      liteproxy.canary.service: "web-next"
+      liteproxy.canary.weight: "5"
+`,
+			want: map[string]string{"web": "web.edge", "canary": "web-next.edge"},
+		},
+		{
+			name: "not attached",
+			yaml: `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "shop.example.com"
+      liteproxy.port: "80"
+      liteproxy.network: "internal"
+networks:
+  internal: {}
+`,
+			wantErr: "not attached to network internal",
+		},
+		{
+			name: "invalid name",
+			yaml: `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "shop.example.com"
+      liteproxy.port: "80"
+      liteproxy.network: "edge/1"
+`,
+			wantErr: `invalid network "edge/1"`,
+		},
+		{
+			name: "with backend_host",
+			yaml: `
+x-liteproxy:
+  backend_host: "{service}-1"
+  network: "edge"
+services:
+  web:
+    image: nginx
+`,
+			wantErr: "x-liteproxy.network is not supported with x-liteproxy.backend_host",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := Parse([]byte(tt.yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := make(map[string]string)
+			for _, r := range routes {
+				got[r.ServiceName] = r.DialHost()
+				if r.Canary != nil {
+					got["canary"] = r.Canary.Host
+				}
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("dial hosts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCompat(t *testing.T) {
+	yaml := `
+x-liteproxy:
+  compat: [traefik, caddy]
+services:
+  api:
+    image: api
+    labels:
+      traefik.http.routers.api.rule: "Host(` + "`api.example.com`" + `)"
+      traefik.http.services.api.loadbalancer.server.port: "8080"
+      liteproxy.timeout: "30s"
+  web:
+    image: web
+    labels:
+      caddy: "web.example.com"
+      caddy.reverse_proxy: "{{upstreams 3000}}"
+  native:
+    image: native
+    labels:
+      liteproxy.host: "native.example.com"
+      liteproxy.port: "80"
+      traefik.http.routers.native.rule: "Host(` + "`other.example.com`" + `)"
+`
+	routes, err := Parse([]byte(yaml), "test.yaml")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, r := range routes {
+		got[r.ServiceName] = fmt.Sprintf("%s:%d", r.Host, r.ServicePort)
+		if r.ServiceName == "api" && (r.Timeout == nil || !r.PassHostHeader) {
+			t.Errorf("api route = %+v, want liteproxy.timeout kept and passhost translated", r)
+		}
+	}
+	want := map[string]string{"api": "api.example.com:8080", "web": "web.example.com:3000", "native": "native.example.com:80"}
+	if !maps.Equal(got, want) {
+		t.Errorf("routes = %v, want %v", got, want)
+	}
+
+	// Without compat, other proxies' labels are ignored
+	routes, err = Parse([]byte(strings.Replace(yaml, "compat: [traefik, caddy]", "network: edge", 1)), "test.yaml")
+	if err != nil || len(routes) != 1 {
+		t.Errorf("Parse() without compat = %d routes, %v; want only the native route", len(routes), err)
+	}
+
+	_, err = Parse([]byte(strings.Replace(yaml, "[traefik, caddy]", "nginx", 1)), "test.yaml")
+	if err == nil || !strings.Contains(err.Error(), "compat") {
+		t.Errorf("Parse() with unknown compat error = %v", err)
+	}
+}
+
+func TestParseFilesSeparatesProjects(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	a := write("a.yaml", `
+name: stack-a
+x-liteproxy:
+  backend_host: "{service}.{project}"
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "a.example.com"
+      liteproxy.port: "80"
+`)
+	b := write("b.yaml", `
+name: stack-b
+x-liteproxy:
+  backend_host: "{service}.{project}"
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "b.example.com"
+      liteproxy.port: "80"
+`)
+
+	routes, err := ParseFiles([]string{a, b})
+	if err != nil {
+		t.Fatalf("ParseFiles() error = %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want 2", len(routes))
+	}
+
+	got := map[string]string{}
+	for _, r := range routes {
+		got[r.Host] = r.DialHost()
+	}
+	if got["a.example.com"] != "web.stack-a" || got["b.example.com"] != "web.stack-b" {
+		t.Errorf("dial hosts = %v, want web.stack-a and web.stack-b", got)
+	}
+}
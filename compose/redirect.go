@@ -0,0 +1,133 @@
+package compose
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for the HTTP to HTTPS redirect and HSTS
+const (
+	LabelHTTPSRedirect         = "liteproxy.https_redirect"
+	LabelHTTPSRedirectStatus   = "liteproxy.https_redirect.status"
+	LabelHTTPSRedirectExempt   = "liteproxy.https_redirect.exempt"
+	LabelHSTSMaxAge            = "liteproxy.hsts.max_age"
+	LabelHSTSIncludeSubdomains = "liteproxy.hsts.include_subdomains"
+	LabelHSTSPreload           = "liteproxy.hsts.preload"
+)
+
+// HTTPSRedirect changes how plain HTTP requests for a route are sent to
+// HTTPS. Routes without one redirect every request with a 301.
+type HTTPSRedirect struct {
+	Disabled bool     `json:"disabled,omitempty"` // serve the whole route over plain HTTP
+	Status   int      `json:"status,omitempty"`   // 301 or 308
+	Exempt   []string `json:"exempt,omitempty"`   // paths (or /prefix/*) served over plain HTTP
+}
+
+// HSTS is the Strict-Transport-Security header added to HTTPS responses
+type HSTS struct {
+	MaxAge            time.Duration `json:"max_age"`
+	IncludeSubdomains bool          `json:"include_subdomains,omitempty"`
+	Preload           bool          `json:"preload,omitempty"`
+}
+
+// Header returns the Strict-Transport-Security header value
+func (h *HSTS) Header() string {
+	v := "max-age=" + strconv.FormatInt(int64(h.MaxAge/time.Second), 10)
+	if h.IncludeSubdomains {
+		v += "; includeSubDomains"
+	}
+	if h.Preload {
+		v += "; preload"
+	}
+	return v
+}
+
+// ServesHTTP reports whether plain HTTP requests for path are proxied
+// rather than redirected to HTTPS
+func (r *Route) ServesHTTP(path string) bool {
+	if r.IsDirect(path) {
+		return true
+	}
+	return r.HTTPSRedirect != nil && (r.HTTPSRedirect.Disabled || matchPaths(r.HTTPSRedirect.Exempt, path))
+}
+
+// RedirectStatus returns the status plain HTTP requests are redirected with
+func (r *Route) RedirectStatus() int {
+	if r.HTTPSRedirect != nil && r.HTTPSRedirect.Status != 0 {
+		return r.HTTPSRedirect.Status
+	}
+	return http.StatusMovedPermanently
+}
+
+// extractHTTPSRedirect extracts the redirect and HSTS settings
+func extractHTTPSRedirect(route *Route, labels types.Labels) error {
+	redirect := &HTTPSRedirect{}
+	if v := labels[LabelHTTPSRedirect]; v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", LabelHTTPSRedirect, v)
+		}
+		redirect.Disabled = !enabled
+	}
+	if v := labels[LabelHTTPSRedirectStatus]; v != "" {
+		status, err := strconv.Atoi(v)
+		if err != nil || (status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect) {
+			return fmt.Errorf("invalid %s %q (want 301 or 308)", LabelHTTPSRedirectStatus, v)
+		}
+		redirect.Status = status
+	}
+	if paths := labels[LabelHTTPSRedirectExempt]; paths != "" {
+		for _, p := range strings.Split(paths, ",") {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "/") || strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+				return fmt.Errorf("invalid %s entry %q (want /path or /prefix/*)", LabelHTTPSRedirectExempt, p)
+			}
+			redirect.Exempt = append(redirect.Exempt, p)
+		}
+	}
+	if redirect.Disabled || redirect.Status != 0 || len(redirect.Exempt) > 0 {
+		route.HTTPSRedirect = redirect
+	}
+
+	maxAge := labels[LabelHSTSMaxAge]
+	if maxAge == "" {
+		for _, label := range []string{LabelHSTSIncludeSubdomains, LabelHSTSPreload} {
+			if labels[label] != "" {
+				return fmt.Errorf("%s requires %s", label, LabelHSTSMaxAge)
+			}
+		}
+		return nil
+	}
+	if route.Passthrough {
+		return fmt.Errorf("%s is not supported with %s", LabelHSTSMaxAge, LabelPassthrough)
+	}
+	// Browsers would upgrade the requests the route serves over HTTP
+	if redirect.Disabled {
+		return fmt.Errorf("%s is not supported with %s \"false\"", LabelHSTSMaxAge, LabelHTTPSRedirect)
+	}
+	d, err := time.ParseDuration(maxAge)
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid %s %q", LabelHSTSMaxAge, maxAge)
+	}
+	hsts := &HSTS{MaxAge: d}
+	for label, dst := range map[string]*bool{LabelHSTSIncludeSubdomains: &hsts.IncludeSubdomains, LabelHSTSPreload: &hsts.Preload} {
+		if v := labels[label]; v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", label, v)
+			}
+			*dst = b
+		}
+	}
+	// The preload list only accepts policies covering subdomains
+	if hsts.Preload && !hsts.IncludeSubdomains {
+		return fmt.Errorf("%s requires %s", LabelHSTSPreload, LabelHSTSIncludeSubdomains)
+	}
+	route.HSTS = hsts
+	return nil
+}
@@ -0,0 +1,115 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   string
+		want     *HTTPSRedirect
+		wantHSTS string
+		wantErr  string
+	}{
+		{name: "none"},
+		{name: "disabled", labels: `liteproxy.https_redirect: "false"`, want: &HTTPSRedirect{Disabled: true}},
+		{name: "enabled is the default", labels: `liteproxy.https_redirect: "true"`},
+		{name: "308", labels: `liteproxy.https_redirect.status: "308"`, want: &HTTPSRedirect{Status: 308}},
+		{
+			name:   "exempt paths",
+			labels: `liteproxy.https_redirect.exempt: "/healthz, /.well-known/*"`,
+			want:   &HTTPSRedirect{Exempt: []string{"/healthz", "/.well-known/*"}},
+		},
+		{name: "hsts", labels: `liteproxy.hsts.max_age: "8760h"`, wantHSTS: "max-age=31536000"},
+		{
+			name: "hsts preload",
+			labels: `liteproxy.hsts.max_age: "8760h"
+      liteproxy.hsts.include_subdomains: "true"
+      liteproxy.hsts.preload: "true"`,
+			wantHSTS: "max-age=31536000; includeSubDomains; preload",
+		},
+		{name: "302", labels: `liteproxy.https_redirect.status: "302"`, wantErr: `invalid liteproxy.https_redirect.status "302" (want 301 or 308)`},
+		{name: "invalid toggle", labels: `liteproxy.https_redirect: "maybe"`, wantErr: `invalid liteproxy.https_redirect "maybe"`},
+		{name: "relative exempt path", labels: `liteproxy.https_redirect.exempt: "healthz"`, wantErr: `invalid liteproxy.https_redirect.exempt entry "healthz"`},
+		{name: "invalid max age", labels: `liteproxy.hsts.max_age: "forever"`, wantErr: `invalid liteproxy.hsts.max_age "forever"`},
+		{name: "preload without max age", labels: `liteproxy.hsts.preload: "true"`, wantErr: "liteproxy.hsts.preload requires liteproxy.hsts.max_age"},
+		{
+			name: "preload without subdomains",
+			labels: `liteproxy.hsts.max_age: "8760h"
+      liteproxy.hsts.preload: "true"`,
+			wantErr: "liteproxy.hsts.preload requires liteproxy.hsts.include_subdomains",
+		},
+		{
+			name: "hsts on a plain HTTP route",
+			labels: `liteproxy.hsts.max_age: "8760h"
+      liteproxy.https_redirect: "false"`,
+			wantErr: "not supported with liteproxy.https_redirect",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  web:
+    image: web
+    labels:
+      liteproxy.host: "www.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := routes[0].HTTPSRedirect; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HTTPSRedirect = %+v, want %+v", got, tt.want)
+			}
+			var hsts string
+			if routes[0].HSTS != nil {
+				hsts = routes[0].HSTS.Header()
+			}
+			if hsts != tt.wantHSTS {
+				t.Errorf("HSTS = %q, want %q", hsts, tt.wantHSTS)
+			}
+		})
+	}
+}
+
+func TestRouteServesHTTP(t *testing.T) {
+	route := Route{
+		DirectPaths:   []string{"/healthz"},
+		HTTPSRedirect: &HTTPSRedirect{Status: 308, Exempt: []string{"/metrics", "/.well-known/*"}},
+		HSTS:          &HSTS{MaxAge: time.Hour},
+	}
+	for path, want := range map[string]bool{
+		"/":                     false,
+		"/healthz":              true,
+		"/metrics":              true,
+		"/metrics/extra":        false,
+		"/.well-known/security": true,
+	} {
+		if got := route.ServesHTTP(path); got != want {
+			t.Errorf("ServesHTTP(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if got := route.RedirectStatus(); got != 308 {
+		t.Errorf("RedirectStatus() = %d, want 308", got)
+	}
+	if got := (&Route{}).RedirectStatus(); got != 301 {
+		t.Errorf("default RedirectStatus() = %d, want 301", got)
+	}
+	if !(&Route{HTTPSRedirect: &HTTPSRedirect{Disabled: true}}).ServesHTTP("/anything") {
+		t.Error("a route with the redirect disabled should serve every path over HTTP")
+	}
+}
@@ -0,0 +1,113 @@
+package compose
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for sending clients to the backend for their region
+const (
+	LabelRegions         = "liteproxy.regions"
+	LabelRegionHeader    = "liteproxy.region_header"
+	LabelRegionCountries = "liteproxy.region_countries"
+)
+
+// DefaultRegionHeader names the client's region unless liteproxy.region_header is set
+const DefaultRegionHeader = "X-Region"
+
+// Regions picks a backend by the region a request header names, failing
+// over to the other regions in order when it is unhealthy
+type Regions struct {
+	Header    string            `json:"header"`
+	Backends  []RegionBackend   `json:"backends"`            // in failover order; the first serves unknown regions
+	Countries map[string]string `json:"countries,omitempty"` // country code → region, for headers naming countries
+}
+
+// RegionBackend is the backend serving one region
+type RegionBackend struct {
+	Region string `json:"region"`
+	Addr   string `json:"addr"`
+}
+
+// Region returns the region a header value names, directly or by
+// country, or "" if it names none
+func (rg *Regions) Region(value string) string {
+	value = strings.TrimSpace(value)
+	for _, b := range rg.Backends {
+		if strings.EqualFold(b.Region, value) {
+			return b.Region
+		}
+	}
+	return rg.Countries[strings.ToUpper(value)]
+}
+
+// extractRegions extracts the regional backends. Their addresses become
+// the route's backends, so health checks cover them.
+func extractRegions(route *Route, labels types.Labels) error {
+	regions := labels[LabelRegions]
+	if regions == "" {
+		for _, label := range []string{LabelRegionHeader, LabelRegionCountries} {
+			if labels[label] != "" {
+				return fmt.Errorf("%s requires %s", label, LabelRegions)
+			}
+		}
+		return nil
+	}
+	switch {
+	case route.Passthrough:
+		return fmt.Errorf("%s is not supported with %s", LabelRegions, LabelPassthrough)
+	case len(route.Backends) > 0:
+		return fmt.Errorf("%s is not supported with %s", LabelRegions, LabelBackends)
+	case route.StickyCookie != "":
+		return fmt.Errorf("%s is not supported with %s", LabelRegions, LabelSticky)
+	case labels[LabelDiscovery] == DiscoveryDNS:
+		return fmt.Errorf("%s is not supported with %s %q", LabelRegions, LabelDiscovery, DiscoveryDNS)
+	}
+
+	rg := &Regions{Header: DefaultRegionHeader}
+	if header := labels[LabelRegionHeader]; header != "" {
+		name, err := headerName(LabelRegionHeader, header)
+		if err != nil {
+			return err
+		}
+		rg.Header = name
+	}
+	for _, entry := range strings.Split(regions, ",") {
+		region, addr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		region, addr = strings.ToLower(strings.TrimSpace(region)), strings.TrimSpace(addr)
+		if !ok || region == "" || addr == "" {
+			return fmt.Errorf("invalid %s entry %q (want region=host[:port])", LabelRegions, entry)
+		}
+		if rg.Region(region) != "" {
+			return fmt.Errorf("duplicate region %q in %s", region, LabelRegions)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, strconv.Itoa(route.ServicePort))
+		}
+		rg.Backends = append(rg.Backends, RegionBackend{Region: region, Addr: addr})
+		route.Backends = append(route.Backends, addr)
+	}
+
+	if countries := labels[LabelRegionCountries]; countries != "" {
+		rg.Countries = make(map[string]string)
+		for _, entry := range strings.Split(countries, ",") {
+			region, codes, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			region = strings.ToLower(strings.TrimSpace(region))
+			if !ok || rg.Region(region) != region {
+				return fmt.Errorf("invalid %s entry %q (want region=CC CC ... for a region in %s)", LabelRegionCountries, entry, LabelRegions)
+			}
+			for _, code := range strings.Fields(codes) {
+				rg.Countries[strings.ToUpper(code)] = region
+			}
+		}
+	}
+
+	// Replicas of the service itself aren't backends here
+	route.Discovery = ""
+	route.Regions = rg
+	return nil
+}
@@ -0,0 +1,96 @@
+package compose
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Regions
+		wantErr string
+	}{
+		{name: "none"},
+		{
+			name:   "regions",
+			labels: `liteproxy.regions: "eu=eu-api, US=us-api:9000"`,
+			want: &Regions{Header: DefaultRegionHeader, Backends: []RegionBackend{
+				{Region: "eu", Addr: "eu-api:8080"}, {Region: "us", Addr: "us-api:9000"},
+			}},
+		},
+		{
+			name: "country header",
+			labels: `liteproxy.regions: "eu=eu-api,us=us-api"
+      liteproxy.region_header: "cf-ipcountry"
+      liteproxy.region_countries: "eu=DE fr NL, us=US CA"`,
+			want: &Regions{
+				Header:    "Cf-Ipcountry",
+				Backends:  []RegionBackend{{Region: "eu", Addr: "eu-api:8080"}, {Region: "us", Addr: "us-api:8080"}},
+				Countries: map[string]string{"DE": "eu", "FR": "eu", "NL": "eu", "US": "us", "CA": "us"},
+			},
+		},
+		{name: "header without regions", labels: `liteproxy.region_header: "X-Geo"`, wantErr: "requires liteproxy.regions"},
+		{name: "missing address", labels: `liteproxy.regions: "eu="`, wantErr: "invalid liteproxy.regions entry"},
+		{name: "duplicate region", labels: `liteproxy.regions: "eu=a,EU=b"`, wantErr: "duplicate region"},
+		{
+			name: "countries for an unknown region",
+			labels: `liteproxy.regions: "eu=eu-api"
+      liteproxy.region_countries: "apac=JP"`,
+			wantErr: "invalid liteproxy.region_countries entry",
+		},
+		{
+			name: "with backends",
+			labels: `liteproxy.regions: "eu=eu-api"
+      liteproxy.backends: "a,b"`,
+			wantErr: "not supported with liteproxy.backends",
+		},
+		{
+			name: "with sticky sessions",
+			labels: `liteproxy.regions: "eu=eu-api"
+      liteproxy.sticky: "true"`,
+			wantErr: "not supported with liteproxy.sticky",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].Regions
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("Regions = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Header != tt.want.Header || !slices.Equal(got.Backends, tt.want.Backends) || !maps.Equal(got.Countries, tt.want.Countries) {
+				t.Fatalf("Regions = %+v, want %+v", got, tt.want)
+			}
+			// Health checks cover every regional backend
+			if len(routes[0].Backends) != len(got.Backends) {
+				t.Errorf("Backends = %v", routes[0].Backends)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package compose
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for routes whose service can be scaled to zero
+const (
+	LabelScaledToZero      = "liteproxy.scaled_to_zero"
+	LabelScaledToZeroWait  = "liteproxy.scaled_to_zero.wait"
+	LabelScaledToZeroStart = "liteproxy.scaled_to_zero.start"
+)
+
+// Values for the liteproxy.scaled_to_zero label
+const (
+	ScaledToZeroUnavailable = "unavailable" // 503, through the error pages if set
+	ScaledToZeroWait        = "wait"        // hold requests until a replica appears
+	ScaledToZeroRemove      = "remove"      // match requests as if the route didn't exist
+)
+
+// DefaultScaledToZeroWait is how long requests are held for a replica
+// unless liteproxy.scaled_to_zero.wait is set
+const DefaultScaledToZeroWait = 30 * time.Second
+
+// ScaledToZero says what a route does while its service has no running
+// containers, which shows as none of its backend names resolving
+type ScaledToZero struct {
+	Action string        `json:"action"`          // unavailable, wait or remove
+	Wait   time.Duration `json:"wait,omitempty"`  // longest a request is held (wait only)
+	Start  string        `json:"start,omitempty"` // command or http(s) webhook starting the service (wait only)
+}
+
+// StartURL reports whether Start is a webhook rather than a command
+func (s *ScaledToZero) StartURL() bool {
+	return strings.HasPrefix(s.Start, "http://") || strings.HasPrefix(s.Start, "https://")
+}
+
+// extractScaledToZero extracts the scaled to zero behavior. It must run
+// after the dialer is known, as dialed backends aren't resolved by liteproxy.
+func extractScaledToZero(route *Route, labels types.Labels) error {
+	action, wait, start := labels[LabelScaledToZero], labels[LabelScaledToZeroWait], labels[LabelScaledToZeroStart]
+	switch action {
+	case "":
+		if wait != "" {
+			return fmt.Errorf("%s requires %s %q", LabelScaledToZeroWait, LabelScaledToZero, ScaledToZeroWait)
+		}
+		if start != "" {
+			return fmt.Errorf("%s requires %s %q", LabelScaledToZeroStart, LabelScaledToZero, ScaledToZeroWait)
+		}
+		return nil
+	case ScaledToZeroUnavailable, ScaledToZeroWait, ScaledToZeroRemove:
+	default:
+		return fmt.Errorf("invalid %s %q (want %s, %s or %s)", LabelScaledToZero, action,
+			ScaledToZeroUnavailable, ScaledToZeroWait, ScaledToZeroRemove)
+	}
+	switch {
+	case route.Passthrough:
+		return fmt.Errorf("%s is not supported with %s", LabelScaledToZero, LabelPassthrough)
+	case route.Dial != "":
+		return fmt.Errorf("%s is not supported with %s", LabelScaledToZero, LabelDial)
+	}
+
+	s := &ScaledToZero{Action: action}
+	if action == ScaledToZeroWait {
+		s.Wait = DefaultScaledToZeroWait
+	}
+	if wait != "" {
+		if action != ScaledToZeroWait {
+			return fmt.Errorf("%s requires %s %q", LabelScaledToZeroWait, LabelScaledToZero, ScaledToZeroWait)
+		}
+		d, err := time.ParseDuration(wait)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", LabelScaledToZeroWait, wait)
+		}
+		s.Wait = d
+	}
+	if start = strings.TrimSpace(start); start != "" {
+		if action != ScaledToZeroWait {
+			return fmt.Errorf("%s requires %s %q", LabelScaledToZeroStart, LabelScaledToZero, ScaledToZeroWait)
+		}
+		s.Start = start
+		if u, err := url.Parse(start); s.StartURL() && (err != nil || u.Host == "") {
+			return fmt.Errorf("invalid %s %q", LabelScaledToZeroStart, start)
+		}
+	}
+	route.ScaledToZero = s
+	return nil
+}
@@ -0,0 +1,99 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScaledToZero(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *ScaledToZero
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "unavailable", labels: `liteproxy.scaled_to_zero: "unavailable"`, want: &ScaledToZero{Action: ScaledToZeroUnavailable}},
+		{name: "remove", labels: `liteproxy.scaled_to_zero: "remove"`, want: &ScaledToZero{Action: ScaledToZeroRemove}},
+		{name: "wait", labels: `liteproxy.scaled_to_zero: "wait"`, want: &ScaledToZero{Action: ScaledToZeroWait, Wait: DefaultScaledToZeroWait}},
+		{
+			name: "wait duration",
+			labels: `liteproxy.scaled_to_zero: "wait"
+      liteproxy.scaled_to_zero.wait: "5s"`,
+			want: &ScaledToZero{Action: ScaledToZeroWait, Wait: 5 * time.Second},
+		},
+		{
+			name: "start command",
+			labels: `liteproxy.scaled_to_zero: "wait"
+      liteproxy.scaled_to_zero.start: "docker compose up -d web"`,
+			want: &ScaledToZero{Action: ScaledToZeroWait, Wait: DefaultScaledToZeroWait, Start: "docker compose up -d web"},
+		},
+		{
+			name: "start webhook",
+			labels: `liteproxy.scaled_to_zero: "wait"
+      liteproxy.scaled_to_zero.start: "http://starter:8080/start"`,
+			want: &ScaledToZero{Action: ScaledToZeroWait, Wait: DefaultScaledToZeroWait, Start: "http://starter:8080/start"},
+		},
+		{
+			name: "invalid webhook",
+			labels: `liteproxy.scaled_to_zero: "wait"
+      liteproxy.scaled_to_zero.start: "http://"`,
+			wantErr: `invalid liteproxy.scaled_to_zero.start "http://"`,
+		},
+		{
+			name: "start without wait",
+			labels: `liteproxy.scaled_to_zero: "unavailable"
+      liteproxy.scaled_to_zero.start: "docker compose up -d web"`,
+			wantErr: "requires liteproxy.scaled_to_zero",
+		},
+		{name: "invalid action", labels: `liteproxy.scaled_to_zero: "sleep"`, wantErr: `invalid liteproxy.scaled_to_zero "sleep"`},
+		{
+			name: "invalid wait",
+			labels: `liteproxy.scaled_to_zero: "wait"
+      liteproxy.scaled_to_zero.wait: "forever"`,
+			wantErr: `invalid liteproxy.scaled_to_zero.wait "forever"`,
+		},
+		{name: "wait without action", labels: `liteproxy.scaled_to_zero.wait: "5s"`, wantErr: "requires liteproxy.scaled_to_zero"},
+		{
+			name: "wait with remove",
+			labels: `liteproxy.scaled_to_zero: "remove"
+      liteproxy.scaled_to_zero.wait: "5s"`,
+			wantErr: "requires liteproxy.scaled_to_zero",
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.scaled_to_zero: "unavailable"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  web:
+    image: web
+    labels:
+      liteproxy.host: "web.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].ScaledToZero
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("ScaledToZero = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
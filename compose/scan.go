@@ -0,0 +1,48 @@
+package compose
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for scanning uploads before they reach the backend
+const (
+	LabelScan        = "liteproxy.scan"
+	LabelScanMaxSize = "liteproxy.scan.max_size"
+)
+
+// DefaultScanMaxSize is the largest request body sent for scanning
+const DefaultScanMaxSize = 100 << 20
+
+// Scan sends request bodies to a content scanner, such as an antivirus,
+// and only forwards them to the backend once it finds them clean
+type Scan struct {
+	URL     string `json:"url"`      // http(s):// scanning endpoint or icap:// REQMOD service
+	MaxSize int    `json:"max_size"` // larger bodies are refused with 413
+}
+
+// extractScan extracts the content scanner settings
+func extractScan(labels types.Labels) (*Scan, error) {
+	scanURL := labels[LabelScan]
+	if scanURL == "" {
+		if labels[LabelScanMaxSize] != "" {
+			return nil, fmt.Errorf("%s requires %s", LabelScanMaxSize, LabelScan)
+		}
+		return nil, nil
+	}
+	u, err := url.Parse(scanURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "icap") {
+		return nil, fmt.Errorf("invalid %s %q (want an http://, https:// or icap:// URL)", LabelScan, scanURL)
+	}
+	s := &Scan{URL: scanURL, MaxSize: DefaultScanMaxSize}
+	if size := labels[LabelScanMaxSize]; size != "" {
+		n, err := parseSize(size)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", LabelScanMaxSize, size)
+		}
+		s.MaxSize = n
+	}
+	return s, nil
+}
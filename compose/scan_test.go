@@ -0,0 +1,71 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Scan
+		wantErr string
+	}{
+		{name: "none"},
+		{
+			name:   "http scanner",
+			labels: `liteproxy.scan: "http://clamav:8080/scan"`,
+			want:   &Scan{URL: "http://clamav:8080/scan", MaxSize: DefaultScanMaxSize},
+		},
+		{
+			name: "icap with max size",
+			labels: `liteproxy.scan: "icap://icap:1344/avscan"
+      liteproxy.scan.max_size: "20MB"`,
+			want: &Scan{URL: "icap://icap:1344/avscan", MaxSize: 20 << 20},
+		},
+		{name: "unsupported scheme", labels: `liteproxy.scan: "ftp://scanner"`, wantErr: "invalid liteproxy.scan"},
+		{name: "no host", labels: `liteproxy.scan: "/scan"`, wantErr: "invalid liteproxy.scan"},
+		{name: "max size without scanner", labels: `liteproxy.scan.max_size: "20MB"`, wantErr: "requires liteproxy.scan"},
+		{
+			name: "bad max size",
+			labels: `liteproxy.scan: "http://clamav:8080/scan"
+      liteproxy.scan.max_size: "lots"`,
+			wantErr: "invalid liteproxy.scan.max_size",
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.scan: "http://clamav:8080/scan"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  files:
+    image: files
+    labels:
+      liteproxy.host: "files.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := routes[0].Scan; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Scan = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package compose
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/balancer"
+)
+
+// IsSiteFile reports whether path names a site file (Liteproxyfile,
+// Caddyfile or *.liteproxy) rather than a compose file
+func IsSiteFile(path string) bool {
+	base := filepath.Base(path)
+	return base == "Liteproxyfile" || base == "Caddyfile" || filepath.Ext(base) == ".liteproxy"
+}
+
+// siteToken is a word of a site file and the line it is on
+type siteToken struct {
+	text string
+	line int
+}
+
+// siteDirective is a directive, its arguments and optional block
+type siteDirective struct {
+	name  string
+	args  []string
+	line  int
+	block []siteDirective
+}
+
+// ParseSiteFile parses a Caddyfile-style site file, one block per site:
+//
+//	example.com www.example.com {
+//	    reverse_proxy /api/* api-1:8080 api-2:8080 {
+//	        lb_policy least_conn
+//	    }
+//	    reverse_proxy web:80
+//	}
+func ParseSiteFile(data []byte, filename string) ([]Route, error) {
+	sites, err := parseSiteDirectives(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var routes []Route
+	redirects := make(map[string][]string) // target host → hosts redirecting to it
+	for _, site := range sites {
+		hosts := siteHosts(append([]string{site.name}, site.args...))
+		if len(hosts) == 0 || site.block == nil {
+			return nil, fmt.Errorf("%s:%d: expected site addresses followed by {", filename, site.line)
+		}
+		siteRoutes, target, err := parseSite(hosts, site.block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		if target != "" {
+			redirects[target] = append(redirects[target], hosts...)
+		}
+		routes = append(routes, siteRoutes...)
+	}
+
+	for target, from := range redirects {
+		found := false
+		for i := range routes {
+			if routes[i].Host == target {
+				routes[i].RedirectFrom = append(routes[i].RedirectFrom, from...)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: redir from %s: no site for %s", filename, strings.Join(from, ", "), target)
+		}
+	}
+	return routes, nil
+}
+
+// parseSite turns one site block into routes, or into a redirect target
+func parseSite(hosts []string, block []siteDirective) ([]Route, string, error) {
+	var routes []Route
+	var target string
+	for _, d := range block {
+		switch d.name {
+		case "reverse_proxy":
+			r, err := parseReverseProxy(hosts, "", false, d)
+			if err != nil {
+				return nil, "", err
+			}
+			routes = append(routes, *r)
+		case "handle", "handle_path":
+			if len(d.args) != 1 || d.block == nil {
+				return nil, "", fmt.Errorf("line %d: %s needs a path and a block", d.line, d.name)
+			}
+			for _, inner := range d.block {
+				if inner.name != "reverse_proxy" {
+					return nil, "", fmt.Errorf("line %d: unsupported directive %q in %s", inner.line, inner.name, d.name)
+				}
+				r, err := parseReverseProxy(hosts, d.args[0], d.name == "handle_path", inner)
+				if err != nil {
+					return nil, "", err
+				}
+				routes = append(routes, *r)
+			}
+		case "redir":
+			if len(d.args) == 0 {
+				return nil, "", fmt.Errorf("line %d: redir needs a target", d.line)
+			}
+			// liteproxy redirects always keep the path, so {uri} is implied
+			dest, _, _ := strings.Cut(d.args[0], "{")
+			u, err := url.Parse(dest)
+			if err != nil || u.Host == "" {
+				return nil, "", fmt.Errorf("line %d: redir target %q must be an absolute URL", d.line, d.args[0])
+			}
+			target = u.Hostname()
+		default:
+			return nil, "", fmt.Errorf("line %d: unsupported directive %q", d.line, d.name)
+		}
+	}
+	if target != "" && len(routes) > 0 {
+		return nil, "", fmt.Errorf("site %s: redir cannot be combined with reverse_proxy", hosts[0])
+	}
+	if target == "" && len(routes) == 0 {
+		return nil, "", fmt.Errorf("site %s: no reverse_proxy or redir", hosts[0])
+	}
+	return routes, target, nil
+}
+
+// parseReverseProxy parses `reverse_proxy [path] upstreams... [{ ... }]`
+func parseReverseProxy(hosts []string, path string, strip bool, d siteDirective) (*Route, error) {
+	args := d.args
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		if path != "" {
+			return nil, fmt.Errorf("line %d: reverse_proxy inside %s cannot have its own path", d.line, path)
+		}
+		path, args = args[0], args[1:]
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("line %d: reverse_proxy needs at least one upstream", d.line)
+	}
+
+	var backends []string
+	for _, a := range args {
+		addr, err := siteUpstream(a)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", d.line, err)
+		}
+		backends = append(backends, addr)
+	}
+	host, portStr, _ := net.SplitHostPort(backends[0])
+	port, _ := strconv.Atoi(portStr)
+
+	route := &Route{
+		Host:           hosts[0],
+		Aliases:        hosts[1:],
+		PathPrefix:     sitePath(path),
+		ServiceName:    host,
+		ServicePort:    port,
+		PassHostHeader: true, // as Caddy does
+		StripPrefix:    strip,
+	}
+	if len(backends) > 1 {
+		route.Backends = backends
+	}
+
+	for _, sub := range d.block {
+		if err := applyProxyOption(route, sub); err != nil {
+			return nil, fmt.Errorf("line %d: %w", sub.line, err)
+		}
+	}
+	if route.Retry != nil && route.Retry.Attempts == 0 {
+		route.Retry = nil // lb_try_interval alone retries nothing
+	}
+	return route, nil
+}
+
+// applyProxyOption applies one reverse_proxy subdirective to route
+func applyProxyOption(route *Route, d siteDirective) error {
+	arg := func() (string, error) {
+		if len(d.args) != 1 {
+			return "", fmt.Errorf("%s needs one argument", d.name)
+		}
+		return d.args[0], nil
+	}
+	duration := func() (time.Duration, error) {
+		v, err := arg()
+		if err != nil {
+			return 0, err
+		}
+		return time.ParseDuration(v)
+	}
+	healthCheck := func() *HealthCheck {
+		if route.HealthCheck == nil {
+			route.HealthCheck = &HealthCheck{Type: HealthHTTP, Path: "/", Interval: DefaultHealthInterval, Timeout: DefaultHealthTimeout}
+		}
+		return route.HealthCheck
+	}
+	retry := func() *Retry {
+		if route.Retry == nil {
+			route.Retry = &Retry{Backoff: DefaultRetryBackoff}
+		}
+		return route.Retry
+	}
+
+	switch d.name {
+	case "lb_policy":
+		policy, err := arg()
+		if err != nil {
+			return err
+		}
+		if policy == "uri_hash" {
+			policy = balancer.URLHash
+		}
+		if !balancer.Valid(policy) {
+			return fmt.Errorf("invalid lb_policy %q", policy)
+		}
+		route.Balance = policy
+	case "health_uri":
+		p, err := arg()
+		if err != nil {
+			return err
+		}
+		healthCheck().Path = p
+	case "health_interval":
+		v, err := duration()
+		if err != nil {
+			return err
+		}
+		healthCheck().Interval = v
+	case "health_timeout":
+		v, err := duration()
+		if err != nil {
+			return err
+		}
+		healthCheck().Timeout = v
+	case "lb_retries":
+		v, err := arg()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid lb_retries %q", v)
+		}
+		retry().Attempts = n
+	case "lb_try_interval":
+		v, err := duration()
+		if err != nil {
+			return err
+		}
+		if v <= 0 {
+			return fmt.Errorf("invalid lb_try_interval %v", v)
+		}
+		retry().Backoff = v
+	case "flush_interval":
+		v, err := arg()
+		if err != nil {
+			return err
+		}
+		route.FlushImmediately = v == "-1"
+	case "header_up":
+		if len(d.args) == 2 && strings.EqualFold(d.args[0], "Host") {
+			switch d.args[1] {
+			case "{host}", "{http.request.host}":
+				route.PassHostHeader = true
+			case "{upstream_hostport}", "{http.reverse_proxy.upstream.hostport}":
+				route.PassHostHeader = false
+			default:
+				return fmt.Errorf("unsupported Host value %q", d.args[1])
+			}
+			return nil
+		}
+		if route.RequestHeaders == nil {
+			route.RequestHeaders = &HeaderRules{}
+		}
+		return applySiteHeader(route.RequestHeaders, d)
+	case "header_down":
+		if route.ResponseHeaders == nil {
+			route.ResponseHeaders = &HeaderRules{}
+		}
+		return applySiteHeader(route.ResponseHeaders, d)
+	default:
+		return fmt.Errorf("unsupported reverse_proxy option %q", d.name)
+	}
+	return nil
+}
+
+// applySiteHeader applies `Name value`, `+Name value` (add) or `-Name`
+// (remove) to rules
+func applySiteHeader(rules *HeaderRules, d siteDirective) error {
+	if len(d.args) == 0 {
+		return fmt.Errorf("%s needs a header name", d.name)
+	}
+	if name, ok := strings.CutPrefix(d.args[0], "-"); ok {
+		if len(d.args) != 1 {
+			return fmt.Errorf("%s %s takes no value", d.name, d.args[0])
+		}
+		name, err := headerName(d.name, name)
+		if err != nil {
+			return err
+		}
+		rules.Remove = append(rules.Remove, name)
+		return nil
+	}
+	if len(d.args) != 2 {
+		return fmt.Errorf("%s needs a header name and value", d.name)
+	}
+	if strings.Contains(d.args[1], "{") {
+		return fmt.Errorf("%s %s: placeholders are not supported", d.name, d.args[0])
+	}
+	name, add := strings.CutPrefix(d.args[0], "+")
+	name, err := headerName(d.name, name)
+	if err != nil {
+		return err
+	}
+	if add {
+		rules.add(name, d.args[1])
+	} else {
+		rules.set(name, d.args[1])
+	}
+	return nil
+}
+
+// siteHosts splits site addresses, dropping schemes and ports
+func siteHosts(addrs []string) []string {
+	var hosts []string
+	for _, a := range addrs {
+		for _, h := range strings.Split(a, ",") {
+			if h = strings.TrimSpace(h); h == "" {
+				continue
+			}
+			if _, rest, ok := strings.Cut(h, "://"); ok {
+				h = rest
+			}
+			if host, _, err := net.SplitHostPort(h); err == nil {
+				h = host
+			}
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// siteUpstream normalizes an upstream to host:port, defaulting to port 80
+func siteUpstream(s string) (string, error) {
+	if _, rest, ok := strings.Cut(s, "://"); ok {
+		if !strings.HasPrefix(s, "http://") {
+			return "", fmt.Errorf("upstream %q: only http:// upstreams are supported", s)
+		}
+		s = rest
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(s, "80")
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || host == "" {
+		return "", fmt.Errorf("invalid upstream %q", s)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", fmt.Errorf("invalid upstream port in %q", s)
+	}
+	return s, nil
+}
+
+// sitePath turns a path matcher such as /api/* into a path prefix
+func sitePath(matcher string) string {
+	p := strings.TrimRight(strings.TrimSuffix(matcher, "*"), "/")
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+// parseSiteDirectives parses a site file into directives. A directive ends
+// at the end of its line or at a closing brace, and a { on the same line
+// opens its block
+func parseSiteDirectives(src string) ([]siteDirective, error) {
+	tokens, err := tokenizeSiteFile(src)
+	if err != nil {
+		return nil, err
+	}
+	ds, rest, err := parseSiteBlock(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("line %d: unexpected }", rest[0].line)
+	}
+	return ds, nil
+}
+
+func parseSiteBlock(tokens []siteToken) ([]siteDirective, []siteToken, error) {
+	var ds []siteDirective
+	for len(tokens) > 0 {
+		tok := tokens[0]
+		if tok.text == "}" {
+			return ds, tokens, nil
+		}
+		if tok.text == "{" {
+			return nil, nil, fmt.Errorf("line %d: unexpected {", tok.line)
+		}
+
+		d := siteDirective{name: tok.text, line: tok.line}
+		tokens = tokens[1:]
+		for len(tokens) > 0 && tokens[0].line == d.line && tokens[0].text != "{" && tokens[0].text != "}" {
+			d.args = append(d.args, tokens[0].text)
+			tokens = tokens[1:]
+		}
+		if len(tokens) > 0 && tokens[0].text == "{" && tokens[0].line == d.line {
+			block, rest, err := parseSiteBlock(tokens[1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(rest) == 0 {
+				return nil, nil, fmt.Errorf("line %d: %s: missing }", d.line, d.name)
+			}
+			d.block = block
+			if d.block == nil {
+				d.block = []siteDirective{}
+			}
+			tokens = rest[1:]
+		}
+		ds = append(ds, d)
+	}
+	return ds, nil, nil
+}
+
+// tokenizeSiteFile splits a site file into words, dropping comments
+// Braces must be separate words, so placeholders like {host} stay intact
+func tokenizeSiteFile(src string) ([]siteToken, error) {
+	var tokens []siteToken
+	for n, line := range strings.Split(src, "\n") {
+		for line != "" {
+			line = strings.TrimLeft(line, " \t\r")
+			switch {
+			case line == "" || line[0] == '#':
+				line = ""
+			case line[0] == '"':
+				end := strings.IndexByte(line[1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: unterminated string", n+1)
+				}
+				tokens = append(tokens, siteToken{line[1 : end+1], n + 1})
+				line = line[end+2:]
+			default:
+				end := strings.IndexAny(line, " \t\r")
+				if end < 0 {
+					end = len(line)
+				}
+				tokens = append(tokens, siteToken{line[:end], n + 1})
+				line = line[end:]
+			}
+		}
+	}
+	return tokens, nil
+}
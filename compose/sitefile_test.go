@@ -0,0 +1,135 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSiteFile(t *testing.T) {
+	src := `
+# Standalone sites, no compose needed
+example.com www.example.com {
+    reverse_proxy /api/* api-1:8080 api-2:8080 {
+        lb_policy least_conn
+        health_uri /healthz
+        health_interval 5s
+        lb_retries 2
+        lb_try_interval 250ms
+    }
+    handle_path /static/* {
+        reverse_proxy http://assets
+    }
+    reverse_proxy web:3000 {
+        header_up Host {upstream_hostport}
+        header_up X-Tenant acme
+        header_down -Server
+        header_down +Cache-Control no-transform
+        flush_interval -1
+    }
+}
+
+old.example.com, https://legacy.example.com:443 {
+    redir https://example.com{uri}
+}
+
+blog.example.com { reverse_proxy ghost:2368 }
+`
+	routes, err := ParseSiteFile([]byte(src), "Liteproxyfile")
+	if err != nil {
+		t.Fatalf("ParseSiteFile() error = %v", err)
+	}
+	if len(routes) != 4 {
+		t.Fatalf("got %d routes, want 4: %+v", len(routes), routes)
+	}
+
+	api := routes[0]
+	if api.Host != "example.com" || api.PathPrefix != "/api" || api.ServiceName != "api-1" || api.ServicePort != 8080 {
+		t.Errorf("api route = %+v", api)
+	}
+	if !slices.Equal(api.Backends, []string{"api-1:8080", "api-2:8080"}) || api.Balance != "least_conn" {
+		t.Errorf("api backends = %v balance %q", api.Backends, api.Balance)
+	}
+	if hc := api.HealthCheck; hc == nil || hc.Type != HealthHTTP || hc.Path != "/healthz" || hc.Interval != 5*time.Second || hc.Timeout != DefaultHealthTimeout {
+		t.Errorf("api health check = %+v", hc)
+	}
+	if r := api.Retry; r == nil || r.Attempts != 2 || r.Backoff != 250*time.Millisecond {
+		t.Errorf("api retry = %+v", r)
+	}
+	if !slices.Equal(api.Aliases, []string{"www.example.com"}) || !api.PassHostHeader {
+		t.Errorf("api aliases = %v passhost %v, want www alias and passhost", api.Aliases, api.PassHostHeader)
+	}
+	if !slices.Equal(api.RedirectFrom, []string{"old.example.com", "legacy.example.com"}) {
+		t.Errorf("api redirect_from = %v", api.RedirectFrom)
+	}
+
+	static := routes[1]
+	if static.PathPrefix != "/static" || !static.StripPrefix || static.ServiceName != "assets" || static.ServicePort != 80 {
+		t.Errorf("static route = %+v", static)
+	}
+
+	web := routes[2]
+	if web.PathPrefix != "/" || web.PassHostHeader || !web.FlushImmediately || len(web.Backends) != 0 {
+		t.Errorf("web route = %+v", web)
+	}
+	if rh := web.RequestHeaders; rh == nil || rh.Set["X-Tenant"] != "acme" {
+		t.Errorf("web request headers = %+v", rh)
+	}
+	if rh := web.ResponseHeaders; rh == nil || !slices.Equal(rh.Remove, []string{"Server"}) || rh.Add["Cache-Control"] != "no-transform" {
+		t.Errorf("web response headers = %+v", rh)
+	}
+
+	if blog := routes[3]; blog.Host != "blog.example.com" || blog.ServiceName != "ghost" || blog.ServicePort != 2368 {
+		t.Errorf("blog route = %+v", blog)
+	}
+}
+
+func TestParseSiteFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"missing brace", "example.com {\n reverse_proxy web:80\n", "missing }"},
+		{"stray brace", "}\n", "unexpected }"},
+		{"no block", "example.com\n", "expected site addresses"},
+		{"unknown directive", "example.com {\n encode gzip\n}\n", `line 2: unsupported directive "encode"`},
+		{"unknown option", "example.com {\n reverse_proxy web:80 {\n  transport http\n }\n}\n", `line 3: unsupported reverse_proxy option "transport"`},
+		{"no upstream", "example.com {\n reverse_proxy /api/*\n}\n", "needs at least one upstream"},
+		{"https upstream", "example.com {\n reverse_proxy https://web\n}\n", "only http://"},
+		{"bad policy", "example.com {\n reverse_proxy a b {\n  lb_policy random\n }\n}\n", "invalid lb_policy"},
+		{"redir without site", "old.example.com {\n redir https://new.example.com\n}\n", "no site for new.example.com"},
+		{"empty site", "example.com {\n}\n", "no reverse_proxy or redir"},
+		{"bad retries", "example.com {\n reverse_proxy web {\n  lb_retries many\n }\n}\n", "invalid lb_retries"},
+		{"header placeholder", "example.com {\n reverse_proxy web {\n  header_up X-Real-IP {remote_host}\n }\n}\n", "placeholders are not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSiteFile([]byte(tt.src), "Liteproxyfile")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseSiteFile() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFileDetectsSiteFile(t *testing.T) {
+	for _, name := range []string{"Liteproxyfile", "Caddyfile", "sites.liteproxy"} {
+		path := filepath.Join(t.TempDir(), name)
+		os.WriteFile(path, []byte("example.com {\n reverse_proxy web:80\n}\n"), 0o644)
+
+		routes, err := ParseFile(path)
+		if err != nil {
+			t.Fatalf("ParseFile(%s) error = %v", name, err)
+		}
+		if len(routes) != 1 || routes[0].Host != "example.com" {
+			t.Errorf("ParseFile(%s) = %+v", name, routes)
+		}
+	}
+	if IsSiteFile("compose.yaml") {
+		t.Error("IsSiteFile(compose.yaml) = true")
+	}
+}
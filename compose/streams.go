@@ -0,0 +1,52 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Labels for long-lived connections (WebSockets and server-sent events)
+const (
+	LabelStreamsMaxConnections = "liteproxy.streams.max_connections"
+	LabelStreamsIdleTimeout    = "liteproxy.streams.idle_timeout"
+)
+
+// Streams limits the long-lived connections on a route: WebSocket
+// upgrades and server-sent event streams. They are exempt from
+// liteproxy.timeout, so these are what bound them instead.
+type Streams struct {
+	MaxConnections int           `json:"max_connections,omitempty"` // open streams allowed across all clients (0 = unlimited)
+	IdleTimeout    time.Duration `json:"idle_timeout,omitempty"`    // close streams with no data either way for this long (0 = never)
+}
+
+// extractStreams extracts the long-lived connection limits
+func extractStreams(route *Route, labels types.Labels) error {
+	max, idle := labels[LabelStreamsMaxConnections], labels[LabelStreamsIdleTimeout]
+	if max == "" && idle == "" {
+		return nil
+	}
+	if route.Passthrough {
+		return fmt.Errorf("%s is not supported with %s", LabelStreamsMaxConnections, LabelPassthrough)
+	}
+
+	streams := &Streams{}
+	if max != "" {
+		n, err := strconv.Atoi(max)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid %s %q (want a positive number)", LabelStreamsMaxConnections, max)
+		}
+		streams.MaxConnections = n
+	}
+	if idle != "" {
+		d, err := time.ParseDuration(idle)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", LabelStreamsIdleTimeout, idle)
+		}
+		streams.IdleTimeout = d
+	}
+	route.Streams = streams
+	return nil
+}
@@ -0,0 +1,63 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    *Streams
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "max connections", labels: `liteproxy.streams.max_connections: "100"`, want: &Streams{MaxConnections: 100}},
+		{name: "idle timeout", labels: `liteproxy.streams.idle_timeout: "5m"`, want: &Streams{IdleTimeout: 5 * time.Minute}},
+		{
+			name: "both",
+			labels: `liteproxy.streams.max_connections: "10"
+      liteproxy.streams.idle_timeout: "30s"`,
+			want: &Streams{MaxConnections: 10, IdleTimeout: 30 * time.Second},
+		},
+		{name: "zero max", labels: `liteproxy.streams.max_connections: "0"`, wantErr: `invalid liteproxy.streams.max_connections "0"`},
+		{name: "invalid max", labels: `liteproxy.streams.max_connections: "lots"`, wantErr: `invalid liteproxy.streams.max_connections "lots"`},
+		{name: "invalid idle timeout", labels: `liteproxy.streams.idle_timeout: "soon"`, wantErr: `invalid liteproxy.streams.idle_timeout "soon"`},
+		{
+			name: "passthrough",
+			labels: `liteproxy.streams.max_connections: "10"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  chat:
+    image: chat
+    labels:
+      liteproxy.host: "chat.example.com"
+      liteproxy.port: "8080"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].Streams
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Streams = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
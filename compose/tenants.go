@@ -0,0 +1,208 @@
+package compose
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// LabelTenants names a tenants file, turning the route into a template:
+// one route per tenant, with {tenant} in the host replaced by its name
+const LabelTenants = "liteproxy.tenants"
+
+// LabelTenantResolver maps each subdomain of a wildcard route to its own
+// backend, through a lookup file or an HTTP endpoint
+const LabelTenantResolver = "liteproxy.tenant_resolver"
+
+// TenantPlaceholder is replaced by the tenant name in template routes
+const TenantPlaceholder = "{tenant}"
+
+// ReadTenants reads a tenants file: one name per line, blank lines and
+// # comments ignored
+func ReadTenants(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tenants file: %w", err)
+	}
+
+	var tenants []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		name, _, _ := strings.Cut(scanner.Text(), "#")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !validTenant(name) {
+			return nil, fmt.Errorf("%s:%d: invalid tenant %q (want lowercase letters, digits and -)", path, line, name)
+		}
+		if slices.Contains(tenants, name) {
+			return nil, fmt.Errorf("%s:%d: duplicate tenant %q", path, line, name)
+		}
+		tenants = append(tenants, name)
+	}
+	return tenants, scanner.Err()
+}
+
+// validTenant reports whether name can be used as a DNS label
+func validTenant(name string) bool {
+	if len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// expandTenants returns route as is, or one copy per tenant if its labels
+// make it a template. Relative tenants files are found next to the
+// compose file in dir.
+func expandTenants(route Route, labels types.Labels, dir string) ([]Route, error) {
+	path := labels[LabelTenants]
+	templated := strings.Contains(route.Host, TenantPlaceholder)
+	switch {
+	case path == "" && templated:
+		return nil, fmt.Errorf("%s in %s requires %s", TenantPlaceholder, LabelHost, LabelTenants)
+	case path == "":
+		return []Route{route}, nil
+	case !templated:
+		return nil, fmt.Errorf("%s requires %s in %s", LabelTenants, TenantPlaceholder, LabelHost)
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	tenants, err := ReadTenants(path)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]Route, len(tenants))
+	for i, tenant := range tenants {
+		r := route
+		fill := func(s string) string { return strings.ReplaceAll(s, TenantPlaceholder, tenant) }
+		r.Host = fill(r.Host)
+		r.Aliases = fillAll(r.Aliases, fill)
+		r.RedirectFrom = fillAll(r.RedirectFrom, fill)
+		r.RequestHeaders = r.RequestHeaders.fill(fill)
+		r.ResponseHeaders = r.ResponseHeaders.fill(fill)
+		r.Tenant = tenant
+		r.TenantsFile = path
+		routes[i] = r
+	}
+	return routes, nil
+}
+
+func fillAll(values []string, fill func(string) string) []string {
+	if values == nil {
+		return nil
+	}
+	filled := make([]string, len(values))
+	for i, v := range values {
+		filled[i] = fill(v)
+	}
+	return filled
+}
+
+// fill returns a copy of h with fill applied to every value, so a backend
+// can be told which tenant a request is for
+func (h *HeaderRules) fill(fill func(string) string) *HeaderRules {
+	if h == nil {
+		return nil
+	}
+	filled := &HeaderRules{Remove: h.Remove}
+	for name, value := range h.Set {
+		filled.set(name, fill(value))
+	}
+	for name, value := range h.Add {
+		filled.add(name, fill(value))
+	}
+	return filled
+}
+
+// extractTenantResolver sets the route's tenant resolver, loading the
+// backends of a lookup file. Relative paths are found next to the compose
+// file in dir.
+func extractTenantResolver(route *Route, labels types.Labels, dir string) error {
+	resolver := labels[LabelTenantResolver]
+	if resolver == "" {
+		return nil
+	}
+	if !strings.HasPrefix(route.Host, "*.") {
+		return fmt.Errorf("%s requires a wildcard host, got %q", LabelTenantResolver, route.Host)
+	}
+	if strings.HasPrefix(resolver, "http://") || strings.HasPrefix(resolver, "https://") {
+		route.TenantResolver = resolver
+		return nil
+	}
+
+	if !filepath.IsAbs(resolver) {
+		resolver = filepath.Join(dir, resolver)
+	}
+	backends, err := ReadTenantBackends(resolver)
+	if err != nil {
+		return err
+	}
+	route.TenantResolver = resolver
+	route.TenantBackends = backends
+	return nil
+}
+
+// ReadTenantBackends reads a tenant lookup file: one `tenant host:port`
+// per line, blank lines and # comments ignored
+func ReadTenantBackends(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading tenant lookup file: %w", err)
+	}
+
+	backends := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want `tenant host:port`", path, line)
+		}
+		tenant, addr := fields[0], fields[1]
+		if !validTenant(tenant) {
+			return nil, fmt.Errorf("%s:%d: invalid tenant %q (want lowercase letters, digits and -)", path, line, tenant)
+		}
+		if _, ok := backends[tenant]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate tenant %q", path, line, tenant)
+		}
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			return nil, fmt.Errorf("%s:%d: invalid backend %q (want host:port)", path, line, addr)
+		}
+		backends[tenant] = addr
+	}
+	return backends, scanner.Err()
+}
+
+// TenantFiles returns the tenants and tenant lookup files routes were
+// generated from
+func TenantFiles(routes []Route) []string {
+	var files []string
+	for _, r := range routes {
+		if r.TenantsFile != "" && !slices.Contains(files, r.TenantsFile) {
+			files = append(files, r.TenantsFile)
+		}
+		if r.TenantBackends != nil && !slices.Contains(files, r.TenantResolver) {
+			files = append(files, r.TenantResolver)
+		}
+	}
+	return files
+}
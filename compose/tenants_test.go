@@ -0,0 +1,178 @@
+package compose
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTenants(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tenants.txt"), []byte("# customers\nacme\n\nglobex  # trial\n"), 0o644)
+	compose := filepath.Join(dir, "compose.yaml")
+	os.WriteFile(compose, []byte(`
+services:
+  tenant-app:
+    image: app
+    labels:
+      liteproxy.host: "{tenant}.example.com"
+      liteproxy.port: "8080"
+      liteproxy.www: "serve"
+      liteproxy.tenants: "tenants.txt"
+      liteproxy.headers.request.set.X-Tenant: "{tenant}"
+`), 0o644)
+
+	routes, err := ParseFile(compose)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want one per tenant: %+v", len(routes), routes)
+	}
+	for i, tenant := range []string{"acme", "globex"} {
+		r := routes[i]
+		if r.Host != tenant+".example.com" || r.Tenant != tenant || r.ServiceName != "tenant-app" || r.ServicePort != 8080 {
+			t.Errorf("route %d = %+v", i, r)
+		}
+		if len(r.Aliases) != 1 || r.Aliases[0] != "www."+tenant+".example.com" {
+			t.Errorf("route %d aliases = %v", i, r.Aliases)
+		}
+		if got := r.RequestHeaders.Set["X-Tenant"]; got != tenant {
+			t.Errorf("route %d X-Tenant = %q, want %q", i, got, tenant)
+		}
+	}
+	if files := TenantFiles(routes); len(files) != 1 || files[0] != filepath.Join(dir, "tenants.txt") {
+		t.Errorf("TenantFiles() = %v", files)
+	}
+}
+
+func TestParseTenantsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		tenants string
+		want    string
+	}{
+		{"placeholder without tenants", `liteproxy.host: "{tenant}.example.com"`, "", "requires liteproxy.tenants"},
+		{"tenants without placeholder", `liteproxy.host: "app.example.com"
+      liteproxy.tenants: "tenants.txt"`, "acme\n", "requires {tenant}"},
+		{"missing file", `liteproxy.host: "{tenant}.example.com"
+      liteproxy.tenants: "missing.txt"`, "", "reading tenants file"},
+		{"invalid tenant", `liteproxy.host: "{tenant}.example.com"
+      liteproxy.tenants: "tenants.txt"`, "Acme Corp\n", "invalid tenant"},
+		{"duplicate tenant", `liteproxy.host: "{tenant}.example.com"
+      liteproxy.tenants: "tenants.txt"`, "acme\nacme\n", "duplicate tenant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			os.WriteFile(filepath.Join(dir, "tenants.txt"), []byte(tt.tenants), 0o644)
+			compose := filepath.Join(dir, "compose.yaml")
+			os.WriteFile(compose, []byte(`
+services:
+  app:
+    image: app
+    labels:
+      liteproxy.port: "80"
+      `+tt.labels+`
+`), 0o644)
+			_, err := ParseFile(compose)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseFile() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTenantResolver(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tenants.map"), []byte("acme  app-acme:8080\nglobex app-globex:9000 # dedicated\n"), 0o644)
+
+	tests := []struct {
+		name     string
+		labels   string
+		resolver string
+		backends map[string]string
+		wantErr  string
+	}{
+		{
+			name: "lookup file",
+			labels: `liteproxy.host: "*.tenant.com"
+      liteproxy.tenant_resolver: "tenants.map"`,
+			resolver: filepath.Join(dir, "tenants.map"),
+			backends: map[string]string{"acme": "app-acme:8080", "globex": "app-globex:9000"},
+		},
+		{
+			name: "endpoint",
+			labels: `liteproxy.host: "*.tenant.com"
+      liteproxy.tenant_resolver: "http://tenants:9000/resolve"`,
+			resolver: "http://tenants:9000/resolve",
+		},
+		{
+			name: "not wildcard",
+			labels: `liteproxy.host: "app.tenant.com"
+      liteproxy.tenant_resolver: "tenants.map"`,
+			wantErr: "requires a wildcard host",
+		},
+		{
+			name: "missing file",
+			labels: `liteproxy.host: "*.tenant.com"
+      liteproxy.tenant_resolver: "missing.map"`,
+			wantErr: "reading tenant lookup file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "compose.yaml")
+			os.WriteFile(path, []byte(`
+services:
+  app:
+    image: app
+    labels:
+      liteproxy.port: "80"
+      `+tt.labels+`
+`), 0o644)
+			routes, err := ParseFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFile() error = %v", err)
+			}
+			r := routes[0]
+			if r.TenantResolver != tt.resolver || !maps.Equal(r.TenantBackends, tt.backends) {
+				t.Errorf("resolver = %q backends = %v, want %q %v", r.TenantResolver, r.TenantBackends, tt.resolver, tt.backends)
+			}
+		})
+	}
+}
+
+func TestReadTenantBackendsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"missing backend", "acme\n", "want `tenant host:port`"},
+		{"no port", "acme app-acme\n", "invalid backend"},
+		{"bad tenant", "Acme app:80\n", "invalid tenant"},
+		{"duplicate", "acme a:80\nacme b:80\n", "duplicate tenant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.map")
+			os.WriteFile(path, []byte(tt.data), 0o644)
+			_, err := ReadTenantBackends(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadTenantBackends() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
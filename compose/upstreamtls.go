@@ -0,0 +1,110 @@
+package compose
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/localrivet/liteproxy/spiffe"
+)
+
+// Labels for TLS towards the backend
+const (
+	LabelUpstreamTLSCert = "liteproxy.upstream_tls.cert"
+	LabelUpstreamTLSKey  = "liteproxy.upstream_tls.key"
+	LabelUpstreamTLSCA   = "liteproxy.upstream_tls.ca"
+
+	LabelUpstreamTLSServerName         = "liteproxy.upstream_tls.server_name"
+	LabelUpstreamTLSInsecureSkipVerify = "liteproxy.upstream_tls.insecure_skip_verify"
+
+	LabelUpstreamTLSSPIFFEID = "liteproxy.upstream_tls.spiffe_id"
+)
+
+// UpstreamTLS configures HTTPS to a route's backends, authenticating the
+// proxy with a client certificate for backends that require mTLS
+type UpstreamTLS struct {
+	Cert string `json:"cert,omitempty"` // client certificate file (PEM)
+	Key  string `json:"key,omitempty"`  // client private key file (PEM)
+	CA   string `json:"ca,omitempty"`   // CA bundle verifying the backend (empty = system roots)
+
+	// ServerName is sent as SNI and checked against the backend's
+	// certificate instead of the service name
+	ServerName string `json:"server_name,omitempty"`
+	// InsecureSkipVerify accepts any backend certificate. For development only.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	// SPIFFEID is the backend's expected SPIFFE ID, or a bare trust domain.
+	// The proxy's own SVID and the trust bundles come from the Workload API.
+	SPIFFEID string `json:"spiffe_id,omitempty"`
+}
+
+// UsesUpstreamTLS reports whether the route's backends are spoken to over TLS
+func (r *Route) UsesUpstreamTLS() bool {
+	return r.UpstreamTLS != nil || r.Protocol == SchemeHTTPS
+}
+
+// extractUpstreamTLS extracts upstream TLS settings, checking the files
+// load so mistakes show up at startup rather than on the first request
+func extractUpstreamTLS(labels types.Labels) (*UpstreamTLS, error) {
+	t := UpstreamTLS{
+		Cert: labels[LabelUpstreamTLSCert],
+		Key:  labels[LabelUpstreamTLSKey],
+		CA:   labels[LabelUpstreamTLSCA],
+
+		ServerName: labels[LabelUpstreamTLSServerName],
+
+		SPIFFEID: labels[LabelUpstreamTLSSPIFFEID],
+	}
+	if v, ok := labels[LabelUpstreamTLSInsecureSkipVerify]; ok {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q (want true or false)", LabelUpstreamTLSInsecureSkipVerify, v)
+		}
+		t.InsecureSkipVerify = skip
+	}
+	if t == (UpstreamTLS{}) {
+		return nil, nil
+	}
+	if t.InsecureSkipVerify && (t.CA != "" || t.ServerName != "" || t.SPIFFEID != "") {
+		return nil, fmt.Errorf("%s can't be combined with %s, %s or %s: nothing is verified", LabelUpstreamTLSInsecureSkipVerify, LabelUpstreamTLSCA, LabelUpstreamTLSServerName, LabelUpstreamTLSSPIFFEID)
+	}
+	if t.SPIFFEID != "" {
+		if t.Cert != "" || t.Key != "" || t.CA != "" {
+			return nil, fmt.Errorf("%s can't be combined with certificate files: the workload API provides them", LabelUpstreamTLSSPIFFEID)
+		}
+		if _, err := spiffe.ParseID(t.SPIFFEID); err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		return nil, fmt.Errorf("%s and %s must be set together", LabelUpstreamTLSCert, LabelUpstreamTLSKey)
+	}
+	if t.Cert != "" {
+		if _, err := tls.LoadX509KeyPair(t.Cert, t.Key); err != nil {
+			return nil, fmt.Errorf("invalid upstream client certificate: %w", err)
+		}
+	}
+	if t.CA != "" {
+		if _, err := LoadCertPool(t.CA); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in CA bundle %s", path)
+	}
+	return pool, nil
+}
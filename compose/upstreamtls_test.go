@@ -0,0 +1,130 @@
+package compose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a self-signed certificate and its key as PEM files
+func writeSelfSigned(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "liteproxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
+
+func TestParseUpstreamTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeSelfSigned(t, cert, key)
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("not a certificate"), 0o600)
+
+	tests := []struct {
+		name    string
+		labels  string
+		want    *UpstreamTLS
+		wantErr string
+	}{
+		{name: "none"},
+		{
+			name: "client certificate",
+			labels: `liteproxy.upstream_tls.cert: "` + cert + `"
+      liteproxy.upstream_tls.key: "` + key + `"
+      liteproxy.upstream_tls.ca: "` + cert + `"`,
+			want: &UpstreamTLS{Cert: cert, Key: key, CA: cert},
+		},
+		{name: "ca only", labels: `liteproxy.upstream_tls.ca: "` + cert + `"`, want: &UpstreamTLS{CA: cert}},
+		{name: "cert without key", labels: `liteproxy.upstream_tls.cert: "` + cert + `"`, wantErr: "must be set together"},
+		{
+			name: "mismatched files",
+			labels: `liteproxy.upstream_tls.cert: "` + cert + `"
+      liteproxy.upstream_tls.key: "` + garbage + `"`,
+			wantErr: "invalid upstream client certificate",
+		},
+		{name: "bad ca", labels: `liteproxy.upstream_tls.ca: "` + garbage + `"`, wantErr: "no certificates"},
+		{
+			name:   "spiffe id",
+			labels: `liteproxy.upstream_tls.spiffe_id: "spiffe://example.org/ns/prod/sa/api"`,
+			want:   &UpstreamTLS{SPIFFEID: "spiffe://example.org/ns/prod/sa/api"},
+		},
+		{name: "spiffe trust domain", labels: `liteproxy.upstream_tls.spiffe_id: "spiffe://example.org"`, want: &UpstreamTLS{SPIFFEID: "spiffe://example.org"}},
+		{name: "bad spiffe id", labels: `liteproxy.upstream_tls.spiffe_id: "https://example.org/api"`, wantErr: "invalid SPIFFE ID"},
+		{
+			name: "spiffe id with certificate files",
+			labels: `liteproxy.upstream_tls.spiffe_id: "spiffe://example.org/api"
+      liteproxy.upstream_tls.ca: "` + cert + `"`,
+			wantErr: "can't be combined",
+		},
+		{
+			name: "server name",
+			labels: `liteproxy.upstream_tls.ca: "` + cert + `"
+      liteproxy.upstream_tls.server_name: "api.internal"`,
+			want: &UpstreamTLS{CA: cert, ServerName: "api.internal"},
+		},
+		{name: "skip verify", labels: `liteproxy.upstream_tls.insecure_skip_verify: "true"`, want: &UpstreamTLS{InsecureSkipVerify: true}},
+		{name: "skip verify off", labels: `liteproxy.upstream_tls.insecure_skip_verify: "false"`},
+		{name: "invalid skip verify", labels: `liteproxy.upstream_tls.insecure_skip_verify: "sometimes"`, wantErr: `invalid liteproxy.upstream_tls.insecure_skip_verify "sometimes"`},
+		{
+			name: "skip verify with ca",
+			labels: `liteproxy.upstream_tls.insecure_skip_verify: "true"
+      liteproxy.upstream_tls.ca: "` + cert + `"`,
+			wantErr: "nothing is verified",
+		},
+		{
+			name: "http health check",
+			labels: `liteproxy.upstream_tls.ca: "` + cert + `"
+      liteproxy.healthcheck.path: "/healthz"`,
+			wantErr: "requires liteproxy.healthcheck.type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "api.example.com"
+      liteproxy.port: "443"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].UpstreamTLS
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("UpstreamTLS = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
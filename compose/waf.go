@@ -0,0 +1,59 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/localrivet/liteproxy/waf"
+)
+
+// Labels for the request inspection rules
+const (
+	LabelWAF     = "liteproxy.waf"
+	LabelWAFMode = "liteproxy.waf.mode"
+)
+
+// Values for the liteproxy.waf.mode label
+const (
+	WAFModeBlock = "block" // rules act as written
+	WAFModeLog   = "log"   // every match is only logged, for trying rules out
+)
+
+// WAF inspects requests against rules before they're proxied
+type WAF struct {
+	Rules []string `json:"rules"` // rule files, or "builtin" for the embedded set
+	Mode  string   `json:"mode"`
+
+	RuleSet *waf.RuleSet `json:"-"` // compiled when the configuration loads
+}
+
+// extractWAF extracts the WAF settings, compiling the rules so mistakes
+// show up at startup rather than on the first request
+func extractWAF(labels types.Labels) (*WAF, error) {
+	rules := labels[LabelWAF]
+	if rules == "" {
+		if labels[LabelWAFMode] != "" {
+			return nil, fmt.Errorf("%s requires %s", LabelWAFMode, LabelWAF)
+		}
+		return nil, nil
+	}
+	w := &WAF{Mode: WAFModeBlock}
+	for _, src := range strings.Split(rules, ",") {
+		if src = strings.TrimSpace(src); src != "" {
+			w.Rules = append(w.Rules, src)
+		}
+	}
+	switch mode := labels[LabelWAFMode]; mode {
+	case "":
+	case WAFModeBlock, WAFModeLog:
+		w.Mode = mode
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want %s or %s)", LabelWAFMode, mode, WAFModeBlock, WAFModeLog)
+	}
+	var err error
+	if w.RuleSet, err = waf.Load(w.Rules); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
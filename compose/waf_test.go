@@ -0,0 +1,80 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseWAF(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(rules, []byte("rules:\n  - id: no-admin\n    path: '^/admin'\n"), 0o644)
+
+	tests := []struct {
+		name      string
+		labels    string
+		wantRules []string
+		wantMode  string
+		wantErr   string
+	}{
+		{name: "none"},
+		{name: "builtin", labels: `liteproxy.waf: "builtin"`, wantRules: []string{"builtin"}, wantMode: WAFModeBlock},
+		{
+			name: "builtin and file in log mode",
+			labels: `liteproxy.waf: "builtin, ` + rules + `"
+      liteproxy.waf.mode: "log"`,
+			wantRules: []string{"builtin", rules},
+			wantMode:  WAFModeLog,
+		},
+		{name: "missing file", labels: `liteproxy.waf: "/nonexistent/rules.yaml"`, wantErr: "reading WAF rules"},
+		{name: "mode without rules", labels: `liteproxy.waf.mode: "log"`, wantErr: "requires liteproxy.waf"},
+		{
+			name: "invalid mode",
+			labels: `liteproxy.waf: "builtin"
+      liteproxy.waf.mode: "drop"`,
+			wantErr: "invalid liteproxy.waf.mode",
+		},
+		{
+			name: "passthrough",
+			labels: `liteproxy.waf: "builtin"
+      liteproxy.passthrough: "true"`,
+			wantErr: "not supported with liteproxy.passthrough",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yaml := `
+services:
+  app:
+    image: app
+    labels:
+      liteproxy.host: "app.example.com"
+      liteproxy.port: "80"
+      ` + tt.labels + `
+`
+			routes, err := Parse([]byte(yaml), "test.yaml")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := routes[0].WAF
+			if tt.wantRules == nil {
+				if got != nil {
+					t.Errorf("WAF = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || !slices.Equal(got.Rules, tt.wantRules) || got.Mode != tt.wantMode || got.RuleSet == nil {
+				t.Errorf("WAF = %+v, want rules %v in %s mode, compiled", got, tt.wantRules, tt.wantMode)
+			}
+		})
+	}
+}
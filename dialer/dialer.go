@@ -0,0 +1,70 @@
+// Package dialer connects to backends over overlay networks and tunnels,
+// for routes with a liteproxy.dial label
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Dialer opens connections to a route's backends
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Provider builds a Dialer from a liteproxy.dial URL. Providers check the
+// URL and any files it names, but connect lazily on the first dial.
+type Provider func(u *url.URL) (Dialer, error)
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"iface":  newInterface,
+		"socks5": newSOCKS5,
+		"ssh":    newSSH,
+	}
+)
+
+// Register makes a provider available under scheme, replacing any
+// existing one. Call it from an init function.
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = p
+}
+
+// New returns a Dialer for spec, a URL such as iface://wg0,
+// socks5://127.0.0.1:1055 or ssh://deploy@bastion?key=/keys/id_ed25519
+func New(spec string) (Dialer, error) {
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("invalid dialer %q (want scheme://...)", spec)
+	}
+	mu.RLock()
+	p, ok := providers[u.Scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown dialer %q (want one of %v)", u.Scheme, Schemes())
+	}
+	d, err := p(u)
+	if err != nil {
+		return nil, fmt.Errorf("dialer %s: %w", u.Redacted(), err)
+	}
+	return d, nil
+}
+
+// Schemes returns the registered provider schemes, sorted
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	schemes := make([]string, 0, len(providers))
+	for s := range providers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
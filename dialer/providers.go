@@ -0,0 +1,162 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// dialTimeout bounds connecting to a tunnel endpoint
+const dialTimeout = 30 * time.Second
+
+// iface dials from the first address of a network interface, such as a
+// WireGuard tunnel: iface://wg0
+type iface struct {
+	name string
+}
+
+func newInterface(u *url.URL) (Dialer, error) {
+	if u.Host == "" {
+		return nil, errors.New("missing interface name (want iface://wg0)")
+	}
+	return iface{name: u.Host}, nil
+}
+
+// DialContext looks the interface up on every dial, so it may come up
+// after liteproxy starts
+func (d iface) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	ifi, err := net.InterfaceByName(d.name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			nd := net.Dialer{Timeout: dialTimeout, LocalAddr: &net.TCPAddr{IP: ipnet.IP}}
+			return nd.DialContext(ctx, network, addr)
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no addresses", d.name)
+}
+
+// newSOCKS5 dials through a SOCKS5 proxy, such as Tailscale's userspace
+// proxy or `ssh -D`: socks5://[user:pass@]host:port
+func newSOCKS5(u *url.URL) (Dialer, error) {
+	if u.Port() == "" {
+		return nil, errors.New("missing port (want socks5://host:port)")
+	}
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{Timeout: dialTimeout})
+	if err != nil {
+		return nil, err
+	}
+	return d.(proxy.ContextDialer), nil
+}
+
+// sshTunnel dials through an SSH server, sharing one connection to it:
+// ssh://user@host[:port]?key=/path/to/key[&known_hosts=/path]
+type sshTunnel struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+func newSSH(u *url.URL) (Dialer, error) {
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("missing user (want ssh://user@host)")
+	}
+	q := u.Query()
+	keyFile := q.Get("key")
+	if keyFile == "" {
+		return nil, errors.New("missing key (want ?key=/path/to/key)")
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parsing key %s: %w", keyFile, err)
+	}
+
+	knownHostsFile := q.Get("known_hosts")
+	if knownHostsFile == "" {
+		home, _ := os.UserHomeDir()
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("host keys are required: %w", err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	return &sshTunnel{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeys,
+			Timeout:         dialTimeout,
+		},
+	}, nil
+}
+
+func (d *sshTunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.DialContext(ctx, network, addr)
+}
+
+// connect returns the SSH connection, reconnecting if it was lost
+func (d *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	conn, err := (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.addr, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	d.client = client
+	go func() {
+		client.Wait()
+		d.mu.Lock()
+		if d.client == client {
+			d.client = nil
+		}
+		d.mu.Unlock()
+	}()
+	return client, nil
+}
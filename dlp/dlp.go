@@ -0,0 +1,165 @@
+// Package dlp finds sensitive data, such as card numbers and stack traces,
+// in response bodies, so accidental leaks can be masked or blocked
+package dlp
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// Builtin names the embedded pattern set in a list of pattern sources
+const Builtin = "builtin"
+
+// DefaultMask replaces masked matches unless a pattern sets its own
+const DefaultMask = "[REDACTED]"
+
+// Pattern actions
+const (
+	ActionMask  = "mask"  // replace each match (the default)
+	ActionBlock = "block" // refuse the whole response
+	ActionLog   = "log"   // only log and count matches
+)
+
+//go:embed builtin.yaml
+var builtinPatterns []byte
+
+// Pattern is a regular expression, in Go (RE2) syntax, for data that
+// must not leave the network
+type Pattern struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description,omitempty"`
+	Pattern     string `yaml:"pattern"`
+	Action      string `yaml:"action,omitempty"`
+	Mask        string `yaml:"mask,omitempty"` // replacement for masked matches
+	Luhn        bool   `yaml:"luhn,omitempty"` // only match digit runs passing the Luhn check, for card numbers
+
+	re *regexp.Regexp
+}
+
+// Set is a compiled list of patterns
+type Set struct {
+	patterns []*Pattern
+}
+
+// Finding counts the matches of one pattern in a body
+type Finding struct {
+	Pattern *Pattern
+	Count   int
+}
+
+// Load reads and compiles patterns from sources, each a patterns file or Builtin
+func Load(sources []string) (*Set, error) {
+	s := &Set{}
+	ids := make(map[string]string)
+	for _, src := range sources {
+		data := builtinPatterns
+		if src != Builtin {
+			var err error
+			if data, err = os.ReadFile(src); err != nil {
+				return nil, fmt.Errorf("reading DLP patterns: %w", err)
+			}
+		}
+		patterns, err := parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("DLP patterns %s: %w", src, err)
+		}
+		for _, p := range patterns {
+			if prev, ok := ids[p.ID]; ok {
+				return nil, fmt.Errorf("DLP patterns %s: pattern %q already defined in %s", src, p.ID, prev)
+			}
+			ids[p.ID] = src
+		}
+		s.patterns = append(s.patterns, patterns...)
+	}
+	return s, nil
+}
+
+func parse(data []byte) ([]*Pattern, error) {
+	var file struct {
+		Patterns []*Pattern `yaml:"patterns"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for i, p := range file.Patterns {
+		if p.ID == "" {
+			return nil, fmt.Errorf("pattern %d has no id", i+1)
+		}
+		switch p.Action {
+		case "":
+			p.Action = ActionMask
+		case ActionMask, ActionBlock, ActionLog:
+		default:
+			return nil, fmt.Errorf("pattern %s: invalid action %q (want %s, %s or %s)", p.ID, p.Action, ActionMask, ActionBlock, ActionLog)
+		}
+		if p.Pattern == "" {
+			return nil, fmt.Errorf("pattern %s has no pattern", p.ID)
+		}
+		var err error
+		if p.re, err = regexp.Compile(p.Pattern); err != nil {
+			return nil, fmt.Errorf("pattern %s: %w", p.ID, err)
+		}
+		if p.Mask == "" {
+			p.Mask = DefaultMask
+		}
+	}
+	return file.Patterns, nil
+}
+
+// Len returns the number of patterns
+func (s *Set) Len() int {
+	return len(s.patterns)
+}
+
+// Scan looks for every pattern in body, in order. It returns the body with
+// the matches of mask patterns replaced, and what was found.
+func (s *Set) Scan(body []byte) ([]byte, []Finding) {
+	var findings []Finding
+	for _, p := range s.patterns {
+		count := 0
+		body = p.re.ReplaceAllFunc(body, func(m []byte) []byte {
+			if p.Luhn && !luhn(m) {
+				return m
+			}
+			count++
+			if p.Action == ActionMask {
+				return []byte(p.Mask)
+			}
+			return m
+		})
+		if count > 0 {
+			findings = append(findings, Finding{Pattern: p, Count: count})
+		}
+	}
+	return body, findings
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum; separators
+// such as spaces and dashes are skipped
+func luhn(s []byte) bool {
+	digits := bytes.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+	if len(digits) < 12 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
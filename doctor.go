@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+	liteTLS "github.com/localrivet/liteproxy/tls"
+)
+
+// Doctor check outcomes
+const (
+	doctorPass = "PASS"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+	doctorSkip = "SKIP"
+)
+
+// maxClockSkew is the largest clock difference to the ACME server that
+// still passes
+const maxClockSkew = time.Minute
+
+// doctorTimeout bounds each network check
+const doctorTimeout = 5 * time.Second
+
+// doctorResult is the outcome of one doctor check
+type doctorResult struct {
+	status string
+	name   string
+	detail string
+}
+
+// doctor runs the checks behind `liteproxy doctor`
+type doctor struct {
+	cfg Config
+
+	// Swappable for tests
+	listen   func(network, addr string) (net.Listener, error)
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	preCheck func(ctx context.Context, host string) liteTLS.PreCheckResult
+	client   *http.Client
+	acmeURL  string
+	now      func() time.Time
+}
+
+// runDoctor implements `liteproxy doctor`: check the usual first-run
+// problems and print a pass/fail report
+func runDoctor(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	files := fs.String("f", strings.Join(getEnvList("LITEPROXY_COMPOSE_FILE", defaultComposeFiles()), ","),
+		"compose files (comma-separated)")
+	dir := fs.String("d", os.Getenv("LITEPROXY_COMPOSE_DIR"), "compose directory")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg := loadConfig()
+	cfg.ComposeFiles = splitList(*files)
+	cfg.ComposeDir = *dir
+	slog.SetDefault(slog.New(slog.DiscardHandler)) // the report covers what the pre-checker would log
+	preChecker, err := liteTLS.NewPreChecker(cfg.PublicIPs)
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy doctor: %v\n", err)
+		return 2
+	}
+
+	d := &doctor{
+		cfg:      cfg,
+		listen:   net.Listen,
+		dial:     (&net.Dialer{Timeout: doctorTimeout}).DialContext,
+		preCheck: preChecker.Check,
+		client:   &http.Client{Timeout: doctorTimeout},
+		acmeURL:  cfg.ACMEDirectory,
+		now:      time.Now,
+	}
+	return d.report(d.run(context.Background()), stdout)
+}
+
+// run performs every check, returning results in a stable order
+func (d *doctor) run(ctx context.Context) []doctorResult {
+	var checks []func() doctorResult
+
+	files, err := d.cfg.configFiles()
+	var routes []compose.Route
+	if err == nil {
+		routes, err = compose.ParseFiles(files)
+	}
+	checks = append(checks, func() doctorResult {
+		if err != nil {
+			return doctorResult{doctorFail, "config", err.Error()}
+		}
+		return doctorResult{doctorPass, "config", fmt.Sprintf("%d routes from %s", len(routes), strings.Join(files, ", "))}
+	})
+
+	if d.cfg.HTTPEnabled {
+		checks = append(checks, func() doctorResult { return d.checkPort(d.cfg.HTTPPort) })
+	}
+	if d.cfg.HTTPSEnabled {
+		checks = append(checks, func() doctorResult { return d.checkPort(d.cfg.HTTPSPort) })
+	}
+
+	if err == nil {
+		for _, host := range router.New(routes).Hosts() {
+			checks = append(checks, func() doctorResult { return d.checkDNS(ctx, host) })
+		}
+		seen := make(map[string]bool)
+		sniPorts := make(map[int]bool) // shared by passthrough presets
+		for _, route := range routes {
+			if route.TCPPort != 0 {
+				checks = append(checks, func() doctorResult { return d.checkPort(route.TCPPort) })
+			}
+			for _, port := range route.SNIPorts {
+				if !sniPorts[port] {
+					sniPorts[port] = true
+					checks = append(checks, func() doctorResult { return d.checkPort(port) })
+				}
+			}
+			if route.IsForward() && route.TCPPort == 0 {
+				checks = append(checks, func() doctorResult {
+					return doctorResult{doctorSkip, fmt.Sprintf("backend %s:%d", route.DialHost(), route.ServicePort), "UDP backends can't be probed"}
+				})
+				continue
+			}
+			if route.Dial != "" {
+				checks = append(checks, func() doctorResult {
+					via := route.Dial
+					if u, err := url.Parse(via); err == nil {
+						via = u.Redacted()
+					}
+					return doctorResult{doctorSkip, "backend " + route.Host, "reached through " + via}
+				})
+				continue
+			}
+			for _, addr := range route.Addrs() {
+				if !seen[addr] {
+					seen[addr] = true
+					checks = append(checks, func() doctorResult { return d.checkBackend(ctx, addr) })
+				}
+			}
+		}
+	}
+
+	if d.cfg.HTTPSEnabled {
+		checks = append(checks, d.checkCertCache)
+	}
+	switch {
+	case d.cfg.HTTPSEnabled && d.cfg.TLSMode == liteTLS.ModeLocal:
+		checks = append(checks, func() doctorResult {
+			return doctorResult{doctorSkip, "acme", "certificates come from the local CA"}
+		})
+	case d.cfg.HTTPSEnabled:
+		var resp *http.Response
+		var respErr error
+		var once sync.Once
+		directory := func() (*http.Response, error) {
+			once.Do(func() { resp, respErr = d.fetchDirectory(ctx) })
+			return resp, respErr
+		}
+		checks = append(checks,
+			func() doctorResult { return d.checkACME(directory) },
+			func() doctorResult { return d.checkClock(directory) })
+	default:
+		checks = append(checks, func() doctorResult {
+			return doctorResult{doctorSkip, "certificates", "HTTPS is not enabled"}
+		})
+	}
+
+	// Network checks are slow, so run them side by side
+	results := make([]doctorResult, len(checks))
+	sem := make(chan struct{}, 16)
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = check()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// report prints results and returns the exit code: 1 if anything failed
+func (d *doctor) report(results []doctorResult, w io.Writer) int {
+	failed, warned := 0, 0
+	for _, r := range results {
+		fmt.Fprintf(w, "%s  %-28s %s\n", r.status, r.name, r.detail)
+		switch r.status {
+		case doctorFail:
+			failed++
+		case doctorWarn:
+			warned++
+		}
+	}
+	fmt.Fprintf(w, "\n%d checks: %d failed, %d warnings\n", len(results), failed, warned)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkPort verifies the proxy could listen on port
+func (d *doctor) checkPort(port int) doctorResult {
+	name := "port " + strconv.Itoa(port)
+	ln, err := d.listen("tcp", ":"+strconv.Itoa(port))
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return doctorResult{doctorWarn, name, "already in use: fine if liteproxy is running, otherwise another process holds it"}
+	case errors.Is(err, syscall.EACCES):
+		return doctorResult{doctorFail, name, "permission denied: run as root or grant CAP_NET_BIND_SERVICE"}
+	case err != nil:
+		return doctorResult{doctorFail, name, err.Error()}
+	}
+	ln.Close()
+	return doctorResult{doctorPass, name, "bindable"}
+}
+
+// checkDNS verifies host resolves to this machine
+func (d *doctor) checkDNS(ctx context.Context, host string) doctorResult {
+	name := "dns " + host
+	if strings.HasPrefix(host, "*.") {
+		return doctorResult{doctorSkip, name, "wildcard host, check a concrete subdomain instead"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	result := d.preCheck(ctx, host)
+	switch {
+	case result.Error != "":
+		return doctorResult{doctorFail, name, result.Error}
+	case result.Warning != "":
+		return doctorResult{doctorWarn, name, result.Warning}
+	}
+	return doctorResult{doctorPass, name, "points at this server"}
+}
+
+// checkBackend verifies a backend accepts connections
+func (d *doctor) checkBackend(ctx context.Context, addr string) doctorResult {
+	name := "backend " + addr
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	conn, err := d.dial(ctx, "tcp", addr)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return doctorResult{doctorFail, name, "does not resolve: is liteproxy on the same network as the service?"}
+		}
+		return doctorResult{doctorFail, name, err.Error()}
+	}
+	conn.Close()
+	return doctorResult{doctorPass, name, "reachable"}
+}
+
+// checkCertCache verifies certificates can be stored
+func (d *doctor) checkCertCache() doctorResult {
+	name := "cert cache " + d.cfg.ACMEDir
+	if err := os.MkdirAll(d.cfg.ACMEDir, 0o700); err != nil {
+		return doctorResult{doctorFail, name, err.Error()}
+	}
+	f, err := os.CreateTemp(d.cfg.ACMEDir, ".doctor-*")
+	if err != nil {
+		return doctorResult{doctorFail, name, fmt.Sprintf("not writable: %v", err)}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return doctorResult{doctorPass, name, "writable"}
+}
+
+// fetchDirectory requests the ACME directory, whose response also carries
+// the server's clock
+func (d *doctor) fetchDirectory(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.acmeURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// checkACME verifies the ACME directory answers
+func (d *doctor) checkACME(directory func() (*http.Response, error)) doctorResult {
+	resp, err := directory()
+	switch {
+	case err != nil:
+		return doctorResult{doctorFail, "acme", fmt.Sprintf("%s unreachable: %v", d.acmeURL, err)}
+	case resp.StatusCode != http.StatusOK:
+		return doctorResult{doctorFail, "acme", fmt.Sprintf("%s answered %s", d.acmeURL, resp.Status)}
+	}
+	return doctorResult{doctorPass, "acme", d.acmeURL + " reachable"}
+}
+
+// checkClock compares the local clock with the ACME server's
+func (d *doctor) checkClock(directory func() (*http.Response, error)) doctorResult {
+	resp, err := directory()
+	if err != nil {
+		return doctorResult{doctorSkip, "clock", "ACME server unreachable"}
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return doctorResult{doctorSkip, "clock", "ACME server sent no Date header"}
+	}
+	skew := d.now().Sub(date).Round(time.Second)
+	if skew.Abs() > maxClockSkew {
+		return doctorResult{doctorFail, "clock", fmt.Sprintf("%v off from the ACME server: fix NTP, certificates may be rejected as not yet valid", skew)}
+	}
+	return doctorResult{doctorPass, "clock", fmt.Sprintf("within %v of the ACME server", maxClockSkew)}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	liteTLS "github.com/localrivet/liteproxy/tls"
+)
+
+const doctorCompose = `
+services:
+  api:
+    image: api
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "8080"
+  web:
+    image: web
+    labels:
+      liteproxy.host: "stale.example.com"
+      liteproxy.port: "80"
+`
+
+func TestDoctor(t *testing.T) {
+	acmeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"newNonce":"..."}`))
+	}))
+	defer acmeServer.Close()
+
+	d := &doctor{
+		cfg: Config{
+			ComposeFiles: []string{writeCompose(t, doctorCompose)},
+			HTTPEnabled:  true, HTTPPort: 80,
+			HTTPSEnabled: true, HTTPSPort: 443,
+			ACMEDir: t.TempDir(),
+		},
+		listen: func(network, addr string) (net.Listener, error) {
+			if addr == ":443" {
+				return nil, &net.OpError{Op: "listen", Net: network, Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}
+			}
+			return net.Listen("tcp", "127.0.0.1:0")
+		},
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == "web:80" {
+				return nil, &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Name: "web", IsNotFound: true}}
+			}
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		},
+		preCheck: func(ctx context.Context, host string) liteTLS.PreCheckResult {
+			if host == "stale.example.com" {
+				return liteTLS.PreCheckResult{Host: host, Error: "DNS mismatch: stale.example.com resolves to 192.0.2.1"}
+			}
+			return liteTLS.PreCheckResult{Host: host}
+		},
+		client:  acmeServer.Client(),
+		acmeURL: acmeServer.URL,
+		now:     func() time.Time { return time.Now().Add(3 * time.Minute) },
+	}
+
+	results := d.run(context.Background())
+	want := map[string]string{
+		"config":                      doctorPass,
+		"port 80":                     doctorPass,
+		"port 443":                    doctorWarn,
+		"dns example.com":             doctorPass,
+		"dns stale.example.com":       doctorFail,
+		"backend api:8080":            doctorPass,
+		"backend web:80":              doctorFail,
+		"cert cache " + d.cfg.ACMEDir: doctorPass,
+		"acme":                        doctorPass,
+		"clock":                       doctorFail,
+	}
+	if len(results) != len(want) {
+		t.Errorf("got %d results, want %d: %+v", len(results), len(want), results)
+	}
+	for _, r := range results {
+		if want[r.name] != r.status {
+			t.Errorf("%s: %s (%s), want %s", r.name, r.status, r.detail, want[r.name])
+		}
+	}
+	if entries, _ := os.ReadDir(d.cfg.ACMEDir); len(entries) != 0 {
+		t.Errorf("cert cache check left files behind: %v", entries)
+	}
+
+	var out bytes.Buffer
+	if code := d.report(results, &out); code != 1 {
+		t.Errorf("report() = %d, want 1 with failures", code)
+	}
+	if !strings.Contains(out.String(), "10 checks: 3 failed, 1 warnings") {
+		t.Errorf("report summary missing:\n%s", out.String())
+	}
+}
+
+func TestDoctorHTTPOnly(t *testing.T) {
+	d := &doctor{
+		cfg: Config{
+			ComposeFiles: []string{writeCompose(t, "services:\n  api:\n    image: api\n    labels:\n      liteproxy.host: \"*.example.com\"\n      liteproxy.port: \"80\"\n")},
+			HTTPEnabled:  true, HTTPPort: 80,
+		},
+		listen: func(network, addr string) (net.Listener, error) { return net.Listen("tcp", "127.0.0.1:0") },
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		},
+	}
+
+	var out bytes.Buffer
+	if code := d.report(d.run(context.Background()), &out); code != 0 {
+		t.Errorf("report() = %d, want 0:\n%s", code, out.String())
+	}
+	for _, want := range []string{"SKIP  dns *.example.com", "SKIP  certificates"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Package egress is a forward proxy for the compose stack's outbound
+// calls: CONNECT tunnels and absolute-URI HTTP requests, limited to an
+// allowlist of destinations and to clients with proxy credentials
+package egress
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+// Outcomes of an egress request
+const (
+	ResultAllowed      = "allowed"
+	ResultDenied       = "denied"
+	ResultUnauthorized = "unauthorized"
+	ResultError        = "error"
+)
+
+var egressRequests = metrics.Default.NewCounterVec(
+	"liteproxy_egress_requests_total",
+	"Requests to the egress proxy, by result.",
+	"result")
+
+// dialTimeout bounds connecting to a destination
+const dialTimeout = 10 * time.Second
+
+// rule is one allowlist entry
+type rule struct {
+	host   string       // exact host, or the parent of a *. wildcard
+	suffix bool         // host matches subdomains of host, not host itself
+	prefix netip.Prefix // IP destinations, instead of host
+	port   string       // "" for any port
+}
+
+func (r rule) matches(host, port string) bool {
+	if r.port != "" && r.port != port {
+		return false
+	}
+	if r.prefix.IsValid() {
+		addr, err := netip.ParseAddr(host)
+		return err == nil && r.prefix.Contains(addr.Unmap())
+	}
+	if r.suffix {
+		return strings.HasSuffix(host, "."+r.host)
+	}
+	return host == r.host
+}
+
+// Proxy is the egress proxy's http.Handler
+type Proxy struct {
+	allow []rule
+	users map[string]string // user → password
+
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+	forward *httputil.ReverseProxy
+}
+
+// New returns a Proxy allowing the destinations in allow, each a host,
+// *.parent for its subdomains, or an IP or CIDR, optionally with :port.
+// Clients authenticate with Basic proxy credentials from users, each
+// user:password.
+func New(allow, users []string) (*Proxy, error) {
+	if len(allow) == 0 {
+		return nil, fmt.Errorf("no allowed destinations")
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users")
+	}
+	p := &Proxy{users: make(map[string]string, len(users))}
+	for _, entry := range allow {
+		r, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		p.allow = append(p.allow, r)
+	}
+	for _, u := range users {
+		name, password, ok := strings.Cut(u, ":")
+		if !ok || name == "" || password == "" {
+			return nil, fmt.Errorf("invalid user %q (want user:password)", name)
+		}
+		p.users[name] = password
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	p.dial = dialer.DialContext
+	p.forward = &httputil.ReverseProxy{
+		// The request already names its destination; no X-Forwarded-*
+		// headers, so the stack's internal addresses don't leak
+		Rewrite: func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{
+			DialContext:           func(ctx context.Context, network, addr string) (net.Conn, error) { return p.dial(ctx, network, addr) },
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: 60 * time.Second,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Warn("egress failed", "dest", r.URL.Host, "err", err)
+			egressRequests.Inc(ResultError)
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		},
+	}
+	return p, nil
+}
+
+func parseRule(entry string) (rule, error) {
+	var r rule
+	host := strings.ToLower(strings.TrimSpace(entry))
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		host, r.port = h, port
+	}
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		r.prefix = prefix.Masked()
+		return r, nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		r.prefix = netip.PrefixFrom(addr, addr.BitLen())
+		return r, nil
+	}
+	if parent, ok := strings.CutPrefix(host, "*."); ok {
+		host, r.suffix = parent, true
+	}
+	if host == "" || strings.ContainsAny(host, "*/") {
+		return r, fmt.Errorf("invalid destination %q (want host, *.parent, IP or CIDR, with an optional :port)", entry)
+	}
+	r.host = host
+	return r, nil
+}
+
+// allowed reports whether hostport may be reached
+func (p *Proxy) allowed(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, r := range p.allow {
+		if r.matches(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the user named by r's proxy credentials, or false
+func (p *Proxy) authenticate(r *http.Request) (string, bool) {
+	auth, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return "", false
+	}
+	// Reuse the Authorization parser on the proxy credentials
+	user, password, ok := (&http.Request{Header: http.Header{"Authorization": {"Basic " + auth}}}).BasicAuth()
+	if !ok {
+		return "", false
+	}
+	want, known := p.users[user]
+	if subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 || !known {
+		return "", false
+	}
+	return user, true
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.authenticate(r)
+	if !ok {
+		egressRequests.Inc(ResultUnauthorized)
+		w.Header().Set("Proxy-Authenticate", `Basic realm="liteproxy egress"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	dest := r.Host
+	if r.Method != http.MethodConnect {
+		if !r.URL.IsAbs() || r.URL.Scheme != "http" {
+			http.Error(w, "egress proxy requests need an absolute http:// URL; use CONNECT for https", http.StatusBadRequest)
+			return
+		}
+		dest = r.URL.Host
+		if r.URL.Port() == "" {
+			dest = net.JoinHostPort(r.URL.Hostname(), "80")
+		}
+	}
+	if !p.allowed(dest) {
+		egressRequests.Inc(ResultDenied)
+		slog.Warn("egress denied", "dest", dest, "user", user, "client", r.RemoteAddr)
+		http.Error(w, "destination not allowed", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	egressRequests.Inc(ResultAllowed)
+	p.forward.ServeHTTP(w, r)
+}
+
+// tunnel connects the client to r's destination and copies bytes both
+// ways until the destination closes
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		egressRequests.Inc(ResultError)
+		slog.Warn("egress failed", "dest", r.Host, "err", err)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	client, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		egressRequests.Inc(ResultError)
+		http.Error(w, "tunnels need HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	defer client.Close()
+	egressRequests.Inc(ResultAllowed)
+
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+	go func() {
+		// Bytes the client sent after the CONNECT are already buffered.
+		// Once the client is done sending, let the destination know but
+		// keep reading its reply.
+		io.Copy(upstream, brw.Reader)
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+	}()
+	io.Copy(client, upstream)
+}
@@ -0,0 +1,142 @@
+package egress
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	p, err := New([]string{"api.example.com:443", "*.amazonaws.com", "10.0.0.0/8:5432", "192.0.2.7"}, []string{"app:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dest string
+		want bool
+	}{
+		{"api.example.com:443", true},
+		{"API.example.com.:443", true},
+		{"api.example.com:80", false},
+		{"www.example.com:443", false},
+		{"s3.amazonaws.com:443", true},
+		{"amazonaws.com:443", false},
+		{"evil-amazonaws.com:443", false},
+		{"10.1.2.3:5432", true},
+		{"10.1.2.3:22", false},
+		{"192.0.2.7:8080", true},
+		{"192.0.2.8:8080", false},
+		{"api.example.com", false}, // no port
+	}
+	for _, tt := range tests {
+		if got := p.allowed(tt.dest); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.dest, got, tt.want)
+		}
+	}
+
+	for _, bad := range [][2][]string{
+		{nil, {"app:secret"}},
+		{{"example.com"}, nil},
+		{{"*"}, {"app:secret"}},
+		{{"example.com/path"}, {"app:secret"}},
+		{{"example.com"}, {"app"}},
+	} {
+		if _, err := New(bad[0], bad[1]); err == nil {
+			t.Errorf("New(%q, %q) accepted", bad[0], bad[1])
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" || r.Header.Get("X-Forwarded-For") != "" {
+			t.Errorf("destination saw proxy headers: %v", r.Header)
+		}
+		io.WriteString(w, "plain")
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer secure.Close()
+	denied := httptest.NewServer(http.NotFoundHandler())
+	defer denied.Close()
+
+	p, err := New([]string{host(plain.URL), host(secure.URL)}, []string{"app:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	client := func(user *url.Userinfo) *http.Client {
+		proxyURL, _ := url.Parse(front.URL)
+		proxyURL.User = user
+		transport := secure.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		return &http.Client{Transport: transport}
+	}
+
+	tests := []struct {
+		name     string
+		user     *url.Userinfo
+		target   string
+		wantCode int
+		wantBody string
+		wantErr  bool
+	}{
+		{name: "http", user: url.UserPassword("app", "secret"), target: plain.URL, wantCode: 200, wantBody: "plain"},
+		{name: "connect", user: url.UserPassword("app", "secret"), target: secure.URL, wantCode: 200, wantBody: "secure"},
+		{name: "no credentials", target: plain.URL, wantCode: http.StatusProxyAuthRequired},
+		{name: "wrong password", user: url.UserPassword("app", "guess"), target: plain.URL, wantCode: http.StatusProxyAuthRequired},
+		{name: "unknown user", user: url.UserPassword("other", "secret"), target: plain.URL, wantCode: http.StatusProxyAuthRequired},
+		{name: "denied destination", user: url.UserPassword("app", "secret"), target: denied.URL, wantCode: http.StatusForbidden},
+		{name: "denied tunnel", user: url.UserPassword("app", "secret"), target: "https://" + host(denied.URL), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := client(tt.user).Get(tt.target)
+			if tt.wantErr {
+				if err == nil {
+					res.Body.Close()
+					t.Fatal("request succeeded, want the tunnel refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantCode)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if tt.wantCode == http.StatusProxyAuthRequired && res.Header.Get("Proxy-Authenticate") == "" {
+				t.Error("407 without Proxy-Authenticate")
+			}
+		})
+	}
+
+	// Origin-form requests aren't proxied
+	req, _ := http.NewRequest("GET", front.URL+"/", nil)
+	req.Header.Set("Proxy-Authorization", "Basic YXBwOnNlY3JldA==")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("origin-form request: status %d, want 400", res.StatusCode)
+	}
+}
+
+// host returns the host:port of a test server URL
+func host(raw string) string {
+	u, _ := url.Parse(raw)
+	return u.Host
+}
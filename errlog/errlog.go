@@ -0,0 +1,148 @@
+// Package errlog rate-limits repetitive error logging. During an outage
+// every request can fail the same way; only the first few messages per
+// source and error class are logged each interval, and the rest are
+// summarized when the interval ends.
+package errlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+// Defaults for Default, changed with Configure
+const (
+	DefaultInterval = time.Minute
+	DefaultBurst    = 5
+)
+
+// Error classes returned by Class
+const (
+	ClassRefused  = "refused"
+	ClassTimeout  = "timeout"
+	ClassReset    = "reset"
+	ClassDNS      = "dns"
+	ClassCanceled = "canceled"
+	ClassOther    = "other"
+)
+
+var suppressedTotal = metrics.Default.NewCounterVec(
+	"liteproxy_log_suppressed_total",
+	"Error log lines suppressed as repeats of recent ones, by source.",
+	"source")
+
+// Default is the limiter used by the proxy's error logging
+var Default = New(DefaultInterval, DefaultBurst)
+
+// Limiter logs at most burst messages per key each interval
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // 0 logs everything
+	burst    int
+	windows  map[string]*window
+
+	output func(msg string) // swappable for tests
+}
+
+// window counts one key's messages since its first in the interval
+type window struct {
+	source     string
+	logged     int
+	suppressed int
+	last       string // the latest suppressed message, quoted in the summary
+}
+
+// New creates a limiter; an interval of 0 disables limiting
+func New(interval time.Duration, burst int) *Limiter {
+	return &Limiter{
+		interval: interval,
+		burst:    max(burst, 1),
+		windows:  make(map[string]*window),
+		output:   func(msg string) { slog.Warn(msg) },
+	}
+}
+
+// Configure changes the interval and burst. Counts already running keep
+// their current interval.
+func (l *Limiter) Configure(interval time.Duration, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = interval
+	l.burst = max(burst, 1)
+}
+
+// Printf logs a message from source, such as "proxy", unless burst
+// messages with the same key were logged this interval. Keys name what
+// failed and how, e.g. the backend and Class of the error.
+func (l *Limiter) Printf(source, key, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	key = source + " " + key
+
+	l.mu.Lock()
+	if l.interval <= 0 {
+		l.mu.Unlock()
+		l.output(msg)
+		return
+	}
+	w := l.windows[key]
+	if w == nil {
+		w = &window{source: source}
+		l.windows[key] = w
+		time.AfterFunc(l.interval, func() { l.flush(key) })
+	}
+	if w.logged >= l.burst {
+		w.suppressed++
+		w.last = msg
+		l.mu.Unlock()
+		suppressedTotal.Inc(source)
+		return
+	}
+	w.logged++
+	l.mu.Unlock()
+	l.output(msg)
+}
+
+// flush ends key's interval, logging how many messages were suppressed
+func (l *Limiter) flush(key string) {
+	l.mu.Lock()
+	w := l.windows[key]
+	delete(l.windows, key)
+	interval := l.interval
+	l.mu.Unlock()
+	if w != nil && w.suppressed > 0 {
+		l.output(fmt.Sprintf("suppressed %d similar messages (%s) in the last %s, latest: %s", w.suppressed, key, interval, w.last))
+	}
+}
+
+// Printf logs through Default
+func Printf(source, key, format string, args ...any) {
+	Default.Printf(source, key, format, args...)
+}
+
+// Class names the kind of network error err is, for use in keys
+func Class(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ClassRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ClassReset
+	case errors.As(err, &dnsErr):
+		return ClassDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ClassTimeout
+	}
+	return ClassOther
+}
@@ -0,0 +1,76 @@
+package errlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(time.Hour, 2)
+	var lines []string
+	l.output = func(msg string) { lines = append(lines, msg) }
+
+	for i := range 5 {
+		l.Printf("proxy", "web:80 refused", "proxy error to web:80: attempt %d", i)
+	}
+	l.Printf("proxy", "api:80 refused", "proxy error to api:80")
+	want := []string{"proxy error to web:80: attempt 0", "proxy error to web:80: attempt 1", "proxy error to api:80"}
+	if fmt.Sprint(lines) != fmt.Sprint(want) {
+		t.Fatalf("logged %q, want %q", lines, want)
+	}
+	if got := suppressedTotal.Value("proxy"); got < 3 {
+		t.Errorf("liteproxy_log_suppressed_total{source=proxy} = %d, want at least 3", got)
+	}
+
+	// The end of the interval summarizes what was held back and starts over
+	lines = nil
+	l.flush("proxy web:80 refused")
+	l.flush("proxy api:80 refused")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "suppressed 3 similar messages (proxy web:80 refused) in the last 1h0m0s, latest: proxy error to web:80: attempt 4") {
+		t.Fatalf("summary = %q", lines)
+	}
+	lines = nil
+	l.Printf("proxy", "web:80 refused", "proxy error to web:80: again")
+	if len(lines) != 1 {
+		t.Errorf("after the interval logged %q, want the message", lines)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := New(0, 1)
+	n := 0
+	l.output = func(string) { n++ }
+	for range 10 {
+		l.Printf("tls", "protocol", "TLS handshake failed")
+	}
+	if n != 10 {
+		t.Errorf("logged %d messages, want 10", n)
+	}
+}
+
+func TestClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ClassRefused},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ClassReset},
+		{&net.DNSError{Err: "no such host", Name: "web", IsNotFound: true}, ClassDNS},
+		{fmt.Errorf("dial: %w", os.ErrDeadlineExceeded), ClassTimeout},
+		{context.DeadlineExceeded, ClassTimeout},
+		{context.Canceled, ClassCanceled},
+		{errors.New("malformed HTTP response"), ClassOther},
+	}
+	for _, tt := range tests {
+		if got := Class(tt.err); got != tt.want {
+			t.Errorf("Class(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
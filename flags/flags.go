@@ -0,0 +1,176 @@
+// Package flags evaluates feature flags for requests at the proxy, so
+// backends can roll features out gradually without a flags SDK
+package flags
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// HeaderPrefix starts the request header carrying each flag's value
+const HeaderPrefix = "X-Flag-"
+
+// validName matches flag names, which become part of a header name
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// ValidName reports whether name can be used as a flag name
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Flag is on or off for each request: the first rule that applies decides,
+// else Default does
+type Flag struct {
+	Rules   []Rule `yaml:"rules,omitempty"`
+	Default bool   `yaml:"default,omitempty"`
+	By      string `yaml:"by,omitempty"` // attribute bucketing clients for percentages, default ip
+}
+
+// Rule applies to requests whose Attribute has one of Values, or to all
+// requests without an Attribute. It turns the flag on for Percent of them.
+type Rule struct {
+	Attribute string   `yaml:"attribute,omitempty"` // header:<name>, cookie:<name>, query:<name>, host, path or ip
+	Values    []string `yaml:"values,omitempty"`
+	Percent   *int     `yaml:"percent,omitempty"` // default 100
+}
+
+// Set is a parsed flags document
+type Set struct {
+	flags map[string]*Flag
+	names []string // sorted
+}
+
+// Parse reads a flags document, in YAML or JSON:
+//
+//	flags:
+//	  new-checkout:
+//	    by: cookie:session
+//	    rules:
+//	      - attribute: header:X-Plan
+//	        values: [enterprise]
+//	      - percent: 25
+func Parse(data []byte) (*Set, error) {
+	var doc struct {
+		Flags map[string]*Flag `yaml:"flags"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	s := &Set{flags: make(map[string]*Flag, len(doc.Flags))}
+	for name, f := range doc.Flags {
+		if !ValidName(name) {
+			return nil, fmt.Errorf("invalid flag name %q (want letters, digits, - and _)", name)
+		}
+		if f == nil {
+			f = &Flag{}
+		}
+		if f.By != "" {
+			if err := validAttribute(f.By); err != nil {
+				return nil, fmt.Errorf("flag %s: by: %w", name, err)
+			}
+		}
+		for i, r := range f.Rules {
+			if r.Attribute != "" {
+				if err := validAttribute(r.Attribute); err != nil {
+					return nil, fmt.Errorf("flag %s: rule %d: %w", name, i+1, err)
+				}
+			} else if len(r.Values) > 0 {
+				return nil, fmt.Errorf("flag %s: rule %d has values but no attribute", name, i+1)
+			}
+			if r.Percent != nil && (*r.Percent < 0 || *r.Percent > 100) {
+				return nil, fmt.Errorf("flag %s: rule %d: percent %d out of range 0-100", name, i+1, *r.Percent)
+			}
+		}
+		s.flags[name] = f
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	return s, nil
+}
+
+func validAttribute(attr string) error {
+	kind, name, _ := strings.Cut(attr, ":")
+	switch kind {
+	case "header", "cookie", "query":
+		if name == "" {
+			return fmt.Errorf("attribute %q needs a name (%s:<name>)", attr, kind)
+		}
+	case "host", "path", "ip":
+		if name != "" {
+			return fmt.Errorf("invalid attribute %q", attr)
+		}
+	default:
+		return fmt.Errorf("invalid attribute %q (want header:, cookie:, query:, host, path or ip)", attr)
+	}
+	return nil
+}
+
+// Names returns the names of all flags, sorted
+func (s *Set) Names() []string {
+	return s.names
+}
+
+// Enabled reports whether the named flag is on for r; unknown flags are off
+func (s *Set) Enabled(name string, r *http.Request) bool {
+	f, ok := s.flags[name]
+	if !ok {
+		return false
+	}
+	for _, rule := range f.Rules {
+		if rule.Attribute != "" && !slices.Contains(rule.Values, attribute(r, rule.Attribute)) {
+			continue
+		}
+		if rule.Percent == nil || *rule.Percent >= 100 {
+			return true
+		}
+		return bucket(name, attribute(r, f.By)) < *rule.Percent
+	}
+	return f.Default
+}
+
+// bucket places a client in 0-99 for a flag. Each flag hashes separately,
+// so the same clients aren't first in line for every rollout.
+func bucket(flag, key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(flag))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+// attribute returns the value of attr for r; by default the client IP
+func attribute(r *http.Request, attr string) string {
+	kind, name, _ := strings.Cut(attr, ":")
+	switch kind {
+	case "header":
+		return r.Header.Get(name)
+	case "cookie":
+		if c, err := r.Cookie(name); err == nil {
+			return c.Value
+		}
+		return ""
+	case "query":
+		return r.URL.Query().Get(name)
+	case "host":
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return strings.ToLower(host)
+	case "path":
+		return r.URL.Path
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,143 @@
+package flags
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const doc = `
+flags:
+  new-checkout:
+    by: header:X-User-Id
+    rules:
+      - attribute: header:X-Plan
+        values: [enterprise]
+      - attribute: cookie:beta
+        values: ["0"]
+        percent: 0
+      - percent: 30
+  dark-mode:
+    default: true
+    rules:
+      - attribute: host
+        values: [legacy.example.com]
+        percent: 0
+  off: {}
+`
+
+func TestEnabled(t *testing.T) {
+	s, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.Names(), []string{"dark-mode", "new-checkout", "off"}) {
+		t.Errorf("Names() = %v", s.Names())
+	}
+
+	tests := []struct {
+		name    string
+		flag    string
+		host    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "attribute match", flag: "new-checkout", headers: map[string]string{"X-Plan": "enterprise", "X-User-Id": "u1"}, want: true},
+		{name: "opted out", flag: "new-checkout", headers: map[string]string{"Cookie": "beta=0", "X-User-Id": "u1"}},
+		{name: "default on", flag: "dark-mode", want: true},
+		{name: "excluded host", flag: "dark-mode", host: "legacy.example.com"},
+		{name: "no rules", flag: "off"},
+		{name: "unknown flag", flag: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://"+cmp.Or(tt.host, "app.example.com")+"/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := s.Enabled(tt.flag, r); got != tt.want {
+				t.Errorf("Enabled(%q) = %v, want %v", tt.flag, got, tt.want)
+			}
+		})
+	}
+
+	// The percentage rule: about 30% of users, each always in or out
+	on := 0
+	for i := range 1000 {
+		r := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		r.Header.Set("X-User-Id", fmt.Sprintf("user-%d", i))
+		first := s.Enabled("new-checkout", r)
+		if s.Enabled("new-checkout", r) != first {
+			t.Fatalf("user-%d flipped between requests", i)
+		}
+		if first {
+			on++
+		}
+	}
+	if on < 250 || on > 350 {
+		t.Errorf("%d of 1000 users got a 30%% flag", on)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string]string{
+		"bad name":          "flags:\n  'new checkout': {}\n",
+		"bad attribute":     "flags:\n  x:\n    rules:\n      - attribute: body\n        values: [a]\n",
+		"attribute no name": "flags:\n  x:\n    by: 'header:'\n",
+		"values alone":      "flags:\n  x:\n    rules:\n      - values: [a]\n",
+		"percent too big":   "flags:\n  x:\n    rules:\n      - percent: 120\n",
+		"not yaml":          "flags: [",
+	}
+	for name, doc := range tests {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.yaml")
+	os.WriteFile(path, []byte("flags:\n  a:\n    default: true\n"), 0o644)
+	s, err := NewSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.Flags().Names(), []string{"a"}) {
+		t.Errorf("file flags = %v", s.Flags().Names())
+	}
+
+	// A broken document keeps the previous flags
+	os.WriteFile(path, []byte("flags: ["), 0o644)
+	if err := s.Refresh(context.Background()); err == nil {
+		t.Error("broken document: expected error")
+	}
+	if !slices.Equal(s.Flags().Names(), []string{"a"}) {
+		t.Errorf("after a failed refresh: %v", s.Flags().Names())
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/flags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"flags": {"b": {"rules": [{"percent": 50}]}}}`))
+	}))
+	defer srv.Close()
+	s, err = NewSource(srv.URL + "/flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.Flags().Names(), []string{"b"}) {
+		t.Errorf("HTTP flags = %v", s.Flags().Names())
+	}
+	if _, err := NewSource(srv.URL + "/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing endpoint: error = %v", err)
+	}
+}
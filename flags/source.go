@@ -0,0 +1,93 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RefreshInterval is how often a source is read again
+const RefreshInterval = 30 * time.Second
+
+// fetchTimeout bounds each request to a flags endpoint
+const fetchTimeout = 5 * time.Second
+
+// maxDocument is the largest flags document read
+const maxDocument = 4 << 20
+
+// Source keeps the flags from a file or HTTP endpoint current. When a
+// refresh fails, the last flags read stay in effect.
+type Source struct {
+	src    string
+	client *http.Client
+	set    atomic.Pointer[Set]
+}
+
+// NewSource reads flags from src, a file path or an http(s):// URL
+func NewSource(src string) (*Source, error) {
+	s := &Source{src: src, client: &http.Client{Timeout: fetchTimeout}}
+	if err := s.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Flags returns the current flags
+func (s *Source) Flags() *Set {
+	return s.set.Load()
+}
+
+// Refresh reads the source again
+func (s *Source) Refresh(ctx context.Context) error {
+	data, err := s.read(ctx)
+	if err != nil {
+		return fmt.Errorf("reading flags: %w", err)
+	}
+	set, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("flags %s: %w", s.src, err)
+	}
+	s.set.Store(set)
+	return nil
+}
+
+// Run refreshes the flags every RefreshInterval until ctx is done
+func (s *Source) Run(ctx context.Context) {
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				slog.Warn("refreshing flags failed, keeping the previous flags", "err", err)
+			}
+		}
+	}
+}
+
+func (s *Source) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.src, "http://") && !strings.HasPrefix(s.src, "https://") {
+		return os.ReadFile(s.src)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", s.src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", s.src, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDocument))
+}
@@ -0,0 +1,295 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// Checker runs active health checks against route backends and tracks
+// passive failures reported from live traffic
+// Backends without a health check are always considered healthy
+type Checker struct {
+	mu       sync.RWMutex
+	probes   map[string]*probe   // probe key (addr + check settings) → running probe
+	byAddr   map[string][]*probe // backend address → probes for it
+	passive  map[string]*passive // backend address → passive failure tracking
+	routes   []compose.Route     // routes from the last Update
+	resolved map[string][]string // route host+path → addresses found by DNS discovery
+}
+
+// probe periodically checks a single backend
+type probe struct {
+	addr      string
+	check     compose.HealthCheck
+	healthy   atomic.Bool
+	lastCheck atomic.Int64           // unix nanos of the last completed probe
+	lastErr   atomic.Pointer[string] // error from the last failed probe
+	cancel    context.CancelFunc
+}
+
+// BackendStatus is the health of one backend, for the admin API
+type BackendStatus struct {
+	Addr      string    `json:"addr"`
+	Healthy   bool      `json:"healthy"`
+	Ejected   bool      `json:"ejected,omitempty"`    // ejected by passive checks
+	Check     string    `json:"check,omitempty"`      // active check type
+	LastCheck time.Time `json:"last_check,omitzero"`  // time of the last active probe
+	LastError string    `json:"last_error,omitempty"` // error from the last failed probe
+}
+
+// NewChecker creates a Checker with no probes running
+func NewChecker() *Checker {
+	return &Checker{
+		probes:   make(map[string]*probe),
+		byAddr:   make(map[string][]*probe),
+		passive:  make(map[string]*passive),
+		resolved: make(map[string][]string),
+	}
+}
+
+// Update starts probes for the backends of all routes with a health check
+// and stops probes that are no longer needed. Probes and passive tracking
+// whose settings are unchanged are kept, so their status survives a reload.
+func (c *Checker) Update(routes []compose.Route) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Forget resolved addresses of routes that were removed
+	keep := make(map[string][]string)
+	for _, route := range routes {
+		if addrs, ok := c.resolved[routeKey(&route)]; ok {
+			keep[routeKey(&route)] = addrs
+		}
+	}
+	c.resolved = keep
+	c.update(routes)
+}
+
+// SetAddrs replaces the backends checked for route with addresses resolved
+// by DNS discovery, keeping the status of addresses that remain
+func (c *Checker) SetAddrs(route *compose.Route, addrs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resolved[routeKey(route)] = addrs
+	c.update(c.routes)
+}
+
+// addrs returns the backends to check for route
+func (c *Checker) addrs(route *compose.Route) []string {
+	if addrs, ok := c.resolved[routeKey(route)]; ok {
+		return addrs
+	}
+	return route.Addrs()
+}
+
+func routeKey(route *compose.Route) string {
+	return route.Host + route.Path()
+}
+
+// update reconciles probes and passive tracking with routes
+// c.mu must be held
+func (c *Checker) update(routes []compose.Route) {
+	probes := make(map[string]*probe)
+	byAddr := make(map[string][]*probe)
+	passives := make(map[string]*passive)
+
+	for i := range routes {
+		route := &routes[i]
+		if route.PassiveCheck != nil {
+			recovery := recoveryCheck(route)
+			for _, addr := range c.addrs(route) {
+				if _, ok := passives[addr]; ok {
+					continue
+				}
+				p, ok := c.passive[addr]
+				if !ok || p.cfg != *route.PassiveCheck || p.recovery != recovery {
+					p = newPassive(addr, *route.PassiveCheck, recovery)
+				}
+				passives[addr] = p
+			}
+		}
+		if route.HealthCheck == nil {
+			continue
+		}
+		for _, addr := range c.addrs(route) {
+			key := probeKey(addr, *route.HealthCheck)
+			if _, ok := probes[key]; ok {
+				continue
+			}
+			p, ok := c.probes[key]
+			if !ok {
+				p = c.start(addr, *route.HealthCheck)
+			}
+			probes[key] = p
+			byAddr[addr] = append(byAddr[addr], p)
+		}
+	}
+
+	// Stop probes that were removed
+	for key, p := range c.probes {
+		if _, ok := probes[key]; !ok {
+			p.cancel()
+		}
+	}
+
+	for addr, p := range c.passive {
+		if passives[addr] != p {
+			p.cancel()
+		}
+	}
+
+	c.probes = probes
+	c.byAddr = byAddr
+	c.passive = passives
+	c.routes = routes
+}
+
+// Report records the outcome of a request proxied to addr
+// A failure is a 5xx response, dial error or timeout
+func (c *Checker) Report(addr string, failed bool) {
+	c.mu.RLock()
+	p := c.passive[addr]
+	c.mu.RUnlock()
+
+	if p != nil {
+		p.report(failed)
+	}
+}
+
+// Healthy reports whether all health checks for the backend are passing
+// and it has not been ejected by passive checks
+func (c *Checker) Healthy(addr string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if p := c.passive[addr]; p != nil && p.ejected.Load() {
+		return false
+	}
+
+	for _, p := range c.byAddr[addr] {
+		if !p.healthy.Load() {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the health of every checked backend, sorted by address
+func (c *Checker) Status() []BackendStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	byAddr := make(map[string]*BackendStatus)
+	status := func(addr string) *BackendStatus {
+		s, ok := byAddr[addr]
+		if !ok {
+			s = &BackendStatus{Addr: addr, Healthy: true}
+			byAddr[addr] = s
+		}
+		return s
+	}
+	for addr, ps := range c.byAddr {
+		s := status(addr)
+		for _, p := range ps {
+			s.Check = p.check.Type
+			if !p.healthy.Load() {
+				s.Healthy = false
+				if err := p.lastErr.Load(); err != nil {
+					s.LastError = *err
+				}
+			}
+			if t := p.lastCheck.Load(); t != 0 && time.Unix(0, t).After(s.LastCheck) {
+				s.LastCheck = time.Unix(0, t)
+			}
+		}
+	}
+	for addr, p := range c.passive {
+		if s := status(addr); p.ejected.Load() {
+			s.Healthy, s.Ejected = false, true
+		}
+	}
+
+	list := make([]BackendStatus, 0, len(byAddr))
+	for _, addr := range slices.Sorted(maps.Keys(byAddr)) {
+		list = append(list, *byAddr[addr])
+	}
+	return list
+}
+
+// Stop stops all running probes
+func (c *Checker) Stop() {
+	c.Update(nil)
+}
+
+func (c *Checker) start(addr string, check compose.HealthCheck) *probe {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &probe{addr: addr, check: check, cancel: cancel}
+	p.healthy.Store(true) // assume healthy until the first probe says otherwise
+	go p.run(ctx)
+	return p
+}
+
+func (p *probe) run(ctx context.Context) {
+	ticker := time.NewTicker(p.check.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.once(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *probe) once(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.check.Timeout)
+	defer cancel()
+
+	err := Probe(ctx, p.addr, p.check)
+	if ctx.Err() == context.Canceled {
+		return // stopped mid-probe
+	}
+
+	healthy := err == nil
+	p.lastCheck.Store(time.Now().UnixNano())
+	if err != nil {
+		msg := err.Error()
+		p.lastErr.Store(&msg)
+	}
+	if p.healthy.Swap(healthy) != healthy {
+		if healthy {
+			slog.Info("backend is healthy", "backend", p.addr, "check", p.check.Type)
+		} else {
+			slog.Warn("backend is unhealthy", "backend", p.addr, "check", p.check.Type, "err", err)
+		}
+	}
+}
+
+// Probe runs a single health check against addr
+func Probe(ctx context.Context, addr string, check compose.HealthCheck) error {
+	switch check.Type {
+	case compose.HealthHTTP:
+		return probeHTTP(ctx, addr, check.Path)
+	case compose.HealthTCP:
+		return probeTCP(ctx, addr)
+	case compose.HealthGRPC:
+		return probeGRPC(ctx, addr, check.Service)
+	default:
+		return fmt.Errorf("unknown health check type %q", check.Type)
+	}
+}
+
+func probeKey(addr string, check compose.HealthCheck) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s", addr, check.Type, check.Path, check.Service, check.Interval, check.Timeout)
+}
@@ -0,0 +1,325 @@
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+func TestProbeHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+	addr := strings.TrimPrefix(backend.URL, "http://")
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"healthy path", "/healthz", false},
+		{"failing path", "/broken", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := compose.HealthCheck{Type: compose.HealthHTTP, Path: tt.path}
+			err := Probe(context.Background(), addr, check)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	check := compose.HealthCheck{Type: compose.HealthTCP}
+	if err := Probe(context.Background(), addr, check); err != nil {
+		t.Errorf("Probe() on open port error = %v", err)
+	}
+
+	ln.Close()
+	if err := Probe(context.Background(), addr, check); err == nil {
+		t.Error("Probe() on closed port expected error")
+	}
+}
+
+// newGRPCHealthServer starts an h2c server answering grpc.health.v1.Health/Check
+// with the status for the requested service
+func newGRPCHealthServer(t *testing.T, statuses map[string]uint64) string {
+	t.Helper()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grpc.health.v1.Health/Check" || r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		service := ""
+		if len(body) > 5 && body[5] == 0x0a {
+			l, n := binary.Uvarint(body[6:])
+			service = string(body[6+n : 6+n+int(l)])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		status, ok := statuses[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5") // NOT_FOUND
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		msg := []byte{0x08} // field 1, varint
+		msg = binary.AppendUvarint(msg, status)
+		w.Write(grpcFrame(msg))
+		w.Header().Set("Grpc-Status", "0")
+	})
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestProbeGRPC(t *testing.T) {
+	addr := newGRPCHealthServer(t, map[string]uint64{
+		"":            1, // SERVING
+		"orders.v1":   1,
+		"payments.v1": 2, // NOT_SERVING
+	})
+
+	tests := []struct {
+		name    string
+		service string
+		wantErr string
+	}{
+		{"whole server serving", "", ""},
+		{"named service serving", "orders.v1", ""},
+		{"named service not serving", "payments.v1", "NOT_SERVING"},
+		{"unknown service", "missing.v1", "grpc-status 5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := compose.HealthCheck{Type: compose.HealthGRPC, Service: tt.service}
+			err := Probe(context.Background(), addr, check)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Probe() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Probe() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeHealthResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		want uint64
+	}{
+		{"empty is UNKNOWN", nil, 0},
+		{"serving", []byte{0x08, 0x01}, 1},
+		{"not serving", []byte{0x08, 0x02}, 2},
+		{"skips unknown field", []byte{0x12, 0x02, 'h', 'i', 0x08, 0x01}, 1},
+		{"truncated", []byte{0x08}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeHealthResponse(tt.msg); got != tt.want {
+				t.Errorf("decodeHealthResponse() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckerMarksUnhealthy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() // nothing listening: TCP checks fail
+
+	routes := []compose.Route{
+		{
+			Host:     "example.com",
+			Backends: []string{addr},
+			HealthCheck: &compose.HealthCheck{
+				Type:     compose.HealthTCP,
+				Interval: 10 * time.Millisecond,
+				Timeout:  100 * time.Millisecond,
+			},
+		},
+	}
+
+	c := NewChecker()
+	defer c.Stop()
+	c.Update(routes)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Healthy(addr) {
+		if time.Now().After(deadline) {
+			t.Fatal("backend still healthy after failing checks")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Backends without checks are always healthy
+	if !c.Healthy("other:80") {
+		t.Error("Healthy() for unchecked backend = false, want true")
+	}
+
+	// Removing the check on reload drops the probe
+	c.Update(nil)
+	if !c.Healthy(addr) {
+		t.Error("Healthy() after removing check = false, want true")
+	}
+}
+
+func TestPassiveEjectAndRecover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	routes := []compose.Route{
+		{
+			Host:         "example.com",
+			Backends:     []string{addr},
+			PassiveCheck: &compose.PassiveCheck{MaxFails: 3, FailTimeout: 20 * time.Millisecond},
+		},
+	}
+
+	c := NewChecker()
+	defer c.Stop()
+	c.Update(routes)
+
+	// A success resets the consecutive failure count
+	c.Report(addr, true)
+	c.Report(addr, true)
+	c.Report(addr, false)
+	c.Report(addr, true)
+	c.Report(addr, true)
+	if !c.Healthy(addr) {
+		t.Fatal("backend ejected before max_fails consecutive failures")
+	}
+
+	c.Report(addr, true)
+	if c.Healthy(addr) {
+		t.Fatal("backend not ejected after max_fails consecutive failures")
+	}
+
+	// The listener is up, so the recovery probe restores the backend
+	deadline := time.Now().Add(2 * time.Second)
+	for !c.Healthy(addr) {
+		if time.Now().After(deadline) {
+			t.Fatal("backend not restored after recovery probe passed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPassiveIgnoredWithoutConfig(t *testing.T) {
+	c := NewChecker()
+	defer c.Stop()
+
+	for i := 0; i < 10; i++ {
+		c.Report("web:80", true)
+	}
+	if !c.Healthy("web:80") {
+		t.Error("Healthy() = false for backend without passive checks")
+	}
+}
+
+func TestCheckerSetAddrs(t *testing.T) {
+	up, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Close()
+
+	routes := []compose.Route{
+		{
+			Host:        "example.com",
+			PathPrefix:  "/",
+			ServiceName: "web",
+			ServicePort: 80,
+			HealthCheck: &compose.HealthCheck{
+				Type:     compose.HealthTCP,
+				Interval: 10 * time.Millisecond,
+				Timeout:  100 * time.Millisecond,
+			},
+		},
+	}
+
+	c := NewChecker()
+	defer c.Stop()
+	c.Update(routes)
+
+	// Replicas found by DNS discovery replace the service name
+	c.SetAddrs(&routes[0], []string{up.Addr().String(), down.Addr().String()})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Healthy(down.Addr().String()) {
+		if time.Now().After(deadline) {
+			t.Fatal("replica still healthy after failing checks")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := c.Status()
+	if len(status) != 2 {
+		t.Fatalf("Status() = %+v, want the two replicas only", status)
+	}
+	for _, s := range status {
+		wantHealthy := s.Addr == up.Addr().String()
+		if s.Healthy != wantHealthy || s.Check != compose.HealthTCP || s.LastCheck.IsZero() {
+			t.Errorf("Status(%s) = %+v, want healthy=%v with a tcp check", s.Addr, s, wantHealthy)
+		}
+		if !wantHealthy && s.LastError == "" {
+			t.Errorf("Status(%s) has no last error", s.Addr)
+		}
+	}
+
+	// Resolved addresses survive a reload of the same route
+	c.Update(routes)
+	if len(c.Status()) != 2 {
+		t.Errorf("Status() after reload = %+v, want the replicas kept", c.Status())
+	}
+}
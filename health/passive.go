@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// passive tracks live traffic failures for a single backend and ejects it
+// after too many consecutive failures. Ejected backends are probed every
+// FailTimeout and restored as soon as a probe passes.
+type passive struct {
+	addr     string
+	cfg      compose.PassiveCheck
+	recovery compose.HealthCheck // probe used to detect recovery
+
+	mu      sync.Mutex
+	fails   int
+	ejected atomic.Bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newPassive(addr string, cfg compose.PassiveCheck, recovery compose.HealthCheck) *passive {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &passive{addr: addr, cfg: cfg, recovery: recovery, ctx: ctx, cancel: cancel}
+}
+
+// report records the outcome of a proxied request
+func (p *passive) report(failed bool) {
+	if p.ejected.Load() {
+		return // recovery is decided by probes, not stragglers
+	}
+
+	p.mu.Lock()
+	if !failed {
+		p.fails = 0
+		p.mu.Unlock()
+		return
+	}
+	p.fails++
+	if p.fails < p.cfg.MaxFails {
+		p.mu.Unlock()
+		return
+	}
+	p.fails = 0
+	p.mu.Unlock()
+
+	if p.ejected.CompareAndSwap(false, true) {
+		slog.Warn("backend ejected after consecutive failures", "backend", p.addr, "failures", p.cfg.MaxFails)
+		go p.recover()
+	}
+}
+
+func (p *passive) recover() {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(p.cfg.FailTimeout):
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, p.recovery.Timeout)
+		err := Probe(ctx, p.addr, p.recovery)
+		cancel()
+		if err == nil {
+			p.ejected.Store(false)
+			slog.Info("backend recovered", "backend", p.addr, "check", p.recovery.Type)
+			return
+		}
+	}
+}
+
+// recoveryCheck returns the probe used to detect recovery of an ejected backend:
+// the route's active check if it has one, otherwise a TCP connect
+func recoveryCheck(route *compose.Route) compose.HealthCheck {
+	if route.HealthCheck != nil {
+		return *route.HealthCheck
+	}
+	return compose.HealthCheck{Type: compose.HealthTCP, Timeout: compose.DefaultHealthTimeout}
+}
@@ -0,0 +1,187 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Probe transports are separate from the proxy transport so health checks
+// never compete with live traffic for pooled connections
+var (
+	httpTransport = &http.Transport{
+		DisableKeepAlives: true,
+	}
+	grpcTransport = newGRPCTransport()
+)
+
+func newGRPCTransport() *http.Transport {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true) // gRPC over cleartext HTTP/2 (h2c)
+	return &http.Transport{
+		Protocols: &protocols,
+	}
+}
+
+// probeHTTP passes if GET path returns a 2xx or 3xx status
+func probeHTTP(ctx context.Context, addr, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "liteproxy-healthcheck")
+
+	resp, err := httpTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeTCP passes if a TCP connection can be established
+func probeTCP(ctx context.Context, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// grpc.health.v1 serving statuses
+var servingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// probeGRPC calls grpc.health.v1.Health/Check and passes if the status is SERVING
+// The messages are tiny, so they are encoded by hand instead of pulling in grpc-go
+func probeGRPC(ctx context.Context, addr, service string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"http://"+addr+"/grpc.health.v1.Health/Check",
+		bytes.NewReader(grpcFrame(encodeHealthRequest(service))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "liteproxy-healthcheck")
+
+	resp, err := grpcTransport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Errors may arrive in trailers, or in headers for trailers-only responses
+	code := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code = resp.Header.Get("Grpc-Status")
+		msg = resp.Header.Get("Grpc-Message")
+	}
+	if code != "0" {
+		return fmt.Errorf("grpc-status %s: %s", code, msg)
+	}
+
+	if len(data) < 5 {
+		return fmt.Errorf("truncated response")
+	}
+	n := binary.BigEndian.Uint32(data[1:5])
+	if uint32(len(data)-5) < n {
+		return fmt.Errorf("truncated response")
+	}
+
+	status := decodeHealthResponse(data[5 : 5+n])
+	if status != 1 {
+		name, ok := servingStatus[status]
+		if !ok {
+			name = fmt.Sprintf("status %d", status)
+		}
+		return fmt.Errorf("grpc health %s", name)
+	}
+	return nil
+}
+
+// grpcFrame prefixes a message with the gRPC length-prefixed framing
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(msg))) // frame[0] = 0: uncompressed
+	copy(frame[5:], msg)
+	return frame
+}
+
+// encodeHealthRequest encodes HealthCheckRequest{service: 1}
+func encodeHealthRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	msg := []byte{0x0a} // field 1, wire type 2 (length-delimited)
+	msg = binary.AppendUvarint(msg, uint64(len(service)))
+	return append(msg, service...)
+}
+
+// decodeHealthResponse decodes HealthCheckResponse{status: 1} and returns
+// the status, skipping unknown fields. A missing status decodes as UNKNOWN.
+func decodeHealthResponse(msg []byte) uint64 {
+	var status uint64
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0
+		}
+		msg = msg[n:]
+
+		switch tag & 7 { // wire type
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0
+			}
+			msg = msg[n:]
+			if tag>>3 == 1 {
+				status = v
+			}
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return 0
+			}
+			msg = msg[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0
+			}
+			msg = msg[n+int(l):]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return 0
+			}
+			msg = msg[4:]
+		default:
+			return 0
+		}
+	}
+	return status
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// altSvcMaxAge is how long clients may remember that HTTP/3 is available
+const altSvcMaxAge = 24 * 60 * 60
+
+// altSvc advertises the HTTP/3 listener on port to HTTP/1.1 and HTTP/2
+// clients, which switch to QUIC for later requests
+func altSvc(h http.Handler, port int) http.Handler {
+	value := fmt.Sprintf(`h3=":%d"; ma=%d`, port, altSvcMaxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", value)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
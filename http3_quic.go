@@ -0,0 +1,29 @@
+//go:build http3
+
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strconv"
+
+	"github.com/localrivet/liteproxy/listener"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Supported reports whether this binary can serve HTTP/3
+const http3Supported = true
+
+// serveHTTP3 serves h over QUIC on the UDP side of port, with the same
+// certificates as the TCP listener
+func serveHTTP3(port int, h http.Handler, tlsConfig *tls.Config) error {
+	conn, err := listener.ListenPacket(":" + strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+	srv := &http3.Server{
+		Handler:   h,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+	return srv.Serve(conn)
+}
@@ -0,0 +1,18 @@
+//go:build !http3
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+// http3Supported reports whether this binary can serve HTTP/3
+const http3Supported = false
+
+// serveHTTP3 is unavailable without the http3 build tag, which pulls in
+// quic-go
+func serveHTTP3(port int, h http.Handler, tlsConfig *tls.Config) error {
+	return errors.New("built without HTTP/3 support (rebuild with -tags http3)")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAltSvc(t *testing.T) {
+	h := altSvc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 443)
+
+	for _, proto := range []struct{ major, minor int }{{1, 1}, {2, 0}, {3, 0}} {
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		req.ProtoMajor, req.ProtoMinor = proto.major, proto.minor
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		got := rec.Header().Get("Alt-Svc")
+		want := `h3=":443"; ma=86400`
+		if proto.major == 3 {
+			want = "" // already on HTTP/3
+		}
+		if got != want {
+			t.Errorf("HTTP/%d Alt-Svc = %q, want %q", proto.major, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/localrivet/liteproxy/compose"
+	"go.yaml.in/yaml/v4"
+)
+
+// importUsage describes `liteproxy import`
+const importUsage = `usage: liteproxy import traefik [compose.yaml]
+       liteproxy import caddy [compose.yaml]
+       liteproxy import nginx nginx.conf`
+
+// importLabelOrder puts the labels that identify a route first
+var importLabelOrder = []string{compose.LabelHost, compose.LabelPath, compose.LabelPort}
+
+// runImport implements `liteproxy import`: translate Traefik or Caddy labels, or nginx
+// server blocks into liteproxy labels, printed as a compose services fragment
+func runImport(args []string, stdout, stderr io.Writer) int {
+	if len(args) < 1 || len(args) > 2 || (args[0] == "nginx" && len(args) != 2) {
+		fmt.Fprintln(stderr, importUsage)
+		return 2
+	}
+
+	path := "./compose.yaml"
+	if len(args) == 2 {
+		path = args[1]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy import: %v\n", err)
+		return 1
+	}
+
+	var im *compose.Import
+	switch args[0] {
+	case "traefik":
+		im, err = compose.ImportTraefik(data, path)
+	case "caddy":
+		im, err = compose.ImportCaddy(data, path)
+	case "nginx":
+		im, err = compose.ImportNginx(data)
+	default:
+		fmt.Fprintln(stderr, importUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy import: %s: %v\n", path, err)
+		return 1
+	}
+
+	for _, w := range im.Warnings {
+		fmt.Fprintf(stderr, "warning: %s\n", w)
+	}
+	if len(im.Services) == 0 {
+		fmt.Fprintln(stderr, "liteproxy import: no routes found")
+		return 1
+	}
+	enc := yaml.NewEncoder(stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(importedYAML(im.Services)); err != nil {
+		fmt.Fprintf(stderr, "liteproxy import: %v\n", err)
+		return 1
+	}
+	enc.Close()
+	return 0
+}
+
+// importedYAML builds a compose services fragment, keeping service order and
+// quoting label values as compose files usually do
+func importedYAML(services []compose.ImportedService) *yaml.Node {
+	str := func(s string, style yaml.Style) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s, Style: style}
+	}
+	mapping := func(content ...*yaml.Node) *yaml.Node {
+		return &yaml.Node{Kind: yaml.MappingNode, Content: content}
+	}
+
+	svcs := mapping()
+	for _, svc := range services {
+		keys := slices.DeleteFunc(slices.Sorted(maps.Keys(svc.Labels)), func(k string) bool {
+			return slices.Contains(importLabelOrder, k)
+		})
+		for _, k := range slices.Backward(importLabelOrder) {
+			if _, ok := svc.Labels[k]; ok {
+				keys = slices.Insert(keys, 0, k)
+			}
+		}
+
+		labels := mapping()
+		for _, k := range keys {
+			labels.Content = append(labels.Content, str(k, 0), str(svc.Labels[k], yaml.DoubleQuotedStyle))
+		}
+		svcs.Content = append(svcs.Content, str(svc.Name, 0), mapping(str("labels", 0), labels))
+	}
+	return mapping(str("services", 0), svcs)
+}
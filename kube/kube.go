@@ -0,0 +1,327 @@
+// Package kube turns Kubernetes Ingress objects into routes, so liteproxy
+// can run as an ingress controller for small clusters
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// DefaultIngressClass is the ingress class liteproxy serves by default
+const DefaultIngressClass = "liteproxy"
+
+// ingressClassAnnotation is the pre-IngressClass way of picking a controller
+const ingressClassAnnotation = "kubernetes.io/ingress.class"
+
+// In-cluster service account files
+const (
+	tokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	caFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+const ingressesPath = "/apis/networking.k8s.io/v1/ingresses"
+
+// watchTimeout is how long one watch request stays open before it is renewed
+const watchTimeout = 5 * time.Minute
+
+// retryInterval is the wait after a failed list or watch
+const retryInterval = 5 * time.Second
+
+// Client lists and watches Ingresses through the Kubernetes API
+type Client struct {
+	// TrustAnnotations lets Ingress annotations set restricted labels (see
+	// restrictedAnnotations), for clusters whose every namespace is trusted
+	TrustAnnotations bool
+
+	base      string // API server URL
+	tokenFile string // re-read on every request, as tokens are rotated
+	http      *http.Client
+}
+
+// InClusterClient returns a Client using the pod's service account
+func InClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod (KUBERNETES_SERVICE_HOST is not set)")
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return &Client{
+		base:      "https://" + net.JoinHostPort(host, port),
+		tokenFile: tokenFile,
+		http: &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}},
+	}, nil
+}
+
+// Ingress is the part of a networking.k8s.io/v1 Ingress liteproxy uses
+type Ingress struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		IngressClassName string `json:"ingressClassName"`
+		Rules            []struct {
+			Host string `json:"host"`
+			HTTP *struct {
+				Paths []struct {
+					Path     string `json:"path"`
+					PathType string `json:"pathType"`
+					Backend  struct {
+						Service *struct {
+							Name string `json:"name"`
+							Port struct {
+								Number int    `json:"number"`
+								Name   string `json:"name"`
+							} `json:"port"`
+						} `json:"service"`
+					} `json:"backend"`
+				} `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+// Routes lists every Ingress and returns the routes of those in class,
+// with the list's resource version to watch from
+func (c *Client) Routes(ctx context.Context, class string) ([]compose.Route, string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []Ingress `json:"items"`
+	}
+	resp, err := c.get(ctx, ingressesPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("decoding ingresses: %w", err)
+	}
+	return Routes(list.Items, class, c.TrustAnnotations), list.Metadata.ResourceVersion, nil
+}
+
+// Watch calls onChange with the new routes whenever an Ingress change
+// alters them, until ctx is done. routes and version are what Routes returned.
+func (c *Client) Watch(ctx context.Context, class string, routes []compose.Route, version string, onChange func([]compose.Route)) {
+	last := compose.Hash(routes)
+	for ctx.Err() == nil {
+		if version != "" {
+			if err := c.waitForChange(ctx, version); err != nil {
+				slog.Error("kubernetes: watching ingresses failed", "err", err)
+				sleep(ctx, retryInterval)
+			}
+		}
+
+		// Relist on every change: simple, and cheap for a small cluster
+		routes, v, err := c.Routes(ctx, class)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("kubernetes: listing ingresses failed", "err", err)
+			}
+			version = ""
+			sleep(ctx, retryInterval)
+			continue
+		}
+		version = v
+		if hash := compose.Hash(routes); hash != last {
+			last = hash
+			onChange(routes)
+		}
+	}
+}
+
+// waitForChange watches from version until the first event, or until the
+// watch times out or expires
+func (c *Client) waitForChange(ctx context.Context, version string) error {
+	query := url.Values{
+		"watch":           {"true"},
+		"resourceVersion": {version},
+		"timeoutSeconds":  {strconv.Itoa(int(watchTimeout.Seconds()))},
+	}
+	resp, err := c.get(ctx, ingressesPath+"?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&event); err != nil && ctx.Err() == nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// get sends an authenticated GET to the API server
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s answered %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+// Routes converts the ingresses in class to routes, one per host and path.
+// liteproxy.* annotations apply to every route of their Ingress, like
+// compose labels. Ingresses that can't be converted are logged and skipped.
+func Routes(ingresses []Ingress, class string, trusted bool) []compose.Route {
+	var routes []compose.Route
+	for _, ing := range ingresses {
+		if !inClass(ing, class) {
+			continue
+		}
+		ingRoutes, err := ingressRoutes(ing, trusted)
+		if err != nil {
+			slog.Warn("kubernetes: skipping ingress", "ingress", ing.Metadata.Namespace+"/"+ing.Metadata.Name, "err", err)
+			continue
+		}
+		routes = append(routes, ingRoutes...)
+	}
+	return routes
+}
+
+func inClass(ing Ingress, class string) bool {
+	if ing.Spec.IngressClassName != "" {
+		return ing.Spec.IngressClassName == class
+	}
+	return ing.Metadata.Annotations[ingressClassAnnotation] == class
+}
+
+// pathLabels are set from each Ingress path, so annotations can't override them
+var pathLabels = []string{compose.LabelHost, compose.LabelPort, compose.LabelPath, compose.LabelPathExact, compose.LabelPathRegex}
+
+// restrictedAnnotations are labels that reach past the Ingress's own
+// service: other backends or namespaces, host ports, or files and commands
+// on the proxy's host. Anyone who can create an Ingress could use them, so
+// they need TrustAnnotations.
+var restrictedAnnotations = []string{
+	compose.LabelBackends, compose.LabelUpstreams, compose.LabelDial, compose.LabelNetwork,
+	compose.LabelCanaryService, compose.LabelRegions, compose.LabelTenants, compose.LabelTenantResolver,
+	compose.LabelScaledToZeroStart, compose.LabelTCPPort, compose.LabelUDPPort, compose.LabelPassthroughPort,
+	compose.LabelMTLSCA, compose.LabelUpstreamTLSCA, compose.LabelUpstreamTLSCert, compose.LabelUpstreamTLSKey,
+	compose.LabelWAF, compose.LabelDLP,
+}
+
+// restricted reports whether annotation k=v needs TrustAnnotations. Rule
+// lists are files unless they only name the builtin rules.
+func restricted(k, v string) bool {
+	if !slices.Contains(restrictedAnnotations, k) {
+		return false
+	}
+	if k == compose.LabelWAF || k == compose.LabelDLP {
+		for item := range strings.SplitSeq(v, ",") {
+			if strings.TrimSpace(item) != "builtin" {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func ingressRoutes(ing Ingress, trusted bool) ([]compose.Route, error) {
+	if !trusted {
+		for k, v := range ing.Metadata.Annotations {
+			if restricted(k, v) {
+				return nil, fmt.Errorf("annotation %s requires LITEPROXY_INGRESS_TRUST_ANNOTATIONS", k)
+			}
+		}
+	}
+	var routes []compose.Route
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host == "" {
+			return nil, errors.New("rules without a host are not supported")
+		}
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			svc := p.Backend.Service
+			if svc == nil {
+				return nil, fmt.Errorf("%s%s: only service backends are supported", rule.Host, p.Path)
+			}
+			if svc.Port.Number == 0 {
+				return nil, fmt.Errorf("%s%s: named service ports are not supported, use a number", rule.Host, p.Path)
+			}
+
+			labels := map[string]string{
+				compose.LabelHost: rule.Host,
+				compose.LabelPort: strconv.Itoa(svc.Port.Number),
+			}
+			switch p.PathType {
+			case "Exact":
+				labels[compose.LabelPathExact] = p.Path
+			case "Prefix", "ImplementationSpecific", "":
+				if p.Path != "" {
+					labels[compose.LabelPath] = p.Path
+				}
+			default:
+				return nil, fmt.Errorf("%s%s: unsupported pathType %q", rule.Host, p.Path, p.PathType)
+			}
+			for k, v := range ing.Metadata.Annotations {
+				if strings.HasPrefix(k, "liteproxy.") && !slices.Contains(pathLabels, k) {
+					labels[k] = v
+				}
+			}
+			route, err := compose.RouteFromLabels(svc.Name, labels)
+			if err != nil {
+				return nil, err
+			}
+			route.Project = ing.Metadata.Namespace
+			route.BackendHost = svc.Name + "." + ing.Metadata.Namespace + ".svc"
+			routes = append(routes, *route)
+		}
+	}
+	return routes, nil
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,165 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+const ingressesJSON = `{"metadata": {"resourceVersion": "%d"}, "items": [
+  {
+    "metadata": {"name": "shop", "namespace": "prod", "annotations": {"liteproxy.timeout": "30s"}},
+    "spec": {
+      "ingressClassName": "liteproxy",
+      "rules": [{"host": "shop.example.com", "http": {"paths": [
+        {"path": "/", "pathType": "Prefix", "backend": {"service": {"name": "web", "port": {"number": 80}}}},
+        {"path": "/api", "pathType": "Prefix", "backend": {"service": {"name": "api", "port": {"number": 8080}}}},
+        {"path": "/healthz", "pathType": "Exact", "backend": {"service": {"name": "web", "port": {"number": 80}}}}
+      ]}}]
+    }
+  },
+  {
+    "metadata": {"name": "legacy", "namespace": "prod", "annotations": {"kubernetes.io/ingress.class": "liteproxy"}},
+    "spec": {"rules": [{"host": "legacy.example.com", "http": {"paths": [
+      {"backend": {"service": {"name": "legacy", "port": {"number": %d}}}}
+    ]}}]}
+  },
+  {
+    "metadata": {"name": "other", "namespace": "prod"},
+    "spec": {"ingressClassName": "nginx", "rules": [{"host": "other.example.com", "http": {"paths": [
+      {"path": "/", "backend": {"service": {"name": "other", "port": {"number": 80}}}}
+    ]}}]}
+  },
+  {
+    "metadata": {"name": "named-port", "namespace": "prod"},
+    "spec": {"ingressClassName": "liteproxy", "rules": [{"host": "named.example.com", "http": {"paths": [
+      {"path": "/", "backend": {"service": {"name": "named", "port": {"name": "http"}}}}
+    ]}}]}
+  }
+]}`
+
+func TestRoutes(t *testing.T) {
+	var list struct {
+		Items []Ingress `json:"items"`
+	}
+	if err := json.Unmarshal(fmt.Appendf(nil, ingressesJSON, 1, 3000), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := Routes(list.Items, DefaultIngressClass, false)
+	if len(routes) != 4 {
+		t.Fatalf("got %d routes, want 4 (other class and named port skipped): %+v", len(routes), routes)
+	}
+	want := []struct {
+		host, path, backend string
+		port                int
+	}{
+		{"shop.example.com", "/", "web.prod.svc", 80},
+		{"shop.example.com", "/api", "api.prod.svc", 8080},
+		{"shop.example.com", "=/healthz", "web.prod.svc", 80},
+		{"legacy.example.com", "/", "legacy.prod.svc", 3000},
+	}
+	for i, w := range want {
+		r := routes[i]
+		if r.Host != w.host || r.Path() != w.path || r.DialHost() != w.backend || r.ServicePort != w.port || r.Project != "prod" {
+			t.Errorf("route %d = %s%s -> %s:%d (%s), want %s%s -> %s:%d", i, r.Host, r.Path(), r.DialHost(), r.ServicePort, r.Project, w.host, w.path, w.backend, w.port)
+		}
+	}
+	if routes[0].Timeout == nil || routes[0].Timeout.Duration != 30*time.Second {
+		t.Errorf("annotation liteproxy.timeout not applied: %+v", routes[0].Timeout)
+	}
+}
+
+func TestRestrictedAnnotations(t *testing.T) {
+	ing := func(annotations map[string]string) []Ingress {
+		var i Ingress
+		if err := json.Unmarshal([]byte(`{"ingressClassName": "liteproxy", "rules": [{"host": "shop.example.com", "http": {"paths": [
+			{"path": "/", "backend": {"service": {"name": "web", "port": {"number": 80}}}}
+		]}}]}`), &i.Spec); err != nil {
+			t.Fatal(err)
+		}
+		i.Metadata.Name, i.Metadata.Namespace, i.Metadata.Annotations = "shop", "prod", annotations
+		return []Ingress{i}
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		trusted     bool
+		want        int
+	}{
+		{"plain", map[string]string{compose.LabelTimeout: "30s"}, false, 1},
+		{"backends", map[string]string{compose.LabelBackends: "db.internal:5432"}, false, 0},
+		{"backends, trusted", map[string]string{compose.LabelBackends: "db.internal:5432"}, true, 1},
+		{"start command", map[string]string{compose.LabelScaledToZeroStart: "rm -rf /"}, false, 0},
+		{"waf file", map[string]string{compose.LabelWAF: "/etc/passwd"}, false, 0},
+		{"builtin waf", map[string]string{compose.LabelWAF: "builtin"}, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Routes(ing(tt.annotations), DefaultIngressClass, tt.trusted); len(got) != tt.want {
+				t.Errorf("got %d routes, want %d: %+v", len(got), tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	var version, legacyPort atomic.Int32
+	version.Store(1)
+	legacyPort.Store(3000)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("watch") == "true" {
+			// The first watch sees a change; later ones see nothing until they time out
+			if r.URL.Query().Get("resourceVersion") == "1" {
+				legacyPort.Store(4000)
+				version.Store(2)
+				fmt.Fprintln(w, `{"type": "MODIFIED", "object": {}}`)
+				return
+			}
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprintf(w, ingressesJSON, version.Load(), legacyPort.Load())
+	}))
+	defer api.Close()
+
+	tokenFile := t.TempDir() + "/token"
+	os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600)
+	c := &Client{base: api.URL, tokenFile: tokenFile, http: api.Client()}
+
+	routes, v, err := c.Routes(context.Background(), DefaultIngressClass)
+	if err != nil || v != "1" || len(routes) != 4 {
+		t.Fatalf("Routes() = %d routes, version %q, error %v", len(routes), v, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan []compose.Route, 10)
+	go c.Watch(ctx, DefaultIngressClass, routes, v, func(routes []compose.Route) { changes <- routes })
+
+	select {
+	case routes := <-changes:
+		if routes[3].ServicePort != 4000 {
+			t.Errorf("after change: legacy port %d, want 4000", routes[3].ServicePort)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+	select {
+	case routes := <-changes:
+		t.Errorf("unexpected second change: %+v", routes)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,336 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Environment passed to the process started by Upgrade
+const (
+	envListenFDs = "LITEPROXY_LISTEN_FDS" // network:addr of each inherited socket, from fd 3
+	envReadyFD   = "LITEPROXY_READY_FD"   // pipe written once the new process is serving
+)
+
+// fileSocket is a socket that can be duplicated into another process
+type fileSocket interface {
+	File() (*os.File, error)
+}
+
+// socket is an open listening socket that Upgrade passes on
+type socket struct {
+	name string // network:addr, as requested
+	file fileSocket
+	stop io.Closer
+}
+
+var (
+	socketsMu sync.Mutex
+	sockets   []*socket
+
+	inheritMu sync.Mutex
+	inherited map[string][]*os.File // nil until first read from the environment
+
+	readyOnce sync.Once
+
+	draining atomic.Bool
+	active   atomic.Int64
+)
+
+func register(s *socket) {
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	sockets = append(sockets, s)
+}
+
+func unregister(s *socket) {
+	socketsMu.Lock()
+	defer socketsMu.Unlock()
+	for i, o := range sockets {
+		if o == s {
+			sockets = append(sockets[:i], sockets[i+1:]...)
+			return
+		}
+	}
+}
+
+// inherit returns a socket for name passed by the previous process, if any
+func inherit(name string) *os.File {
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	if inherited == nil {
+		inherited = make(map[string][]*os.File)
+		names := os.Getenv(envListenFDs)
+		os.Unsetenv(envListenFDs)
+		if names != "" {
+			for i, n := range strings.Split(names, ",") {
+				inherited[n] = append(inherited[n], os.NewFile(uintptr(3+i), n))
+			}
+		}
+	}
+	files := inherited[name]
+	if len(files) == 0 {
+		return nil
+	}
+	inherited[name] = files[1:]
+	return files[0]
+}
+
+// openTCP opens a TCP socket on bind, or takes over one passed by the
+// previous process for addr during an upgrade
+func openTCP(lc *net.ListenConfig, addr, bind string) (net.Listener, error) {
+	if f := inherit("tcp:" + addr); f != nil {
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return lc.Listen(context.Background(), "tcp", bind)
+}
+
+// track registers ln, opened on addr, for Upgrade and Drain. inner is ln
+// with any tuning applied.
+func track(addr string, ln, inner net.Listener) net.Listener {
+	fs, ok := ln.(fileSocket)
+	if !ok {
+		return inner
+	}
+	h := &handoffListener{Listener: inner}
+	h.socket = &socket{name: "tcp:" + addr, file: fs, stop: ln}
+	register(h.socket)
+	return h
+}
+
+// ListenPacket opens a UDP socket on addr, or takes over the one passed by
+// the previous process during an upgrade
+func ListenPacket(addr string) (net.PacketConn, error) {
+	var (
+		conn net.PacketConn
+		err  error
+	)
+	name := "udp:" + addr
+	if f := inherit(name); f != nil {
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		conn, err = net.ListenPacket("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fs, ok := conn.(fileSocket)
+	if !ok {
+		return conn, nil
+	}
+	p := &handoffPacketConn{PacketConn: conn}
+	p.socket = &socket{name: name, file: fs, stop: conn}
+	register(p.socket)
+	return p, nil
+}
+
+// handoffListener counts accepted connections so Drain can wait for them,
+// and stops accepting once the sockets have been handed to a new process
+type handoffListener struct {
+	net.Listener
+	socket *socket
+}
+
+func (l *handoffListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		if draining.Load() {
+			// The new process accepts from here on; block rather than
+			// fail so servers don't treat it as fatal
+			select {}
+		}
+		return nil, err
+	}
+	active.Add(1)
+	return &handoffConn{Conn: conn}, nil
+}
+
+func (l *handoffListener) Close() error {
+	unregister(l.socket)
+	return l.Listener.Close()
+}
+
+// handoffPacketConn is a UDP socket that can be handed to a new process
+type handoffPacketConn struct {
+	net.PacketConn
+	socket *socket
+}
+
+func (c *handoffPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil && draining.Load() {
+		select {}
+	}
+	return n, addr, err
+}
+
+func (c *handoffPacketConn) Close() error {
+	unregister(c.socket)
+	return c.PacketConn.Close()
+}
+
+// handoffConn is an accepted connection that counts towards Drain
+type handoffConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *handoffConn) Close() error {
+	c.once.Do(func() { active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// CloseWrite half-closes the connection when the underlying one supports it
+func (c *handoffConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// ReadFrom and WriteTo keep splice(2) available to io.Copy
+func (c *handoffConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{c.Conn}, r)
+}
+
+func (c *handoffConn) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := c.Conn.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.Copy(w, struct{ io.Reader }{c.Conn})
+}
+
+// Upgrade starts a new process from the current executable with the same
+// arguments, passing it every open listening socket, and waits up to
+// timeout for it to call Ready. The caller should then Drain and exit.
+func Upgrade(timeout time.Duration) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	socketsMu.Lock()
+	var (
+		files []*os.File
+		names []string
+	)
+	for _, s := range sockets {
+		f, err := s.file.File()
+		if err != nil {
+			socketsMu.Unlock()
+			closeFiles(files)
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		files = append(files, f)
+		names = append(names, s.name)
+	}
+	socketsMu.Unlock()
+	defer closeFiles(files)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envListenFDs+"=") && !strings.HasPrefix(kv, envReadyFD+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		envListenFDs+"="+strings.Join(names, ","),
+		envReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+	p, err := startProcess(exe, os.Args[1:], env, append(files, w))
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// The pipe reaches EOF without a byte if the new process exits first
+	ready := make(chan bool, 1)
+	go func() {
+		var b [1]byte
+		n, _ := r.Read(b[:])
+		ready <- n == 1
+	}()
+	select {
+	case ok := <-ready:
+		if ok {
+			return p, nil
+		}
+		p.Wait()
+		return nil, errors.New("new process exited before it was ready")
+	case <-time.After(timeout):
+		p.Kill()
+		p.Wait()
+		return nil, fmt.Errorf("new process not ready after %s", timeout)
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// Ready tells the process that started this one with Upgrade that it is
+// serving, and closes inherited sockets the new configuration didn't use.
+// It does nothing if this process wasn't started by Upgrade.
+func Ready() {
+	readyOnce.Do(func() {
+		inherit("")
+		inheritMu.Lock()
+		for name, files := range inherited {
+			closeFiles(files)
+			delete(inherited, name)
+		}
+		inheritMu.Unlock()
+
+		fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+		os.Unsetenv(envReadyFD)
+		if err != nil {
+			return
+		}
+		f := os.NewFile(uintptr(fd), "ready")
+		f.Write([]byte{1})
+		f.Close()
+	})
+}
+
+// Drain stops accepting on every socket, leaving new connections to the
+// process started by Upgrade, and waits up to timeout for open connections
+// to close. It reports whether they all did.
+func Drain(timeout time.Duration) bool {
+	draining.Store(true)
+	socketsMu.Lock()
+	for _, s := range sockets {
+		s.stop.Close()
+	}
+	sockets = nil
+	socketsMu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for active.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The upgrade test re-runs this binary as the new process
+const (
+	envHandoffChild     = "LITEPROXY_TEST_HANDOFF_CHILD"
+	envHandoffAcceptors = "LITEPROXY_TEST_HANDOFF_ACCEPTORS" // listen with the tuned profile
+)
+
+func TestMain(m *testing.M) {
+	if addr := os.Getenv(envHandoffChild); addr != "" {
+		os.Exit(handoffChild(addr))
+	}
+	os.Exit(m.Run())
+}
+
+// handoffChild takes over addr, reports ready and answers one connection.
+// It fails if any socket passed for addr was left unused.
+func handoffChild(addr string) int {
+	var opts Options
+	if n, _ := strconv.Atoi(os.Getenv(envHandoffAcceptors)); n > 0 {
+		opts = Options{Profile: ProfileTuned, Acceptors: n}
+	}
+	ln, err := Listen(addr, opts)
+	if err != nil {
+		return 1
+	}
+	if inherit("tcp:"+addr) != nil {
+		return 2
+	}
+	Ready()
+	conn, err := ln.Accept()
+	if err != nil {
+		return 1
+	}
+	conn.Write([]byte("new"))
+	conn.Close()
+	return 0
+}
+
+func TestUpgrade(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"default", Options{}},
+		{"tuned", Options{Profile: ProfileTuned, Acceptors: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.Profile == ProfileTuned && !tuningSupported {
+				t.Skip("tuned profile requires Linux")
+			}
+			testUpgrade(t, tt.opts)
+		})
+	}
+}
+
+// testUpgrade hands a listener opened with opts to a new process
+func testUpgrade(t *testing.T, opts Options) {
+	const addr = "127.0.0.1:0"
+	ln, err := Listen(addr, opts)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { draining.Store(false) })
+
+	// A connection accepted before the upgrade stays with this process
+	old, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+
+	t.Setenv(envHandoffChild, addr)
+	t.Setenv(envHandoffAcceptors, strconv.Itoa(opts.Acceptors))
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^$"}
+	defer func() { os.Args = args }()
+	p, err := Upgrade(10 * time.Second)
+	if err != nil {
+		t.Fatalf("Upgrade() error = %v", err)
+	}
+
+	drained := make(chan bool, 1)
+	go func() { drained <- Drain(5 * time.Second) }()
+
+	// Nothing accepts from ln here, so dial once its sockets are closed
+	for {
+		socketsMu.Lock()
+		n := len(sockets)
+		socketsMu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// New connections reach the new process on the same port
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() after upgrade error = %v", err)
+	}
+	got, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil || string(got) != "new" {
+		t.Errorf("after upgrade read %q, %v, want %q", got, err, "new")
+	}
+	if state, err := p.Wait(); err != nil || !state.Success() {
+		t.Errorf("new process exited with %v, %v", state, err)
+	}
+
+	// Drain waits for the old connection
+	select {
+	case <-drained:
+		t.Fatal("Drain() returned with a connection still open")
+	case <-time.After(200 * time.Millisecond):
+	}
+	server.Close()
+	old.Close()
+	if !<-drained {
+		t.Error("Drain() = false, want true once connections close")
+	}
+}
+
+func TestUpgradeFails(t *testing.T) {
+	// The new process can't listen on a bad address and exits
+	t.Setenv(envHandoffChild, "bad address")
+	if _, err := Upgrade(10 * time.Second); err == nil {
+		t.Error("Upgrade() error = nil, want an error when the new process exits")
+	}
+}
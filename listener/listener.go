@@ -0,0 +1,173 @@
+// Package listener opens the proxy's TCP listeners, optionally tuned for
+// high connection rates
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+)
+
+// Performance profiles
+const (
+	ProfileDefault = "default" // a single standard Go listener
+	ProfileTuned   = "tuned"   // SO_REUSEPORT acceptors and TCP_QUICKACK (Linux only)
+)
+
+// Options controls how listeners are opened
+type Options struct {
+	Profile   string // default or tuned
+	Acceptors int    // SO_REUSEPORT sockets per address for the tuned profile (0 = GOMAXPROCS)
+	Nagle     bool   // enable Nagle's algorithm (clear TCP_NODELAY, which Go sets by default)
+}
+
+// Valid reports whether profile is a known performance profile
+func Valid(profile string) bool {
+	return profile == ProfileDefault || profile == ProfileTuned
+}
+
+// Listen opens a TCP listener on addr according to opts. During an upgrade
+// it takes over the sockets passed by the previous process instead.
+func Listen(addr string, opts Options) (net.Listener, error) {
+	if opts.Profile != ProfileTuned {
+		ln, err := openTCP(&net.ListenConfig{}, addr, addr)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Nagle {
+			return track(addr, ln, &tuned{Listener: ln, nagle: true}), nil
+		}
+		return track(addr, ln, ln), nil
+	}
+
+	if !tuningSupported {
+		return nil, fmt.Errorf("performance profile %q requires Linux", ProfileTuned)
+	}
+
+	n := opts.Acceptors
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	bind := addr
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		// Every socket is passed on under the requested address, so only
+		// fresh binds use the resolved one
+		ln, err := openTCP(&lc, addr, bind)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		// Later sockets must bind the port the first one got (for ":0")
+		if i == 0 {
+			bind = ln.Addr().String()
+		}
+		lns = append(lns, track(addr, ln, &tuned{Listener: ln, nagle: opts.Nagle, quickAck: true}))
+	}
+	if n == 1 {
+		return lns[0], nil
+	}
+	return newMulti(lns), nil
+}
+
+// tuned applies socket options to accepted connections
+type tuned struct {
+	net.Listener
+	nagle    bool
+	quickAck bool
+}
+
+func (l *tuned) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if l.nagle {
+			tc.SetNoDelay(false)
+		}
+		if l.quickAck {
+			setQuickAck(tc)
+		}
+	}
+	return conn, nil
+}
+
+// multi fans in connections from several SO_REUSEPORT sockets, each with its
+// own accept goroutine, so the kernel spreads new connections across them
+type multi struct {
+	lns   []net.Listener
+	conns chan net.Conn
+	errs  chan error
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newMulti(lns []net.Listener) *multi {
+	m := &multi{
+		lns:   lns,
+		conns: make(chan net.Conn),
+		errs:  make(chan error, len(lns)),
+		done:  make(chan struct{}),
+	}
+	for _, ln := range lns {
+		go m.accept(ln)
+	}
+	return m
+}
+
+func (m *multi) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			// Pass errors on so the server can back off (e.g. EMFILE),
+			// but keep accepting unless the socket is closed
+			select {
+			case m.errs <- err:
+			case <-m.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		select {
+		case m.conns <- conn:
+		case <-m.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (m *multi) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case err := <-m.errs:
+		return nil, err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multi) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.done)
+		for _, ln := range m.lns {
+			if cerr := ln.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+func (m *multi) Addr() net.Addr { return m.lns[0].Addr() }
@@ -0,0 +1,84 @@
+package listener
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestListenDefault(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", Options{Profile: ProfileDefault})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	h, ok := ln.(*handoffListener)
+	if !ok {
+		t.Fatalf("Listen() = %T, want *handoffListener", ln)
+	}
+	if _, ok := h.Listener.(*net.TCPListener); !ok {
+		t.Errorf("Listen() wraps %T, want a plain *net.TCPListener", h.Listener)
+	}
+	roundTrip(t, ln)
+}
+
+func TestListenTuned(t *testing.T) {
+	if !tuningSupported {
+		if _, err := Listen("127.0.0.1:0", Options{Profile: ProfileTuned}); err == nil {
+			t.Error("Listen() error = nil, want unsupported profile error")
+		}
+		return
+	}
+
+	ln, err := Listen("127.0.0.1:0", Options{Profile: ProfileTuned, Acceptors: 4})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		roundTrip(t, ln)
+	}
+
+	ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() after Close = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for profile, want := range map[string]bool{"default": true, "tuned": true, "io_uring": false, "": false} {
+		if got := Valid(profile); got != want {
+			t.Errorf("Valid(%q) = %v, want %v", profile, got, want)
+		}
+	}
+}
+
+// roundTrip dials ln, accepts the connection and echoes a byte through it
+func roundTrip(t *testing.T, ln net.Listener) {
+	t.Helper()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer server.Close()
+
+	if hc, ok := server.(*handoffConn); !ok {
+		t.Errorf("accepted %T, want *handoffConn", server)
+	} else if _, ok := hc.Conn.(*net.TCPConn); !ok {
+		t.Errorf("accepted %T underneath, want *net.TCPConn so copies can use splice", hc.Conn)
+	}
+	client.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(server, buf); err != nil || buf[0] != 'x' {
+		t.Errorf("read %q, %v, want x", buf, err)
+	}
+}
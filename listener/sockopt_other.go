@@ -0,0 +1,14 @@
+//go:build !linux
+
+package listener
+
+import (
+	"net"
+	"syscall"
+)
+
+const tuningSupported = false
+
+func reusePort(network, address string, c syscall.RawConn) error { return nil }
+
+func setQuickAck(conn *net.TCPConn) {}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package listener
+
+import (
+	"os"
+	"syscall"
+)
+
+// startProcess starts exe with args, passing files from fd 3. The fds are
+// passed as they are: os/exec would call Fd, which puts the sockets this
+// process still accepts from in blocking mode, and an Accept stuck in the
+// kernel keeps Drain from closing them.
+func startProcess(exe string, args, env []string, files []*os.File) (*os.Process, error) {
+	fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, f := range files {
+		rc, err := f.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		rc.Control(func(fd uintptr) { fds = append(fds, fd) })
+	}
+	pid, _, err := syscall.StartProcess(exe, append([]string{exe}, args...), &syscall.ProcAttr{Env: env, Files: fds})
+	if err != nil {
+		return nil, err
+	}
+	return os.FindProcess(pid)
+}
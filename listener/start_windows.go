@@ -0,0 +1,11 @@
+package listener
+
+import (
+	"errors"
+	"os"
+)
+
+// startProcess fails: Windows processes can't inherit sockets by fd
+func startProcess(exe string, args, env []string, files []*os.File) (*os.Process, error) {
+	return nil, errors.New("upgrades are not supported on Windows")
+}
@@ -0,0 +1,50 @@
+// Package logging sets up liteproxy's leveled logger. Lines are written as
+// logfmt-style text for people or as JSON for log shippers, and lines from
+// the standard log package go through the same handler.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Output formats for Setup
+const (
+	FormatText = "text" // key=value pairs
+	FormatJSON = "json" // one JSON object per line
+)
+
+// Setup makes a handler writing to w in format, dropping lines below level
+// (debug, info, warn or error), the default logger. Empty values mean text
+// and info.
+func Setup(w io.Writer, format, level string) error {
+	opts := &slog.HandlerOptions{}
+	if level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log level %q (want debug, info, warn or error)", level)
+		}
+		opts.Level = l
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want %s or %s)", format, FormatText, FormatJSON)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// Fatal logs msg with args at error level and exits, like log.Fatal
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		level   string
+		want    []string // in the output, in order
+		wantErr string
+	}{
+		{name: "defaults", want: []string{`level=INFO msg="backend up" addr=10.0.0.1:80`, `level=WARN msg="backend down"`, `level=INFO msg="from log"`}},
+		{name: "text", format: "text", level: "warn", want: []string{`level=WARN msg="backend down"`}},
+		{name: "json", format: "JSON", level: "debug", want: []string{`"level":"DEBUG","msg":"probing"`, `"msg":"backend up","addr":"10.0.0.1:80"`}},
+		{name: "invalid format", format: "xml", wantErr: `invalid log format "xml"`},
+		{name: "invalid level", level: "loud", wantErr: `invalid log level "loud"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Setup(&buf, tt.format, tt.level)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Setup() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			slog.Debug("probing")
+			slog.Info("backend up", "addr", "10.0.0.1:80")
+			slog.Warn("backend down")
+			log.Print("from log")
+
+			out := buf.String()
+			rest := out
+			for _, want := range tt.want {
+				i := strings.Index(rest, want)
+				if i < 0 {
+					t.Fatalf("output missing %q after earlier lines:\n%s", want, out)
+				}
+				rest = rest[i+len(want):]
+			}
+			if tt.level == "warn" && strings.Contains(out, "INFO") {
+				t.Errorf("info line logged at level warn:\n%s", out)
+			}
+			if tt.format == "JSON" {
+				for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+					if !json.Valid([]byte(line)) {
+						t.Errorf("not JSON: %s", line)
+					}
+				}
+			}
+		})
+	}
+}
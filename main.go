@@ -1,54 +1,451 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/localrivet/liteproxy/accesslog"
+	"github.com/localrivet/liteproxy/admin"
 	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/egress"
+	"github.com/localrivet/liteproxy/errlog"
+	"github.com/localrivet/liteproxy/flags"
+	"github.com/localrivet/liteproxy/health"
+	"github.com/localrivet/liteproxy/kube"
+	"github.com/localrivet/liteproxy/listener"
+	"github.com/localrivet/liteproxy/logging"
+	"github.com/localrivet/liteproxy/metrics"
 	"github.com/localrivet/liteproxy/passthrough"
 	"github.com/localrivet/liteproxy/proxy"
+	"github.com/localrivet/liteproxy/proxyproto"
 	"github.com/localrivet/liteproxy/router"
+	"github.com/localrivet/liteproxy/sandbox"
+	"github.com/localrivet/liteproxy/socks"
+	"github.com/localrivet/liteproxy/spiffe"
 	liteTLS "github.com/localrivet/liteproxy/tls"
 	"github.com/localrivet/liteproxy/watcher"
-	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/acme"
 )
 
+// configReloads counts reloads from every trigger: file watchers, SIGHUP,
+// the admin API and Kubernetes
+var configReloads = metrics.Default.NewCounterVec(
+	"liteproxy_config_reloads_total",
+	"Configuration reloads by result. After an error the previous routes stay loaded.",
+	"result")
+
 // Config holds all configuration loaded from environment variables
 type Config struct {
-	ComposeFile  string
+	ComposeFiles []string
+	ComposeDir   string // every compose and site file in it is loaded too
 	HTTPPort     int
 	HTTPSPort    int
 	ACMEEmail    string
 	ACMEDir      string
 	HTTPSEnabled bool
+	TLSMode      string // acme or local
+	HTTP3        bool   // also serve HTTP/3 over QUIC on the HTTPS port
+	HTTPEnabled  bool   // serve the main HTTP listener (redirects, ACME, passthrough)
+	ACMEHTTPAddr string // optional: separate listener for ACME HTTP-01 challenges
 	Watch        bool
+	Kubernetes   bool   // also route Kubernetes Ingresses, watched through the API
+	IngressClass string // ingress class served in Kubernetes mode
+
+	ExternalHTTPPort  int // port clients reach HTTP on, for redirects behind NAT (0 = as requested)
+	ExternalHTTPSPort int // port clients reach HTTPS on, for redirects behind NAT (0 = as requested)
+
+	ACMEPreCheck    bool          // verify DNS points here before requesting certificates
+	ACMECAACheck    bool          // verify CAA records allow Let's Encrypt before requesting certificates
+	PublicIPs       []string      // this server's public IPs for pre-checks (default: interface addresses)
+	ACMEConcurrency int           // certificates requested in parallel
+	ACMEPerHour     int           // new certificate orders per hour, 0 = unlimited
+	ACMERehearsal   time.Duration // how often to rehearse issuance against ACME staging, 0 = never
+
+	ACMEDirectory    string                       // ACME directory certificates are ordered from
+	ACMEEAB          *acme.ExternalAccountBinding // external account binding for CAs that require one
+	ACMERehearsalEAB *acme.ExternalAccountBinding // external account binding at the staging directory rehearsals use
+	ACMECAAIssuer    string                       // CAA issuer domain of the ACME CA, "" if unknown
+
+	AdminAddr string // empty disables the admin API; a bare port binds to 127.0.0.1
+
+	EgressAddr  string   // empty disables the egress (forward) proxy
+	EgressAllow []string // destinations the egress proxy may reach
+	EgressUsers []string // user:password credentials for the egress proxy
+
+	SOCKSAddr  string   // empty disables the SOCKS5 listener for developer access
+	SOCKSUsers []string // user:password credentials for the SOCKS5 listener
+	SOCKSAllow []string // addresses or CIDRs SOCKS5 clients reach besides routed backends
+
+	MetricsAddr     string // empty disables the metrics endpoint
+	MetricsHostMode string // route or request
+	MetricsMaxHosts int    // distinct request hosts before folding into "other"
+
+	AccessLog              string   // stdout, stderr or a file path; empty disables
+	AccessLogHeaders       []string // request headers to include in entries
+	AccessLogRedactQuery   []string // query parameters whose values are masked
+	AccessLogRedactHeaders []string // headers whose values are masked
+	AccessLogRedactPaths   []string // patterns for path segments to mask
+	AccessLogSinks         []string // remote collectors: syslog+udp/tcp/tls://, loki+http(s):// or http(s):// URLs
+
+	ClientConcurrency int // in-flight requests per client IP on routes without a limit, 0 = unlimited
+
+	DebugHeaders bool // add X-Liteproxy-Upstream to responses
+	TLSHeaders   bool // add X-TLS-* headers to requests to backends
+	TLSDebug     bool // log every failed TLS handshake
+
+	PerfProfile string // listener profile: default or tuned
+	Acceptors   int    // SO_REUSEPORT sockets per port for the tuned profile (0 = GOMAXPROCS)
+	TCPNoDelay  bool   // disable Nagle's algorithm on client connections
+
+	ProxyProtocol  []string // load balancer addresses or CIDRs that send PROXY protocol headers
+	TrustedProxies []string // proxy addresses or CIDRs whose X-Forwarded-* headers are passed on
+
+	SPIFFESocket string // SPIFFE Workload API socket for upstream_tls.spiffe_id routes
+
+	UpgradeDrain time.Duration // how long the old process keeps serving open connections after an upgrade
+	PIDFile      string        // file holding the serving process ID, rewritten by upgrades
+
+	RunAs   *sandbox.User // user to switch to once listening, nil = stay as started
+	Sandbox bool          // restrict file writes to the certificate, log and temp directories
+
+	ErrorPages string // directory of HTML error page templates
+	Flags      string // feature flags file or http(s) URL for liteproxy.flags routes
+
+	LogFormat        string        // text or json
+	LogLevel         string        // debug, info, warn or error
+	ErrorLogInterval time.Duration // repeats of an error logged at most ErrorLogBurst times per interval, 0 = no limit
+	ErrorLogBurst    int
+
+	StartCommands bool // let liteproxy.scaled_to_zero.start run commands, not only webhooks
+
+	IngressTrustAll bool // let Ingress annotations set labels that reach past their own service
 }
 
 func loadConfig() Config {
 	cfg := Config{
-		ComposeFile:  getEnv("LITEPROXY_COMPOSE_FILE", "./compose.yaml"),
+		ComposeFiles: getEnvList("LITEPROXY_COMPOSE_FILE", defaultComposeFiles()),
+		ComposeDir:   os.Getenv("LITEPROXY_COMPOSE_DIR"),
 		HTTPPort:     getEnvInt("LITEPROXY_HTTP_PORT", 80),
 		HTTPSPort:    getEnvInt("LITEPROXY_HTTPS_PORT", 443),
 		ACMEEmail:    os.Getenv("LITEPROXY_ACME_EMAIL"),
 		ACMEDir:      getEnv("LITEPROXY_ACME_DIR", "./certs"),
 		HTTPSEnabled: getEnvBool("LITEPROXY_HTTPS_ENABLED", false),
+		TLSMode:      getEnv("LITEPROXY_TLS_MODE", liteTLS.ModeACME),
+		HTTP3:        getEnvBool("LITEPROXY_HTTP3", false),
+		HTTPEnabled:  getEnvBool("LITEPROXY_HTTP_ENABLED", true),
+		ACMEHTTPAddr: os.Getenv("LITEPROXY_ACME_HTTP_ADDR"),
 		Watch:        getEnvBool("LITEPROXY_WATCH", false),
+		Kubernetes:   getEnvBool("LITEPROXY_KUBERNETES", false),
+		IngressClass: getEnv("LITEPROXY_INGRESS_CLASS", kube.DefaultIngressClass),
+		SPIFFESocket: getEnv("LITEPROXY_SPIFFE_SOCKET", os.Getenv("SPIFFE_ENDPOINT_SOCKET")),
+		ErrorPages:   os.Getenv("LITEPROXY_ERROR_PAGES"),
+		Flags:        os.Getenv("LITEPROXY_FLAGS"),
+
+		ExternalHTTPPort:  getEnvInt("LITEPROXY_EXTERNAL_HTTP_PORT", 0),
+		ExternalHTTPSPort: getEnvInt("LITEPROXY_EXTERNAL_HTTPS_PORT", 0),
+
+		ACMEPreCheck:    getEnvBool("LITEPROXY_ACME_PRECHECK", false),
+		ACMECAACheck:    getEnvBool("LITEPROXY_ACME_CAA_CHECK", true),
+		PublicIPs:       getEnvList("LITEPROXY_PUBLIC_IP", nil),
+		ACMEConcurrency: getEnvInt("LITEPROXY_ACME_CONCURRENCY", liteTLS.DefaultIssueConcurrency),
+		ACMEPerHour:     getEnvInt("LITEPROXY_ACME_MAX_PER_HOUR", liteTLS.DefaultIssuePerHour),
+
+		ACMEDirectory: getEnv("LITEPROXY_ACME_DIRECTORY_URL", acme.LetsEncryptURL),
+		ACMECAAIssuer: os.Getenv("LITEPROXY_ACME_CAA_ISSUER"),
+
+		AdminAddr: os.Getenv("LITEPROXY_ADMIN_ADDR"),
+
+		EgressAddr:  os.Getenv("LITEPROXY_EGRESS_ADDR"),
+		EgressAllow: getEnvList("LITEPROXY_EGRESS_ALLOW", nil),
+		EgressUsers: getEnvList("LITEPROXY_EGRESS_USERS", nil),
+
+		SOCKSAddr:  os.Getenv("LITEPROXY_SOCKS_ADDR"),
+		SOCKSUsers: getEnvList("LITEPROXY_SOCKS_USERS", nil),
+		SOCKSAllow: getEnvList("LITEPROXY_SOCKS_ALLOW", nil),
+
+		MetricsAddr:     os.Getenv("LITEPROXY_METRICS_ADDR"),
+		MetricsHostMode: getEnv("LITEPROXY_METRICS_HOST_MODE", metrics.HostRoute),
+		MetricsMaxHosts: getEnvInt("LITEPROXY_METRICS_MAX_HOSTS", 1000),
+
+		AccessLog:              os.Getenv("LITEPROXY_ACCESS_LOG"),
+		AccessLogHeaders:       getEnvList("LITEPROXY_ACCESS_LOG_HEADERS", []string{"User-Agent", "Referer"}),
+		AccessLogRedactQuery:   getEnvList("LITEPROXY_ACCESS_LOG_REDACT_QUERY", accesslog.DefaultRedactQuery),
+		AccessLogRedactHeaders: getEnvList("LITEPROXY_ACCESS_LOG_REDACT_HEADERS", accesslog.DefaultRedactHeaders),
+		AccessLogRedactPaths:   getEnvList("LITEPROXY_ACCESS_LOG_REDACT_PATHS", nil),
+		AccessLogSinks:         getEnvList("LITEPROXY_ACCESS_LOG_SINKS", nil),
+
+		ClientConcurrency: getEnvInt("LITEPROXY_CLIENT_CONCURRENCY", 0),
+
+		DebugHeaders: getEnvBool("LITEPROXY_DEBUG_HEADERS", false),
+		TLSHeaders:   getEnvBool("LITEPROXY_TLS_HEADERS", false),
+		TLSDebug:     getEnvBool("LITEPROXY_TLS_DEBUG", false),
+
+		PerfProfile: getEnv("LITEPROXY_PERF_PROFILE", listener.ProfileDefault),
+		Acceptors:   getEnvInt("LITEPROXY_ACCEPTORS", 0),
+		TCPNoDelay:  getEnvBool("LITEPROXY_TCP_NODELAY", true),
+
+		ProxyProtocol:  getEnvList("LITEPROXY_PROXY_PROTOCOL", nil),
+		TrustedProxies: getEnvList("LITEPROXY_TRUSTED_PROXIES", nil),
+
+		UpgradeDrain: 30 * time.Second,
+		PIDFile:      os.Getenv("LITEPROXY_PID_FILE"),
+
+		Sandbox: getEnvBool("LITEPROXY_SANDBOX", false),
+
+		LogFormat:        getEnv("LITEPROXY_LOG_FORMAT", logging.FormatText),
+		LogLevel:         getEnv("LITEPROXY_LOG_LEVEL", "info"),
+		ErrorLogInterval: errlog.DefaultInterval,
+		ErrorLogBurst:    getEnvInt("LITEPROXY_ERROR_LOG_BURST", errlog.DefaultBurst),
+
+		StartCommands: getEnvBool("LITEPROXY_START_COMMANDS", false),
+
+		IngressTrustAll: getEnvBool("LITEPROXY_INGRESS_TRUST_ANNOTATIONS", false),
+	}
+
+	// Set up logging first, so configuration errors come out in its format
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel); err != nil {
+		logging.Fatal("invalid logging config", "err", err)
+	}
+
+	if v := os.Getenv("LITEPROXY_ACME_REHEARSAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			logging.Fatal("invalid LITEPROXY_ACME_REHEARSAL (want a duration like 168h)", "value", v)
+		}
+		cfg.ACMERehearsal = d
+	}
+	if cfg.ACMERehearsal > 0 && liteTLS.StagingFor(cfg.ACMEDirectory) == "" {
+		logging.Fatal("LITEPROXY_ACME_REHEARSAL: no known staging directory", "acme_directory", cfg.ACMEDirectory)
+	}
+	if v := os.Getenv("LITEPROXY_ERROR_LOG_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			logging.Fatal("invalid LITEPROXY_ERROR_LOG_INTERVAL (want a duration like 1m, or 0)", "value", v)
+		}
+		cfg.ErrorLogInterval = d
+	}
+	if v := os.Getenv("LITEPROXY_UPGRADE_DRAIN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			logging.Fatal("invalid LITEPROXY_UPGRADE_DRAIN (want a duration like 30s)", "value", v)
+		}
+		cfg.UpgradeDrain = d
+	}
+	if getEnvBool("LITEPROXY_ACME_STAGING", false) {
+		staging := liteTLS.StagingFor(cfg.ACMEDirectory)
+		if staging == "" {
+			logging.Fatal("LITEPROXY_ACME_STAGING: no known staging directory; set LITEPROXY_ACME_DIRECTORY_URL to it", "acme_directory", cfg.ACMEDirectory)
+		}
+		cfg.ACMEDirectory = staging
+	}
+	if liteTLS.IsStaging(cfg.ACMEDirectory) && cfg.TLSMode == liteTLS.ModeACME {
+		// Untrusted staging certificates must never be served once staging is turned off
+		cfg.ACMEDir = filepath.Join(cfg.ACMEDir, "staging")
+	}
+	cfg.ACMEEAB = getEnvEAB("LITEPROXY_ACME_EAB_KID", "LITEPROXY_ACME_EAB_HMAC_KEY")
+	if cfg.ACMERehearsal > 0 {
+		cfg.ACMERehearsalEAB = getEnvEAB("LITEPROXY_ACME_REHEARSAL_EAB_KID", "LITEPROXY_ACME_REHEARSAL_EAB_HMAC_KEY")
+		if cfg.ACMERehearsalEAB == nil && liteTLS.IsStaging(cfg.ACMEDirectory) {
+			cfg.ACMERehearsalEAB = cfg.ACMEEAB // already the staging directory's
+		}
+		if cfg.ACMERehearsalEAB == nil && liteTLS.StagingNeedsEAB(cfg.ACMEDirectory) {
+			// Every rehearsal would fail to register an account
+			slog.Warn("ACME rehearsals disabled: the staging directory requires its own EAB credentials; set LITEPROXY_ACME_REHEARSAL_EAB_KID and LITEPROXY_ACME_REHEARSAL_EAB_HMAC_KEY",
+				"staging_directory", liteTLS.StagingFor(cfg.ACMEDirectory))
+			cfg.ACMERehearsal = 0
+		}
+	}
+	if cfg.ACMECAAIssuer == "" {
+		cfg.ACMECAAIssuer = liteTLS.CAAIssuerFor(cfg.ACMEDirectory)
+	}
+	if v := os.Getenv("LITEPROXY_USER"); v != "" {
+		if !sandbox.Supported {
+			logging.Fatal("LITEPROXY_USER requires Linux")
+		}
+		u, err := sandbox.ParseUser(v)
+		if err != nil {
+			logging.Fatal("invalid LITEPROXY_USER", "value", v, "err", err)
+		}
+		cfg.RunAs = &u
+	}
+	if cfg.Sandbox && !sandbox.Supported {
+		logging.Fatal("LITEPROXY_SANDBOX requires Linux")
+	}
+	if cfg.TLSMode != liteTLS.ModeACME && cfg.TLSMode != liteTLS.ModeLocal {
+		logging.Fatal("invalid LITEPROXY_TLS_MODE (want "+liteTLS.ModeACME+" or "+liteTLS.ModeLocal+")", "value", cfg.TLSMode)
+	}
+	if cfg.HTTPSEnabled && cfg.TLSMode == liteTLS.ModeACME && cfg.ACMEEmail == "" {
+		logging.Fatal("LITEPROXY_ACME_EMAIL is required when HTTPS is enabled")
+	}
+	if !listener.Valid(cfg.PerfProfile) {
+		logging.Fatal("invalid LITEPROXY_PERF_PROFILE (want "+listener.ProfileDefault+" or "+listener.ProfileTuned+")", "value", cfg.PerfProfile)
+	}
+	if !cfg.HTTPEnabled && !cfg.HTTPSEnabled {
+		logging.Fatal("LITEPROXY_HTTP_ENABLED=false requires HTTPS to be enabled")
+	}
+	if cfg.ACMEHTTPAddr != "" && (!cfg.HTTPSEnabled || cfg.TLSMode != liteTLS.ModeACME) {
+		logging.Fatal("LITEPROXY_ACME_HTTP_ADDR requires HTTPS with LITEPROXY_TLS_MODE=" + liteTLS.ModeACME)
+	}
+	if cfg.HTTP3 && !cfg.HTTPSEnabled {
+		logging.Fatal("LITEPROXY_HTTP3 requires HTTPS to be enabled")
+	}
+	if cfg.HTTP3 && !http3Supported {
+		logging.Fatal("LITEPROXY_HTTP3 requires a build with -tags http3")
 	}
+	if _, err := proxyproto.ParseTrusted(cfg.ProxyProtocol); err != nil {
+		logging.Fatal("invalid LITEPROXY_PROXY_PROTOCOL", "err", err)
+	}
+	if _, err := proxyproto.ParseTrusted(cfg.TrustedProxies); err != nil {
+		logging.Fatal("invalid LITEPROXY_TRUSTED_PROXIES", "err", err)
+	}
+	if cfg.EgressAddr != "" {
+		if _, err := egress.New(cfg.EgressAllow, cfg.EgressUsers); err != nil {
+			logging.Fatal("invalid egress proxy config (LITEPROXY_EGRESS_ALLOW, LITEPROXY_EGRESS_USERS)", "err", err)
+		}
+	}
+	if cfg.SOCKSAddr != "" {
+		if _, err := socks.New(cfg.SOCKSUsers, cfg.SOCKSAllow); err != nil {
+			logging.Fatal("invalid SOCKS5 config (LITEPROXY_SOCKS_USERS, LITEPROXY_SOCKS_ALLOW)", "err", err)
+		}
+	}
+
+	return cfg
+}
+
+// defaultComposeFiles is ./compose.yaml, unless routes come from a compose
+// directory or Kubernetes Ingresses
+func defaultComposeFiles() []string {
+	if os.Getenv("LITEPROXY_COMPOSE_DIR") != "" || getEnvBool("LITEPROXY_KUBERNETES", false) {
+		return nil
+	}
+	return []string{"./compose.yaml"}
+}
 
-	if cfg.HTTPSEnabled && cfg.ACMEEmail == "" {
-		log.Fatal("LITEPROXY_ACME_EMAIL is required when HTTPS is enabled")
+// configFiles returns the compose files to load. The compose directory is
+// listed on every call, so a reload picks up files added to it.
+func (cfg Config) configFiles() ([]string, error) {
+	if cfg.ComposeDir == "" {
+		return cfg.ComposeFiles, nil
+	}
+	files, err := compose.DirFiles(cfg.ComposeDir)
+	if err != nil {
+		return nil, err
 	}
+	return append(slices.Clone(cfg.ComposeFiles), files...), nil
+}
 
+// redacted returns a copy of cfg that is safe to serve from the admin API,
+// with passwords and keys masked
+func (cfg Config) redacted() Config {
+	cfg.EgressUsers = redactUsers(cfg.EgressUsers)
+	cfg.SOCKSUsers = redactUsers(cfg.SOCKSUsers)
+	cfg.ACMEEAB = redactEAB(cfg.ACMEEAB)
+	cfg.ACMERehearsalEAB = redactEAB(cfg.ACMERehearsalEAB)
+	if cfg.AccessLogSinks != nil {
+		sinks := make([]string, len(cfg.AccessLogSinks))
+		for i, rawURL := range cfg.AccessLogSinks {
+			sinks[i] = redactURL(rawURL)
+		}
+		cfg.AccessLogSinks = sinks
+	}
+	if strings.HasPrefix(cfg.Flags, "http://") || strings.HasPrefix(cfg.Flags, "https://") {
+		cfg.Flags = redactURL(cfg.Flags) // a file path has no credentials
+	}
 	return cfg
 }
 
+// redactURL masks the password and query values of a URL, where
+// credentials for endpoints without an auth header of their own go
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q[k] = []string{"xxxxx"}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactEAB drops the HMAC key of an external account binding; the key ID
+// alone cannot register an account
+func redactEAB(eab *acme.ExternalAccountBinding) *acme.ExternalAccountBinding {
+	if eab == nil {
+		return nil
+	}
+	masked := *eab
+	masked.Key = nil
+	return &masked
+}
+
+// redactUsers masks the passwords of user:password credentials
+func redactUsers(users []string) []string {
+	if users == nil {
+		return nil
+	}
+	masked := make([]string, len(users))
+	for i, u := range users {
+		name, _, _ := strings.Cut(u, ":")
+		masked[i] = name + ":xxxxx"
+	}
+	return masked
+}
+
+// watchConfig watches the compose files and every file in the compose
+// directory, calling reload when any of them change. The returned function
+// stops watching.
+func watchConfig(cfg Config, reload func()) (stop func()) {
+	var stops []func()
+	paths := cfg.ComposeFiles
+	if cfg.ComposeDir != "" {
+		paths = append(slices.Clip(paths), cfg.ComposeDir)
+	}
+	for _, path := range paths {
+		stop, err := watcher.Watch(path, reload)
+		if err != nil {
+			slog.Warn("failed to set up file watcher", "path", path, "err", err)
+			continue
+		}
+		stops = append(stops, stop)
+		slog.Info("file watching enabled", "path", path)
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// parseConfig parses the routes of every configured compose file
+func parseConfig(cfg Config) ([]compose.Route, error) {
+	files, err := cfg.configFiles()
+	if err != nil {
+		return nil, err
+	}
+	return compose.ParseFiles(files)
+}
+
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -56,6 +453,23 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getEnvEAB reads an external account binding from a key ID and a base64url
+// HMAC key variable, nil if neither is set
+func getEnvEAB(kidKey, hmacKey string) *acme.ExternalAccountBinding {
+	kid, key := os.Getenv(kidKey), os.Getenv(hmacKey)
+	if kid == "" && key == "" {
+		return nil
+	}
+	if kid == "" || key == "" {
+		logging.Fatal(kidKey + " and " + hmacKey + " must be set together")
+	}
+	hmac, err := liteTLS.ParseEABKey(key)
+	if err != nil {
+		logging.Fatal("invalid "+hmacKey, "err", err)
+	}
+	return &acme.ExternalAccountBinding{KID: kid, Key: hmac}
+}
+
 func getEnvInt(key string, fallback int) int {
 	if v := os.Getenv(key); v != "" {
 		if i, err := strconv.Atoi(v); err == nil {
@@ -65,6 +479,26 @@ func getEnvInt(key string, fallback int) int {
 	return fallback
 }
 
+// getEnvList reads a comma-separated list, trimming spaces and dropping empty items
+func getEnvList(key string, fallback []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	return splitList(v)
+}
+
+// splitList splits a comma-separated list, trimming spaces and dropping empty items
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func getEnvBool(key string, fallback bool) bool {
 	if v := os.Getenv(key); v != "" {
 		return v == "true" || v == "1" || v == "yes"
@@ -73,32 +507,142 @@ func getEnvBool(key string, fallback bool) bool {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "routes" {
+		os.Exit(runRoutes(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tail" {
+		os.Exit(runTail(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "certs" {
+		os.Exit(runCerts(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	startedAt := time.Now()
 	cfg := loadConfig()
+	errlog.Default.Configure(cfg.ErrorLogInterval, cfg.ErrorLogBurst)
 
-	log.Printf("liteproxy starting")
-	log.Printf("  compose file: %s", cfg.ComposeFile)
-	log.Printf("  HTTP port: %d", cfg.HTTPPort)
-	log.Printf("  HTTPS enabled: %v", cfg.HTTPSEnabled)
+	attrs := []any{"version", buildVersion(), "compose_files", cfg.ComposeFiles}
+	if cfg.ComposeDir != "" {
+		attrs = append(attrs, "compose_dir", cfg.ComposeDir)
+	}
+	if cfg.HTTPEnabled {
+		attrs = append(attrs, "http_port", cfg.HTTPPort)
+	} else {
+		attrs = append(attrs, "http", "disabled")
+	}
+	attrs = append(attrs, "https", cfg.HTTPSEnabled)
 	if cfg.HTTPSEnabled {
-		log.Printf("  HTTPS port: %d", cfg.HTTPSPort)
+		attrs = append(attrs, "https_port", cfg.HTTPSPort, "tls_mode", cfg.TLSMode)
+		if cfg.HTTP3 {
+			attrs = append(attrs, "http3_udp_port", cfg.HTTPSPort)
+		}
+		if cfg.ACMEHTTPAddr != "" {
+			attrs = append(attrs, "acme_http_addr", cfg.ACMEHTTPAddr)
+		}
+		if cfg.ACMEPreCheck {
+			attrs = append(attrs, "acme_precheck", true)
+		}
+		if cfg.TLSMode == liteTLS.ModeACME {
+			attrs = append(attrs, "acme_directory", cfg.ACMEDirectory)
+		}
+		if cfg.ACMERehearsal > 0 {
+			attrs = append(attrs, "acme_rehearsal", cfg.ACMERehearsal.String())
+		}
+	}
+	if cfg.ExternalHTTPPort != 0 || cfg.ExternalHTTPSPort != 0 {
+		attrs = append(attrs, "external_http_port", cfg.ExternalHTTPPort, "external_https_port", cfg.ExternalHTTPSPort)
+	}
+	attrs = append(attrs, "watch", cfg.Watch)
+	if len(cfg.ProxyProtocol) > 0 {
+		attrs = append(attrs, "proxy_protocol_from", cfg.ProxyProtocol)
+	}
+	if len(cfg.TrustedProxies) > 0 {
+		attrs = append(attrs, "trusted_proxies", cfg.TrustedProxies)
+	}
+	if cfg.Kubernetes {
+		attrs = append(attrs, "ingress_class", cfg.IngressClass)
+	}
+	if cfg.SPIFFESocket != "" {
+		attrs = append(attrs, "spiffe_socket", cfg.SPIFFESocket)
+	}
+	if cfg.PerfProfile != listener.ProfileDefault {
+		attrs = append(attrs, "perf_profile", cfg.PerfProfile)
 	}
-	log.Printf("  watch mode: %v", cfg.Watch)
+	if cfg.ErrorPages != "" {
+		attrs = append(attrs, "error_pages", cfg.ErrorPages)
+	}
+	if cfg.Flags != "" {
+		attrs = append(attrs, "flags", cfg.Flags)
+	}
+	if cfg.AccessLog != "" {
+		attrs = append(attrs, "access_log", cfg.AccessLog)
+	}
+	if len(cfg.AccessLogSinks) > 0 {
+		attrs = append(attrs, "access_log_sinks", len(cfg.AccessLogSinks))
+	}
+	if cfg.AdminAddr != "" {
+		attrs = append(attrs, "admin", admin.Addr(cfg.AdminAddr))
+	}
+	if cfg.EgressAddr != "" {
+		attrs = append(attrs, "egress", cfg.EgressAddr, "egress_allow", cfg.EgressAllow)
+	}
+	if cfg.SOCKSAddr != "" {
+		attrs = append(attrs, "socks", cfg.SOCKSAddr)
+	}
+	if cfg.MetricsAddr != "" {
+		attrs = append(attrs, "metrics", cfg.MetricsAddr, "metrics_host_labels", cfg.MetricsHostMode)
+	}
+	slog.Info("liteproxy starting", attrs...)
 
 	// Parse compose file
-	routes, err := compose.ParseFile(cfg.ComposeFile)
+	routes, err := parseConfig(cfg)
 	if err != nil {
-		log.Fatalf("failed to parse compose file: %v", err)
+		logging.Fatal("failed to parse compose file", "err", err)
+	}
+
+	// Add routes for Kubernetes Ingresses
+	var (
+		kubeClient  *kube.Client
+		kubeRoutes  []compose.Route
+		kubeVersion string
+	)
+	if cfg.Kubernetes {
+		kubeClient, err = kube.InClusterClient()
+		if err != nil {
+			logging.Fatal("kubernetes client failed", "err", err)
+		}
+		kubeClient.TrustAnnotations = cfg.IngressTrustAll
+		kubeRoutes, kubeVersion, err = kubeClient.Routes(context.Background(), cfg.IngressClass)
+		if err != nil {
+			logging.Fatal("kubernetes: listing ingresses failed", "err", err)
+		}
+		routes = append(routes, kubeRoutes...)
 	}
-	log.Printf("loaded %d routes", len(routes))
+	slog.Info("loaded routes", "count", len(routes))
 	for _, r := range routes {
-		extra := ""
-		if r.Passthrough {
-			extra = " [passthrough]"
+		if r.IsForward() {
+			continue // logged by the forwarder
 		}
-		log.Printf("  %s%s -> %s:%d%s", r.Host, r.PathPrefix, r.ServiceName, r.ServicePort, extra)
+		attrs := []any{"route", describeRoute(r)}
 		if len(r.RedirectFrom) > 0 {
-			log.Printf("    redirects from: %v", r.RedirectFrom)
+			attrs = append(attrs, "redirects_from", r.RedirectFrom)
+		}
+		if len(r.Backends) > 0 {
+			attrs = append(attrs, "backends", r.Backends)
 		}
+		if r.HealthCheck != nil {
+			attrs = append(attrs, "health_check", r.HealthCheck.Type, "health_interval", r.HealthCheck.Interval.String())
+		}
+		slog.Info("route", attrs...)
 	}
 
 	// Create router
@@ -110,38 +654,211 @@ func main() {
 		scheme = "https"
 	}
 
+	// Start health checks for routes that configure them
+	checker := health.NewChecker()
+	checker.Update(routes)
+	defer checker.Stop()
+
 	// Create proxy handler
 	handler := proxy.New(rtr, scheme)
+	if cfg.HTTPSEnabled {
+		handler.SetRedirectPort(cfg.ExternalHTTPSPort)
+	} else {
+		handler.SetRedirectPort(cfg.ExternalHTTPPort)
+	}
+	handler.SetHealthChecker(checker)
+	handler.SetDebugHeaders(cfg.DebugHeaders)
+	handler.SetTLSHeaders(cfg.TLSHeaders)
+	handler.SetStartCommands(cfg.StartCommands)
+	handler.SetClientConcurrency(cfg.ClientConcurrency)
+	trustedProxies, _ := proxyproto.ParseTrusted(cfg.TrustedProxies) // validated in loadConfig
+	handler.SetTrustedProxies(trustedProxies)
+	if cfg.ErrorPages != "" {
+		pages, err := proxy.LoadErrorPages(cfg.ErrorPages)
+		if err != nil {
+			logging.Fatal("invalid error pages", "err", err)
+		}
+		handler.SetErrorPages(pages)
+	}
+	var flagSource *flags.Source
+	if cfg.Flags != "" {
+		flagSource, err = flags.NewSource(cfg.Flags)
+		if err != nil {
+			logging.Fatal("invalid feature flags", "err", err)
+		}
+		handler.SetFlags(flagSource)
+		go flagSource.Run(context.Background())
+	}
+
+	// Fetch the workload identity for routes verifying backends by SPIFFE ID
+	if cfg.SPIFFESocket != "" {
+		svids, err := spiffe.NewSource(context.Background(), cfg.SPIFFESocket)
+		if err != nil {
+			logging.Fatal("invalid SPIFFE config", "err", err)
+		}
+		select {
+		case <-svids.Ready():
+		case <-time.After(5 * time.Second):
+			slog.Warn("no SVID from the SPIFFE workload API yet; spiffe_id routes fail until one arrives")
+		}
+		handler.SetSPIFFE(svids)
+	}
+
+	// Write access logs if enabled; the admin API can also tail them live
+	var tail *accesslog.Tail
+	if cfg.AdminAddr != "" {
+		tail = accesslog.NewTail()
+	}
+	var accessLog *accesslog.Logger
+	if cfg.AccessLog != "" || len(cfg.AccessLogSinks) > 0 || tail != nil {
+		var err error
+		accessLog, err = openAccessLog(cfg)
+		if err != nil {
+			logging.Fatal("failed to set up access log", "err", err)
+		}
+		accessLog.SetTail(tail)
+		handler.SetAccessLog(accessLog)
+	}
+
+	// Serve metrics on their own listener if enabled
+	if cfg.MetricsAddr != "" {
+		labeler, err := metrics.NewHostLabeler(cfg.MetricsHostMode, cfg.MetricsMaxHosts)
+		if err != nil {
+			logging.Fatal("invalid metrics config", "err", err)
+		}
+		handler.SetMetrics(labeler)
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			if err := serve(cfg.MetricsAddr, mux); err != nil {
+				logging.Fatal("metrics server error", "err", err)
+			}
+		}()
+	}
+
+	// Serve the egress proxy for outbound calls if enabled
+	if cfg.EgressAddr != "" {
+		egressProxy, _ := egress.New(cfg.EgressAllow, cfg.EgressUsers) // validated in loadConfig
+		go func() {
+			if err := serve(cfg.EgressAddr, egressProxy); err != nil {
+				logging.Fatal("egress proxy error", "err", err)
+			}
+		}()
+	}
+
+	// Serve SOCKS5 for developer access to internal services if enabled
+	var socksServer *socks.Server
+	if cfg.SOCKSAddr != "" {
+		socksServer, _ = socks.New(cfg.SOCKSUsers, cfg.SOCKSAllow) // validated in loadConfig
+		socksServer.SetServices(socksServices(routes))
+		go func() {
+			if err := socksServer.ListenAndServe(cfg.SOCKSAddr); err != nil {
+				logging.Fatal("SOCKS5 listener error", "err", err)
+			}
+		}()
+	}
+
+	// Expose non-HTTP services on their own ports
+	forwarder := passthrough.NewForwarder()
+	if err := forwarder.Update(routes); err != nil {
+		logging.Fatal("failed to open forwarded ports", "err", err)
+	}
 
 	// Check if we have passthrough routes
 	hasPassthrough := rtr.HasPassthroughRoutes()
 	if hasPassthrough {
-		log.Println("passthrough routes detected - using TCP routing")
+		slog.Info("passthrough routes detected, using TCP routing")
 	}
 
 	// State for hot reload
 	var (
-		mu             sync.Mutex
-		certManager    *autocert.Manager
-		httpListener   *passthrough.Listener
-		httpsListener  *passthrough.Listener
+		mu            sync.Mutex
+		currentRoutes = routes
+		reloads       int
+		lastReload    *admin.ReloadStatus
+		certHosts     *liteTLS.HostList
+		preChecker    *liteTLS.PreChecker
+		issueQueue    *liteTLS.IssueQueue
+		rehearsal     *liteTLS.Rehearsal
+		clientAuth    *liteTLS.ClientAuth
+		httpListener  *passthrough.Listener
+		httpsListener *passthrough.Listener
 	)
 
+	// Tenants files are watched alongside the compose files, including
+	// ones added by a reload
+	var reload func() error
+	watched := make(map[string]bool)
+	watchTenants := func(routes []compose.Route) {
+		if !cfg.Watch {
+			return
+		}
+		for _, path := range compose.TenantFiles(routes) {
+			if watched[path] {
+				continue
+			}
+			if _, err := watcher.Watch(path, func() { reload() }); err != nil {
+				slog.Warn("failed to set up file watcher", "path", path, "err", err)
+				continue
+			}
+			watched[path] = true
+			slog.Info("file watching enabled", "path", path)
+		}
+	}
+
 	// Reload function
-	reload := func() {
+	reload = func() error {
 		mu.Lock()
 		defer mu.Unlock()
 
-		log.Println("reloading configuration...")
+		slog.Info("reloading configuration")
+		reloads++
 
-		newRoutes, err := compose.ParseFile(cfg.ComposeFile)
+		newRoutes, err := parseConfig(cfg)
 		if err != nil {
-			log.Printf("reload failed: %v", err)
-			return
+			slog.Error("reload failed", "err", err)
+			lastReload = &admin.ReloadStatus{Time: time.Now(), Error: err.Error()}
+			configReloads.Inc("error")
+			return err
+		}
+		var pages *proxy.ErrorPages
+		if cfg.ErrorPages != "" {
+			if pages, err = proxy.LoadErrorPages(cfg.ErrorPages); err != nil {
+				slog.Error("reload failed", "err", err)
+				lastReload = &admin.ReloadStatus{Time: time.Now(), Error: err.Error()}
+				configReloads.Inc("error")
+				return err
+			}
+		}
+		newRoutes = append(newRoutes, kubeRoutes...)
+		if clientAuth != nil {
+			if err := clientAuth.Update(clientAuthPolicies(newRoutes)); err != nil {
+				slog.Error("reload failed", "err", err)
+				lastReload = &admin.ReloadStatus{Time: time.Now(), Error: err.Error()}
+				configReloads.Inc("error")
+				return err
+			}
+		}
+
+		// Nothing below fails the reload
+		if pages != nil {
+			handler.SetErrorPages(pages)
 		}
+		if flagSource != nil {
+			if err := flagSource.Refresh(context.Background()); err != nil {
+				slog.Error("feature flags failed to load, keeping the previous ones", "err", err)
+			}
+		}
+		diff := compose.Diff(currentRoutes, newRoutes)
+		currentRoutes = newRoutes
+		lastReload = &admin.ReloadStatus{Time: time.Now(), OK: true}
+		configReloads.Inc("ok")
+		watchTenants(newRoutes)
 
 		newRouter := router.New(newRoutes)
 		handler.UpdateRouter(newRouter)
+		checker.Update(newRoutes)
 
 		// Update passthrough listeners
 		if httpListener != nil {
@@ -150,37 +867,125 @@ func main() {
 		if httpsListener != nil {
 			httpsListener.UpdateRouter(newRouter)
 		}
-
-		log.Printf("reloaded %d routes", len(newRoutes))
-		for _, r := range newRoutes {
-			extra := ""
-			if r.Passthrough {
-				extra = " [passthrough]"
-			}
-			log.Printf("  %s%s -> %s:%d%s", r.Host, r.PathPrefix, r.ServiceName, r.ServicePort, extra)
+		if err := forwarder.Update(newRoutes); err != nil {
+			slog.Error("reload failed", "err", err)
+		}
+		if socksServer != nil {
+			socksServer.SetServices(socksServices(newRoutes))
 		}
 
+		logRouteDiff(len(newRoutes), diff)
+
 		// Update TLS hosts if HTTPS is enabled
-		if cfg.HTTPSEnabled && certManager != nil {
+		if cfg.HTTPSEnabled && certHosts != nil {
 			hosts := newRouter.Hosts()
-			certManager = liteTLS.UpdateHosts(certManager, hosts)
+			certHosts.Set(hosts)
+			if issueQueue != nil {
+				issueQueue.Enqueue(hosts)
+			}
+		}
+		return nil
+	}
+
+	// Serve the admin API on its own listener if enabled
+	if cfg.AdminAddr != "" {
+		adminServer := &admin.Server{
+			Addr: admin.Addr(cfg.AdminAddr),
+			Routes: func() []compose.Route {
+				mu.Lock()
+				defer mu.Unlock()
+				return currentRoutes
+			},
+			Health:     checker.Status,
+			Saturation: proxy.Saturation,
+			Tail:       tail,
+			Status: func() admin.Status {
+				mu.Lock()
+				defer mu.Unlock()
+				return admin.Status{
+					Version:    buildVersion(),
+					GoVersion:  runtime.Version(),
+					StartedAt:  startedAt,
+					Uptime:     time.Since(startedAt).Seconds(),
+					ConfigHash: compose.Hash(currentRoutes),
+					Routes:     len(currentRoutes),
+					Reloads:    reloads,
+					LastReload: lastReload,
+				}
+			},
+			Certificates: func() any {
+				mu.Lock()
+				defer mu.Unlock()
+				if issueQueue == nil {
+					return nil
+				}
+				status := certificateStatus{Queue: issueQueue.Status()}
+				status.Certificates, _ = liteTLS.CachedCertificates(cfg.ACMEDir)
+				if preChecker != nil {
+					status.PreChecks = preChecker.Results()
+				}
+				if rehearsal != nil {
+					status.Rehearsal = rehearsal.Results()
+				}
+				return status
+			},
+			Reload: reload,
+			Config: cfg.redacted(),
+			Faults: func() []proxy.FaultStatus {
+				mu.Lock()
+				defer mu.Unlock()
+				return handler.Faults(currentRoutes)
+			},
+			SetFault: func(route string, fault *compose.Fault) {
+				handler.SetFault(route, fault)
+				if fault == nil {
+					slog.Info("fault injection turned off", "route", route)
+				} else {
+					slog.Info("fault injection set", "route", route, "delay", fault.Delay.String(), "abort_percent", fault.AbortPercent, "abort_status", fault.AbortStatus)
+				}
+			},
+			ClearFault: func(route string) {
+				handler.ClearFault(route)
+				slog.Info("fault injection reverted to labels", "route", route)
+			},
+			Validate: func(path string, data []byte) ([]compose.Route, error) {
+				routes, err := validateCandidate(cfg, path, data)
+				if err != nil {
+					return nil, err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				return append(routes, kubeRoutes...), nil
+			},
 		}
+		go func() {
+			if err := serve(admin.Addr(cfg.AdminAddr), adminServer.Handler()); err != nil {
+				logging.Fatal("admin server error", "err", err)
+			}
+		}()
+	}
+
+	// Reload whenever Ingresses change
+	if kubeClient != nil {
+		go kubeClient.Watch(context.Background(), cfg.IngressClass, kubeRoutes, kubeVersion, func(routes []compose.Route) {
+			mu.Lock()
+			kubeRoutes = routes
+			mu.Unlock()
+			reload()
+		})
 	}
 
 	// Set up file watcher if enabled
 	if cfg.Watch {
-		stop, err := watcher.Watch(cfg.ComposeFile, reload)
-		if err != nil {
-			log.Printf("warning: failed to set up file watcher: %v", err)
-		} else {
-			defer stop()
-			log.Println("file watching enabled")
-		}
+		defer watchConfig(cfg, func() { reload() })()
+		mu.Lock()
+		watchTenants(currentRoutes)
+		mu.Unlock()
 	}
 
-	// Set up signal handling for SIGHUP reload and graceful shutdown
+	// Set up signal handling for SIGHUP reload, SIGUSR2 upgrades and graceful shutdown
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, append([]os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM}, upgradeSignals...)...)
 
 	go func() {
 		for sig := range sigChan {
@@ -188,8 +993,14 @@ func main() {
 			case syscall.SIGHUP:
 				reload()
 			case syscall.SIGINT, syscall.SIGTERM:
-				log.Println("shutting down...")
+				slog.Info("shutting down")
+				// Ship access log entries still queued for remote sinks
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				accessLog.Close(ctx)
+				cancel()
 				os.Exit(0)
+			default:
+				go upgrade(cfg, accessLog)
 			}
 		}
 	}()
@@ -197,46 +1008,153 @@ func main() {
 	// Start servers
 	if cfg.HTTPSEnabled {
 		hosts := rtr.Hosts()
-		certManager = liteTLS.Manager(liteTLS.Config{
-			Email:    cfg.ACMEEmail,
-			CacheDir: cfg.ACMEDir,
-			Hosts:    hosts,
-		})
-		tlsConfig := liteTLS.TLSConfig(certManager)
+		mu.Lock()
+		certHosts = liteTLS.NewHostList(hosts)
+
+		// challenges wraps plain HTTP handlers to answer ACME HTTP-01 challenges
+		var tlsConfig *tls.Config
+		var challenges func(http.Handler) http.Handler
+		if cfg.TLSMode == liteTLS.ModeLocal {
+			localCA, err := liteTLS.LoadLocalCA(cfg.ACMEDir, certHosts)
+			if err != nil {
+				logging.Fatal("failed to set up local CA", "err", err)
+			}
+			slog.Info("serving certificates from the local CA; trust its certificate to avoid browser warnings", "ca", localCA.CertFile())
+			tlsConfig = liteTLS.LocalTLSConfig(localCA)
+			challenges = func(h http.Handler) http.Handler { return h }
+		} else {
+			certManager := liteTLS.Manager(liteTLS.Config{
+				Email:        cfg.ACMEEmail,
+				CacheDir:     cfg.ACMEDir,
+				Hosts:        certHosts,
+				DirectoryURL: cfg.ACMEDirectory,
+				EAB:          cfg.ACMEEAB,
+			})
+			if cfg.ACMEPreCheck {
+				preChecker, err = liteTLS.NewPreChecker(cfg.PublicIPs)
+				if err != nil {
+					logging.Fatal("invalid ACME pre-check config", "err", err)
+				}
+				certManager.HostPolicy = preChecker.Policy(certManager.HostPolicy)
+			}
+			var caaChecker *liteTLS.CAAChecker
+			if cfg.ACMECAACheck && cfg.ACMECAAIssuer == "" {
+				slog.Warn("CAA checks disabled: the CA behind the ACME directory is unknown, set LITEPROXY_ACME_CAA_ISSUER to enable them", "acme_directory", cfg.ACMEDirectory)
+			} else if cfg.ACMECAACheck {
+				caaChecker = liteTLS.NewCAAChecker(cfg.ACMECAAIssuer)
+				certManager.HostPolicy = caaChecker.Policy(certManager.HostPolicy)
+			}
+			issueQueue = liteTLS.NewIssueQueue(certManager, cfg.ACMEConcurrency, cfg.ACMEPerHour)
+			certManager.HostPolicy = issueQueue.Policy(certManager.HostPolicy)
+			liteTLS.TrackIssuance(certManager)
+			tlsConfig = liteTLS.TLSConfig(certManager)
+			challenges = liteTLS.ChallengeHandler(certManager)
+			if cfg.ACMERehearsal > 0 {
+				rehearsal = liteTLS.NewRehearsal(liteTLS.Config{
+					Email:        cfg.ACMEEmail,
+					CacheDir:     cfg.ACMEDir,
+					Hosts:        certHosts,
+					DirectoryURL: liteTLS.StagingFor(cfg.ACMEDirectory),
+					EAB:          cfg.ACMERehearsalEAB,
+				}, caaChecker)
+				tlsConfig.GetCertificate = rehearsal.GetCertificate(tlsConfig.GetCertificate)
+				challenges = func(h http.Handler) http.Handler {
+					return rehearsal.ChallengeHandler(liteTLS.ChallengeHandler(certManager)(h))
+				}
+				go rehearsal.Run(context.Background(), cfg.ACMERehearsal)
+			}
+		}
+
+		// Only hosts with liteproxy.mtls.ca ask for client certificates
+		clientAuth = liteTLS.NewClientAuth(tlsConfig)
+		if err := clientAuth.Update(clientAuthPolicies(currentRoutes)); err != nil {
+			logging.Fatal("failed to load client CAs", "err", err)
+		}
+		tlsConfig = clientAuth.Config()
+		mu.Unlock()
+		liteTLS.SetHandshakeDebug(cfg.TLSDebug)
+
+		// HTTP/3 shares the certificates and handler; TCP responses advertise it
+		var tlsServed http.Handler = handler
+		if cfg.HTTP3 {
+			// Behind NAT clients reach QUIC on the external port, not ours
+			advertised := cfg.HTTPSPort
+			if cfg.ExternalHTTPSPort != 0 {
+				advertised = cfg.ExternalHTTPSPort
+			}
+			tlsServed = altSvc(handler, advertised)
+			go func() {
+				slog.Info("starting HTTP/3 server", "udp_port", cfg.HTTPSPort)
+				if err := serveHTTP3(cfg.HTTPSPort, handler, tlsConfig); err != nil {
+					logging.Fatal("HTTP/3 server error", "err", err)
+				}
+			}()
+		}
 
 		// HTTP handler for ACME challenges + redirect
-		httpHandler := certManager.HTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			target := "https://" + r.Host + r.URL.RequestURI()
-			http.Redirect(w, r, target, http.StatusMovedPermanently)
+		httpHandler := challenges(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Platform health checks often only speak plain HTTP
+			status := handler.RedirectStatus(r)
+			if status == 0 {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			target := "https://" + proxy.ExternalHost(r.Host, "https", cfg.ExternalHTTPSPort) + r.URL.RequestURI()
+			http.Redirect(w, r, target, status)
 		}))
 
+		// Dedicated ACME challenge listener (e.g. behind a port-forwarding firewall)
+		if cfg.ACMEHTTPAddr != "" {
+			go func() {
+				slog.Info("starting ACME HTTP-01 server", "addr", cfg.ACMEHTTPAddr)
+				if err := serve(cfg.ACMEHTTPAddr, challengeOnly(challenges)); err != nil {
+					logging.Fatal("ACME HTTP server error", "err", err)
+				}
+			}()
+		} else if !cfg.HTTPEnabled && cfg.TLSMode == liteTLS.ModeACME {
+			slog.Info("HTTP listener disabled without LITEPROXY_ACME_HTTP_ADDR; certificates will use TLS-ALPN-01 challenges only")
+		}
+
+		// Order certificates for the initial hosts once challenges can be
+		// answered; reloads enqueue the hosts they add
+		preIssue := func() {
+			if issueQueue != nil {
+				issueQueue.Enqueue(certHosts.List())
+			}
+		}
+
 		// HTTPS handler with TLS termination
-		httpsHandler := &tlsHandler{handler: handler, tlsConfig: tlsConfig}
+		httpsHandler := &tlsHandler{handler: tlsServed, tlsConfig: tlsConfig}
 
 		if hasPassthrough {
 			// Use passthrough listeners for both ports
-			httpLn, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.HTTPPort))
-			if err != nil {
-				log.Fatalf("failed to listen on HTTP port: %v", err)
+			if cfg.HTTPEnabled {
+				httpLn, err := listen(cfg, cfg.HTTPPort)
+				if err != nil {
+					logging.Fatal("failed to listen on HTTP port", "err", err)
+				}
+				httpListener = passthrough.NewHTTPListener(httpLn, rtr, httpHandler)
+
+				go func() {
+					slog.Info("starting HTTP passthrough", "port", cfg.HTTPPort)
+					if err := httpListener.Serve(); err != nil {
+						logging.Fatal("HTTP listener error", "err", err)
+					}
+				}()
 			}
-			httpsLn, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.HTTPSPort))
+
+			httpsLn, err := listen(cfg, cfg.HTTPSPort)
 			if err != nil {
-				log.Fatalf("failed to listen on HTTPS port: %v", err)
+				logging.Fatal("failed to listen on HTTPS port", "err", err)
 			}
-
-			httpListener = passthrough.NewHTTPListener(httpLn, rtr, httpHandler)
 			httpsListener = passthrough.NewTLSListener(httpsLn, rtr, httpsHandler, tlsConfig)
+			httpsListener.SetErrorLog(liteTLS.ErrorLog())
 
-			go func() {
-				log.Printf("starting HTTP passthrough on :%d", cfg.HTTPPort)
-				if err := httpListener.Serve(); err != nil {
-					log.Fatalf("HTTP listener error: %v", err)
-				}
-			}()
-
-			log.Printf("starting HTTPS passthrough on :%d", cfg.HTTPSPort)
+			ready(cfg)
+			preIssue()
+			slog.Info("starting HTTPS passthrough", "port", cfg.HTTPSPort)
 			if err := httpsListener.Serve(); err != nil {
-				log.Fatalf("HTTPS listener error: %v", err)
+				logging.Fatal("HTTPS listener error", "err", err)
 			}
 		} else {
 			// Standard HTTP servers (no passthrough routes)
@@ -246,48 +1164,293 @@ func main() {
 			}
 			httpsServer := &http.Server{
 				Addr:      ":" + strconv.Itoa(cfg.HTTPSPort),
-				Handler:   handler,
+				Handler:   tlsServed,
 				TLSConfig: tlsConfig,
+				ErrorLog:  liteTLS.ErrorLog(),
 			}
 
-			go func() {
-				log.Printf("starting HTTP server on :%d (ACME + redirect)", cfg.HTTPPort)
-				if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
-					log.Fatalf("HTTP server error: %v", err)
+			if cfg.HTTPEnabled {
+				httpLn, err := listen(cfg, cfg.HTTPPort)
+				if err != nil {
+					logging.Fatal("failed to listen on HTTP port", "err", err)
 				}
-			}()
+				go func() {
+					slog.Info("starting HTTP server for ACME and redirects", "port", cfg.HTTPPort)
+					if err := httpServer.Serve(httpLn); err != http.ErrServerClosed {
+						logging.Fatal("HTTP server error", "err", err)
+					}
+				}()
+			}
 
-			log.Printf("starting HTTPS server on :%d", cfg.HTTPSPort)
-			if err := httpsServer.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
-				log.Fatalf("HTTPS server error: %v", err)
+			httpsLn, err := listen(cfg, cfg.HTTPSPort)
+			if err != nil {
+				logging.Fatal("failed to listen on HTTPS port", "err", err)
+			}
+			ready(cfg)
+			preIssue()
+			slog.Info("starting HTTPS server", "port", cfg.HTTPSPort)
+			if err := httpsServer.ServeTLS(httpsLn, "", ""); err != http.ErrServerClosed {
+				logging.Fatal("HTTPS server error", "err", err)
 			}
 		}
 	} else {
 		// HTTP only mode
 		if hasPassthrough {
-			httpLn, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.HTTPPort))
+			httpLn, err := listen(cfg, cfg.HTTPPort)
 			if err != nil {
-				log.Fatalf("failed to listen on HTTP port: %v", err)
+				logging.Fatal("failed to listen on HTTP port", "err", err)
 			}
 
 			httpListener = passthrough.NewHTTPListener(httpLn, rtr, handler)
-			log.Printf("starting HTTP passthrough on :%d", cfg.HTTPPort)
+			ready(cfg)
+			slog.Info("starting HTTP passthrough", "port", cfg.HTTPPort)
 			if err := httpListener.Serve(); err != nil {
-				log.Fatalf("HTTP listener error: %v", err)
+				logging.Fatal("HTTP listener error", "err", err)
 			}
 		} else {
+			// Accept HTTP/2 with prior knowledge too, so gRPC clients reach
+			// the proxy without TLS (e.g. behind a TLS-terminating load balancer)
+			var protocols http.Protocols
+			protocols.SetHTTP1(true)
+			protocols.SetUnencryptedHTTP2(true)
 			httpServer := &http.Server{
-				Addr:    ":" + strconv.Itoa(cfg.HTTPPort),
-				Handler: handler,
+				Addr:      ":" + strconv.Itoa(cfg.HTTPPort),
+				Handler:   handler,
+				Protocols: &protocols,
+			}
+			httpLn, err := listen(cfg, cfg.HTTPPort)
+			if err != nil {
+				logging.Fatal("failed to listen on HTTP port", "err", err)
 			}
-			log.Printf("starting HTTP server on :%d", cfg.HTTPPort)
-			if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
-				log.Fatalf("HTTP server error: %v", err)
+			ready(cfg)
+			slog.Info("starting HTTP server", "port", cfg.HTTPPort)
+			if err := httpServer.Serve(httpLn); err != http.ErrServerClosed {
+				logging.Fatal("HTTP server error", "err", err)
 			}
 		}
 	}
 }
 
+// ready writes the PID file and tells the process that started this one
+// with a SIGUSR2 upgrade that it can stop accepting
+func ready(cfg Config) {
+	if cfg.PIDFile != "" {
+		if err := os.WriteFile(cfg.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+			slog.Warn("failed to write PID file", "err", err)
+		}
+	}
+	harden(cfg)
+	listener.Ready()
+}
+
+// harden switches to LITEPROXY_USER and applies LITEPROXY_SANDBOX once the
+// main listeners are open. Files the new user keeps writing are handed to it.
+func harden(cfg Config) {
+	if cfg.RunAs == nil && !cfg.Sandbox {
+		return
+	}
+	var owned, writable []string
+	if cfg.HTTPSEnabled {
+		if err := os.MkdirAll(cfg.ACMEDir, 0o700); err != nil {
+			logging.Fatal("failed to create ACME directory", "path", cfg.ACMEDir, "err", err)
+		}
+		owned = append(owned, cfg.ACMEDir)
+		writable = append(writable, cfg.ACMEDir)
+	}
+	if cfg.PIDFile != "" {
+		owned = append(owned, cfg.PIDFile)
+		writable = append(writable, filepath.Dir(cfg.PIDFile))
+	}
+	if cfg.AccessLog != "" && cfg.AccessLog != "stdout" && cfg.AccessLog != "stderr" {
+		owned = append(owned, cfg.AccessLog)
+		writable = append(writable, filepath.Dir(cfg.AccessLog))
+	}
+	writable = append(writable, os.TempDir()) // request bodies spooled for scanning
+
+	if cfg.RunAs != nil {
+		if err := sandbox.Chown(*cfg.RunAs, owned...); err != nil {
+			logging.Fatal("failed to hand files to LITEPROXY_USER", "err", err)
+		}
+		if err := sandbox.DropPrivileges(*cfg.RunAs); err != nil {
+			logging.Fatal("failed to switch to LITEPROXY_USER", "err", err)
+		}
+		slog.Info("running as another user", "uid", cfg.RunAs.UID, "gid", cfg.RunAs.GID)
+	}
+	if cfg.Sandbox {
+		abi, err := sandbox.RestrictWrites(writable)
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+			slog.Warn("sandbox unavailable, file writes are not restricted", "err", err)
+		case err != nil:
+			logging.Fatal("failed to apply sandbox", "err", err)
+		default:
+			slog.Info("sandbox applied", "landlock_abi", abi, "writable", writable)
+		}
+	}
+}
+
+// upgrade starts a new liteproxy from the binary on disk, hands it every
+// listening socket and exits once open connections finish and their access
+// log entries are shipped. The old process keeps serving if the new one
+// fails to start.
+func upgrade(cfg Config, accessLog *accesslog.Logger) {
+	slog.Info("upgrade: starting new process")
+	p, err := listener.Upgrade(upgradeTimeout)
+	if err != nil {
+		slog.Error("upgrade failed, still serving", "err", err)
+		return
+	}
+	slog.Info("upgrade: new process is serving, draining connections", "pid", p.Pid, "drain", cfg.UpgradeDrain.String())
+	if !listener.Drain(cfg.UpgradeDrain) {
+		slog.Warn("upgrade: drain timed out, closing remaining connections")
+	}
+	// Ship access log entries still queued for remote sinks, as on shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	accessLog.Close(ctx)
+	cancel()
+	os.Exit(0)
+}
+
+// upgradeTimeout bounds how long a new process may take to start serving
+const upgradeTimeout = 30 * time.Second
+
+// challengeOnly is the handler of the dedicated ACME challenge listener,
+// which answers HTTP-01 challenges and nothing else
+func challengeOnly(challenges func(http.Handler) http.Handler) http.Handler {
+	return challenges(http.NotFoundHandler())
+}
+
+// serve serves h on addr through a listener that survives upgrades
+func serve(addr string, h http.Handler) error {
+	ln, err := listener.Listen(addr, listener.Options{})
+	if err != nil {
+		return err
+	}
+	return http.Serve(ln, h)
+}
+
+// listen opens the proxy listener on port using the configured performance
+// profile, reading PROXY protocol headers from trusted load balancers
+func listen(cfg Config, port int) (net.Listener, error) {
+	ln, err := listener.Listen(":"+strconv.Itoa(port), listener.Options{
+		Profile:   cfg.PerfProfile,
+		Acceptors: cfg.Acceptors,
+		Nagle:     !cfg.TCPNoDelay,
+	})
+	if err != nil || len(cfg.ProxyProtocol) == 0 {
+		return ln, err
+	}
+	trusted, err := proxyproto.ParseTrusted(cfg.ProxyProtocol)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return proxyproto.NewListener(ln, trusted), nil
+}
+
+// openAccessLog creates the access logger described by cfg
+func openAccessLog(cfg Config) (*accesslog.Logger, error) {
+	redactor, err := accesslog.NewRedactor(cfg.AccessLogRedactQuery, cfg.AccessLogRedactHeaders, cfg.AccessLogRedactPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.Writer // nil: entries only go to the admin API's tail
+	switch cfg.AccessLog {
+	case "":
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.AccessLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	l := accesslog.New(w, redactor, cfg.AccessLogHeaders)
+	for _, rawURL := range cfg.AccessLogSinks {
+		sink, err := accesslog.NewSink(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		l.AddSink(sink)
+	}
+	return l, nil
+}
+
+// clientAuthPolicies returns the client certificate policy of each host
+// whose routes set liteproxy.mtls.ca
+func clientAuthPolicies(routes []compose.Route) map[string]liteTLS.ClientAuthPolicy {
+	policies := make(map[string]liteTLS.ClientAuthPolicy)
+	for _, r := range routes {
+		if r.ClientAuth != nil {
+			host, _, _ := strings.Cut(r.Host, ":")
+			policies[host] = liteTLS.ClientAuthPolicy{CA: r.ClientAuth.CA, Require: r.ClientAuth.Require}
+		}
+	}
+	return policies
+}
+
+// socksServices maps each routed service name to the host the proxy
+// dials for it, so SOCKS5 clients can use compose service names
+func socksServices(routes []compose.Route) map[string]string {
+	services := make(map[string]string)
+	for _, r := range routes {
+		if r.ServiceName != "" {
+			services[r.ServiceName] = r.DialHost()
+		}
+	}
+	return services
+}
+
+// describeRoute is a route's line in the startup log and route tables
+func describeRoute(r compose.Route) string {
+	line := fmt.Sprintf("%s%s -> %s:%d", r.Host, r.Path(), r.DialHost(), r.ServicePort)
+	switch {
+	case r.Preset != "":
+		ports := make([]string, len(r.SNIPorts))
+		for i, port := range r.SNIPorts {
+			ports[i] = ":" + strconv.Itoa(port)
+		}
+		line += fmt.Sprintf(" [%s passthrough on %s]", r.Preset, strings.Join(ports, ", "))
+	case r.Passthrough:
+		line += " [passthrough]"
+	}
+	return line
+}
+
+// logRouteDiff logs what a reload changed rather than the whole table
+func logRouteDiff(total int, d compose.RouteDiff) {
+	slog.Info("reloaded routes", "count", total, "added", len(d.Added), "removed", len(d.Removed), "changed", len(d.Changed))
+	// Forward routes are logged by the forwarder
+	for _, r := range d.Added {
+		if !r.IsForward() {
+			slog.Info("route added", "route", describeRoute(r))
+		}
+	}
+	for _, r := range d.Removed {
+		if !r.IsForward() {
+			slog.Info("route removed", "route", describeRoute(r))
+		}
+	}
+	for _, c := range d.Changed {
+		if !c.Route.IsForward() {
+			slog.Info("route changed", "route", describeRoute(c.Route), "fields", c.Fields)
+		}
+	}
+}
+
+// certificateStatus is the admin API's view of certificate issuance
+type certificateStatus struct {
+	Certificates []liteTLS.CertificateInfo `json:"certificates,omitempty"`
+	PreChecks    []liteTLS.PreCheckResult  `json:"prechecks,omitempty"`
+	Rehearsal    []liteTLS.RehearsalResult `json:"rehearsal,omitempty"`
+	Queue        liteTLS.QueueStatus       `json:"queue"`
+}
+
 // tlsHandler wraps an http.Handler with TLS termination
 type tlsHandler struct {
 	handler   http.Handler
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Host label modes for per-route metrics
+const (
+	// HostRoute reports the route's configured host, so every tenant of
+	// *.tenant.com shares one series
+	HostRoute = "route"
+	// HostRequest reports the request's Host header, capped by MaxHosts
+	HostRequest = "request"
+)
+
+// OtherHost is reported once the distinct host limit is reached
+const OtherHost = "other"
+
+// HostLabeler picks the host label for per-route metrics, keeping the number
+// of series bounded for deployments with thousands of tenant subdomains
+type HostLabeler struct {
+	mode     string
+	maxHosts int
+
+	mu   sync.RWMutex
+	seen map[string]struct{}
+}
+
+// NewHostLabeler creates a labeler for mode (HostRoute or HostRequest)
+// In HostRequest mode, hosts beyond the first maxHosts distinct ones are
+// reported as OtherHost (maxHosts <= 0 means no limit)
+func NewHostLabeler(mode string, maxHosts int) (*HostLabeler, error) {
+	if mode != HostRoute && mode != HostRequest {
+		return nil, fmt.Errorf("invalid metrics host mode %q", mode)
+	}
+	return &HostLabeler{
+		mode:     mode,
+		maxHosts: maxHosts,
+		seen:     make(map[string]struct{}),
+	}, nil
+}
+
+// Label returns the host label for a request to requestHost that matched a
+// route for routeHost
+func (l *HostLabeler) Label(requestHost, routeHost string) string {
+	if l.mode == HostRoute {
+		return routeHost
+	}
+	if l.maxHosts <= 0 {
+		return requestHost
+	}
+
+	l.mu.RLock()
+	_, ok := l.seen[requestHost]
+	full := len(l.seen) >= l.maxHosts
+	l.mu.RUnlock()
+	if ok {
+		return requestHost
+	}
+	if full {
+		return OtherHost
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[requestHost]; ok {
+		return requestHost
+	}
+	if len(l.seen) >= l.maxHosts {
+		return OtherHost
+	}
+	l.seen[requestHost] = struct{}{}
+	return requestHost
+}
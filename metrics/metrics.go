@@ -0,0 +1,352 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the registry served by Handler
+var Default = NewRegistry()
+
+// DefaultBuckets are latency buckets in seconds, suited to proxied requests
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector writes its series in Prometheus text exposition format
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and renders them for scraping
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+	sort.Slice(r.collectors, func(i, j int) bool { return r.collectors[i].name() < r.collectors[j].name() })
+}
+
+// Handler serves the registry in Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		r.mu.RLock()
+		for _, c := range r.collectors {
+			c.write(bw)
+		}
+		r.mu.RUnlock()
+		bw.Flush()
+	})
+}
+
+// Handler serves the default registry
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	desc
+	mu     sync.RWMutex
+	values map[string]*atomic.Uint64 // joined label values → count
+}
+
+// NewCounterVec creates and registers a counter in r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{n: name, help: help, labels: labels},
+		values: make(map[string]*atomic.Uint64),
+	}
+	r.register(c)
+	return c
+}
+
+// Inc increments the counter for the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds n to the counter for the label values
+func (c *CounterVec) Add(n uint64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	c.mu.RLock()
+	v, ok := c.values[key]
+	c.mu.RUnlock()
+	if !ok {
+		c.mu.Lock()
+		if v, ok = c.values[key]; !ok {
+			v = new(atomic.Uint64)
+			c.values[key] = v
+		}
+		c.mu.Unlock()
+	}
+	v.Add(n)
+}
+
+// Value returns the current count for the label values
+func (c *CounterVec) Value(values ...string) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if v, ok := c.values[strings.Join(values, "\xff")]; ok {
+		return v.Load()
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w, "counter")
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %d\n", c.n, c.labelPairs(key, ""), c.values[key].Load())
+	}
+}
+
+// Each calls fn with the label values and count of every series
+func (c *CounterVec) Each(fn func(values []string, v uint64)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, key := range sortedKeys(c.values) {
+		fn(strings.Split(key, "\xff"), c.values[key].Load())
+	}
+}
+
+// GaugeVec is a gauge partitioned by label values
+type GaugeVec struct {
+	desc
+	mu     sync.RWMutex
+	values map[string]*atomic.Int64 // joined label values → value
+}
+
+// NewGaugeVec creates and registers a gauge in r
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		desc:   desc{n: name, help: help, labels: labels},
+		values: make(map[string]*atomic.Int64),
+	}
+	r.register(g)
+	return g
+}
+
+// Add adds delta, which may be negative, to the gauge for the label values
+func (g *GaugeVec) Add(delta int64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	g.mu.RLock()
+	v, ok := g.values[key]
+	g.mu.RUnlock()
+	if !ok {
+		g.mu.Lock()
+		if v, ok = g.values[key]; !ok {
+			v = new(atomic.Int64)
+			g.values[key] = v
+		}
+		g.mu.Unlock()
+	}
+	v.Add(delta)
+}
+
+// Value returns the current value for the label values
+func (g *GaugeVec) Value(values ...string) int64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if v, ok := g.values[strings.Join(values, "\xff")]; ok {
+		return v.Load()
+	}
+	return 0
+}
+
+// Each calls fn with the label values and value of every series
+func (g *GaugeVec) Each(fn func(values []string, v int64)) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, key := range sortedKeys(g.values) {
+		fn(strings.Split(key, "\xff"), g.values[key].Load())
+	}
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %d\n", g.n, g.labelPairs(key, ""), g.values[key].Load())
+	}
+}
+
+// GaugeFunc is a gauge whose value is computed at scrape time
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge in r
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{n: name, help: help}, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(g.fn()))
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.RWMutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []atomic.Uint64 // one per bucket, non-cumulative
+	count  atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+// NewHistogramVec creates and registers a histogram in r
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{n: name, help: help, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records v for the label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	h.mu.RLock()
+	hist, ok := h.values[key]
+	h.mu.RUnlock()
+	if !ok {
+		h.mu.Lock()
+		if hist, ok = h.values[key]; !ok {
+			hist = &histogram{counts: make([]atomic.Uint64, len(h.buckets))}
+			h.values[key] = hist
+		}
+		h.mu.Unlock()
+	}
+
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hist.counts[i].Add(1)
+	}
+	hist.count.Add(1)
+	for {
+		old := hist.sum.Load()
+		if hist.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			break
+		}
+	}
+}
+
+// Count returns the number of observations for the label values
+func (h *HistogramVec) Count(values ...string) uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if hist, ok := h.values[strings.Join(values, "\xff")]; ok {
+		return hist.count.Load()
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w, "histogram")
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hist.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.labelPairs(key, formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.n, h.labelPairs(key, "+Inf"), hist.count.Load())
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.n, h.labelPairs(key, ""), formatFloat(math.Float64frombits(hist.sum.Load())))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.n, h.labelPairs(key, ""), hist.count.Load())
+	}
+}
+
+// desc holds the metadata shared by all metric types
+type desc struct {
+	n      string
+	help   string
+	labels []string
+}
+
+func (d *desc) name() string { return d.n }
+
+func (d *desc) header(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.n, d.help, d.n, typ)
+}
+
+// labelPairs renders {a="x",b="y"} from a joined key, adding le if set
+func (d *desc) labelPairs(key, le string) string {
+	if len(d.labels) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			if i >= len(d.labels) {
+				break
+			}
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(d.labels[i])
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(v))
+			b.WriteByte('"')
+		}
+	}
+	if le != "" {
+		if len(d.labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`le="`)
+		b.WriteString(le)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
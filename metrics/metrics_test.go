@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_requests_total", "Test requests.", "host", "code")
+
+	c.Inc("example.com", "200")
+	c.Inc("example.com", "200")
+	c.Add(3, "example.com", "502")
+
+	if got := c.Value("example.com", "200"); got != 2 {
+		t.Errorf("Value() = %d, want 2", got)
+	}
+
+	out := scrape(t, r)
+	for _, want := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{host="example.com",code="200"} 2`,
+		`test_requests_total{host="example.com",code="502"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_duration_seconds", "Test durations.", []float64{0.1, 1}, "host")
+
+	h.Observe(0.05, "a")
+	h.Observe(0.5, "a")
+	h.Observe(5, "a")
+
+	out := scrape(t, r)
+	for _, want := range []string{
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{host="a",le="0.1"} 1`,
+		`test_duration_seconds_bucket{host="a",le="1"} 2`,
+		`test_duration_seconds_bucket{host="a",le="+Inf"} 3`,
+		`test_duration_seconds_sum{host="a"} 5.55`,
+		`test_duration_seconds_count{host="a"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGaugeVec("test_in_flight", "Test in-flight requests.", "service")
+
+	g.Add(3, "api")
+	g.Add(-1, "api")
+	g.Add(1, "web")
+
+	if got := g.Value("api"); got != 2 {
+		t.Errorf("Value() = %d, want 2", got)
+	}
+
+	var got []string
+	g.Each(func(values []string, v int64) { got = append(got, fmt.Sprintf("%s=%d", values[0], v)) })
+	if strings.Join(got, ",") != "api=2,web=1" {
+		t.Errorf("Each() visited %v, want [api=2 web=1]", got)
+	}
+
+	out := scrape(t, r)
+	for _, want := range []string{
+		"# TYPE test_in_flight gauge",
+		`test_in_flight{service="api"} 2`,
+		`test_in_flight{service="web"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("test_routes", "Test routes.", func() float64 { return 42 })
+
+	if out := scrape(t, r); !strings.Contains(out, "test_routes 42\n") {
+		t.Errorf("output missing gauge value:\n%s", out)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test.", "path")
+	c.Inc(`/a"b\c`)
+
+	if out := scrape(t, r); !strings.Contains(out, `test_total{path="/a\"b\\c"} 1`) {
+		t.Errorf("label not escaped:\n%s", out)
+	}
+}
+
+func TestHostLabelerRouteMode(t *testing.T) {
+	l, err := NewHostLabeler(HostRoute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		host := fmt.Sprintf("tenant%d.tenant.com", i)
+		if got := l.Label(host, "*.tenant.com"); got != "*.tenant.com" {
+			t.Fatalf("Label(%q) = %q, want %q", host, got, "*.tenant.com")
+		}
+	}
+}
+
+func TestHostLabelerRequestModeCap(t *testing.T) {
+	l, err := NewHostLabeler(HostRequest, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"a.tenant.com", "a.tenant.com"},
+		{"b.tenant.com", "b.tenant.com"},
+		{"c.tenant.com", OtherHost}, // over the limit
+		{"a.tenant.com", "a.tenant.com"},
+	}
+	for _, tt := range tests {
+		if got := l.Label(tt.host, "*.tenant.com"); got != tt.want {
+			t.Errorf("Label(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestNewHostLabelerInvalid(t *testing.T) {
+	if _, err := NewHostLabeler("tenant", 0); err == nil {
+		t.Error("NewHostLabeler() expected error for invalid mode")
+	}
+}
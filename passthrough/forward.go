@@ -0,0 +1,169 @@
+package passthrough
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/listener"
+)
+
+// Forwarder exposes forward routes (liteproxy.tcp.port, liteproxy.udp.port)
+// on their host ports. TCP connections are proxied like passthrough routes;
+// UDP datagrams are relayed per client. It also opens the ports of
+// passthrough presets (liteproxy.passthrough: mqtt), routed by host.
+type Forwarder struct {
+	mu        sync.Mutex
+	listeners map[forwardKey]*forwardListener
+	sni       map[int]*sniListener
+}
+
+// forwardKey names a host port, such as tcp/5432
+type forwardKey struct {
+	proto string // tcp or udp
+	port  int
+}
+
+func (k forwardKey) String() string {
+	return k.proto + "/" + strconv.Itoa(k.port)
+}
+
+// forwardListener is one open host port
+type forwardListener struct {
+	route  atomic.Pointer[compose.Route] // swapped on reload, read per connection
+	closer io.Closer
+}
+
+// NewForwarder creates a forwarder without listeners
+func NewForwarder() *Forwarder {
+	return &Forwarder{
+		listeners: make(map[forwardKey]*forwardListener),
+		sni:       make(map[int]*sniListener),
+	}
+}
+
+// Update opens ports for new forward routes, closes those of removed ones
+// and points the rest at their current backends. Connections already open
+// on a closed port carry on. A port that can't be opened is reported but
+// doesn't stop the others.
+func (f *Forwarder) Update(routes []compose.Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	want := make(map[forwardKey]*compose.Route)
+	wantSNI := make(map[int]map[string]*compose.Route)
+	for _, route := range routes {
+		r := &route
+		if r.TCPPort != 0 {
+			want[forwardKey{"tcp", r.TCPPort}] = r
+		}
+		if r.UDPPort != 0 {
+			want[forwardKey{"udp", r.UDPPort}] = r
+		}
+		for _, port := range r.SNIPorts {
+			if wantSNI[port] == nil {
+				wantSNI[port] = make(map[string]*compose.Route)
+			}
+			for _, host := range append([]string{r.Host}, r.Aliases...) {
+				wantSNI[port][strings.ToLower(host)] = r
+			}
+		}
+	}
+
+	for key, l := range f.listeners {
+		if _, ok := want[key]; !ok {
+			l.closer.Close()
+			delete(f.listeners, key)
+			slog.Info("forward: closed", "port", key.String())
+		}
+	}
+	var errs []error
+	for key, r := range want {
+		if l, ok := f.listeners[key]; ok {
+			l.route.Store(r)
+			continue
+		}
+		l, err := listenForward(key, r)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f.listeners[key] = l
+		slog.Info("forward: opened", "port", key.String(), "backend", net.JoinHostPort(r.DialHost(), strconv.Itoa(r.ServicePort)))
+	}
+
+	for port, l := range f.sni {
+		if _, ok := wantSNI[port]; !ok {
+			l.closer.Close()
+			delete(f.sni, port)
+			slog.Info("forward: closed", "port", forwardKey{"tcp", port}.String())
+		}
+	}
+	for port, hosts := range wantSNI {
+		if l, ok := f.sni[port]; ok {
+			l.table.Store(newSNITable(hosts))
+			continue
+		}
+		l, err := listenSNI(port, hosts)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f.sni[port] = l
+		slog.Info("forward: opened", "port", forwardKey{"tcp", port}.String(), "preset", l.table.Load().preset(), "hosts", sniHostList(hosts))
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every port
+func (f *Forwarder) Close() {
+	f.Update(nil)
+}
+
+func listenForward(key forwardKey, r *compose.Route) (*forwardListener, error) {
+	l := &forwardListener{}
+	l.route.Store(r)
+	addr := ":" + strconv.Itoa(key.port)
+	if key.proto == "udp" {
+		conn, err := listener.ListenPacket(addr)
+		if err != nil {
+			return nil, fmt.Errorf("forward %s: %w", key, err)
+		}
+		relay := &udpRelay{conn: conn, route: &l.route, sessions: make(map[string]*udpSession)}
+		l.closer = conn
+		go relay.serve()
+		return l, nil
+	}
+	ln, err := listener.Listen(addr, listener.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("forward %s: %w", key, err)
+	}
+	l.closer = ln
+	go l.serveTCP(ln)
+	return l, nil
+}
+
+func (l *forwardListener) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// Out of file descriptors and the like; back off and retry
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		r := l.route.Load()
+		backend := net.JoinHostPort(r.DialHost(), strconv.Itoa(r.ServicePort))
+		go proxyTCP(conn, backend, r.ProxyProtocol, nil, 0)
+	}
+}
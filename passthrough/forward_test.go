@@ -0,0 +1,150 @@
+package passthrough
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// freePort returns a port nothing is listening on for proto
+func freePort(t *testing.T, proto string) int {
+	t.Helper()
+	if proto == "udp" {
+		c, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		return c.LocalAddr().(*net.UDPAddr).Port
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// tcpGreeter answers each connection with its name and closes it
+func tcpGreeter(t *testing.T, name string) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, name+"\n")
+			conn.Close()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestForwardTCP(t *testing.T) {
+	port := freePort(t, "tcp")
+	route := compose.Route{ServiceName: "127.0.0.1", ServicePort: tcpGreeter(t, "db1"), TCPPort: port}
+	f := NewForwarder()
+	defer f.Close()
+	if err := f.Update([]compose.Route{route}); err != nil {
+		t.Fatal(err)
+	}
+	greeting := func() (string, error) {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		return strings.TrimSpace(line), err
+	}
+	if got, err := greeting(); got != "db1" {
+		t.Fatalf("greeting = %q, %v; want db1", got, err)
+	}
+
+	// A reload keeps the port open and moves it to the new backend
+	route.ServicePort = tcpGreeter(t, "db2")
+	if err := f.Update([]compose.Route{route}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := greeting(); got != "db2" {
+		t.Errorf("after reload: greeting = %q, %v; want db2", got, err)
+	}
+
+	// Removing the route closes the port
+	f.Update(nil)
+	if _, err := greeting(); err == nil {
+		t.Error("port still open after its route was removed")
+	}
+}
+
+func TestForwardUDP(t *testing.T) {
+	// The backend echoes each datagram in upper case
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo([]byte(strings.ToUpper(string(buf[:n]))), addr)
+		}
+	}()
+
+	port := freePort(t, "udp")
+	f := NewForwarder()
+	defer f.Close()
+	err = f.Update([]compose.Route{{ServiceName: "127.0.0.1", ServicePort: backend.LocalAddr().(*net.UDPAddr).Port, UDPPort: port}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two clients each get their own replies
+	for _, msg := range []string{"ping", "hello"} {
+		conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for range 2 {
+			conn.Write([]byte(msg))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 1500)
+			n, err := conn.Read(buf)
+			if err != nil || string(buf[:n]) != strings.ToUpper(msg) {
+				t.Fatalf("reply to %q = %q, %v", msg, buf[:n], err)
+			}
+		}
+	}
+}
+
+func TestForwardPortInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f := NewForwarder()
+	defer f.Close()
+	err = f.Update([]compose.Route{{ServiceName: "db", ServicePort: 5432, TCPPort: ln.Addr().(*net.TCPAddr).Port}})
+	if err == nil {
+		t.Error("expected error for a port in use")
+	}
+}
@@ -0,0 +1,115 @@
+package passthrough
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// forwardHTTP is proxyTCP for plain HTTP/1 passthrough connections that
+// should tell the backend the client's address: each request is parsed and
+// written on with X-Forwarded-For and X-Forwarded-Proto set, while
+// responses are copied back untouched. Upgraded connections, such as
+// WebSockets, are copied raw once the upgrade request has been sent.
+func forwardHTTP(client net.Conn, backend string, proxyProtocol int, initialData []byte, idle time.Duration) {
+	backendConn, err := dialBackend(client, backend, proxyProtocol)
+	if err != nil {
+		client.Close()
+		return
+	}
+
+	// The peeked data goes back to its pool when the caller returns, which
+	// may be before the rewriting goroutine has read it all
+	src := &replayConn{Conn: client, buf: append([]byte(nil), initialData...)}
+	pr, pw := io.Pipe()
+	go rewriteRequests(pw, bufio.NewReader(src), clientIP(client))
+	splice(&forwardedConn{Conn: client, requests: pr}, backendConn, nil, idle)
+}
+
+// rewriteRequests reads requests from br and writes them to w with the
+// client's address added, until the client is done or upgrades
+func rewriteRequests(w *io.PipeWriter, br *bufio.Reader, ip string) {
+	bw := bufio.NewWriter(w)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			w.CloseWithError(err)
+			return
+		}
+		// Headers clients send are replaced, so they can't forge their address
+		req.Header.Set("X-Forwarded-For", ip)
+		req.Header.Set("X-Forwarded-Proto", "http")
+		if _, ok := req.Header["User-Agent"]; !ok {
+			req.Header["User-Agent"] = []string{""} // keep net/http from adding its own
+		}
+		// Send the headers before waiting on the body: clients that sent
+		// Expect: 100-continue hold it back until the backend answers
+		if req.Body != http.NoBody {
+			req.Body = flushingBody{req.Body, bw}
+		}
+		err = req.Write(bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			w.CloseWithError(err)
+			return
+		}
+		if req.Method == http.MethodConnect || req.Header.Get("Upgrade") != "" {
+			_, err := io.Copy(w, br)
+			w.CloseWithError(err)
+			return
+		}
+	}
+}
+
+// flushingBody flushes what has been written of a request before each read
+// of its body, so nothing sits buffered while the client is slow to send
+type flushingBody struct {
+	io.ReadCloser
+	w *bufio.Writer
+}
+
+func (b flushingBody) Read(p []byte) (int, error) {
+	if err := b.w.Flush(); err != nil {
+		return 0, err
+	}
+	return b.ReadCloser.Read(p)
+}
+
+// clientIP returns the address of the client at the other end of conn
+func clientIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// forwardedConn is a client connection whose reads return the rewritten
+// requests instead of the raw stream
+type forwardedConn struct {
+	net.Conn
+	requests *io.PipeReader
+}
+
+func (c *forwardedConn) Read(b []byte) (int, error) {
+	return c.requests.Read(b)
+}
+
+func (c *forwardedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func (c *forwardedConn) Close() error {
+	c.requests.Close()
+	return c.Conn.Close()
+}
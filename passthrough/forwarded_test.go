@@ -0,0 +1,112 @@
+package passthrough
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestPassthroughForwardedFor(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %s ua=%q %s", r.Method, r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Proto"), r.UserAgent(), body)
+	}))
+	defer backend.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	backendPort := backend.Listener.Addr().(*net.TCPAddr).Port
+	routes := []compose.Route{{Host: "app.example.com", ServiceName: "127.0.0.1", ServicePort: backendPort, Passthrough: true, ForwardedFor: true}}
+	l := NewHTTPListener(ln, router.New(routes), http.NotFoundHandler())
+	go l.Serve()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	// Several requests on one connection, including a forged header and a body
+	requests := []struct {
+		raw  string
+		want string
+	}{
+		{"GET / HTTP/1.1\r\nHost: app.example.com\r\n\r\n", `GET 127.0.0.1 http ua="" `},
+		{"GET / HTTP/1.1\r\nHost: app.example.com\r\nX-Forwarded-For: 10.9.9.9\r\nUser-Agent: curl\r\n\r\n", `GET 127.0.0.1 http ua="curl" `},
+		{"POST / HTTP/1.1\r\nHost: app.example.com\r\nContent-Length: 5\r\n\r\nhello", `POST 127.0.0.1 http ua="" hello`},
+	}
+	for _, req := range requests {
+		if _, err := io.WriteString(conn, req.raw); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("ReadResponse() error = %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := strings.TrimSpace(string(body)); got != strings.TrimSpace(req.want) {
+			t.Errorf("backend saw %q, want %q", got, req.want)
+		}
+	}
+}
+
+func TestPassthroughForwardedExpectContinue(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body) // reading sends the 100 Continue
+		fmt.Fprintf(w, "%s %s", r.Header.Get("X-Forwarded-For"), body)
+	}))
+	defer backend.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	backendPort := backend.Listener.Addr().(*net.TCPAddr).Port
+	routes := []compose.Route{{Host: "app.example.com", ServiceName: "127.0.0.1", ServicePort: backendPort, Passthrough: true, ForwardedFor: true}}
+	l := NewHTTPListener(ln, router.New(routes), http.NotFoundHandler())
+	go l.Serve()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+
+	// The client holds the body back until the backend asks for it
+	io.WriteString(conn, "PUT /upload HTTP/1.1\r\nHost: app.example.com\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("waiting for 100 Continue: %v", err)
+	}
+	if resp.StatusCode != http.StatusContinue {
+		t.Fatalf("status = %d, want 100", resp.StatusCode)
+	}
+
+	io.WriteString(conn, "hello")
+	resp, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := string(body); got != "127.0.0.1 hello" {
+		t.Errorf("backend saw %q, want %q", got, "127.0.0.1 hello")
+	}
+}
@@ -0,0 +1,104 @@
+package passthrough
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// mcLegacyPing starts the ping of clients older than Minecraft 1.7, which
+// carries no server address
+const mcLegacyPing = 0xFE
+
+var errMCHandshake = errors.New("malformed Minecraft handshake")
+
+// handleMinecraft routes a Minecraft Java Edition connection by the server
+// address the client typed, sent in cleartext in its first packet
+func (l *sniListener) handleMinecraft(conn net.Conn, table *sniTable, buf []byte) {
+	n := 0
+	for {
+		m, err := conn.Read(buf[n:])
+		if err != nil {
+			conn.Close()
+			return
+		}
+		n += m
+
+		host, err := parseMinecraftHandshake(buf[:n])
+		if errors.Is(err, errMCHandshake) || (err != nil && n == len(buf)) {
+			l.reject(conn, "a malformed Minecraft handshake")
+			return
+		}
+		if err != nil {
+			continue // the packet is split across reads
+		}
+		route := table.match(host)
+		if route == nil {
+			l.reject(conn, fmt.Sprintf("server address %q", host))
+			return
+		}
+		l.proxy(conn, route, nil, buf[:n])
+		return
+	}
+}
+
+// parseMinecraftHandshake returns the server address in a handshake packet,
+// or "" for a legacy ping. It returns io.ErrUnexpectedEOF if b holds only
+// part of the packet.
+func parseMinecraftHandshake(b []byte) (string, error) {
+	if len(b) > 0 && b[0] == mcLegacyPing {
+		return "", nil
+	}
+	length, n, err := readVarInt(b)
+	if err != nil {
+		return "", err
+	}
+	if length < 1 || length > peekBufSize {
+		return "", errMCHandshake
+	}
+	if len(b)-n < length {
+		return "", io.ErrUnexpectedEOF
+	}
+	packet := b[n : n+length]
+
+	// Packet ID 0, the protocol version, then the address as a
+	// length-prefixed string
+	id, m, err := readVarInt(packet)
+	if err != nil || id != 0 {
+		return "", errMCHandshake
+	}
+	packet = packet[m:]
+	if _, m, err = readVarInt(packet); err != nil {
+		return "", errMCHandshake
+	}
+	packet = packet[m:]
+	size, m, err := readVarInt(packet)
+	if err != nil || size < 0 || size > len(packet)-m {
+		return "", errMCHandshake
+	}
+	host := string(packet[m : m+size])
+
+	// Forge appends "\x00FML\x00" and some launchers add more after a NUL;
+	// clients resolving SRV records may leave a trailing dot
+	host, _, _ = strings.Cut(host, "\x00")
+	return strings.TrimSuffix(host, "."), nil
+}
+
+// readVarInt decodes a Minecraft VarInt: 7 bits per byte, least
+// significant first, at most 5 bytes
+func readVarInt(b []byte) (value, n int, err error) {
+	for shift := 0; shift < 35; shift += 7 {
+		if n == len(b) {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		c := b[n]
+		n++
+		value |= int(c&0x7F) << shift
+		if c&0x80 == 0 {
+			return int(int32(value)), n, nil
+		}
+	}
+	return 0, 0, errMCHandshake
+}
@@ -0,0 +1,149 @@
+package passthrough
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// mcBackend is a fake game server that answers with its name and the
+// handshake it received
+func mcBackend(t *testing.T, name string) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 512)
+				n, _ := conn.Read(buf)
+				host, _ := parseMinecraftHandshake(buf[:n])
+				fmt.Fprintf(conn, "%s %s", name, host)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func appendVarInt(b []byte, v int) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// mcHandshake builds a handshake packet for host
+func mcHandshake(host string) []byte {
+	body := appendVarInt(nil, 0)   // packet ID
+	body = appendVarInt(body, 767) // protocol version
+	body = appendVarInt(body, len(host))
+	body = append(body, host...)
+	body = binary.BigEndian.AppendUint16(body, 25565)
+	body = appendVarInt(body, 2) // next state: login
+	return append(appendVarInt(nil, len(body)), body...)
+}
+
+func TestForwardMinecraft(t *testing.T) {
+	port := freePort(t, "tcp")
+	mc := func(host, name string) compose.Route {
+		return compose.Route{Host: host, ServiceName: "127.0.0.1", ServicePort: mcBackend(t, name),
+			Passthrough: true, Preset: compose.PresetMinecraft, SNIPorts: []int{port}}
+	}
+	f := NewForwarder()
+	defer f.Close()
+	if err := f.Update([]compose.Route{
+		mc("survival.example.com", "survival"),
+		mc("*.minigames.example.com", "minigames"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func() net.Conn {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"survival.example.com", "survival survival.example.com"},
+		{"Survival.Example.com.", "survival Survival.Example.com"},
+		{"survival.example.com\x00FML3\x00", "survival survival.example.com"},
+		{"bedwars.minigames.example.com", "minigames bedwars.minigames.example.com"},
+	}
+	for _, tt := range tests {
+		conn := dial()
+		conn.Write(mcHandshake(tt.host))
+		got, _ := io.ReadAll(conn)
+		if string(got) != tt.want {
+			t.Errorf("handshake for %q reached %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	t.Run("split handshake", func(t *testing.T) {
+		conn := dial()
+		packet := mcHandshake("survival.example.com")
+		conn.Write(packet[:5])
+		time.Sleep(50 * time.Millisecond)
+		conn.Write(packet[5:])
+		if got, _ := io.ReadAll(conn); !bytes.HasPrefix(got, []byte("survival ")) {
+			t.Errorf("reached %q, want survival", got)
+		}
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		conn := dial()
+		conn.Write(mcHandshake("creative.example.com"))
+		if got, _ := io.ReadAll(conn); len(got) != 0 {
+			t.Errorf("unknown host reached %q", got)
+		}
+	})
+}
+
+func TestParseMinecraftHandshake(t *testing.T) {
+	full := mcHandshake("play.example.com")
+	tests := []struct {
+		name    string
+		in      []byte
+		want    string
+		wantErr error
+	}{
+		{name: "handshake", in: full, want: "play.example.com"},
+		{name: "with login start", in: append(full, 0x05, 0x00, 'S', 't', 'e', 'v', 'e'), want: "play.example.com"},
+		{name: "legacy ping", in: []byte{0xFE, 0x01}, want: ""},
+		{name: "partial", in: full[:len(full)-3], wantErr: io.ErrUnexpectedEOF},
+		{name: "empty", in: nil, wantErr: io.ErrUnexpectedEOF},
+		{name: "wrong packet ID", in: []byte{0x02, 0x01, 0x00}, wantErr: errMCHandshake},
+		{name: "address overruns packet", in: []byte{0x03, 0x00, 0x01, 0x20}, wantErr: errMCHandshake},
+		{name: "oversized", in: []byte{0xFF, 0xFF, 0x03}, wantErr: errMCHandshake},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMinecraftHandshake(tt.in)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("parseMinecraftHandshake() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,13 +3,17 @@ package passthrough
 import (
 	"bufio"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/localrivet/liteproxy/proxyproto"
 	"github.com/localrivet/liteproxy/router"
 )
 
@@ -32,6 +36,7 @@ type Listener struct {
 	httpsHandler http.Handler
 	tlsConfig    *tls.Config
 	isTLS        bool
+	errorLog     *log.Logger // optional: ErrorLog for terminated connections
 
 	mu sync.RWMutex
 }
@@ -57,6 +62,13 @@ func NewHTTPListener(ln net.Listener, r *router.Router, httpHandler http.Handler
 	}
 }
 
+// SetErrorLog sets the logger for handshake and server errors on TLS
+// connections; nil uses the standard logger
+// Must be called before Serve
+func (l *Listener) SetErrorLog(errorLog *log.Logger) {
+	l.errorLog = errorLog
+}
+
 // UpdateRouter updates the router (called on config reload)
 func (l *Listener) UpdateRouter(r *router.Router) {
 	l.mu.Lock()
@@ -101,6 +113,16 @@ func (l *Listener) handleTLSConn(conn net.Conn, r *router.Router) {
 
 	sni, err := extractSNI(buf[:n])
 	if err != nil {
+		// Plain HTTP sent to the HTTPS port gets an explanation, like nginx
+		if looksLikeHTTP(buf[:n]) {
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			io.WriteString(conn, plainHTTPResponse)
+			err = errNotTLS
+		}
+		// Reported in net/http's format so one ErrorLog handles both paths
+		if l.errorLog != nil {
+			l.errorLog.Printf("http: TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+		}
 		// Not valid TLS or no SNI - close connection
 		peekBufPool.Put(buf)
 		conn.Close()
@@ -111,8 +133,8 @@ func (l *Listener) handleTLSConn(conn net.Conn, r *router.Router) {
 	route := r.GetPassthrough(sni)
 	if route != nil {
 		// Passthrough: forward raw TCP to backend
-		backend := fmt.Sprintf("%s:%d", route.ServiceName, route.ServicePort)
-		proxyTCP(conn, backend, buf[:n])
+		backend := net.JoinHostPort(route.DialHost(), strconv.Itoa(route.ServicePort))
+		proxyTCP(conn, backend, route.ProxyProtocol, buf[:n], route.IdleTimeout)
 		peekBufPool.Put(buf)
 		return
 	}
@@ -121,7 +143,7 @@ func (l *Listener) handleTLSConn(conn net.Conn, r *router.Router) {
 	// Create replay connection with peeked data, then wrap with TLS
 	wrappedConn := &replayConn{Conn: conn, buf: buf[:n], pool: &peekBufPool, poolBuf: buf}
 	tlsConn := tls.Server(wrappedConn, l.tlsConfig)
-	server := &http.Server{Handler: l.httpsHandler}
+	server := &http.Server{Handler: l.httpsHandler, ErrorLog: l.errorLog}
 	singleLn := newSingleConnListener(tlsConn)
 	server.Serve(singleLn)
 }
@@ -149,8 +171,12 @@ func (l *Listener) handleHTTPConn(conn net.Conn, r *router.Router) {
 	route, port := r.GetPassthroughPort(host, true)
 	if route != nil {
 		// Passthrough: forward raw TCP to backend (using http_port if set)
-		backend := fmt.Sprintf("%s:%d", route.ServiceName, port)
-		proxyTCP(conn, backend, buf[:n])
+		backend := net.JoinHostPort(route.DialHost(), strconv.Itoa(port))
+		if route.ForwardedFor {
+			forwardHTTP(conn, backend, route.ProxyProtocol, buf[:n], route.IdleTimeout)
+		} else {
+			proxyTCP(conn, backend, route.ProxyProtocol, buf[:n], route.IdleTimeout)
+		}
 		peekBufPool.Put(buf)
 		return
 	}
@@ -162,14 +188,37 @@ func (l *Listener) handleHTTPConn(conn net.Conn, r *router.Router) {
 	server.Serve(singleLn)
 }
 
-// proxyTCP forwards raw TCP between client and backend with zero-copy where possible
-func proxyTCP(client net.Conn, backend string, initialData []byte) {
-	backendConn, err := net.DialTimeout("tcp", backend, 10*time.Second)
+// proxyTCP forwards raw TCP between client and backend with zero-copy where possible,
+// first sending a PROXY protocol header if proxyProtocol is 1 or 2. Both are
+// closed after idle without data either way, if idle is set.
+func proxyTCP(client net.Conn, backend string, proxyProtocol int, initialData []byte, idle time.Duration) {
+	backendConn, err := dialBackend(client, backend, proxyProtocol)
 	if err != nil {
 		client.Close()
 		return
 	}
+	splice(client, backendConn, initialData, idle)
+}
+
+// dialBackend connects to backend for client, sending a PROXY protocol
+// header if proxyProtocol is 1 or 2
+func dialBackend(client net.Conn, backend string, proxyProtocol int) (net.Conn, error) {
+	backendConn, err := net.DialTimeout("tcp", backend, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if proxyProtocol != 0 {
+		if err := proxyproto.WriteHeader(backendConn, proxyProtocol, client.RemoteAddr(), client.LocalAddr()); err != nil {
+			backendConn.Close()
+			return nil, err
+		}
+	}
+	return backendConn, nil
+}
 
+// splice sends initialData to the backend, then copies both ways until
+// both sides are done, and closes them
+func splice(client, backendConn net.Conn, initialData []byte, idle time.Duration) {
 	// Write peeked data to backend first
 	if len(initialData) > 0 {
 		if _, err := backendConn.Write(initialData); err != nil {
@@ -179,6 +228,16 @@ func proxyTCP(client net.Conn, backend string, initialData []byte) {
 		}
 	}
 
+	if idle > 0 {
+		timer := time.AfterFunc(idle, func() {
+			client.Close()
+			backendConn.Close()
+		})
+		defer timer.Stop()
+		client = &idleConn{Conn: client, timer: timer, idle: idle}
+		backendConn = &idleConn{Conn: backendConn, timer: timer, idle: idle}
+	}
+
 	// Bidirectional copy with pooled buffers
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -188,8 +247,8 @@ func proxyTCP(client net.Conn, backend string, initialData []byte) {
 		buf := copyBufPool.Get().([]byte)
 		io.CopyBuffer(backendConn, client, buf)
 		copyBufPool.Put(buf)
-		if tc, ok := backendConn.(*net.TCPConn); ok {
-			tc.CloseWrite()
+		if cw, ok := backendConn.(closeWriter); ok {
+			cw.CloseWrite()
 		}
 		wg.Done()
 	}()
@@ -199,8 +258,8 @@ func proxyTCP(client net.Conn, backend string, initialData []byte) {
 		buf := copyBufPool.Get().([]byte)
 		io.CopyBuffer(client, backendConn, buf)
 		copyBufPool.Put(buf)
-		if tc, ok := client.(*net.TCPConn); ok {
-			tc.CloseWrite()
+		if cw, ok := client.(closeWriter); ok {
+			cw.CloseWrite()
 		}
 		wg.Done()
 	}()
@@ -210,6 +269,35 @@ func proxyTCP(client net.Conn, backend string, initialData []byte) {
 	backendConn.Close()
 }
 
+// closeWriter is a connection that can be half-closed, such as
+// *net.TCPConn or a PROXY protocol connection wrapping one
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// idleConn pushes back a shared idle timer whenever data arrives, so a
+// connection pair stays open while either side is talking
+type idleConn struct {
+	net.Conn
+	timer *time.Timer
+	idle  time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.timer.Reset(c.idle)
+	}
+	return n, err
+}
+
+func (c *idleConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
 // replayConn replays buffered data before reading from underlying conn
 type replayConn struct {
 	net.Conn
@@ -355,6 +443,25 @@ func extractSNI(data []byte) (string, error) {
 	return "", fmt.Errorf("no SNI")
 }
 
+var errNotTLS = errors.New("not TLS: plain HTTP request sent to HTTPS port")
+
+// plainHTTPResponse answers plain HTTP requests on the TLS port
+const plainHTTPResponse = "HTTP/1.1 400 Bad Request\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Connection: close\r\n" +
+	"\r\n" +
+	"400 Bad Request: plain HTTP request sent to HTTPS port\n"
+
+// looksLikeHTTP reports whether data starts like an HTTP/1.x request line
+func looksLikeHTTP(data []byte) bool {
+	for _, method := range []string{"GET ", "HEAD ", "POST ", "PUT ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "} {
+		if len(data) >= len(method) && string(data[:len(method)]) == method {
+			return true
+		}
+	}
+	return false
+}
+
 // extractHTTPHost parses HTTP request and returns Host header
 func extractHTTPHost(data []byte) (string, error) {
 	reader := bufio.NewReader(&bytesReader{data, 0})
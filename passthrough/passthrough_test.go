@@ -1,7 +1,17 @@
 package passthrough
 
 import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
 	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
 )
 
 func TestExtractSNI(t *testing.T) {
@@ -185,3 +195,91 @@ func BenchmarkExtractHTTPHost(b *testing.B) {
 		extractHTTPHost(request)
 	}
 }
+
+func TestLooksLikeHTTP(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{"GET / HTTP/1.1\r\n", true},
+		{"POST /api HTTP/1.1\r\n", true},
+		{"OPTIONS * HTTP/1.1\r\n", true},
+		{"\x16\x03\x01\x02\x00", false},
+		{"GE", false},
+		{"GETX / HTTP/1.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := looksLikeHTTP([]byte(tt.data)); got != tt.want {
+			t.Errorf("looksLikeHTTP(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestPlainHTTPOnTLSPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewTLSListener(ln, router.New(nil), http.NotFoundHandler(), &tls.Config{})
+	go l.Serve()
+	defer ln.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse() error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "plain HTTP request sent to HTTPS port") {
+		t.Errorf("got %d %q, want 400 plain HTTP explanation", resp.StatusCode, body)
+	}
+}
+
+func TestPassthroughProxyProtocol(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- header
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	backendPort := backend.Addr().(*net.TCPAddr).Port
+	routes := []compose.Route{{Host: "tcp.example.com", ServiceName: "127.0.0.1", ServicePort: backendPort, Passthrough: true, ProxyProtocol: 1}}
+	l := NewHTTPListener(ln, router.New(routes), http.NotFoundHandler())
+	go l.Serve()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: tcp.example.com\r\n\r\n"))
+
+	clientPort := conn.LocalAddr().(*net.TCPAddr).Port
+	listenPort := ln.Addr().(*net.TCPAddr).Port
+	want := fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %d\r\n", clientPort, listenPort)
+	if got := <-received; got != want {
+		t.Errorf("backend received %q, want %q", got, want)
+	}
+}
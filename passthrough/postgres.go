@@ -0,0 +1,185 @@
+package passthrough
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// Codes a Postgres client sends in place of a protocol version
+const (
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+	pgCancelRequest = 80877102
+)
+
+// pgMaxStartup bounds a startup message, as Postgres itself does
+const pgMaxStartup = 10000
+
+// tlsHandshakeRecord starts a TLS ClientHello
+const tlsHandshakeRecord = 0x16
+
+// handlePostgres routes a Postgres connection. TLS clients send an
+// SSLRequest in cleartext first, or since Postgres 17 may start TLS
+// directly; either way the ClientHello's SNI picks the route. Cleartext
+// clients are routed by the database and user in their startup message.
+func (l *sniListener) handlePostgres(conn net.Conn, table *sniTable, buf []byte) {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(conn, hdr[:1]); err != nil {
+			conn.Close()
+			return
+		}
+
+		// Direct TLS (sslnegotiation=direct) has no SSLRequest
+		if hdr[0] == tlsHandshakeRecord {
+			buf[0] = hdr[0]
+			n, err := conn.Read(buf[1:])
+			if err != nil {
+				conn.Close()
+				return
+			}
+			l.proxyPostgresTLS(conn, table, nil, buf[:n+1])
+			return
+		}
+
+		if _, err := io.ReadFull(conn, hdr[1:]); err != nil {
+			conn.Close()
+			return
+		}
+		length := binary.BigEndian.Uint32(hdr[:4])
+		code := binary.BigEndian.Uint32(hdr[4:])
+		if length < 8 || length > pgMaxStartup {
+			l.reject(conn, "a malformed Postgres startup packet")
+			return
+		}
+
+		switch code {
+		case pgGSSENCRequest:
+			// Decline; the client goes on with an SSLRequest or a startup message
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				conn.Close()
+				return
+			}
+			continue
+
+		case pgSSLRequest:
+			if _, err := conn.Write([]byte{'S'}); err != nil {
+				conn.Close()
+				return
+			}
+			n, err := conn.Read(buf)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			l.proxyPostgresTLS(conn, table, hdr[:], buf[:n])
+			return
+		}
+
+		msg := make([]byte, length)
+		copy(msg, hdr[:])
+		if _, err := io.ReadFull(conn, msg[8:]); err != nil {
+			conn.Close()
+			return
+		}
+		if code == pgCancelRequest {
+			// Cancel requests carry only a backend process ID
+			route := table.match("")
+			if route == nil {
+				l.reject(conn, "a Postgres cancel request")
+				return
+			}
+			l.proxy(conn, route, nil, msg)
+			return
+		}
+
+		database, user := parseStartup(msg[8:])
+		route := table.matchStartup(database, user)
+		if route == nil {
+			l.reject(conn, fmt.Sprintf("Postgres database %q user %q", database, user))
+			return
+		}
+		l.proxy(conn, route, nil, msg)
+		return
+	}
+}
+
+// proxyPostgresTLS routes by the SNI in hello. sslRequest is replayed to
+// the backend before the ClientHello, if the client sent one.
+func (l *sniListener) proxyPostgresTLS(conn net.Conn, table *sniTable, sslRequest, hello []byte) {
+	sni, _ := extractSNI(hello)
+	route := table.match(sni)
+	if route == nil {
+		l.reject(conn, fmt.Sprintf("SNI %q", sni))
+		return
+	}
+	l.proxy(conn, route, sslRequest, hello)
+}
+
+// postgresStartTLS sends a client's SSLRequest to the backend and consumes
+// its answer; the client has already been told to go ahead
+func postgresStartTLS(backend net.Conn, sslRequest []byte) error {
+	if _, err := backend.Write(sslRequest); err != nil {
+		return err
+	}
+	backend.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer backend.SetReadDeadline(time.Time{})
+	var answer [1]byte
+	if _, err := io.ReadFull(backend, answer[:]); err != nil {
+		return err
+	}
+	if answer[0] != 'S' {
+		return fmt.Errorf("backend refused TLS (answered %q)", answer[0])
+	}
+	return nil
+}
+
+// parseStartup returns the database and user from a startup message's
+// parameters. The database defaults to the user, as in Postgres.
+func parseStartup(params []byte) (database, user string) {
+	for len(params) > 0 {
+		key, rest, ok := bytes.Cut(params, []byte{0})
+		if !ok || len(key) == 0 {
+			break
+		}
+		value, rest, ok := bytes.Cut(rest, []byte{0})
+		if !ok {
+			break
+		}
+		switch string(key) {
+		case "database":
+			database = string(value)
+		case "user":
+			user = string(value)
+		}
+		params = rest
+	}
+	if database == "" {
+		database = user
+	}
+	return database, user
+}
+
+// matchStartup returns the route for a cleartext startup message: the
+// first route listing its database or user, else the first that lists none
+func (t *sniTable) matchStartup(database, user string) *compose.Route {
+	var fallback *compose.Route
+	for _, r := range t.routes {
+		if r.Postgres == nil {
+			if fallback == nil {
+				fallback = r
+			}
+			continue
+		}
+		if r.Postgres.Matches(database, user) {
+			return r
+		}
+	}
+	return fallback
+}
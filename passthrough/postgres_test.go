@@ -0,0 +1,188 @@
+package passthrough
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// bufferedConn reads through a bufio.Reader that has peeked at the conn
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// pgBackend is a fake Postgres server that answers with its name and, for
+// cleartext clients, the database and user it was asked for
+func pgBackend(t *testing.T, name string, cert tls.Certificate) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if first, _ := r.Peek(1); len(first) == 1 && first[0] == tlsHandshakeRecord {
+					tlsConn := tls.Server(bufferedConn{conn, r}, &tls.Config{Certificates: []tls.Certificate{cert}})
+					io.WriteString(tlsConn, name)
+					tlsConn.Close()
+					return
+				}
+				var hdr [8]byte
+				if _, err := io.ReadFull(r, hdr[:]); err != nil {
+					return
+				}
+				if binary.BigEndian.Uint32(hdr[4:]) == pgSSLRequest {
+					conn.Write([]byte{'S'})
+					tlsConn := tls.Server(bufferedConn{conn, r}, &tls.Config{Certificates: []tls.Certificate{cert}})
+					io.WriteString(tlsConn, name)
+					tlsConn.Close()
+					return
+				}
+				params := make([]byte, binary.BigEndian.Uint32(hdr[:4])-8)
+				io.ReadFull(r, params)
+				database, user := parseStartup(params)
+				fmt.Fprintf(conn, "%s %s %s", name, database, user)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// startupMessage builds a protocol 3.0 startup message
+func startupMessage(params ...string) []byte {
+	body := binary.BigEndian.AppendUint32(nil, 196608)
+	for _, p := range params {
+		body = append(append(body, p...), 0)
+	}
+	body = append(body, 0)
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body)+4)), body...)
+}
+
+func pgRequest(code uint32) []byte {
+	return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), code)
+}
+
+func TestForwardPostgres(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	cert := ts.TLS.Certificates[0]
+	ts.Close()
+
+	port := freePort(t, "tcp")
+	pg := func(host, service string, match *compose.PostgresMatch) compose.Route {
+		return compose.Route{Host: host, ServiceName: "127.0.0.1", ServicePort: pgBackend(t, service, cert),
+			Passthrough: true, Preset: compose.PresetPostgres, SNIPorts: []int{port}, Postgres: match}
+	}
+	f := NewForwarder()
+	defer f.Close()
+	err := f.Update([]compose.Route{
+		pg("db.example.com", "main", nil),
+		pg("orders.example.com", "orders", &compose.PostgresMatch{Databases: []string{"orders"}}),
+		pg("reports.example.com", "reports", &compose.PostgresMatch{Users: []string{"analyst"}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func() net.Conn {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("cleartext", func(t *testing.T) {
+		tests := []struct {
+			params []string
+			want   string
+		}{
+			{[]string{"user", "app", "database", "orders"}, "orders orders app"},
+			{[]string{"user", "analyst", "database", "warehouse"}, "reports warehouse analyst"},
+			{[]string{"user", "app", "database", "billing"}, "main billing app"},
+			{[]string{"user", "orders"}, "orders orders orders"}, // database defaults to the user
+		}
+		for _, tt := range tests {
+			conn := dial()
+			conn.Write(startupMessage(tt.params...))
+			got, _ := io.ReadAll(conn)
+			if string(got) != tt.want {
+				t.Errorf("startup %q reached %q, want %q", tt.params, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("after GSSENCRequest", func(t *testing.T) {
+		conn := dial()
+		conn.Write(pgRequest(pgGSSENCRequest))
+		answer := make([]byte, 1)
+		if _, err := io.ReadFull(conn, answer); err != nil || answer[0] != 'N' {
+			t.Fatalf("GSSENCRequest answer = %q, %v; want N", answer, err)
+		}
+		conn.Write(startupMessage("user", "app", "database", "orders"))
+		if got, _ := io.ReadAll(conn); string(got) != "orders orders app" {
+			t.Errorf("reached %q", got)
+		}
+	})
+
+	t.Run("SSLRequest", func(t *testing.T) {
+		conn := dial()
+		conn.Write(pgRequest(pgSSLRequest))
+		answer := make([]byte, 1)
+		if _, err := io.ReadFull(conn, answer); err != nil || answer[0] != 'S' {
+			t.Fatalf("SSLRequest answer = %q, %v; want S", answer, err)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: "reports.example.com", InsecureSkipVerify: true})
+		if got, _ := io.ReadAll(tlsConn); string(got) != "reports" {
+			t.Errorf("reached %q, want reports", got)
+		}
+	})
+
+	t.Run("direct TLS", func(t *testing.T) {
+		tlsConn := tls.Client(dial(), &tls.Config{ServerName: "orders.example.com", InsecureSkipVerify: true, NextProtos: []string{"postgresql"}})
+		if got, _ := io.ReadAll(tlsConn); string(got) != "orders" {
+			t.Errorf("reached %q, want orders", got)
+		}
+	})
+
+	t.Run("unknown SNI", func(t *testing.T) {
+		conn := dial()
+		conn.Write(pgRequest(pgSSLRequest))
+		io.ReadFull(conn, make([]byte, 1))
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: "nope.example.com", InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err == nil {
+			t.Error("unknown SNI host was forwarded")
+		}
+	})
+}
+
+func TestParseStartup(t *testing.T) {
+	msg := startupMessage("user", "app", "database", "orders", "application_name", "psql")
+	if db, user := parseStartup(msg[8:]); db != "orders" || user != "app" {
+		t.Errorf("parseStartup() = %q, %q", db, user)
+	}
+	if db, user := parseStartup([]byte("user\x00")); db != "" || user != "" {
+		t.Errorf("truncated: parseStartup() = %q, %q", db, user)
+	}
+}
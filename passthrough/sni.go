@@ -0,0 +1,187 @@
+package passthrough
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/errlog"
+	"github.com/localrivet/liteproxy/listener"
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+var (
+	sniConnections = metrics.Default.NewCounterVec(
+		"liteproxy_passthrough_connections_total",
+		"Connections to passthrough preset ports, by protocol and host.",
+		"protocol", "host")
+	sniOpen = metrics.Default.NewGaugeVec(
+		"liteproxy_passthrough_connections_open",
+		"Open connections on passthrough preset ports, by protocol.",
+		"protocol")
+	sniRejected = metrics.Default.NewCounterVec(
+		"liteproxy_passthrough_rejected_total",
+		"Connections to passthrough preset ports without a known host, by port.",
+		"port")
+)
+
+// helloTimeout bounds waiting for a client's TLS ClientHello
+const helloTimeout = 10 * time.Second
+
+// sniListener is a passthrough preset's port, shared by its routes and
+// routed by the SNI in each client's ClientHello or the hostname the
+// protocol sends first
+type sniListener struct {
+	port   int
+	table  atomic.Pointer[sniTable] // swapped on reload
+	closer net.Listener
+}
+
+// sniTable is the routes sharing a port
+type sniTable struct {
+	hosts  map[string]*compose.Route // host or alias → route
+	routes []*compose.Route          // distinct routes, sorted by host
+}
+
+func newSNITable(hosts map[string]*compose.Route) *sniTable {
+	t := &sniTable{hosts: hosts}
+	for _, r := range hosts {
+		if !slices.Contains(t.routes, r) {
+			t.routes = append(t.routes, r)
+		}
+	}
+	slices.SortFunc(t.routes, func(a, b *compose.Route) int { return strings.Compare(a.Host, b.Host) })
+	return t
+}
+
+// preset is the protocol of the routes on the port. Routes only share a
+// port with their own preset, or among the presets routed by SNI alone.
+func (t *sniTable) preset() string {
+	if len(t.routes) == 0 {
+		return ""
+	}
+	return t.routes[0].Preset
+}
+
+func listenSNI(port int, hosts map[string]*compose.Route) (*sniListener, error) {
+	ln, err := listener.Listen(":"+strconv.Itoa(port), listener.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("passthrough port %d: %w", port, err)
+	}
+	l := &sniListener{port: port, closer: ln}
+	l.table.Store(newSNITable(hosts))
+	go l.serve(ln)
+	return l, nil
+}
+
+func (l *sniListener) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// Out of file descriptors and the like; back off and retry
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go l.handle(conn)
+	}
+}
+
+func (l *sniListener) handle(conn net.Conn) {
+	buf := peekBufPool.Get().([]byte)
+	defer peekBufPool.Put(buf)
+
+	table := l.table.Load()
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	switch table.preset() {
+	case compose.PresetPostgres:
+		l.handlePostgres(conn, table, buf)
+		return
+	case compose.PresetMinecraft:
+		l.handleMinecraft(conn, table, buf)
+		return
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	sni, _ := extractSNI(buf[:n])
+	route := table.match(sni)
+	if route == nil {
+		l.reject(conn, fmt.Sprintf("SNI %q", sni))
+		return
+	}
+	l.proxy(conn, route, nil, buf[:n])
+}
+
+// proxy connects the client to route's backend, replaying the client's
+// Postgres SSLRequest first if it sent one, then initialData
+func (l *sniListener) proxy(conn net.Conn, route *compose.Route, sslRequest, initialData []byte) {
+	conn.SetReadDeadline(time.Time{})
+	sniConnections.Inc(route.Preset, route.Host)
+	sniOpen.Add(1, route.Preset)
+	defer sniOpen.Add(-1, route.Preset)
+
+	backend := net.JoinHostPort(route.DialHost(), strconv.Itoa(route.ServicePort))
+	backendConn, err := dialBackend(conn, backend, route.ProxyProtocol)
+	if err != nil {
+		errlog.Printf("passthrough", backend+" "+errlog.Class(err), "passthrough port %d: %s: %v", l.port, backend, err)
+		conn.Close()
+		return
+	}
+	if sslRequest != nil {
+		if err := postgresStartTLS(backendConn, sslRequest); err != nil {
+			errlog.Printf("passthrough", backend+" starttls", "passthrough port %d: %s: %v", l.port, backend, err)
+			conn.Close()
+			backendConn.Close()
+			return
+		}
+	}
+	splice(conn, backendConn, initialData, route.IdleTimeout)
+}
+
+func (l *sniListener) reject(conn net.Conn, what string) {
+	sniRejected.Inc(strconv.Itoa(l.port))
+	slog.Warn("passthrough: no route", "port", l.port, "for", what, "client", conn.RemoteAddr().String())
+	conn.Close()
+}
+
+// match returns the route for an SNI host, trying *.parent after the exact
+// host. Without SNI, a port with a single route uses it.
+func (t *sniTable) match(sni string) *compose.Route {
+	if sni == "" {
+		if len(t.routes) == 1 {
+			return t.routes[0]
+		}
+		return nil
+	}
+	sni = strings.ToLower(sni)
+	if r, ok := t.hosts[sni]; ok {
+		return r
+	}
+	if _, parent, ok := strings.Cut(sni, "."); ok {
+		return t.hosts["*."+parent]
+	}
+	return nil
+}
+
+// sniHostList is a sorted, comma-separated list of hosts for logging
+func sniHostList(hosts map[string]*compose.Route) string {
+	names := make([]string, 0, len(hosts))
+	for h := range hosts {
+		names = append(names, h)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
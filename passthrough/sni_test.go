@@ -0,0 +1,104 @@
+package passthrough
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+func TestForwardSNI(t *testing.T) {
+	backend := func(name string) int {
+		s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(s.Close)
+		return s.Listener.Addr().(*net.TCPAddr).Port
+	}
+	port := freePort(t, "tcp")
+	routes := []compose.Route{
+		{Host: "mqtt.example.com", ServiceName: "127.0.0.1", ServicePort: backend("broker"),
+			Passthrough: true, Preset: compose.PresetMQTT, SNIPorts: []int{port}},
+		{Host: "*.devices.example.com", ServiceName: "127.0.0.1", ServicePort: backend("fleet"),
+			Passthrough: true, Preset: compose.PresetMQTT, SNIPorts: []int{port}},
+	}
+	f := NewForwarder()
+	defer f.Close()
+	if err := f.Update(routes); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return net.Dial(network, fmt.Sprintf("127.0.0.1:%d", port))
+		},
+	}}
+	for host, want := range map[string]string{
+		"mqtt.example.com":            "broker",
+		"MQTT.example.com":            "broker",
+		"sensor1.devices.example.com": "fleet",
+	} {
+		resp, err := client.Get("https://" + host + "/")
+		if err != nil {
+			t.Errorf("%s: %v", host, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("%s reached %q, want %q", host, body, want)
+		}
+	}
+
+	if _, err := client.Get("https://unknown.example.com/"); err == nil {
+		t.Error("unknown SNI host was forwarded")
+	}
+}
+
+func TestProxyTCPIdleTimeout(t *testing.T) {
+	// The backend echoes until the client goes away
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go proxyTCP(server, ln.Addr().String(), 0, nil, 200*time.Millisecond)
+
+	// Traffic keeps the connection open past the timeout
+	buf := make([]byte, 4)
+	for range 4 {
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(client, "ping")
+		if _, err := io.ReadFull(client, buf); err != nil {
+			t.Fatalf("connection closed while active: %v", err)
+		}
+	}
+
+	// Silence closes it
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(buf); err == nil {
+		t.Error("idle connection still open")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("idle connection not closed by the proxy")
+	}
+}
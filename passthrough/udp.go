@@ -0,0 +1,115 @@
+package passthrough
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/errlog"
+)
+
+// udpIdleTimeout ends a client's UDP session after this long without
+// datagrams in either direction
+const udpIdleTimeout = 2 * time.Minute
+
+// maxDatagram fits any UDP payload
+const maxDatagram = 64 << 10
+
+// udpRelay relays datagrams between clients and a backend. Each client
+// gets its own socket towards the backend, so replies find their way back.
+type udpRelay struct {
+	conn  net.PacketConn
+	route *atomic.Pointer[compose.Route]
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession // client address → session
+}
+
+// udpSession is one client's socket towards the backend
+type udpSession struct {
+	backend net.Conn
+	active  atomic.Int64 // unix nanoseconds of the last datagram
+}
+
+func (s *udpSession) touch() {
+	s.active.Store(time.Now().UnixNano())
+}
+
+func (u *udpRelay) serve() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, client, err := u.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			u.closeSessions()
+			return
+		}
+		if err != nil {
+			continue
+		}
+		s, err := u.session(client)
+		if err != nil {
+			errlog.Printf("forward", u.conn.LocalAddr().String()+" "+errlog.Class(err), "forward udp %s: %v", u.conn.LocalAddr(), err)
+			continue
+		}
+		s.touch()
+		s.backend.Write(buf[:n])
+	}
+}
+
+// session returns the client's session, dialing the backend for a new one
+func (u *udpRelay) session(client net.Addr) (*udpSession, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if s, ok := u.sessions[client.String()]; ok {
+		return s, nil
+	}
+	r := u.route.Load()
+	backend, err := net.DialTimeout("udp", net.JoinHostPort(r.DialHost(), strconv.Itoa(r.ServicePort)), 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s := &udpSession{backend: backend}
+	s.touch()
+	u.sessions[client.String()] = s
+	go u.reply(client, s)
+	return s, nil
+}
+
+// reply sends the backend's datagrams back to the client until the session
+// goes idle or the backend refuses them
+func (u *udpRelay) reply(client net.Addr, s *udpSession) {
+	buf := make([]byte, maxDatagram)
+	for {
+		s.backend.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, err := s.backend.Read(buf)
+		if err != nil {
+			var ne net.Error
+			idle := time.Since(time.Unix(0, s.active.Load()))
+			if errors.As(err, &ne) && ne.Timeout() && idle < udpIdleTimeout {
+				continue // the client is still sending
+			}
+			break
+		}
+		s.touch()
+		u.conn.WriteTo(buf[:n], client)
+	}
+
+	u.mu.Lock()
+	if u.sessions[client.String()] == s {
+		delete(u.sessions, client.String())
+	}
+	u.mu.Unlock()
+	s.backend.Close()
+}
+
+func (u *udpRelay) closeSessions() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, s := range u.sessions {
+		s.backend.Close()
+	}
+}
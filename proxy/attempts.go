@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/localrivet/liteproxy/accesslog"
+)
+
+// UpstreamHeader names the backend that served the response (debug mode only)
+const UpstreamHeader = "X-Liteproxy-Upstream"
+
+// attemptsKey is the context key for a request's *attemptLog
+type attemptsKey struct{}
+
+// attemptLog collects the upstream attempts made for one request
+type attemptLog struct {
+	mu       sync.Mutex
+	attempts []accesslog.Attempt
+}
+
+func (l *attemptLog) add(a accesslog.Attempt) {
+	l.mu.Lock()
+	l.attempts = append(l.attempts, a)
+	l.mu.Unlock()
+}
+
+func (l *attemptLog) list() []accesslog.Attempt {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.attempts
+}
+
+// withAttemptLog returns r with a fresh attemptLog attached
+func withAttemptLog(r *http.Request) (*http.Request, *attemptLog) {
+	l := &attemptLog{}
+	return r.WithContext(context.WithValue(r.Context(), attemptsKey{}, l)), l
+}
+
+// attemptTransport records every round trip to a backend in the request's
+// attemptLog, if it has one. Each retry or failover is a separate round trip.
+type attemptTransport struct {
+	http.RoundTripper
+}
+
+func (t attemptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l, _ := req.Context().Value(attemptsKey{}).(*attemptLog)
+	if l == nil {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+
+	a := accesslog.Attempt{
+		Backend:  req.URL.Host,
+		Duration: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		a.Outcome = err.Error()
+	} else {
+		a.Outcome = strconv.Itoa(resp.StatusCode)
+	}
+	l.add(a)
+
+	return resp, err
+}
+
+// SetDebugHeaders makes responses carry X-Liteproxy-Upstream naming the backend
+// Must be called before serving requests
+func (h *Handler) SetDebugHeaders(enabled bool) {
+	h.debugHeaders = enabled
+}
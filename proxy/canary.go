@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+var canaryRequests = metrics.Default.NewCounterVec(
+	"liteproxy_canary_requests_total",
+	"Requests sent to a route's canary service, by service and canary.",
+	"service", "canary")
+
+// canaryBackend returns the canary's address if r falls in the route's
+// canary share, else "". Hashed routes place each client by its key, so
+// it stays on one side while the weight doesn't change; clients without
+// the header or cookie are placed by IP.
+func canaryBackend(route *compose.Route, r *http.Request) string {
+	c := route.Canary
+	if c == nil || c.Weight == 0 {
+		return ""
+	}
+	var bucket int
+	if c.Hash == "" {
+		bucket = rand.IntN(100)
+	} else {
+		h := fnv.New32a()
+		h.Write([]byte(route.Host + route.Path()))
+		h.Write([]byte{0})
+		h.Write([]byte(canaryKey(c.Hash, r)))
+		bucket = int(h.Sum32() % 100)
+	}
+	if bucket >= c.Weight {
+		return ""
+	}
+	canaryRequests.Inc(route.ServiceName, c.Service)
+	return c.Addr()
+}
+
+// canaryKey returns the value of r that hash names
+func canaryKey(hash string, r *http.Request) string {
+	kind, name, _ := strings.Cut(hash, ":")
+	switch kind {
+	case "header":
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := r.Cookie(name); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return clientIP(r)
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+func TestCanaryBackend(t *testing.T) {
+	route := func(weight int, hash string) *compose.Route {
+		return &compose.Route{
+			Host: "api.test", PathPrefix: "/", ServiceName: "api", ServicePort: 8080,
+			Canary: &compose.Canary{Service: "api-v2", Port: 8080, Weight: weight, Hash: hash},
+		}
+	}
+	request := func(ip string) *http.Request {
+		req := httptest.NewRequest("GET", "http://api.test/", nil)
+		req.RemoteAddr = ip + ":1234"
+		return req
+	}
+
+	if addr := canaryBackend(&compose.Route{ServiceName: "api"}, request("10.0.0.1")); addr != "" {
+		t.Errorf("route without a canary picked %q", addr)
+	}
+	if addr := canaryBackend(route(0, ""), request("10.0.0.1")); addr != "" {
+		t.Errorf("weight 0 picked %q", addr)
+	}
+	if addr := canaryBackend(route(100, ""), request("10.0.0.1")); addr != "api-v2:8080" {
+		t.Errorf("weight 100 picked %q, want the canary", addr)
+	}
+
+	// Random splits land near the weight
+	canary := 0
+	for range 10000 {
+		if canaryBackend(route(20, ""), request("10.0.0.1")) != "" {
+			canary++
+		}
+	}
+	if canary < 1500 || canary > 2500 {
+		t.Errorf("weight 20 sent %d of 10000 requests to the canary", canary)
+	}
+
+	// Hashed splits keep each client on one side, and raising the weight
+	// only moves clients onto the canary
+	for _, hash := range []string{"ip", "header:X-User", "cookie:session"} {
+		canary := 0
+		for i := range 1000 {
+			req := request(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+			req.Header.Set("X-User", fmt.Sprint("user", i))
+			req.AddCookie(&http.Cookie{Name: "session", Value: fmt.Sprint("s", i)})
+			first := canaryBackend(route(30, hash), req)
+			for range 3 {
+				if again := canaryBackend(route(30, hash), req); again != first {
+					t.Fatalf("%s: client %d moved from %q to %q", hash, i, first, again)
+				}
+			}
+			if first != "" {
+				canary++
+				if canaryBackend(route(50, hash), req) == "" {
+					t.Fatalf("%s: client %d left the canary when its weight went up", hash, i)
+				}
+			}
+		}
+		if canary < 200 || canary > 400 {
+			t.Errorf("%s: weight 30 sent %d of 1000 clients to the canary", hash, canary)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/localrivet/liteproxy/compose"
+	"golang.org/x/net/http/httpguts"
+)
+
+// compressibleTypes are media types worth compressing besides text/* and
+// the +json and +xml suffixes; images, video and archives are already compressed
+var compressibleTypes = map[string]bool{
+	"application/javascript":        true,
+	"application/x-javascript":      true,
+	"application/json":              true,
+	"application/xml":               true,
+	"application/wasm":              true,
+	"application/vnd.ms-fontobject": true,
+	"font/otf":                      true,
+	"font/ttf":                      true,
+	"image/svg+xml":                 true,
+	"image/x-icon":                  true,
+}
+
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || compressibleTypes[mt] ||
+		strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml")
+}
+
+// acceptEncoding picks the encoding from offered, in order of preference,
+// that the client's Accept-Encoding ranks highest, honouring q=0 and the *
+// wildcard. It returns "" if the client takes none of them.
+func acceptEncoding(h http.Header, offered []string) string {
+	qs, anyQ := make(map[string]float64), -1.0
+	for _, v := range h.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+			switch name = strings.ToLower(strings.TrimSpace(name)); name {
+			case "*":
+				anyQ = q
+			case "x-gzip":
+				qs[compose.CompressGzip] = q
+			default:
+				qs[name] = q
+			}
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		q, ok := qs[enc]
+		if !ok {
+			q = anyQ
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// encoder is a pooled compressor for one Content-Encoding
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// brotliLevel trades some of brotli's ratio for the speed on-the-fly
+// compression needs
+const brotliLevel = 5
+
+var encoders = map[string]*sync.Pool{
+	compose.CompressGzip:   {New: func() any { return gzip.NewWriter(nil) }},
+	compose.CompressBrotli: {New: func() any { return brotli.NewWriterLevel(nil, brotliLevel) }},
+}
+
+// compressWriter compresses responses on the fly. Compression is decided
+// when the headers are written: the body must be of a compressible type and
+// not already encoded. Bodies of unknown length are held back until MinSize
+// bytes arrive, the response ends or the proxy flushes an event stream.
+type compressWriter struct {
+	http.ResponseWriter
+	minSize  int
+	encoding string // what the client takes, "" if none; HEAD requests never get one
+
+	status   int    // status held back until the decision, 0 before WriteHeader
+	buf      []byte // body held back until the decision
+	decided  bool
+	compress bool
+	enc      encoder
+}
+
+func newCompressWriter(w http.ResponseWriter, r *http.Request, c *compose.Compress) *compressWriter {
+	cw := &compressWriter{
+		ResponseWriter: w,
+		minSize:        c.MinSize,
+	}
+	if r.Method != http.MethodHead {
+		cw.encoding = acceptEncoding(r.Header, c.Encodings)
+	}
+	return cw
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		// Informational responses (103 Early Hints) pass straight through
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	h := w.Header()
+	eligible := code != http.StatusNoContent && code != http.StatusNotModified && code != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		!httpguts.HeaderValuesContainsToken(h["Cache-Control"], "no-transform") &&
+		compressible(h.Get("Content-Type"))
+	if eligible {
+		// Caches must keep the compressed and plain variants apart
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if !eligible || w.encoding == "" {
+		w.decide(false)
+		return
+	}
+	if cl := h.Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(cl)
+		w.decide(err == nil && n >= w.minSize)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) >= w.minSize {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if w.compress {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide writes the headers, compressing or not, and any body held back
+func (w *compressWriter) decide(compress bool) error {
+	w.decided, w.compress = true, compress
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		// The compressed bytes differ, so a strong validator no longer holds
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.enc = encoders[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if compress {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush sends what's been written so far, for server-sent events, which
+// are compressed before MinSize arrives. The proxy flushes every chunked
+// response as it goes, so other bodies keep waiting for MinSize or the end.
+func (w *compressWriter) Flush() {
+	if w.status != 0 && !w.decided {
+		if !isEventStream(w.Header()) {
+			return
+		}
+		w.decide(true)
+	}
+	if w.compress {
+		w.enc.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close ends the response: a short body still held back is sent as it is
+func (w *compressWriter) close() {
+	if w.status != 0 && !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(nil)
+		encoders[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
+
+// Unwrap lets http.ResponseController reach Hijack (WebSockets)
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestCompress(t *testing.T) {
+	page := strings.Repeat("<p>hello</p>", 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page", "/head":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, page)
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, page)
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "zstd")
+			io.WriteString(w, page)
+		case "/no-transform":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Cache-Control", "no-transform")
+			io.WriteString(w, page)
+		}
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	h := New(router.New([]compose.Route{
+		{
+			Host: "app.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			Compress: &compose.Compress{Encodings: []string{"br", "gzip"}, MinSize: compose.DefaultCompressMinSize},
+		},
+		{
+			Host: "gzip.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			Compress: &compose.Compress{Encodings: []string{"gzip"}, MinSize: compose.DefaultCompressMinSize},
+		},
+	}), "http")
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		acceptEncoding string
+		wantEncoding   string
+		wantVary       bool
+	}{
+		{"compressible page", "GET", "http://app.com/page", "gzip, br", "br", true},
+		{"gzip preferred by client", "GET", "http://app.com/page", "gzip, br;q=0.5", "gzip", true},
+		{"client without brotli", "GET", "http://app.com/page", "gzip", "gzip", true},
+		{"gzip only route", "GET", "http://gzip.com/page", "gzip, br", "gzip", true},
+		{"client without gzip", "GET", "http://gzip.com/page", "br", "", true},
+		{"gzip refused with q=0", "GET", "http://gzip.com/page", "*, gzip;q=0", "", true},
+		{"wildcard", "GET", "http://app.com/page", "*", "br", true},
+		{"below min size", "GET", "http://app.com/small", "gzip, br", "", true},
+		{"incompressible type", "GET", "http://app.com/image", "gzip, br", "", false},
+		{"already encoded", "GET", "http://app.com/encoded", "gzip, br, zstd", "zstd", false},
+		{"no-transform", "GET", "http://app.com/no-transform", "gzip, br", "", false},
+		{"HEAD", "HEAD", "http://app.com/head", "gzip, br", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			res := rec.Result()
+			if got := res.Header.Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if vary := strings.Contains(res.Header.Get("Vary"), "Accept-Encoding"); vary != tt.wantVary {
+				t.Errorf("Vary = %q, want Accept-Encoding %v", res.Header.Get("Vary"), tt.wantVary)
+			}
+			if tt.wantEncoding != "br" && tt.wantEncoding != "gzip" {
+				return
+			}
+			if res.Header.Get("Content-Length") != "" || res.Header.Get("ETag") != `W/"v1"` {
+				t.Errorf("Content-Length = %q, ETag = %q; want none and a weak ETag", res.Header.Get("Content-Length"), res.Header.Get("ETag"))
+			}
+			var zr io.Reader = brotli.NewReader(res.Body)
+			if tt.wantEncoding == "gzip" {
+				var err error
+				if zr, err = gzip.NewReader(res.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			body, _ := io.ReadAll(zr)
+			if string(body) != page {
+				t.Errorf("decompressed body has %d bytes, want %d", len(body), len(page))
+			}
+		})
+	}
+}
+
+func TestCompressStreaming(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	proxy := httptest.NewServer(New(router.New([]compose.Route{{
+		Host: "app.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+		Compress: &compose.Compress{Encodings: []string{"br", "gzip"}, MinSize: compose.DefaultCompressMinSize},
+	}}), "http"))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/events", nil)
+	req.Host = "app.com"
+	req.Header.Set("Accept-Encoding", "gzip") // set explicitly so the client doesn't decompress
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", res.Header.Get("Content-Encoding"))
+	}
+
+	// The first event must arrive before the stream ends
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(zr)
+	line, err := br.ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+	close(release)
+	rest, _ := io.ReadAll(br)
+	if string(rest) != "\ndata: second\n\n" {
+		t.Errorf("rest of stream = %q", rest)
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	both := []string{"br", "gzip"}
+	tests := []struct {
+		header  string
+		offered []string
+		want    string
+	}{
+		{"", both, ""},
+		{"gzip", both, "gzip"},
+		{"deflate, gzip;q=0.5", both, "gzip"},
+		{"GZIP", both, "gzip"},
+		{"x-gzip", both, "gzip"},
+		{"gzip, br", both, "br"},
+		{"br;q=0.8, gzip", both, "gzip"},
+		{"br", both, "br"},
+		{"br", []string{"gzip"}, ""},
+		{"gzip;q=0", both, ""},
+		{"*", both, "br"},
+		{"*;q=0", both, ""},
+		{"*, br;q=0", both, "gzip"},
+		{"*, gzip;q=0", []string{"gzip"}, ""},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.header != "" {
+			h.Set("Accept-Encoding", tt.header)
+		}
+		if got := acceptEncoding(h, tt.offered); got != tt.want {
+			t.Errorf("acceptEncoding(%q, %v) = %q, want %q", tt.header, tt.offered, got, tt.want)
+		}
+	}
+}
+
+func TestCompressSmallChunked(t *testing.T) {
+	// Without a Content-Length the proxy flushes after every write
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"ok":true}`)
+		w.(http.Flusher).Flush()
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	proxy := httptest.NewServer(New(router.New([]compose.Route{{
+		Host: "app.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+		Compress: &compose.Compress{Encodings: []string{"gzip"}, MinSize: 1024},
+	}}), "http"))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/", nil)
+	req.Host = "app.com"
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if enc := res.Header.Get("Content-Encoding"); enc != "" || string(body) != `{"ok":true}` {
+		t.Errorf("got Content-Encoding %q, body %q; want the 11 bytes uncompressed", enc, body)
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight answer
+const corsMaxAge = "600"
+
+// applyCORS adds the route's CORS headers for requests from allowed
+// origins, and answers preflights itself. It reports whether the request
+// was answered.
+func applyCORS(w http.ResponseWriter, r *http.Request, cors *compose.CORS) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+	h := w.Header()
+	if !cors.AnyOrigin() || cors.Credentials {
+		h.Add("Vary", "Origin")
+	}
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if !cors.AllowsOrigin(origin) {
+		// Without CORS headers the browser keeps the response from the page
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	allowOrigin := origin
+	if cors.AnyOrigin() && !cors.Credentials {
+		allowOrigin = "*"
+	}
+	h.Set("Access-Control-Allow-Origin", allowOrigin)
+	if cors.Credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		return false
+	}
+
+	allowed := cors.AllowsMethod(r.Header.Get("Access-Control-Request-Method"))
+	requested := splitHeaderList(r.Header.Values("Access-Control-Request-Headers"))
+	for _, name := range requested {
+		allowed = allowed && cors.AllowsHeader(name)
+	}
+	if !allowed {
+		h.Del("Access-Control-Allow-Origin")
+		h.Del("Access-Control-Allow-Credentials")
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(cors.Methods, ", "))
+	if len(cors.Headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(cors.Headers, ", "))
+	} else if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	h.Set("Access-Control-Max-Age", corsMaxAge)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// splitHeaderList splits comma-separated header values into trimmed items
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for item := range strings.SplitSeq(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// dropBackendCORS removes CORS headers sent by the backend, so the route's
+// policy is the only one browsers see. Access-Control-Expose-Headers is
+// kept: only the backend knows which of its response headers pages read.
+func dropBackendCORS(header http.Header) {
+	for name := range header {
+		if strings.HasPrefix(name, "Access-Control-") && name != "Access-Control-Expose-Headers" {
+			header.Del(name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestCORS(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Access-Control-Allow-Origin", "https://stale.example.com") // replaced by the route's policy
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id")
+	}))
+	defer backend.Close()
+	port := backend.Listener.Addr().(*net.TCPAddr).Port
+
+	h := New(router.New([]compose.Route{
+		{
+			Host: "api.example.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: port,
+			CORS: &compose.CORS{
+				Origins: []string{"https://app.example.com"}, Methods: []string{"GET", "PUT"},
+				Headers: []string{"Authorization"}, Credentials: true,
+			},
+		},
+		{
+			Host: "public.example.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: port,
+			CORS: &compose.CORS{Origins: []string{"*"}, Methods: compose.DefaultCORSMethods},
+		},
+	}), "http")
+
+	tests := []struct {
+		name        string
+		method      string
+		host        string
+		headers     map[string]string
+		wantStatus  int
+		wantOrigin  string
+		wantHeaders string // Access-Control-Allow-Headers
+		wantBackend bool
+	}{
+		{
+			name: "preflight", method: "OPTIONS", host: "api.example.com",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "PUT", "Access-Control-Request-Headers": "authorization"},
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com", wantHeaders: "Authorization",
+		},
+		{
+			name: "preflight from other origin", method: "OPTIONS", host: "api.example.com",
+			headers:    map[string]string{"Origin": "https://evil.example.com", "Access-Control-Request-Method": "PUT"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "preflight for other method", method: "OPTIONS", host: "api.example.com",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "preflight for other header", method: "OPTIONS", host: "api.example.com",
+			headers:    map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Debug"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "preflight any origin", method: "OPTIONS", host: "public.example.com",
+			headers:    map[string]string{"Origin": "https://anyone.example.net", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Debug"},
+			wantStatus: http.StatusNoContent, wantOrigin: "*", wantHeaders: "X-Debug",
+		},
+		{
+			name: "request", method: "GET", host: "api.example.com",
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusOK, wantOrigin: "https://app.example.com", wantBackend: true,
+		},
+		{
+			name: "request from other origin", method: "GET", host: "api.example.com",
+			headers:    map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus: http.StatusOK, wantBackend: true,
+		},
+		{name: "same origin", method: "GET", host: "api.example.com", wantStatus: http.StatusOK, wantBackend: true},
+		{
+			name: "plain OPTIONS", method: "OPTIONS", host: "api.example.com",
+			headers:    map[string]string{"Origin": "https://app.example.com"},
+			wantStatus: http.StatusOK, wantOrigin: "https://app.example.com", wantBackend: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := calls.Load()
+			req := httptest.NewRequest(tt.method, "http://"+tt.host+"/v1/items", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Values("Access-Control-Allow-Origin"); len(got) > 1 || w.Header().Get("Access-Control-Allow-Origin") != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+				t.Errorf("Access-Control-Allow-Headers = %q, want %q", got, tt.wantHeaders)
+			}
+			if got := calls.Load() > before; got != tt.wantBackend {
+				t.Errorf("reached backend = %v, want %v", got, tt.wantBackend)
+			}
+			if got := w.Header().Get("Access-Control-Expose-Headers"); tt.wantBackend && got != "X-Request-Id" {
+				t.Errorf("Access-Control-Expose-Headers = %q, want the backend's", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// dnsRefreshInterval is how long resolved backend addresses are used before
+// they are looked up again
+const dnsRefreshInterval = 5 * time.Second
+
+// dnsLookupTimeout bounds each refresh
+const dnsLookupTimeout = 2 * time.Second
+
+// dnsPool resolves a route's backends to every address behind their names,
+// so each replica of a compose service becomes its own backend
+type dnsPool struct {
+	names    []string // configured backends (host:port)
+	lookup   func(ctx context.Context, host string) ([]string, error)
+	interval time.Duration        // how long addresses are used before they are looked up again
+	onChange func(addrs []string) // optional: called when the resolved addresses change
+
+	addrs      atomic.Pointer[[]string]
+	expires    atomic.Int64 // unix nanos
+	refreshing atomic.Bool
+	once       sync.Once
+
+	resolved map[string][]string // name → last good addresses, only touched by refresh
+}
+
+func newDNSPool(names []string, lookup func(ctx context.Context, host string) ([]string, error)) *dnsPool {
+	p := &dnsPool{names: names, lookup: lookup, interval: dnsRefreshInterval, resolved: make(map[string][]string)}
+	p.addrs.Store(&names)
+	return p
+}
+
+// Addrs returns the resolved backend addresses, refreshing them in the
+// background once they expire. The first call resolves synchronously.
+func (p *dnsPool) Addrs() []string {
+	p.once.Do(p.refresh)
+	if time.Now().UnixNano() > p.expires.Load() && p.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer p.refreshing.Store(false)
+			p.refresh()
+		}()
+	}
+	return *p.addrs.Load()
+}
+
+// refresh resolves every name, keeping the previous addresses for names
+// that fail to resolve. Calls never overlap.
+func (p *dnsPool) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	var addrs []string
+	for _, name := range p.names {
+		addrs = append(addrs, p.resolve(ctx, name)...)
+	}
+	slices.Sort(addrs)
+	addrs = slices.Compact(addrs)
+
+	if old := *p.addrs.Load(); !slices.Equal(addrs, old) {
+		slog.Info("backends resolved", "backends", p.names, "addrs", addrs)
+		p.addrs.Store(&addrs)
+		if p.onChange != nil {
+			p.onChange(addrs)
+		}
+	}
+	p.expires.Store(time.Now().Add(p.interval).UnixNano())
+}
+
+// resolve returns the addresses behind one host:port backend
+func (p *dnsPool) resolve(ctx context.Context, name string) []string {
+	host, port, err := net.SplitHostPort(name)
+	if err != nil || net.ParseIP(host) != nil {
+		return []string{name}
+	}
+
+	ips, err := p.lookup(ctx, host)
+	if err != nil || len(ips) == 0 {
+		if prev, ok := p.resolved[name]; ok {
+			slog.Warn("resolving backend failed, keeping previous addresses", "host", host, "err", err)
+			return prev
+		}
+		return []string{name}
+	}
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	p.resolved[name] = addrs
+	return addrs
+}
+
+// backendAddrs returns the addresses to balance across for the route
+func (h *Handler) backendAddrs(route *compose.Route) []string {
+	if route.Discovery != compose.DiscoveryDNS {
+		return route.Addrs()
+	}
+
+	h.mu.RLock()
+	p, ok := h.pools[route]
+	h.mu.RUnlock()
+	if !ok {
+		h.mu.Lock()
+		if p, ok = h.pools[route]; !ok {
+			p = newDNSPool(route.Addrs(), h.lookupHost)
+			if route.DNS != nil && route.DNS.TTL > 0 {
+				p.interval = route.DNS.TTL
+			}
+			// Health checks follow the replicas rather than the name
+			if h.health != nil && (route.HealthCheck != nil || route.PassiveCheck != nil) {
+				p.onChange = func(addrs []string) { h.health.SetAddrs(route, addrs) }
+			}
+			h.pools[route] = p
+		}
+		h.mu.Unlock()
+	}
+	return p.Addrs()
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/dlp"
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+var dlpMatches = metrics.Default.NewCounterVec(
+	"liteproxy_dlp_matches_total",
+	"Sensitive data found in responses, by service, pattern and action taken.",
+	"service", "pattern", "action")
+
+// dlpWriter holds back response bodies to scan them for sensitive data,
+// then sends them masked, replaces them with an error or lets them through.
+// Streams, encoded bodies and bodies over MaxSize pass through unscanned.
+type dlpWriter struct {
+	http.ResponseWriter
+	h       *Handler
+	r       *http.Request
+	cfg     *compose.DLP
+	service string
+
+	status   int    // status held back while scanning, 0 before WriteHeader
+	buf      []byte // body held back while scanning
+	scanning bool
+}
+
+func newDLPWriter(h *Handler, w http.ResponseWriter, r *http.Request, route *compose.Route) *dlpWriter {
+	return &dlpWriter{ResponseWriter: w, h: h, r: r, cfg: route.DLP, service: route.ServiceName}
+}
+
+func (w *dlpWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		// Informational responses and upgrades pass straight through
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	h := w.Header()
+	mt, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	size, _ := strconv.Atoi(h.Get("Content-Length"))
+	switch {
+	case w.r.Method == http.MethodHead || code == http.StatusNoContent || code == http.StatusNotModified:
+	case mt == "text/event-stream":
+	case h.Get("Content-Encoding") != "" && h.Get("Content-Encoding") != "identity":
+		// The backend ignored the request for an unencoded body
+		w.skip("it is encoded")
+	case size > w.cfg.MaxSize:
+		w.skip("it is larger than " + strconv.Itoa(w.cfg.MaxSize) + " bytes")
+	default:
+		w.scanning = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *dlpWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.scanning {
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.cfg.MaxSize {
+		// Too big to hold back; send what's held and stream the rest
+		w.skip("it is larger than " + strconv.Itoa(w.cfg.MaxSize) + " bytes")
+		w.scanning = false
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *dlpWriter) skip(reason string) {
+	slog.Warn("dlp: response not scanned", "method", w.r.Method, "host", w.r.Host, "path", w.r.URL.Path, "reason", reason)
+}
+
+// Flush only reaches the client once the body is no longer held back
+func (w *dlpWriter) Flush() {
+	if !w.scanning {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// close scans a held back body and sends the outcome
+func (w *dlpWriter) close() {
+	if !w.scanning {
+		return
+	}
+	w.scanning = false
+	body, findings := w.cfg.Set.Scan(w.buf)
+	blocked, masked := false, false
+	for _, f := range findings {
+		action := f.Pattern.Action
+		if w.cfg.Mode == compose.DLPModeLog {
+			action = dlp.ActionLog
+		}
+		blocked = blocked || action == dlp.ActionBlock
+		masked = masked || action == dlp.ActionMask
+		dlpMatches.Add(uint64(f.Count), w.service, f.Pattern.ID, action)
+		slog.Warn("dlp: matches in response", "action", action, "count", f.Count, "pattern", f.Pattern.ID,
+			"method", w.r.Method, "host", w.r.Host, "path", w.r.URL.Path, "service", w.service)
+	}
+
+	h := w.Header()
+	switch {
+	case blocked:
+		// None of the backend's headers belong on the error
+		clear(h)
+		w.h.writeError(w.ResponseWriter, w.r, http.StatusBadGateway, "response blocked")
+		return
+	case masked:
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		h.Del("ETag")
+	default:
+		body = w.buf
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// Unwrap lets http.ResponseController reach Hijack (WebSockets)
+func (w *dlpWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
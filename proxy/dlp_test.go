@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/dlp"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestDLP(t *testing.T) {
+	set, err := dlp.Load([]string{dlp.Builtin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotEncoding, gotRange string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding, gotRange = r.Header.Get("Accept-Encoding"), r.Header.Get("Range")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Set-Cookie", "session=abc")
+		switch r.URL.Path {
+		case "/clean":
+			io.WriteString(w, `{"ok":true}`)
+		case "/card":
+			io.WriteString(w, `{"card":"4111 1111 1111 1111","note":"`+strings.Repeat("x", 2000)+`"}`)
+		case "/trace":
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "panic: boom\n\ngoroutine 1 [running]:\nmain.main()")
+		case "/large":
+			io.WriteString(w, strings.Repeat("4111 1111 1111 1111 ", 300))
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, "4111 1111 1111 1111")
+		}
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	route := func(host, mode string) compose.Route {
+		return compose.Route{
+			Host: host, PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			DLP:      &compose.DLP{Mode: mode, MaxSize: 4000, Set: set},
+			Compress: &compose.Compress{Encodings: []string{"gzip"}, MinSize: compose.DefaultCompressMinSize},
+		}
+	}
+	h := New(router.New([]compose.Route{
+		route("app.com", compose.DLPModeEnforce),
+		route("log.com", compose.DLPModeLog),
+	}), "http")
+
+	tests := []struct {
+		name       string
+		url        string
+		wantCode   int
+		wantBody   string // substring
+		wantETag   string
+		wantCookie bool
+	}{
+		{"clean", "http://app.com/clean", 200, `{"ok":true}`, `"v1"`, true},
+		{"masked", "http://app.com/card", 200, `{"card":"[REDACTED]"`, "", true},
+		{"blocked", "http://app.com/trace", 502, "response blocked", "", false},
+		{"too large to scan", "http://app.com/large", 200, "4111 1111 1111 1111", `"v1"`, true},
+		{"encoded", "http://app.com/encoded", 200, "4111 1111 1111 1111", `"v1"`, true},
+		{"log mode", "http://log.com/trace", 500, "goroutine 1 [running]", `"v1"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			req.Header.Set("Range", "bytes=0-10")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			res := rec.Result()
+			body := res.Body
+			if res.Header.Get("Content-Encoding") == "gzip" {
+				if body, err = gzip.NewReader(res.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			b, _ := io.ReadAll(body)
+			if res.StatusCode != tt.wantCode || !strings.Contains(string(b), tt.wantBody) {
+				t.Errorf("got %d %q, want %d containing %q", res.StatusCode, b, tt.wantCode, tt.wantBody)
+			}
+			if etag := strings.TrimPrefix(res.Header.Get("ETag"), "W/"); etag != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", etag, tt.wantETag)
+			}
+			if cookie := res.Header.Get("Set-Cookie") != ""; cookie != tt.wantCookie {
+				t.Errorf("Set-Cookie sent: %v, want %v", cookie, tt.wantCookie)
+			}
+			if gotEncoding != "identity" || gotRange != "" {
+				t.Errorf("backend got Accept-Encoding %q and Range %q, want identity and none", gotEncoding, gotRange)
+			}
+		})
+	}
+	if n := dlpMatches.Value("127.0.0.1", "credit-card", dlp.ActionMask); n != 1 {
+		t.Errorf("masked card numbers counted: %d, want 1", n)
+	}
+	if n := dlpMatches.Value("127.0.0.1", "stack-trace-go", dlp.ActionLog); n != 1 {
+		t.Errorf("logged stack traces counted: %d, want 1", n)
+	}
+}
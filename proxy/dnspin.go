@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/errlog"
+	"golang.org/x/sync/singleflight"
+)
+
+// pinnedDialer dials backends by name for routes with liteproxy.dns
+// settings: answers are reused for the TTL, and with pinning the last good
+// answer is dialed while lookups fail, so a DNS outage doesn't take the
+// route down with it
+type pinnedDialer struct {
+	dns    compose.DNS
+	lookup func(ctx context.Context, host string) ([]string, error)
+	dialer *net.Dialer
+	calls  singleflight.Group // one lookup per host at a time
+
+	mu    sync.Mutex
+	hosts map[string]pinnedHost
+}
+
+// pinnedHost is the last good answer for a host
+type pinnedHost struct {
+	ips     []string
+	expires time.Time
+}
+
+func newPinnedDialer(dns compose.DNS, lookup func(ctx context.Context, host string) ([]string, error)) *pinnedDialer {
+	return &pinnedDialer{
+		dns:    dns,
+		lookup: lookup,
+		dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		hosts:  make(map[string]pinnedHost),
+	}
+}
+
+func (d *pinnedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ip := range ips {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// resolve returns the addresses for host, looking it up once the last
+// answer is older than the TTL. Dials waiting on the same host share a lookup.
+func (d *pinnedDialer) resolve(ctx context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	last, ok := d.hosts[host]
+	d.mu.Unlock()
+	if ok && time.Now().Before(last.expires) {
+		return last.ips, nil
+	}
+
+	v, err, _ := d.calls.Do(host, func() (any, error) {
+		// Shared by every waiting dial, so one client going away doesn't fail the rest
+		ips, err := d.lookup(context.WithoutCancel(ctx), host)
+		if err == nil && len(ips) == 0 {
+			err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		d.hosts[host] = pinnedHost{ips: ips, expires: time.Now().Add(d.dns.TTL)}
+		d.mu.Unlock()
+		return ips, nil
+	})
+	if err == nil {
+		return v.([]string), nil
+	}
+	if ok && d.dns.Pin {
+		errlog.Printf("dns", host, "resolving backend %s: %v (dialing pinned %v)", host, err, last.ips)
+		return last.ips, nil
+	}
+	return nil, err
+}
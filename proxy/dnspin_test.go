@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestPinnedDNS(t *testing.T) {
+	// Connection: close makes every request dial, and so resolve, again
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+	}))
+	defer backend.Close()
+	_, p, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	port, _ := net.LookupPort("tcp", p)
+
+	tests := []struct {
+		name    string
+		dns     compose.DNS
+		lookups int32 // after three requests
+		want    int   // status once DNS is down
+	}{
+		{"pin", compose.DNS{Pin: true}, 3, http.StatusOK},
+		{"no pin", compose.DNS{TTL: time.Nanosecond}, 3, http.StatusBadGateway},
+		{"ttl", compose.DNS{TTL: time.Hour}, 1, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(router.New([]compose.Route{{
+				Host: "example.com", PathPrefix: "/", ServiceName: "flaky.home.arpa", ServicePort: port, DNS: &tt.dns,
+			}}), "http")
+			var lookups atomic.Int32
+			h.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+				if lookups.Add(1) > 1 {
+					return nil, errors.New("server misbehaving")
+				}
+				return []string{"127.0.0.1"}, nil
+			}
+
+			for i, want := range []int{http.StatusOK, tt.want, tt.want} {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+				if w.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, w.Code, want)
+				}
+			}
+			if got := lookups.Load(); got != tt.lookups {
+				t.Errorf("lookups = %d, want %d", got, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestPinnedDNSSharedLookup(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var lookups atomic.Int32
+	d := newPinnedDialer(compose.DNS{Pin: true}, func(ctx context.Context, host string) ([]string, error) {
+		if lookups.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return []string{"127.0.0.1"}, nil
+	})
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ips, err := d.resolve(context.Background(), "flaky.home.arpa"); err != nil || len(ips) != 1 {
+				t.Errorf("resolve() = %v, %v", ips, err)
+			}
+		}()
+	}
+	<-started
+	time.Sleep(50 * time.Millisecond) // let the other dials line up behind the lookup
+	close(release)
+	wg.Wait()
+	if got := lookups.Load(); got != 1 {
+		t.Errorf("lookups = %d, want 1", got)
+	}
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrorPages holds HTML templates for the errors the proxy itself sends,
+// such as 404 for unknown hosts and 502 when a backend is down
+type ErrorPages struct {
+	pages map[string]map[string]*template.Template // host ("" for any) -> "404" or "error" -> template
+}
+
+// errorPageData is what error page templates can use
+type errorPageData struct {
+	Status     int    // e.g. 502
+	StatusText string // e.g. Bad Gateway
+	Message    string // the proxy's short explanation, e.g. "no healthy backend"
+	Host       string
+	Path       string
+}
+
+// LoadErrorPages reads error page templates from dir: <status>.html for
+// one status, error.html for any other, and the same files in a
+// subdirectory named after a host (or *.domain) to override them for it
+func LoadErrorPages(dir string) (*ErrorPages, error) {
+	p := &ErrorPages{pages: make(map[string]map[string]*template.Template)}
+	if err := p.loadDir(dir, ""); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			if err := p.loadDir(filepath.Join(dir, e.Name()), strings.ToLower(e.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+func (p *ErrorPages) loadDir(dir, host string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading error pages: %w", err)
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".html")
+		if e.IsDir() || !ok {
+			continue
+		}
+		if code, err := strconv.Atoi(name); name != "error" && (err != nil || code < 400 || code > 599) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		tmpl, err := template.ParseFiles(path)
+		if err != nil {
+			return fmt.Errorf("error page %s: %w", path, err)
+		}
+		if p.pages[host] == nil {
+			p.pages[host] = make(map[string]*template.Template)
+		}
+		p.pages[host][name] = tmpl
+	}
+	return nil
+}
+
+// lookup returns the most specific template for host and status
+func (p *ErrorPages) lookup(host string, status int) *template.Template {
+	hosts := []string{host}
+	if _, parent, ok := strings.Cut(host, "."); ok {
+		hosts = append(hosts, "*."+parent)
+	}
+	hosts = append(hosts, "")
+	for _, h := range hosts {
+		if t := p.pages[h][strconv.Itoa(status)]; t != nil {
+			return t
+		}
+		if t := p.pages[h]["error"]; t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// errorHostKey is the context key for the host a client asked for
+type errorHostKey struct{}
+
+// withErrorHost returns r with its host attached, for writeError calls
+// made with the outgoing request
+func withErrorHost(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), errorHostKey{}, r.Host))
+}
+
+// SetErrorPages replaces the error page templates; nil sends plain text errors
+// Safe to call while serving requests
+func (h *Handler) SetErrorPages(p *ErrorPages) {
+	h.errorPages.Store(p)
+}
+
+// writeError sends an error generated by the proxy, as an HTML page when
+// the operator provides one and the client is a browser, else as plain text
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if p := h.errorPages.Load(); p != nil && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		host := r.Host
+		if v, ok := r.Context().Value(errorHostKey{}).(string); ok {
+			host = v
+		}
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		host = strings.ToLower(host)
+		if tmpl := p.lookup(host, status); tmpl != nil {
+			var buf bytes.Buffer
+			err := tmpl.Execute(&buf, errorPageData{
+				Status:     status,
+				StatusText: http.StatusText(status),
+				Message:    message,
+				Host:       host,
+				Path:       r.URL.Path,
+			})
+			if err == nil {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Header().Set("X-Content-Type-Options", "nosniff")
+				w.WriteHeader(status)
+				w.Write(buf.Bytes())
+				return
+			}
+			slog.Warn("error page failed", "host", host, "status", status, "err", err)
+		}
+	}
+	http.Error(w, message, status)
+}
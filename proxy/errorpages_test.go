@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"404.html":                    `<h1>{{.Status}} nothing at {{.Host}}{{.Path}}</h1>`,
+		"error.html":                  `<h1>{{.StatusText}}: {{.Message}}</h1>`,
+		"notes.txt":                   `ignored`,
+		"shop.example.com/502.html":   `<h1>The shop is down</h1>`,
+		"*.tenant.example/error.html": `<h1>Tenant error {{.Status}}</h1>`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages, err := LoadErrorPages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down := compose.Route{PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: 1} // nothing listens on port 1
+	shop, api, tenant := down, down, down
+	shop.Host, api.Host, tenant.Host = "shop.example.com", "api.example.com", "*.tenant.example"
+	h := New(router.New([]compose.Route{shop, api, tenant}), "http")
+	h.SetErrorPages(pages)
+
+	tests := []struct {
+		name     string
+		url      string
+		accept   string
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{"unknown host", "http://nowhere.com/a%3Cb", "text/html,*/*", 404, "text/html", "<h1>404 nothing at nowhere.com/a&lt;b</h1>"},
+		{"unknown host for an API client", "http://nowhere.com/", "application/json", 404, "text/plain", "no route found\n"},
+		{"host override", "http://shop.example.com/", "text/html", 502, "text/html", "<h1>The shop is down</h1>"},
+		{"fallback page", "http://api.example.com/", "text/html", 502, "text/html", "<h1>Bad Gateway: Bad Gateway</h1>"},
+		{"wildcard host override", "http://acme.tenant.example/", "text/html", 502, "text/html", "<h1>Tenant error 502</h1>"},
+		{"no upstream error leaked", "http://api.example.com/", "", 502, "text/plain", "Bad Gateway\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode || !strings.HasPrefix(rec.Header().Get("Content-Type"), tt.wantType) || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %s %q, want %d %s %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String(),
+					tt.wantCode, tt.wantType, tt.wantBody)
+			}
+		})
+	}
+
+	// Without pages, errors stay plain text
+	h.SetErrorPages(nil)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://nowhere.com/", nil)
+	req.Header.Set("Accept", "text/html")
+	h.ServeHTTP(rec, req)
+	if rec.Body.String() != "no route found\n" {
+		t.Errorf("without pages: %q", rec.Body.String())
+	}
+}
+
+func TestLoadErrorPagesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "502.html"), []byte(`{{.Status`), 0o644)
+	if _, err := LoadErrorPages(dir); err == nil {
+		t.Error("broken template: expected error")
+	}
+	if _, err := LoadErrorPages(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing directory: expected error")
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ExternalHost returns host as clients must address it on scheme when the
+// proxy is reached on port, e.g. behind NAT mapping 443 to 8443. Any port
+// already in host is replaced; the scheme's default port is left out.
+// Port 0 returns host unchanged.
+func ExternalHost(host, scheme string, port int) string {
+	if port == 0 {
+		return host
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if (scheme == "https" && port == 443) || (scheme == "http" && port == 80) {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestExternalHost(t *testing.T) {
+	tests := []struct {
+		host, scheme string
+		port         int
+		want         string
+	}{
+		{"example.com:8080", "https", 0, "example.com:8080"}, // unset keeps the host as requested
+		{"example.com:8080", "https", 443, "example.com"},
+		{"example.com:8080", "https", 8443, "example.com:8443"},
+		{"example.com", "https", 8443, "example.com:8443"},
+		{"example.com:8443", "http", 80, "example.com"},
+		{"example.com", "http", 443, "example.com:443"},
+		{"[2001:db8::1]:8080", "https", 443, "[2001:db8::1]"},
+		{"[2001:db8::1]", "https", 8443, "[2001:db8::1]:8443"},
+	}
+	for _, tt := range tests {
+		if got := ExternalHost(tt.host, tt.scheme, tt.port); got != tt.want {
+			t.Errorf("ExternalHost(%q, %q, %d) = %q, want %q", tt.host, tt.scheme, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectExternalPort(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80, RedirectFrom: []string{"old.example.com"}},
+	}
+	h := New(router.New(routes), "https")
+	h.SetRedirectPort(8443)
+
+	req := httptest.NewRequest("GET", "https://old.example.com:8443/page?x=1", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://example.com:8443/page?x=1" {
+		t.Errorf("Location = %q", loc)
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+var faultsInjected = metrics.Default.NewCounterVec(
+	"liteproxy_faults_injected_total",
+	"Requests delayed or aborted by fault injection, by service and type.",
+	"service", "type")
+
+// Fault sources reported by Faults
+const (
+	FaultFromLabels = "labels" // the route's liteproxy.fault.* labels
+	FaultFromAdmin  = "admin"  // set through the admin API, overriding the labels
+)
+
+// FaultStatus is the fault in effect on a route
+type FaultStatus struct {
+	Route  string         `json:"route"`           // host and path
+	Fault  *compose.Fault `json:"fault,omitempty"` // nil when the admin API turned the labels' fault off
+	Source string         `json:"source"`
+}
+
+// SetFault overrides the fault of the route with key (host and path) until
+// ClearFault, across reloads. A nil fault turns injection off.
+func (h *Handler) SetFault(key string, f *compose.Fault) {
+	h.faultsMu.Lock()
+	defer h.faultsMu.Unlock()
+	h.faults[key] = f
+}
+
+// ClearFault drops the override of SetFault, so the route's labels apply
+func (h *Handler) ClearFault(key string) {
+	h.faultsMu.Lock()
+	defer h.faultsMu.Unlock()
+	delete(h.faults, key)
+}
+
+// Faults returns the faults in effect on routes, sorted by route
+func (h *Handler) Faults(routes []compose.Route) []FaultStatus {
+	h.faultsMu.RLock()
+	defer h.faultsMu.RUnlock()
+	var list []FaultStatus
+	for i := range routes {
+		key := routes[i].Key()
+		if f, ok := h.faults[key]; ok {
+			list = append(list, FaultStatus{Route: key, Fault: f, Source: FaultFromAdmin})
+		} else if routes[i].Fault != nil {
+			list = append(list, FaultStatus{Route: key, Fault: routes[i].Fault, Source: FaultFromLabels})
+		}
+	}
+	slices.SortFunc(list, func(a, b FaultStatus) int { return strings.Compare(a.Route, b.Route) })
+	return list
+}
+
+func (h *Handler) faultFor(route *compose.Route) *compose.Fault {
+	h.faultsMu.RLock()
+	defer h.faultsMu.RUnlock()
+	if f, ok := h.faults[route.Key()]; ok {
+		return f
+	}
+	return route.Fault
+}
+
+// injectFault delays the request and aborts its share of requests, as the
+// route's fault says. It reports whether the request is finished: aborted,
+// or abandoned by the client during the delay.
+func (h *Handler) injectFault(w http.ResponseWriter, r *http.Request, route *compose.Route) bool {
+	f := h.faultFor(route)
+	if f == nil {
+		return false
+	}
+	if f.Delay > 0 {
+		faultsInjected.Inc(route.ServiceName, "delay")
+		t := time.NewTimer(f.Delay)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return true
+		}
+	}
+	if f.AbortPercent > 0 && rand.Float64()*100 < f.AbortPercent {
+		faultsInjected.Inc(route.ServiceName, "abort")
+		h.writeError(w, r, f.AbortStatus, "fault injected")
+		return true
+	}
+	return false
+}
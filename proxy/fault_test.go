@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestFaultInjection(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	routes := []compose.Route{
+		{Host: "slow.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port, Fault: &compose.Fault{Delay: 100 * time.Millisecond}},
+		{Host: "broken.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port, Fault: &compose.Fault{AbortPercent: 100, AbortStatus: 429}},
+		{Host: "fine.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port},
+	}
+	h := New(router.New(routes), "http")
+
+	get := func(host string) (int, time.Duration) {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://"+host+"/", nil))
+		return w.Code, time.Since(start)
+	}
+
+	if code, elapsed := get("slow.test"); code != http.StatusOK || elapsed < 100*time.Millisecond {
+		t.Errorf("delayed route = %d after %v, want 200 after at least 100ms", code, elapsed)
+	}
+	if code, _ := get("broken.test"); code != 429 {
+		t.Errorf("aborting route = %d, want 429", code)
+	}
+	if code, _ := get("fine.test"); code != http.StatusOK {
+		t.Errorf("route without a fault = %d, want 200", code)
+	}
+
+	// Admin overrides win over labels until cleared, and survive reloads
+	h.SetFault("fine.test/", &compose.Fault{AbortPercent: 100, AbortStatus: 503})
+	h.SetFault("broken.test/", nil)
+	h.UpdateRouter(router.New(routes))
+	if code, _ := get("fine.test"); code != http.StatusServiceUnavailable {
+		t.Errorf("overridden route = %d, want 503", code)
+	}
+	if code, _ := get("broken.test"); code != http.StatusOK {
+		t.Errorf("route with its fault turned off = %d, want 200", code)
+	}
+
+	faults := h.Faults(routes)
+	want := []FaultStatus{
+		{Route: "broken.test/", Source: FaultFromAdmin},
+		{Route: "fine.test/", Fault: &compose.Fault{AbortPercent: 100, AbortStatus: 503}, Source: FaultFromAdmin},
+		{Route: "slow.test/", Fault: routes[0].Fault, Source: FaultFromLabels},
+	}
+	if len(faults) != len(want) {
+		t.Fatalf("Faults() = %+v, want %+v", faults, want)
+	}
+	for i := range want {
+		got := faults[i]
+		if got.Route != want[i].Route || got.Source != want[i].Source || (got.Fault == nil) != (want[i].Fault == nil) ||
+			(got.Fault != nil && *got.Fault != *want[i].Fault) {
+			t.Errorf("Faults()[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	h.ClearFault("broken.test/")
+	if code, _ := get("broken.test"); code != 429 {
+		t.Errorf("route after clearing its override = %d, want 429", code)
+	}
+}
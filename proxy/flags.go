@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/flags"
+)
+
+// SetFlags sets where routes with liteproxy.flags get their feature flags;
+// without a source every flag is off
+// Must be called before serving requests
+func (h *Handler) SetFlags(s *flags.Source) {
+	h.flags = s
+}
+
+// setFlagHeaders replaces any X-Flag-* headers the client sent with the
+// route's flags as evaluated for r
+func (h *Handler) setFlagHeaders(r *http.Request, route *compose.Route) {
+	for name := range r.Header {
+		if strings.HasPrefix(name, flags.HeaderPrefix) {
+			delete(r.Header, name)
+		}
+	}
+	set := &flags.Set{}
+	if h.flags != nil {
+		set = h.flags.Flags()
+	}
+	names := route.Flags
+	if slices.Contains(names, "*") {
+		names = set.Names()
+	}
+	for _, name := range names {
+		r.Header.Set(flags.HeaderPrefix+name, strconv.FormatBool(set.Enabled(name, r)))
+	}
+}
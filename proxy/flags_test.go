@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/flags"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestFlagHeaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.yaml")
+	os.WriteFile(path, []byte(`
+flags:
+  new-checkout:
+    rules:
+      - attribute: header:X-Plan
+        values: [enterprise]
+  dark-mode:
+    default: true
+`), 0o644)
+	src, err := flags.NewSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	route := func(host string, names ...string) compose.Route {
+		return compose.Route{Host: host, PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum, Flags: names}
+	}
+	h := New(router.New([]compose.Route{
+		route("one.com", "new-checkout", "retired"),
+		route("all.com", "*"),
+		route("none.com"),
+	}), "http")
+	h.SetFlags(src)
+
+	tests := []struct {
+		name string
+		host string
+		plan string
+		want map[string]string // header -> value, "" for absent
+	}{
+		{"listed flags", "one.com", "enterprise", map[string]string{"X-Flag-New-Checkout": "true", "X-Flag-Retired": "false", "X-Flag-Dark-Mode": ""}},
+		{"rule not matched", "one.com", "free", map[string]string{"X-Flag-New-Checkout": "false"}},
+		{"all flags", "all.com", "", map[string]string{"X-Flag-New-Checkout": "false", "X-Flag-Dark-Mode": "true", "X-Flag-Retired": ""}},
+		{"no flags", "none.com", "enterprise", map[string]string{"X-Flag-New-Checkout": "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://"+tt.host+"/", nil)
+			req.Header.Set("X-Plan", tt.plan)
+			// Clients can't set flags themselves on flag routes
+			req.Header.Set("X-Flag-New-Checkout", "true")
+			req.Header.Set("X-Flag-Retired", "true")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			for name, want := range tt.want {
+				if got.Get(name) != want {
+					t.Errorf("%s = %q, want %q", name, got.Get(name), want)
+				}
+			}
+		})
+	}
+}
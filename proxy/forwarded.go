@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net"
+	"net/http/httputil"
+	"net/netip"
+	"strconv"
+
+	"github.com/localrivet/liteproxy/accesslog"
+)
+
+// Headers telling backends what the client's TLS handshake negotiated,
+// sent when enabled with SetTLSHeaders
+const (
+	TLSVersionHeader = "X-TLS-Version"
+	TLSCipherHeader  = "X-TLS-Cipher"
+	TLSALPNHeader    = "X-TLS-ALPN"
+)
+
+// SetTLSHeaders sets whether backends get the client's TLS version, cipher
+// suite and ALPN protocol in X-TLS-* headers
+// Must be called before serving requests
+func (h *Handler) SetTLSHeaders(enabled bool) {
+	h.tlsHeaders = enabled
+}
+
+// SetTrustedProxies sets the peers, such as a load balancer in front of
+// liteproxy, whose X-Forwarded-* headers are passed on to backends in
+// place of the proxy's own view of the connection
+// Must be called before serving requests
+func (h *Handler) SetTrustedProxies(trusted []netip.Prefix) {
+	h.trustedProxies = trusted
+}
+
+// trusts reports whether the peer at remoteAddr is a trusted proxy
+func (h *Handler) trusts(remoteAddr string) bool {
+	if len(h.trustedProxies) == 0 {
+		return false
+	}
+	ap, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	for _, p := range h.trustedProxies {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// setForwarded sets X-Forwarded-For, -Host, -Proto and -Port on the
+// outgoing request. The scheme and port are the ones the client used: TLS
+// on the connection means https, and the port comes from the Host header
+// or the scheme's default, so they stay right behind NAT. A trusted proxy
+// in front of liteproxy knows better, so its headers are kept.
+func (h *Handler) setForwarded(pr *httputil.ProxyRequest) {
+	in := pr.In.Header
+	trusted := h.trusts(pr.In.RemoteAddr)
+	if trusted {
+		// SetXForwarded appends the peer to the chain
+		if prior, ok := in["X-Forwarded-For"]; ok {
+			pr.Out.Header["X-Forwarded-For"] = append([]string(nil), prior...)
+		}
+	}
+	pr.SetXForwarded()
+
+	host, proto := pr.In.Host, "http"
+	if pr.In.TLS != nil {
+		proto = "https"
+	}
+	port := ""
+	if trusted {
+		if v := in.Get("X-Forwarded-Proto"); v == "http" || v == "https" {
+			proto = v
+		}
+		if v := in.Get("X-Forwarded-Host"); v != "" {
+			host = v
+		}
+		if v := in.Get("X-Forwarded-Port"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 65535 {
+				port = v
+			}
+		}
+	}
+	if port == "" {
+		if _, p, err := net.SplitHostPort(host); err == nil {
+			port = p
+		} else if proto == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	pr.Out.Header.Set("X-Forwarded-Host", host)
+	pr.Out.Header.Set("X-Forwarded-Proto", proto)
+	pr.Out.Header.Set("X-Forwarded-Port", port)
+	if h.tlsHeaders {
+		setTLSHeaders(pr)
+	}
+}
+
+// setTLSHeaders replaces X-TLS-* headers with the client connection's,
+// leaving them out for plain HTTP
+func setTLSHeaders(pr *httputil.ProxyRequest) {
+	out := pr.Out.Header
+	for _, name := range []string{TLSVersionHeader, TLSCipherHeader, TLSALPNHeader} {
+		out.Del(name)
+	}
+	t := accesslog.NewTLS(pr.In.TLS)
+	if t == nil {
+		return
+	}
+	out.Set(TLSVersionHeader, t.Version)
+	out.Set(TLSCipherHeader, t.Cipher)
+	if t.ALPN != "" {
+		out.Set(TLSALPNHeader, t.ALPN)
+	}
+}
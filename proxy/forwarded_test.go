@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	h := New(router.New([]compose.Route{{Host: "app.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port}}), "https")
+	h.SetTrustedProxies([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+
+	lb := http.Header{
+		"X-Forwarded-For":   {"203.0.113.9"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"app.test"},
+		"X-Forwarded-Port":  {"8443"},
+	}
+	tests := []struct {
+		name      string
+		target    string
+		tls       bool
+		remote    string
+		header    http.Header
+		wantFor   string
+		wantProto string
+		wantHost  string
+		wantPort  string
+	}{
+		{name: "plain http", target: "http://app.test/", remote: "198.51.100.1:1234", wantFor: "198.51.100.1", wantProto: "http", wantHost: "app.test", wantPort: "80"},
+		{name: "tls", target: "https://app.test/", tls: true, remote: "198.51.100.1:1234", wantFor: "198.51.100.1", wantProto: "https", wantHost: "app.test", wantPort: "443"},
+		{name: "port in host", target: "https://app.test:8443/", tls: true, remote: "198.51.100.1:1234", wantFor: "198.51.100.1", wantProto: "https", wantHost: "app.test:8443", wantPort: "8443"},
+		{name: "untrusted peer", target: "http://app.test/", remote: "198.51.100.1:1234", header: lb, wantFor: "198.51.100.1", wantProto: "http", wantHost: "app.test", wantPort: "80"},
+		{name: "trusted proxy", target: "http://app.test/", remote: "10.1.2.3:1234", header: lb, wantFor: "203.0.113.9, 10.1.2.3", wantProto: "https", wantHost: "app.test", wantPort: "8443"},
+		{
+			name: "trusted proxy without port", target: "http://app.test/", remote: "10.1.2.3:1234",
+			header:  http.Header{"X-Forwarded-Proto": {"https"}},
+			wantFor: "10.1.2.3", wantProto: "https", wantHost: "app.test", wantPort: "443",
+		},
+		{
+			name: "trusted proxy with bad values", target: "http://app.test/", remote: "10.1.2.3:1234",
+			header:  http.Header{"X-Forwarded-Proto": {"gopher"}, "X-Forwarded-Port": {"99999"}},
+			wantFor: "10.1.2.3", wantProto: "http", wantHost: "app.test", wantPort: "80",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.RemoteAddr = tt.remote
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{ServerName: "app.test"}
+			}
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			for name, want := range map[string]string{
+				"X-Forwarded-For":   tt.wantFor,
+				"X-Forwarded-Proto": tt.wantProto,
+				"X-Forwarded-Host":  tt.wantHost,
+				"X-Forwarded-Port":  tt.wantPort,
+			} {
+				if v := got.Get(name); v != want {
+					t.Errorf("%s = %q, want %q", name, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	h := New(router.New([]compose.Route{{Host: "app.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port}}), "https")
+	h.SetTLSHeaders(true)
+
+	req := httptest.NewRequest("GET", "https://app.test/", nil)
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, NegotiatedProtocol: "h2"}
+	req.Header.Set(TLSVersionHeader, "SSL 3.0") // spoofed
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	for name, want := range map[string]string{TLSVersionHeader: "TLS 1.3", TLSCipherHeader: "TLS_AES_128_GCM_SHA256", TLSALPNHeader: "h2"} {
+		if v := got.Get(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "http://app.test/", nil)
+	req.Header.Set(TLSVersionHeader, "TLS 1.3")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if v := got.Get(TLSVersionHeader); v != "" {
+		t.Errorf("plain HTTP request reached the backend with %s %q", TLSVersionHeader, v)
+	}
+}
@@ -1,19 +1,31 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/localrivet/liteproxy/accesslog"
+	"github.com/localrivet/liteproxy/balancer"
 	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/errlog"
+	"github.com/localrivet/liteproxy/flags"
+	"github.com/localrivet/liteproxy/health"
+	"github.com/localrivet/liteproxy/metrics"
 	"github.com/localrivet/liteproxy/router"
+	"github.com/localrivet/liteproxy/spiffe"
 )
 
 // bufferPool implements httputil.BufferPool for efficient memory reuse
@@ -23,16 +35,27 @@ type bufferPool struct {
 
 const bufferSize = 32 * 1024 // 32KB, same as Traefik
 
-func newBufferPool() *bufferPool {
+func newBufferPool(size int) *bufferPool {
 	return &bufferPool{
 		pool: sync.Pool{
 			New: func() any {
-				return make([]byte, bufferSize)
+				return make([]byte, size)
 			},
 		},
 	}
 }
 
+// sizedBufferPools holds pools for routes with a custom copy buffer size
+var sizedBufferPools sync.Map // int → *bufferPool
+
+func bufferPoolFor(size int) *bufferPool {
+	if p, ok := sizedBufferPools.Load(size); ok {
+		return p.(*bufferPool)
+	}
+	p, _ := sizedBufferPools.LoadOrStore(size, newBufferPool(size))
+	return p.(*bufferPool)
+}
+
 func (b *bufferPool) Get() []byte {
 	return b.pool.Get().([]byte)
 }
@@ -43,8 +66,8 @@ func (b *bufferPool) Put(buf []byte) {
 
 // Shared resources for all proxies
 var (
-	sharedBufferPool = newBufferPool()
-	sharedTransport = &http.Transport{
+	sharedBufferPool = newBufferPool(bufferSize)
+	sharedTransport  = &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
@@ -61,35 +84,154 @@ var (
 
 // Handler serves as the main HTTP handler for proxying requests
 type Handler struct {
-	router atomic.Pointer[router.Router] // lock-free router access
-	scheme string                        // http or https for redirects
+	router  atomic.Pointer[router.Router] // lock-free router access
+	scheme  string                        // http or https for redirects
+	port    int                           // optional: port clients reach scheme on, for redirects
+	health  *health.Checker               // optional: skip backends failing health checks
+	metrics *metrics.HostLabeler          // optional: record per-route metrics
+
+	accessLog         *accesslog.Logger // optional: write access log entries
+	debugHeaders      bool              // optional: add X-Liteproxy-Upstream to responses
+	tlsHeaders        bool              // optional: add X-TLS-* to requests to backends
+	clientConcurrency int               // optional: default in-flight requests per client IP
+	trustedProxies    []netip.Prefix    // optional: peers whose X-Forwarded-* headers are kept
+	spiffe            *spiffe.Source    // optional: workload identity for spiffe_id routes
+	flags             *flags.Source     // optional: feature flags for liteproxy.flags routes
+	startCommands     bool              // optional: run liteproxy.scaled_to_zero.start commands
+
+	errorPages atomic.Pointer[ErrorPages] // optional: HTML pages for errors the proxy sends
+
+	h2 *h2Fallback // backends pinned to HTTP/1.1 after HTTP/2 errors
+
+	lookupHost func(ctx context.Context, host string) ([]string, error) // resolves backends for DNS discovery
+
+	mu        sync.RWMutex
+	proxies   map[proxyKey]*httputil.ReverseProxy // cache of proxies by service:port and route options
+	balancers map[*compose.Route]*balancerEntry   // cache of balancers for multi-backend routes
+	pools     map[*compose.Route]*dnsPool         // resolved backends for DNS discovery routes
+	limiters  map[*compose.Route]*clientLimiter   // per-client concurrency caps
+	resolvers map[*compose.Route]*tenantResolver  // HTTP tenant resolvers and their cached answers
+	zeros     map[*compose.Route]*zeroWatch       // scaled to zero state of liteproxy.scaled_to_zero routes
+
+	transports map[transportKey]http.RoundTripper // transports for dialers and upstream TLS
+
+	streamsMu sync.Mutex
+	streams   map[string]int // open WebSockets and event streams by route, kept across reloads
+
+	faultsMu sync.RWMutex
+	faults   map[string]*compose.Fault // admin API fault overrides by route, kept across reloads
+}
 
-	mu      sync.RWMutex
-	proxies map[string]*httputil.ReverseProxy // cache of proxies by service:port
+// balancerEntry is a cached balancer and the addresses it was built for
+type balancerEntry struct {
+	addrs []string
+	b     balancer.Balancer
 }
 
 // New creates a new proxy Handler
 func New(r *router.Router, scheme string) *Handler {
 	h := &Handler{
-		scheme:  scheme,
-		proxies: make(map[string]*httputil.ReverseProxy),
+		scheme:     scheme,
+		h2:         newH2Fallback(),
+		lookupHost: net.DefaultResolver.LookupHost,
+		proxies:    make(map[proxyKey]*httputil.ReverseProxy),
+		balancers:  make(map[*compose.Route]*balancerEntry),
+		pools:      make(map[*compose.Route]*dnsPool),
+		limiters:   make(map[*compose.Route]*clientLimiter),
+		resolvers:  make(map[*compose.Route]*tenantResolver),
+		zeros:      make(map[*compose.Route]*zeroWatch),
+		transports: make(map[transportKey]http.RoundTripper),
+		streams:    make(map[string]int),
+		faults:     make(map[string]*compose.Fault),
 	}
 	h.router.Store(r)
 	return h
 }
 
+// SetHealthChecker makes the handler skip backends that fail health checks
+// Must be called before serving requests
+func (h *Handler) SetHealthChecker(c *health.Checker) {
+	h.health = c
+}
+
+// SetRedirectPort sets the port redirect Locations send clients to, for
+// proxies reached on another port than they listen on
+// Must be called before serving requests
+func (h *Handler) SetRedirectPort(port int) {
+	h.port = port
+}
+
+// SetAccessLog enables access logging to l
+// Must be called before serving requests
+func (h *Handler) SetAccessLog(l *accesslog.Logger) {
+	h.accessLog = l
+}
+
 // UpdateRouter updates the router (called on config reload)
 func (h *Handler) UpdateRouter(r *router.Router) {
 	h.router.Store(r) // atomic, lock-free
+	routerReloads.Inc()
 
-	// Clear proxy cache under lock
+	// Clear proxy, balancer, DNS, limiter and tenant caches under lock
 	h.mu.Lock()
-	h.proxies = make(map[string]*httputil.ReverseProxy)
+	h.proxies = make(map[proxyKey]*httputil.ReverseProxy)
+	h.balancers = make(map[*compose.Route]*balancerEntry)
+	h.pools = make(map[*compose.Route]*dnsPool)
+	h.limiters = make(map[*compose.Route]*clientLimiter)
+	h.resolvers = make(map[*compose.Route]*tenantResolver)
+	h.zeros = make(map[*compose.Route]*zeroWatch)
 	h.mu.Unlock()
 }
 
 // ServeHTTP handles incoming requests
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Fast path: nothing to record
+	logging := h.accessLog != nil && h.accessLog.Enabled()
+	if h.metrics == nil && !logging {
+		// The matched route still labels recovered panics
+		h.serveRecovered(w, r, &requestInfo{})
+		return
+	}
+
+	start := time.Now()
+	reqURL := *r.URL // the proxied URL may have its prefix stripped
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	var info requestInfo
+
+	var attempts *attemptLog
+	if logging {
+		r, attempts = withAttemptLog(r)
+	}
+
+	h.serveRecovered(rec, r, &info)
+
+	if h.metrics != nil && info.route != nil {
+		h.observe(r.Host, info.route, rec.status, start)
+	}
+	if logging {
+		entry := accesslog.Entry{
+			Time:     start,
+			Status:   rec.status,
+			Bytes:    rec.bytes,
+			Duration: float64(time.Since(start).Microseconds()) / 1000,
+			Backend:  info.backend,
+			Attempts: attempts.list(),
+		}
+		if info.route != nil {
+			entry.Route = info.route.Host + info.route.Path()
+		}
+		h.accessLog.Log(r, &reqURL, entry)
+	}
+}
+
+// requestInfo collects what serve decided, for metrics and access logs
+type requestInfo struct {
+	route   *compose.Route
+	backend string
+}
+
+// serve routes and proxies a request, filling info if non-nil
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, info *requestInfo) {
 	host := r.Host
 	path := r.URL.Path
 
@@ -98,7 +240,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Check for redirect first
 	if target := rtr.Redirect(host); target != nil {
-		redirectURL := fmt.Sprintf("%s://%s%s", h.scheme, target.Host, path)
+		redirectURL := fmt.Sprintf("%s://%s%s", h.scheme, ExternalHost(target.Host, h.scheme, h.port), path)
 		if r.URL.RawQuery != "" {
 			redirectURL += "?" + r.URL.RawQuery
 		}
@@ -106,15 +248,150 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Proxy-managed robots.txt / security.txt override the backend
+	if content, ok := rtr.StaticFile(host, path); ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, content)
+		return
+	}
+
 	// Find matching route
+	var matchStart time.Time
+	if h.metrics != nil {
+		matchStart = time.Now()
+	}
 	route := rtr.Match(host, path)
+	if route != nil && h.removedScaledToZero(route) {
+		route = rtr.MatchSkipping(host, path, h.removedScaledToZero)
+	}
+	if h.metrics != nil {
+		routerMatchDuration.Observe(time.Since(matchStart).Seconds())
+	}
 	if route == nil {
-		http.Error(w, "no route found", http.StatusNotFound)
+		h.writeError(w, r, http.StatusNotFound, "no route found")
+		return
+	}
+
+	if info != nil {
+		info.route = route
+	}
+
+	// CORS headers go on every answer, errors included, so pages can read them
+	if route.CORS != nil && applyCORS(w, r, route.CORS) {
+		return
+	}
+
+	// A service scaled to zero gets the route's chosen answer, not dial errors
+	if route.ScaledToZero != nil && !h.serveScaledToZero(w, r, route) {
+		return
+	}
+
+	// Client certificates were verified in the handshake for its server name
+	// Direct paths (platform health checks) are never checked, but a subject
+	// sent by the client is dropped on every path
+	if route.ClientAuth != nil {
+		r.Header.Del(ClientCertSubjectHeader)
+		if !route.IsDirect(path) && !h.checkClientCert(w, r, route.ClientAuth) {
+			return
+		}
+	}
+
+	// Refuse requests matching the route's WAF rules
+	// Direct paths (platform health checks) are never inspected
+	if route.WAF != nil && !route.IsDirect(path) && h.inspect(w, r, route.WAF) {
+		return
+	}
+
+	// Keep one client from tying up the backend's connections
+	// Direct paths (platform health checks) are never limited
+	if l := h.limiterFor(route); l != nil && !route.IsDirect(path) {
+		ip := clientIP(r)
+		if !l.acquire(ip) {
+			requestsShed.Inc(route.ServiceName, ShedClientConcurrency)
+			w.Header().Set("Retry-After", "1")
+			h.writeError(w, r, http.StatusTooManyRequests, "too many concurrent requests")
+			return
+		}
+		defer l.release(ip)
+	}
+
+	// Hold uploads until the content scanner passes them
+	if route.Scan != nil && r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		body, err := scanBody(r, route.Scan)
+		switch {
+		case errors.Is(err, errInfected):
+			slog.Warn("content scan rejected upload", "host", host, "path", path, "err", err)
+			h.writeError(w, r, http.StatusForbidden, "upload rejected by content scan")
+			return
+		case errors.Is(err, errScanTooBig):
+			h.writeError(w, r, http.StatusRequestEntityTooLarge, "upload too large to scan")
+			return
+		case err != nil:
+			errlog.Printf("scan", route.Scan.URL, "content scan for %s%s: %v", host, path, err)
+			h.writeError(w, r, http.StatusBadGateway, "content scanner unavailable")
+			return
+		}
+		defer body.Close()
+		setBody(r, body)
+	}
+
+	if len(route.Flags) > 0 {
+		h.setFlagHeaders(r, route)
+	}
+	if route.RouteHeader {
+		r.Header.Set(RouteHeader, routeHeaderValue(route))
+	}
+
+	// Chaos testing: slow down or fail requests before they reach a backend
+	if h.injectFault(w, r, route) {
 		return
 	}
 
-	// Get or create proxy for this route
-	proxy := h.getProxy(route)
+	// Pick a backend (keyed on the original URL so hashing ignores prefix stripping)
+	// Canary routes send their share of requests to the canary service, skipping the rest
+	// Wildcard routes with a tenant resolver use the subdomain's own backend
+	// Sticky routes keep clients on the backend named by their cookie
+	// Regional routes prefer the backend for the client's region
+	var addr string
+	if addr = canaryBackend(route, r); addr == "" {
+		if route.TenantResolver != "" {
+			var err error
+			addr, err = h.resolveTenant(r.Context(), route, host)
+			if errors.Is(err, errUnknownTenant) {
+				h.writeError(w, r, http.StatusNotFound, "unknown tenant")
+				return
+			}
+			if err != nil {
+				errlog.Printf("tenants", route.TenantResolver, "tenant resolver for %s: %v", host, err)
+				h.writeError(w, r, http.StatusBadGateway, "tenant resolver unavailable")
+				return
+			}
+		} else if addr = h.stickyBackend(route, r); addr == "" {
+			var done func(string)
+			if route.Regions != nil {
+				addr = h.regionBackend(route, r)
+			} else {
+				addr, done = h.pickBackend(route, host+r.URL.RequestURI())
+			}
+			if addr == "" {
+				requestsShed.Inc(route.ServiceName, ShedNoHealthyBackend)
+				h.writeError(w, r, http.StatusServiceUnavailable, "no healthy backend")
+				return
+			}
+			if done != nil {
+				defer done(addr)
+			}
+			if route.StickyCookie != "" {
+				setStickyCookie(w, r, route, addr)
+			}
+		}
+	}
+	if info != nil {
+		info.backend = addr
+	}
+
+	// Get or create proxy for this backend
+	proxy := h.getProxy(addr, route)
 
 	// Strip the path prefix before proxying (if enabled)
 	if route.StripPrefix && route.PathPrefix != "/" {
@@ -124,12 +401,179 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	proxy.ServeHTTP(w, r)
+	// Bound the whole exchange. WebSockets are exempt, and event streams
+	// once the backend answers with one; liteproxy.streams.* bounds those.
+	// What the client claims to accept can't lift the timeout.
+	if route.Timeout != nil && route.Timeout.Duration > 0 && !isUpgrade(r) {
+		var release func()
+		r, release = withRouteDeadline(r, route.Timeout.Duration)
+		defer release()
+	}
+	stream := isStream(r)
+	if stream {
+		if !h.acquireStream(route) {
+			requestsShed.Inc(route.ServiceName, ShedStreamConnections)
+			w.Header().Set("Retry-After", "1")
+			h.writeError(w, r, http.StatusServiceUnavailable, "too many open connections")
+			return
+		}
+		defer h.releaseStream(route)
+	}
+
+	// Ask for an uncompressed body so the proxy can rewrite or compress it itself
+	// An explicit identity also stops the transport from adding its own gzip
+	if route.UpstreamEncoding == compose.EncodingIdentity || route.DLP != nil {
+		r.Header.Set("Accept-Encoding", "identity")
+	}
+	// DLP scans need the whole body, not a range of it
+	if route.DLP != nil {
+		r.Header.Del("Range")
+		r.Header.Del("If-Range")
+	}
+
+	// Compress responses the backend sent uncompressed
+	if route.Compress != nil {
+		cw := newCompressWriter(w, r, route.Compress)
+		defer cw.close()
+		w = cw
+	}
+
+	// Scan responses for sensitive data before compressing them
+	if route.DLP != nil {
+		dw := newDLPWriter(h, w, r, route)
+		defer dw.close()
+		w = dw
+	}
+
+	// Close streams that have gone quiet
+	if stream && route.Streams != nil && route.Streams.IdleTimeout > 0 {
+		var sw *streamWriter
+		sw, r = newStreamWriter(w, r, route.Streams.IdleTimeout)
+		defer sw.close()
+		w = sw
+	}
+
+	// Error pages are picked by the client's host; the proxy's error
+	// handler only sees the outgoing request
+	if h.errorPages.Load() != nil {
+		r = withErrorHost(r)
+	}
+
+	requestsInFlight.Add(1, route.ServiceName)
+	defer requestsInFlight.Add(-1, route.ServiceName)
+	requestsProxied.Inc(route.ServiceName)
+	ww := &waitWriter{ResponseWriter: w, service: route.ServiceName}
+	requestsWaiting.Add(1, route.ServiceName)
+	defer ww.stopWaiting()
+
+	proxy.ServeHTTP(ww, r)
 }
 
-// getProxy returns a cached or new reverse proxy for the route
-func (h *Handler) getProxy(route *compose.Route) *httputil.ReverseProxy {
-	key := fmt.Sprintf("%s:%d", route.ServiceName, route.ServicePort)
+// healthyFunc returns the health filter for the route's backends, or nil if unchecked
+func (h *Handler) healthyFunc(route *compose.Route) func(string) bool {
+	if h.health != nil && (route.HealthCheck != nil || route.PassiveCheck != nil) {
+		return h.health.Healthy
+	}
+	return nil
+}
+
+// pickBackend returns the backend address to proxy to for the route,
+// or "" if every backend is failing its health check. A non-nil done must
+// be called with the address once the request completes.
+func (h *Handler) pickBackend(route *compose.Route, key string) (addr string, done func(string)) {
+	healthy := h.healthyFunc(route)
+	addrs := h.backendAddrs(route)
+	if len(addrs) == 1 {
+		if healthy != nil && !healthy(addrs[0]) {
+			return "", nil
+		}
+		return addrs[0], nil
+	}
+
+	// DNS discovery can change the address set, which needs a new balancer
+	h.mu.RLock()
+	e, ok := h.balancers[route]
+	h.mu.RUnlock()
+	if !ok || !slices.Equal(e.addrs, addrs) {
+		h.mu.Lock()
+		if e, ok = h.balancers[route]; !ok || !slices.Equal(e.addrs, addrs) {
+			e = &balancerEntry{addrs: addrs, b: balancer.New(route.Balance, addrs)}
+			h.balancers[route] = e
+		}
+		h.mu.Unlock()
+	}
+
+	addr = e.b.Next(key, healthy)
+	if t, ok := e.b.(balancer.Tracker); ok && addr != "" {
+		done = t.Done
+	}
+	return addr, done
+}
+
+// proxyKey identifies a cached proxy: routes to the same backend share
+// one unless their options differ
+type proxyKey struct {
+	addr string
+	opts proxyOptions
+}
+
+// proxyOptions are the route options baked into a proxy
+type proxyOptions struct {
+	passHostHeader   bool
+	flushImmediately bool
+	copyBufferSize   int
+	http1Only        bool
+	protocol         string
+	timeoutResponse  string
+	headerTimeout    time.Duration
+	requestHeaders   *compose.HeaderRules
+	responseHeaders  *compose.HeaderRules
+	hsts             string
+	retries          int
+	retryBackoff     time.Duration
+	retryAfter       time.Duration
+	dial             string
+	upstreamTLS      compose.UpstreamTLS
+	dns              compose.DNS // static routes only; DNS discovery pools resolve themselves
+	cors             bool        // the route answers CORS, replacing the backend's headers
+}
+
+func optionsFor(route *compose.Route) proxyOptions {
+	opts := proxyOptions{
+		passHostHeader:   route.PassHostHeader,
+		flushImmediately: route.FlushImmediately,
+		copyBufferSize:   route.CopyBufferSize,
+		http1Only:        route.UpstreamProtocol == compose.ProtocolHTTP1,
+		protocol:         route.Protocol,
+		requestHeaders:   route.RequestHeaders,
+		responseHeaders:  route.ResponseHeaders,
+		dial:             route.Dial,
+	}
+	if route.UpstreamTLS != nil {
+		opts.upstreamTLS = *route.UpstreamTLS
+	}
+	opts.cors = route.CORS != nil
+	if route.DNS != nil && route.Discovery != compose.DiscoveryDNS {
+		opts.dns = *route.DNS
+	}
+	if route.Timeout != nil {
+		opts.timeoutResponse = route.Timeout.Response
+		opts.headerTimeout = route.Timeout.Headers
+	}
+	if route.HSTS != nil {
+		opts.hsts = route.HSTS.Header()
+	}
+	if route.Retry != nil {
+		opts.retries = route.Retry.Attempts
+		opts.retryBackoff = route.Retry.Backoff
+		opts.retryAfter = route.Retry.After
+	}
+	return opts
+}
+
+// getProxy returns a cached or new reverse proxy for the backend address
+func (h *Handler) getProxy(addr string, route *compose.Route) *httputil.ReverseProxy {
+	key := proxyKey{addr: addr, opts: optionsFor(route)}
 
 	h.mu.RLock()
 	proxy, ok := h.proxies[key]
@@ -149,42 +593,158 @@ func (h *Handler) getProxy(route *compose.Route) *httputil.ReverseProxy {
 
 	target := &url.URL{
 		Scheme: "http",
-		Host:   fmt.Sprintf("%s:%d", route.ServiceName, route.ServicePort),
+		Host:   addr,
+	}
+	if key.opts.upstreamTLS != (compose.UpstreamTLS{}) || key.opts.protocol == compose.SchemeHTTPS {
+		target.Scheme = "https"
 	}
 
-	proxy = h.buildProxy(target, route.PassHostHeader)
+	proxy = h.buildProxy(target, key.opts)
 	h.proxies[key] = proxy
 	return proxy
 }
 
 // buildProxy creates a high-performance reverse proxy
-func (h *Handler) buildProxy(target *url.URL, passHostHeader bool) *httputil.ReverseProxy {
+func (h *Handler) buildProxy(target *url.URL, opts proxyOptions) *httputil.ReverseProxy {
+	flushInterval := 100 * time.Millisecond
+	if opts.flushImmediately {
+		flushInterval = -1 // flush after every write
+	}
+	// HTTP/1.1-only routes have nothing to fall back from, and h2c backends
+	// nothing to fall back to
+	var transport http.RoundTripper = fallbackTransport{h.h2, sharedTransport, http1Transport}
+	if opts.http1Only {
+		transport = http1Transport
+	}
+	if opts.protocol == compose.SchemeH2C {
+		transport = h2cTransport
+	}
+	if opts.dial != "" || opts.upstreamTLS != (compose.UpstreamTLS{}) || opts.dns != (compose.DNS{}) {
+		key := transportKey{
+			dial:        opts.dial,
+			upstreamTLS: opts.upstreamTLS,
+			h2c:         opts.protocol == compose.SchemeH2C,
+			http1Only:   opts.http1Only,
+			dns:         opts.dns,
+		}
+		transport = h.customTransport(key)
+		if !key.h2c && !key.http1Only {
+			h1 := key
+			h1.http1Only = true
+			transport = fallbackTransport{h.h2, transport, h.customTransport(h1)}
+		}
+	}
+	if opts.headerTimeout > 0 {
+		transport = headerTimeoutTransport{transport, opts.headerTimeout}
+	}
+	transport = attemptTransport{transport}
+	if opts.retries > 0 || opts.retryAfter > 0 {
+		transport = retryTransport{transport, opts.retries, opts.retryBackoff, opts.retryAfter}
+	}
+	bufPool := sharedBufferPool
+	if opts.copyBufferSize > 0 {
+		bufPool = bufferPoolFor(opts.copyBufferSize)
+	}
+
 	return &httputil.ReverseProxy{
 		Rewrite: func(pr *httputil.ProxyRequest) {
 			pr.SetURL(target)
 
-			if passHostHeader {
+			if opts.passHostHeader {
 				pr.Out.Host = pr.In.Host
 			}
 
 			// Normalize WebSocket headers for strict servers
 			normalizeWebSocketHeaders(pr.Out.Header)
 
-			pr.SetXForwarded()
+			h.setForwarded(pr)
+
+			// Route rules come last so they can override the proxy's own headers
+			if opts.requestHeaders != nil {
+				applyHeaders(pr.Out.Header, opts.requestHeaders)
+				if host := pr.Out.Header.Get("Host"); host != "" {
+					pr.Out.Host = host
+					pr.Out.Header.Del("Host")
+				}
+			}
 		},
 
-		Transport:     sharedTransport,
-		FlushInterval: 100 * time.Millisecond,
-		BufferPool:    sharedBufferPool,
+		Transport:     transport,
+		FlushInterval: flushInterval,
+		BufferPool:    bufPool,
+
+		ModifyResponse: func(resp *http.Response) error {
+			h.report(target.Host, resp.StatusCode >= 500)
+			if isEventStream(resp.Header) {
+				liftRouteDeadline(resp.Request.Context())
+			}
+			if h.debugHeaders {
+				resp.Header.Set(UpstreamHeader, target.Host)
+			}
+			if opts.cors {
+				dropBackendCORS(resp.Header)
+			}
+			if opts.responseHeaders != nil {
+				applyHeaders(resp.Header, opts.responseHeaders)
+			}
+			if opts.hsts != "" && resp.Request.TLS != nil {
+				resp.Header.Set("Strict-Transport-Security", opts.hsts)
+			}
+			return nil
+		},
 
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			log.Printf("proxy error to %s: %v", target.Host, err)
-			w.WriteHeader(http.StatusBadGateway)
-			fmt.Fprintf(w, "Bad Gateway: %v", err)
+			// Clients going away says nothing about the backend
+			if !errors.Is(err, context.Canceled) {
+				h.report(target.Host, true)
+			}
+			errlog.Printf("proxy", target.Host+" "+errlog.Class(err), "proxy error to %s: %v", target.Host, err)
+			if h.debugHeaders {
+				w.Header().Set(UpstreamHeader, target.Host)
+			}
+			if opts.responseHeaders != nil {
+				applyHeaders(w.Header(), opts.responseHeaders)
+			}
+			if opts.hsts != "" && r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", opts.hsts)
+			}
+			// The route's deadline passed before the backend responded
+			if errors.Is(context.Cause(r.Context()), context.DeadlineExceeded) || errors.Is(err, errHeaderTimeout) {
+				if opts.timeoutResponse == "" {
+					h.writeError(w, r, http.StatusGatewayTimeout, "Gateway Timeout")
+					return
+				}
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(http.StatusGatewayTimeout)
+				io.WriteString(w, opts.timeoutResponse)
+				return
+			}
+			// The error itself is logged above; it may name internal addresses
+			h.writeError(w, r, http.StatusBadGateway, "Bad Gateway")
 		},
 	}
 }
 
+// report feeds the outcome of a proxied request to passive health checks
+func (h *Handler) report(addr string, failed bool) {
+	if h.health != nil {
+		h.health.Report(addr, failed)
+	}
+}
+
+// applyHeaders applies a route's header rules: remove, then set, then add
+func applyHeaders(h http.Header, rules *compose.HeaderRules) {
+	for _, name := range rules.Remove {
+		h.Del(name)
+	}
+	for name, value := range rules.Set {
+		h.Set(name, value)
+	}
+	for name, value := range rules.Add {
+		h.Add(name, value)
+	}
+}
+
 // normalizeWebSocketHeaders ensures WebSocket headers have correct casing
 // Some strict WebSocket servers require exact header names
 func normalizeWebSocketHeaders(h http.Header) {
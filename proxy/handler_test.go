@@ -1,15 +1,28 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/localrivet/liteproxy/accesslog"
 	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/dialer"
+	"github.com/localrivet/liteproxy/health"
+	"github.com/localrivet/liteproxy/metrics"
 	"github.com/localrivet/liteproxy/router"
 )
 
@@ -228,7 +241,7 @@ func TestProxyIntegration(t *testing.T) {
 	h := New(rtr, "http")
 
 	// Pre-populate the proxy cache with our test backend
-	h.proxies["api:8080"] = &httputil.ReverseProxy{
+	h.proxies[proxyKey{addr: "api:8080"}] = &httputil.ReverseProxy{
 		Rewrite: func(pr *httputil.ProxyRequest) {
 			pr.SetURL(backendURL)
 			pr.SetXForwarded()
@@ -281,7 +294,7 @@ func TestProxyIntegrationNoStrip(t *testing.T) {
 	rtr := router.New(routes)
 	h := New(rtr, "http")
 
-	h.proxies["api:8080"] = &httputil.ReverseProxy{
+	h.proxies[proxyKey{addr: "api:8080"}] = &httputil.ReverseProxy{
 		Rewrite: func(pr *httputil.ProxyRequest) {
 			pr.SetURL(backendURL)
 			pr.SetXForwarded()
@@ -405,7 +418,7 @@ func TestWebSocketHeadersForwarded(t *testing.T) {
 	rtr := router.New(routes)
 	h := New(rtr, "http")
 
-	h.proxies["ws:8080"] = &httputil.ReverseProxy{
+	h.proxies[proxyKey{addr: "ws:8080"}] = &httputil.ReverseProxy{
 		Rewrite: func(pr *httputil.ProxyRequest) {
 			pr.SetURL(backendURL)
 			normalizeWebSocketHeaders(pr.Out.Header)
@@ -437,3 +450,1121 @@ func TestWebSocketHeadersForwarded(t *testing.T) {
 		}
 	}
 }
+
+func TestURLHashPicksSameBackend(t *testing.T) {
+	routes := []compose.Route{
+		{
+			Host:        "cdn.example.com",
+			PathPrefix:  "/",
+			ServiceName: "cache",
+			ServicePort: 80,
+			Backends:    []string{"cache-1:80", "cache-2:80", "cache-3:80"},
+			Balance:     "url_hash",
+		},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+	route := rtr.Match("cdn.example.com", "/")
+
+	first, _ := h.pickBackend(route, "cdn.example.com/app.js")
+	for i := 0; i < 10; i++ {
+		if got, _ := h.pickBackend(route, "cdn.example.com/app.js"); got != first {
+			t.Fatalf("pickBackend() = %q, want %q", got, first)
+		}
+	}
+
+	// The ring is rebuilt after a reload but still maps the URL to the same node
+	h.UpdateRouter(router.New(routes))
+	route = h.router.Load().Match("cdn.example.com", "/")
+	if got, _ := h.pickBackend(route, "cdn.example.com/app.js"); got != first {
+		t.Errorf("pickBackend() after reload = %q, want %q", got, first)
+	}
+}
+
+func TestPickBackendSingle(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+
+	if got, _ := h.pickBackend(rtr.Match("example.com", "/"), "example.com/"); got != "web:80" {
+		t.Errorf("pickBackend() = %q, want %q", got, "web:80")
+	}
+}
+
+func TestPassiveHealthEjectsFailingBackend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	routes := []compose.Route{
+		{
+			Host:         "example.com",
+			PathPrefix:   "/",
+			ServiceName:  "api",
+			ServicePort:  8080,
+			Backends:     []string{backendURL.Host},
+			PassiveCheck: &compose.PassiveCheck{MaxFails: 2, FailTimeout: time.Hour},
+		},
+	}
+	checker := health.NewChecker()
+	defer checker.Stop()
+	checker.Update(routes)
+
+	h := New(router.New(routes), "http")
+	h.SetHealthChecker(checker)
+
+	wantCodes := []int{
+		http.StatusInternalServerError,
+		http.StatusInternalServerError,
+		http.StatusServiceUnavailable, // ejected
+	}
+	for i, want := range wantCodes {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("request %d status = %d, want %d", i, w.Code, want)
+		}
+	}
+}
+
+func TestMetricsAggregateWildcardHosts(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	routes := []compose.Route{
+		{Host: "*.metrics.test", PathPrefix: "/", ServiceName: "tenant-app", ServicePort: 8080, Backends: []string{backendURL.Host}},
+	}
+	h := New(router.New(routes), "http")
+	labeler, _ := metrics.NewHostLabeler(metrics.HostRoute, 0)
+	h.SetMetrics(labeler)
+
+	for _, host := range []string{"a.metrics.test", "b.metrics.test", "c.metrics.test:8080"} {
+		req := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := requestsTotal.Value("*.metrics.test", "/", "tenant-app", "200"); got != 3 {
+		t.Errorf("requests for *.metrics.test = %d, want 3", got)
+	}
+	if got := requestsTotal.Value("a.metrics.test", "/", "tenant-app", "200"); got != 0 {
+		t.Errorf("requests for a.metrics.test = %d, want 0 (aggregated)", got)
+	}
+}
+
+func TestAccessLogRecordsAttempts(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "api", ServicePort: 8080, Backends: []string{backendURL.Host}},
+	}
+	h := New(router.New(routes), "http")
+
+	var buf bytes.Buffer
+	redactor, _ := accesslog.NewRedactor(nil, nil, nil)
+	h.SetAccessLog(accesslog.New(&buf, redactor, nil))
+	h.SetDebugHeaders(true)
+
+	req := httptest.NewRequest("POST", "http://example.com/orders", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if got := w.Header().Get(UpstreamHeader); got != backendURL.Host {
+		t.Errorf("%s = %q, want %q", UpstreamHeader, got, backendURL.Host)
+	}
+
+	var entry accesslog.Entry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid access log entry %q: %v", buf.String(), err)
+	}
+	if entry.Status != http.StatusCreated {
+		t.Errorf("Status = %d, want %d", entry.Status, http.StatusCreated)
+	}
+	if len(entry.Attempts) != 1 {
+		t.Fatalf("Attempts = %+v, want 1 attempt", entry.Attempts)
+	}
+	if a := entry.Attempts[0]; a.Backend != backendURL.Host || a.Outcome != "201" {
+		t.Errorf("Attempts[0] = %+v, want backend %s outcome 201", a, backendURL.Host)
+	}
+}
+
+func TestDebugHeadersOffByDefault(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "api", ServicePort: 8080, Backends: []string{backendURL.Host}},
+	}
+	h := New(router.New(routes), "http")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	if got := w.Header().Get(UpstreamHeader); got != "" {
+		t.Errorf("%s = %q, want empty", UpstreamHeader, got)
+	}
+}
+
+func TestProxyIPv6Backend(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 loopback not available")
+	}
+
+	var forwardedFor string
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedFor = r.Header.Get("X-Forwarded-For")
+		io.WriteString(w, "v6")
+	}))
+	backend.Listener = ln
+	backend.Start()
+	defer backend.Close()
+
+	// Backends given as bare IPv6 literals get bracketed when the port is added
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "::1", ServicePort: ln.Addr().(*net.TCPAddr).Port},
+	}
+	h := New(router.New(routes), "http")
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Host = "example.com"
+	req.RemoteAddr = "[2001:db8::7]:51234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "v6" {
+		t.Fatalf("status = %d body = %q, want 200 v6", w.Code, w.Body.String())
+	}
+	// X-Forwarded-For carries bare addresses, without brackets or port
+	if forwardedFor != "2001:db8::7" {
+		t.Errorf("X-Forwarded-For = %q, want %q", forwardedFor, "2001:db8::7")
+	}
+}
+
+func TestRobotsOverridesBackend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "User-agent: *\nAllow: /\n")
+	}))
+	defer backend.Close()
+
+	addr := backend.Listener.Addr().(*net.TCPAddr)
+	routes := []compose.Route{
+		{Host: "staging.example.com", PathPrefix: "/", ServiceName: addr.IP.String(), ServicePort: addr.Port,
+			Robots: "User-agent: *\nDisallow: /\n"},
+	}
+	h := New(router.New(routes), "https")
+
+	req := httptest.NewRequest("GET", "http://staging.example.com/robots.txt", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if got := w.Body.String(); got != "User-agent: *\nDisallow: /\n" {
+		t.Errorf("body = %q, want proxy-managed robots.txt", got)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+
+	// Other paths still reach the backend
+	req = httptest.NewRequest("GET", "http://staging.example.com/other", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got := w.Body.String(); got != "User-agent: *\nAllow: /\n" {
+		t.Errorf("backend body = %q, want backend response", got)
+	}
+}
+
+func TestUpstreamEncoding(t *testing.T) {
+	var got []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Accept-Encoding"))
+	}))
+	defer backend.Close()
+
+	addr := backend.Listener.Addr().(*net.TCPAddr)
+	routes := []compose.Route{
+		{Host: "raw.example.com", PathPrefix: "/", ServiceName: addr.IP.String(), ServicePort: addr.Port,
+			UpstreamEncoding: compose.EncodingIdentity},
+		{Host: "pass.example.com", PathPrefix: "/", ServiceName: addr.IP.String(), ServicePort: addr.Port},
+	}
+	h := New(router.New(routes), "http")
+
+	for _, host := range []string{"raw.example.com", "pass.example.com"} {
+		req := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	want := []string{"identity", "gzip, br"}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("backend Accept-Encoding = %q, want %q", got, want)
+	}
+}
+
+func TestRouterMetrics(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80, RedirectFrom: []string{"old.example.com"}},
+		{Host: "*.example.com", PathPrefix: "/", ServiceName: "tenant", ServicePort: 80},
+	}
+	h := New(router.New(routes), "http")
+	labeler, _ := metrics.NewHostLabeler(metrics.HostRoute, 0)
+	h.SetMetrics(labeler)
+
+	before := routerMatchDuration.Count()
+	req := httptest.NewRequest("GET", "http://unknown.test/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if got := routerMatchDuration.Count() - before; got != 1 {
+		t.Errorf("match observations = %d, want 1", got)
+	}
+
+	if got, want := routerStats(), (router.Stats{Routes: 1, Wildcards: 1, Redirects: 1}); got != want {
+		t.Errorf("routerStats() = %+v, want %+v", got, want)
+	}
+
+	reloads := routerReloads.Value()
+	h.UpdateRouter(router.New(routes[:1]))
+	if got := routerReloads.Value() - reloads; got != 1 {
+		t.Errorf("reloads = %d, want 1", got)
+	}
+	if got := routerStats().Wildcards; got != 0 {
+		t.Errorf("wildcard routes after reload = %d, want 0", got)
+	}
+}
+
+func TestRangeRequestsPassThrough(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "artifact.bin", modTime, bytes.NewReader(content))
+	}))
+	defer backend.Close()
+
+	addr := backend.Listener.Addr().(*net.TCPAddr)
+	routes := []compose.Route{
+		{Host: "downloads.example.com", PathPrefix: "/", ServiceName: addr.IP.String(), ServicePort: addr.Port,
+			FlushImmediately: true, CopyBufferSize: 256 << 10},
+	}
+	h := New(router.New(routes), "http")
+
+	tests := []struct {
+		name       string
+		ifRange    string
+		wantStatus int
+		wantLen    int
+	}{
+		{name: "range", wantStatus: http.StatusPartialContent, wantLen: 100},
+		{name: "if-range match", ifRange: `"v1"`, wantStatus: http.StatusPartialContent, wantLen: 100},
+		{name: "if-range stale", ifRange: `"v0"`, wantStatus: http.StatusOK, wantLen: len(content)},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://downloads.example.com/artifact.bin", nil)
+		req.Header.Set("Range", "bytes=1000-1099")
+		if tt.ifRange != "" {
+			req.Header.Set("If-Range", tt.ifRange)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tt.wantStatus || w.Body.Len() != tt.wantLen {
+			t.Errorf("%s: got %d with %d bytes, want %d with %d bytes", tt.name, w.Code, w.Body.Len(), tt.wantStatus, tt.wantLen)
+		}
+		if tt.wantStatus == http.StatusPartialContent && !bytes.Equal(w.Body.Bytes(), content[1000:1100]) {
+			t.Errorf("%s: partial body mismatch", tt.name)
+		}
+	}
+}
+
+func TestProxyPerRouteOptions(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "site.example.com", PathPrefix: "/", ServiceName: "app", ServicePort: 80},
+		{Host: "site.example.com", PathPrefix: "/downloads", ServiceName: "app", ServicePort: 80,
+			FlushImmediately: true, CopyBufferSize: 1 << 20},
+		{Host: "other.example.com", PathPrefix: "/", ServiceName: "app", ServicePort: 80},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+
+	plain := h.getProxy("app:80", rtr.Match("site.example.com", "/"))
+	download := h.getProxy("app:80", rtr.Match("site.example.com", "/downloads/x"))
+	shared := h.getProxy("app:80", rtr.Match("other.example.com", "/"))
+
+	if plain == download {
+		t.Error("routes with different options share a proxy")
+	}
+	if plain != shared {
+		t.Error("routes with default options to the same backend should share a proxy")
+	}
+	if download.FlushInterval != -1 {
+		t.Errorf("FlushInterval = %v, want -1", download.FlushInterval)
+	}
+	if buf := download.BufferPool.Get(); len(buf) != 1<<20 {
+		t.Errorf("copy buffer = %d bytes, want %d", len(buf), 1<<20)
+	}
+}
+
+func TestH2Fallback(t *testing.T) {
+	f := newH2Fallback()
+	h2Err := errors.New("http2: server sent GOAWAY and closed the connection")
+
+	// Unrelated errors and successes reset the streak
+	f.record("api:443", h2Err)
+	f.record("api:443", h2Err)
+	f.record("api:443", nil)
+	f.record("api:443", h2Err)
+	f.record("api:443", errors.New("dial tcp: connection refused"))
+	if f.isPinned("api:443") {
+		t.Fatal("backend pinned without consecutive HTTP/2 errors")
+	}
+
+	for i := 0; i < h2FallbackThreshold; i++ {
+		f.record("api:443", h2Err)
+	}
+	if !f.isPinned("api:443") {
+		t.Error("backend not pinned after consecutive HTTP/2 errors")
+	}
+	if f.isPinned("web:443") {
+		t.Error("unrelated backend pinned")
+	}
+}
+
+func TestUpstreamProtocolHTTP1(t *testing.T) {
+	if http1Transport.ForceAttemptHTTP2 || http1Transport.TLSNextProto == nil {
+		t.Fatal("http1Transport can negotiate HTTP/2")
+	}
+
+	routes := []compose.Route{
+		{Host: "legacy.example.com", PathPrefix: "/", ServiceName: "legacy", ServicePort: 443,
+			UpstreamProtocol: compose.ProtocolHTTP1},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+
+	proxy := h.getProxy("legacy:443", rtr.Match("legacy.example.com", "/"))
+	if tr, ok := proxy.Transport.(attemptTransport); !ok || tr.RoundTripper != http.RoundTripper(http1Transport) {
+		t.Errorf("Transport = %#v, want http1Transport", proxy.Transport)
+	}
+}
+
+func TestCustomTransportFallback(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "secure.example.com", PathPrefix: "/", ServiceName: "secure", ServicePort: 443,
+			UpstreamTLS: &compose.UpstreamTLS{ServerName: "secure.internal"}},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+
+	// Routes with their own transport fall back to HTTP/1.1 too
+	proxy := h.getProxy("secure:443", rtr.Match("secure.example.com", "/"))
+	tr, ok := proxy.Transport.(attemptTransport)
+	if !ok {
+		t.Fatalf("Transport = %#v, want attemptTransport", proxy.Transport)
+	}
+	fb, ok := tr.RoundTripper.(fallbackTransport)
+	if !ok {
+		t.Fatalf("Transport = %#v, want fallbackTransport", tr.RoundTripper)
+	}
+	h1, ok := fb.h1.(*http.Transport)
+	if !ok || h1.ForceAttemptHTTP2 || h1.TLSNextProto == nil {
+		t.Errorf("fallback transport can negotiate HTTP/2: %#v", fb.h1)
+	}
+	if h1 != nil && h1.TLSClientConfig.ServerName != "secure.internal" {
+		t.Errorf("fallback transport ServerName = %q, want the route's", h1.TLSClientConfig.ServerName)
+	}
+}
+
+func TestProtocolH2C(t *testing.T) {
+	// A gRPC-style backend: cleartext HTTP/2 only, status in trailers
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("backend got %s, want HTTP/2", r.Proto)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		io.WriteString(w, "reply")
+		w.(http.Flusher).Flush() // streamed, so no Content-Length
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	backend.Config.Protocols = h2cProtocols()
+	backend.Start()
+	defer backend.Close()
+
+	addr := backend.Listener.Addr().(*net.TCPAddr)
+	routes := []compose.Route{
+		{Host: "grpc.example.com", PathPrefix: "/", ServiceName: addr.IP.String(), ServicePort: addr.Port,
+			Protocol: compose.SchemeH2C},
+	}
+	h := New(router.New(routes), "http")
+	front := httptest.NewServer(h)
+	defer front.Close()
+
+	req, _ := http.NewRequest("POST", front.URL+"/helloworld.Greeter/SayHello", strings.NewReader("request"))
+	req.Host = "grpc.example.com"
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "reply" {
+		t.Fatalf("status = %d body = %q, want 200 reply", resp.StatusCode, body)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want %q", got, "0")
+	}
+}
+
+func TestProtocolHTTPS(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "secure.example.com", PathPrefix: "/", ServiceName: "secure", ServicePort: 443,
+			Protocol: compose.SchemeHTTPS},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+
+	proxy := h.getProxy("secure:443", rtr.Match("secure.example.com", "/"))
+	in := httptest.NewRequest("GET", "http://secure.example.com/", nil)
+	pr := &httputil.ProxyRequest{In: in, Out: in.Clone(in.Context())}
+	proxy.Rewrite(pr)
+	if pr.Out.URL.Scheme != "https" || pr.Out.URL.Host != "secure:443" {
+		t.Errorf("backend URL = %s, want https://secure:443", pr.Out.URL)
+	}
+}
+
+func TestDNSDiscoveryBalancesReplicas(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80, Discovery: compose.DiscoveryDNS},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+
+	var lookups atomic.Int32
+	ips := []string{"10.0.0.2", "10.0.0.1"}
+	h.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		lookups.Add(1)
+		if host != "web" {
+			return nil, errors.New("no such host")
+		}
+		return ips, nil
+	}
+
+	route := rtr.Match("example.com", "/")
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		addr, _ := h.pickBackend(route, "")
+		seen[addr]++
+	}
+	if seen["10.0.0.1:80"] != 2 || seen["10.0.0.2:80"] != 2 {
+		t.Errorf("picked %v, want both replicas twice", seen)
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("lookups = %d, want 1 while the addresses are fresh", n)
+	}
+
+	// Lookup failures keep the last good addresses
+	h.lookupHost = func(context.Context, string) ([]string, error) { return nil, errors.New("timeout") }
+	p := h.pools[route]
+	p.refresh()
+	if addrs := p.Addrs(); len(addrs) != 2 {
+		t.Errorf("Addrs() after failed lookup = %v, want previous replicas", addrs)
+	}
+}
+
+func TestLeastConnReleasesBackend(t *testing.T) {
+	routes := []compose.Route{
+		{
+			Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80,
+			Backends: []string{"web-1:80", "web-2:80"}, Balance: "least_conn",
+		},
+	}
+	rtr := router.New(routes)
+	h := New(rtr, "http")
+	route := rtr.Match("example.com", "/")
+
+	busy, done := h.pickBackend(route, "")
+	if done == nil {
+		t.Fatal("pickBackend() done = nil, want a release func for least_conn")
+	}
+
+	// The busy backend is avoided until its request completes
+	for i := 0; i < 3; i++ {
+		addr, release := h.pickBackend(route, "")
+		if addr == busy {
+			t.Fatalf("pickBackend() = %q, want the idle backend", addr)
+		}
+		release(addr)
+	}
+	done(busy)
+}
+
+func TestDNSDiscoveryHealthFollowsReplicas(t *testing.T) {
+	routes := []compose.Route{
+		{
+			Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80,
+			Discovery:    compose.DiscoveryDNS,
+			PassiveCheck: &compose.PassiveCheck{MaxFails: 1, FailTimeout: time.Hour},
+		},
+	}
+	rtr := router.New(routes)
+	checker := health.NewChecker()
+	defer checker.Stop()
+	checker.Update(routes)
+
+	h := New(rtr, "http")
+	h.SetHealthChecker(checker)
+	h.lookupHost = func(context.Context, string) ([]string, error) {
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+
+	route := rtr.Match("example.com", "/")
+	h.pickBackend(route, "")
+
+	// A failing replica is ejected on its own
+	checker.Report("10.0.0.1:80", true)
+	for i := 0; i < 4; i++ {
+		if addr, _ := h.pickBackend(route, ""); addr != "10.0.0.2:80" {
+			t.Fatalf("pickBackend() = %q, want the healthy replica", addr)
+		}
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			// Never sends headers
+			<-r.Context().Done()
+		case "/trickle":
+			// Starts promptly, then streams a byte at a time forever
+			for {
+				if _, err := w.Write([]byte("x")); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	host, port, _ := net.SplitHostPort(backendURL.Host)
+	portNum, _ := net.LookupPort("tcp", port)
+
+	routes := []compose.Route{
+		{
+			Host: "example.com", PathPrefix: "/", ServiceName: host, ServicePort: portNum,
+			Timeout: &compose.Timeout{Duration: 100 * time.Millisecond, Response: "try again later"},
+		},
+	}
+	proxy := httptest.NewServer(New(router.New(routes), "http"))
+	defer proxy.Close()
+
+	get := func(path string) (*http.Response, string, time.Duration) {
+		t.Helper()
+		start := time.Now()
+		req, _ := http.NewRequest("GET", proxy.URL+path, nil)
+		req.Host = "example.com"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body) // a cut-off stream ends in an error
+		return resp, string(body), time.Since(start)
+	}
+
+	if resp, body, _ := get("/fast"); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("fast: %d %q, want 200 ok", resp.StatusCode, body)
+	}
+
+	resp, body, elapsed := get("/slow")
+	if resp.StatusCode != http.StatusGatewayTimeout || body != "try again later" {
+		t.Errorf("slow: %d %q, want 504 with the configured response", resp.StatusCode, body)
+	}
+	if elapsed > time.Second {
+		t.Errorf("slow: took %v, want about 100ms", elapsed)
+	}
+
+	// Headers arrive in time, so only the body is cut off
+	resp, body, elapsed = get("/trickle")
+	if resp.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Errorf("trickle: %d with %d bytes, want 200 and a partial body", resp.StatusCode, len(body))
+	}
+	if elapsed > time.Second {
+		t.Errorf("trickle: took %v, want the stream cut off after about 100ms", elapsed)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	// Reserve a port nothing listens on yet
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	routes := []compose.Route{
+		{
+			Host: "example.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: addr.Port,
+			Retry: &compose.Retry{Attempts: 4, Backoff: 50 * time.Millisecond},
+		},
+		{
+			Host: "deadline.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: addr.Port,
+			Retry:   &compose.Retry{Attempts: 4, Backoff: time.Second},
+			Timeout: &compose.Timeout{Duration: 200 * time.Millisecond},
+		},
+	}
+	h := New(router.New(routes), "http")
+
+	serve := func(method, host string) (*httptest.ResponseRecorder, time.Duration) {
+		start := time.Now()
+		req := httptest.NewRequest(method, "http://"+host+"/", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec, time.Since(start)
+	}
+
+	// Requests with a body aren't safe to send twice
+	if rec, _ := serve("POST", "example.com"); rec.Code != http.StatusBadGateway {
+		t.Errorf("POST: status %d, want 502 without retrying", rec.Code)
+	}
+
+	// Waiting out the backoff would pass the deadline, so give up at once
+	if rec, elapsed := serve("GET", "deadline.com"); rec.Code != http.StatusBadGateway || elapsed > time.Second {
+		t.Errorf("deadline: status %d after %v, want a prompt 502", rec.Code, elapsed)
+	}
+
+	// The backend comes up while the proxy is backing off
+	go func() {
+		time.Sleep(75 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr.String())
+		if err != nil {
+			return
+		}
+		backend := &httptest.Server{
+			Listener: ln,
+			Config:   &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })},
+		}
+		backend.Start()
+		t.Cleanup(backend.Close)
+	}()
+	if rec, _ := serve("GET", "example.com"); rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("GET: %d %q, want 200 ok after retrying", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		retryAfter string // sent with the first 503
+		want       int
+		calls      int32
+	}{
+		{"waited out", "GET", "0", http.StatusOK, 2},
+		{"http date", "GET", time.Now().UTC().Format(http.TimeFormat), http.StatusOK, 2},
+		{"too long", "GET", "30", http.StatusServiceUnavailable, 1},
+		{"no header", "GET", "", http.StatusServiceUnavailable, 1},
+		{"not idempotent", "POST", "0", http.StatusServiceUnavailable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				io.WriteString(w, "ok")
+			}))
+			defer backend.Close()
+			addr := backend.Listener.Addr().(*net.TCPAddr)
+
+			h := New(router.New([]compose.Route{{
+				Host: "example.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: addr.Port,
+				Retry: &compose.Retry{Backoff: compose.DefaultRetryBackoff, After: 5 * time.Second},
+			}}), "http")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "http://example.com/", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := calls.Load(); got != tt.calls {
+				t.Errorf("backend called %d times, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestTenantResolver(t *testing.T) {
+	newBackend := func(name string) string {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(backend.Close)
+		return strings.TrimPrefix(backend.URL, "http://")
+	}
+	acme, globex := newBackend("acme"), newBackend("globex")
+
+	var calls atomic.Int32
+	resolver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Query().Get("tenant") {
+		case "acme":
+			fmt.Fprintf(w, `{"backend": %q}`, acme)
+		case "broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer resolver.Close()
+
+	routes := []compose.Route{
+		{Host: "*.tenant.com", PathPrefix: "/", ServiceName: "app", ServicePort: 80, TenantResolver: resolver.URL},
+		{
+			Host: "*.static.com", PathPrefix: "/", ServiceName: "app", ServicePort: 80,
+			TenantResolver: "tenants.map", TenantBackends: map[string]string{"globex": globex},
+		},
+	}
+	h := New(router.New(routes), "http")
+
+	serve := func(host string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://"+host+"/", nil))
+		return rec
+	}
+
+	tests := []struct {
+		host   string
+		status int
+		body   string
+	}{
+		{"acme.tenant.com", http.StatusOK, "acme"},
+		{"acme.tenant.com", http.StatusOK, "acme"},
+		{"nobody.tenant.com", http.StatusNotFound, "unknown tenant\n"},
+		{"nobody.tenant.com", http.StatusNotFound, "unknown tenant\n"},
+		{"broken.tenant.com", http.StatusBadGateway, "tenant resolver unavailable\n"},
+		{"globex.static.com", http.StatusOK, "globex"},
+		{"acme.static.com", http.StatusNotFound, "unknown tenant\n"},
+	}
+	for _, tt := range tests {
+		if rec := serve(tt.host); rec.Code != tt.status || rec.Body.String() != tt.body {
+			t.Errorf("%s: %d %q, want %d %q", tt.host, rec.Code, rec.Body.String(), tt.status, tt.body)
+		}
+	}
+	// Known and unknown tenants are cached; failures are not
+	if got := calls.Load(); got != 3 {
+		t.Errorf("resolver called %d times, want 3", got)
+	}
+}
+
+func TestTenantResolverCoalesces(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		fmt.Fprint(w, `{"backend": "app-acme:8080"}`)
+	}))
+	defer server.Close()
+	resolver := newTenantResolver(server.URL)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if addr, err := resolver.resolve(context.Background(), "acme"); addr != "app-acme:8080" || err != nil {
+				t.Errorf("resolve = %q, %v", addr, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := calls.Load(); got != 1 {
+		t.Errorf("resolver called %d times for concurrent misses, want 1", got)
+	}
+}
+
+func TestTenantResolverBounded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(http.NotFound))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		expires time.Duration // of the answers already cached
+		want    int
+	}{
+		{"expired answers swept", -time.Second, 1},
+		{"live answers evicted", time.Minute, tenantResolverMaxEntries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newTenantResolver(server.URL)
+			for i := range tenantResolverMaxEntries {
+				resolver.cache[fmt.Sprint("tenant", i)] = tenantAnswer{err: errUnknownTenant, expires: time.Now().Add(tt.expires)}
+			}
+			if _, err := resolver.resolve(context.Background(), "random"); !errors.Is(err, errUnknownTenant) {
+				t.Fatalf("resolve = %v, want unknown tenant", err)
+			}
+			if _, ok := resolver.cache["random"]; !ok || len(resolver.cache) != tt.want {
+				t.Errorf("cache has %d answers (new one cached: %v), want %d", len(resolver.cache), ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteDialer(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via tunnel")
+	}))
+	defer backend.Close()
+
+	// A tunnel that reaches the backend whatever address is dialed
+	var dialed atomic.Value
+	dialer.Register("tunnel", func(u *url.URL) (dialer.Dialer, error) {
+		return dialFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed.Store(addr)
+			var d net.Dialer
+			return d.DialContext(ctx, network, backend.Listener.Addr().String())
+		}), nil
+	})
+
+	routes := []compose.Route{
+		{Host: "remote.com", PathPrefix: "/", ServiceName: "api.internal", ServicePort: 8080, Dial: "tunnel://office"},
+		{Host: "broken.com", PathPrefix: "/", ServiceName: "api.internal", ServicePort: 8080, Dial: "nope://office"},
+	}
+	h := New(router.New(routes), "http")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://remote.com/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "via tunnel" {
+		t.Errorf("tunnel: %d %q, want 200 via tunnel", rec.Code, rec.Body.String())
+	}
+	if got := dialed.Load(); got != "api.internal:8080" {
+		t.Errorf("dialed %v, want api.internal:8080", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://broken.com/", nil))
+	if rec.Code != http.StatusBadGateway || strings.Contains(rec.Body.String(), "dialer") {
+		t.Errorf("broken dialer: %d %q, want 502 without the logged error", rec.Code, rec.Body.String())
+	}
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (f dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+func TestClientConcurrency(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	host, port, _ := net.SplitHostPort(backendURL.Host)
+	portNum, _ := net.LookupPort("tcp", port)
+
+	routes := []compose.Route{
+		{Host: "limited.com", PathPrefix: "/", ServiceName: host, ServicePort: portNum, ClientConcurrency: 2, DirectPaths: []string{"/healthz"}},
+		{Host: "default.com", PathPrefix: "/", ServiceName: host, ServicePort: portNum},
+	}
+	h := New(router.New(routes), "http")
+	h.SetClientConcurrency(1)
+
+	serve := func(host, path, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://"+host+path, nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Fill the route's cap with slow requests from one client
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve("limited.com", "/slow", "10.0.0.1:1000")
+		}()
+		<-started
+	}
+
+	rec := serve("limited.com", "/", "10.0.0.1:1001")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over the cap: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if rec := serve("limited.com", "/", "10.0.0.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("other client: status %d, want 200", rec.Code)
+	}
+	if rec := serve("limited.com", "/healthz", "10.0.0.1:1005"); rec.Code != http.StatusOK {
+		t.Errorf("direct path: status %d, want 200", rec.Code)
+	}
+
+	// Routes without the label use the handler default
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve("default.com", "/slow", "10.0.0.1:1002")
+	}()
+	<-started
+	if rec := serve("default.com", "/", "10.0.0.1:1003"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("default cap: status %d, want 429", rec.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	if rec := serve("limited.com", "/", "10.0.0.1:1004"); rec.Code != http.StatusOK {
+		t.Errorf("after release: status %d, want 200", rec.Code)
+	}
+}
+
+func TestSaturation(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	routes := []compose.Route{{
+		Host: "example.com", PathPrefix: "/", ServiceName: "saturated", ServicePort: 80,
+		Backends: []string{backendURL.Host}, ClientConcurrency: 1,
+	}}
+	h := New(router.New(routes), "http")
+
+	load := func() ServiceLoad {
+		for _, l := range Saturation() {
+			if l.Service == "saturated" {
+				return l
+			}
+		}
+		return ServiceLoad{}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+	}()
+	<-started
+
+	if l := load(); l.InFlight != 1 || l.Waiting != 1 {
+		t.Errorf("while the backend stalls: in flight %d, waiting %d, want 1 and 1", l.InFlight, l.Waiting)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+	if l := load(); l.Shed[ShedClientConcurrency] != 1 {
+		t.Errorf("shed = %v, want one client_concurrency", l.Shed)
+	}
+
+	close(release)
+	wg.Wait()
+	if l := load(); l.InFlight != 0 || l.Waiting != 0 || l.Proxied != 1 {
+		t.Errorf("after the response: in flight %d, waiting %d, proxied %d; want 0, 0 and 1", l.InFlight, l.Waiting, l.Proxied)
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	var gotHost string
+	var gotHeader http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotHeader = r.Host, r.Header.Clone()
+		w.Header().Set("Server", "nginx/1.2.3")
+		w.Header().Set("Vary", "Accept-Encoding")
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	host, port, _ := net.SplitHostPort(backendURL.Host)
+	portNum, _ := net.LookupPort("tcp", port)
+
+	routes := []compose.Route{
+		{
+			Host: "example.com", PathPrefix: "/", ServiceName: host, ServicePort: portNum,
+			RequestHeaders: &compose.HeaderRules{
+				Remove: []string{"Cookie"},
+				Set:    map[string]string{"X-Tenant": "acme", "Host": "internal.example"},
+			},
+			ResponseHeaders: &compose.HeaderRules{
+				Remove: []string{"Server"},
+				Set:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+				Add:    map[string]string{"Vary": "Origin"},
+			},
+		},
+		{
+			Host: "down.example.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: 1,
+			ResponseHeaders: &compose.HeaderRules{Set: map[string]string{"Strict-Transport-Security": "max-age=31536000"}},
+		},
+	}
+	h := New(router.New(routes), "http")
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("X-Tenant", "spoofed")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if gotHeader.Get("Cookie") != "" || gotHeader.Get("X-Tenant") != "acme" || gotHost != "internal.example" {
+		t.Errorf("backend got Host %q, Cookie %q, X-Tenant %q", gotHost, gotHeader.Get("Cookie"), gotHeader.Get("X-Tenant"))
+	}
+	if gotHeader.Get("X-Forwarded-Host") != "example.com" {
+		t.Errorf("X-Forwarded-Host = %q, want the original host", gotHeader.Get("X-Forwarded-Host"))
+	}
+	resp := rec.Result()
+	if resp.Header.Get("Server") != "" || resp.Header.Get("Strict-Transport-Security") == "" {
+		t.Errorf("response headers = %v", resp.Header)
+	}
+	if vary := resp.Header.Values("Vary"); len(vary) != 2 {
+		t.Errorf("Vary = %v, want the backend's value plus Origin", vary)
+	}
+
+	// Proxy error pages get response headers too
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://down.example.com/", nil))
+	if rec.Code != http.StatusBadGateway || rec.Header().Get("Strict-Transport-Security") == "" {
+		t.Errorf("error response = %d %v, want 502 with Strict-Transport-Security", rec.Code, rec.Header())
+	}
+}
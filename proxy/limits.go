@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// clientLimiter caps the requests one client IP has in flight on a route
+type clientLimiter struct {
+	max int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newClientLimiter(max int) *clientLimiter {
+	return &clientLimiter{max: max, inFlight: make(map[string]int)}
+}
+
+// acquire reserves a slot for ip, reporting false if it has none left
+func (l *clientLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[ip] >= l.max {
+		return false
+	}
+	l.inFlight[ip]++
+	return true
+}
+
+func (l *clientLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[ip] <= 1 {
+		delete(l.inFlight, ip)
+		return
+	}
+	l.inFlight[ip]--
+}
+
+// SetClientConcurrency sets the in-flight requests allowed per client IP on
+// routes without liteproxy.client_concurrency (0 = unlimited)
+// Must be called before serving requests
+func (h *Handler) SetClientConcurrency(n int) {
+	h.clientConcurrency = n
+}
+
+// limiterFor returns the route's per-client limiter, or nil if unlimited
+func (h *Handler) limiterFor(route *compose.Route) *clientLimiter {
+	max := route.ClientConcurrency
+	if max == 0 {
+		max = h.clientConcurrency
+	}
+	if max <= 0 {
+		return nil
+	}
+
+	h.mu.RLock()
+	l, ok := h.limiters[route]
+	h.mu.RUnlock()
+	if ok {
+		return l
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if l, ok = h.limiters[route]; !ok {
+		l = newClientLimiter(max)
+		h.limiters[route] = l
+	}
+	return l
+}
+
+// RedirectStatus returns the status a plain HTTP request is redirected to
+// HTTPS with, or 0 if its route serves it over plain HTTP
+func (h *Handler) RedirectStatus(r *http.Request) int {
+	route := h.router.Load().Match(r.Host, r.URL.Path)
+	if route == nil {
+		return http.StatusMovedPermanently
+	}
+	if route.ServesHTTP(r.URL.Path) {
+		return 0
+	}
+	return route.RedirectStatus()
+}
+
+// clientIP returns the IP of the connection the request arrived on
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
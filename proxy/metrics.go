@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/metrics"
+	"github.com/localrivet/liteproxy/router"
+)
+
+// Per-route request metrics
+var (
+	requestsTotal = metrics.Default.NewCounterVec(
+		"liteproxy_requests_total",
+		"Proxied requests by route and status code.",
+		"host", "path", "service", "code")
+	requestDuration = metrics.Default.NewHistogramVec(
+		"liteproxy_request_duration_seconds",
+		"Time to proxy a request, including the upstream response.",
+		metrics.DefaultBuckets,
+		"host", "path", "service")
+
+	routerMatchDuration = metrics.Default.NewHistogramVec(
+		"liteproxy_router_match_duration_seconds",
+		"Time to match a request to a route.",
+		matchBuckets)
+	routerReloads = metrics.Default.NewCounterVec(
+		"liteproxy_router_reloads_total",
+		"Route table replacements from config reloads.")
+	_ = metrics.Default.NewGaugeFunc(
+		"liteproxy_router_routes",
+		"Exact host routes in the route table.",
+		func() float64 { return float64(routerStats().Routes) })
+	_ = metrics.Default.NewGaugeFunc(
+		"liteproxy_router_wildcard_routes",
+		"Wildcard host routes in the route table.",
+		func() float64 { return float64(routerStats().Wildcards) })
+	_ = metrics.Default.NewGaugeFunc(
+		"liteproxy_router_redirects",
+		"Redirect domains in the route table.",
+		func() float64 { return float64(routerStats().Redirects) })
+)
+
+// matchBuckets covers route matching, which takes well under a microsecond
+// for small tables
+var matchBuckets = []float64{1e-7, 2.5e-7, 5e-7, 1e-6, 2.5e-6, 5e-6, 1e-5, 1e-4, 1e-3}
+
+// metricsHandler is the handler whose route table the gauges report
+var metricsHandler atomic.Pointer[Handler]
+
+func routerStats() router.Stats {
+	if h := metricsHandler.Load(); h != nil {
+		return h.router.Load().Stats()
+	}
+	return router.Stats{}
+}
+
+// SetMetrics enables per-route metrics with host labels chosen by l
+// Must be called before serving requests
+func (h *Handler) SetMetrics(l *metrics.HostLabeler) {
+	h.metrics = l
+	metricsHandler.Store(h)
+}
+
+// observe records a completed request against its route
+func (h *Handler) observe(host string, route *compose.Route, status int, start time.Time) {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	label := h.metrics.Label(host, route.Host)
+	requestsTotal.Inc(label, route.Path(), route.ServiceName, strconv.Itoa(status))
+	requestDuration.Observe(time.Since(start).Seconds(), label, route.Path(), route.ServiceName)
+}
+
+// statusRecorder captures the response status and size for metrics and access logs
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush and Hijack (WebSockets)
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// ClientCertSubjectHeader tells the backend the subject of the client
+// certificate verified during the handshake. Values sent by clients are
+// removed on routes with client certificates.
+const ClientCertSubjectHeader = "X-Client-Cert-Subject"
+
+// checkClientCert enforces the route's client certificate settings,
+// writing an error and returning false if the request must not continue.
+// The handshake verified certificates for the server name it carried, so
+// a request for another host on the same connection is sent back.
+func (h *Handler) checkClientCert(w http.ResponseWriter, r *http.Request, auth *compose.ClientAuth) bool {
+	if r.TLS == nil {
+		if auth.Require {
+			h.writeError(w, r, http.StatusForbidden, "client certificate required")
+			return false
+		}
+		return true
+	}
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if !strings.EqualFold(r.TLS.ServerName, host) {
+		h.writeError(w, r, http.StatusMisdirectedRequest, "misdirected request")
+		return false
+	}
+	if len(r.TLS.VerifiedChains) == 0 {
+		if auth.Require {
+			h.writeError(w, r, http.StatusForbidden, "client certificate required")
+			return false
+		}
+		return true
+	}
+	r.Header.Set(ClientCertSubjectHeader, r.TLS.PeerCertificates[0].Subject.String())
+	return true
+}
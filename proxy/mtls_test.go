@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestClientCert(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get(ClientCertSubjectHeader))
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	route := func(host string, require bool) compose.Route {
+		return compose.Route{
+			Host: host, PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port,
+			ClientAuth:  &compose.ClientAuth{CA: "clients.crt", Require: require},
+			DirectPaths: []string{"/healthz"},
+		}
+	}
+	h := New(router.New([]compose.Route{route("secure.test", true), route("optional.test", false)}), "https")
+
+	alice := &x509.Certificate{Subject: pkix.Name{CommonName: "alice", Organization: []string{"Example"}}}
+	verified := func(sni string) *tls.ConnectionState {
+		return &tls.ConnectionState{ServerName: sni, PeerCertificates: []*x509.Certificate{alice}, VerifiedChains: [][]*x509.Certificate{{alice}}}
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		tls      *tls.ConnectionState
+		spoof    bool
+		wantCode int
+		wantBody string
+	}{
+		{name: "verified", target: "https://secure.test/", tls: verified("secure.test"), spoof: true, wantCode: 200, wantBody: "CN=alice,O=Example"},
+		{name: "verified with port", target: "https://secure.test:8443/", tls: verified("secure.test"), wantCode: 200, wantBody: "CN=alice,O=Example"},
+		{name: "other host's handshake", target: "https://secure.test/", tls: &tls.ConnectionState{ServerName: "public.test"}, wantCode: http.StatusMisdirectedRequest},
+		{name: "no server name", target: "https://secure.test/", tls: &tls.ConnectionState{}, wantCode: http.StatusMisdirectedRequest},
+		{name: "no certificate", target: "https://secure.test/", tls: &tls.ConnectionState{ServerName: "secure.test"}, wantCode: http.StatusForbidden},
+		{name: "plain http", target: "http://secure.test/", wantCode: http.StatusForbidden},
+		{name: "direct path", target: "http://secure.test/healthz", wantCode: 200},
+		{name: "direct path, forged subject", target: "http://secure.test/healthz", spoof: true, wantCode: 200},
+		{name: "direct path, optional", target: "https://optional.test/healthz", tls: &tls.ConnectionState{ServerName: "optional.test"}, spoof: true, wantCode: 200},
+		{name: "optional without certificate", target: "https://optional.test/", tls: &tls.ConnectionState{ServerName: "optional.test"}, spoof: true, wantCode: 200},
+		{name: "optional with certificate", target: "https://optional.test/", tls: verified("optional.test"), wantCode: 200, wantBody: "CN=alice,O=Example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.TLS = tt.tls
+			if tt.spoof {
+				req.Header.Set(ClientCertSubjectHeader, "spoofed")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == 200 && rec.Body.String() != tt.wantBody {
+				t.Errorf("backend saw subject %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
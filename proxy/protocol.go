@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// h2FallbackThreshold is how many consecutive HTTP/2 errors pin a backend
+// to HTTP/1.1
+const h2FallbackThreshold = 3
+
+// http1Transport never negotiates HTTP/2, for backends that mishandle it
+var http1Transport = func() *http.Transport {
+	t := sharedTransport.Clone()
+	t.ForceAttemptHTTP2 = false
+	t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper) // non-nil disables h2
+	return t
+}()
+
+// h2cTransport speaks HTTP/2 over cleartext with prior knowledge, for gRPC
+// backends that don't listen for HTTP/1.1 at all
+var h2cTransport = func() *http.Transport {
+	t := sharedTransport.Clone()
+	t.Protocols = h2cProtocols()
+	return t
+}()
+
+func h2cProtocols() *http.Protocols {
+	var p http.Protocols
+	p.SetUnencryptedHTTP2(true)
+	return &p
+}
+
+// h2Fallback tracks backends whose HTTP/2 connections keep failing
+// Backends stay pinned to HTTP/1.1 until the process restarts
+type h2Fallback struct {
+	pinned sync.Map // addr → true, read on every request
+
+	mu       sync.Mutex
+	failures map[string]int
+	failing  atomic.Int32 // len(failures), so successes skip the lock
+}
+
+func newH2Fallback() *h2Fallback {
+	return &h2Fallback{failures: make(map[string]int)}
+}
+
+func (f *h2Fallback) isPinned(addr string) bool {
+	_, ok := f.pinned.Load(addr)
+	return ok
+}
+
+// record notes the outcome of a round trip to addr
+func (f *h2Fallback) record(addr string, err error) {
+	isH2Err := err != nil && strings.Contains(err.Error(), "http2:")
+	if !isH2Err && f.failing.Load() == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !isH2Err {
+		if _, ok := f.failures[addr]; ok {
+			delete(f.failures, addr)
+			f.failing.Add(-1)
+		}
+		return
+	}
+	if _, ok := f.failures[addr]; !ok {
+		f.failing.Add(1)
+	}
+	f.failures[addr]++
+	if f.failures[addr] == h2FallbackThreshold {
+		f.pinned.Store(addr, true)
+		slog.Warn("consecutive HTTP/2 errors, falling back to HTTP/1.1", "backend", addr, "errors", f.failures[addr], "last", err)
+	}
+}
+
+// fallbackTransport uses HTTP/2 where a backend negotiates it and switches
+// the backend to HTTP/1.1 after repeated HTTP/2 errors
+type fallbackTransport struct {
+	fallback *h2Fallback
+	h2, h1   http.RoundTripper // the route's transport, and the same without HTTP/2
+}
+
+func (t fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := req.URL.Host
+	if t.fallback.isPinned(addr) {
+		return t.h1.RoundTrip(req)
+	}
+	resp, err := t.h2.RoundTrip(req)
+	t.fallback.record(addr, err)
+	return resp, err
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+var panicsRecovered = metrics.Default.NewCounterVec(
+	"liteproxy_panics_recovered_total",
+	"Panics recovered while serving a request, by service.",
+	"service")
+
+// serveRecovered is serve, answering 500 rather than dropping the
+// connection if anything on the way panics
+func (h *Handler) serveRecovered(w http.ResponseWriter, r *http.Request, info *requestInfo) {
+	sw := &startWriter{ResponseWriter: w}
+	defer func() {
+		if p := recover(); p != nil {
+			h.recovered(sw, r, info, p)
+		}
+	}()
+	h.serve(sw, r, info)
+}
+
+// startWriter notes whether the response has started, so a panic after
+// that aborts the connection instead of appending an error page
+type startWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *startWriter) WriteHeader(code int) {
+	// Informational responses (103 Early Hints) don't start the response
+	if code >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *startWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach Flush and Hijack (WebSockets)
+func (w *startWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recovered logs panic p with its stack, counts it, and answers 500 if the
+// response hasn't started. A response already under way can't be turned
+// into an error, so its connection is aborted instead. The route comes from
+// info alone: matching again could panic again.
+func (h *Handler) recovered(w *startWriter, r *http.Request, info *requestInfo, p any) {
+	// The reverse proxy aborts responses it can't finish this way on purpose
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+
+	var route string
+	service := "unknown"
+	if info != nil && info.route != nil {
+		route, service = info.route.Host+info.route.Path(), info.route.ServiceName
+	}
+	panicsRecovered.Inc(service)
+	slog.Error("panic serving request", "method", r.Method, "host", r.Host, "path", r.URL.Path,
+		"route", route, "service", service, "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+
+	if w.started {
+		panic(http.ErrAbortHandler)
+	}
+	h.writeError(w, r, http.StatusInternalServerError, "Internal Server Error")
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/metrics"
+	"github.com/localrivet/liteproxy/router"
+)
+
+// panicTransport panics in RoundTrip, or while the body is read once the
+// response has started
+type panicTransport struct {
+	value  any
+	inBody bool
+}
+
+func (t panicTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	if !t.inBody {
+		panic(t.value)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: panicBody{t.value}}, nil
+}
+
+type panicBody struct{ value any }
+
+func (b panicBody) Read([]byte) (int, error) { panic(b.value) }
+func (b panicBody) Close() error             { return nil }
+
+func TestRecoverPanic(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport panicTransport
+		metrics   bool // serve through the status recorder
+		want      int  // 0: the connection is aborted
+	}{
+		{"before response", panicTransport{value: "boom"}, false, http.StatusInternalServerError},
+		{"before response, recorded", panicTransport{value: errors.New("boom")}, true, http.StatusInternalServerError},
+		{"response started", panicTransport{value: "boom", inBody: true}, false, 0},
+		{"response started, recorded", panicTransport{value: "boom", inBody: true}, true, 0},
+		{"abort", panicTransport{value: http.ErrAbortHandler}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := compose.Route{Host: "example.com", PathPrefix: "/", ServiceName: "panicky", ServicePort: 80, Dial: "iface://lo"}
+			h := New(router.New([]compose.Route{route}), "http")
+			h.transports[transportKey{dial: route.Dial}] = tt.transport
+			if tt.metrics {
+				labeler, _ := metrics.NewHostLabeler(metrics.HostRoute, 0)
+				h.SetMetrics(labeler)
+			}
+			before := panicsRecovered.Value("panicky")
+
+			w := httptest.NewRecorder()
+			var aborted bool
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						aborted = p == http.ErrAbortHandler
+						if !aborted {
+							t.Fatalf("unexpected panic %v", p)
+						}
+					}
+				}()
+				h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+			}()
+
+			if tt.want == 0 {
+				if !aborted {
+					t.Errorf("status = %d, want the connection aborted", w.Code)
+				}
+			} else {
+				body, _ := io.ReadAll(w.Body)
+				if aborted || w.Code != tt.want || !strings.Contains(string(body), "Internal Server Error") {
+					t.Errorf("got %d %q (aborted %v), want %d", w.Code, body, aborted, tt.want)
+				}
+			}
+			wantCount := uint64(1)
+			if tt.transport.value == http.ErrAbortHandler {
+				wantCount = 0
+			}
+			if got := panicsRecovered.Value("panicky") - before; got != wantCount {
+				t.Errorf("liteproxy_panics_recovered_total = %d, want %d", got, wantCount)
+			}
+		})
+	}
+}
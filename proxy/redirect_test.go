@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestRedirectStatusAndHSTS(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=0") // overridden by the route
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	h := New(router.New([]compose.Route{
+		{
+			Host: "www.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port,
+			HTTPSRedirect: &compose.HTTPSRedirect{Status: http.StatusPermanentRedirect, Exempt: []string{"/status/*"}},
+			HSTS:          &compose.HSTS{MaxAge: 24 * time.Hour, IncludeSubdomains: true},
+		},
+		{
+			Host: "internal.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port,
+			HTTPSRedirect: &compose.HTTPSRedirect{Disabled: true},
+		},
+		{Host: "plain.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port},
+	}), "https")
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"http://www.test/", http.StatusPermanentRedirect},
+		{"http://www.test/status/ok", 0},
+		{"http://internal.test/anything", 0},
+		{"http://plain.test/", http.StatusMovedPermanently},
+		{"http://unknown.test/", http.StatusMovedPermanently},
+	}
+	for _, tt := range tests {
+		if got := h.RedirectStatus(httptest.NewRequest("GET", tt.target, nil)); got != tt.want {
+			t.Errorf("RedirectStatus(%s) = %d, want %d", tt.target, got, tt.want)
+		}
+	}
+
+	// HSTS is only sent over HTTPS, where it replaces the backend's
+	req := httptest.NewRequest("GET", "https://www.test/", nil)
+	req.TLS = &tls.ConnectionState{ServerName: "www.test"}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Values("Strict-Transport-Security"); len(got) != 1 || got[0] != "max-age=86400; includeSubDomains" {
+		t.Errorf("HTTPS Strict-Transport-Security = %q, want the route's", got)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://www.test/status/ok", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=0" {
+		t.Errorf("plain HTTP Strict-Transport-Security = %q, want the backend's", got)
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+var regionFailovers = metrics.Default.NewCounterVec(
+	"liteproxy_region_failovers_total",
+	"Requests sent to another region because their own region's backend was unhealthy, by service and preferred region.",
+	"service", "region")
+
+// regionBackend returns the backend for the region the request's header
+// names, else the first healthy one in failover order, or "" if every
+// region is unhealthy
+func (h *Handler) regionBackend(route *compose.Route, r *http.Request) string {
+	rg := route.Regions
+	healthy := h.healthyFunc(route)
+	up := func(addr string) bool { return healthy == nil || healthy(addr) }
+
+	region := rg.Region(r.Header.Get(rg.Header))
+	if region == "" {
+		region = rg.Backends[0].Region
+	}
+	for _, b := range rg.Backends {
+		if b.Region == region && up(b.Addr) {
+			return b.Addr
+		}
+	}
+	for _, b := range rg.Backends {
+		if up(b.Addr) {
+			regionFailovers.Inc(route.ServiceName, region)
+			return b.Addr
+		}
+	}
+	return ""
+}
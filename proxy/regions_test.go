@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/health"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestRegions(t *testing.T) {
+	backend := func(name string) string {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(s.Close)
+		u, _ := url.Parse(s.URL)
+		return u.Host
+	}
+	eu, us := backend("eu"), backend("us")
+	routes := []compose.Route{{
+		Host: "api.com", PathPrefix: "/", ServiceName: "api-regions", ServicePort: 80,
+		Backends:     []string{eu, us},
+		PassiveCheck: &compose.PassiveCheck{MaxFails: 1, FailTimeout: time.Hour},
+		Regions: &compose.Regions{
+			Header:    "Cf-Ipcountry",
+			Backends:  []compose.RegionBackend{{Region: "eu", Addr: eu}, {Region: "us", Addr: us}},
+			Countries: map[string]string{"DE": "eu", "US": "us", "CA": "us"},
+		},
+	}}
+	checker := health.NewChecker()
+	defer checker.Stop()
+	checker.Update(routes)
+	h := New(router.New(routes), "http")
+	h.SetHealthChecker(checker)
+
+	get := func(country string) string {
+		req := httptest.NewRequest("GET", "http://api.com/", nil)
+		if country != "" {
+			req.Header.Set("CF-IPCountry", country)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec.Result().Status
+		}
+		return rec.Body.String()
+	}
+
+	tests := map[string]string{
+		"DE": "eu",
+		"ca": "us",
+		"US": "us",
+		"us": "us", // region names work as well as countries
+		"JP": "eu", // unknown places go to the first region
+		"":   "eu",
+	}
+	for country, want := range tests {
+		if got := get(country); got != want {
+			t.Errorf("country %q served by %s, want %s", country, got, want)
+		}
+	}
+
+	// Failover to the other region while the preferred one is down
+	checker.Report(us, true)
+	if got := get("US"); got != "eu" {
+		t.Errorf("with us down: served by %s, want eu", got)
+	}
+	if n := regionFailovers.Value("api-regions", "us"); n != 1 {
+		t.Errorf("failovers counted: %d, want 1", n)
+	}
+	checker.Report(eu, true)
+	if got := get("DE"); got != "503 Service Unavailable" {
+		t.Errorf("with every region down: %s, want 503", got)
+	}
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryTransport retries round trips the backend refused to connect, and
+// once more after a 503 whose Retry-After is at most after. Only idempotent
+// requests whose body can be replayed are retried, and never past the
+// request's deadline.
+type retryTransport struct {
+	http.RoundTripper
+	retries int
+	backoff time.Duration
+	after   time.Duration
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if !retryable(req) {
+		return resp, err
+	}
+
+	ctx := req.Context()
+	backoff := t.backoff
+	for i := 0; i < t.retries && isDialError(err); i++ {
+		// Give up now rather than sleep through the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		var ok bool
+		if req, ok = replay(req); !ok {
+			return nil, err
+		}
+		resp, err = t.RoundTripper.RoundTrip(req)
+	}
+
+	if err != nil || t.after <= 0 || resp.StatusCode != http.StatusServiceUnavailable {
+		return resp, err
+	}
+	// The backend is restarting and says when to come back; if that's soon,
+	// wait rather than pass the 503 on
+	wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok || wait > t.after {
+		return resp, err
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		return resp, err
+	}
+	timer := time.NewTimer(wait)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return resp, err
+	case <-timer.C:
+	}
+	retry, ok := replay(req)
+	if !ok {
+		return resp, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return t.RoundTripper.RoundTrip(retry)
+}
+
+// replay returns a copy of req to send again, with a fresh body
+func replay(req *http.Request) (*http.Request, bool) {
+	if req.GetBody == nil {
+		return req, true
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	req = req.Clone(req.Context())
+	req.Body = body
+	return req, true
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP
+// date, into how long to wait from now
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// retryable reports whether req may safely be sent again
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// isDialError reports whether err means the connection was never made, so
+// the backend can't have seen the request
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
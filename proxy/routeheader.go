@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// RouteHeader tells backends of routes with liteproxy.route_header which
+// route delivered the request, replacing any value the client sent
+const RouteHeader = "X-Liteproxy-Route"
+
+// routeHeaderValue describes route as a structured field dictionary
+// (RFC 8941), e.g.
+//
+//	route="shop.example.com/api", service="api", match=prefix, path="/api", strip
+//
+// project and tenant are added when the route has them, and strip only
+// when the prefix is removed before proxying.
+func routeHeaderValue(route *compose.Route) string {
+	var b strings.Builder
+	b.WriteString("route=")
+	writeSFString(&b, route.Key())
+	b.WriteString(", service=")
+	writeSFString(&b, route.ServiceName)
+	if route.Project != "" {
+		b.WriteString(", project=")
+		writeSFString(&b, route.Project)
+	}
+	if route.Tenant != "" {
+		b.WriteString(", tenant=")
+		writeSFString(&b, route.Tenant)
+	}
+	match, path := "prefix", route.PathPrefix
+	switch {
+	case route.PathExact != "":
+		match, path = "exact", route.PathExact
+	case route.PathRegex != "":
+		match, path = "regex", route.PathRegex
+	}
+	b.WriteString(", match=")
+	b.WriteString(match)
+	b.WriteString(", path=")
+	writeSFString(&b, path)
+	if route.StripPrefix && match == "prefix" && route.PathPrefix != "/" {
+		b.WriteString(", strip")
+	}
+	return b.String()
+}
+
+// writeSFString writes s as a structured field string, dropping characters
+// outside printable ASCII, which the format can't carry
+func writeSFString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
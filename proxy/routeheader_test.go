@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestRouteHeaderValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		route compose.Route
+		want  string
+	}{
+		{
+			name:  "prefix stripped",
+			route: compose.Route{Host: "shop.com", PathPrefix: "/api", StripPrefix: true, ServiceName: "api"},
+			want:  `route="shop.com/api", service="api", match=prefix, path="/api", strip`,
+		},
+		{
+			name:  "root prefix",
+			route: compose.Route{Host: "shop.com", PathPrefix: "/", StripPrefix: true, ServiceName: "web", Project: "shop", Tenant: "acme"},
+			want:  `route="shop.com/", service="web", project="shop", tenant="acme", match=prefix, path="/"`,
+		},
+		{
+			name:  "exact",
+			route: compose.Route{Host: "shop.com", PathExact: "/health", ServiceName: "web"},
+			want:  `route="shop.com=/health", service="web", match=exact, path="/health"`,
+		},
+		{
+			name:  "regex escaped",
+			route: compose.Route{Host: "shop.com", PathRegex: `^/v\d+/"x"`, ServiceName: "web"},
+			want:  `route="shop.com~^/v\\d+/\"x\"", service="web", match=regex, path="^/v\\d+/\"x\""`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := routeHeaderValue(&tt.route); got != tt.want {
+				t.Errorf("routeHeaderValue() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteHeader(t *testing.T) {
+	var got []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Values(RouteHeader)
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	h := New(router.New([]compose.Route{
+		{Host: "on.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum, RouteHeader: true},
+		{Host: "off.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum},
+	}), "http")
+
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"on.com", []string{`route="on.com/", service="127.0.0.1", match=prefix, path="/"`}},
+		{"off.com", []string{"forged"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://"+tt.host+"/", nil)
+			req.Header.Set(RouteHeader, "forged")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+			if len(got) != len(tt.want) || got[0] != tt.want[0] {
+				t.Errorf("%s = %q, want %q", RouteHeader, got, tt.want)
+			}
+		})
+	}
+}
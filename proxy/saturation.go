@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+// Reasons a request is shed before reaching a backend
+const (
+	ShedClientConcurrency = "client_concurrency"
+	ShedNoHealthyBackend  = "no_healthy_backend"
+	ShedScaledToZero      = "scaled_to_zero"
+	ShedStreamConnections = "stream_connections"
+)
+
+// Saturation metrics, recorded whether or not the metrics endpoint is on
+var (
+	requestsInFlight = metrics.Default.NewGaugeVec(
+		"liteproxy_requests_in_flight",
+		"Requests being proxied, by service.",
+		"service")
+	requestsWaiting = metrics.Default.NewGaugeVec(
+		"liteproxy_requests_waiting",
+		"Requests waiting for the backend to start responding, by service.",
+		"service")
+	requestsShed = metrics.Default.NewCounterVec(
+		"liteproxy_requests_shed_total",
+		"Requests rejected before reaching a backend, by service and reason.",
+		"service", "reason")
+	requestsProxied = metrics.Default.NewCounterVec(
+		"liteproxy_requests_proxied_total",
+		"Requests sent to a backend, by service.",
+		"service")
+)
+
+// ServiceLoad is how busy a service looks from the proxy
+type ServiceLoad struct {
+	Service  string            `json:"service"`
+	InFlight int64             `json:"in_flight"`
+	Waiting  int64             `json:"waiting"` // sent to a backend, no response headers yet
+	Shed     map[string]uint64 `json:"shed"`    // reason → requests rejected since startup
+	Proxied  uint64            `json:"proxied"` // requests sent to a backend since startup
+}
+
+// Saturation returns the load of every service that has received requests,
+// sorted by service
+func Saturation() []ServiceLoad {
+	byService := make(map[string]*ServiceLoad)
+	load := func(service string) *ServiceLoad {
+		l, ok := byService[service]
+		if !ok {
+			l = &ServiceLoad{Service: service, Shed: map[string]uint64{}}
+			byService[service] = l
+		}
+		return l
+	}
+	requestsInFlight.Each(func(values []string, v int64) { load(values[0]).InFlight = v })
+	requestsWaiting.Each(func(values []string, v int64) { load(values[0]).Waiting = v })
+	requestsShed.Each(func(values []string, v uint64) { load(values[0]).Shed[values[1]] = v })
+	requestsProxied.Each(func(values []string, v uint64) { load(values[0]).Proxied = v })
+
+	loads := make([]ServiceLoad, 0, len(byService))
+	for _, l := range byService {
+		loads = append(loads, *l)
+	}
+	slices.SortFunc(loads, func(a, b ServiceLoad) int { return strings.Compare(a.Service, b.Service) })
+	return loads
+}
+
+// waitWriter counts a request as waiting until the backend's response
+// headers are written to the client
+type waitWriter struct {
+	http.ResponseWriter
+	service string
+	started bool
+}
+
+func (w *waitWriter) stopWaiting() {
+	if !w.started {
+		w.started = true
+		requestsWaiting.Add(-1, w.service)
+	}
+}
+
+func (w *waitWriter) WriteHeader(code int) {
+	if code >= http.StatusOK {
+		w.stopWaiting()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *waitWriter) Write(b []byte) (int, error) {
+	w.stopWaiting()
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach Flush and Hijack (WebSockets)
+func (w *waitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/health"
+)
+
+// zeroCheckInterval is how long a route's scaled to zero state is trusted
+// before its backends are looked up again
+const zeroCheckInterval = 2 * time.Second
+
+// zeroPollInterval is how often a waiting request looks for a replica
+const zeroPollInterval = 250 * time.Millisecond
+
+// zeroWatch caches whether a route's service is scaled to zero, refreshing
+// in the background like dnsPool
+type zeroWatch struct {
+	check func(ctx context.Context) bool
+
+	zero       atomic.Bool
+	expires    atomic.Int64 // unix nanos
+	refreshing atomic.Bool
+	once       sync.Once
+
+	started atomic.Int64 // unix nanos the start hook last ran
+}
+
+// Zero reports whether the service was scaled to zero when last checked.
+// The first call checks synchronously.
+func (z *zeroWatch) Zero() bool {
+	z.once.Do(z.refresh)
+	if time.Now().UnixNano() > z.expires.Load() && z.refreshing.CompareAndSwap(false, true) {
+		go func() {
+			defer z.refreshing.Store(false)
+			z.refresh()
+		}()
+	}
+	return z.zero.Load()
+}
+
+func (z *zeroWatch) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+	z.set(z.check(ctx))
+}
+
+func (z *zeroWatch) set(zero bool) {
+	z.zero.Store(zero)
+	z.expires.Store(time.Now().Add(zeroCheckInterval).UnixNano())
+}
+
+// resolvesNone reports whether every backend name of the route is unknown
+// to DNS, which is how Docker answers for a service with no running
+// containers. Backends given as IP addresses, and lookups failing any other
+// way, never count as scaled to zero.
+func (h *Handler) resolvesNone(ctx context.Context, route *compose.Route) bool {
+	for _, addr := range route.Addrs() {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return false
+		}
+		_, err = h.lookupHost(ctx, host)
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return false
+		}
+	}
+	return true
+}
+
+// zeroWatchFor returns the cached scaled to zero state of the route
+func (h *Handler) zeroWatchFor(route *compose.Route) *zeroWatch {
+	h.mu.RLock()
+	z, ok := h.zeros[route]
+	h.mu.RUnlock()
+	if ok {
+		return z
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if z, ok = h.zeros[route]; !ok {
+		z = &zeroWatch{check: func(ctx context.Context) bool { return h.resolvesNone(ctx, route) }}
+		h.zeros[route] = z
+	}
+	return z
+}
+
+// removedScaledToZero reports whether the route is taken out of routing
+// while its service is scaled to zero, letting a less specific route, such
+// as a catch-all, serve its requests
+func (h *Handler) removedScaledToZero(route *compose.Route) bool {
+	return route.ScaledToZero != nil && route.ScaledToZero.Action == compose.ScaledToZeroRemove &&
+		h.zeroWatchFor(route).Zero()
+}
+
+// serveScaledToZero answers for a route whose service has no running
+// containers, as liteproxy.scaled_to_zero says. It reports whether the
+// request should still be proxied, after waiting for a replica.
+func (h *Handler) serveScaledToZero(w http.ResponseWriter, r *http.Request, route *compose.Route) bool {
+	z := h.zeroWatchFor(route)
+	if !z.Zero() {
+		return true
+	}
+
+	// Removed routes were already skipped when matching; one only gets here
+	// if its service scaled down since, and is answered as unavailable
+	if route.ScaledToZero.Action == compose.ScaledToZeroWait {
+		if route.ScaledToZero.Start != "" {
+			h.startService(route, z)
+		}
+		if h.waitForBackend(r.Context(), route, z) {
+			return true
+		}
+	}
+	requestsShed.Inc(route.ServiceName, ShedScaledToZero)
+	w.Header().Set("Retry-After", "5")
+	h.writeError(w, r, http.StatusServiceUnavailable, "service is scaled to zero")
+	return false
+}
+
+// waitForBackend polls the route's backends until one passes the route's
+// health check, or accepts connections if it has none, for at most the
+// route's wait or until the client goes away
+func (h *Handler) waitForBackend(ctx context.Context, route *compose.Route, z *zeroWatch) bool {
+	ctx, cancel := context.WithTimeout(ctx, route.ScaledToZero.Wait)
+	defer cancel()
+	ticker := time.NewTicker(zeroPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		if backendReady(ctx, route) {
+			z.set(false)
+			return true
+		}
+	}
+}
+
+// backendReady reports whether any of the route's backends passes its
+// health check, or a TCP check if it has none
+func backendReady(ctx context.Context, route *compose.Route) bool {
+	check := compose.HealthCheck{Type: compose.HealthTCP}
+	if route.HealthCheck != nil {
+		check = *route.HealthCheck
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+	for _, addr := range route.Addrs() {
+		if health.Probe(ctx, addr, check) == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestScaledToZero(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	upPort, _ := net.LookupPort("tcp", port)
+	downPort := unusedPort(t)
+
+	tests := []struct {
+		name   string
+		action string
+		port   int // a replica listens here while the request waits, or nothing does
+		want   int
+	}{
+		{"unavailable", compose.ScaledToZeroUnavailable, upPort, http.StatusServiceUnavailable},
+		{"remove", compose.ScaledToZeroRemove, upPort, http.StatusNotFound},
+		{"wait times out", compose.ScaledToZeroWait, downPort, http.StatusServiceUnavailable},
+		{"wait for replica", compose.ScaledToZeroWait, upPort, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// localhost is dialed as usual, but looks scaled to zero to the first lookup
+			h := New(router.New([]compose.Route{{
+				Host: "example.com", PathPrefix: "/", ServiceName: "localhost", ServicePort: tt.port,
+				ScaledToZero: &compose.ScaledToZero{Action: tt.action, Wait: 600 * time.Millisecond},
+			}}), "http")
+			h.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestScaledToZeroRemoveFallsThrough(t *testing.T) {
+	catchAll := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "catch-all "+r.URL.Path)
+	}))
+	defer catchAll.Close()
+	_, port, _ := net.SplitHostPort(catchAll.Listener.Addr().String())
+	catchAllPort, _ := net.LookupPort("tcp", port)
+
+	h := New(router.New([]compose.Route{
+		{
+			Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080,
+			ScaledToZero: &compose.ScaledToZero{Action: compose.ScaledToZeroRemove},
+		},
+		{Host: "example.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: catchAllPort},
+	}), "http")
+	h.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	// The scaled down route is left out, so the catch-all answers
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/api/users", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "catch-all /api/users" {
+		t.Errorf("response = %d %q, want the catch-all route", w.Code, w.Body.String())
+	}
+}
+
+func TestScaledToZeroStart(t *testing.T) {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(unusedPort(t)))
+
+	// The webhook starts the backend, once however many requests wait
+	var starts atomic.Int32
+	var started []byte
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		starts.Add(1)
+		started, _ = io.ReadAll(r.Body)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		backend := &httptest.Server{Listener: ln, Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}}
+		backend.Start()
+		t.Cleanup(backend.Close)
+	}))
+	defer hook.Close()
+
+	_, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	h := New(router.New([]compose.Route{{
+		Host: "example.com", PathPrefix: "/", ServiceName: "localhost", ServicePort: portNum,
+		ScaledToZero: &compose.ScaledToZero{Action: compose.ScaledToZeroWait, Wait: 2 * time.Second, Start: hook.URL},
+	}}), "http")
+	h.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200 once started", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := starts.Load(); n != 1 {
+		t.Errorf("webhook called %d times, want 1", n)
+	}
+	if !strings.Contains(string(started), `"service":"localhost"`) {
+		t.Errorf("webhook body = %s, want the service", started)
+	}
+}
+
+// unusedPort returns a port nothing listens on
+func unusedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestResolvesNone(t *testing.T) {
+	h := New(router.New(nil), "http")
+	h.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		switch host {
+		case "gone":
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		case "slow":
+			return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+	tests := []struct {
+		backends []string
+		want     bool
+	}{
+		{[]string{"gone:80"}, true},
+		{[]string{"gone:80", "web:80"}, false},
+		{[]string{"slow:80"}, false},
+		{[]string{"10.0.0.1:80"}, false},
+	}
+	for _, tt := range tests {
+		route := &compose.Route{Backends: tt.backends}
+		if got := h.resolvesNone(context.Background(), route); got != tt.want {
+			t.Errorf("resolvesNone(%v) = %v, want %v", tt.backends, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// scanTimeout bounds one scan, including sending the body to the scanner
+const scanTimeout = time.Minute
+
+// spoolMemory is how much of a scanned body is kept in memory before
+// spilling to a temporary file
+var spoolMemory = 1 << 20
+
+var (
+	errInfected    = errors.New("rejected by content scan")
+	errScanTooBig  = errors.New("body too large to scan")
+	errScanPartial = errors.New("scanner answered before reading the whole body")
+)
+
+var scanClient = &http.Client{Timeout: scanTimeout}
+
+// scanBody streams the request body to the route's scanner while spooling
+// a copy. The copy replaces r.Body only when the scanner finds it clean, so
+// no byte reaches the backend before the verdict. The caller must close
+// the returned spool.
+func scanBody(r *http.Request, s *compose.Scan) (*spool, error) {
+	if r.ContentLength > int64(s.MaxSize) {
+		return nil, errScanTooBig
+	}
+	sp := &spool{}
+	body := io.TeeReader(io.LimitReader(r.Body, int64(s.MaxSize)+1), sp)
+
+	ctx, cancel := context.WithTimeout(r.Context(), scanTimeout)
+	defer cancel()
+	u, err := url.Parse(s.URL)
+	if err == nil {
+		if u.Scheme == "icap" {
+			err = icapScan(ctx, u, r, body)
+		} else {
+			err = httpScan(ctx, s.URL, r, body)
+		}
+	}
+	if err == nil {
+		// Whatever the scanner didn't read wasn't scanned
+		if n, _ := io.Copy(io.Discard, body); n > 0 {
+			err = errScanPartial
+		}
+	}
+	if err == nil && sp.size > int64(s.MaxSize) {
+		err = errScanTooBig
+	}
+	if sp.err != nil {
+		err = sp.err
+	}
+	if err != nil {
+		sp.Close()
+		return nil, err
+	}
+	return sp, sp.rewind()
+}
+
+// httpScan POSTs the body to a scanning endpoint: 2xx means clean and 403 infected
+func httpScan(ctx context.Context, scanURL string, r *http.Request, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, scanURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = r.ContentLength
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	resp, err := scanClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusForbidden:
+		return errInfected
+	default:
+		return fmt.Errorf("scanner returned %s", resp.Status)
+	}
+}
+
+// icapScan sends the request to an ICAP REQMOD service (RFC 3507). 204 No
+// Content means clean; a modified request (200) means the service blocked it.
+func icapScan(ctx context.Context, u *url.URL, r *http.Request, body io.Reader) error {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The encapsulated HTTP request header, then the body in chunks
+	var hdr bytes.Buffer
+	fmt.Fprintf(&hdr, "%s %s HTTP/1.1\r\nHost: %s\r\n", r.Method, r.URL.RequestURI(), r.Host)
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		fmt.Fprintf(&hdr, "Content-Type: %s\r\n", ct)
+	}
+	hdr.WriteString("\r\n")
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "REQMOD %s ICAP/1.0\r\nHost: %s\r\nAllow: 204\r\nEncapsulated: req-hdr=0, req-body=%d\r\n\r\n",
+		u.String(), u.Host, hdr.Len())
+	w.Write(hdr.Bytes())
+	writeErr := writeChunked(w, body)
+	if writeErr == nil {
+		writeErr = w.Flush()
+	}
+
+	// The service may answer early and close, so read even if writing failed
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	status, err := tp.ReadLine()
+	if err != nil {
+		if writeErr != nil {
+			return writeErr
+		}
+		return fmt.Errorf("reading ICAP response: %w", err)
+	}
+	header, _ := tp.ReadMIMEHeader()
+	_, code, _ := strings.Cut(status, " ")
+	code, _, _ = strings.Cut(code, " ")
+	switch code {
+	case "204":
+		return writeErr
+	case "200":
+		if v := cmp.Or(header.Get("X-Infection-Found"), header.Get("X-Virus-ID")); v != "" {
+			return fmt.Errorf("%w: %s", errInfected, v)
+		}
+		return errInfected
+	default:
+		return fmt.Errorf("ICAP service returned %q", status)
+	}
+}
+
+// writeChunked writes body with HTTP chunked encoding, as ICAP requires
+func writeChunked(w *bufio.Writer, body io.Reader) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			if _, werr := w.WriteString("\r\n"); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			_, err = w.WriteString("0\r\n\r\n")
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// spool holds a scanned body, in memory up to spoolMemory and in a
+// temporary file past that
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+	err  error // first error spilling to disk
+
+	r io.Reader // reads the body back after rewind
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	s.size += int64(len(p))
+	if s.err != nil {
+		return len(p), nil
+	}
+	if s.file == nil && s.buf.Len()+len(p) > spoolMemory {
+		if s.file, s.err = os.CreateTemp("", "liteproxy-scan-*"); s.err != nil {
+			return len(p), nil
+		}
+		os.Remove(s.file.Name()) // unlinked: the data goes when the file is closed
+		_, s.err = s.file.Write(s.buf.Bytes())
+		s.buf = bytes.Buffer{}
+	}
+	if s.file != nil {
+		if s.err == nil {
+			_, s.err = s.file.Write(p)
+		}
+		return len(p), nil
+	}
+	return s.buf.Write(p)
+}
+
+// rewind prepares the spool to be read as the request body
+func (s *spool) rewind() error {
+	if s.file == nil {
+		s.r = &s.buf
+		return nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.r = s.file
+	return nil
+}
+
+func (s *spool) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *spool) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// setBody replaces the request body with a scanned spool
+func setBody(r *http.Request, s *spool) {
+	r.Body = io.NopCloser(s) // the spool is closed by the caller once the request is done
+	r.ContentLength = s.size
+	r.TransferEncoding = nil
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+// scanRoutes proxies to a backend that echoes request bodies, scanning
+// uploads with scanURL
+func scanRoutes(t *testing.T, scanURL string) *Handler {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	t.Cleanup(backend.Close)
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	return New(router.New([]compose.Route{{
+		Host: "upload.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+		Scan: &compose.Scan{URL: scanURL, MaxSize: 4 << 20},
+	}}), "http")
+}
+
+func upload(h *Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "http://upload.com/files", strings.NewReader(body)))
+	return rec
+}
+
+func TestScanHTTP(t *testing.T) {
+	scanner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "EICAR") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer scanner.Close()
+
+	old := spoolMemory
+	spoolMemory = 1024 // exercise the temporary file
+	defer func() { spoolMemory = old }()
+	h := scanRoutes(t, scanner.URL)
+
+	large := strings.Repeat("x", 100<<10)
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"clean", "hello", http.StatusOK},
+		{"clean spooled to disk", large, http.StatusOK},
+		{"infected", "X5O!P%@AP EICAR test file", http.StatusForbidden},
+		{"too large", strings.Repeat("x", 5<<20), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := upload(h, tt.body)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != tt.body {
+				t.Errorf("backend received %d bytes, want %d", rec.Body.Len(), len(tt.body))
+			}
+		})
+	}
+
+	// Requests without a body aren't scanned
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://upload.com/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestScanHTTPFailsClosed(t *testing.T) {
+	scanner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer scanner.Close()
+	if rec := upload(scanRoutes(t, scanner.URL), "hello"); rec.Code != http.StatusBadGateway {
+		t.Errorf("scanner error: status = %d, want 502", rec.Code)
+	}
+
+	scanner.Close()
+	if rec := upload(scanRoutes(t, scanner.URL), "hello"); rec.Code != http.StatusBadGateway {
+		t.Errorf("scanner down: status = %d, want 502", rec.Code)
+	}
+}
+
+// serveICAP runs a fake ICAP REQMOD service that blocks bodies containing EICAR
+func serveICAP(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				// ICAP header, then the encapsulated HTTP header
+				for range 2 {
+					for {
+						line, err := br.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if line == "\r\n" {
+							break
+						}
+					}
+				}
+				body, err := io.ReadAll(httputil.NewChunkedReader(br))
+				if err != nil {
+					return
+				}
+				br.ReadString('\n') // the final CRLF
+				if strings.Contains(string(body), "EICAR") {
+					io.WriteString(conn, "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\nEncapsulated: null-body=0\r\n\r\n")
+					return
+				}
+				io.WriteString(conn, "ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n")
+			}()
+		}
+	}()
+	return "icap://" + ln.Addr().String() + "/avscan"
+}
+
+func TestScanICAP(t *testing.T) {
+	h := scanRoutes(t, serveICAP(t))
+
+	if rec := upload(h, "hello"); rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("clean: %d %q, want 200 hello", rec.Code, rec.Body.String())
+	}
+	if rec := upload(h, "X5O!P%@AP EICAR test file"); rec.Code != http.StatusForbidden {
+		t.Errorf("infected: status = %d, want 403", rec.Code)
+	}
+}
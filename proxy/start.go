@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/errlog"
+)
+
+// SetStartCommands sets whether liteproxy.scaled_to_zero.start may run
+// commands. Webhooks are always allowed.
+// Must be called before serving requests
+func (h *Handler) SetStartCommands(enabled bool) {
+	h.startCommands = enabled
+}
+
+// startService runs the route's liteproxy.scaled_to_zero.start hook in the
+// background. It runs at most once per wait, so a burst of requests to a
+// stopped service starts it once.
+func (h *Handler) startService(route *compose.Route, z *zeroWatch) {
+	s := route.ScaledToZero
+	now := time.Now().UnixNano()
+	last := z.started.Load()
+	if now-last < int64(s.Wait) || !z.started.CompareAndSwap(last, now) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Wait)
+		defer cancel()
+		var err error
+		switch {
+		case s.StartURL():
+			err = startWebhook(ctx, s.Start, route)
+		case !h.startCommands:
+			err = errors.New("start commands are disabled (set LITEPROXY_START_COMMANDS=true)")
+		default:
+			err = startCommand(ctx, s.Start, route)
+		}
+		if err != nil {
+			errlog.Printf("start", route.Key(), "starting %s for %s: %v", route.ServiceName, route.Key(), err)
+			return
+		}
+		slog.Info("started service", "service", route.ServiceName, "route", route.Key())
+	}()
+}
+
+// startCommand runs command, split on spaces without a shell, with the
+// route described in LITEPROXY_* environment variables
+func startCommand(ctx context.Context, command string, route *compose.Route) error {
+	args := strings.Fields(command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"LITEPROXY_SERVICE="+route.ServiceName,
+		"LITEPROXY_PROJECT="+route.Project,
+		"LITEPROXY_HOST="+route.Host,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return fmt.Errorf("%w: %s", err, out[max(0, len(out)-200):])
+		}
+		return err
+	}
+	return nil
+}
+
+// startRequest is the body POSTed to start webhooks
+type startRequest struct {
+	Service string `json:"service"`
+	Project string `json:"project,omitempty"`
+	Host    string `json:"host"`
+	Route   string `json:"route"`
+}
+
+// startWebhook POSTs the route to url, expecting a 2xx response
+func startWebhook(ctx context.Context, url string, route *compose.Route) error {
+	body, _ := json.Marshal(startRequest{
+		Service: route.ServiceName,
+		Project: route.Project,
+		Host:    route.Host,
+		Route:   route.Key(),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/localrivet/liteproxy/compose"
+)
+
+// stickyToken is the cookie value naming a backend, opaque so clients
+// don't learn internal addresses
+func stickyToken(addr string) string {
+	sum := sha256.Sum256([]byte(addr))
+	return hex.EncodeToString(sum[:8])
+}
+
+// stickyBackend returns the backend the client's affinity cookie pins it
+// to, or "" if there's no cookie or that backend is gone or unhealthy
+func (h *Handler) stickyBackend(route *compose.Route, r *http.Request) string {
+	if route.StickyCookie == "" {
+		return ""
+	}
+	c, err := r.Cookie(route.StickyCookie)
+	if err != nil {
+		return ""
+	}
+	healthy := h.healthyFunc(route)
+	for _, addr := range h.backendAddrs(route) {
+		if stickyToken(addr) == c.Value {
+			if healthy != nil && !healthy(addr) {
+				return ""
+			}
+			return addr
+		}
+	}
+	return ""
+}
+
+// setStickyCookie pins the client to addr for the rest of its session
+func setStickyCookie(w http.ResponseWriter, r *http.Request, route *compose.Route, addr string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     route.StickyCookie,
+		Value:    stickyToken(addr),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestSticky(t *testing.T) {
+	var backends []string
+	for _, name := range []string{"a", "b", "c"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+		defer srv.Close()
+		backends = append(backends, srv.Listener.Addr().String())
+	}
+	_, port, _ := net.SplitHostPort(backends[0])
+	portNum, _ := net.LookupPort("tcp", port)
+	h := New(router.New([]compose.Route{{
+		Host: "app.com", PathPrefix: "/", ServiceName: "a", ServicePort: portNum,
+		Backends: backends, StickyCookie: "lp_srv",
+	}}), "http")
+
+	get := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://app.com/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get(nil)
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "lp_srv" || !cookies[0].HttpOnly {
+		t.Fatalf("first response cookies = %v, want an HttpOnly lp_srv cookie", cookies)
+	}
+
+	// Round robin would move on; the cookie keeps the client in place
+	for range 5 {
+		rec := get(cookies[0])
+		if rec.Body.String() != first.Body.String() {
+			t.Fatalf("pinned request went to %q, want %q", rec.Body.String(), first.Body.String())
+		}
+		if c := rec.Result().Cookies(); len(c) != 0 {
+			t.Errorf("pinned response re-set cookies %v", c)
+		}
+	}
+
+	// A cookie for a backend that's gone gets a fresh pick and cookie
+	rec := get(&http.Cookie{Name: "lp_srv", Value: stickyToken("10.0.0.99:80")})
+	if rec.Code != http.StatusOK || len(rec.Result().Cookies()) != 1 {
+		t.Errorf("stale cookie: status %d, cookies %v", rec.Code, rec.Result().Cookies())
+	}
+}
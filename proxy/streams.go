@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/metrics"
+	"golang.org/x/net/http/httpguts"
+)
+
+var streamsOpen = metrics.Default.NewGaugeVec(
+	"liteproxy_streams_open",
+	"Open WebSocket and server-sent event connections, by service.",
+	"service")
+
+// isStream reports whether r opens a long-lived connection: a protocol
+// upgrade (WebSockets) or a server-sent event stream. Only upgrades are
+// trusted to be streams for timeouts; see isUpgrade and isEventStream.
+func isStream(r *http.Request) bool {
+	if isUpgrade(r) {
+		return true
+	}
+	for _, v := range r.Header.Values("Accept") {
+		if strings.Contains(v, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+// isUpgrade reports whether r asks to switch protocols (WebSockets)
+func isUpgrade(r *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(r.Header["Connection"], "Upgrade")
+}
+
+// isEventStream reports whether response headers h are those of a
+// server-sent event stream, whatever the request claimed to accept
+func isEventStream(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// streamKey identifies a route's streams across reloads, which replace
+// the route but leave its open connections running
+func streamKey(route *compose.Route) string {
+	return route.Host + route.Path()
+}
+
+// acquireStream counts a stream opening on the route, reporting false if
+// the route's liteproxy.streams.max_connections are all in use
+func (h *Handler) acquireStream(route *compose.Route) bool {
+	key := streamKey(route)
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	if route.Streams != nil && route.Streams.MaxConnections > 0 && h.streams[key] >= route.Streams.MaxConnections {
+		return false
+	}
+	h.streams[key]++
+	streamsOpen.Add(1, route.ServiceName)
+	return true
+}
+
+func (h *Handler) releaseStream(route *compose.Route) {
+	key := streamKey(route)
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	if h.streams[key] <= 1 {
+		delete(h.streams, key)
+	} else {
+		h.streams[key]--
+	}
+	streamsOpen.Add(-1, route.ServiceName)
+}
+
+// streamWriter closes a stream once no data has passed for the idle
+// timeout. Event streams are cancelled when the backend goes quiet;
+// upgraded connections get a deadline that moves with traffic either way.
+type streamWriter struct {
+	http.ResponseWriter
+	idle  time.Duration
+	timer *time.Timer
+}
+
+// newStreamWriter returns a writer for r's stream and the request to
+// proxy, whose context is cancelled when the stream sits idle
+func newStreamWriter(w http.ResponseWriter, r *http.Request, idle time.Duration) (*streamWriter, *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	return &streamWriter{ResponseWriter: w, idle: idle, timer: time.AfterFunc(idle, cancel)}, r.WithContext(ctx)
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.timer.Reset(w.idle)
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack hands over the client connection for an upgrade, which is then
+// timed by its own traffic rather than the response
+func (w *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.timer.Stop()
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(w.idle))
+	return &idleConn{Conn: conn, idle: w.idle}, brw, nil
+}
+
+func (w *streamWriter) close() {
+	w.timer.Stop()
+}
+
+// Unwrap lets http.ResponseController reach Flush
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idleConn pushes its deadline back whenever data is read or written, so
+// traffic in either direction keeps an upgraded connection open
+type idleConn struct {
+	net.Conn
+	idle time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.idle))
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.idle))
+	}
+	return n, err
+}
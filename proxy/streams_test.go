@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestStreams(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "echo" {
+			conn, brw, _ := http.NewResponseController(w).Hijack()
+			defer conn.Close()
+			brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			brw.Flush()
+			io.Copy(conn, brw)
+			return
+		}
+		// One event, then nothing until the client goes away
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	h := New(router.New([]compose.Route{{
+		Host: "chat.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port,
+		Timeout: &compose.Timeout{Duration: 50 * time.Millisecond},
+		Streams: &compose.Streams{MaxConnections: 1, IdleTimeout: 200 * time.Millisecond},
+	}}), "http")
+	front := httptest.NewServer(h)
+	defer front.Close()
+
+	events := func() *http.Response {
+		req, _ := http.NewRequest("GET", front.URL+"/events", nil)
+		req.Host = "chat.test"
+		req.Header.Set("Accept", "text/event-stream")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// An event stream outlives the route timeout and is closed once idle
+	start := time.Now()
+	first := events()
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("stream status = %d, want 200", first.StatusCode)
+	}
+
+	// The route allows one stream at a time
+	second := events()
+	second.Body.Close()
+	if second.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second stream status = %d, want 503", second.StatusCode)
+	}
+
+	body, _ := io.ReadAll(first.Body)
+	first.Body.Close()
+	if !strings.Contains(string(body), "data: hello") {
+		t.Errorf("stream body = %q, want the event", body)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("stream closed after %v, want the 200ms idle timeout", elapsed)
+	}
+
+	// The slot is free again once the stream closes
+	open := func() int {
+		h.streamsMu.Lock()
+		defer h.streamsMu.Unlock()
+		return h.streams["chat.test/"]
+	}
+	for deadline := time.Now().Add(time.Second); open() != 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := open(); n != 0 {
+		t.Fatalf("%d streams still counted after closing", n)
+	}
+
+	// Traffic keeps an upgraded connection open past the idle timeout
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: chat.test\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil || res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade = %v, %v; want 101", res, err)
+	}
+	buf := make([]byte, 4)
+	for range 3 {
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(conn, "ping")
+		if _, err := io.ReadFull(br, buf); err != nil {
+			t.Fatalf("echo after %v: %v", time.Since(start), err)
+		}
+	}
+
+	// ...and closes it once quiet
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("idle upgraded connection read = %v, want EOF", err)
+	}
+}
+
+func TestStreamClaimKeepsTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A slow ordinary response, whatever the client asked for
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+		io.WriteString(w, "done")
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	h := New(router.New([]compose.Route{{
+		Host: "app.test", PathPrefix: "/", ServiceName: u.Hostname(), ServicePort: port,
+		Timeout: &compose.Timeout{Duration: 50 * time.Millisecond, Headers: 50 * time.Millisecond},
+	}}), "http")
+
+	req := httptest.NewRequest("GET", "http://app.test/report", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504 when only the request claims a stream", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, want the 50ms timeout", elapsed)
+	}
+}
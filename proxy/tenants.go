@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"golang.org/x/sync/singleflight"
+)
+
+// tenantResolverTTL is how long answers from an HTTP tenant resolver,
+// including unknown tenants, are reused
+const tenantResolverTTL = 30 * time.Second
+
+// tenantResolverTimeout bounds each call to an HTTP tenant resolver
+const tenantResolverTimeout = 2 * time.Second
+
+// tenantResolverMaxEntries caps the answers kept per resolver, so requests
+// for random subdomains can't grow the cache without bound
+const tenantResolverMaxEntries = 10000
+
+// errUnknownTenant means the resolver has no backend for the subdomain
+var errUnknownTenant = errors.New("unknown tenant")
+
+// tenantResolver asks an HTTP endpoint for each tenant's backend:
+//
+//	GET <url>?tenant=acme → 200 {"backend": "app-acme:8080"}, or 404 if unknown
+type tenantResolver struct {
+	url    string
+	client *http.Client
+	calls  singleflight.Group // one call per tenant at a time
+
+	mu    sync.Mutex
+	cache map[string]tenantAnswer
+}
+
+// tenantAnswer is a cached resolver answer
+type tenantAnswer struct {
+	addr    string
+	err     error // nil or errUnknownTenant
+	expires time.Time
+}
+
+func newTenantResolver(url string) *tenantResolver {
+	return &tenantResolver{
+		url:    url,
+		client: &http.Client{Timeout: tenantResolverTimeout},
+		cache:  make(map[string]tenantAnswer),
+	}
+}
+
+// resolve returns the backend address for tenant. Concurrent misses for
+// the same tenant share one call. Failed calls aren't cached, so a resolver
+// outage ends as soon as it recovers.
+func (t *tenantResolver) resolve(ctx context.Context, tenant string) (string, error) {
+	t.mu.Lock()
+	a, ok := t.cache[tenant]
+	t.mu.Unlock()
+	if ok && time.Now().Before(a.expires) {
+		return a.addr, a.err
+	}
+
+	v, err, _ := t.calls.Do(tenant, func() (any, error) {
+		// Shared by every waiter, so one client going away doesn't fail the rest
+		addr, err := t.fetch(context.WithoutCancel(ctx), tenant)
+		if err != nil && !errors.Is(err, errUnknownTenant) {
+			return "", err
+		}
+		t.store(tenant, tenantAnswer{addr: addr, err: err, expires: time.Now().Add(tenantResolverTTL)})
+		return addr, err
+	})
+	return v.(string), err
+}
+
+// store caches answer a, sweeping expired answers once the cache is full
+// and evicting an arbitrary one if that frees nothing
+func (t *tenantResolver) store(tenant string, a tenantAnswer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.cache[tenant]; !ok && len(t.cache) >= tenantResolverMaxEntries {
+		now := time.Now()
+		for k, old := range t.cache {
+			if now.After(old.expires) {
+				delete(t.cache, k)
+			}
+		}
+		for k := range t.cache {
+			if len(t.cache) < tenantResolverMaxEntries {
+				break
+			}
+			delete(t.cache, k)
+		}
+	}
+	t.cache[tenant] = a
+}
+
+func (t *tenantResolver) fetch(ctx context.Context, tenant string) (string, error) {
+	u, err := url.Parse(t.url)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("tenant", tenant)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", errUnknownTenant
+	default:
+		return "", fmt.Errorf("tenant resolver answered %s", resp.Status)
+	}
+	var answer struct {
+		Backend string `json:"backend"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("tenant resolver: %w", err)
+	}
+	if _, port, err := net.SplitHostPort(answer.Backend); err != nil || port == "" {
+		return "", fmt.Errorf("tenant resolver: invalid backend %q for %s (want host:port)", answer.Backend, tenant)
+	}
+	return answer.Backend, nil
+}
+
+// resolveTenant returns the backend for the subdomain host was requested
+// with, from the route's lookup file or HTTP resolver
+func (h *Handler) resolveTenant(ctx context.Context, route *compose.Route, host string) (string, error) {
+	tenant, _, _ := strings.Cut(host, ".")
+	tenant = strings.ToLower(tenant)
+	if route.TenantBackends != nil {
+		addr, ok := route.TenantBackends[tenant]
+		if !ok {
+			return "", errUnknownTenant
+		}
+		return addr, nil
+	}
+
+	h.mu.RLock()
+	t, ok := h.resolvers[route]
+	h.mu.RUnlock()
+	if !ok {
+		h.mu.Lock()
+		if t, ok = h.resolvers[route]; !ok {
+			t = newTenantResolver(route.TenantResolver)
+			h.resolvers[route] = t
+		}
+		h.mu.Unlock()
+	}
+	return t.resolve(ctx, tenant)
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// routeDeadline bounds a request by liteproxy.timeout. Unlike
+// context.WithTimeout, the deadline is lifted once the backend answers
+// with an event stream, which is bounded by liteproxy.streams.* instead.
+type routeDeadline struct {
+	context.Context // cancelled with cause context.DeadlineExceeded
+	deadline        time.Time
+	timer           *time.Timer
+	lifted          atomic.Bool
+}
+
+type routeDeadlineKey struct{}
+
+// withRouteDeadline returns r bounded by d and a function releasing it
+func withRouteDeadline(r *http.Request, d time.Duration) (*http.Request, func()) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	c := &routeDeadline{Context: ctx, deadline: time.Now().Add(d)}
+	c.timer = time.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+	return r.WithContext(c), func() {
+		c.timer.Stop()
+		cancel(nil)
+	}
+}
+
+func (c *routeDeadline) Deadline() (time.Time, bool) {
+	if c.lifted.Load() {
+		return c.Context.Deadline()
+	}
+	return c.deadline, true
+}
+
+func (c *routeDeadline) Err() error {
+	if err := c.Context.Err(); err != nil {
+		if cause := context.Cause(c.Context); errors.Is(cause, context.DeadlineExceeded) {
+			return cause
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *routeDeadline) Value(key any) any {
+	if key == (routeDeadlineKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+// liftRouteDeadline removes liteproxy.timeout from the request behind ctx,
+// unless it has already passed
+func liftRouteDeadline(ctx context.Context) {
+	if c, ok := ctx.Value(routeDeadlineKey{}).(*routeDeadline); ok && c.timer.Stop() {
+		c.lifted.Store(true)
+	}
+}
+
+// errHeaderTimeout fails round trips whose response headers took longer
+// than liteproxy.response_header_timeout
+var errHeaderTimeout = errors.New("timeout awaiting response headers")
+
+// headerTimeoutTransport bounds the wait for each attempt's response
+// headers; the body may then take as long as liteproxy.timeout allows.
+// WebSockets are exempt, as from liteproxy.timeout.
+type headerTimeoutTransport struct {
+	http.RoundTripper
+	timeout time.Duration
+}
+
+func (t headerTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isUpgrade(req) {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(t.timeout, func() { cancel(errHeaderTimeout) })
+	resp, err := t.RoundTripper.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("%w after %s", errHeaderTimeout, t.timeout)
+	}
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	resp.Body = cancelBody{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelBody releases the round trip's context once the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
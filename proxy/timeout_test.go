@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+func TestHeaderTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hung":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+		case "/slow-body":
+			// Headers at once, then a body slower than the header timeout
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			io.WriteString(w, "done")
+		}
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	h := New(router.New([]compose.Route{{
+		Host: "example.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+		Timeout: &compose.Timeout{Headers: 100 * time.Millisecond},
+	}}), "http")
+
+	tests := []struct {
+		path     string
+		want     int
+		wantBody string
+		maxTime  time.Duration
+	}{
+		{"/hung", http.StatusGatewayTimeout, "", time.Second},
+		{"/slow-body", http.StatusOK, "done", 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			start := time.Now()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+tt.path, nil))
+			if elapsed := time.Since(start); elapsed > tt.maxTime {
+				t.Errorf("took %v, want under %v", elapsed, tt.maxTime)
+			}
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
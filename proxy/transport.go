@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/dialer"
+	"github.com/localrivet/liteproxy/spiffe"
+)
+
+// transportKey identifies a transport for routes that can't use the shared
+// ones: backends behind a dialer, spoken to over TLS or with DNS settings
+type transportKey struct {
+	dial        string
+	upstreamTLS compose.UpstreamTLS
+	h2c         bool
+	http1Only   bool
+	dns         compose.DNS
+}
+
+// errTransport fails every round trip, for routes whose transport can't be built
+type errTransport struct {
+	err error
+}
+
+func (t errTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// customTransport returns the transport for key. Transports are kept across
+// reloads so tunnels and idle connections survive them. Called with h.mu held.
+func (h *Handler) customTransport(key transportKey) http.RoundTripper {
+	if t, ok := h.transports[key]; ok {
+		return t
+	}
+	t, err := newTransport(key, h.spiffe, h.lookupHost)
+	if err != nil {
+		h.transports[key] = errTransport{err}
+		return errTransport{err}
+	}
+	h.transports[key] = t
+	return t
+}
+
+// SetSPIFFE sets the Workload API source used by routes with a SPIFFE ID
+// Must be called before serving requests
+func (h *Handler) SetSPIFFE(s *spiffe.Source) {
+	h.spiffe = s
+}
+
+func newTransport(key transportKey, svids *spiffe.Source, lookup func(ctx context.Context, host string) ([]string, error)) (*http.Transport, error) {
+	t := sharedTransport.Clone()
+	if key.dial != "" {
+		d, err := dialer.New(key.dial)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = nil // the dialer decides the path to the backend
+		t.DialContext = d.DialContext
+	}
+	if key.dns != (compose.DNS{}) {
+		t.DialContext = newPinnedDialer(key.dns, lookup).DialContext
+	}
+	if key.h2c {
+		t.Protocols = h2cProtocols()
+	}
+	if key.http1Only {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if key.upstreamTLS.SPIFFEID != "" {
+		if svids == nil {
+			return nil, errors.New("route has a SPIFFE ID but no workload API is configured (LITEPROXY_SPIFFE_SOCKET)")
+		}
+		t.TLSClientConfig = &tls.Config{
+			GetClientCertificate: svids.GetClientCertificate,
+			// SVIDs name workloads, not hosts; VerifyPeer checks the chain and ID
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: svids.VerifyPeer(key.upstreamTLS.SPIFFEID),
+			ServerName:            key.upstreamTLS.ServerName,
+		}
+	} else if key.upstreamTLS != (compose.UpstreamTLS{}) {
+		cfg := &tls.Config{
+			ServerName:         key.upstreamTLS.ServerName,
+			InsecureSkipVerify: key.upstreamTLS.InsecureSkipVerify,
+		}
+		if key.upstreamTLS.CA != "" {
+			pool, err := compose.LoadCertPool(key.upstreamTLS.CA)
+			if err != nil {
+				return nil, err
+			}
+			cfg.RootCAs = pool
+		}
+		if key.upstreamTLS.Cert != "" {
+			l := &certLoader{certFile: key.upstreamTLS.Cert, keyFile: key.upstreamTLS.Key}
+			cfg.GetClientCertificate = l.get
+		}
+		t.TLSClientConfig = cfg
+	}
+	return t, nil
+}
+
+// certLoader serves a client certificate from files, reloading it when
+// they change so rotated certificates are used without a restart
+type certLoader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // newest modification time of the files when loaded
+}
+
+func (l *certLoader) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	modTime, err := newestModTime(l.certFile, l.keyFile)
+	if err == nil && l.cert != nil && !modTime.After(l.modTime) {
+		return l.cert, nil
+	}
+	cert, loadErr := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if loadErr != nil {
+		// Mid-rotation the files may not match yet; keep the old pair
+		if l.cert != nil {
+			slog.Warn("upstream client certificate failed to load, keeping the previous one", "file", l.certFile, "err", loadErr)
+			return l.cert, nil
+		}
+		return nil, loadErr
+	}
+	l.cert, l.modTime = &cert, modTime
+	return l.cert, nil
+}
+
+func newestModTime(files ...string) (time.Time, error) {
+	var newest time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+)
+
+// testCA issues client certificates for mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue writes a client certificate for cn and its key as PEM files
+func (ca *testCA) issue(t *testing.T, certFile, keyFile, cn string) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+}
+
+func TestUpstreamMTLS(t *testing.T) {
+	ca := newTestCA(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	backend.StartTLS()
+	defer backend.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "backend-ca.pem")
+	ca.issue(t, certFile, keyFile, "liteproxy")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0o600)
+
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+	routes := []compose.Route{
+		{
+			Host: "secure.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			UpstreamTLS: &compose.UpstreamTLS{Cert: certFile, Key: keyFile, CA: caFile},
+		},
+		{
+			Host: "nocert.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			UpstreamTLS: &compose.UpstreamTLS{CA: caFile},
+		},
+		{
+			Host: "spiffe.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			UpstreamTLS: &compose.UpstreamTLS{SPIFFEID: "spiffe://example.org/api"},
+		},
+	}
+	h := New(router.New(routes), "http")
+
+	get := func(host string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://"+host+"/", nil))
+		return rec
+	}
+
+	if rec := get("secure.com"); rec.Code != http.StatusOK || rec.Body.String() != "hello liteproxy" {
+		t.Errorf("mTLS: %d %q, want 200 hello liteproxy", rec.Code, rec.Body.String())
+	}
+	if rec := get("nocert.com"); rec.Code != http.StatusBadGateway {
+		t.Errorf("without client certificate: %d, want 502", rec.Code)
+	}
+	if rec := get("spiffe.com"); rec.Code != http.StatusBadGateway {
+		t.Errorf("SPIFFE ID without a workload API: %d, want 502", rec.Code)
+	}
+
+	// A rotated certificate is picked up on the next connection
+	ca.issue(t, certFile, keyFile, "liteproxy-rotated")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	h.transports[transportKey{upstreamTLS: *routes[0].UpstreamTLS}].(*http.Transport).CloseIdleConnections()
+	if rec := get("secure.com"); rec.Body.String() != "hello liteproxy-rotated" {
+		t.Errorf("after rotation: %q, want hello liteproxy-rotated", rec.Body.String())
+	}
+}
+
+func TestUpstreamServerName(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.ServerName)
+	}))
+	defer backend.Close()
+
+	caFile := filepath.Join(t.TempDir(), "backend-ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0o600)
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+
+	// httptest's certificate is valid for example.com, not the service address
+	route := func(host string, tlsConfig compose.UpstreamTLS) compose.Route {
+		return compose.Route{Host: host, PathPrefix: "/", ServiceName: "localhost", ServicePort: portNum, UpstreamTLS: &tlsConfig}
+	}
+	h := New(router.New([]compose.Route{
+		route("named.com", compose.UpstreamTLS{CA: caFile, ServerName: "example.com"}),
+		route("unnamed.com", compose.UpstreamTLS{CA: caFile}),
+		route("wrongname.com", compose.UpstreamTLS{CA: caFile, ServerName: "other.test"}),
+		route("insecure.com", compose.UpstreamTLS{InsecureSkipVerify: true}),
+	}), "http")
+
+	tests := []struct {
+		host     string
+		wantCode int
+		wantBody string
+	}{
+		{"named.com", http.StatusOK, "example.com"},
+		{"unnamed.com", http.StatusBadGateway, ""},
+		{"wrongname.com", http.StatusBadGateway, ""},
+		{"insecure.com", http.StatusOK, "localhost"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://"+tt.host+"/", nil))
+		if rec.Code != tt.wantCode || (tt.wantBody != "" && rec.Body.String() != tt.wantBody) {
+			t.Errorf("%s: %d %q, want %d %q", tt.host, rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/waf"
+)
+
+// inspect checks the request against the route's WAF rules, logging every
+// match. It reports whether a blocking rule matched, having sent the 403.
+func (h *Handler) inspect(w http.ResponseWriter, r *http.Request, cfg *compose.WAF) (blocked bool) {
+	var body []byte
+	if cfg.RuleSet.InspectsBody() && r.Body != nil && r.Body != http.NoBody {
+		// Put the inspected start of the body back in front of the rest
+		var err error
+		body, err = io.ReadAll(io.LimitReader(r.Body, waf.BodyLimit))
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "error reading request body")
+			return true
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	}
+
+	for _, rule := range cfg.RuleSet.Match(r, body) {
+		if rule.Action == waf.ActionBlock && cfg.Mode == compose.WAFModeBlock {
+			slog.Warn("waf: blocked request", "method", r.Method, "host", r.Host, "path", r.URL.Path, "client", clientIP(r), "rule", rule.ID)
+			h.writeError(w, r, http.StatusForbidden, "request blocked")
+			return true
+		}
+		slog.Info("waf: matched request", "method", r.Method, "host", r.Host, "path", r.URL.Path, "client", clientIP(r), "rule", rule.ID)
+	}
+	return false
+}
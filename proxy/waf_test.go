@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/router"
+	"github.com/localrivet/liteproxy/waf"
+)
+
+func TestWAF(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	portNum, _ := net.LookupPort("tcp", port)
+
+	rules, err := waf.Load([]string{waf.Builtin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(router.New([]compose.Route{
+		{
+			Host: "app.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			WAF: &compose.WAF{Rules: []string{waf.Builtin}, Mode: compose.WAFModeBlock, RuleSet: rules},
+		},
+		{
+			Host: "trial.com", PathPrefix: "/", ServiceName: "127.0.0.1", ServicePort: portNum,
+			WAF: &compose.WAF{Rules: []string{waf.Builtin}, Mode: compose.WAFModeLog, RuleSet: rules},
+		},
+	}), "http")
+
+	large := strings.Repeat("a", waf.BodyLimit+1000)
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		wantCode int
+	}{
+		{"clean request", "GET", "http://app.com/products?id=42", "", http.StatusOK},
+		{"sqli in query", "GET", "http://app.com/products?id=1%20UNION%20SELECT%20password", "", http.StatusForbidden},
+		{"xss in body", "POST", "http://app.com/comments", "<script>alert(1)</script>", http.StatusForbidden},
+		{"large clean body", "POST", "http://app.com/upload", large, http.StatusOK},
+		{"log mode", "GET", "http://trial.com/products?id=1%20UNION%20SELECT%20password", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, body))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != tt.body {
+				t.Errorf("backend received %d body bytes, want %d", rec.Body.Len(), len(tt.body))
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Package proxyproto reads and writes PROXY protocol headers (v1 and v2),
+// which carry the client's address across TCP load balancers
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerTimeout bounds reading the header, so a silent client can't hold
+// a connection open
+const headerTimeout = 5 * time.Second
+
+// v2Signature starts every version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Length is the longest version 1 header, including CRLF
+const maxV1Length = 107
+
+// ParseTrusted parses addresses and CIDRs of load balancers allowed to send
+// PROXY protocol headers
+func ParseTrusted(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", s)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Listener reads a PROXY protocol header from connections accepted from
+// trusted addresses. Other connections are passed through untouched.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+// NewListener wraps ln, expecting headers from the trusted prefixes
+func NewListener(ln net.Listener, trusted []netip.Prefix) *Listener {
+	return &Listener{Listener: ln, trusted: trusted}
+}
+
+// Accept returns the next connection. The header is read on first use by
+// the connection's own goroutine, so a slow client can't stall Accept.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, _ := netip.AddrFromSlice(tcp.IP)
+	ip = ip.Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a load balancer. RemoteAddr and LocalAddr
+// report the addresses from the PROXY protocol header.
+type Conn struct {
+	net.Conn
+
+	once     sync.Once
+	r        *bufio.Reader // holds bytes read past the header
+	src, dst net.Addr      // nil for LOCAL (health check) connections
+	err      error
+}
+
+// readHeader reads the header once; a missing or invalid header fails
+// every later Read
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		c.r = bufio.NewReaderSize(c.Conn, 256)
+		c.src, c.dst, c.err = ReadHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.err = fmt.Errorf("PROXY protocol from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.r.Buffered() > 0 {
+		return c.r.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the client's address from the header
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, from the header
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite half-closes the underlying TCP connection
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// ReadHeader reads a version 1 or 2 header from r, returning nil addresses
+// for headers that carry none (v1 UNKNOWN, v2 LOCAL)
+func ReadHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	start, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+	switch {
+	case bytes.Equal(start, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readV1(r)
+	}
+	return nil, nil, errors.New("missing header")
+}
+
+func readV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("v1 header too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid v1 header %q", strings.TrimSpace(string(line)))
+	}
+	srcAddr, err1 := v1Addr(fields[2], fields[4])
+	dstAddr, err2 := v1Addr(fields[3], fields[5])
+	if err := errors.Join(err1, err2); err != nil {
+		return nil, nil, fmt.Errorf("invalid v1 header: %w", err)
+	}
+	return srcAddr, dstAddr, nil
+}
+
+func v1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var head [16]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+	if head[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", head[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	// LOCAL: the balancer's own connection, e.g. a health check
+	if head[12]&0x0f == 0 {
+		return nil, nil, nil
+	}
+	var ipLen int
+	switch head[13] {
+	case 0x11: // TCP over IPv4
+		ipLen = 4
+	case 0x21: // TCP over IPv6
+		ipLen = 16
+	default: // UDP, unix sockets or unspecified: no usable address
+		return nil, nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, errors.New("v2 header too short for its addresses")
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:ipLen])
+	dstIP, _ := netip.AddrFromSlice(body[ipLen : 2*ipLen])
+	ports := body[2*ipLen:]
+	src = net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(ports[0:2])))
+	dst = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(ports[2:4])))
+	return src, dst, nil
+}
+
+// WriteHeader writes a version 1 or 2 header for a connection from src to
+// dst. Addresses that aren't TCP are sent as unknown.
+func WriteHeader(w io.Writer, version int, src, dst net.Addr) error {
+	srcTCP, ok1 := src.(*net.TCPAddr)
+	dstTCP, ok2 := dst.(*net.TCPAddr)
+	known := ok1 && ok2
+	var srcAP, dstAP netip.AddrPort
+	ipv4 := false
+	if known {
+		srcAP, dstAP = srcTCP.AddrPort(), dstTCP.AddrPort()
+		srcAP = netip.AddrPortFrom(srcAP.Addr().Unmap(), srcAP.Port())
+		dstAP = netip.AddrPortFrom(dstAP.Addr().Unmap(), dstAP.Port())
+		ipv4 = srcAP.Addr().Is4() && dstAP.Addr().Is4()
+		if !ipv4 {
+			// Mixed families are sent as IPv6, mapping any IPv4 address
+			srcAP = netip.AddrPortFrom(netip.AddrFrom16(srcAP.Addr().As16()), srcAP.Port())
+			dstAP = netip.AddrPortFrom(netip.AddrFrom16(dstAP.Addr().As16()), dstAP.Port())
+		}
+	}
+
+	switch version {
+	case 1:
+		if !known {
+			_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+			return err
+		}
+		proto := "TCP6"
+		if ipv4 {
+			proto = "TCP4"
+		}
+		_, err := fmt.Fprintf(w, "PROXY %s %s %s %d %d\r\n", proto, srcAP.Addr(), dstAP.Addr(), srcAP.Port(), dstAP.Port())
+		return err
+	case 2:
+		buf := append([]byte{}, v2Signature...)
+		if !known {
+			buf = append(buf, 0x21, 0x00, 0, 0) // PROXY, unspecified family
+			_, err := w.Write(buf)
+			return err
+		}
+		fam, ips := byte(0x21), append(srcAP.Addr().AsSlice(), dstAP.Addr().AsSlice()...)
+		if ipv4 {
+			fam = 0x11
+		}
+		buf = append(buf, 0x21, fam)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(ips)+4))
+		buf = append(buf, ips...)
+		buf = binary.BigEndian.AppendUint16(buf, srcAP.Port())
+		buf = binary.BigEndian.AppendUint16(buf, dstAP.Port())
+		_, err := w.Write(buf)
+		return err
+	}
+	return fmt.Errorf("unsupported PROXY protocol version %d", version)
+}
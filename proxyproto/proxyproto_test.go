@@ -0,0 +1,136 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func tcpAddr(s string) *net.TCPAddr {
+	return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(s))
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst net.Addr
+		want     [2]string // addresses read back, "" for none
+		v1       string
+	}{
+		{"ipv4", tcpAddr("203.0.113.7:51000"), tcpAddr("10.0.0.2:443"), [2]string{"203.0.113.7:51000", "10.0.0.2:443"}, "PROXY TCP4 203.0.113.7 10.0.0.2 51000 443\r\n"},
+		{"ipv6", tcpAddr("[2001:db8::7]:51000"), tcpAddr("[2001:db8::1]:443"), [2]string{"[2001:db8::7]:51000", "[2001:db8::1]:443"}, "PROXY TCP6 2001:db8::7 2001:db8::1 51000 443\r\n"},
+		{"mixed", tcpAddr("203.0.113.7:51000"), tcpAddr("[2001:db8::1]:443"), [2]string{"203.0.113.7:51000", "[2001:db8::1]:443"}, "PROXY TCP6 ::ffff:203.0.113.7 2001:db8::1 51000 443\r\n"},
+		{"unknown", &net.UnixAddr{Name: "/run/x.sock"}, tcpAddr("10.0.0.2:443"), [2]string{}, "PROXY UNKNOWN\r\n"},
+	}
+	for _, tt := range tests {
+		for _, version := range []int{1, 2} {
+			var buf bytes.Buffer
+			if err := WriteHeader(&buf, version, tt.src, tt.dst); err != nil {
+				t.Fatalf("%s v%d: WriteHeader() error = %v", tt.name, version, err)
+			}
+			if version == 1 && buf.String() != tt.v1 {
+				t.Errorf("%s v1 header = %q, want %q", tt.name, buf.String(), tt.v1)
+			}
+			buf.WriteString("payload")
+
+			r := bufio.NewReader(&buf)
+			src, dst, err := ReadHeader(r)
+			if err != nil {
+				t.Fatalf("%s v%d: ReadHeader() error = %v", tt.name, version, err)
+			}
+			got := [2]string{}
+			if src != nil {
+				got = [2]string{src.String(), dst.String()}
+			}
+			if got != tt.want {
+				t.Errorf("%s v%d: read %v, want %v", tt.name, version, got, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Errorf("%s v%d: data after header = %q", tt.name, version, rest)
+			}
+		}
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"no header", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", "missing header"},
+		{"bad v1", "PROXY TCP4 nonsense\r\n", "invalid v1 header"},
+		{"long v1", "PROXY TCP4 " + strings.Repeat("1", 200), "too long"},
+		{"bad v2 version", "\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x00", "unsupported version"},
+		{"short v2", "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x01\x02\x03\x04", "too short"},
+	}
+	for _, tt := range tests {
+		_, _, err := ReadHeader(bufio.NewReader(strings.NewReader(tt.data)))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accept := func(trusted []string, send string) (net.Conn, string) {
+		t.Helper()
+		prefixes, err := ParseTrusted(trusted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pl := NewListener(ln, prefixes)
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		io.WriteString(client, send)
+		client.(*net.TCPConn).CloseWrite()
+
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(conn)
+		if err != nil {
+			return conn, "error: " + err.Error()
+		}
+		return conn, string(data)
+	}
+
+	header := "PROXY TCP4 203.0.113.7 10.0.0.2 51000 443\r\n"
+	conn, data := accept([]string{"127.0.0.1"}, header+"hello")
+	if conn.RemoteAddr().String() != "203.0.113.7:51000" || conn.LocalAddr().String() != "10.0.0.2:443" || data != "hello" {
+		t.Errorf("trusted: remote %v local %v data %q", conn.RemoteAddr(), conn.LocalAddr(), data)
+	}
+
+	// Only trusted balancers may claim another client's address
+	conn, data = accept([]string{"10.0.0.0/8"}, header+"hello")
+	if !strings.HasPrefix(conn.RemoteAddr().String(), "127.0.0.1:") || data != header+"hello" {
+		t.Errorf("untrusted: remote %v data %q, want the connection untouched", conn.RemoteAddr(), data)
+	}
+
+	// Trusted sources must send a header
+	if _, data = accept([]string{"127.0.0.0/8"}, "hello, no header here"); !strings.Contains(data, "missing header") {
+		t.Errorf("trusted without header: %q, want an error", data)
+	}
+}
+
+func TestParseTrusted(t *testing.T) {
+	got, err := ParseTrusted([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil || len(got) != 3 || got[1].String() != "192.0.2.1/32" {
+		t.Errorf("ParseTrusted() = %v, %v", got, err)
+	}
+	if _, err := ParseTrusted([]string{"lb.internal"}); err == nil {
+		t.Error("ParseTrusted(hostname) error = nil")
+	}
+}
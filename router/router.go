@@ -1,6 +1,9 @@
 package router
 
 import (
+	"cmp"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -10,10 +13,14 @@ import (
 
 // Router holds the routing table with thread-safe access
 type Router struct {
-	mu        sync.RWMutex
-	routes    []compose.Route           // exact host routes (sorted by path length)
-	wildcards []compose.Route           // wildcard host routes (*.example.com)
-	redirects map[string]*compose.Route // redirect domain → target route
+	mu                sync.RWMutex
+	routes            []compose.Route           // exact host routes (sorted by compareRoutes)
+	wildcards         []compose.Route           // wildcard host routes (*.example.com)
+	redirects         map[string]*compose.Route // redirect domain → target route
+	wildcardRedirects map[string]*compose.Route // redirect suffix (".oldbrand.com") → target route
+	exactHosts        map[string]struct{}       // hosts with an exact route, which win over wildcard redirects
+	portHosts         bool                      // some exact routes include a port (example.com:8443)
+	regexps           map[string]*regexp.Regexp // compiled liteproxy.path_regex patterns
 }
 
 // New creates a new Router from a list of routes
@@ -33,67 +40,160 @@ func (r *Router) Update(routes []compose.Route) {
 	// Separate exact and wildcard routes
 	var exact, wildcards []compose.Route
 	for _, route := range routes {
+		// Forward routes have no host; they get their own listeners
+		if route.IsForward() {
+			continue
+		}
+		// Passthrough presets are routed by SNI on their own ports
+		if len(route.SNIPorts) != 0 {
+			continue
+		}
 		if strings.HasPrefix(route.Host, "*.") {
 			wildcards = append(wildcards, route)
 		} else {
 			exact = append(exact, route)
 		}
+		// Aliases are served exactly like the primary host
+		for _, alias := range route.Aliases {
+			aliased := route
+			aliased.Host = alias
+			aliased.Aliases = nil
+			aliased.RedirectFrom = nil // redirects already point at the primary
+			exact = append(exact, aliased)
+		}
 	}
 
-	// Sort both by path length descending (longest prefix first)
-	sort.Slice(exact, func(i, j int) bool {
-		return len(exact[i].PathPrefix) > len(exact[j].PathPrefix)
-	})
-	sort.Slice(wildcards, func(i, j int) bool {
-		return len(wildcards[i].PathPrefix) > len(wildcards[j].PathPrefix)
-	})
+	// Sort both by priority, then exact paths, regexes and prefixes
+	slices.SortStableFunc(exact, compareRoutes)
+	slices.SortStableFunc(wildcards, compareRoutes)
+
+	// Patterns were validated by the parser; a route whose pattern fails
+	// to compile here never matches
+	r.regexps = make(map[string]*regexp.Regexp)
+	for _, list := range [][]compose.Route{exact, wildcards} {
+		for _, route := range list {
+			if route.PathRegex == "" {
+				continue
+			}
+			if re, err := regexp.Compile(route.PathRegex); err == nil {
+				r.regexps[route.PathRegex] = re
+			}
+		}
+	}
 
 	r.routes = exact
 	r.wildcards = wildcards
 
-	// Build redirect map from all routes
+	r.portHosts = false
+	for _, route := range exact {
+		if _, port := splitHostPort(route.Host); port != "" {
+			r.portHosts = true
+			break
+		}
+	}
+
+	r.exactHosts = make(map[string]struct{}, len(exact))
+	for _, route := range exact {
+		r.exactHosts[route.Host] = struct{}{}
+	}
+
+	// Build redirect maps from all routes
 	r.redirects = make(map[string]*compose.Route)
+	r.wildcardRedirects = make(map[string]*compose.Route)
 	for i := range r.routes {
-		route := &r.routes[i]
-		for _, domain := range route.RedirectFrom {
-			r.redirects[domain] = route
-		}
+		r.addRedirects(&r.routes[i])
 	}
 	for i := range r.wildcards {
-		route := &r.wildcards[i]
-		for _, domain := range route.RedirectFrom {
+		r.addRedirects(&r.wildcards[i])
+	}
+}
+
+// compareRoutes orders routes for matching: higher liteproxy.priority
+// first, then exact paths, regular expressions and prefixes, each longest
+// first. Routes that still tie are ordered by service and project name, so
+// the winner doesn't depend on file order.
+func compareRoutes(a, b compose.Route) int {
+	return cmp.Or(
+		cmp.Compare(b.Priority, a.Priority),
+		cmp.Compare(pathKind(a), pathKind(b)),
+		cmp.Compare(len(b.Path()), len(a.Path())),
+		strings.Compare(a.ServiceName, b.ServiceName),
+		strings.Compare(a.Project, b.Project),
+	)
+}
+
+// pathKind ranks how a route matches paths, most specific first
+func pathKind(route compose.Route) int {
+	switch {
+	case route.PathExact != "":
+		return 0
+	case route.PathRegex != "":
+		return 1
+	}
+	return 2
+}
+
+// matchesPath reports whether path matches the route's exact path,
+// regular expression or prefix
+func (r *Router) matchesPath(route *compose.Route, path string) bool {
+	switch {
+	case route.PathExact != "":
+		return path == route.PathExact
+	case route.PathRegex != "":
+		re := r.regexps[route.PathRegex]
+		return re != nil && re.MatchString(path)
+	}
+	return matchesPathPrefix(path, route.PathPrefix)
+}
+
+// addRedirects registers the route's redirect_from domains
+// "*.oldbrand.com" is stored by its suffix so lookups don't allocate
+func (r *Router) addRedirects(route *compose.Route) {
+	for _, domain := range route.RedirectFrom {
+		if strings.HasPrefix(domain, "*.") {
+			r.wildcardRedirects[domain[1:]] = route
+		} else {
 			r.redirects[domain] = route
 		}
 	}
 }
 
-// Match finds the route for a request using longest prefix matching
+// Match finds the route for a request: an exact path, then a regular
+// expression, then the longest prefix, unless liteproxy.priority ranks
+// routes otherwise
 // Priority: exact host match > wildcard host match
 // Returns nil if no route matches
+// Routes whose host includes a port only match requests for that port,
+// and win over routes for the bare host
 func (r *Router) Match(host, path string) *compose.Route {
+	return r.MatchSkipping(host, path, nil)
+}
+
+// MatchSkipping is Match, passing over routes for which skip reports true
+// so that the next best route matches instead. A nil skip skips nothing.
+func (r *Router) MatchSkipping(host, path string, skip func(*compose.Route) bool) *compose.Route {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// Strip port from host if present
-	if idx := strings.LastIndex(host, ":"); idx != -1 {
-		host = host[:idx]
-	}
-
 	// Normalize empty path to /
 	if path == "" {
 		path = "/"
 	}
 
-	// Try exact host match first
-	for i := range r.routes {
-		route := &r.routes[i]
-		if route.Host != host {
-			continue
-		}
-		if matchesPathPrefix(path, route.PathPrefix) {
+	name, port := splitHostPort(host)
+
+	// Try host:port routes first
+	if port != "" && r.portHosts {
+		if route := r.matchExact(host, path, skip); route != nil {
 			return route
 		}
 	}
+	host = name
+
+	// Try exact host match
+	if route := r.matchExact(host, path, skip); route != nil {
+		return route
+	}
 
 	// Try wildcard match (*.example.com)
 	if idx := strings.Index(host, "."); idx != -1 {
@@ -103,7 +203,7 @@ func (r *Router) Match(host, path string) *compose.Route {
 			if route.Host != wildcardHost {
 				continue
 			}
-			if matchesPathPrefix(path, route.PathPrefix) {
+			if r.matchesPath(route, path) && (skip == nil || !skip(route)) {
 				return route
 			}
 		}
@@ -112,6 +212,48 @@ func (r *Router) Match(host, path string) *compose.Route {
 	return nil
 }
 
+// matchExact finds the first matching route for an exact host in
+// compareRoutes order
+func (r *Router) matchExact(host, path string, skip func(*compose.Route) bool) *compose.Route {
+	for i := range r.routes {
+		route := &r.routes[i]
+		if route.Host != host {
+			continue
+		}
+		if r.matchesPath(route, path) && (skip == nil || !skip(route)) {
+			return route
+		}
+	}
+	return nil
+}
+
+// hostname strips the port from a Host header value, handling IPv6 literals
+// "[::1]:8080" and "[::1]" become "::1"; a bare "::1" is returned as-is
+func hostname(host string) string {
+	name, _ := splitHostPort(host)
+	return name
+}
+
+// splitHostPort is net.SplitHostPort with a fallback for hosts without a port,
+// parsed by hand to keep the hot path allocation-free
+func splitHostPort(host string) (name, port string) {
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end == -1 {
+			return host, ""
+		}
+		if rest := host[end+1:]; strings.HasPrefix(rest, ":") {
+			port = rest[1:]
+		}
+		return host[1:end], port
+	}
+	// A single colon separates the port; more than one means a bare IPv6 literal
+	if i := strings.IndexByte(host, ':'); i != -1 && strings.IndexByte(host[i+1:], ':') == -1 {
+		return host[:i], host[i+1:]
+	}
+	return host, ""
+}
+
 // matchesPathPrefix checks if path matches the prefix with proper path boundary handling
 // e.g., /api matches /api, /api/, /api/users but NOT /apiv2
 func matchesPathPrefix(path, prefix string) bool {
@@ -131,17 +273,83 @@ func matchesPathPrefix(path, prefix string) bool {
 	return path[len(prefix)] == '/'
 }
 
+// Paths the proxy can answer itself instead of the backend
+const (
+	RobotsPath      = "/robots.txt"
+	SecurityTxtPath = "/.well-known/security.txt"
+)
+
+// StaticFile returns proxy-managed content for robots.txt and security.txt,
+// set by any route of the host (exact host first, then wildcard)
+func (r *Router) StaticFile(host, path string) (string, bool) {
+	if path != RobotsPath && path != SecurityTxtPath {
+		return "", false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	host = hostname(host)
+	for i := range r.routes {
+		if r.routes[i].Host == host {
+			if content := staticFile(&r.routes[i], path); content != "" {
+				return content, true
+			}
+		}
+	}
+	if idx := strings.Index(host, "."); idx != -1 {
+		wildcardHost := "*" + host[idx:]
+		for i := range r.wildcards {
+			if r.wildcards[i].Host == wildcardHost {
+				if content := staticFile(&r.wildcards[i], path); content != "" {
+					return content, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func staticFile(route *compose.Route, path string) string {
+	if path == RobotsPath {
+		return route.Robots
+	}
+	return route.SecurityTxt
+}
+
 // Redirect checks if the host should redirect, returns target route or nil
+// Exact redirect_from domains win over wildcard ones ("*.oldbrand.com")
 func (r *Router) Redirect(host string) *compose.Route {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// Strip port from host if present
-	if idx := strings.LastIndex(host, ":"); idx != -1 {
-		host = host[:idx]
+	if route, ok := r.redirects[host]; ok {
+		return route // redirect_from entries may include a port
+	}
+	host = hostname(host)
+	if route, ok := r.redirects[host]; ok {
+		return route
 	}
 
-	return r.redirects[host]
+	// Wildcard redirects cover subdomains at any depth, most specific first,
+	// unless the host has its own route
+	if len(r.wildcardRedirects) == 0 {
+		return nil
+	}
+	if _, ok := r.exactHosts[host]; ok {
+		return nil
+	}
+	for i := strings.IndexByte(host, '.'); i != -1; {
+		if route, ok := r.wildcardRedirects[host[i:]]; ok {
+			return route
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next == -1 {
+			break
+		}
+		i += 1 + next
+	}
+	return nil
 }
 
 // Hosts returns all unique hosts that should be served (for TLS certificates)
@@ -150,11 +358,12 @@ func (r *Router) Hosts() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	// Certificates are per hostname, so ports are dropped
 	hostSet := make(map[string]struct{})
 	for _, route := range r.routes {
-		hostSet[route.Host] = struct{}{}
+		hostSet[hostname(route.Host)] = struct{}{}
 		for _, redirect := range route.RedirectFrom {
-			hostSet[redirect] = struct{}{}
+			hostSet[hostname(redirect)] = struct{}{}
 		}
 	}
 	for _, route := range r.wildcards {
@@ -172,6 +381,24 @@ func (r *Router) Hosts() []string {
 	return hosts
 }
 
+// Stats describes the size of the route table
+type Stats struct {
+	Routes    int // exact host routes, including aliases
+	Wildcards int // wildcard host routes
+	Redirects int // redirect domains, exact and wildcard
+}
+
+// Stats returns the size of the route table
+func (r *Router) Stats() Stats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return Stats{
+		Routes:    len(r.routes),
+		Wildcards: len(r.wildcards),
+		Redirects: len(r.redirects) + len(r.wildcardRedirects),
+	}
+}
+
 // Routes returns a copy of all routes (for debugging/logging)
 func (r *Router) Routes() []compose.Route {
 	r.mu.RLock()
@@ -206,12 +433,16 @@ func (r *Router) getPassthroughRoute(host string) *compose.Route {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// Strip port from host if present
-	if idx := strings.LastIndex(host, ":"); idx != -1 {
-		host = host[:idx]
+	// Check exact matches first, preferring host:port routes
+	if r.portHosts {
+		for i := range r.routes {
+			route := &r.routes[i]
+			if route.Host == host && route.Passthrough {
+				return route
+			}
+		}
 	}
-
-	// Check exact matches first
+	host = hostname(host)
 	for i := range r.routes {
 		route := &r.routes[i]
 		if route.Host == host && route.Passthrough {
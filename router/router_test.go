@@ -179,6 +179,8 @@ func TestHosts(t *testing.T) {
 			PathPrefix:  "/",
 			ServiceName: "api",
 		},
+		// Forward routes have no host to serve
+		{ServiceName: "db", ServicePort: 5432, TCPPort: 5432},
 	}
 	r := New(routes)
 
@@ -282,6 +284,96 @@ func TestLongestPrefixOrdering(t *testing.T) {
 	}
 }
 
+func TestPriorityOrdering(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "root", ServicePort: 80},
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 80},
+		{Host: "example.com", PathPrefix: "/api/v1", ServiceName: "legacy", ServicePort: 80, Priority: -1},
+		{Host: "example.com", PathPrefix: "/maintenance", ServiceName: "maintenance", ServicePort: 80, Priority: 10},
+		// Same host and prefix: the higher priority wins, then the service name
+		{Host: "tie.example.com", PathPrefix: "/", ServiceName: "zeta", ServicePort: 80},
+		{Host: "tie.example.com", PathPrefix: "/", ServiceName: "alpha", ServicePort: 80},
+		{Host: "ranked.example.com", PathPrefix: "/", ServiceName: "zeta", ServicePort: 80, Priority: 1},
+		{Host: "ranked.example.com", PathPrefix: "/", ServiceName: "alpha", ServicePort: 80},
+		{Host: "*.example.com", PathPrefix: "/", ServiceName: "wild-low", ServicePort: 80},
+		{Host: "*.example.com", PathPrefix: "/", ServiceName: "wild-high", ServicePort: 80, Priority: 5},
+	}
+
+	tests := []struct {
+		host        string
+		path        string
+		wantService string
+	}{
+		{"example.com", "/api/users", "api"},
+		{"example.com", "/api/v1/users", "api"}, // lower priority loses despite the longer prefix
+		{"example.com", "/maintenance", "maintenance"},
+		{"tie.example.com", "/", "alpha"},
+		{"ranked.example.com", "/", "zeta"},
+		{"shop.example.com", "/", "wild-high"},
+	}
+
+	// The result must not depend on the order routes were listed in
+	for _, order := range [][]compose.Route{routes, reversed(routes)} {
+		r := New(order)
+		for _, tt := range tests {
+			route := r.Match(tt.host, tt.path)
+			if route == nil {
+				t.Fatalf("Match(%q, %q) = nil", tt.host, tt.path)
+			}
+			if route.ServiceName != tt.wantService {
+				t.Errorf("Match(%q, %q).ServiceName = %q, want %q", tt.host, tt.path, route.ServiceName, tt.wantService)
+			}
+		}
+	}
+}
+
+func TestPathExactAndRegex(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+		{Host: "example.com", PathPrefix: "/v1", ServiceName: "v1-prefix", ServicePort: 80},
+		{Host: "example.com", PathRegex: "^/v[0-9]+/", ServiceName: "versioned", ServicePort: 80},
+		{Host: "example.com", PathRegex: `\.php$`, ServiceName: "php", ServicePort: 80, Priority: -1},
+		{Host: "example.com", PathExact: "/healthz", ServiceName: "health", ServicePort: 80},
+		{Host: "example.com", PathExact: "/v1/status", ServiceName: "status", ServicePort: 80},
+		{Host: "*.example.com", PathRegex: "^/api/", ServiceName: "wild-api", ServicePort: 80},
+		{Host: "*.example.com", PathPrefix: "/", ServiceName: "wild", ServicePort: 80},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		host        string
+		path        string
+		wantService string
+	}{
+		{"example.com", "/healthz", "health"},
+		{"example.com", "/healthz/deep", "web"}, // exact means exact
+		{"example.com", "/v1/status", "status"}, // exact beats regex
+		{"example.com", "/v2/users", "versioned"},
+		{"example.com", "/v1/users", "versioned"}, // regex beats prefix
+		{"example.com", "/v1", "v1-prefix"},
+		{"example.com", "/index.php", "web"}, // lower priority loses to the / prefix
+		{"shop.example.com", "/api/cart", "wild-api"},
+		{"shop.example.com", "/cart", "wild"},
+	}
+	for _, tt := range tests {
+		route := r.Match(tt.host, tt.path)
+		if route == nil {
+			t.Fatalf("Match(%q, %q) = nil", tt.host, tt.path)
+		}
+		if route.ServiceName != tt.wantService {
+			t.Errorf("Match(%q, %q).ServiceName = %q, want %q", tt.host, tt.path, route.ServiceName, tt.wantService)
+		}
+	}
+}
+
+func reversed(routes []compose.Route) []compose.Route {
+	out := make([]compose.Route, len(routes))
+	for i, r := range routes {
+		out[len(routes)-1-i] = r
+	}
+	return out
+}
+
 func TestPathEdgeCases(t *testing.T) {
 	routes := []compose.Route{
 		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 80},
@@ -642,3 +734,216 @@ func BenchmarkGetPassthrough(b *testing.B) {
 		}
 	})
 }
+
+func TestHostname(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"example.com:8080", "example.com"},
+		{"[::1]:8080", "::1"},
+		{"[::1]", "::1"},
+		{"::1", "::1"},
+		{"fd00::5", "fd00::5"},
+		{"[fd00::5]:443", "fd00::5"},
+		{"10.0.0.1:80", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := hostname(tt.host); got != tt.want {
+			t.Errorf("hostname(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestMatchIPv6Host(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "fd00::5", PathPrefix: "/", ServiceName: "v6", ServicePort: 80},
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+	}
+	r := New(routes)
+
+	for _, host := range []string{"[fd00::5]:8080", "[fd00::5]", "fd00::5"} {
+		route := r.Match(host, "/")
+		if route == nil || route.ServiceName != "v6" {
+			t.Errorf("Match(%q) = %v, want v6", host, route)
+		}
+	}
+	if route := r.Match("example.com:8443", "/"); route == nil || route.ServiceName != "web" {
+		t.Errorf("Match(example.com:8443) = %v, want web", route)
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		host     string
+		wantName string
+		wantPort string
+	}{
+		{"example.com", "example.com", ""},
+		{"example.com:8443", "example.com", "8443"},
+		{"[::1]:8080", "::1", "8080"},
+		{"[::1]", "::1", ""},
+		{"::1", "::1", ""},
+		{"[::1", "[::1", ""},
+	}
+
+	for _, tt := range tests {
+		name, port := splitHostPort(tt.host)
+		if name != tt.wantName || port != tt.wantPort {
+			t.Errorf("splitHostPort(%q) = (%q, %q), want (%q, %q)", tt.host, name, port, tt.wantName, tt.wantPort)
+		}
+	}
+}
+
+func TestMatchHostWithPort(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80},
+		{Host: "example.com:8443", PathPrefix: "/", ServiceName: "admin", ServicePort: 80},
+		{Host: "[fd00::5]:9000", PathPrefix: "/", ServiceName: "v6admin", ServicePort: 80},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		host        string
+		wantService string
+	}{
+		{"example.com", "web"},
+		{"example.com:80", "web"},
+		{"example.com:8443", "admin"},
+		{"[fd00::5]:9000", "v6admin"},
+	}
+
+	for _, tt := range tests {
+		route := r.Match(tt.host, "/")
+		if route == nil || route.ServiceName != tt.wantService {
+			t.Errorf("Match(%q) = %v, want %s", tt.host, route, tt.wantService)
+		}
+	}
+
+	// Certificates are issued per hostname
+	hosts := r.Hosts()
+	for _, h := range hosts {
+		if h == "example.com:8443" {
+			t.Errorf("Hosts() = %v, should not include ports", hosts)
+		}
+	}
+}
+
+func TestRedirectWithPort(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80, RedirectFrom: []string{"www.example.com"}},
+	}
+	r := New(routes)
+
+	for _, host := range []string{"www.example.com", "www.example.com:8080"} {
+		if route := r.Redirect(host); route == nil || route.Host != "example.com" {
+			t.Errorf("Redirect(%q) = %v, want example.com", host, route)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80, Aliases: []string{"www.example.com"}},
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080},
+	}
+	r := New(routes)
+
+	if route := r.Match("www.example.com", "/about"); route == nil || route.ServiceName != "web" {
+		t.Errorf("Match(www.example.com) = %v, want web", route)
+	}
+	if route := r.Redirect("www.example.com"); route != nil {
+		t.Errorf("Redirect(www.example.com) = %v, want nil for served alias", route)
+	}
+
+	hosts := r.Hosts()
+	want := []string{"example.com", "www.example.com"}
+	if len(hosts) != len(want) || hosts[0] != want[0] || hosts[1] != want[1] {
+		t.Errorf("Hosts() = %v, want %v", hosts, want)
+	}
+}
+
+func TestWildcardRedirectFrom(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "newbrand.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80,
+			RedirectFrom: []string{"*.oldbrand.com", "oldbrand.com"}},
+		{Host: "docs.newbrand.com", PathPrefix: "/", ServiceName: "docs", ServicePort: 80,
+			RedirectFrom: []string{"*.docs.oldbrand.com"}},
+		{Host: "api.oldbrand.com", PathPrefix: "/", ServiceName: "legacy-api", ServicePort: 80},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		host     string
+		wantHost string // empty = no redirect
+	}{
+		{"oldbrand.com", "newbrand.com"},
+		{"www.oldbrand.com", "newbrand.com"},
+		{"shop.eu.oldbrand.com:8080", "newbrand.com"},
+		{"v2.docs.oldbrand.com", "docs.newbrand.com"}, // most specific wildcard wins
+		{"api.oldbrand.com", ""},                      // exact routes win over wildcard redirects
+		{"oldbrand.com.evil.com", ""},
+		{"notoldbrand.com", ""},
+	}
+
+	for _, tt := range tests {
+		route := r.Redirect(tt.host)
+		got := ""
+		if route != nil {
+			got = route.Host
+		}
+		if got != tt.wantHost {
+			t.Errorf("Redirect(%q) = %q, want %q", tt.host, got, tt.wantHost)
+		}
+	}
+}
+
+func TestStaticFile(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "staging.example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 80},
+		{Host: "staging.example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80,
+			Robots: "User-agent: *\nDisallow: /\n", SecurityTxt: "Contact: mailto:sec@example.com\n"},
+		{Host: "*.preview.example.com", PathPrefix: "/", ServiceName: "preview", ServicePort: 80,
+			Robots: "User-agent: *\nDisallow: /\n"},
+		{Host: "example.com", PathPrefix: "/", ServiceName: "prod", ServicePort: 80},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		host, path string
+		want       string
+		wantOK     bool
+	}{
+		{"staging.example.com", "/robots.txt", "User-agent: *\nDisallow: /\n", true},
+		{"staging.example.com:8443", "/robots.txt", "User-agent: *\nDisallow: /\n", true},
+		{"staging.example.com", "/.well-known/security.txt", "Contact: mailto:sec@example.com\n", true},
+		{"staging.example.com", "/index.html", "", false},
+		{"pr-42.preview.example.com", "/robots.txt", "User-agent: *\nDisallow: /\n", true},
+		{"pr-42.preview.example.com", "/.well-known/security.txt", "", false},
+		{"example.com", "/robots.txt", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := r.StaticFile(tt.host, tt.path)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("StaticFile(%q, %q) = %q, %v, want %q, %v", tt.host, tt.path, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	routes := []compose.Route{
+		{Host: "example.com", PathPrefix: "/", ServiceName: "web", ServicePort: 80,
+			Aliases: []string{"www.example.com"}, RedirectFrom: []string{"old.com", "*.old.com"}},
+		{Host: "example.com", PathPrefix: "/api", ServiceName: "api", ServicePort: 8080},
+		{Host: "*.example.com", PathPrefix: "/", ServiceName: "tenant", ServicePort: 80},
+	}
+
+	got := New(routes).Stats()
+	want := Stats{Routes: 3, Wildcards: 1, Redirects: 2}
+	if got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/localrivet/liteproxy/compose"
+	"go.yaml.in/yaml/v4"
+)
+
+// runRoutes implements `liteproxy routes`: print the resolved route table
+func runRoutes(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("routes", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", "json", "output format: json or yaml")
+	files := fs.String("f", strings.Join(getEnvList("LITEPROXY_COMPOSE_FILE", defaultComposeFiles()), ","),
+		"compose files (comma-separated)")
+	dir := fs.String("d", os.Getenv("LITEPROXY_COMPOSE_DIR"), "compose directory")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	routes, err := parseConfig(Config{ComposeFiles: splitList(*files), ComposeDir: *dir})
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy routes: %v\n", err)
+		return 1
+	}
+
+	data, err := json.MarshalIndent(compose.Export(routes), "", "  ")
+	if err == nil && *output == "yaml" {
+		data, err = jsonToYAML(data)
+	} else if *output != "json" && *output != "yaml" {
+		err = fmt.Errorf("unknown output format %q (want json or yaml)", *output)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy routes: %v\n", err)
+		return 1
+	}
+
+	stdout.Write(data)
+	if *output == "json" {
+		fmt.Fprintln(stdout)
+	}
+	return 0
+}
+
+// jsonToYAML re-encodes JSON as block-style YAML, keeping key order
+func jsonToYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	var clearStyle func(n *yaml.Node)
+	clearStyle = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			clearStyle(c)
+		}
+	}
+	clearStyle(&node)
+	return yaml.Marshal(&node)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const routesCompose = `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "80"
+      liteproxy.healthcheck.path: "/healthz"
+  api:
+    image: api
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.path: "/api"
+      liteproxy.port: "8080"
+      liteproxy.backends: "api-1,api-2:9000"
+`
+
+func writeCompose(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunRoutesJSON(t *testing.T) {
+	path := writeCompose(t, routesCompose)
+
+	var stdout, stderr bytes.Buffer
+	if code := runRoutes([]string{"-f", path, "--output", "json"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runRoutes() = %d, stderr: %s", code, stderr.String())
+	}
+
+	var routes []map[string]any
+	if err := json.Unmarshal(stdout.Bytes(), &routes); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, stdout.String())
+	}
+	if len(routes) != 2 {
+		t.Fatalf("got %d routes, want 2", len(routes))
+	}
+
+	// Sorted by host then path, with defaults and upstreams resolved
+	if routes[0]["path"] != "/" || routes[1]["path"] != "/api" {
+		t.Errorf("routes not sorted by path: %v, %v", routes[0]["path"], routes[1]["path"])
+	}
+	hc := routes[0]["healthcheck"].(map[string]any)
+	if hc["type"] != "http" || hc["interval"] != "10s" {
+		t.Errorf("healthcheck = %v, want resolved http check with 10s interval", hc)
+	}
+	upstreams := routes[1]["upstreams"].([]any)
+	if len(upstreams) != 2 || upstreams[0] != "api-1:8080" || upstreams[1] != "api-2:9000" {
+		t.Errorf("upstreams = %v, want [api-1:8080 api-2:9000]", upstreams)
+	}
+}
+
+func TestRunRoutesYAML(t *testing.T) {
+	path := writeCompose(t, routesCompose)
+
+	var stdout, stderr bytes.Buffer
+	if code := runRoutes([]string{"-f", path, "--output", "yaml"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runRoutes() = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "- host: example.com\n  path: /\n") {
+		t.Errorf("unexpected YAML output:\n%s", stdout.String())
+	}
+}
+
+func TestRunRoutesErrors(t *testing.T) {
+	path := writeCompose(t, routesCompose)
+
+	var stdout, stderr bytes.Buffer
+	if code := runRoutes([]string{"-f", path, "--output", "xml"}, &stdout, &stderr); code == 0 {
+		t.Error("runRoutes() with unknown format = 0, want failure")
+	}
+	if code := runRoutes([]string{"-f", filepath.Join(t.TempDir(), "missing.yaml")}, &stdout, &stderr); code == 0 {
+		t.Error("runRoutes() with missing file = 0, want failure")
+	}
+}
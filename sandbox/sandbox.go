@@ -0,0 +1,93 @@
+// Package sandbox hardens the proxy once its listeners are open: it drops
+// root for an unprivileged user and limits where files can be written.
+// Both are Linux only.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// User is the account the proxy runs as after dropping root
+type User struct {
+	UID int
+	GID int
+}
+
+// ParseUser resolves spec: a user name or UID, optionally followed by
+// :group or :GID. Without a group, the user's primary group is used, so a
+// UID without an account needs one.
+func ParseUser(spec string) (User, error) {
+	name, group, hasGroup := strings.Cut(spec, ":")
+	if name == "" || (hasGroup && group == "") {
+		return User{}, errors.New("want user[:group]")
+	}
+
+	var u User
+	var primary string
+	if uid, err := strconv.Atoi(name); err == nil {
+		u.UID = uid
+		if acct, err := user.LookupId(name); err == nil {
+			primary = acct.Gid
+		}
+	} else {
+		acct, err := user.Lookup(name)
+		if err != nil {
+			return User{}, err
+		}
+		if u.UID, err = strconv.Atoi(acct.Uid); err != nil {
+			return User{}, fmt.Errorf("user %s has no numeric UID", name)
+		}
+		primary = acct.Gid
+	}
+
+	switch {
+	case hasGroup:
+		gid, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return User{}, err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return User{}, fmt.Errorf("group %s has no numeric GID", group)
+			}
+		}
+		u.GID = gid
+	case primary != "":
+		gid, err := strconv.Atoi(primary)
+		if err != nil {
+			return User{}, fmt.Errorf("user %s has no numeric GID", name)
+		}
+		u.GID = gid
+	default:
+		return User{}, fmt.Errorf("UID %d has no account; give a group as %d:GID", u.UID, u.UID)
+	}
+	if u.UID == 0 {
+		return User{}, errors.New("user is root")
+	}
+	return u, nil
+}
+
+// Chown gives u every file beneath each of paths, so it can keep writing
+// what was created while the proxy ran as root. Missing paths are skipped.
+func Chown(u User, paths ...string) error {
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, u.UID, u.GID)
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
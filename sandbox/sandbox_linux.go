@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Supported reports whether the platform can drop privileges and sandbox
+const Supported = true
+
+// writeAccess is what RestrictWrites limits: every Landlock right that
+// creates, modifies or removes files, in the first Landlock ABI
+const writeAccess = unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+	unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+	unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+	unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+	unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+	unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+	unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+	unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+	unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+// DropPrivileges switches every thread of the process to u. Only
+// CAP_NET_BIND_SERVICE is kept, also across exec for binary upgrades, so
+// ports below 1024 added on reload still open. It does nothing if the
+// process already runs as u.
+func DropPrivileges(u User) error {
+	if os.Getuid() == u.UID && os.Geteuid() == u.UID {
+		return nil
+	}
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_KEEPCAPS, 1, 0); err != nil {
+		return fmt.Errorf("keeping capabilities: %w", err)
+	}
+	if err := syscall.Setgroups([]int{u.GID}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(u.GID); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(u.UID); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+
+	const bind = 1 << unix.CAP_NET_BIND_SERVICE
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{{Effective: bind, Permitted: bind, Inheritable: bind}}
+	if err := allThreads(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); err != nil {
+		return fmt.Errorf("capset: %w", err)
+	}
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, unix.CAP_NET_BIND_SERVICE); err != nil {
+		return fmt.Errorf("ambient capabilities: %w", err)
+	}
+	return nil
+}
+
+// RestrictWrites uses Landlock so the process, and any it starts, can only
+// create, modify and delete files beneath dirs. Reading and executing are
+// unaffected, and so are files already open. It returns the Landlock ABI
+// version applied, or an error wrapping errors.ErrUnsupported on kernels
+// without Landlock.
+func RestrictWrites(dirs []string) (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock: %w (%v)", errors.ErrUnsupported, errno)
+	}
+	access := uint64(writeAccess)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: access}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock: creating ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, dir := range dirs {
+		dirFD, err := unix.Open(dir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return 0, fmt.Errorf("landlock: %s: %w", dir, err)
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(dirFD)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+		unix.Close(dirFD)
+		if errno != 0 {
+			return 0, fmt.Errorf("landlock: %s: %w", dir, errno)
+		}
+	}
+
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+		return 0, fmt.Errorf("no_new_privs: %w", err)
+	}
+	if err := allThreads(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); err != nil {
+		return 0, fmt.Errorf("landlock: %w", err)
+	}
+	return int(abi), nil
+}
+
+// allThreads makes a system call whose effect is per thread on every
+// thread of the process, which Go can only do in builds without cgo
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno == syscall.ENOTSUP {
+		return errors.New("requires a build with CGO_ENABLED=0")
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
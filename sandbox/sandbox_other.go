@@ -0,0 +1,21 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Supported reports whether the platform can drop privileges and sandbox
+const Supported = false
+
+// DropPrivileges is only supported on Linux
+func DropPrivileges(u User) error {
+	return errors.ErrUnsupported
+}
+
+// RestrictWrites is only supported on Linux
+func RestrictWrites(dirs []string) (int, error) {
+	return 0, fmt.Errorf("landlock: %w", errors.ErrUnsupported)
+}
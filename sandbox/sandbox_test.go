@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseUser(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    User
+		wantErr string
+	}{
+		{spec: "1000:1000", want: User{UID: 1000, GID: 1000}},
+		{spec: "65534:100", want: User{UID: 65534, GID: 100}},
+		{spec: "", wantErr: "want user[:group]"},
+		{spec: "1000:", wantErr: "want user[:group]"},
+		{spec: "0:0", wantErr: "user is root"},
+		{spec: "4242424", wantErr: "UID 4242424 has no account"},
+		{spec: "no-such-liteproxy-user", wantErr: "unknown user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseUser(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseUser(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseUser(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUserByName(t *testing.T) {
+	acct, err := user.Current()
+	if err != nil || acct.Uid == "0" {
+		t.Skip("needs a non-root account")
+	}
+	got, err := ParseUser(acct.Username)
+	if err != nil {
+		t.Fatalf("ParseUser(%q) error = %v", acct.Username, err)
+	}
+	if strconv.Itoa(got.UID) != acct.Uid || strconv.Itoa(got.GID) != acct.Gid {
+		t.Errorf("ParseUser(%q) = %+v, want %s:%s", acct.Username, got, acct.Uid, acct.Gid)
+	}
+}
+
+func TestChownSkipsMissing(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	u := User{UID: os.Getuid(), GID: os.Getgid()}
+	if err := Chown(u, dir, filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Chown() error = %v", err)
+	}
+}
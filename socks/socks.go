@@ -0,0 +1,380 @@
+// Package socks is a SOCKS5 listener giving developers authenticated
+// access to the compose stack's internal services, dialing compose
+// service names the way the proxy does
+package socks
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/localrivet/liteproxy/listener"
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+// Outcomes of a SOCKS connection
+const (
+	ResultAllowed      = "allowed"
+	ResultDenied       = "denied"
+	ResultUnauthorized = "unauthorized"
+	ResultError        = "error"
+)
+
+var socksConnections = metrics.Default.NewCounterVec(
+	"liteproxy_socks_connections_total",
+	"Connections to the SOCKS5 listener, by result.",
+	"result")
+
+const (
+	handshakeTimeout = 10 * time.Second
+	dialTimeout      = 10 * time.Second
+)
+
+// SOCKS5 protocol values (RFC 1928, RFC 1929)
+const (
+	version5        = 0x05
+	authVersion     = 0x01
+	methodUserPass  = 0x02
+	methodNoneValid = 0xff
+	cmdConnect      = 0x01
+	atypIPv4        = 0x01
+	atypDomain      = 0x03
+	atypIPv6        = 0x04
+
+	repSucceeded          = 0x00
+	repGeneralFailure     = 0x01
+	repNotAllowed         = 0x02
+	repHostUnreachable    = 0x04
+	repConnectionRefused  = 0x05
+	repCommandUnsupported = 0x07
+	repAddressUnsupported = 0x08
+)
+
+// errNotAllowed is returned by the dialer for addresses outside the stack
+var errNotAllowed = errors.New("destination is not a routed backend or allowed address")
+
+// Server is an authenticated SOCKS5 server supporting CONNECT
+type Server struct {
+	users map[string]string // user → password
+	allow []netip.Prefix    // addresses reachable besides routed backends
+
+	mu       sync.RWMutex
+	services map[string]string // compose service name → host to dial
+
+	// lookup resolves backend hosts; swappable for tests
+	lookup func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// New returns a Server for the users, each user:password. Clients reach
+// the routed backends, and the addresses or CIDRs in allow.
+func New(users, allow []string) (*Server, error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users")
+	}
+	s := &Server{users: make(map[string]string, len(users)), lookup: lookupIP}
+	for _, u := range users {
+		name, password, ok := strings.Cut(u, ":")
+		if !ok || name == "" || password == "" || len(name) > 255 || len(password) > 255 {
+			return nil, fmt.Errorf("invalid user %q (want user:password)", name)
+		}
+		s.users[name] = password
+	}
+	for _, a := range allow {
+		prefix, err := netip.ParsePrefix(a)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(a)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid allowed address %q (want an IP or CIDR)", a)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		s.allow = append(s.allow, prefix.Masked())
+	}
+	return s, nil
+}
+
+func lookupIP(ctx context.Context, host string) ([]netip.Addr, error) {
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+}
+
+// dialer returns a dialer for a destination, routed if it names a routed
+// service. The address is checked after DNS resolution, so a name can't
+// point outside the stack.
+func (s *Server) dialer(routed bool) *net.Dialer {
+	return &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !s.allowed(ap.Addr().Unmap(), routed) {
+				return errNotAllowed
+			}
+			return nil
+		},
+	}
+}
+
+// allowed reports whether addr may be dialed: it is in the allow list, or
+// is a routed backend's address. Loopback and unspecified addresses, where
+// liteproxy's own admin and metrics listeners live, must be allowed
+// explicitly.
+func (s *Server) allowed(addr netip.Addr, routed bool) bool {
+	for _, prefix := range s.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return false
+	}
+	return routed || s.isBackend(addr)
+}
+
+// isBackend reports whether a routed service resolves to addr, for
+// clients that dial a backend by IP rather than by name
+func (s *Server) isBackend(addr netip.Addr) bool {
+	s.mu.RLock()
+	hosts := make([]string, 0, len(s.services))
+	for _, host := range s.services {
+		hosts = append(hosts, host)
+	}
+	s.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	for _, host := range hosts {
+		if ip, err := netip.ParseAddr(host); err == nil {
+			if ip.Unmap() == addr {
+				return true
+			}
+			continue
+		}
+		addrs, _ := s.lookup(ctx, host)
+		for _, a := range addrs {
+			if a.Unmap() == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetServices sets the compose service names clients may use as
+// destinations, each mapped to the host the proxy dials for it
+func (s *Server) SetServices(services map[string]string) {
+	s.mu.Lock()
+	s.services = services
+	s.mu.Unlock()
+}
+
+// resolve maps a compose service name to the host to dial, reporting
+// whether it is routed; other names are left to DNS
+func (s *Server) resolve(host string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if h, ok := s.services[host]; ok {
+		return h, true
+	}
+	return host, false
+}
+
+// ListenAndServe accepts SOCKS5 connections on addr
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := listener.Listen(addr, listener.Options{})
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts SOCKS5 connections on ln until it is closed
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	user, err := s.authenticate(conn)
+	if err != nil {
+		socksConnections.Inc(ResultUnauthorized)
+		return
+	}
+
+	dest, rep, err := readRequest(conn)
+	if err != nil {
+		if rep != 0 {
+			socksConnections.Inc(ResultError)
+			writeReply(conn, rep)
+		}
+		return
+	}
+
+	host, port, _ := net.SplitHostPort(dest)
+	target, routed := s.resolve(host)
+	upstream, err := s.dialer(routed).DialContext(context.Background(), "tcp", net.JoinHostPort(target, port))
+	if err != nil {
+		if errors.Is(err, errNotAllowed) {
+			socksConnections.Inc(ResultDenied)
+			slog.Warn("socks denied", "dest", dest, "user", user, "client", conn.RemoteAddr().String())
+			writeReply(conn, repNotAllowed)
+			return
+		}
+		socksConnections.Inc(ResultError)
+		slog.Warn("socks connection failed", "dest", dest, "err", err)
+		writeReply(conn, dialReply(err))
+		return
+	}
+	defer upstream.Close()
+	socksConnections.Inc(ResultAllowed)
+	slog.Info("socks connected", "user", user, "dest", dest, "client", conn.RemoteAddr().String())
+
+	if err := writeReply(conn, repSucceeded); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	go func() {
+		// Once the client is done sending, let the service know but keep
+		// reading its reply
+		io.Copy(upstream, conn)
+		if tc, ok := upstream.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		}
+	}()
+	io.Copy(conn, upstream)
+}
+
+// authenticate negotiates username/password authentication and returns the user
+func (s *Server) authenticate(conn net.Conn) (string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != version5 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	if !strings.ContainsRune(string(methods), methodUserPass) {
+		conn.Write([]byte{version5, methodNoneValid})
+		return "", errors.New("client doesn't offer username/password authentication")
+	}
+	if _, err := conn.Write([]byte{version5, methodUserPass}); err != nil {
+		return "", err
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != authVersion {
+		return "", fmt.Errorf("unsupported auth version %d", hdr[0])
+	}
+	user := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return "", err
+	}
+	var plen [1]byte
+	if _, err := io.ReadFull(conn, plen[:]); err != nil {
+		return "", err
+	}
+	password := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return "", err
+	}
+
+	want, known := s.users[string(user)]
+	if subtle.ConstantTimeCompare(password, []byte(want)) != 1 || !known {
+		conn.Write([]byte{authVersion, 0x01})
+		slog.Warn("socks authentication failed", "user", user, "client", conn.RemoteAddr().String())
+		return "", errors.New("invalid credentials")
+	}
+	_, err := conn.Write([]byte{authVersion, 0x00})
+	return string(user), err
+}
+
+// readRequest reads a CONNECT request and returns its host:port. On
+// failure, rep is the reply code to send, or 0 if the connection is unusable.
+func readRequest(conn net.Conn) (dest string, rep byte, err error) {
+	var hdr [4]byte // VER CMD RSV ATYP
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	if hdr[0] != version5 {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", hdr[0])
+	}
+
+	var host string
+	switch hdr[3] {
+	case atypIPv4, atypIPv6:
+		ip := make([]byte, 4)
+		if hdr[3] == atypIPv6 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", 0, err
+		}
+		addr, _ := netip.AddrFromSlice(ip)
+		host = addr.String()
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		return "", repAddressUnsupported, fmt.Errorf("unsupported address type %d", hdr[3])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", 0, err
+	}
+	if hdr[1] != cmdConnect {
+		return "", repCommandUnsupported, fmt.Errorf("unsupported command %d", hdr[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), 0, nil
+}
+
+// writeReply sends a reply with an empty bound address; clients of
+// CONNECT don't use it
+func writeReply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{version5, rep, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// dialReply maps a dial error to a SOCKS reply code
+func dialReply(err error) byte {
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return repConnectionRefused
+	case errors.As(err, new(*net.DNSError)):
+		return repHostUnreachable
+	default:
+		return repGeneralFailure
+	}
+}
@@ -0,0 +1,125 @@
+package socks
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestNew(t *testing.T) {
+	for _, users := range [][]string{nil, {"dev"}, {":secret"}, {"dev:"}} {
+		if _, err := New(users, nil); err == nil {
+			t.Errorf("New(%q) accepted", users)
+		}
+	}
+	if _, err := New([]string{"dev:secret"}, []string{"10.0.0.0/33"}); err == nil {
+		t.Error("New() accepted an invalid allowed CIDR")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	s, err := New([]string{"dev:secret"}, []string{"172.18.0.0/16", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetServices(map[string]string{"api": "api", "cache": "10.0.5.5"})
+	s.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("10.0.5.4")}, nil // api's container
+	}
+
+	tests := []struct {
+		addr   string
+		routed bool
+		want   bool
+	}{
+		{"172.18.0.5", false, true}, // allowed CIDR
+		{"127.0.0.1", false, true},  // allowed explicitly
+		{"10.0.5.4", true, true},    // what a routed name resolved to
+		{"10.0.5.4", false, true},   // a routed backend dialed by IP
+		{"10.0.5.5", false, true},   // a routed backend given as an IP
+		{"10.0.0.1", false, false},  // elsewhere on the private network
+		{"192.168.1.10", false, false},
+		{"8.8.8.8", false, false},
+		{"127.0.0.2", true, false}, // loopback needs allowing, even for routes
+		{"::1", false, false},
+	}
+	for _, tt := range tests {
+		if got := s.allowed(netip.MustParseAddr(tt.addr), tt.routed); got != tt.want {
+			t.Errorf("allowed(%s, routed=%v) = %v, want %v", tt.addr, tt.routed, got, tt.want)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	// An echo service standing in for an internal-only database
+	svc, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close()
+	go func() {
+		for {
+			c, err := svc.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(svc.Addr().String())
+
+	s, err := New([]string{"dev:secret"}, []string{"127.0.0.1"}) // the test service is on loopback
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetServices(map[string]string{"db": "127.0.0.1"})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go s.Serve(ln)
+
+	dial := func(user, password, dest string) (net.Conn, error) {
+		d, err := proxy.SOCKS5("tcp", ln.Addr().String(), &proxy.Auth{User: user, Password: password}, proxy.Direct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Dial("tcp", dest)
+	}
+
+	t.Run("service name", func(t *testing.T) {
+		conn, err := dial("dev", "secret", "db:"+port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, "ping")
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("echo = %q, %v", buf, err)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		if _, err := dial("dev", "guess", "db:"+port); err == nil {
+			t.Error("connected with a wrong password")
+		}
+	})
+
+	t.Run("outside the stack", func(t *testing.T) {
+		_, err := dial("dev", "secret", "127.0.0.2:"+port)
+		if err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("dial error = %v, want not allowed", err)
+		}
+	})
+}
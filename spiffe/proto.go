@@ -0,0 +1,134 @@
+package spiffe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The Workload API speaks protobuf. Only the few messages liteproxy reads
+// are decoded here, by hand, to avoid pulling in a gRPC stack:
+//
+//	message X509SVIDResponse {
+//	  repeated X509SVID svids = 1;
+//	  repeated bytes crl = 2;
+//	  map<string, bytes> federated_bundles = 3;
+//	}
+//	message X509SVID {
+//	  string spiffe_id = 1;
+//	  bytes x509_svid = 2;     // ASN.1 DER certificates, leaf first
+//	  bytes x509_svid_key = 3; // PKCS#8 DER private key
+//	  bytes bundle = 4;        // ASN.1 DER CA certificates
+//	}
+
+// svidResponse is a decoded X509SVIDResponse
+type svidResponse struct {
+	svids            []svidMessage
+	federatedBundles map[string][]byte // trust domain ID -> DER certificates
+}
+
+// svidMessage is a decoded X509SVID
+type svidMessage struct {
+	id     string
+	certs  []byte
+	key    []byte
+	bundle []byte
+}
+
+var errTruncated = errors.New("truncated protobuf message")
+
+func decodeSVIDResponse(b []byte) (svidResponse, error) {
+	var resp svidResponse
+	err := eachField(b, func(num int, data []byte) error {
+		switch num {
+		case 1:
+			svid, err := decodeSVID(data)
+			if err != nil {
+				return err
+			}
+			resp.svids = append(resp.svids, svid)
+		case 3:
+			var td string
+			var bundle []byte
+			err := eachField(data, func(num int, data []byte) error {
+				switch num {
+				case 1:
+					td = string(data)
+				case 2:
+					bundle = data
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if resp.federatedBundles == nil {
+				resp.federatedBundles = make(map[string][]byte)
+			}
+			resp.federatedBundles[td] = bundle
+		}
+		return nil
+	})
+	return resp, err
+}
+
+func decodeSVID(b []byte) (svidMessage, error) {
+	var svid svidMessage
+	err := eachField(b, func(num int, data []byte) error {
+		switch num {
+		case 1:
+			svid.id = string(data)
+		case 2:
+			svid.certs = data
+		case 3:
+			svid.key = data
+		case 4:
+			svid.bundle = data
+		}
+		return nil
+	})
+	return svid, err
+}
+
+// eachField calls fn with the payload of every length-delimited field in b,
+// skipping fields of other wire types
+func eachField(b []byte, fn func(num int, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		num, wireType := int(tag>>3), tag&7
+		switch wireType {
+		case 0: // varint
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errTruncated
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errTruncated
+			}
+			data := b[n : n+int(size)]
+			b = b[n+int(size):]
+			if err := fn(num, data); err != nil {
+				return err
+			}
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errTruncated
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+	}
+	return nil
+}
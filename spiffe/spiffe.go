@@ -0,0 +1,317 @@
+// Package spiffe fetches X.509 workload identities (SVIDs) from a SPIFFE
+// Workload API, such as a SPIRE agent, and verifies peers by SPIFFE ID
+package spiffe
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fetchURL       = "http://localhost/SpiffeWorkloadAPI/FetchX509SVID"
+	maxMessageSize = 4 << 20
+	maxBackoff     = 30 * time.Second
+)
+
+// Source holds the current SVID and trust bundles, kept up to date from the
+// Workload API's stream so rotated certificates are picked up as they're issued
+type Source struct {
+	client *http.Client
+
+	mu      sync.RWMutex
+	id      string
+	cert    *tls.Certificate
+	bundles map[string]*x509.CertPool // trust domain -> CA certificates
+
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+// NewSource starts streaming SVIDs from the Workload API at addr, either
+// unix:///path/to/socket or a socket path. The stream reconnects until ctx ends.
+func NewSource(ctx context.Context, addr string) (*Source, error) {
+	path := strings.TrimPrefix(addr, "unix://")
+	if path == "" || !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("workload API address %q: want unix:///path/to/socket", addr)
+	}
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	s := &Source{
+		client: &http.Client{Transport: &http.Transport{
+			Protocols: protocols,
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}},
+		ready: make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s, nil
+}
+
+// Ready is closed once the first SVID has been received
+func (s *Source) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// ID returns the SPIFFE ID of the current SVID, or "" before the first one
+func (s *Source) ID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.id
+}
+
+// GetClientCertificate presents the current SVID, for tls.Config
+func (s *Source) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert == nil {
+		return nil, errors.New("no SVID received from the workload API yet")
+	}
+	return s.cert, nil
+}
+
+// VerifyPeer returns a tls.Config VerifyPeerCertificate callback accepting
+// certificates that chain to the peer's trust bundle and carry a SPIFFE ID
+// matching want (see MatchID). It's used with InsecureSkipVerify, as SVIDs
+// carry no DNS names for the standard verification to check.
+func (s *Source) VerifyPeer(want string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("spiffe: peer sent no certificate")
+		}
+		intermediates := x509.NewCertPool()
+		var leaf *x509.Certificate
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("spiffe: parsing peer certificate: %w", err)
+			}
+			if i == 0 {
+				leaf = cert
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+		id, err := certID(leaf)
+		if err != nil {
+			return err
+		}
+		if !MatchID(want, id) {
+			return fmt.Errorf("spiffe: peer ID %s, want %s", id, want)
+		}
+		td, _ := ParseID(id)
+		s.mu.RLock()
+		roots := s.bundles[td]
+		s.mu.RUnlock()
+		if roots == nil {
+			return fmt.Errorf("spiffe: no trust bundle for %s", td)
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("spiffe: verifying %s: %w", id, err)
+		}
+		return nil
+	}
+}
+
+// ParseID checks a SPIFFE ID (spiffe://trust-domain/path) and returns its trust domain
+func ParseID(id string) (trustDomain string, err error) {
+	u, err := url.Parse(id)
+	if err != nil || u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.Port() != "" ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid SPIFFE ID %q: want spiffe://trust-domain/path", id)
+	}
+	if u.Host != strings.ToLower(u.Host) {
+		return "", fmt.Errorf("invalid SPIFFE ID %q: trust domain must be lowercase", id)
+	}
+	return u.Host, nil
+}
+
+// MatchID reports whether id satisfies want. A want without a path, like
+// spiffe://example.org, accepts any ID in that trust domain.
+func MatchID(want, id string) bool {
+	if id == want {
+		return true
+	}
+	return !strings.Contains(strings.TrimPrefix(want, "spiffe://"), "/") &&
+		strings.HasPrefix(id, strings.TrimSuffix(want, "/")+"/")
+}
+
+// certID returns the SPIFFE ID in a certificate's URI SANs
+func certID(cert *x509.Certificate) (string, error) {
+	var ids []string
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			ids = append(ids, u.String())
+		}
+	}
+	if len(ids) != 1 {
+		return "", fmt.Errorf("spiffe: peer certificate has %d SPIFFE IDs, want 1", len(ids))
+	}
+	return ids[0], nil
+}
+
+// run keeps a stream open, reconnecting with backoff when it fails
+func (s *Source) run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		received, err := s.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = time.Second
+		}
+		slog.Error("spiffe: workload API failed", "err", err, "retry_in", backoff.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// stream reads X509SVIDResponse messages until the stream ends, reporting
+// whether any update was applied
+func (s *Source) stream(ctx context.Context) (received bool, err error) {
+	// An empty X509SVIDRequest in a gRPC frame: uncompressed, zero length
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fetchURL, bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("workload.spiffe.io", "true") // required by the spec as SSRF protection
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := grpcStatus(resp.Header); err != nil {
+		return false, err
+	}
+
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				if err := grpcStatus(resp.Trailer); err != nil {
+					return received, err
+				}
+				return received, errors.New("stream closed")
+			}
+			return received, err
+		}
+		if header[0] != 0 {
+			return received, errors.New("compressed messages are not supported")
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if size > maxMessageSize {
+			return received, fmt.Errorf("message of %d bytes is too large", size)
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			return received, err
+		}
+		if err := s.update(msg); err != nil {
+			return received, err
+		}
+		received = true
+	}
+}
+
+// grpcStatus returns the error carried by grpc-status, if any
+func grpcStatus(h http.Header) error {
+	if code := h.Get("Grpc-Status"); code != "" && code != "0" {
+		return fmt.Errorf("grpc status %s: %s", code, h.Get("Grpc-Message"))
+	}
+	return nil
+}
+
+// update applies an X509SVIDResponse, using its first (default) SVID
+func (s *Source) update(msg []byte) error {
+	resp, err := decodeSVIDResponse(msg)
+	if err != nil {
+		return err
+	}
+	if len(resp.svids) == 0 {
+		return errors.New("response has no SVIDs")
+	}
+	svid := resp.svids[0]
+	td, err := ParseID(svid.id)
+	if err != nil {
+		return err
+	}
+	certs, err := x509.ParseCertificates(svid.certs)
+	if err != nil || len(certs) == 0 {
+		return fmt.Errorf("parsing SVID %s certificates: %v", svid.id, err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(svid.key)
+	if err != nil {
+		return fmt.Errorf("parsing SVID %s key: %w", svid.id, err)
+	}
+	cert := &tls.Certificate{PrivateKey: key, Leaf: certs[0]}
+	for _, c := range certs {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+
+	bundles := make(map[string]*x509.CertPool)
+	own, err := certPool(svid.bundle)
+	if err != nil {
+		return fmt.Errorf("parsing %s bundle: %w", td, err)
+	}
+	bundles[td] = own
+	for id, der := range resp.federatedBundles {
+		pool, err := certPool(der)
+		if err != nil {
+			return fmt.Errorf("parsing %s bundle: %w", id, err)
+		}
+		bundles[strings.TrimPrefix(id, "spiffe://")] = pool
+	}
+
+	s.mu.Lock()
+	s.id, s.cert, s.bundles = svid.id, cert, bundles
+	s.mu.Unlock()
+	slog.Info("spiffe: received SVID", "id", svid.id, "expires", certs[0].NotAfter.Format(time.RFC3339))
+	s.readyOnce.Do(func() { close(s.ready) })
+	return nil
+}
+
+func certPool(der []byte) (*x509.CertPool, error) {
+	certs, err := x509.ParseCertificates(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+	return pool, nil
+}
@@ -0,0 +1,238 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues SVIDs for a trust domain
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns the DER certificate and PKCS#8 key of an SVID for id
+func (ca *testCA) issue(t *testing.T, id string) (cert, key []byte) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(id)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{u},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	cert, err = x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &priv.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// field encodes a length-delimited protobuf field
+func field(num int, data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// serveWorkloadAPI runs a fake Workload API that sends one response and
+// keeps the stream open, returning the socket address
+func serveWorkloadAPI(t *testing.T, response []byte) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "spiffe") // short enough for a socket path
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{
+		Protocols: protocols,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status")
+			if r.URL.Path != "/SpiffeWorkloadAPI/FetchX509SVID" || r.Header.Get("workload.spiffe.io") != "true" {
+				w.Header().Set("Grpc-Status", "3")
+				return
+			}
+			frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(response)))
+			w.Write(append(frame, response...))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}),
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "unix://" + socket
+}
+
+func TestSource(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+	svid, key := ca.issue(t, "spiffe://example.org/liteproxy")
+	response := field(1, concat(
+		field(1, []byte("spiffe://example.org/liteproxy")),
+		field(2, svid),
+		field(3, key),
+		field(4, ca.cert.Raw),
+	))
+	response = append(response, field(3, concat(
+		field(1, []byte("spiffe://partner.org")),
+		field(2, other.cert.Raw),
+	))...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src, err := NewSource(ctx, serveWorkloadAPI(t, response))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-src.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no SVID received")
+	}
+
+	if got := src.ID(); got != "spiffe://example.org/liteproxy" {
+		t.Errorf("ID() = %q", got)
+	}
+	cert, err := src.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 1 || cert.PrivateKey == nil {
+		t.Errorf("client certificate has %d certificates, key %v", len(cert.Certificate), cert.PrivateKey)
+	}
+
+	api, _ := ca.issue(t, "spiffe://example.org/api")
+	partner, _ := other.issue(t, "spiffe://partner.org/billing")
+	forged, _ := other.issue(t, "spiffe://example.org/api")
+	tests := []struct {
+		name    string
+		want    string
+		peer    []byte
+		wantErr bool
+	}{
+		{"exact ID", "spiffe://example.org/api", api, false},
+		{"trust domain", "spiffe://example.org", api, false},
+		{"wrong ID", "spiffe://example.org/db", api, true},
+		{"federated trust domain", "spiffe://partner.org/billing", partner, false},
+		{"signed by another trust domain's CA", "spiffe://example.org/api", forged, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := src.VerifyPeer(tt.want)([][]byte{tt.peer}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyPeer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewSourceAddress(t *testing.T) {
+	for _, addr := range []string{"", "tcp://127.0.0.1:8081", "agent.sock"} {
+		if _, err := NewSource(context.Background(), addr); err == nil {
+			t.Errorf("NewSource(%q): expected error", addr)
+		}
+	}
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{"spiffe://example.org/ns/prod/sa/api", "example.org", false},
+		{"spiffe://example.org", "example.org", false},
+		{"https://example.org/api", "", true},
+		{"spiffe:///api", "", true},
+		{"spiffe://Example.org/api", "", true},
+		{"spiffe://example.org:443/api", "", true},
+		{"spiffe://example.org/api?x=1", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseID(tt.id)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseID(%q) = %q, %v; want %q, wantErr %v", tt.id, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMatchID(t *testing.T) {
+	tests := []struct {
+		want, id string
+		match    bool
+	}{
+		{"spiffe://example.org/api", "spiffe://example.org/api", true},
+		{"spiffe://example.org/api", "spiffe://example.org/api/v2", false},
+		{"spiffe://example.org", "spiffe://example.org/api", true},
+		{"spiffe://example.org", "spiffe://example.org.evil/api", false},
+		{"spiffe://example.org", "spiffe://other.org/api", false},
+	}
+	for _, tt := range tests {
+		if got := MatchID(tt.want, tt.id); got != tt.match {
+			t.Errorf("MatchID(%q, %q) = %v, want %v", tt.want, tt.id, got, tt.match)
+		}
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/localrivet/liteproxy/accesslog"
+	"github.com/localrivet/liteproxy/admin"
+)
+
+// runTail implements `liteproxy tail`: stream live traffic from the admin API
+func runTail(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	host := fs.String("host", "", "only requests for this host")
+	path := fs.String("path", "", "only requests whose URI starts with this prefix")
+	status := fs.String("status", "", "only responses with this status code (404) or class (5xx)")
+	jsonOutput := fs.Bool("json", false, "print entries as JSON lines")
+	addr := fs.String("admin", os.Getenv("LITEPROXY_ADMIN_ADDR"), "admin API address")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *addr == "" {
+		fmt.Fprintln(stderr, "liteproxy tail: no admin API address (set LITEPROXY_ADMIN_ADDR or pass -admin)")
+		return 2
+	}
+
+	q := url.Values{}
+	for k, v := range map[string]string{"host": *host, "path": *path, "status": *status} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if _, err := admin.ParseTailFilter(q); err != nil {
+		fmt.Fprintf(stderr, "liteproxy tail: %v\n", err)
+		return 2
+	}
+
+	u := url.URL{Scheme: "http", Host: adminDialAddr(*addr), Path: "/tail", RawQuery: q.Encode()}
+	resp, err := http.Get(u.String())
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy tail: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		fmt.Fprintf(stderr, "liteproxy tail: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if *jsonOutput {
+			fmt.Fprintln(stdout, scanner.Text())
+			continue
+		}
+		var e accesslog.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			fmt.Fprintf(stderr, "liteproxy tail: %v\n", err)
+			continue
+		}
+		fmt.Fprintln(stdout, formatTailEntry(e))
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "liteproxy tail: %v\n", err)
+	} else {
+		fmt.Fprintln(stderr, "liteproxy tail: stream closed by the proxy")
+	}
+	return 1
+}
+
+// formatTailEntry renders an entry as one human-readable line
+//
+//	12:04:05.123 502 GET example.com/api/users 30.1ms api:8080
+func formatTailEntry(e accesslog.Entry) string {
+	line := fmt.Sprintf("%s %d %s %s%s %.1fms",
+		e.Time.Local().Format("15:04:05.000"), e.Status, e.Method, e.Host, e.URI, e.Duration)
+	if e.Backend != "" {
+		line += " " + e.Backend
+	}
+	return line
+}
+
+// adminDialAddr returns the address to connect to the admin API on,
+// mapping wildcard listen addresses to loopback
+func adminDialAddr(addr string) string {
+	host, port, err := net.SplitHostPort(admin.Addr(addr))
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/localrivet/liteproxy/accesslog"
+)
+
+func TestRunTail(t *testing.T) {
+	var gotQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"time":"2026-01-02T03:04:05Z","method":"GET","host":"example.com","uri":"/api/users","status":502,"duration_ms":30.14,"backend":"api:8080"}` + "\n"))
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	var stdout, stderr bytes.Buffer
+	runTail([]string{"-admin", addr, "-host", "example.com", "-status", "5xx"}, &stdout, &stderr)
+	if gotQuery != "host=example.com&status=5xx" {
+		t.Errorf("query = %q", gotQuery)
+	}
+	want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Local().Format("15:04:05.000") + " 502 GET example.com/api/users 30.1ms api:8080\n"
+	if stdout.String() != want {
+		t.Errorf("output = %q, want %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	runTail([]string{"-admin", addr, "-json"}, &stdout, &stderr)
+	if !strings.HasPrefix(stdout.String(), `{"time":"2026-01-02T03:04:05Z"`) {
+		t.Errorf("json output = %q", stdout.String())
+	}
+
+	if code := runTail([]string{"-admin", addr, "-status", "bad"}, &stdout, &stderr); code != 2 {
+		t.Errorf("invalid status: exit %d, want 2", code)
+	}
+	t.Setenv("LITEPROXY_ADMIN_ADDR", "")
+	if code := runTail(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("no admin address: exit %d, want 2", code)
+	}
+}
+
+func TestFormatTailEntry(t *testing.T) {
+	e := accesslog.Entry{Time: time.Now(), Method: "POST", Host: "example.com", URI: "/login", Status: 404, Duration: 1.25}
+	if got := formatTailEntry(e); !strings.HasSuffix(got, " 404 POST example.com/login 1.2ms") {
+		t.Errorf("formatTailEntry() = %q", got)
+	}
+}
+
+func TestAdminDialAddr(t *testing.T) {
+	tests := map[string]string{
+		"9901":           "127.0.0.1:9901",
+		"0.0.0.0:9901":   "127.0.0.1:9901",
+		"[::]:9901":      "127.0.0.1:9901",
+		"10.0.0.5:9901":  "10.0.0.5:9901",
+		"localhost:9901": "localhost:9901",
+	}
+	for in, want := range tests {
+		if got := adminDialAddr(in); got != want {
+			t.Errorf("adminDialAddr(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/localrivet/liteproxy/metrics"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME challenge types used as the type label
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// Certificate lookup results used as the result label
+const (
+	LookupHit   = "hit"   // served from memory or the certificate directory
+	LookupMiss  = "miss"  // the handshake waited for a new certificate
+	LookupError = "error" // no certificate could be served
+)
+
+// issuanceBuckets suit ACME orders, which take seconds rather than milliseconds
+var issuanceBuckets = []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+var (
+	acmeChallenges = metrics.Default.NewCounterVec(
+		"liteproxy_acme_challenges_total",
+		"ACME challenge requests answered, by challenge type.",
+		"type")
+	acmeIssuance = metrics.Default.NewHistogramVec(
+		"liteproxy_acme_issuance_duration_seconds",
+		"Time from starting an ACME order to storing the issued certificate.",
+		issuanceBuckets)
+	certLookups = metrics.Default.NewCounterVec(
+		"liteproxy_tls_certificate_lookups_total",
+		"Certificate lookups during TLS handshakes, by result.",
+		"result")
+)
+
+// challengePrefix starts the paths of HTTP-01 challenge requests
+const challengePrefix = "/.well-known/acme-challenge/"
+
+// issuanceTracker sits between an autocert manager and its cache, noticing
+// orders starting (through the host policy, which autocert consults only for
+// hosts without a certificate) and certificates being stored
+type issuanceTracker struct {
+	autocert.Cache
+
+	mu      sync.Mutex
+	stored  map[string]uint64    // certificates stored per host
+	started map[string]time.Time // orders in flight per host
+}
+
+func newIssuanceTracker(cache autocert.Cache) *issuanceTracker {
+	return &issuanceTracker{
+		Cache:   cache,
+		stored:  make(map[string]uint64),
+		started: make(map[string]time.Time),
+	}
+}
+
+// Put records certificates as they are stored, observing how long the
+// order took. Renewals, which don't consult the host policy, aren't timed.
+func (t *issuanceTracker) Put(ctx context.Context, key string, data []byte) error {
+	if err := t.Cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	host, ok := certHost(key)
+	if !ok {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stored[host]++
+	if start, ok := t.started[host]; ok {
+		acmeIssuance.Observe(time.Since(start).Seconds())
+		delete(t.started, host)
+	}
+	return nil
+}
+
+// certHost returns the host of a certificate cache key: the host itself,
+// or host+rsa for RSA certificates. Account keys and challenge tokens
+// contain a + of their own.
+func certHost(key string) (string, bool) {
+	host := strings.TrimSuffix(key, "+rsa")
+	if strings.Contains(host, "+") {
+		return "", false
+	}
+	return host, true
+}
+
+// policy wraps the host policy to note when an order for a host starts.
+// It must be outermost, so orders another policy refuses aren't noted.
+func (t *issuanceTracker) policy(next autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if err := next(ctx, host); err != nil {
+			return err
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.started[host]; !ok {
+			t.started[host] = time.Now()
+		}
+		return nil
+	}
+}
+
+func (t *issuanceTracker) storedFor(host string) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stored[host]
+}
+
+// getCertificate counts lookups by next as hits, misses or errors: a
+// lookup during which a certificate for the host was stored had to wait
+// for it. TLS-ALPN-01 challenges are counted as challenges.
+func (t *issuanceTracker) getCertificate(next func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if slices.Equal(hello.SupportedProtos, []string{acme.ALPNProto}) {
+			acmeChallenges.Inc(ChallengeTLSALPN01)
+			return next(hello)
+		}
+		host := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+		before := t.storedFor(host)
+		cert, err := next(hello)
+		switch {
+		case err != nil:
+			certLookups.Inc(LookupError)
+		case t.storedFor(host) != before:
+			certLookups.Inc(LookupMiss)
+		default:
+			certLookups.Inc(LookupHit)
+		}
+		return cert, err
+	}
+}
+
+// ChallengeHandler returns m's HTTP-01 challenge handler, counting the
+// challenge requests it answers
+func ChallengeHandler(m *autocert.Manager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := m.HTTPHandler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, challengePrefix) {
+				acmeChallenges.Inc(ChallengeHTTP01)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCertHost(t *testing.T) {
+	tests := map[string]string{
+		"example.com":      "example.com",
+		"example.com+rsa":  "example.com",
+		"acme_account+key": "",
+		"abc123+http-01":   "",
+		"abc123+token":     "",
+	}
+	for key, want := range tests {
+		host, ok := certHost(key)
+		if host != want || ok != (want != "") {
+			t.Errorf("certHost(%q) = %q, %v; want %q", key, host, ok, want)
+		}
+	}
+}
+
+func TestIssuanceTracker(t *testing.T) {
+	tr := newIssuanceTracker(autocert.DirCache(t.TempDir()))
+	ctx := context.Background()
+	allow := func(context.Context, string) error { return nil }
+	refuse := func(context.Context, string) error { return errors.New("not configured") }
+
+	// Cached certificates are hits; a lookup during which the certificate
+	// was stored is a miss, and the order it waited for is timed. Like
+	// autocert, the fake normalizes the server name.
+	next := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+		switch name {
+		case "new.example.com", "other.example.com":
+			if err := tr.policy(allow)(ctx, name); err != nil {
+				return nil, err
+			}
+			tr.Put(ctx, name, []byte("cert"))
+		case "unknown.example.com":
+			return nil, tr.policy(refuse)(ctx, name)
+		}
+		return &tls.Certificate{}, nil
+	}
+	get := tr.getCertificate(next)
+
+	hits, misses, errs := certLookups.Value(LookupHit), certLookups.Value(LookupMiss), certLookups.Value(LookupError)
+	issued := acmeIssuance.Count()
+	alpn := acmeChallenges.Value(ChallengeTLSALPN01)
+
+	get(&tls.ClientHelloInfo{ServerName: "cached.example.com"})
+	get(&tls.ClientHelloInfo{ServerName: "New.Example.com."})
+	get(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	get(&tls.ClientHelloInfo{ServerName: "unknown.example.com"})
+	get(&tls.ClientHelloInfo{ServerName: "pending.example.com", SupportedProtos: []string{acme.ALPNProto}})
+
+	if got := certLookups.Value(LookupHit) - hits; got != 1 {
+		t.Errorf("hits = %d, want 1", got)
+	}
+	if got := certLookups.Value(LookupMiss) - misses; got != 2 {
+		t.Errorf("misses = %d, want 2", got)
+	}
+	if got := certLookups.Value(LookupError) - errs; got != 1 {
+		t.Errorf("errors = %d, want 1", got)
+	}
+	if got := acmeIssuance.Count() - issued; got != 2 {
+		t.Errorf("issuances timed = %d, want 2", got)
+	}
+	if got := acmeChallenges.Value(ChallengeTLSALPN01) - alpn; got != 1 {
+		t.Errorf("TLS-ALPN-01 challenges = %d, want 1", got)
+	}
+
+	// Stored certificates are still read back through the cache
+	if data, err := tr.Get(ctx, "new.example.com"); err != nil || string(data) != "cert" {
+		t.Errorf("Get() = %q, %v", data, err)
+	}
+	if _, ok := tr.started["unknown.example.com"]; ok {
+		t.Error("refused order was timed")
+	}
+}
+
+func TestChallengeHandler(t *testing.T) {
+	m := Manager(Config{CacheDir: t.TempDir(), Hosts: NewHostList([]string{"example.com"})})
+	h := ChallengeHandler(m)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	before := acmeChallenges.Value(ChallengeHTTP01)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/token", nil))
+	if got := acmeChallenges.Value(ChallengeHTTP01) - before; got != 1 {
+		t.Errorf("HTTP-01 challenges = %d, want 1", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	if w.Code != http.StatusTeapot || acmeChallenges.Value(ChallengeHTTP01)-before != 1 {
+		t.Errorf("other request = %d, challenges %d; want the fallback and no count", w.Code, acmeChallenges.Value(ChallengeHTTP01)-before)
+	}
+}
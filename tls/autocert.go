@@ -1,46 +1,108 @@
 package tls
 
 import (
+	"context"
 	"crypto/tls"
-	"log"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
 
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
 
 // Config holds TLS configuration
 type Config struct {
-	Email    string   // ACME account email
-	CacheDir string   // Directory to store certificates
-	Hosts    []string // Allowed hosts for certificate issuance
+	Email    string    // ACME account email
+	CacheDir string    // Directory to store certificates
+	Hosts    *HostList // Allowed hosts for certificate issuance
+
+	DirectoryURL string                       // ACME directory; empty means Let's Encrypt production
+	EAB          *acme.ExternalAccountBinding // Optional: binds the account to one at the CA (ZeroSSL, Google)
 }
 
-// Manager creates an autocert manager for automatic Let's Encrypt certificates
+// Manager creates an autocert manager for automatic certificates, from
+// Let's Encrypt unless cfg names another directory
 func Manager(cfg Config) *autocert.Manager {
-	return &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Email:      cfg.Email,
-		Cache:      autocert.DirCache(cfg.CacheDir),
-		HostPolicy: autocert.HostWhitelist(cfg.Hosts...),
+	m := &autocert.Manager{
+		Prompt:                 autocert.AcceptTOS,
+		Email:                  cfg.Email,
+		Cache:                  newIssuanceTracker(autocert.DirCache(cfg.CacheDir)),
+		HostPolicy:             cfg.Hosts.Policy,
+		ExternalAccountBinding: cfg.EAB,
+	}
+	if cfg.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.DirectoryURL}
 	}
+	return m
 }
 
-// TLSConfig returns a tls.Config using the autocert manager
-func TLSConfig(m *autocert.Manager) *tls.Config {
-	return &tls.Config{
-		GetCertificate: m.GetCertificate,
-		NextProtos:     []string{"h2", "http/1.1"},
-		MinVersion:     tls.VersionTLS12,
-	}
+// HostList is the set of hosts allowed certificates
+// It is updated in place on reload so the manager (and any challenge it has
+// in flight) stays the one the TLS listeners use
+type HostList struct {
+	hosts atomic.Pointer[map[string]bool]
 }
 
-// UpdateHosts creates a new manager with updated hosts
+// NewHostList creates a host list allowing hosts
+func NewHostList(hosts []string) *HostList {
+	l := &HostList{}
+	l.store(hosts)
+	return l
+}
+
+// Set replaces the allowed hosts
 // This is called when the compose file is reloaded
-func UpdateHosts(m *autocert.Manager, hosts []string) *autocert.Manager {
-	log.Printf("updating TLS hosts: %v", hosts)
-	return &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Email:      m.Email,
-		Cache:      m.Cache,
-		HostPolicy: autocert.HostWhitelist(hosts...),
+func (l *HostList) Set(hosts []string) {
+	slog.Info("updating TLS hosts", "hosts", hosts)
+	l.store(hosts)
+}
+
+func (l *HostList) store(hosts []string) {
+	set := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		set[strings.ToLower(h)] = true
+	}
+	l.hosts.Store(&set)
+}
+
+// List returns the allowed hosts, sorted
+func (l *HostList) List() []string {
+	hosts := slices.Collect(maps.Keys(*l.hosts.Load()))
+	slices.Sort(hosts)
+	return hosts
+}
+
+// Policy is an autocert.HostPolicy allowing only hosts in the list
+func (l *HostList) Policy(_ context.Context, host string) error {
+	if !(*l.hosts.Load())[host] {
+		return fmt.Errorf("acme/autocert: host %q not configured", host)
+	}
+	return nil
+}
+
+// TrackIssuance wraps m's host policy to time orders for
+// liteproxy_acme_issuance_duration_seconds. Call it once, after every other
+// policy is installed, so orders they refuse aren't timed.
+func TrackIssuance(m *autocert.Manager) {
+	if t, ok := m.Cache.(*issuanceTracker); ok {
+		m.HostPolicy = t.policy(m.HostPolicy)
+	}
+}
+
+// TLSConfig returns a tls.Config using the autocert manager, counting
+// certificate lookups
+func TLSConfig(m *autocert.Manager) *tls.Config {
+	getCertificate := m.GetCertificate
+	if t, ok := m.Cache.(*issuanceTracker); ok {
+		getCertificate = t.getCertificate(getCertificate)
+	}
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto}, // acme-tls/1 for TLS-ALPN-01 challenges
+		MinVersion:     tls.VersionTLS12,
 	}
 }
@@ -0,0 +1,191 @@
+package tls
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// bundleMagic starts every bundle and is authenticated with its contents
+const bundleMagic = "liteproxy-bundle-v1\n"
+
+// Key derivation for bundle passphrases (scrypt's recommended interactive
+// parameters) and the AES-256-GCM key it yields
+const (
+	bundleSaltSize = 16
+	bundleKeySize  = 32
+	bundleScryptN  = 1 << 15
+	bundleScryptR  = 8
+	bundleScryptP  = 1
+)
+
+// ErrBundlePassphrase means a bundle couldn't be decrypted: the passphrase
+// is wrong or the file was modified
+var ErrBundlePassphrase = errors.New("wrong passphrase or corrupted bundle")
+
+// ExportBundle writes every file in the certificate directory - the ACME
+// account key, cached certificates and the local CA - to w as a single
+// archive encrypted with passphrase. It returns the number of files.
+func ExportBundle(dir string, w io.Writer, passphrase []byte) (int, error) {
+	if len(passphrase) == 0 {
+		return 0, errors.New("empty passphrase")
+	}
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	files := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: filepath.ToSlash(name), Mode: 0o600, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		files++
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if files == 0 {
+		return 0, fmt.Errorf("no files in %s", dir)
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+
+	salt := make([]byte, bundleSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, err
+	}
+	aead, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return 0, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
+	}
+
+	out := append([]byte(bundleMagic), salt...)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, archive.Bytes(), []byte(bundleMagic))
+	if _, err := w.Write(out); err != nil {
+		return 0, err
+	}
+	return files, nil
+}
+
+// ImportBundle decrypts a bundle written by ExportBundle into dir. Unless
+// overwrite is set, it refuses to replace files that already exist, and
+// writes nothing in that case. It returns the number of files written.
+func ImportBundle(dir string, r io.Reader, passphrase []byte, overwrite bool) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.HasPrefix(data, []byte(bundleMagic)) {
+		return 0, errors.New("not a liteproxy bundle")
+	}
+	data = data[len(bundleMagic):]
+	if len(data) < bundleSaltSize {
+		return 0, ErrBundlePassphrase
+	}
+	salt, data := data[:bundleSaltSize], data[bundleSaltSize:]
+	aead, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < aead.NonceSize() {
+		return 0, ErrBundlePassphrase
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	archive, err := aead.Open(nil, nonce, data, []byte(bundleMagic))
+	if err != nil {
+		return 0, ErrBundlePassphrase
+	}
+
+	// Read everything first, so a bad entry or an existing file leaves the
+	// directory untouched
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return 0, err
+	}
+	type file struct {
+		path string
+		data []byte
+	}
+	var files []file
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(name) {
+			return 0, fmt.Errorf("bundle contains an invalid entry %q", hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return 0, err
+		}
+		path := filepath.Join(dir, name)
+		if !overwrite {
+			if _, err := os.Stat(path); err == nil {
+				return 0, fmt.Errorf("%s already exists", path)
+			}
+		}
+		files = append(files, file{path, data})
+	}
+
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+			return 0, err
+		}
+		if err := os.WriteFile(f.path, f.data, 0o600); err != nil {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
+
+func bundleCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, bundleScryptN, bundleScryptR, bundleScryptP, bundleKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
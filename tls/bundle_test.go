@@ -0,0 +1,88 @@
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"acme_account+key":         "account key",
+		"example.com":              "certificate and key",
+		LocalCAKeyFile:             "local CA key",
+		"staging/acme_account+key": "staging account key",
+	}
+	for name, data := range files {
+		path := filepath.Join(src, name)
+		os.MkdirAll(filepath.Dir(path), 0o700)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bundle bytes.Buffer
+	n, err := ExportBundle(src, &bundle, []byte("correct horse"))
+	if err != nil || n != len(files) {
+		t.Fatalf("ExportBundle() = %d, %v, want %d files", n, err, len(files))
+	}
+	if bytes.Contains(bundle.Bytes(), []byte("account key")) {
+		t.Error("bundle contains plaintext")
+	}
+
+	dst := t.TempDir()
+	if _, err := ImportBundle(dst, bytes.NewReader(bundle.Bytes()), []byte("wrong"), false); !errors.Is(err, ErrBundlePassphrase) {
+		t.Errorf("wrong passphrase: error = %v, want ErrBundlePassphrase", err)
+	}
+	tampered := bytes.Clone(bundle.Bytes())
+	tampered[len(tampered)-1] ^= 1
+	if _, err := ImportBundle(dst, bytes.NewReader(tampered), []byte("correct horse"), false); !errors.Is(err, ErrBundlePassphrase) {
+		t.Errorf("tampered bundle: error = %v, want ErrBundlePassphrase", err)
+	}
+
+	n, err = ImportBundle(dst, bytes.NewReader(bundle.Bytes()), []byte("correct horse"), false)
+	if err != nil || n != len(files) {
+		t.Fatalf("ImportBundle() = %d, %v, want %d files", n, err, len(files))
+	}
+	for name, want := range files {
+		path := filepath.Join(dst, name)
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm() != 0o600 {
+			t.Errorf("%s mode = %v, want 0600", name, info.Mode().Perm())
+		}
+	}
+
+	// Existing files are kept unless overwriting
+	os.WriteFile(filepath.Join(dst, "example.com"), []byte("newer"), 0o600)
+	if _, err := ImportBundle(dst, bytes.NewReader(bundle.Bytes()), []byte("correct horse"), false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("existing file: error = %v, want already exists", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "example.com")); string(got) != "newer" {
+		t.Errorf("refused import changed example.com to %q", got)
+	}
+	if _, err := ImportBundle(dst, bytes.NewReader(bundle.Bytes()), []byte("correct horse"), true); err != nil {
+		t.Errorf("overwrite: error = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "example.com")); string(got) != files["example.com"] {
+		t.Errorf("overwrite left example.com = %q", got)
+	}
+}
+
+func TestBundleErrors(t *testing.T) {
+	if _, err := ExportBundle(t.TempDir(), &bytes.Buffer{}, []byte("secret")); err == nil {
+		t.Error("ExportBundle() of an empty directory succeeded")
+	}
+	if _, err := ExportBundle(t.TempDir(), &bytes.Buffer{}, nil); err == nil {
+		t.Error("ExportBundle() without a passphrase succeeded")
+	}
+	if _, err := ImportBundle(t.TempDir(), strings.NewReader("-----BEGIN CERTIFICATE-----"), []byte("secret"), false); err == nil {
+		t.Error("ImportBundle() of a non-bundle succeeded")
+	}
+}
@@ -0,0 +1,273 @@
+package tls
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// LetsEncryptCAA is the CAA issuer domain identifying Let's Encrypt
+const LetsEncryptCAA = "letsencrypt.org"
+
+// caaRetry is how long a host whose CAA records forbid issuance stays
+// blocked before they are looked up again
+const caaRetry = time.Minute
+
+// typeCAA is the CAA resource record type (RFC 8659)
+const typeCAA dnsmessage.Type = 257
+
+// caaCritical is the issuer critical flag: a record with an unknown tag and
+// this flag set forbids issuance
+const caaCritical = 128
+
+// CAA is one CAA resource record
+type CAA struct {
+	Flags uint8
+	Tag   string
+	Value string
+}
+
+// CAAChecker looks up a host's CAA records before a certificate is
+// requested, so a record forbidding the CA shows up as an explicit error
+// instead of a generic failed ACME order
+type CAAChecker struct {
+	issuer string
+
+	// Swappable for tests
+	lookup func(ctx context.Context, name string) ([]CAA, error)
+	now    func() time.Time
+
+	mu     sync.Mutex
+	failed map[string]caaFailure
+}
+
+type caaFailure struct {
+	err  error
+	time time.Time
+}
+
+// NewCAAChecker creates a checker for certificates issued by the CA with
+// the given CAA issuer domain
+func NewCAAChecker(issuer string) *CAAChecker {
+	return &CAAChecker{
+		issuer: issuer,
+		lookup: lookupCAA,
+		now:    time.Now,
+		failed: make(map[string]caaFailure),
+	}
+}
+
+// Policy wraps an autocert host policy so hosts it allows are also checked
+// against their CAA records
+func (c *CAAChecker) Policy(next autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if err := next(ctx, host); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		last, ok := c.failed[host]
+		c.mu.Unlock()
+		if ok && c.now().Sub(last.time) < caaRetry {
+			return last.err
+		}
+
+		err := c.Check(ctx, host)
+		c.mu.Lock()
+		if err != nil {
+			c.failed[host] = caaFailure{err: err, time: c.now()}
+		} else {
+			delete(c.failed, host)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			slog.Warn("not requesting certificate", "err", err)
+		}
+		return err
+	}
+}
+
+// Check returns an error if the CAA records covering host forbid the issuer.
+// The closest name with CAA records decides, climbing from host towards the
+// root. Lookup failures allow issuance and leave the decision to the CA.
+func (c *CAAChecker) Check(ctx context.Context, host string) error {
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	for name != "" {
+		records, err := c.lookup(ctx, name)
+		if err != nil {
+			slog.Warn("CAA lookup failed, leaving the check to the CA", "name", name, "err", err)
+			return nil
+		}
+		if len(records) > 0 {
+			return checkCAA(host, name, records, c.issuer)
+		}
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return nil
+}
+
+// checkCAA applies the records found on name to issuance for host
+func checkCAA(host, name string, records []CAA, issuer string) error {
+	var issuers []string
+	restricted := false
+	for _, r := range records {
+		switch strings.ToLower(r.Tag) {
+		case "issue":
+			restricted = true
+			domain, _, _ := strings.Cut(r.Value, ";")
+			if domain = strings.TrimSpace(domain); domain != "" {
+				issuers = append(issuers, domain)
+			}
+		case "issuewild", "iodef":
+		default:
+			if r.Flags&caaCritical != 0 {
+				return fmt.Errorf("CAA: %s has a critical CAA record with unknown tag %q, which forbids issuance for %s by any CA", name, r.Tag, host)
+			}
+		}
+	}
+	if !restricted {
+		return nil
+	}
+	for _, domain := range issuers {
+		if strings.EqualFold(domain, issuer) {
+			return nil
+		}
+	}
+	if len(issuers) == 0 {
+		return fmt.Errorf("CAA: records on %s forbid issuance for %s by any CA: add %s CAA 0 issue %q", name, host, name, issuer)
+	}
+	return fmt.Errorf("CAA: records on %s only allow %s to issue for %s, not %s: add %s CAA 0 issue %q",
+		name, strings.Join(issuers, ", "), host, issuer, name, issuer)
+}
+
+// lookupCAA queries the first nameserver in /etc/resolv.conf for the CAA
+// records on name. Go's resolver has no CAA lookups.
+func lookupCAA(ctx context.Context, name string) ([]CAA, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.N(1 << 16))
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: typeCAA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	server := nameserver()
+	resp, err := exchange(ctx, "udp", server, query)
+	if err == nil && resp.Truncated {
+		resp, err = exchange(ctx, "tcp", server, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.ID != id {
+		return nil, errors.New("mismatched DNS response ID")
+	}
+	switch resp.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("DNS server %s answered %s", server, resp.RCode)
+	}
+
+	var records []CAA
+	for _, answer := range resp.Answers {
+		unknown, ok := answer.Body.(*dnsmessage.UnknownResource)
+		if !ok || answer.Header.Type != typeCAA {
+			continue // e.g. the CNAME the resolver followed
+		}
+		if r, ok := parseCAA(unknown.Data); ok {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// parseCAA parses CAA record data: flags, tag length, tag, value
+func parseCAA(data []byte) (CAA, bool) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return CAA{}, false
+	}
+	tagEnd := 2 + int(data[1])
+	return CAA{Flags: data[0], Tag: string(data[2:tagEnd]), Value: string(data[tagEnd:])}, true
+}
+
+// exchange sends query to server and reads the response, with the length
+// prefix DNS uses over TCP
+func exchange(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(buf[:2]))
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// nameserver returns the first nameserver in /etc/resolv.conf
+func nameserver() string {
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
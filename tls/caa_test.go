@@ -0,0 +1,155 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestCAACheck(t *testing.T) {
+	zone := map[string][]CAA{
+		"open.com":            nil,
+		"le.com":              {{Tag: "issue", Value: "letsencrypt.org"}},
+		"params.com":          {{Tag: "issue", Value: " LetsEncrypt.org; validationmethods=http-01"}},
+		"other.com":           {{Tag: "issue", Value: "digicert.com"}, {Tag: "issue", Value: "sectigo.com"}},
+		"none.com":            {{Tag: "issue", Value: ";"}},
+		"wild.com":            {{Tag: "issuewild", Value: "digicert.com"}, {Tag: "iodef", Value: "mailto:sec@wild.com"}},
+		"critical.com":        {{Flags: 128, Tag: "tbs", Value: "x"}},
+		"noncritical.com":     {{Tag: "tbs", Value: "x"}},
+		"sub.other.com":       {{Tag: "issue", Value: "letsencrypt.org"}},
+		"broken.com":          nil,
+		"deep.sub.broken.com": nil,
+	}
+	c := NewCAAChecker(LetsEncryptCAA)
+	var looked []string
+	c.lookup = func(_ context.Context, name string) ([]CAA, error) {
+		looked = append(looked, name)
+		if name == "broken.com" {
+			return nil, errors.New("SERVFAIL")
+		}
+		return zone[name], nil
+	}
+
+	tests := []struct {
+		host    string
+		wantErr string
+	}{
+		{host: "open.com"},
+		{host: "www.le.com"},
+		{host: "params.com"},
+		{host: "app.other.com", wantErr: "records on other.com only allow digicert.com, sectigo.com to issue for app.other.com, not letsencrypt.org"},
+		{host: "app.sub.other.com"}, // the closest records decide
+		{host: "none.com", wantErr: "forbid issuance for none.com by any CA"},
+		{host: "wild.com"},
+		{host: "critical.com", wantErr: `unknown tag "tbs"`},
+		{host: "noncritical.com"},
+		{host: "deep.sub.broken.com"}, // lookup errors leave it to the CA
+	}
+	for _, tt := range tests {
+		err := c.Check(context.Background(), tt.host)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.host, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.host, err, tt.wantErr)
+		}
+	}
+
+	looked = nil
+	c.Check(context.Background(), "a.b.open.com")
+	if strings.Join(looked, " ") != "a.b.open.com b.open.com open.com com" {
+		t.Errorf("looked up %v, want every name up to the TLD", looked)
+	}
+}
+
+func TestCAAPolicyCachesFailures(t *testing.T) {
+	c := NewCAAChecker(LetsEncryptCAA)
+	lookups := 0
+	c.lookup = func(_ context.Context, name string) ([]CAA, error) {
+		lookups++
+		return []CAA{{Tag: "issue", Value: "digicert.com"}}, nil
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	policy := c.Policy(func(context.Context, string) error { return nil })
+
+	for range 3 {
+		if err := policy(context.Background(), "example.com"); err == nil {
+			t.Fatal("policy allowed a host its CAA records forbid")
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("looked up %d times, want 1 within the retry window", lookups)
+	}
+	now = now.Add(caaRetry)
+	policy(context.Background(), "example.com")
+	if lookups != 2 {
+		t.Errorf("looked up %d times, want a fresh lookup after the retry window", lookups)
+	}
+
+	// The wrapped policy decides first
+	denied := c.Policy(func(context.Context, string) error { return errors.New("not configured") })
+	if err := denied(context.Background(), "other.com"); err == nil || err.Error() != "not configured" {
+		t.Errorf("wrapped policy error = %v", err)
+	}
+}
+
+func TestExchangeCAA(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if query.Unpack(buf[:n]) != nil {
+			return
+		}
+		q := query.Questions[0]
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: query.Questions,
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: typeCAA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.UnknownResource{Type: typeCAA, Data: append([]byte{0, 5}, "issueletsencrypt.org"...)},
+			}},
+		}
+		out, _ := resp.Pack()
+		pc.WriteTo(out, addr)
+	}()
+
+	name, _ := dnsmessage.NewName("example.com.")
+	query, _ := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 7, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: typeCAA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := exchange(ctx, "udp", pc.LocalAddr().String(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 7 || len(resp.Answers) != 1 {
+		t.Fatalf("response = %+v", resp)
+	}
+	r, ok := parseCAA(resp.Answers[0].Body.(*dnsmessage.UnknownResource).Data)
+	if !ok || r != (CAA{Tag: "issue", Value: "letsencrypt.org"}) {
+		t.Errorf("parsed %+v, %v", r, ok)
+	}
+	if _, ok := parseCAA([]byte{0, 9, 'x'}); ok {
+		t.Error("parsed a record with a truncated tag")
+	}
+}
@@ -0,0 +1,67 @@
+package tls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CertificateInfo describes a certificate in the certificate directory
+type CertificateInfo struct {
+	Host     string    `json:"host"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// CachedCertificates lists the certificates autocert stored in dir, sorted
+// by host. Account keys, challenge tokens and unreadable files are skipped.
+func CachedCertificates(dir string) ([]CertificateInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var certs []CertificateInfo
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		host, ok := certHost(e.Name())
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		leaf := firstCertificate(data)
+		if leaf == nil {
+			continue
+		}
+		certs = append(certs, CertificateInfo{Host: host, Issuer: leaf.Issuer.CommonName, NotAfter: leaf.NotAfter})
+	}
+	slices.SortFunc(certs, func(a, b CertificateInfo) int { return strings.Compare(a.Host, b.Host) })
+	return certs, nil
+}
+
+// firstCertificate parses the first certificate in PEM data, which autocert
+// writes after the private key
+func firstCertificate(data []byte) *x509.Certificate {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil
+			}
+			return cert
+		}
+	}
+}
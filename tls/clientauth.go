@@ -0,0 +1,100 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/acme"
+)
+
+// ClientAuthPolicy is the client certificate requirement for one host
+type ClientAuthPolicy struct {
+	CA      string // CA bundle (PEM) client certificates must chain to
+	Require bool   // reject handshakes without a certificate
+}
+
+// ClientAuth asks for client certificates only on the hosts that have a
+// policy, so other hosts on the same listener stay public
+type ClientAuth struct {
+	base    *tls.Config
+	configs atomic.Pointer[map[string]*tls.Config] // by host or *.parent
+}
+
+// NewClientAuth returns a ClientAuth whose Config serves base to hosts
+// without a policy
+func NewClientAuth(base *tls.Config) *ClientAuth {
+	c := &ClientAuth{base: base}
+	c.configs.Store(&map[string]*tls.Config{})
+	return c
+}
+
+// Config returns the tls.Config to serve, which picks each handshake's
+// client certificate requirement by its server name
+func (c *ClientAuth) Config() *tls.Config {
+	cfg := c.base.Clone()
+	cfg.GetConfigForClient = c.configFor
+	return cfg
+}
+
+// Update replaces the policies, keyed by host or *.parent for wildcard
+// hosts. CA bundles are read here, so a bad one fails the update and the
+// previous policies stay in place.
+func (c *ClientAuth) Update(policies map[string]ClientAuthPolicy) error {
+	pools := make(map[string]*x509.CertPool)
+	configs := make(map[string]*tls.Config, len(policies))
+	for host, p := range policies {
+		pool, ok := pools[p.CA]
+		if !ok {
+			data, err := os.ReadFile(p.CA)
+			if err != nil {
+				return fmt.Errorf("client CA for %s: %w", host, err)
+			}
+			pool = x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(data) {
+				return fmt.Errorf("client CA for %s: no certificates in %s", host, p.CA)
+			}
+			pools[p.CA] = pool
+		}
+		cfg := c.base.Clone()
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if p.Require {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		configs[strings.ToLower(host)] = cfg
+	}
+	c.configs.Store(&configs)
+	if len(configs) > 0 {
+		slog.Info("client certificates requested", "hosts", slices.Sorted(maps.Keys(configs)))
+	}
+	return nil
+}
+
+// configFor is tls.Config.GetConfigForClient. A nil config keeps the base.
+func (c *ClientAuth) configFor(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	// ACME validation servers have no client certificate
+	if slices.Equal(hello.SupportedProtos, []string{acme.ALPNProto}) {
+		return nil, nil
+	}
+	configs := *c.configs.Load()
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cfg, ok := configs[host]; ok {
+		return cfg, nil
+	}
+	if _, parent, ok := strings.Cut(host, "."); ok {
+		if cfg, ok := configs["*."+parent]; ok {
+			return cfg, nil
+		}
+	}
+	return nil, nil
+}
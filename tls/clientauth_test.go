@@ -0,0 +1,144 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// clientCA writes a CA to dir and returns its path and a client
+// certificate it signed
+func clientCA(t *testing.T, dir string) (string, tls.Certificate) {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "clients"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	caCert, _ := x509.ParseCertificate(caDER)
+	path := filepath.Join(dir, "clients.crt")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o644)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	hosts := NewHostList([]string{"public.test", "secure.test", "optional.test", "*.tenants.test"})
+	serverCA, err := LoadLocalCA(filepath.Join(dir, "server"), hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile, clientCert := clientCA(t, dir)
+
+	auth := NewClientAuth(LocalTLSConfig(serverCA))
+	err = auth.Update(map[string]ClientAuthPolicy{
+		"secure.test":    {CA: caFile, Require: true},
+		"optional.test":  {CA: caFile},
+		"*.tenants.test": {CA: caFile, Require: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := auth.Config()
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+
+	// handshake connects to host, with a client certificate if withCert,
+	// and returns the subject the server verified
+	handshake := func(host string, withCert bool) (string, error) {
+		client, server := net.Pipe()
+		defer client.Close()
+		clientConfig := &tls.Config{ServerName: host, RootCAs: roots}
+		if withCert {
+			clientConfig.Certificates = []tls.Certificate{clientCert}
+		}
+		result := make(chan error, 1)
+		var subject string
+		go func() {
+			defer server.Close()
+			conn := tls.Server(server, serverConfig)
+			err := conn.Handshake()
+			if err == nil {
+				if chains := conn.ConnectionState().VerifiedChains; len(chains) > 0 {
+					subject = chains[0][0].Subject.CommonName
+				}
+			}
+			result <- err
+		}()
+		conn := tls.Client(client, clientConfig)
+		conn.Handshake()
+		// TLS 1.3 clients learn of a rejected certificate on their first read
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		conn.Read(make([]byte, 1))
+		return subject, <-result
+	}
+
+	tests := []struct {
+		host     string
+		withCert bool
+		wantErr  bool
+		want     string
+	}{
+		{host: "public.test"},
+		{host: "public.test", withCert: true}, // not asked for, not sent
+		{host: "secure.test", wantErr: true},
+		{host: "secure.test", withCert: true, want: "alice"},
+		{host: "optional.test"},
+		{host: "optional.test", withCert: true, want: "alice"},
+		{host: "acme.tenants.test", wantErr: true},
+		{host: "acme.tenants.test", withCert: true, want: "alice"},
+	}
+	for _, tt := range tests {
+		subject, err := handshake(tt.host, tt.withCert)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s (cert %v): handshake error %v, want error %v", tt.host, tt.withCert, err, tt.wantErr)
+		}
+		if subject != tt.want {
+			t.Errorf("%s (cert %v): verified %q, want %q", tt.host, tt.withCert, subject, tt.want)
+		}
+	}
+
+	// ACME validation servers are never asked for certificates
+	if cfg, _ := auth.configFor(&tls.ClientHelloInfo{ServerName: "secure.test", SupportedProtos: []string{acme.ALPNProto}}); cfg != nil {
+		t.Error("TLS-ALPN-01 challenge asked for a client certificate")
+	}
+
+	// A bad CA keeps the previous policies
+	if err := auth.Update(map[string]ClientAuthPolicy{"secure.test": {CA: filepath.Join(dir, "missing.crt")}}); err == nil {
+		t.Error("Update accepted a missing CA bundle")
+	}
+	if cfg, _ := auth.configFor(&tls.ClientHelloInfo{ServerName: "secure.test"}); cfg == nil || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Error("failed update replaced the policies")
+	}
+}
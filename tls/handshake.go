@@ -0,0 +1,103 @@
+package tls
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"github.com/localrivet/liteproxy/errlog"
+	"github.com/localrivet/liteproxy/metrics"
+)
+
+// Handshake failure classes used as the reason label
+const (
+	ReasonUnknownSNI   = "unknown_sni"      // no SNI, or a host without a route
+	ReasonCertificate  = "cert_unavailable" // certificate could not be obtained for the host
+	ReasonClientCert   = "client_cert"      // client certificate missing or rejected
+	ReasonProtocol     = "protocol"         // no shared version/cipher/ALPN, or not TLS at all
+	ReasonClientClosed = "client_closed"    // client went away or timed out mid-handshake
+	ReasonOther        = "other"
+)
+
+var handshakeErrors = metrics.Default.NewCounterVec(
+	"liteproxy_tls_handshake_errors_total",
+	"Failed TLS handshakes by reason.",
+	"reason")
+
+// debugHandshakes enables logging of every failed handshake
+var debugHandshakes atomic.Bool
+
+// SetHandshakeDebug turns per-handshake failure logging on or off
+// Failures are always counted in liteproxy_tls_handshake_errors_total
+func SetHandshakeDebug(on bool) {
+	debugHandshakes.Store(on)
+}
+
+// HandshakeError records a failed handshake from peer
+func HandshakeError(peer string, err error) {
+	reason := classifyHandshake(err.Error())
+	handshakeErrors.Inc(reason)
+	if debugHandshakes.Load() {
+		errlog.Printf("tls", reason, "TLS handshake failed from %s (%s): %v", peer, reason, err)
+	}
+}
+
+// classifyHandshake maps a handshake error message to a failure class
+func classifyHandshake(msg string) string {
+	switch {
+	case containsAny(msg, "not configured", "missing server name", "no SNI"):
+		return ReasonUnknownSNI
+	case strings.Contains(msg, "acme"):
+		return ReasonCertificate
+	case containsAny(msg, "client didn't provide a certificate", "bad certificate", "certificate required",
+		"failed to verify certificate", "unknown certificate authority"):
+		return ReasonClientCert
+	case containsAny(msg, "unsupported versions", "protocol version", "no cipher suite", "no application protocol",
+		"does not look like a TLS handshake", "unsupported", "not TLS", "not ClientHello", "truncated"):
+		return ReasonProtocol
+	case containsAny(msg, "EOF", "connection reset", "broken pipe", "timeout"):
+		return ReasonClientClosed
+	}
+	return ReasonOther
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorLog returns a logger for http.Server.ErrorLog that routes TLS
+// handshake failures through HandshakeError and passes other errors on to
+// the standard logger
+func ErrorLog() *log.Logger {
+	return log.New(errorLogWriter{}, "", 0)
+}
+
+// handshakePrefix starts the message net/http logs for failed handshakes:
+// "http: TLS handshake error from <addr>: <err>"
+var handshakePrefix = []byte("http: TLS handshake error from ")
+
+type errorLogWriter struct{}
+
+func (errorLogWriter) Write(p []byte) (int, error) {
+	rest, ok := bytes.CutPrefix(p, handshakePrefix)
+	if !ok {
+		slog.Warn("http server error", "err", strings.TrimSuffix(string(p), "\n"))
+		return len(p), nil
+	}
+
+	peer, msg, _ := strings.Cut(strings.TrimSuffix(string(rest), "\n"), ": ")
+	HandshakeError(peer, handshakeErr(msg))
+	return len(p), nil
+}
+
+// handshakeErr is a handshake error recovered from a log line
+type handshakeErr string
+
+func (e handshakeErr) Error() string { return string(e) }
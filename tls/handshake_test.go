@@ -0,0 +1,43 @@
+package tls
+
+import (
+	"testing"
+)
+
+func TestClassifyHandshake(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{`acme/autocert: host "unknown.example.com" not configured`, ReasonUnknownSNI},
+		{"acme/autocert: missing server name", ReasonUnknownSNI},
+		{"no SNI", ReasonUnknownSNI},
+		{"acme/autocert: unable to satisfy \"https://acme\" for domain \"example.com\"", ReasonCertificate},
+		{"tls: client didn't provide a certificate", ReasonClientCert},
+		{"tls: failed to verify certificate: x509: certificate signed by unknown authority", ReasonClientCert},
+		{"tls: client offered only unsupported versions: [302 301]", ReasonProtocol},
+		{"tls: no cipher suite supported by both client and server", ReasonProtocol},
+		{"tls: first record does not look like a TLS handshake", ReasonProtocol},
+		{"not TLS: plain HTTP request sent to HTTPS port", ReasonProtocol},
+		{"EOF", ReasonClientClosed},
+		{"read tcp 10.0.0.1:443->203.0.113.5:51234: read: connection reset by peer", ReasonClientClosed},
+		{"something new", ReasonOther},
+	}
+
+	for _, tt := range tests {
+		if got := classifyHandshake(tt.msg); got != tt.want {
+			t.Errorf("classifyHandshake(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestErrorLogCountsHandshakeErrors(t *testing.T) {
+	before := handshakeErrors.Value(ReasonUnknownSNI)
+
+	logger := ErrorLog()
+	logger.Printf("http: TLS handshake error from %s: %v", "203.0.113.5:51234", `acme/autocert: host "nope.example.com" not configured`)
+
+	if got := handshakeErrors.Value(ReasonUnknownSNI) - before; got != 1 {
+		t.Errorf("unknown_sni handshake errors = %d, want 1", got)
+	}
+}
@@ -0,0 +1,229 @@
+package tls
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate modes for LITEPROXY_TLS_MODE
+const (
+	ModeACME  = "acme"  // Let's Encrypt through autocert
+	ModeLocal = "local" // a local CA for development and air-gapped hosts
+)
+
+// Local CA files, kept in the certificate directory
+const (
+	LocalCACertFile = "local-ca.crt"
+	LocalCAKeyFile  = "local-ca.key"
+)
+
+// Validity of local certificates. Leaves stay within the 825 days Apple
+// platforms accept and are reissued 30 days before they expire.
+const (
+	localCAValidity   = 10 * 365 * 24 * time.Hour
+	localLeafValidity = 825 * 24 * time.Hour
+	localLeafRenew    = 30 * 24 * time.Hour
+)
+
+// LocalCA issues certificates for the configured hosts from a CA generated
+// on first use, so HTTPS works where ACME can't: localhost, private names,
+// air-gapped networks and wildcard hosts
+type LocalCA struct {
+	dir   string
+	hosts *HostList
+	cert  *x509.Certificate
+	key   crypto.Signer
+
+	// Swappable for tests
+	now func() time.Time
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate // by certificate name
+}
+
+// LoadLocalCA loads the CA in dir, creating it if there is none, to issue
+// certificates for hosts
+func LoadLocalCA(dir string, hosts *HostList) (*LocalCA, error) {
+	ca := &LocalCA{dir: dir, hosts: hosts, now: time.Now, certs: make(map[string]*tls.Certificate)}
+	pair, err := tls.LoadX509KeyPair(ca.CertFile(), filepath.Join(dir, LocalCAKeyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		if err := ca.create(); err != nil {
+			return nil, fmt.Errorf("creating local CA: %w", err)
+		}
+		slog.Info("created local CA", "file", ca.CertFile())
+		return ca, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading local CA: %w", err)
+	}
+	ca.cert, ca.key = pair.Leaf, pair.PrivateKey.(crypto.Signer)
+	if !ca.cert.IsCA {
+		return nil, fmt.Errorf("loading local CA: %s is not a CA certificate", ca.CertFile())
+	}
+	return ca, nil
+}
+
+// CertFile is the path of the CA certificate clients must trust
+func (ca *LocalCA) CertFile() string {
+	return filepath.Join(ca.dir, LocalCACertFile)
+}
+
+func (ca *LocalCA) create() error {
+	if err := os.MkdirAll(ca.dir, 0o700); err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	now := ca.now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{Organization: []string{"liteproxy local CA"}, CommonName: "liteproxy local CA " + hostname},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		return err
+	}
+	ca.key = key
+	if err := writePEM(filepath.Join(ca.dir, LocalCAKeyFile), key); err != nil {
+		return err
+	}
+	return os.WriteFile(ca.CertFile(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// GetCertificate issues or returns the certificate for the handshake's
+// server name. Subdomains of a wildcard host share one wildcard certificate.
+func (ca *LocalCA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if host == "" {
+		return nil, errors.New("local CA: missing server name")
+	}
+	name := host
+	if ca.hosts.Policy(context.Background(), host) != nil {
+		_, parent, ok := strings.Cut(host, ".")
+		if !ok || ca.hosts.Policy(context.Background(), "*."+parent) != nil {
+			return nil, fmt.Errorf("local CA: host %q not configured", host)
+		}
+		name = "*." + parent
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if cert, ok := ca.certs[name]; ok && ca.fresh(cert) {
+		return cert, nil
+	}
+	path := filepath.Join(ca.dir, "local-"+strings.ReplaceAll(name, "*", "_")+".pem")
+	if cert, err := ca.load(path); err == nil && ca.fresh(cert) {
+		ca.certs[name] = cert
+		return cert, nil
+	}
+	cert, err := ca.issue(name, path)
+	if err != nil {
+		return nil, fmt.Errorf("local CA: issuing %s: %w", name, err)
+	}
+	ca.certs[name] = cert
+	return cert, nil
+}
+
+// fresh reports whether cert was signed by this CA and is not due for renewal
+func (ca *LocalCA) fresh(cert *tls.Certificate) bool {
+	return cert.Leaf.CheckSignatureFrom(ca.cert) == nil && ca.now().Add(localLeafRenew).Before(cert.Leaf.NotAfter)
+}
+
+func (ca *LocalCA) load(path string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// issue creates the certificate for name and stores it with its key at path
+func (ca *LocalCA) issue(name, path string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := ca.now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{Organization: []string{"liteproxy local CA"}, CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(localLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePEM(path, key, der, ca.cert.Raw); err != nil {
+		slog.Warn("local CA: storing certificate failed", "host", name, "err", err)
+	}
+	slog.Info("local CA: issued certificate", "host", name)
+	return &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// writePEM writes key and then any certificates to path, readable only by
+// the owner
+func writePEM(path string, key *ecdsa.PrivateKey, certs ...[]byte) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	for _, der := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func serialNumber() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return n
+}
+
+// LocalTLSConfig returns a tls.Config serving certificates from ca
+func LocalTLSConfig(ca *LocalCA) *tls.Config {
+	return &tls.Config{
+		GetCertificate: ca.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}
+}
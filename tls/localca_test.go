@@ -0,0 +1,102 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalCA(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "certs")
+	hosts := NewHostList([]string{"app.localhost", "*.tenant.test"})
+	ca, err := LoadLocalCA(dir, hosts)
+	if err != nil {
+		t.Fatalf("LoadLocalCA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	get := func(ca *LocalCA, host string) (*tls.Certificate, error) {
+		return ca.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+	}
+
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{host: "app.localhost"},
+		{host: "APP.localhost."},
+		{host: "acme.tenant.test"},
+		{host: "other.localhost", wantErr: true},
+		{host: "a.b.tenant.test", wantErr: true},
+		{host: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		cert, err := get(ca, tt.host)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: issued a certificate for an unconfigured host", tt.host)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.host, err)
+			continue
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: tt.host, Roots: pool}); err != nil {
+			t.Errorf("%q: certificate doesn't verify against the CA: %v", tt.host, err)
+		}
+	}
+
+	// Subdomains of a wildcard share its certificate
+	a, _ := get(ca, "a.tenant.test")
+	b, _ := get(ca, "b.tenant.test")
+	if a != b {
+		t.Error("wildcard subdomains got separate certificates")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "local-_.tenant.test.pem")); err != nil {
+		t.Errorf("wildcard certificate not stored: %v", err)
+	}
+
+	// A restart reuses the CA and the stored certificates
+	issued, _ := get(ca, "app.localhost")
+	ca2, err := LoadLocalCA(dir, hosts)
+	if err != nil {
+		t.Fatalf("reloading local CA: %v", err)
+	}
+	if !ca2.cert.Equal(ca.cert) {
+		t.Error("reload generated a new CA")
+	}
+	reloaded, err := get(ca2, "app.localhost")
+	if err != nil || !reloaded.Leaf.Equal(issued.Leaf) {
+		t.Errorf("reload reissued the certificate (err %v)", err)
+	}
+
+	// Certificates near expiry are reissued
+	ca2.now = func() time.Time { return time.Now().Add(localLeafValidity - localLeafRenew/2) }
+	renewed, err := get(ca2, "app.localhost")
+	if err != nil || renewed.Leaf.Equal(issued.Leaf) {
+		t.Errorf("expiring certificate not renewed (err %v)", err)
+	}
+}
+
+func TestLoadLocalCARejectsLeaf(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadLocalCA(dir, NewHostList([]string{"app.localhost"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Put a leaf where the CA belongs
+	if _, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "local-app.localhost.pem"))
+	os.WriteFile(filepath.Join(dir, LocalCACertFile), data, 0o644)
+	os.WriteFile(filepath.Join(dir, LocalCAKeyFile), data, 0o600)
+	if _, err := LoadLocalCA(dir, NewHostList(nil)); err == nil {
+		t.Error("loaded a leaf certificate as the CA")
+	}
+}
@@ -0,0 +1,177 @@
+package tls
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// precheckRetry is how long a failed pre-check blocks issuance before rechecking
+const precheckRetry = time.Minute
+
+// PreChecker verifies a host points at this machine before asking the CA for
+// a certificate, so misconfigured DNS shows up as an actionable log line
+// instead of a failed ACME order
+type PreChecker struct {
+	publicIPs []netip.Addr // empty = only check that the host resolves
+
+	// Swappable for tests
+	lookup func(ctx context.Context, host string) ([]netip.Addr, error)
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	results map[string]PreCheckResult
+}
+
+// PreCheckResult is the outcome of the last pre-check of a host
+type PreCheckResult struct {
+	Host    string    `json:"host"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`   // issuance is blocked until this clears
+	Warning string    `json:"warning,omitempty"` // issuance may still succeed
+}
+
+// NewPreChecker creates a pre-checker comparing DNS against publicIPs
+// If publicIPs is empty, global unicast addresses of local interfaces are used
+func NewPreChecker(publicIPs []string) (*PreChecker, error) {
+	c := &PreChecker{
+		lookup: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		},
+		dial:    (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+		results: make(map[string]PreCheckResult),
+	}
+
+	for _, s := range publicIPs {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public IP %q: %w", s, err)
+		}
+		c.publicIPs = append(c.publicIPs, ip.Unmap())
+	}
+	if len(c.publicIPs) == 0 {
+		c.publicIPs = interfaceIPs()
+	}
+	if len(c.publicIPs) == 0 {
+		slog.Warn("ACME pre-check: no public IP configured or found on interfaces, only checking that hosts resolve")
+	}
+	return c, nil
+}
+
+// interfaceIPs returns the public addresses assigned to local interfaces
+func interfaceIPs() []netip.Addr {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []netip.Addr
+	for _, a := range addrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr().Unmap()
+		if ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// Policy wraps an autocert host policy so hosts it allows are also pre-checked
+// autocert only consults the policy before issuing, so cached certificates
+// are served without any checks
+func (c *PreChecker) Policy(next autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if err := next(ctx, host); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		last, ok := c.results[host]
+		c.mu.Unlock()
+		if ok && last.Error != "" && time.Since(last.Time) < precheckRetry {
+			return fmt.Errorf("acme pre-check: %s", last.Error)
+		}
+
+		result := c.Check(ctx, host)
+		if result.Error != "" {
+			return fmt.Errorf("acme pre-check: %s", result.Error)
+		}
+		return nil
+	}
+}
+
+// Check runs the pre-checks for host, logs and records the result
+func (c *PreChecker) Check(ctx context.Context, host string) PreCheckResult {
+	result := PreCheckResult{Host: host, Time: time.Now()}
+
+	ips, err := c.lookup(ctx, host)
+	switch {
+	case err != nil || len(ips) == 0:
+		result.Error = fmt.Sprintf("%s does not resolve (%v): create an A/AAAA record pointing at this server", host, err)
+	case len(c.publicIPs) > 0 && !c.pointsHere(ips):
+		result.Error = fmt.Sprintf("DNS mismatch: %s resolves to %s but this server is %s: update its A/AAAA records",
+			host, joinAddrs(ips), joinAddrs(c.publicIPs))
+	default:
+		// HTTP-01 needs port 80; TLS-ALPN-01 can still succeed without it
+		addr := net.JoinHostPort(ips[0].String(), "80")
+		if conn, err := c.dial(ctx, "tcp", addr); err != nil {
+			result.Warning = fmt.Sprintf("port 80 unreachable at %s (%v): HTTP-01 challenges will fail, only TLS-ALPN-01 on 443 can succeed", addr, err)
+		} else {
+			conn.Close()
+		}
+	}
+
+	switch {
+	case result.Error != "":
+		slog.Warn("ACME pre-check failed, not requesting certificate", "err", result.Error)
+	case result.Warning != "":
+		slog.Warn("ACME pre-check warning", "warning", result.Warning)
+	}
+
+	c.mu.Lock()
+	c.results[host] = result
+	c.mu.Unlock()
+	return result
+}
+
+// Results returns the last pre-check result for each host, sorted by host
+func (c *PreChecker) Results() []PreCheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]PreCheckResult, 0, len(c.results))
+	for _, r := range c.results {
+		results = append(results, r)
+	}
+	slices.SortFunc(results, func(a, b PreCheckResult) int { return strings.Compare(a.Host, b.Host) })
+	return results
+}
+
+// pointsHere reports whether every resolved address belongs to this server
+// A stale extra record would make some CA validation attempts fail
+func (c *PreChecker) pointsHere(ips []netip.Addr) bool {
+	for _, ip := range ips {
+		if !slices.Contains(c.publicIPs, ip.Unmap()) {
+			return false
+		}
+	}
+	return true
+}
+
+func joinAddrs(ips []netip.Addr) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, ", ")
+}
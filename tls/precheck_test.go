@@ -0,0 +1,103 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func newTestPreChecker(t *testing.T, dns map[string][]string, port80 bool) *PreChecker {
+	t.Helper()
+	c, err := NewPreChecker([]string{"203.0.113.10"})
+	if err != nil {
+		t.Fatalf("NewPreChecker() error = %v", err)
+	}
+	c.lookup = func(ctx context.Context, host string) ([]netip.Addr, error) {
+		var ips []netip.Addr
+		for _, s := range dns[host] {
+			ips = append(ips, netip.MustParseAddr(s))
+		}
+		if len(ips) == 0 {
+			return nil, errors.New("no such host")
+		}
+		return ips, nil
+	}
+	c.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !port80 {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	return c
+}
+
+func TestPreCheck(t *testing.T) {
+	dns := map[string][]string{
+		"good.example.com":  {"203.0.113.10"},
+		"moved.example.com": {"198.51.100.7"},
+		"stale.example.com": {"203.0.113.10", "198.51.100.7"},
+	}
+
+	tests := []struct {
+		host        string
+		port80      bool
+		wantErr     string // substring, empty = no error
+		wantWarning bool
+	}{
+		{host: "good.example.com", port80: true},
+		{host: "good.example.com", port80: false, wantWarning: true},
+		{host: "moved.example.com", port80: true, wantErr: "DNS mismatch"},
+		{host: "stale.example.com", port80: true, wantErr: "198.51.100.7"},
+		{host: "missing.example.com", port80: true, wantErr: "does not resolve"},
+	}
+
+	for _, tt := range tests {
+		c := newTestPreChecker(t, dns, tt.port80)
+		got := c.Check(context.Background(), tt.host)
+		if tt.wantErr == "" && got.Error != "" {
+			t.Errorf("Check(%q) error = %q, want none", tt.host, got.Error)
+		}
+		if tt.wantErr != "" && !strings.Contains(got.Error, tt.wantErr) {
+			t.Errorf("Check(%q) error = %q, want it to contain %q", tt.host, got.Error, tt.wantErr)
+		}
+		if (got.Warning != "") != tt.wantWarning {
+			t.Errorf("Check(%q) warning = %q, want warning %v", tt.host, got.Warning, tt.wantWarning)
+		}
+	}
+}
+
+func TestPreCheckPolicy(t *testing.T) {
+	c := newTestPreChecker(t, map[string][]string{"good.example.com": {"203.0.113.10"}}, true)
+	policy := c.Policy(func(ctx context.Context, host string) error {
+		if host == "denied.example.com" {
+			return errors.New("not allowed")
+		}
+		return nil
+	})
+
+	if err := policy(context.Background(), "good.example.com"); err != nil {
+		t.Errorf("policy(good) = %v, want nil", err)
+	}
+	if err := policy(context.Background(), "missing.example.com"); err == nil {
+		t.Error("policy(missing) = nil, want pre-check error")
+	}
+	if err := policy(context.Background(), "denied.example.com"); err == nil || err.Error() != "not allowed" {
+		t.Errorf("policy(denied) = %v, want wrapped policy error", err)
+	}
+
+	results := c.Results()
+	if len(results) != 2 || results[0].Host != "good.example.com" || results[1].Host != "missing.example.com" {
+		t.Errorf("Results() = %+v, want good and missing hosts", results)
+	}
+}
+
+func TestNewPreCheckerInvalidIP(t *testing.T) {
+	if _, err := NewPreChecker([]string{"not-an-ip"}); err == nil {
+		t.Error("NewPreChecker() error = nil, want invalid IP error")
+	}
+}
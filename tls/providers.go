@@ -0,0 +1,71 @@
+package tls
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+// ACME directories of CAs liteproxy knows the staging directory and CAA
+// issuer domain of. Any other RFC 8555 directory works too.
+const (
+	ZeroSSLDirectory        = "https://acme.zerossl.com/v2/DV90"
+	BuypassDirectory        = "https://api.buypass.com/acme/directory"
+	BuypassStagingDirectory = "https://api.test4.buypass.no/acme/directory"
+	GoogleDirectory         = "https://dv.acme-v02.api.pki.goog/directory"
+	GoogleStagingDirectory  = "https://dv.acme-v02.test-api.pki.goog/directory"
+)
+
+// acmeCA describes the CA behind an ACME directory
+type acmeCA struct {
+	staging    string // staging directory, "" if the CA has none
+	stagingEAB bool   // the staging directory takes its own external account binding
+	caa        string // CAA issuer domain
+}
+
+var acmeCAs = map[string]acmeCA{
+	acme.LetsEncryptURL:     {staging: StagingDirectory, caa: LetsEncryptCAA},
+	StagingDirectory:        {staging: StagingDirectory, caa: LetsEncryptCAA},
+	ZeroSSLDirectory:        {caa: "sectigo.com"},
+	BuypassDirectory:        {staging: BuypassStagingDirectory, caa: "buypass.com"},
+	BuypassStagingDirectory: {staging: BuypassStagingDirectory, caa: "buypass.com"},
+	GoogleDirectory:         {staging: GoogleStagingDirectory, stagingEAB: true, caa: "pki.goog"},
+	GoogleStagingDirectory:  {staging: GoogleStagingDirectory, stagingEAB: true, caa: "pki.goog"},
+}
+
+// StagingFor returns the staging directory of the CA serving directory, or
+// "" if it has none or isn't known
+func StagingFor(directory string) string {
+	return acmeCAs[directory].staging
+}
+
+// StagingNeedsEAB reports whether the staging directory of the CA serving
+// directory requires external account binding credentials of its own,
+// separate from the production directory's
+func StagingNeedsEAB(directory string) bool {
+	return acmeCAs[directory].stagingEAB
+}
+
+// IsStaging reports whether directory is a known staging directory, whose
+// certificates browsers don't trust
+func IsStaging(directory string) bool {
+	return directory != "" && acmeCAs[directory].staging == directory
+}
+
+// CAAIssuerFor returns the CAA issuer domain of the CA serving directory,
+// or "" if it isn't known
+func CAAIssuerFor(directory string) string {
+	return acmeCAs[directory].caa
+}
+
+// ParseEABKey decodes an external account binding HMAC key the way CAs hand
+// it out: base64url, with or without padding
+func ParseEABKey(s string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(s), "="))
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("want the base64url HMAC key from the CA")
+	}
+	return key, nil
+}
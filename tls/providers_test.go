@@ -0,0 +1,71 @@
+package tls
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestProviders(t *testing.T) {
+	tests := []struct {
+		directory  string
+		staging    string
+		isStaging  bool
+		stagingEAB bool
+		caa        string
+	}{
+		{acme.LetsEncryptURL, StagingDirectory, false, false, LetsEncryptCAA},
+		{StagingDirectory, StagingDirectory, true, false, LetsEncryptCAA},
+		{ZeroSSLDirectory, "", false, false, "sectigo.com"},
+		{BuypassDirectory, BuypassStagingDirectory, false, false, "buypass.com"},
+		{GoogleDirectory, GoogleStagingDirectory, false, true, "pki.goog"},
+		{GoogleStagingDirectory, GoogleStagingDirectory, true, true, "pki.goog"},
+		{"https://acme.internal.example/directory", "", false, false, ""},
+	}
+	for _, tt := range tests {
+		if got := StagingFor(tt.directory); got != tt.staging {
+			t.Errorf("StagingFor(%s) = %q, want %q", tt.directory, got, tt.staging)
+		}
+		if got := IsStaging(tt.directory); got != tt.isStaging {
+			t.Errorf("IsStaging(%s) = %v, want %v", tt.directory, got, tt.isStaging)
+		}
+		if got := StagingNeedsEAB(tt.directory); got != tt.stagingEAB {
+			t.Errorf("StagingNeedsEAB(%s) = %v, want %v", tt.directory, got, tt.stagingEAB)
+		}
+		if got := CAAIssuerFor(tt.directory); got != tt.caa {
+			t.Errorf("CAAIssuerFor(%s) = %q, want %q", tt.directory, got, tt.caa)
+		}
+	}
+}
+
+func TestParseEABKey(t *testing.T) {
+	want := []byte{0xfb, 0xff, 0x01, 0x02}
+	for _, in := range []string{"-_8BAg", "-_8BAg==", " -_8BAg\n"} {
+		got, err := ParseEABKey(in)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("ParseEABKey(%q) = %x, %v, want %x", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "not base64!"} {
+		if _, err := ParseEABKey(in); err == nil {
+			t.Errorf("ParseEABKey(%q) expected an error", in)
+		}
+	}
+}
+
+func TestManagerDirectory(t *testing.T) {
+	m := Manager(Config{CacheDir: t.TempDir(), Hosts: NewHostList(nil)})
+	if m.Client != nil {
+		t.Errorf("default manager Client = %+v, want nil (Let's Encrypt)", m.Client)
+	}
+
+	eab := &acme.ExternalAccountBinding{KID: "kid", Key: []byte("key")}
+	m = Manager(Config{CacheDir: t.TempDir(), Hosts: NewHostList(nil), DirectoryURL: ZeroSSLDirectory, EAB: eab})
+	if m.Client == nil || m.Client.DirectoryURL != ZeroSSLDirectory {
+		t.Errorf("Client = %+v, want directory %s", m.Client, ZeroSSLDirectory)
+	}
+	if m.ExternalAccountBinding != eab {
+		t.Errorf("ExternalAccountBinding = %+v, want %+v", m.ExternalAccountBinding, eab)
+	}
+}
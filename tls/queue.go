@@ -0,0 +1,239 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Issuance limits matching Let's Encrypt's 300 new orders per 3 hours
+const (
+	DefaultIssueConcurrency = 4
+	DefaultIssuePerHour     = 100
+)
+
+// IssueQueue requests certificates for new hosts in the background, a few at
+// a time, and caps issuance per hour across both the queue and on-demand
+// issuance at handshake time
+type IssueQueue struct {
+	concurrency int
+	perHour     int // 0 = unlimited
+
+	// Swappable for tests
+	issue func(host string) error
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu       sync.Mutex
+	pending  []string
+	queued   map[string]bool // pending or issuing
+	issuing  map[string]bool
+	reserved map[string]time.Time // orders counted by a worker, not yet placed
+	failed   map[string]string
+	orders   []time.Time // issuance attempts within the last hour
+	running  int
+}
+
+// QueueStatus is a snapshot of the issuance queue
+type QueueStatus struct {
+	Pending        []string          `json:"pending"`
+	Issuing        []string          `json:"issuing"`
+	Failed         map[string]string `json:"failed,omitempty"`
+	IssuedLastHour int               `json:"issued_last_hour"`
+	PerHour        int               `json:"per_hour"`
+}
+
+// NewIssueQueue creates a queue issuing certificates through m
+func NewIssueQueue(m *autocert.Manager, concurrency, perHour int) *IssueQueue {
+	if concurrency <= 0 {
+		concurrency = DefaultIssueConcurrency
+	}
+	return &IssueQueue{
+		concurrency: concurrency,
+		perHour:     perHour,
+		issue: func(host string) error {
+			_, err := m.GetCertificate(helloFor(host))
+			return err
+		},
+		now:      time.Now,
+		sleep:    time.Sleep,
+		queued:   make(map[string]bool),
+		issuing:  make(map[string]bool),
+		reserved: make(map[string]time.Time),
+		failed:   make(map[string]string),
+	}
+}
+
+// helloFor builds a ClientHello for host that advertises ECDSA support, so
+// autocert issues the same certificate a modern browser would request
+func helloFor(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       host,
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+	}
+}
+
+// Enqueue schedules certificate requests for hosts
+// Hosts with a cached certificate finish immediately without an ACME order;
+// wildcard hosts are skipped since they can't be issued over HTTP challenges
+func (q *IssueQueue) Enqueue(hosts []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, host := range hosts {
+		if strings.Contains(host, "*") || q.queued[host] {
+			continue
+		}
+		q.queued[host] = true
+		q.pending = append(q.pending, host)
+	}
+	for q.running < q.concurrency && len(q.pending) > 0 {
+		q.running++
+		go q.worker()
+	}
+}
+
+// worker issues pending certificates until the queue is empty
+func (q *IssueQueue) worker() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running--
+			q.mu.Unlock()
+			return
+		}
+		if wait := q.waitLocked(); wait > 0 {
+			host := q.pending[0]
+			q.mu.Unlock()
+			slog.Warn("certificate issuance limit reached, waiting", "per_hour", q.perHour, "wait", wait.Round(time.Second).String(), "host", host)
+			q.sleep(wait)
+			continue
+		}
+		host := q.pending[0]
+		q.pending = q.pending[1:]
+		q.issuing[host] = true
+		// Reserve the order now, so other workers and on-demand issuance
+		// can't take the slot between the check and the ACME order
+		if q.perHour > 0 {
+			q.reserved[host] = q.now()
+			q.orders = append(q.orders, q.reserved[host])
+		}
+		q.mu.Unlock()
+
+		err := q.issue(host)
+
+		var limited *limitError
+		q.mu.Lock()
+		delete(q.issuing, host)
+		if at, ok := q.reserved[host]; ok {
+			// Cached certificates never reach the policy, so no order was placed
+			delete(q.reserved, host)
+			if i := slices.Index(q.orders, at); i >= 0 {
+				q.orders = slices.Delete(q.orders, i, i+1)
+			}
+		}
+		switch {
+		case errors.As(err, &limited):
+			q.pending = append(q.pending, host) // retried once the limit allows
+		case err != nil:
+			delete(q.queued, host)
+			q.failed[host] = err.Error()
+		default:
+			delete(q.queued, host)
+			delete(q.failed, host)
+		}
+		q.mu.Unlock()
+
+		if err != nil && limited == nil {
+			slog.Error("certificate issuance failed", "host", host, "err", err)
+		}
+	}
+}
+
+// limitError rejects an order that would exceed the hourly limit
+type limitError struct {
+	perHour int
+	wait    time.Duration
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("certificate issuance limit reached (%d/hour), retry in %s", e.perHour, e.wait.Round(time.Second))
+}
+
+// Policy wraps an autocert host policy so every new order, queued or
+// on-demand, counts against the hourly limit
+// autocert only consults the policy for hosts without a cached certificate
+func (q *IssueQueue) Policy(next autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if err := next(ctx, host); err != nil {
+			return err
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if _, ok := q.reserved[host]; ok {
+			delete(q.reserved, host) // the queue already counted this order
+			return nil
+		}
+		if wait := q.waitLocked(); wait > 0 {
+			return &limitError{perHour: q.perHour, wait: wait}
+		}
+		if q.perHour > 0 {
+			q.orders = append(q.orders, q.now())
+		}
+		return nil
+	}
+}
+
+// waitLocked prunes orders older than an hour and returns how long until
+// another order fits within the limit
+func (q *IssueQueue) waitLocked() time.Duration {
+	if q.perHour <= 0 {
+		return 0
+	}
+	cutoff := q.now().Add(-time.Hour)
+	i := 0
+	for i < len(q.orders) && !q.orders[i].After(cutoff) {
+		i++
+	}
+	q.orders = q.orders[i:]
+
+	if len(q.orders) < q.perHour {
+		return 0
+	}
+	return q.orders[len(q.orders)-q.perHour].Sub(cutoff)
+}
+
+// Status returns a snapshot of the queue
+func (q *IssueQueue) Status() QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.waitLocked() // prune
+	s := QueueStatus{
+		Pending:        slices.Clone(q.pending),
+		IssuedLastHour: len(q.orders),
+		PerHour:        q.perHour,
+	}
+	for host := range q.issuing {
+		s.Issuing = append(s.Issuing, host)
+	}
+	slices.Sort(s.Issuing)
+	if len(q.failed) > 0 {
+		s.Failed = make(map[string]string, len(q.failed))
+		for host, err := range q.failed {
+			s.Failed[host] = err
+		}
+	}
+	return s
+}
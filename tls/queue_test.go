@@ -0,0 +1,203 @@
+package tls
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestQueue(concurrency, perHour int, issue func(string) error) *IssueQueue {
+	q := NewIssueQueue(nil, concurrency, perHour)
+	q.issue = issue
+	return q
+}
+
+func waitIdle(t *testing.T, q *IssueQueue) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		q.mu.Lock()
+		idle := q.running == 0
+		q.mu.Unlock()
+		if idle {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("queue did not drain")
+}
+
+func TestIssueQueueConcurrency(t *testing.T) {
+	var active, peak atomic.Int32
+	var mu sync.Mutex
+	issued := make(map[string]int)
+
+	q := newTestQueue(2, 0, func(host string) error {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		active.Add(-1)
+
+		mu.Lock()
+		issued[host]++
+		mu.Unlock()
+		return nil
+	})
+
+	q.Enqueue([]string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "*.example.com", "a.example.com"})
+	waitIdle(t, q)
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("peak concurrency = %d, want at most 2", p)
+	}
+	if len(issued) != 4 {
+		t.Errorf("issued %v, want 4 hosts without the wildcard", issued)
+	}
+	for host, n := range issued {
+		if n != 1 {
+			t.Errorf("%s issued %d times, want once", host, n)
+		}
+	}
+}
+
+func TestIssueQueueRecordsFailures(t *testing.T) {
+	q := newTestQueue(1, 0, func(host string) error {
+		if host == "bad.example.com" {
+			return context.DeadlineExceeded
+		}
+		return nil
+	})
+
+	q.Enqueue([]string{"good.example.com", "bad.example.com"})
+	waitIdle(t, q)
+
+	s := q.Status()
+	if len(s.Pending) != 0 || len(s.Issuing) != 0 {
+		t.Errorf("Status() = %+v, want empty queue", s)
+	}
+	if _, ok := s.Failed["bad.example.com"]; !ok || len(s.Failed) != 1 {
+		t.Errorf("Failed = %v, want only bad.example.com", s.Failed)
+	}
+}
+
+func TestIssueQueuePolicyLimit(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := newTestQueue(1, 2, nil)
+	q.now = func() time.Time { return now }
+
+	allow := func(context.Context, string) error { return nil }
+	policy := q.Policy(allow)
+
+	for i := 0; i < 2; i++ {
+		if err := policy(context.Background(), "example.com"); err != nil {
+			t.Fatalf("order %d: policy() = %v, want nil", i, err)
+		}
+		now = now.Add(10 * time.Minute)
+	}
+	if err := policy(context.Background(), "example.com"); err == nil {
+		t.Fatal("policy() over limit = nil, want error")
+	}
+	if s := q.Status(); s.IssuedLastHour != 2 || s.PerHour != 2 {
+		t.Errorf("Status() = %+v, want 2 of 2 issued", s)
+	}
+
+	// The first order leaves the window an hour after it was placed
+	now = now.Add(40 * time.Minute)
+	if err := policy(context.Background(), "example.com"); err != nil {
+		t.Errorf("policy() after window = %v, want nil", err)
+	}
+}
+
+func TestIssueQueueReservesOrders(t *testing.T) {
+	var clock sync.Mutex
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var policy func(context.Context, string) error
+	var rejected atomic.Int32
+
+	q := newTestQueue(3, 2, func(host string) error {
+		// autocert consults the policy before placing an order
+		if err := policy(context.Background(), host); err != nil {
+			rejected.Add(1)
+			return err
+		}
+		return nil
+	})
+	q.now = func() time.Time {
+		clock.Lock()
+		defer clock.Unlock()
+		return now
+	}
+	q.sleep = func(d time.Duration) {
+		clock.Lock()
+		now = now.Add(d)
+		clock.Unlock()
+	}
+	policy = q.Policy(func(context.Context, string) error { return nil })
+
+	q.Enqueue([]string{"a.example.com", "b.example.com", "c.example.com"})
+	waitIdle(t, q)
+
+	if n := rejected.Load(); n != 0 {
+		t.Errorf("policy rejected %d queued orders, want none", n)
+	}
+	if s := q.Status(); len(s.Failed) != 0 || len(s.Pending) != 0 || s.IssuedLastHour != 1 {
+		t.Errorf("Status() = %+v, want the third order alone in the next hour", s)
+	}
+}
+
+func TestIssueQueueRefundsCachedHosts(t *testing.T) {
+	// Cached certificates finish without consulting the policy
+	q := newTestQueue(1, 2, func(string) error { return nil })
+	q.Enqueue([]string{"a.example.com", "b.example.com", "c.example.com"})
+	waitIdle(t, q)
+
+	if s := q.Status(); s.IssuedLastHour != 0 {
+		t.Errorf("IssuedLastHour = %d, want 0 without orders", s.IssuedLastHour)
+	}
+}
+
+func TestIssueQueueRequeuesLimitedHosts(t *testing.T) {
+	var attempts atomic.Int32
+	q := newTestQueue(1, 0, func(string) error {
+		if attempts.Add(1) == 1 {
+			return &limitError{perHour: 1, wait: time.Minute}
+		}
+		return nil
+	})
+	q.Enqueue([]string{"example.com"})
+	waitIdle(t, q)
+
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("attempts = %d, want a retry after the limit", n)
+	}
+	if s := q.Status(); len(s.Failed) != 0 {
+		t.Errorf("Failed = %v, want rate-limited hosts requeued", s.Failed)
+	}
+}
+
+func TestHostList(t *testing.T) {
+	l := NewHostList([]string{"example.com"})
+	ctx := context.Background()
+
+	if err := l.Policy(ctx, "example.com"); err != nil {
+		t.Errorf("Policy(example.com) = %v, want nil", err)
+	}
+	if err := l.Policy(ctx, "new.example.com"); err == nil {
+		t.Error("Policy(new.example.com) = nil, want error")
+	}
+
+	l.Set([]string{"new.example.com"})
+	if err := l.Policy(ctx, "new.example.com"); err != nil {
+		t.Errorf("Policy(new.example.com) after Set = %v, want nil", err)
+	}
+	if err := l.Policy(ctx, "example.com"); err == nil {
+		t.Error("Policy(example.com) after Set = nil, want error")
+	}
+}
@@ -0,0 +1,288 @@
+package tls
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/localrivet/liteproxy/metrics"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// StagingDirectory is Let's Encrypt's staging ACME directory, whose
+// certificates aren't trusted and don't count against production limits
+const StagingDirectory = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// Reasons a rehearsal can fail
+const (
+	RehearsalDNS       = "dns"
+	RehearsalCAA       = "caa"
+	RehearsalRateLimit = "rate_limit"
+	RehearsalChallenge = "challenge"
+	RehearsalOther     = "other"
+)
+
+// rehearsalLookupTimeout bounds the DNS check classifying a failure
+const rehearsalLookupTimeout = 10 * time.Second
+
+var rehearsalFailures = metrics.Default.NewCounterVec(
+	"liteproxy_acme_rehearsal_failures_total",
+	"Hosts whose certificate issuance rehearsal against the ACME staging directory failed, by reason.",
+	"reason")
+
+// Rehearsal periodically issues a throwaway certificate for every host from
+// the ACME staging directory, so problems that would break a renewal (DNS,
+// CAA, rate limits, unreachable challenges) show up weeks before the
+// production certificate expires
+type Rehearsal struct {
+	email string
+	eab   *acme.ExternalAccountBinding // nil for staging directories that don't require one
+	hosts *HostList
+	cache *rehearsalCache
+	caa   *CAAChecker // nil skips CAA checks when classifying failures
+
+	// Swappable for tests
+	directory string
+	issue     func(m *autocert.Manager, host string) error
+	lookup    func(ctx context.Context, host string) ([]string, error)
+
+	current atomic.Pointer[autocert.Manager] // answering the running rehearsal's challenges
+
+	mu      sync.Mutex
+	results map[string]RehearsalResult
+}
+
+// RehearsalResult is the outcome of the last rehearsal for a host
+type RehearsalResult struct {
+	Host   string    `json:"host"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"` // empty when issuance succeeded
+	Error  string    `json:"error,omitempty"`
+}
+
+// NewRehearsal creates a rehearsal for the hosts in cfg against the staging
+// directory in cfg.DirectoryURL, Let's Encrypt's if empty, registering with
+// cfg.EAB if the staging directory requires it. Only the staging
+// account key is stored, under CacheDir/staging; certificates are discarded
+// so every run is a full issuance.
+func NewRehearsal(cfg Config, caa *CAAChecker) *Rehearsal {
+	return &Rehearsal{
+		email:     cfg.Email,
+		eab:       cfg.EAB,
+		hosts:     cfg.Hosts,
+		caa:       caa,
+		cache:     &rehearsalCache{account: autocert.DirCache(filepath.Join(cfg.CacheDir, "staging"))},
+		directory: cmp.Or(cfg.DirectoryURL, StagingDirectory),
+		issue: func(m *autocert.Manager, host string) error {
+			_, err := m.GetCertificate(helloFor(host))
+			return err
+		},
+		lookup:  net.DefaultResolver.LookupHost,
+		results: make(map[string]RehearsalResult),
+	}
+}
+
+// Run rehearses every interval until ctx is done, starting after one
+// interval's worth of delay capped at ten minutes so startup issuance goes first
+func (r *Rehearsal) Run(ctx context.Context, interval time.Duration) {
+	timer := time.NewTimer(min(interval, 10*time.Minute))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		r.RunOnce(ctx)
+		timer.Reset(interval)
+	}
+}
+
+// RunOnce rehearses issuance for every configured host except wildcards,
+// which can't be issued over HTTP challenges
+func (r *Rehearsal) RunOnce(ctx context.Context) {
+	hosts := slices.DeleteFunc(r.hosts.List(), func(h string) bool { return strings.Contains(h, "*") })
+	if len(hosts) == 0 {
+		return
+	}
+
+	// A fresh manager, since autocert keeps issued certificates in memory
+	r.cache.reset()
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Email:      r.email,
+		Cache:      r.cache,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Client:     &acme.Client{DirectoryURL: r.directory},
+
+		ExternalAccountBinding: r.eab,
+	}
+	m.HTTPHandler(nil) // enables HTTP-01, answered by ChallengeHandler
+	r.current.Store(m)
+	defer r.current.Store(nil)
+
+	failed := 0
+	for _, host := range hosts {
+		if ctx.Err() != nil {
+			return
+		}
+		err := r.issue(m, host)
+		result := RehearsalResult{Host: host, Time: time.Now()}
+		if err != nil {
+			result.Reason = r.classify(ctx, host, err)
+			result.Error = err.Error()
+			rehearsalFailures.Inc(result.Reason)
+			failed++
+			slog.Warn("ACME rehearsal: renewal would fail", "host", host, "reason", result.Reason, "err", err)
+		}
+
+		r.mu.Lock()
+		r.results[host] = result
+		r.mu.Unlock()
+	}
+
+	// Forget hosts no longer configured
+	r.mu.Lock()
+	for host := range r.results {
+		if !slices.Contains(hosts, host) {
+			delete(r.results, host)
+		}
+	}
+	r.mu.Unlock()
+	slog.Info("ACME rehearsal finished", "renewable", len(hosts)-failed, "hosts", len(hosts))
+}
+
+// classify names the reason a staging issuance failed. autocert drops the
+// CA's reason for failed challenges, so DNS and CAA are checked here.
+func (r *Rehearsal) classify(ctx context.Context, host string, err error) string {
+	var acmeErr *acme.Error
+	if errors.As(err, &acmeErr) {
+		switch {
+		case strings.HasSuffix(acmeErr.ProblemType, ":rateLimited"):
+			return RehearsalRateLimit
+		case strings.HasSuffix(acmeErr.ProblemType, ":caa"):
+			return RehearsalCAA
+		case strings.HasSuffix(acmeErr.ProblemType, ":dns"):
+			return RehearsalDNS
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, rehearsalLookupTimeout)
+	defer cancel()
+	if addrs, err := r.lookup(ctx, host); err != nil || len(addrs) == 0 {
+		return RehearsalDNS
+	}
+	if r.caa != nil && r.caa.Check(ctx, host) != nil {
+		return RehearsalCAA
+	}
+	if strings.Contains(err.Error(), "challenge") {
+		return RehearsalChallenge
+	}
+	return RehearsalOther
+}
+
+// Results returns the last rehearsal result for each host, sorted by host
+func (r *Rehearsal) Results() []RehearsalResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]RehearsalResult, 0, len(r.results))
+	for _, res := range r.results {
+		results = append(results, res)
+	}
+	slices.SortFunc(results, func(a, b RehearsalResult) int { return strings.Compare(a.Host, b.Host) })
+	return results
+}
+
+// ChallengeHandler answers the running rehearsal's HTTP-01 challenges and
+// passes every other request to next
+func (r *Rehearsal) ChallengeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/.well-known/acme-challenge/") && r.current.Load() != nil {
+			if token, err := r.cache.Get(req.Context(), path.Base(req.URL.Path)+"+http-01"); err == nil {
+				w.Write(token)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// GetCertificate answers the running rehearsal's TLS-ALPN-01 challenges and
+// passes every other handshake to next
+func (r *Rehearsal) GetCertificate(next func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if m := r.current.Load(); m != nil && slices.Equal(hello.SupportedProtos, []string{acme.ALPNProto}) {
+			if cert, err := m.GetCertificate(hello); err == nil {
+				return cert, nil
+			}
+		}
+		return next(hello)
+	}
+}
+
+// rehearsalCache keeps the staging account key on disk and everything else,
+// including issued certificates, in memory until the next run
+type rehearsalCache struct {
+	account autocert.Cache
+
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+// isAccountKey reports whether key names autocert's account key
+func isAccountKey(key string) bool {
+	return key == "acme_account+key" || key == "acme_account.key"
+}
+
+func (c *rehearsalCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if isAccountKey(key) {
+		return c.account.Get(ctx, key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if data, ok := c.entries[key]; ok {
+		return data, nil
+	}
+	return nil, autocert.ErrCacheMiss
+}
+
+func (c *rehearsalCache) Put(ctx context.Context, key string, data []byte) error {
+	if isAccountKey(key) {
+		return c.account.Put(ctx, key, data)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string][]byte)
+	}
+	c.entries[key] = data
+	return nil
+}
+
+func (c *rehearsalCache) Delete(ctx context.Context, key string) error {
+	if isAccountKey(key) {
+		return c.account.Delete(ctx, key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *rehearsalCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
@@ -0,0 +1,125 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestRehearsal(t *testing.T) {
+	dir := t.TempDir()
+	hosts := NewHostList([]string{"ok.example.com", "nodns.example.com", "caa.example.com", "limited.example.com", "unreachable.example.com", "*.tenant.example.com"})
+	r := NewRehearsal(Config{CacheDir: dir, Hosts: hosts}, nil)
+	r.lookup = func(_ context.Context, host string) ([]string, error) {
+		if host == "nodns.example.com" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"203.0.113.10"}, nil
+	}
+	var issued []string
+	r.issue = func(m *autocert.Manager, host string) error {
+		if r.current.Load() != m {
+			t.Error("challenges not answered for the running rehearsal")
+		}
+		issued = append(issued, host)
+		switch host {
+		case "nodns.example.com":
+			return errors.New("acme/autocert: unable to satisfy authorization: no viable challenge type found")
+		case "caa.example.com":
+			return &acme.Error{ProblemType: "urn:ietf:params:acme:error:caa", Detail: "CAA record prevents issuance"}
+		case "limited.example.com":
+			return &acme.Error{ProblemType: "urn:ietf:params:acme:error:rateLimited", Detail: "too many certificates"}
+		case "unreachable.example.com":
+			return errors.New("acme/autocert: unable to satisfy authorization: no viable challenge type found")
+		}
+		return nil
+	}
+
+	before := rehearsalFailures.Value(RehearsalCAA)
+	r.RunOnce(context.Background())
+	if len(issued) != 5 {
+		t.Errorf("rehearsed %v, want every host but the wildcard", issued)
+	}
+	if r.current.Load() != nil {
+		t.Error("challenge manager still set after the run")
+	}
+	want := map[string]string{
+		"caa.example.com":         RehearsalCAA,
+		"limited.example.com":     RehearsalRateLimit,
+		"nodns.example.com":       RehearsalDNS,
+		"ok.example.com":          "",
+		"unreachable.example.com": RehearsalChallenge,
+	}
+	results := r.Results()
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v", results)
+	}
+	for _, res := range results {
+		if res.Reason != want[res.Host] {
+			t.Errorf("%s: reason %q, want %q (%s)", res.Host, res.Reason, want[res.Host], res.Error)
+		}
+	}
+	if n := rehearsalFailures.Value(RehearsalCAA) - before; n != 1 {
+		t.Errorf("caa failures counted: %d, want 1", n)
+	}
+
+	// Removed hosts drop out of the results
+	hosts.Set([]string{"ok.example.com"})
+	r.RunOnce(context.Background())
+	if results := r.Results(); len(results) != 1 || results[0].Host != "ok.example.com" {
+		t.Errorf("results after removing hosts = %+v", results)
+	}
+}
+
+func TestRehearsalChallengeHandler(t *testing.T) {
+	r := NewRehearsal(Config{CacheDir: t.TempDir(), Hosts: NewHostList(nil)}, nil)
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("production")) })
+	h := r.ChallengeHandler(next)
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://ok.example.com"+path, nil))
+		return rec.Body.String()
+	}
+
+	r.cache.Put(context.Background(), "tok+http-01", []byte("tok.staging"))
+	if got := get("/.well-known/acme-challenge/tok"); got != "production" {
+		t.Errorf("token served outside a rehearsal: %q", got)
+	}
+	r.current.Store(&autocert.Manager{})
+	if got := get("/.well-known/acme-challenge/tok"); got != "tok.staging" {
+		t.Errorf("staging token = %q", got)
+	}
+	if got := get("/.well-known/acme-challenge/other"); got != "production" {
+		t.Errorf("production token = %q", got)
+	}
+}
+
+func TestRehearsalCacheKeepsOnlyAccountKey(t *testing.T) {
+	dir := t.TempDir()
+	c := &rehearsalCache{account: autocert.DirCache(dir)}
+	ctx := context.Background()
+	c.Put(ctx, "acme_account+key", []byte("key"))
+	c.Put(ctx, "ok.example.com", []byte("cert"))
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "acme_account+key" {
+		t.Errorf("stored on disk: %v", entries)
+	}
+	c.reset()
+	if _, err := c.Get(ctx, "ok.example.com"); err != autocert.ErrCacheMiss {
+		t.Errorf("certificate survived reset: %v", err)
+	}
+	if data, err := c.Get(ctx, "acme_account+key"); err != nil || string(data) != "key" {
+		t.Errorf("account key = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "acme_account+key")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start a zero-downtime upgrade to the binary on disk
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
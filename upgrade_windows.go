@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// upgradeSignals is empty: Windows has no SIGUSR2 and can't pass sockets on
+var upgradeSignals []os.Signal
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/localrivet/liteproxy/compose"
+	"github.com/localrivet/liteproxy/proxy"
+	liteTLS "github.com/localrivet/liteproxy/tls"
+)
+
+// runValidate implements `liteproxy validate`: it checks the configuration
+// as a reload would, prints the route table and exits non-zero on errors,
+// so changes can be checked before they reach a running proxy
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	files := fs.String("f", strings.Join(getEnvList("LITEPROXY_COMPOSE_FILE", defaultComposeFiles()), ","),
+		"compose files (comma-separated)")
+	dir := fs.String("d", os.Getenv("LITEPROXY_COMPOSE_DIR"), "compose directory")
+	errorPages := fs.String("error-pages", os.Getenv("LITEPROXY_ERROR_PAGES"), "error page templates directory")
+	quiet := fs.Bool("q", false, "only report errors")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	routes, err := validateConfig(Config{ComposeFiles: splitList(*files), ComposeDir: *dir, ErrorPages: *errorPages})
+	if err != nil {
+		fmt.Fprintf(stderr, "liteproxy validate: %v\n", err)
+		return 1
+	}
+	if *quiet {
+		return 0
+	}
+	for _, r := range compose.Export(routes) {
+		switch {
+		case r.TCPPort != 0:
+			fmt.Fprintf(stdout, "tcp :%d -> %s:%d\n", r.TCPPort, r.DialHost(), r.ServicePort)
+		case r.UDPPort != 0:
+			fmt.Fprintf(stdout, "udp :%d -> %s:%d\n", r.UDPPort, r.DialHost(), r.ServicePort)
+		default:
+			fmt.Fprintln(stdout, describeRoute(r.Route))
+		}
+	}
+	fmt.Fprintf(stdout, "%d routes OK\n", len(routes))
+	return 0
+}
+
+// validateConfig loads everything a reload loads from disk, returning the
+// first error a reload would fail with
+func validateConfig(cfg Config) ([]compose.Route, error) {
+	routes, err := parseConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ErrorPages != "" {
+		if _, err := proxy.LoadErrorPages(cfg.ErrorPages); err != nil {
+			return nil, err
+		}
+	}
+	if err := liteTLS.NewClientAuth(&tls.Config{}).Update(clientAuthPolicies(routes)); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// validateCandidate checks data as the new contents of the compose file at
+// path, alongside the other configured files, without touching the disk.
+// path may be empty when only one file is configured.
+func validateCandidate(cfg Config, path string, data []byte) ([]compose.Route, error) {
+	files, err := cfg.configFiles()
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		if len(files) != 1 {
+			return nil, fmt.Errorf("%d compose files are loaded; name the one to replace", len(files))
+		}
+		path = files[0]
+	}
+	routes, err := compose.ParseFilesWith(files, path, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := liteTLS.NewClientAuth(&tls.Config{}).Update(clientAuthPolicies(routes)); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
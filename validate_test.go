@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunValidate(t *testing.T) {
+	path := writeCompose(t, routesCompose+`
+  db:
+    image: postgres
+    labels:
+      liteproxy.tcp.port: "5432"
+      liteproxy.port: "5432"
+`)
+
+	var stdout, stderr bytes.Buffer
+	if code := runValidate([]string{"-f", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runValidate() = %d, stderr: %s", code, stderr.String())
+	}
+	want := "tcp :5432 -> db:5432\nexample.com/ -> web:80\nexample.com/api -> api:8080\n3 routes OK\n"
+	if got := stdout.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+
+	stdout.Reset()
+	if code := runValidate([]string{"-f", path, "-q"}, &stdout, &stderr); code != 0 || stdout.Len() != 0 {
+		t.Errorf("-q: code %d, output %q; want 0 and nothing", code, stdout.String())
+	}
+}
+
+func TestRunValidateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    func(t *testing.T) []string
+		wantErr string
+	}{
+		{
+			name: "invalid label",
+			args: func(t *testing.T) []string {
+				return []string{"-f", writeCompose(t, `
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "eighty"
+`)}
+			},
+			wantErr: `invalid port "eighty"`,
+		},
+		{
+			name:    "missing file",
+			args:    func(t *testing.T) []string { return []string{"-f", "/nonexistent/compose.yaml"} },
+			wantErr: "compose.yaml",
+		},
+		{
+			name: "missing error pages",
+			args: func(t *testing.T) []string {
+				return []string{"-f", writeCompose(t, routesCompose), "-error-pages", "/nonexistent/pages"}
+			},
+			wantErr: "/nonexistent/pages",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := runValidate(tt.args(t), &stdout, &stderr); code != 1 {
+				t.Errorf("runValidate() = %d, want 1", code)
+			}
+			if !strings.Contains(stderr.String(), tt.wantErr) {
+				t.Errorf("stderr = %q, want it to mention %q", stderr.String(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCandidate(t *testing.T) {
+	path := writeCompose(t, routesCompose)
+	other := writeCompose(t, `
+services:
+  db:
+    image: postgres
+    labels:
+      liteproxy.tcp.port: "5432"
+      liteproxy.port: "5432"
+`)
+	candidate := []byte(`
+services:
+  web:
+    image: nginx
+    labels:
+      liteproxy.host: "example.com"
+      liteproxy.port: "8080"
+`)
+
+	// The file on disk is left alone
+	routes, err := validateCandidate(Config{ComposeFiles: []string{path}}, "", candidate)
+	if err != nil || len(routes) != 1 || routes[0].ServicePort != 8080 {
+		t.Errorf("validateCandidate() = %+v, %v; want the candidate's one route", routes, err)
+	}
+	if disk, _ := parseConfig(Config{ComposeFiles: []string{path}}); len(disk) != 2 {
+		t.Errorf("compose file on disk has %d routes, want 2", len(disk))
+	}
+
+	// With several files, the candidate replaces the one named
+	both := Config{ComposeFiles: []string{path, other}}
+	if _, err := validateCandidate(both, "", candidate); err == nil || !strings.Contains(err.Error(), "name the one to replace") {
+		t.Errorf("unnamed file with two loaded: %v", err)
+	}
+	if routes, err := validateCandidate(both, path, candidate); err != nil || len(routes) != 2 {
+		t.Errorf("validateCandidate(%s) = %d routes, %v; want the candidate and db", path, len(routes), err)
+	}
+
+	// A candidate clashing with another file fails like a reload would
+	clash := []byte(`
+services:
+  db2:
+    image: postgres
+    labels:
+      liteproxy.tcp.port: "5432"
+      liteproxy.port: "5432"
+`)
+	if _, err := validateCandidate(both, path, clash); err == nil || !strings.Contains(err.Error(), "tcp port 5432") {
+		t.Errorf("conflicting candidate: %v", err)
+	}
+}
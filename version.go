@@ -0,0 +1,27 @@
+package main
+
+import "runtime/debug"
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version string
+
+// buildVersion returns the version, falling back to the module version for
+// `go install` builds and the VCS revision for source builds
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			return "devel-" + s.Value[:12]
+		}
+	}
+	return "devel"
+}
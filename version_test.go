@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestBuildVersion(t *testing.T) {
+	if got := buildVersion(); got == "" {
+		t.Error("buildVersion() is empty without a stamped version")
+	}
+
+	old := version
+	defer func() { version = old }()
+	version = "v1.4.0"
+	if got := buildVersion(); got != "v1.4.0" {
+		t.Errorf("buildVersion() = %q, want the stamped version", got)
+	}
+}
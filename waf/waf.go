@@ -0,0 +1,198 @@
+// Package waf inspects requests against regex rules, to block obvious
+// attacks such as SQL injection and XSS probes at the edge
+package waf
+
+import (
+	_ "embed"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// Builtin names the embedded rule set in a list of rule sources
+const Builtin = "builtin"
+
+// BodyLimit is how much of a request body body rules inspect
+const BodyLimit = 64 << 10
+
+// Rule actions
+const (
+	ActionBlock = "block" // refuse the request with 403 (the default)
+	ActionLog   = "log"   // log the match and let the request through
+)
+
+//go:embed builtin.yaml
+var builtinRules []byte
+
+// Rule matches requests on which every condition it sets matches. Each
+// condition is a regular expression in Go (RE2) syntax.
+type Rule struct {
+	ID          string            `yaml:"id"`
+	Description string            `yaml:"description,omitempty"`
+	Action      string            `yaml:"action,omitempty"`
+	Method      string            `yaml:"method,omitempty"`
+	Path        string            `yaml:"path,omitempty"`    // the decoded path
+	Query       string            `yaml:"query,omitempty"`   // the decoded query string
+	Headers     map[string]string `yaml:"headers,omitempty"` // header name -> pattern, matched against each value
+	Body        string            `yaml:"body,omitempty"`    // the first BodyLimit bytes, form bodies decoded
+
+	method, path, query, body *regexp.Regexp
+	headers                   map[string]*regexp.Regexp
+}
+
+// RuleSet is a compiled list of rules
+type RuleSet struct {
+	rules       []*Rule
+	inspectBody bool
+}
+
+// Load reads and compiles rules from sources, each a rules file or Builtin
+func Load(sources []string) (*RuleSet, error) {
+	rs := &RuleSet{}
+	ids := make(map[string]string)
+	for _, src := range sources {
+		data := builtinRules
+		if src != Builtin {
+			var err error
+			if data, err = os.ReadFile(src); err != nil {
+				return nil, fmt.Errorf("reading WAF rules: %w", err)
+			}
+		}
+		rules, err := parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("WAF rules %s: %w", src, err)
+		}
+		for _, r := range rules {
+			if prev, ok := ids[r.ID]; ok {
+				return nil, fmt.Errorf("WAF rules %s: rule %q already defined in %s", src, r.ID, prev)
+			}
+			ids[r.ID] = src
+			rs.inspectBody = rs.inspectBody || r.body != nil
+		}
+		rs.rules = append(rs.rules, rules...)
+	}
+	return rs, nil
+}
+
+func parse(data []byte) ([]*Rule, error) {
+	var file struct {
+		Rules []*Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for i, r := range file.Rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("rule %d has no id", i+1)
+		}
+		switch r.Action {
+		case "":
+			r.Action = ActionBlock
+		case ActionBlock, ActionLog:
+		default:
+			return nil, fmt.Errorf("rule %s: invalid action %q (want %s or %s)", r.ID, r.Action, ActionBlock, ActionLog)
+		}
+		var err error
+		for _, c := range []struct {
+			pattern string
+			re      **regexp.Regexp
+		}{{r.Method, &r.method}, {r.Path, &r.path}, {r.Query, &r.query}, {r.Body, &r.body}} {
+			if c.pattern == "" {
+				continue
+			}
+			if *c.re, err = regexp.Compile(c.pattern); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.ID, err)
+			}
+		}
+		r.headers = make(map[string]*regexp.Regexp, len(r.Headers))
+		for name, pattern := range r.Headers {
+			if r.headers[http.CanonicalHeaderKey(name)], err = regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("rule %s: header %s: %w", r.ID, name, err)
+			}
+		}
+		if r.method == nil && r.path == nil && r.query == nil && r.body == nil && len(r.headers) == 0 {
+			return nil, fmt.Errorf("rule %s has no conditions", r.ID)
+		}
+	}
+	return file.Rules, nil
+}
+
+// InspectsBody reports whether any rule looks at request bodies, which
+// must then be read before proxying
+func (rs *RuleSet) InspectsBody() bool {
+	return rs.inspectBody
+}
+
+// Len returns the number of rules
+func (rs *RuleSet) Len() int {
+	return len(rs.rules)
+}
+
+// Match returns every rule the request matches, in order. body holds the
+// start of the request body, or nil when no body rules apply.
+func (rs *RuleSet) Match(r *http.Request, body []byte) []*Rule {
+	query := decode(r.URL.RawQuery)
+	var bodyText string
+	if body != nil {
+		bodyText = string(body)
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/x-www-form-urlencoded" {
+			bodyText = decode(bodyText)
+		}
+	}
+
+	var matched []*Rule
+	for _, rule := range rs.rules {
+		if rule.matches(r, query, bodyText) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+func (rule *Rule) matches(r *http.Request, query, body string) bool {
+	if rule.method != nil && !rule.method.MatchString(r.Method) {
+		return false
+	}
+	if rule.path != nil && !rule.path.MatchString(r.URL.Path) {
+		return false
+	}
+	if rule.query != nil && !rule.query.MatchString(query) {
+		return false
+	}
+	if rule.body != nil && !rule.body.MatchString(body) {
+		return false
+	}
+	for name, re := range rule.headers {
+		if !matchAny(re, r.Header.Values(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// decode percent-decodes a query string or form body, so encoded payloads
+// match the same rules; undecodable input is inspected as it is
+func decode(s string) string {
+	if !strings.ContainsAny(s, "%+") {
+		return s
+	}
+	if d, err := url.QueryUnescape(s); err == nil {
+		return d
+	}
+	return s
+}
